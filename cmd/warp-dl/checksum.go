@@ -0,0 +1,75 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"runtime"
+
+	"github.com/spf13/cobra"
+	"warp-dl/internal/downloader"
+	"warp-dl/internal/ui"
+)
+
+var (
+	sumsFile  string
+	sumsAlgo  string
+	sumsJobs  int
+	sumsQuiet bool
+)
+
+var checksumCmd = &cobra.Command{
+	Use:     "checksum [dir]",
+	Short:   "Verify local files against a checksum list",
+	Example: "  warp-dl checksum ./isos --against SHA256SUMS",
+	Args:    cobra.ExactArgs(1),
+	Run: func(cmd *cobra.Command, args []string) {
+		f, err := os.Open(sumsFile)
+		if err != nil {
+			fmt.Fprintln(os.Stderr, err)
+			os.Exit(1)
+		}
+		entries, err := downloader.ParseSums(f, sumsAlgo)
+		f.Close()
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "Failed to parse %s: %v\n", sumsFile, err)
+			os.Exit(1)
+		}
+
+		verifier := downloader.NewSumsVerifier(args[0], entries, sumsJobs)
+		newModel := func(s *downloader.Stats) ui.Model {
+			return ui.NewModel(s).WithLabel("Verified")
+		}
+		if err := runTask(verifier, newModel); err != nil {
+			fmt.Fprintf(os.Stderr, "Verification aborted: %v\n", err)
+			os.Exit(1)
+		}
+
+		failed := 0
+		for _, r := range verifier.Results {
+			if r.Status != downloader.VerifyOK {
+				failed++
+			} else if sumsQuiet {
+				continue
+			}
+			if r.Err != nil && r.Status == downloader.VerifyError {
+				fmt.Printf("%s: %s (%v)\n", r.Entry.Name, r.Status, r.Err)
+			} else {
+				fmt.Printf("%s: %s\n", r.Entry.Name, r.Status)
+			}
+		}
+
+		if failed > 0 {
+			fmt.Fprintf(os.Stderr, "%d of %d files did not verify\n", failed, len(verifier.Results))
+			os.Exit(1)
+		}
+	},
+}
+
+func init() {
+	checksumCmd.Flags().StringVar(&sumsFile, "against", "", "Checksum list, e.g. SHA256SUMS")
+	checksumCmd.Flags().StringVar(&sumsAlgo, "algo", "", "Hash algorithm (default: inferred from digest length)")
+	checksumCmd.Flags().IntVarP(&sumsJobs, "jobs", "j", runtime.NumCPU(), "Number of files hashed in parallel")
+	checksumCmd.Flags().BoolVar(&sumsQuiet, "quiet", false, "Only report files that failed")
+	checksumCmd.MarkFlagRequired("against")
+	rootCmd.AddCommand(checksumCmd)
+}
@@ -0,0 +1,258 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"net/url"
+	"os"
+	"os/signal"
+	"path"
+	"path/filepath"
+	"strings"
+	"syscall"
+	"time"
+
+	tea "github.com/charmbracelet/bubbletea"
+	"github.com/spf13/cobra"
+	"warp-dl/internal/clipboard"
+	"warp-dl/internal/daemon"
+	"warp-dl/internal/downloader"
+	"warp-dl/internal/ui"
+)
+
+var (
+	clipExts    []string
+	clipHosts   []string
+	clipEvery   time.Duration
+	clipConfirm bool
+	clipActive  int
+)
+
+// defaultExtensions are picked up when neither the flags nor the config
+// file say what to watch for
+var defaultExtensions = []string{
+	"iso", "img", "zip", "7z", "rar", "tar", "tgz", "gz", "bz2", "xz", "zst",
+	"exe", "msi", "dmg", "pkg", "deb", "rpm", "apk", "appimage",
+	"mp4", "mkv", "webm", "mp3", "flac", "pdf", "epub", "torrent",
+}
+
+var watchClipboardCmd = &cobra.Command{
+	Use:   "watch-clipboard",
+	Short: "Download the links copied to the clipboard that match the configured file types or hosts",
+	Example: "  warp-dl watch-clipboard --ext iso,zip --dir ~/Downloads\n" +
+		"  warp-dl watch-clipboard --host releases.example.com --confirm=false",
+	Args: cobra.NoArgs,
+	Run: func(cmd *cobra.Command, args []string) {
+		if err := runWatchClipboard(); err != nil {
+			fmt.Fprintln(os.Stderr, err)
+			os.Exit(1)
+		}
+	},
+}
+
+func init() {
+	fs := watchClipboardCmd.Flags()
+	fs.StringSliceVar(&clipExts, "ext", nil, "File types to pick up, e.g. iso,zip,tar.gz (default: the config file's, or common archives, installers and media)")
+	fs.StringSliceVar(&clipHosts, "host", nil, "Hosts to pick up every link of, *.example.com for its subdomains too")
+	fs.DurationVar(&clipEvery, "interval", time.Second, "How often to look at the clipboard")
+	fs.BoolVar(&clipConfirm, "confirm", true, "Ask before queueing each link; c switches it in the progress UI")
+	fs.IntVar(&clipActive, "max-active", 1, "Downloads running at the same time")
+	rootCmd.AddCommand(watchClipboardCmd)
+}
+
+// linkFilter decides which copied URLs are downloads
+type linkFilter struct {
+	exts  []string
+	hosts []string
+}
+
+func newLinkFilter() linkFilter {
+	f := linkFilter{exts: conf.Clipboard.Extensions, hosts: conf.Clipboard.Hosts}
+	if clipExts != nil || clipHosts != nil {
+		f = linkFilter{exts: clipExts, hosts: clipHosts}
+	}
+	if len(f.exts) == 0 && len(f.hosts) == 0 {
+		f.exts = defaultExtensions
+	}
+	return f
+}
+
+// match takes magnet links, links to the hosts and links to files of the
+// types
+func (f linkFilter) match(raw string) bool {
+	if strings.HasPrefix(strings.ToLower(raw), "magnet:") {
+		return true
+	}
+	u, err := url.Parse(raw)
+	if err != nil {
+		return false
+	}
+	host := strings.ToLower(u.Hostname())
+	for _, h := range f.hosts {
+		h = strings.ToLower(h)
+		if host == h || strings.HasPrefix(h, "*.") && (host == h[2:] || strings.HasSuffix(host, h[1:])) {
+			return true
+		}
+	}
+	name := strings.ToLower(path.Base(u.Path))
+	for _, ext := range f.exts {
+		if strings.HasSuffix(name, "."+strings.TrimPrefix(strings.ToLower(ext), ".")) {
+			return true
+		}
+	}
+	return false
+}
+
+// describe sums up the filter for the progress UI
+func (f linkFilter) describe() string {
+	var parts []string
+	if len(f.exts) > 0 {
+		if len(f.exts) > 6 {
+			parts = append(parts, fmt.Sprintf("%d file types", len(f.exts)))
+		} else {
+			parts = append(parts, strings.Join(f.exts, ", ")+" files")
+		}
+	}
+	if len(f.hosts) > 0 {
+		parts = append(parts, "links to "+strings.Join(f.hosts, ", "))
+	}
+	return strings.Join(append(parts, "magnet links"), ", ")
+}
+
+func runWatchClipboard() error {
+	setupProgress()
+	filter := newLinkFilter()
+	dir := downloader.ExpandHome(outDir)
+
+	m := daemon.NewManager(clipActive, func(it *daemon.Item) (downloader.Task, error) {
+		c, err := itemConfig(it.URL, false)
+		if err != nil {
+			return nil, err
+		}
+		if c, err = withTrustedChecksum(c); err != nil {
+			return nil, err
+		}
+		if err := pluginPreDownload(c); err != nil {
+			return nil, err
+		}
+		return newTask(withLANPeers(c)), nil
+	})
+	m.OnDone = pluginDone
+
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	defer stop()
+	copied, err := clipboard.Watch(ctx, clipEvery)
+	if err != nil {
+		return fmt.Errorf("cannot watch the clipboard: %w", err)
+	}
+	ran := make(chan struct{})
+	go func() {
+		m.Run(ctx)
+		close(ran)
+	}()
+
+	// Each link once, copying it again doesn't fetch it twice
+	seen := map[string]bool{}
+	links := func(text string) []string {
+		var found []string
+		for _, u := range clipboard.URLs(text) {
+			if filter.match(u) && !seen[u] {
+				seen[u] = true
+				found = append(found, u)
+			}
+		}
+		return found
+	}
+	queue := func(u string) { m.Add(u, "", dir, "", "clipboard") }
+
+	if plainProgress() {
+		watchPlain(ctx, m, copied, links, queue)
+	} else {
+		list := func() []ui.ListItem {
+			var items []ui.ListItem
+			for _, s := range m.List(nil) {
+				name := s.URL
+				if s.Output != "" {
+					name = filepath.Base(s.Output)
+				} else if u, err := url.Parse(s.URL); err == nil && strings.Trim(u.Path, "/") != "" {
+					name = path.Base(u.Path)
+				}
+				items = append(items, ui.ListItem{
+					ID:         s.ID,
+					Name:       name,
+					URL:        s.URL,
+					State:      string(s.State),
+					Error:      s.Error,
+					Downloaded: s.Downloaded,
+					Total:      s.Total,
+					Speed:      s.Speed,
+					Inspection: s.Inspection,
+				})
+			}
+			return items
+		}
+		p := tea.NewProgram(ui.NewWatchModel(filter.describe(), clipConfirm, queue, list), tea.WithOutput(msgOut))
+		go func() {
+			for text := range copied {
+				for _, u := range links(text) {
+					p.Send(ui.FoundMsg{URL: u})
+				}
+			}
+		}()
+		holdLogs()
+		_, err := p.Run()
+		releaseLogs()
+		if err != nil {
+			return err
+		}
+	}
+
+	stop()
+	<-ran
+	unfinished := 0
+	for _, s := range m.List(nil) {
+		if s.State == daemon.StateRunning || s.State == daemon.StateQueued || s.State == daemon.StateCanceled {
+			unfinished++
+		}
+	}
+	if unfinished > 0 {
+		fmt.Fprintf(msgOut, "Stopped with %d downloads unfinished, copy or pass them again to resume\n", unfinished)
+	}
+	return nil
+}
+
+// watchPlain queues every link without asking and logs how the downloads
+// end, for --progress plain and output that isn't a terminal
+func watchPlain(ctx context.Context, m *daemon.Manager, copied <-chan string, links func(string) []string, queue func(string)) {
+	fmt.Fprintln(msgOut, "Watching the clipboard, Ctrl+C to stop")
+	tick := time.NewTicker(time.Second)
+	defer tick.Stop()
+	states := map[string]daemon.State{}
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case text, ok := <-copied:
+			if !ok {
+				return
+			}
+			for _, u := range links(text) {
+				queue(u)
+				fmt.Fprintf(msgOut, "Queued %s\n", u)
+			}
+		case <-tick.C:
+			for _, s := range m.List(nil) {
+				if states[s.ID] == s.State {
+					continue
+				}
+				states[s.ID] = s.State
+				switch s.State {
+				case daemon.StateDone:
+					fmt.Fprintf(msgOut, "Finished %s\n", s.URL)
+				case daemon.StateFailed:
+					fmt.Fprintf(msgOut, "Failed %s: %s\n", s.URL, s.Error)
+				}
+			}
+		}
+	}
+}
@@ -0,0 +1,105 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"sort"
+
+	"github.com/spf13/cobra"
+	"warp-dl/internal/config"
+	"warp-dl/internal/cookies"
+	"warp-dl/internal/downloader"
+)
+
+var completionCmd = &cobra.Command{
+	Use:   "completion bash|zsh|fish|powershell",
+	Short: "Print the shell completion script",
+	Long: "Print the completion script for a shell. Besides commands and flags it\n" +
+		"completes the config file's profiles and presets and the values of flags\n" +
+		"with a fixed set of choices.",
+	Example: "  source <(warp-dl completion bash)\n" +
+		"  warp-dl completion zsh > \"${fpath[1]}/_warp-dl\"\n" +
+		"  warp-dl completion fish > ~/.config/fish/completions/warp-dl.fish\n" +
+		"  warp-dl completion powershell | Out-String | Invoke-Expression",
+	ValidArgs:             []string{"bash", "zsh", "fish", "powershell"},
+	Args:                  cobra.MatchAll(cobra.ExactArgs(1), cobra.OnlyValidArgs),
+	DisableFlagsInUseLine: true,
+	// No config to load or flags to apply
+	PersistentPreRun: func(cmd *cobra.Command, args []string) {},
+	Run: func(cmd *cobra.Command, args []string) {
+		var err error
+		switch args[0] {
+		case "bash":
+			err = rootCmd.GenBashCompletionV2(os.Stdout, true)
+		case "zsh":
+			err = rootCmd.GenZshCompletion(os.Stdout)
+		case "fish":
+			err = rootCmd.GenFishCompletion(os.Stdout, true)
+		case "powershell":
+			err = rootCmd.GenPowerShellCompletionWithDesc(os.Stdout)
+		}
+		if err != nil {
+			fmt.Fprintln(os.Stderr, err)
+			os.Exit(1)
+		}
+	},
+}
+
+// registerCompletions adds the dynamic completions, once every command's
+// flags exist
+func registerCompletions() {
+	choices := map[string][]string{
+		"progress":             {"tui", "plain", "json", "none"},
+		"http2":                {"on", "force", "off"},
+		"dns":                  {"doh", "dot", "system", "auto"},
+		"on-conflict":          {"overwrite", "skip", "rename", "resume"},
+		"dedupe":               {"skip", "ask", "force"},
+		"file-allocation":      {"none", "trunc", "prealloc", "falloc"},
+		"track":                {"video", "audio"},
+		"print":                printFields,
+		"ua-preset":            downloader.UserAgentPresets(),
+		"tls-fingerprint":      downloader.TLSFingerprints(),
+		"cookies-from-browser": cookies.Browsers,
+	}
+	for flag, values := range choices {
+		rootCmd.RegisterFlagCompletionFunc(flag, cobra.FixedCompletions(values, cobra.ShellCompDirectiveNoFileComp))
+	}
+	rootCmd.RegisterFlagCompletionFunc("profile", func(cmd *cobra.Command, args []string, toComplete string) ([]string, cobra.ShellCompDirective) {
+		return configNames(func(f *config.File) []string { return keys(f.Profiles) }), cobra.ShellCompDirectiveNoFileComp
+	})
+
+	presets := func(cmd *cobra.Command, args []string, toComplete string) ([]string, cobra.ShellCompDirective) {
+		return configNames(func(f *config.File) []string { return keys(f.Presets) }), cobra.ShellCompDirectiveNoFileComp
+	}
+	getCmd.RegisterFlagCompletionFunc("preset", presets)
+	presetDeleteCmd.ValidArgsFunction = func(cmd *cobra.Command, args []string, toComplete string) ([]string, cobra.ShellCompDirective) {
+		if len(args) > 0 {
+			return nil, cobra.ShellCompDirectiveNoFileComp
+		}
+		return presets(cmd, args, toComplete)
+	}
+}
+
+// configNames returns names from the --config file for completions, none
+// when it can't be read: a completion has nowhere to report errors
+func configNames(names func(*config.File) []string) []string {
+	f, err := config.Load(configPath)
+	if err != nil {
+		return nil
+	}
+	return names(f)
+}
+
+// keys returns the sorted keys of a map of names
+func keys[V any](m map[string]V) []string {
+	out := make([]string, 0, len(m))
+	for k := range m {
+		out = append(out, k)
+	}
+	sort.Strings(out)
+	return out
+}
+
+func init() {
+	rootCmd.AddCommand(completionCmd)
+}
@@ -0,0 +1,30 @@
+package main
+
+import (
+	"fmt"
+	"net/http"
+	"net/url"
+
+	"warp-dl/internal/cookies"
+)
+
+// cookiesFrom is the --cookies-from-browser browser[:profile]
+var cookiesFrom string
+
+// browserCookies reads the cookies for the URL's site from the browser's
+// store, nil without --cookies-from-browser
+func browserCookies(rawURL string) ([]*http.Cookie, error) {
+	if cookiesFrom == "" {
+		return nil, nil
+	}
+	u, err := url.Parse(rawURL)
+	if err != nil || u.Hostname() == "" {
+		return nil, nil
+	}
+	list, err := cookies.FromBrowser(cookiesFrom, u.Hostname())
+	if err != nil {
+		return nil, fmt.Errorf("--cookies-from-browser: %w", err)
+	}
+	setupLogging().Info(fmt.Sprintf("%d cookies for %s from %s", len(list), u.Hostname(), cookiesFrom))
+	return list, nil
+}
@@ -0,0 +1,143 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+	"os"
+	"os/signal"
+	"path/filepath"
+	"syscall"
+	"time"
+
+	"github.com/spf13/cobra"
+	"warp-dl/internal/daemon"
+	"warp-dl/internal/downloader"
+)
+
+var (
+	daemonListen string
+	daemonToken  string
+)
+
+var daemonCmd = &cobra.Command{
+	Use:   "daemon",
+	Short: "Run a download queue with a web dashboard and JSON API",
+	Example: "  warp-dl daemon --listen 0.0.0.0:7800\n" +
+		"  warp-dl daemon --token $(openssl rand -hex 16)",
+	Args: cobra.NoArgs,
+	Run: func(cmd *cobra.Command, args []string) {
+		cfg := conf.Daemon
+		if daemonListen != "" {
+			cfg.Listen = daemonListen
+		}
+		if cfg.Listen == "" {
+			cfg.Listen = "127.0.0.1:7800"
+		}
+		if daemonToken != "" {
+			cfg.Tokens = append(cfg.Tokens, daemon.Token{Name: "admin", Token: daemonToken, Scope: daemon.ScopeAdmin})
+		}
+
+		bw, err := daemon.NewBandwidth(cfg)
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "Cannot start daemon: %v\n", err)
+			os.Exit(1)
+		}
+		m := daemon.NewManager(cfg.MaxActive, func(it *daemon.Item) (downloader.Task, error) {
+			// Guests read what they fetch through the API, the daemon's own
+			// headers, cookies and keys stay out of it
+			c, err := itemConfig(it.URL, it.Guest)
+			if err != nil {
+				return nil, err
+			}
+			c.Dir = it.Dir
+			if bw != nil {
+				c.Limiter = bw.Limiter
+			}
+			if it.Name != "" {
+				c.OutputName = filepath.Join(it.Dir, it.Name)
+			}
+			c.Mirrors = it.Mirrors
+			if it.Checksum != nil {
+				c.Checksum = it.Checksum
+			}
+			if len(it.Headers) > 0 {
+				// The browser's over the config file's
+				h := c.Headers.Clone()
+				if h == nil {
+					h = http.Header{}
+				}
+				for name, values := range it.Headers {
+					h[name] = values
+				}
+				c.Headers = h
+			}
+			c.Cookies = append(c.Cookies, it.Cookies...)
+			c, err = withTrustedChecksum(c)
+			if err != nil {
+				return nil, err
+			}
+			if err := pluginPreDownload(c); err != nil {
+				return nil, err
+			}
+			return newTask(withLANPeers(c)), nil
+		})
+		m.OnDone = pluginDone
+		srv, err := daemon.NewServer(cfg, m)
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "Cannot start daemon: %v (add tokens to the config file or pass --token)\n", err)
+			os.Exit(1)
+		}
+		watcher, err := daemon.NewWatcher(cfg, m, setupLogging())
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "Cannot start daemon: %v\n", err)
+			os.Exit(1)
+		}
+
+		ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+		defer stop()
+
+		if useLAN || cfg.LAN.Enabled {
+			useLAN = true
+			if err := serveLAN(ctx, cfg.LAN, m); err != nil {
+				fmt.Fprintf(os.Stderr, "Cannot share downloads on the LAN: %v\n", err)
+				os.Exit(1)
+			}
+		}
+
+		if bw != nil {
+			go bw.Run(ctx)
+		}
+		if watcher != nil {
+			go watcher.Run(ctx)
+			fmt.Printf("Watching %s for torrents, metalinks and URL lists\n", cfg.Watch.Dir)
+		}
+		done := make(chan struct{})
+		go func() {
+			m.Run(ctx)
+			close(done)
+		}()
+
+		httpSrv := &http.Server{Addr: cfg.Listen, Handler: srv.Handler()}
+		go func() {
+			<-ctx.Done()
+			shutdownCtx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+			defer cancel()
+			httpSrv.Shutdown(shutdownCtx)
+		}()
+
+		fmt.Printf("Dashboard on http://%s/\n", cfg.Listen)
+		if err := httpSrv.ListenAndServe(); err != nil && !errors.Is(err, http.ErrServerClosed) {
+			fmt.Fprintln(os.Stderr, err)
+			os.Exit(1)
+		}
+		<-done
+	},
+}
+
+func init() {
+	daemonCmd.Flags().StringVar(&daemonListen, "listen", "", "Address for the dashboard and API (default 127.0.0.1:7800)")
+	daemonCmd.Flags().StringVar(&daemonToken, "token", "", "Admin API token, in addition to the tokens in the config file")
+	rootCmd.AddCommand(daemonCmd)
+}
@@ -0,0 +1,60 @@
+package main
+
+import (
+	"bufio"
+	"context"
+	"fmt"
+	"os"
+	"strings"
+
+	"golang.org/x/term"
+	"warp-dl/internal/downloader"
+	"warp-dl/internal/ui"
+)
+
+var dedupeMode string
+
+// withDedupe sets up the --dedupe check against the output and the
+// history's earlier copies of the URL: skip keeps a copy that has the
+// remote file, ask asks first and force downloads without checking
+func withDedupe(cfg downloader.Config) downloader.Config {
+	switch dedupeMode {
+	case "force":
+		return cfg
+	case "skip":
+		cfg.OnDuplicate = func(ctx context.Context, path string) bool { return false }
+	case "ask":
+		cfg.OnDuplicate = promptDuplicate
+	default:
+		fmt.Fprintf(os.Stderr, "Invalid --dedupe %q: want skip, ask or force\n", dedupeMode)
+		os.Exit(1)
+	}
+	for _, e := range earlierDownloads(cfg.URL) {
+		cfg.Duplicates = append(cfg.Duplicates, e.Path)
+	}
+	return cfg
+}
+
+// promptDuplicate asks in the progress UI, or on the terminal without it,
+// whether to download a file path already has. Without anyone to ask it
+// is downloaded again.
+func promptDuplicate(ctx context.Context, path string) bool {
+	if program != nil {
+		reply := make(chan bool, 1)
+		program.Send(ui.DuplicateMsg{Path: path, Reply: reply})
+		select {
+		case again := <-reply:
+			return again
+		case <-ctx.Done():
+			return false
+		}
+	}
+	if progressFmt == "json" || !term.IsTerminal(int(os.Stdin.Fd())) {
+		fmt.Fprintf(os.Stderr, "Warning: %s already has this file, downloading it again (--dedupe skip keeps it)\n", path)
+		return true
+	}
+	fmt.Fprintf(os.Stderr, "%s already has this file. Download it again? [y/N] ", path)
+	line, _ := bufio.NewReader(os.Stdin).ReadString('\n')
+	answer := strings.ToLower(strings.TrimSpace(line))
+	return answer == "y" || answer == "yes"
+}
@@ -0,0 +1,162 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+	"os/signal"
+	"time"
+
+	"warp-dl/internal/downloader"
+	"warp-dl/internal/ui"
+)
+
+// progressModes are the values of --progress, empty picks tui or plain by
+// whether the output is a terminal
+var progressModes = []string{"tui", "plain", "json", "none"}
+
+// eventInterval is how often --progress json reports progress
+const eventInterval = 500 * time.Millisecond
+
+// events gets the --progress json stream, stdout or --progress-file
+var events io.Writer
+
+// Each line of --progress json is one of these, told apart by "event"
+type eventHeader struct {
+	Event string    `json:"event"`
+	Time  time.Time `json:"time"`
+}
+
+type startEvent struct {
+	eventHeader
+	URL string `json:"url,omitempty"`
+}
+
+type progressEvent struct {
+	eventHeader
+	Downloaded int64    `json:"downloaded"`
+	Total      int64    `json:"total"` // 0 while unknown
+	Speed      float64  `json:"speed"` // Bytes per second, smoothed
+	AvgSpeed   float64  `json:"avg_speed"`
+	ETA        *float64 `json:"eta,omitempty"`  // Seconds
+	Wire       int64    `json:"wire,omitempty"` // Bytes received before decoding, see --compressed
+}
+
+type partEvent struct {
+	eventHeader
+	downloader.PartStatus
+}
+
+type completeEvent struct {
+	eventHeader
+	result
+}
+
+type errorEvent struct {
+	eventHeader
+	Error string `json:"error"`
+}
+
+// setupProgress checks --progress and opens the event stream, which takes
+// stdout from the status messages unless it goes to a file
+func setupProgress() {
+	if err := ui.SetTheme(conf.Theme, noColor); err != nil {
+		fmt.Fprintf(os.Stderr, "Invalid theme in the config file: %v\n", err)
+		os.Exit(1)
+	}
+	switch {
+	case noTUI && progressFmt == "":
+		progressFmt = "plain"
+	case quiet:
+		progressFmt = "none"
+	}
+	valid := progressFmt == ""
+	for _, m := range progressModes {
+		valid = valid || m == progressFmt
+	}
+	if !valid {
+		fmt.Fprintf(os.Stderr, "Invalid --progress %q: want tui, plain, json or none\n", progressFmt)
+		os.Exit(1)
+	}
+	if progressFmt != "json" || events != nil {
+		return
+	}
+
+	if eventsFile == "" {
+		if printField != "" || output == "-" {
+			fmt.Fprintln(os.Stderr, "--progress json and --print or -o - both want stdout, send the events elsewhere with --progress-file")
+			os.Exit(1)
+		}
+		events, msgOut = os.Stdout, os.Stderr
+		return
+	}
+	// Appending works for logs and named pipes alike
+	f, err := os.OpenFile(eventsFile, os.O_WRONLY|os.O_APPEND|os.O_CREATE, 0o644)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Failed to open --progress-file: %v\n", err)
+		os.Exit(1)
+	}
+	events = f
+}
+
+// runJSON runs the task and reports it as newline-delimited JSON events.
+// It reports whether Ctrl+C stopped it.
+func runJSON(task downloader.Task) (interrupted bool, err error) {
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt)
+	defer stop()
+
+	enc := json.NewEncoder(events)
+	header := func(event string) eventHeader {
+		return eventHeader{Event: event, Time: time.Now().UTC()}
+	}
+	src, _ := taskConfig(task)
+	enc.Encode(startEvent{header("start"), redactURL(src.URL)})
+
+	done := make(chan error, 1)
+	go func() {
+		done <- task.Start(ctx)
+	}()
+
+	stats := task.Progress()
+	inspector, _ := task.(downloader.Inspector)
+	states := map[int]downloader.PartState{}
+	report := func() {
+		if inspector != nil {
+			for _, p := range inspector.Inspect().Parts {
+				if st, seen := states[p.ID]; seen && st == p.State || !seen && p.State == downloader.PartPending {
+					continue
+				}
+				states[p.ID] = p.State
+				enc.Encode(partEvent{header("part"), p})
+			}
+		}
+		now := time.Now()
+		ev := progressEvent{eventHeader: header("progress"), Downloaded: stats.GetDownloaded(), Total: stats.GetTotal(), Wire: stats.GetWire()}
+		ev.Speed, ev.AvgSpeed = stats.Rates(now)
+		if eta, ok := downloader.ETA(now, ev.Total-ev.Downloaded, ev.Speed); ok && ev.Total > 0 {
+			secs := eta.Sub(now).Seconds()
+			ev.ETA = &secs
+		}
+		enc.Encode(ev)
+	}
+
+	t := time.NewTicker(eventInterval)
+	defer t.Stop()
+	for {
+		select {
+		case err := <-done:
+			report()
+			if err != nil {
+				enc.Encode(errorEvent{header("error"), err.Error()})
+				return ctx.Err() != nil, err
+			}
+			r, _ := taskResult(task, src, false)
+			enc.Encode(completeEvent{header("complete"), r})
+			return false, nil
+		case <-t.C:
+			report()
+		}
+	}
+}
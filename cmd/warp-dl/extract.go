@@ -0,0 +1,44 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+
+	"warp-dl/internal/downloader"
+	"warp-dl/internal/extract"
+)
+
+var (
+	unpack    bool
+	rmArchive bool
+)
+
+// extractDownload unpacks a finished archive into --dir, or next to it when
+// no --dir was given, and returns the archive's path
+func extractDownload(task downloader.Task, cfg downloader.Config) (string, error) {
+	r, err := taskResult(task, cfg, false)
+	if err != nil {
+		return "", fmt.Errorf("--extract: %w", err)
+	}
+	if len(r.Volumes) > 0 {
+		return "", fmt.Errorf("--extract: the download was saved in volumes, join them first")
+	}
+	dir := downloader.ExpandHome(outDir)
+	if dir == "" {
+		dir = filepath.Dir(r.Path)
+	}
+	n, err := extract.Extract(r.Path, dir)
+	if err != nil {
+		return "", fmt.Errorf("--extract: %w", err)
+	}
+	fmt.Fprintf(msgOut, "Extracted %d entries into %s\n", n, dir)
+	return r.Path, nil
+}
+
+// removeArchive deletes the archive once it's unpacked, for --delete-archive
+func removeArchive(path string) {
+	if err := os.Remove(path); err != nil {
+		fmt.Fprintf(os.Stderr, "Warning: --delete-archive: %v\n", err)
+	}
+}
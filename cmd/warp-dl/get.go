@@ -0,0 +1,72 @@
+package main
+
+import (
+	"fmt"
+	"os"
+
+	"github.com/spf13/cobra"
+	"warp-dl/internal/config"
+)
+
+var presetName string
+
+var getCmd = &cobra.Command{
+	Use:   "get [url | magnet | file.torrent]",
+	Short: "Download a URL, optionally with the flags of a saved preset",
+	Example: "  warp-dl get --preset fast-iso https://example.com/distro.iso\n" +
+		"  warp-dl get --preset fast-iso -c 8 https://example.com/distro.iso",
+	Args: urlArgs,
+	PersistentPreRun: func(cmd *cobra.Command, args []string) {
+		loadConfig()
+		if presetName != "" {
+			if err := applyPreset(cmd, presetName); err != nil {
+				fmt.Fprintln(os.Stderr, err)
+				os.Exit(1)
+			}
+		}
+		applyProfile(cmd)
+		applyPriority(cmd)
+	},
+	Run: func(cmd *cobra.Command, args []string) {
+		runArgs(args)
+	},
+}
+
+// applyPreset sets the preset's flags on cmd. Flags given on the command
+// line win over the preset.
+func applyPreset(cmd *cobra.Command, name string) error {
+	p, ok := conf.Presets[name]
+	if !ok {
+		return fmt.Errorf("no preset named %q, see warp-dl preset list", name)
+	}
+	return setFlags(cmd, fmt.Sprintf("preset %q", name), p)
+}
+
+// setFlags sets the flags of p that are still unset on cmd. Flags other
+// commands have are skipped, unknown ones are an error.
+func setFlags(cmd *cobra.Command, what string, p config.Preset) error {
+	for flag, values := range p {
+		f := cmd.Flags().Lookup(flag)
+		if f == nil {
+			if root := cmd.Root(); root.Flags().Lookup(flag) != nil || root.PersistentFlags().Lookup(flag) != nil {
+				continue
+			}
+			return fmt.Errorf("%s: unknown flag --%s", what, flag)
+		}
+		if f.Changed {
+			continue
+		}
+		for _, v := range values {
+			if err := cmd.Flags().Set(flag, v); err != nil {
+				return fmt.Errorf("%s: --%s: %w", what, flag, err)
+			}
+		}
+	}
+	return nil
+}
+
+func init() {
+	getCmd.Flags().StringVarP(&presetName, "preset", "p", "", "Apply a preset saved with warp-dl preset save")
+	downloadFlags(getCmd.Flags())
+	rootCmd.AddCommand(getCmd)
+}
@@ -0,0 +1,190 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"time"
+
+	"github.com/spf13/cobra"
+	"warp-dl/internal/downloader"
+	"warp-dl/internal/history"
+)
+
+var (
+	historyLimit  int
+	historyBefore string
+)
+
+var historyCmd = &cobra.Command{
+	Use:   "history",
+	Short: "Look up or clear the record of finished downloads",
+}
+
+var historyListCmd = &cobra.Command{
+	Use:   "list",
+	Short: "List finished downloads, newest last",
+	Args:  cobra.NoArgs,
+	Run: func(cmd *cobra.Command, args []string) {
+		entries, err := mustHistory().All()
+		if err != nil {
+			fmt.Fprintln(os.Stderr, err)
+			os.Exit(1)
+		}
+		if historyLimit > 0 && len(entries) > historyLimit {
+			entries = entries[len(entries)-historyLimit:]
+		}
+		printHistory(entries)
+	},
+}
+
+var historySearchCmd = &cobra.Command{
+	Use:     "search <text>",
+	Short:   "List finished downloads whose URL or file contains the text",
+	Example: "  warp-dl history search debian-12",
+	Args:    cobra.ExactArgs(1),
+	Run: func(cmd *cobra.Command, args []string) {
+		entries, err := mustHistory().Search(args[0])
+		if err != nil {
+			fmt.Fprintln(os.Stderr, err)
+			os.Exit(1)
+		}
+		printHistory(entries)
+	},
+}
+
+var historyClearCmd = &cobra.Command{
+	Use:   "clear",
+	Short: "Forget finished downloads, all or those before --before",
+	Example: "  warp-dl history clear\n" +
+		"  warp-dl history clear --before 2024-01-01",
+	Args: cobra.NoArgs,
+	Run: func(cmd *cobra.Command, args []string) {
+		var before time.Time
+		if historyBefore != "" {
+			t, err := parseDate(historyBefore)
+			if err != nil {
+				fmt.Fprintln(os.Stderr, err)
+				os.Exit(1)
+			}
+			before = t
+		}
+		n, err := mustHistory().Clear(before)
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "Failed to clear the history: %v\n", err)
+			os.Exit(1)
+		}
+		fmt.Printf("Removed %d downloads from the history\n", n)
+	},
+}
+
+// openHistory returns the history downloads are recorded in, nil when the
+// config file turned it off
+func openHistory() *history.DB {
+	path := conf.History
+	switch path {
+	case "off":
+		return nil
+	case "":
+		path = history.DefaultPath()
+		if path == "" {
+			return nil
+		}
+	}
+	return history.Open(downloader.ExpandHome(path))
+}
+
+// mustHistory is openHistory for the history commands
+func mustHistory() *history.DB {
+	db := openHistory()
+	if db == nil {
+		fmt.Fprintln(os.Stderr, "The history is off in the config file")
+		os.Exit(1)
+	}
+	return db
+}
+
+// recordHistory adds a finished download to the history. Failing to is only
+// a warning, the download itself is fine.
+func recordHistory(task downloader.Task, cfg downloader.Config, started time.Time) {
+	db := openHistory()
+	if e, ok := task.(*downloader.Engine); db == nil || cfg.Stream != nil || (ok && e.Skipped != "") {
+		return
+	}
+	if c, ok := taskConfig(task); ok {
+		cfg = c
+	}
+	r, err := taskResult(task, cfg, true)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Warning: not recorded in the history: %v\n", err)
+		return
+	}
+	took := time.Since(started)
+	entry := history.Entry{
+		URL:      redactURL(cfg.URL),
+		Path:     r.Path,
+		Size:     r.Size,
+		Checksum: r.Hash,
+		Duration: took.Round(time.Millisecond),
+		Speed:    float64(r.Size) / took.Seconds(),
+		Finished: time.Now(),
+	}
+	if r.URL != entry.URL {
+		entry.FinalURL = r.URL
+	}
+	if err := db.Add(entry); err != nil {
+		fmt.Fprintf(os.Stderr, "Warning: not recorded in the history: %v\n", err)
+	}
+}
+
+// noteDownloadedBefore tells when the history has the URL downloaded to a
+// file that is still there
+func noteDownloadedBefore(url string) {
+	if earlier := earlierDownloads(url); len(earlier) > 0 {
+		e := earlier[0]
+		fmt.Fprintf(msgOut, "You already downloaded this URL to %s on %s\n", e.Path, e.Finished.Local().Format("2006-01-02 15:04"))
+	}
+}
+
+// earlierDownloads returns the history's downloads of the URL whose files
+// are still there, newest first
+func earlierDownloads(url string) []history.Entry {
+	db := openHistory()
+	if db == nil {
+		return nil
+	}
+	entries, err := db.Lookup(redactURL(url))
+	if err != nil {
+		return nil
+	}
+	var there []history.Entry
+	for _, e := range entries {
+		if _, err := os.Stat(e.Path); err == nil {
+			there = append(there, e)
+		}
+	}
+	return there
+}
+
+func printHistory(entries []history.Entry) {
+	for _, e := range entries {
+		fmt.Printf("%s %10.2f MB %8.2f MB/s  %s\n                 %s\n",
+			e.Finished.Local().Format("2006-01-02 15:04"), float64(e.Size)/1024/1024, e.Speed/1024/1024, e.Path, e.URL)
+	}
+}
+
+// parseDate reads a YYYY-MM-DD date, optionally with HH:MM, in local time
+func parseDate(s string) (time.Time, error) {
+	for _, layout := range []string{"2006-01-02 15:04", "2006-01-02T15:04", "2006-01-02", time.RFC3339} {
+		if t, err := time.ParseInLocation(layout, s, time.Local); err == nil {
+			return t, nil
+		}
+	}
+	return time.Time{}, fmt.Errorf("invalid date %q: want YYYY-MM-DD, optionally with HH:MM", s)
+}
+
+func init() {
+	historyListCmd.Flags().IntVarP(&historyLimit, "limit", "n", 0, "Only the last N downloads")
+	historyClearCmd.Flags().StringVar(&historyBefore, "before", "", "Only the downloads that finished before this date (YYYY-MM-DD [HH:MM])")
+	historyCmd.AddCommand(historyListCmd, historySearchCmd, historyClearCmd)
+	rootCmd.AddCommand(historyCmd)
+}
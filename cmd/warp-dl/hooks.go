@@ -0,0 +1,69 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"strconv"
+	"strings"
+
+	"warp-dl/internal/downloader"
+)
+
+var (
+	onComplete string
+	onError    string
+)
+
+// runHook runs the --on-complete command of a finished download, or the
+// --on-error one when failure is set. The command goes to the shell with
+// {file} replaced by the quoted output path and the download described in
+// WARP_DL_* variables.
+func runHook(task downloader.Task, cfg downloader.Config, failure error) error {
+	command, name := onComplete, "--on-complete"
+	if command == "" {
+		command = conf.Hooks.OnComplete
+	}
+	if failure != nil {
+		command, name = onError, "--on-error"
+		if command == "" {
+			command = conf.Hooks.OnError
+		}
+	}
+	if command == "" {
+		return nil
+	}
+
+	// Fails for downloads that left no file, the path and URL are set anyway
+	r, statErr := taskResult(task, cfg, false)
+	if c, _ := taskConfig(task); c.OutputName == "" || cfg.Stream != nil {
+		// Failed before it had a name, or streamed
+		r.Path = ""
+	}
+	env := []string{"WARP_DL_URL=" + r.URL, "WARP_DL_FILE=" + r.Path}
+	if failure != nil {
+		env = append(env,
+			"WARP_DL_SIZE="+strconv.FormatInt(task.Progress().GetDownloaded(), 10),
+			"WARP_DL_ERROR="+failure.Error())
+	} else {
+		env = append(env, "WARP_DL_SIZE="+strconv.FormatInt(max(r.Size, task.Progress().GetDownloaded()), 10))
+		if statErr == nil && r.Path != "" {
+			files := r.Volumes
+			if len(files) == 0 {
+				files = []string{r.Path}
+			}
+			// Directories from torrents have no single digest
+			if digest, err := downloader.HashFiles("sha256", files...); err == nil {
+				env = append(env, "WARP_DL_SHA256="+digest)
+			}
+		}
+	}
+
+	cmd := shellCommand(context.Background(), strings.ReplaceAll(command, "{file}", shellArg("WARP_DL_FILE", r.Path)))
+	cmd.Env = append(os.Environ(), env...)
+	cmd.Stdin, cmd.Stdout, cmd.Stderr = os.Stdin, msgOut, os.Stderr
+	if err := cmd.Run(); err != nil {
+		return fmt.Errorf("%s: %w", name, err)
+	}
+	return nil
+}
@@ -0,0 +1,24 @@
+//go:build !windows
+
+package main
+
+import (
+	"context"
+	"os/exec"
+	"strings"
+)
+
+func shellCommand(ctx context.Context, command string) *exec.Cmd {
+	return exec.CommandContext(ctx, "/bin/sh", "-c", command)
+}
+
+// shellArg is the word for value in a command line, whose environment has
+// it in the variable name
+func shellArg(name, value string) string {
+	return shellQuote(value)
+}
+
+// shellQuote makes s a single word for sh
+func shellQuote(s string) string {
+	return "'" + strings.ReplaceAll(s, "'", `'\''`) + "'"
+}
@@ -0,0 +1,29 @@
+package main
+
+import (
+	"context"
+	"os"
+	"os/exec"
+	"syscall"
+)
+
+func shellCommand(ctx context.Context, command string) *exec.Cmd {
+	shell := os.Getenv("COMSPEC")
+	if shell == "" {
+		shell = "cmd.exe"
+	}
+	cmd := exec.CommandContext(ctx, shell)
+	// cmd.exe parses its command line itself, pass it through untouched
+	// and without delayed expansion, ! in a name stays a !
+	cmd.SysProcAttr = &syscall.SysProcAttr{CmdLine: `"` + shell + `" /d /v:off /s /c "` + command + `"`}
+	return cmd
+}
+
+// shellArg is the word for value in a command line, whose environment has
+// it in the variable name. cmd.exe expands %VAR% even inside double
+// quotes, so the value itself never goes on the line: a file name like
+// %PATH%.bin would be expanded there, but one expansion of the variable
+// isn't expanded again.
+func shellArg(name, value string) string {
+	return `"%` + name + `%"`
+}
@@ -0,0 +1,190 @@
+package main
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/spf13/cobra"
+	"warp-dl/internal/downloader"
+)
+
+var (
+	inputFile string
+	referer   string
+)
+
+// inputEntry is a download of an --input-file: the URL, its mirrors on the
+// same line and the options on the indented lines below
+type inputEntry struct {
+	line    int
+	urls    []string
+	out     string
+	dir     string
+	referer string
+	agent   string
+	headers []string
+}
+
+// urlArgs wants the URL, or nothing with --input-file or --from-page
+func urlArgs(cmd *cobra.Command, args []string) error {
+	if inputFile != "" || fromPage != "" {
+		return cobra.NoArgs(cmd, args)
+	}
+	return cobra.ExactArgs(1)(cmd, args)
+}
+
+func runArgs(args []string) {
+	switch {
+	case inputFile != "" && fromPage != "":
+		fmt.Fprintln(os.Stderr, "--input-file and --from-page exclude each other")
+		os.Exit(1)
+	case inputFile != "":
+		runInputFile(inputFile)
+		return
+	case fromPage != "":
+		runFromPage(fromPage)
+		return
+	}
+	runURL(args[0])
+}
+
+// runInputFile downloads the entries of an input file one after another,
+// stopping at the first that fails like a multi-file metalink
+func runInputFile(path string) {
+	var r io.Reader = os.Stdin
+	if path != "-" {
+		f, err := os.Open(downloader.ExpandHome(path))
+		if err != nil {
+			fmt.Fprintln(os.Stderr, err)
+			os.Exit(1)
+		}
+		defer f.Close()
+		r = f
+	}
+	entries, err := parseInputFile(r)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "%s: %v\n", path, err)
+		os.Exit(1)
+	}
+	if len(entries) > 1 && output != "" {
+		fmt.Fprintln(os.Stderr, "--output cannot be used with several URLs, give them out= in the input file")
+		os.Exit(1)
+	}
+	if len(entries) > 1 && signature != "" {
+		fmt.Fprintln(os.Stderr, "--signature cannot be used with several URLs")
+		os.Exit(1)
+	}
+	for _, in := range entries {
+		runEntry(in)
+	}
+}
+
+// parseInputFile reads aria2's input file format: a line per download with
+// its URL and tab-separated mirrors, then option=value lines indented
+// below it. Blank lines and lines starting with # are skipped.
+func parseInputFile(r io.Reader) ([]inputEntry, error) {
+	var entries []inputEntry
+	sc := bufio.NewScanner(r)
+	for n := 1; sc.Scan(); n++ {
+		line := sc.Text()
+		text := strings.TrimSpace(line)
+		if text == "" || strings.HasPrefix(text, "#") {
+			continue
+		}
+		if line[0] != ' ' && line[0] != '\t' {
+			entries = append(entries, inputEntry{line: n, urls: strings.Fields(text)})
+			continue
+		}
+		if len(entries) == 0 {
+			return nil, fmt.Errorf("line %d: option before the first URL", n)
+		}
+		name, value, ok := strings.Cut(text, "=")
+		if !ok {
+			return nil, fmt.Errorf("line %d: want option=value, got %q", n, text)
+		}
+		in := &entries[len(entries)-1]
+		switch name = strings.TrimSpace(name); name {
+		case "out":
+			in.out = value
+		case "dir":
+			in.dir = value
+		case "referer":
+			in.referer = value
+		case "user-agent":
+			in.agent = value
+		case "header":
+			if _, _, err := downloader.ParseHeader(value); err != nil {
+				return nil, fmt.Errorf("line %d: %w", n, err)
+			}
+			in.headers = append(in.headers, value)
+		default:
+			fmt.Fprintf(os.Stderr, "Warning: line %d: ignoring option %s\n", n, name)
+		}
+	}
+	return entries, sc.Err()
+}
+
+// runEntry downloads an input file entry with its options over the flags
+func runEntry(in inputEntry) {
+	saved := []*string{&output, &outDir, &referer, &userAgent}
+	was := make([]string, len(saved))
+	for i, p := range saved {
+		was[i] = *p
+	}
+	wasHeaders := headerFlags
+	defer func() {
+		for i, p := range saved {
+			*p = was[i]
+		}
+		headerFlags = wasHeaders
+	}()
+
+	if in.dir != "" {
+		outDir = in.dir
+	}
+	if in.out != "" {
+		output = in.out
+		if !filepath.IsAbs(output) && outDir != "" {
+			output = filepath.Join(downloader.ExpandHome(outDir), output)
+		}
+	}
+	if in.referer != "" {
+		referer = in.referer
+	}
+	if in.agent != "" {
+		userAgent = in.agent
+	}
+	headerFlags = entryHeaders(headerFlags, in.headers)
+
+	if downloader.IsMetalink(in.urls[0]) {
+		runMetalink(in.urls[0])
+		return
+	}
+	cfg := baseConfig(in.urls[0])
+	cfg.Mirrors = append(cfg.Mirrors, in.urls[1:]...)
+	runDownload(cfg)
+}
+
+// entryHeaders layers an entry's header= options over the --header flags:
+// a name the entry sets drops the flags' values of it
+func entryHeaders(flags, own []string) []string {
+	if len(own) == 0 {
+		return flags
+	}
+	set := map[string]bool{}
+	for _, h := range own {
+		name, _, _ := downloader.ParseHeader(h)
+		set[name] = true
+	}
+	var out []string
+	for _, h := range flags {
+		if name, _, err := downloader.ParseHeader(h); err != nil || !set[name] {
+			out = append(out, h)
+		}
+	}
+	return append(out, own...)
+}
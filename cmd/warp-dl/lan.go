@@ -0,0 +1,79 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net"
+	"net/http"
+	"os"
+
+	"warp-dl/internal/daemon"
+	"warp-dl/internal/downloader"
+	"warp-dl/internal/lan"
+)
+
+const defaultLANListen = ":7801"
+
+// withLANPeers has the download look for daemons on the network that
+// already have the file. Files are found by digest, so only downloads with
+// a known checksum can come from peers, and the checksum still guards what
+// they send.
+func withLANPeers(cfg downloader.Config) downloader.Config {
+	if useLAN {
+		cfg.FindPeers = func(ctx context.Context, sum *downloader.Checksum) []string {
+			return lan.Find(ctx, sum.Algo, sum.Value)
+		}
+	}
+	return cfg
+}
+
+// serveLAN offers the daemon's finished downloads to its peers and
+// announces it on the network until ctx is canceled
+func serveLAN(ctx context.Context, cfg daemon.LANConfig, m *daemon.Manager) error {
+	listen := cfg.Listen
+	if listen == "" {
+		listen = defaultLANListen
+	}
+	ln, err := net.Listen("tcp", listen)
+	if err != nil {
+		return err
+	}
+	port := ln.Addr().(*net.TCPAddr).Port
+
+	cache := lan.NewCache()
+	done := m.OnDone
+	m.OnDone = func(task downloader.Task) {
+		if done != nil {
+			done(task)
+		}
+		e, ok := task.(*downloader.Engine)
+		if !ok || len(e.Volumes) > 0 || e.Config.Range != nil {
+			return
+		}
+		if sum := e.Config.Checksum; sum != nil {
+			cache.Add(sum.Algo, sum.Value, e.Config.OutputName)
+			return
+		}
+		// Peers ask by SHA-256 when nothing else is known
+		go cache.AddFile(e.Config.OutputName)
+	}
+
+	srv := &http.Server{Handler: cache.Handler()}
+	go func() {
+		<-ctx.Done()
+		srv.Close()
+	}()
+	go func() {
+		if err := lan.Advertise(ctx, port); err != nil {
+			fmt.Fprintf(os.Stderr, "Warning: LAN discovery: %v\n", err)
+		}
+	}()
+	go func() {
+		if err := srv.Serve(ln); err != nil && !errors.Is(err, http.ErrServerClosed) {
+			fmt.Fprintf(os.Stderr, "Warning: LAN peer server: %v\n", err)
+		}
+	}()
+	fmt.Printf("Sharing finished downloads with LAN peers on port %d\n", port)
+	return nil
+}
@@ -0,0 +1,112 @@
+package main
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+	"log/slog"
+	"os"
+	"strings"
+	"sync"
+)
+
+var (
+	logLevel  string
+	logFile   string
+	logFormat string
+	verbosity int
+	wireTrace bool
+)
+
+// logger is the log of the process, set up by setupLogging
+var logger *slog.Logger
+
+// termLog holds back log lines meant for the terminal while the progress
+// UI draws on it, and writes them out when it is done
+type termLog struct {
+	mu   sync.Mutex
+	w    io.Writer
+	hold bool
+	buf  bytes.Buffer
+}
+
+func (t *termLog) Write(p []byte) (int, error) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	if t.hold {
+		return t.buf.Write(p)
+	}
+	return t.w.Write(p)
+}
+
+func (t *termLog) setHold(hold bool) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	t.hold = hold
+	if !hold {
+		t.w.Write(t.buf.Bytes())
+		t.buf.Reset()
+	}
+}
+
+// logTerm is set when the log goes to stderr
+var logTerm *termLog
+
+// setupLogging builds the logger from --log-level, -v, --log-file and
+// --log-format. Warnings go to stderr unless told otherwise.
+func setupLogging() *slog.Logger {
+	if logger != nil {
+		return logger
+	}
+	var level slog.Level
+	if err := level.UnmarshalText([]byte(logLevel)); err != nil {
+		fmt.Fprintf(os.Stderr, "Invalid --log-level %q: want debug, info, warn or error\n", logLevel)
+		os.Exit(1)
+	}
+	// -v shows the decisions, -v -v and --trace every request too
+	switch {
+	case verbosity >= 2 || wireTrace:
+		level = min(level, slog.LevelDebug)
+	case verbosity == 1:
+		level = min(level, slog.LevelInfo)
+	}
+
+	var w io.Writer
+	if logFile != "" {
+		f, err := os.OpenFile(logFile, os.O_WRONLY|os.O_CREATE|os.O_APPEND, 0o644)
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "Cannot open --log-file: %v\n", err)
+			os.Exit(1)
+		}
+		w = f
+	} else {
+		logTerm = &termLog{w: os.Stderr}
+		w = logTerm
+	}
+
+	opts := &slog.HandlerOptions{Level: level}
+	switch strings.ToLower(logFormat) {
+	case "text":
+		logger = slog.New(slog.NewTextHandler(w, opts))
+	case "json":
+		logger = slog.New(slog.NewJSONHandler(w, opts))
+	default:
+		fmt.Fprintf(os.Stderr, "Invalid --log-format %q: want text or json\n", logFormat)
+		os.Exit(1)
+	}
+	return logger
+}
+
+// holdLogs keeps log lines off the terminal while the progress UI runs,
+// releaseLogs writes them out
+func holdLogs() {
+	if logTerm != nil {
+		logTerm.setHold(true)
+	}
+}
+
+func releaseLogs() {
+	if logTerm != nil {
+		logTerm.setHold(false)
+	}
+}
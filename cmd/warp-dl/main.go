@@ -3,75 +3,899 @@ package main
 import (
 	"context"
 	"fmt"
+	"io"
+	"net/http"
 	"os"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
 
 	tea "github.com/charmbracelet/bubbletea"
 	"github.com/spf13/cobra"
+	"github.com/spf13/pflag"
+	"warp-dl/internal/config"
+	"warp-dl/internal/cookies"
 	"warp-dl/internal/downloader"
+	"warp-dl/internal/priority"
+	"warp-dl/internal/torrent"
 	"warp-dl/internal/ui"
 )
 
 var (
-	concurrency int
+	concurrency string
 	output      string
 	useDoH      bool
+	dnsMode     string
+	quality     string
+	track       string
+	platform    string
+	follow      bool
+	followEvery time.Duration
+	sshKey      string
+	sequential  bool
+	seedRatio   float64
+	torrentPort int
+	maxInFlight string
+	bufSize     string
+	minSplit    string
+	niceLevel   int
+	ioPriority  string
+	motw        bool
+	noMOTW      bool
+	configPath  string
+	http2Mode   string
+	splitOutput string
+	pacScript   string
+	wpad        bool
+	fsync       bool
+	dropCache   bool
+	fileAlloc   string
+	autoSum     bool
+	useLAN      bool
+	method      string
+	postData    string
+	retries     int
+	retryWait   time.Duration
+	retryMax    time.Duration
+	retryOn     string
+	headerFlags []string
+	stallAfter  time.Duration
+	hostConns   int
+	pipeline    int
+	linkNames   []string
+	connectTo   []string
+	sniName     string
+	tlsPrint    string
+	pinFlags    []string
+	awsSigV4    string
+	hostDelay   time.Duration
+	holdHost    bool
+	taskbarBar  bool
+	notifyDone  bool
+	paranoid    bool
+	printField  string
+	autoMirrors int
+	noTUI       bool
+	quiet       bool
+	noColor     bool
+	progressFmt string
+	eventsFile  string
+	profileName string
+	proxyURL    string
+	dohServers  []string
+	outDir      string
+	nameFormat  string
+	onConflict  string
+	newerOnly   bool
+	dropPartial bool
+	compressed  bool
+
+	conf = &config.File{}
 )
 
 var rootCmd = &cobra.Command{
-	Use:   "warp-dl [url]",
+	Use:   "warp-dl [url | magnet | file.torrent | gh:owner/repo@tag/asset | -i file | --from-page url]",
 	Short: "A high-performance multi-threaded download manager",
-	Args:  cobra.ExactArgs(1),
+	Args:  urlArgs,
+	PersistentPreRun: func(cmd *cobra.Command, args []string) {
+		loadConfig()
+		applyProfile(cmd)
+		applyDoH(cmd)
+		applyPriority(cmd)
+	},
 	Run: func(cmd *cobra.Command, args []string) {
-		url := args[0]
-		runDownload(url)
+		runArgs(args)
 	},
 }
 
 func init() {
-	rootCmd.Flags().IntVarP(&concurrency, "concurrent", "c", 16, "Number of concurrent connections")
-	rootCmd.Flags().StringVarP(&output, "output", "o", "", "Output filename")
-	rootCmd.Flags().BoolVarP(&useDoH, "doh", "s", true, "Use DNS over HTTPS (Anti-ISP Block)")
+	rootCmd.PersistentFlags().StringVarP(&concurrency, "concurrent", "c", "16", "Number of concurrent connections, or auto to tune it per server")
+	rootCmd.PersistentFlags().StringVarP(&output, "output", "o", "", "Output filename, - to stream the file to stdout in order, e.g. into tar")
+	rootCmd.PersistentFlags().BoolVarP(&useDoH, "doh", "s", true, "Use DNS over HTTPS (Anti-ISP Block), the same as --dns doh; --doh=false is --dns system")
+	rootCmd.PersistentFlags().StringVar(&dnsMode, "dns", "auto", "Resolve host names over doh, dot (DNS over TLS), the system resolver, or auto: DoH, falling back to the system resolver when it fails")
+	rootCmd.PersistentFlags().StringVarP(&quality, "quality", "q", "best", "Stream variant for HLS/DASH: best, worst, <height>p or <bandwidth>")
+	rootCmd.PersistentFlags().StringVar(&platform, "platform", "", "Image variant of oci:// URLs as os/arch[/variant], e.g. linux/arm64 (default: linux and this machine's architecture)")
+	rootCmd.PersistentFlags().StringVar(&track, "track", "video", "DASH adaptation set to download: video or audio")
+	rootCmd.PersistentFlags().StringVar(&sshKey, "ssh-key", "", "Private key for sftp:// URLs (default: SSH agent, ~/.ssh/id_*)")
+	rootCmd.PersistentFlags().StringVar(&configPath, "config", config.DefaultPath(), "Config file")
+	rootCmd.PersistentFlags().StringVar(&profileName, "profile", "", "Use the flag values of this profile from the config file's profiles, over its defaults")
+	rootCmd.PersistentFlags().BoolVar(&latestRelease, "latest", false, "Download the asset of a gh:owner/repo/asset or gl:group/project/asset shorthand from the latest release")
+	rootCmd.PersistentFlags().StringVar(&outDir, "dir", "", "Directory for downloads saved under their own name (default: the current directory)")
+	rootCmd.PersistentFlags().StringVar(&nameFormat, "name-template", "", "Name downloads without --output like {host}/{date}/{filename}; also {name} and {ext}, the name without and just its extension")
+	rootCmd.PersistentFlags().StringVar(&onConflict, "on-conflict", "overwrite", "When the output file exists: overwrite, skip, rename (to \"name (1).ext\") or resume (fetch the rest with a range request)")
+	rootCmd.PersistentFlags().StringVar(&dedupeMode, "dedupe", "ask", "When the output or an earlier download of the URL already has the file (same size, start and end): skip, ask or force (download without checking)")
+	rootCmd.PersistentFlags().BoolVarP(&newerOnly, "newer-only", "N", false, "Only download when the server's copy is newer than the local file (If-Modified-Since), like wget -N")
+	rootCmd.PersistentFlags().BoolVar(&dropPartial, "no-keep-partial", false, "Delete the part files and resume state when a download is interrupted or fails, instead of keeping them to resume")
+	rootCmd.PersistentFlags().StringVar(&onComplete, "on-complete", "", "Run this shell command after a download succeeds, {file} is the quoted output path; WARP_DL_URL, WARP_DL_FILE, WARP_DL_SIZE and WARP_DL_SHA256 describe it")
+	rootCmd.PersistentFlags().StringArrayVar(&pluginPaths, "plugin", nil, "Run this plugin executable at the url-rewrite, header-injection, pre-download and post-download hooks, after the config file's plugins; repeatable")
+	rootCmd.PersistentFlags().StringVar(&onError, "on-error", "", "Run this shell command when a download fails, with {file} and the WARP_DL_* variables of --on-complete and WARP_DL_ERROR")
+	rootCmd.PersistentFlags().StringVar(&schedule, "schedule", "", "Start the download at this time: HH:MM (the next one) or YYYY-MM-DD HH:MM")
+	rootCmd.PersistentFlags().BoolVar(&unpack, "extract", false, "After the download is verified, unpack a zip, tar (plain, gz, bz2, xz, zst) or 7z archive into --dir, or next to it")
+	rootCmd.PersistentFlags().BoolVar(&rmArchive, "delete-archive", false, "Delete the archive once --extract unpacked it")
+	rootCmd.PersistentFlags().BoolVar(&compressed, "compressed", false, "Ask for a gzip, deflate or zstd compressed response and decode it, for servers that only send compressed files. Compressed downloads use one connection")
+	rootCmd.PersistentFlags().StringVar(&proxyURL, "proxy", "", "Proxy for every request: http://, https:// or socks5:// URL, or direct to ignore the environment and PAC")
+	rootCmd.PersistentFlags().StringArrayVar(&dohServers, "doh-server", nil, "DoH JSON endpoint for --doh, repeatable and tried in order (default: Cloudflare)")
+	rootCmd.PersistentFlags().IntVar(&niceLevel, "nice", 0, "CPU niceness, -20 (highest) to 19 (lowest)")
+	rootCmd.PersistentFlags().StringVar(&ioPriority, "ionice", "", "Disk I/O priority: idle, best-effort[:0-7] or realtime[:0-7]")
+	rootCmd.PersistentFlags().BoolVar(&motw, "motw", false, "Windows: mark every download with Mark-of-the-Web (default: executables and archives only)")
+	rootCmd.PersistentFlags().BoolVar(&noMOTW, "no-motw", false, "Windows: never write the Mark-of-the-Web")
+	rootCmd.MarkFlagsMutuallyExclusive("motw", "no-motw")
+	rootCmd.PersistentFlags().StringVar(&http2Mode, "http2", "on", "HTTP/2 multiplexing: on (benchmark against HTTP/1.1 per host), force or off")
+	rootCmd.PersistentFlags().StringVar(&minSplit, "min-split-size", "1M", "Smallest part per connection, smaller files are fetched in a single request")
+	rootCmd.PersistentFlags().StringVar(&pacScript, "pac", "", "Choose proxies with this proxy auto-config script (URL or file)")
+	rootCmd.PersistentFlags().BoolVar(&wpad, "wpad", false, "Discover the network's proxy auto-config script via DHCP and DNS (WPAD)")
+	rootCmd.MarkFlagsMutuallyExclusive("pac", "wpad")
+	rootCmd.PersistentFlags().BoolVar(&fsync, "fsync", false, "Flush the finished file to disk before moving it into place")
+	rootCmd.PersistentFlags().StringVar(&fileAlloc, "file-allocation", "none", "Space for the files before data arrives: none (grow as written), trunc (sparse full size), prealloc (write zeros) or falloc (reserve blocks), against fragmentation on spinning disks and FAT/exFAT")
+	rootCmd.PersistentFlags().BoolVar(&dropCache, "drop-cache", false, "Keep the download out of the page cache as it is written (fadvise DONTNEED, F_NOCACHE on macOS), for huge files on busy servers")
+	rootCmd.PersistentFlags().StringVar(&progressFmt, "progress", "", "Progress output: tui, plain (a line every few seconds, the default when output isn't a terminal), json (one event per line for GUIs and scripts) or none")
+	rootCmd.PersistentFlags().StringVar(&eventsFile, "progress-file", "", "Write --progress json events to this file or named pipe instead of stdout")
+	rootCmd.PersistentFlags().BoolVar(&noTUI, "no-tui", false, "Same as --progress plain")
+	rootCmd.PersistentFlags().BoolVar(&quiet, "quiet", false, "Same as --progress none")
+	rootCmd.PersistentFlags().BoolVar(&noColor, "no-color", false, "Draw the progress UI without colors, also with NO_COLOR set or the config file's theme.no_color")
+	rootCmd.PersistentFlags().StringVar(&printField, "print", "", "After the download, write only this to stdout and everything else to stderr: "+strings.Join(printFields, ", "))
+	rootCmd.PersistentFlags().BoolVar(&autoSum, "auto-checksum", false, "Look for the file's digest in name.sha256, SHA256SUMS or CHECKSUMS.txt next to it and verify the download against it")
+	rootCmd.PersistentFlags().StringVar(&zsyncSrc, "zsync", "", "Update the existing output from a .zsync control file (URL or path), fetching only the blocks that changed; alone it tries the URL with .zsync appended")
+	rootCmd.PersistentFlags().Lookup("zsync").NoOptDefVal = "auto"
+	rootCmd.PersistentFlags().StringVar(&signature, "signature", "", "Detached OpenPGP signature (.sig or .asc, URL or file) the download must match before it is moved into place")
+	rootCmd.PersistentFlags().StringArrayVar(&gpgKeys, "gpg-key", nil, "Public key file the --signature must come from, armored or binary, repeatable")
+	rootCmd.PersistentFlags().BoolVar(&paranoid, "paranoid", false, "Re-fetch a few KB across every part boundary and compare, to catch servers whose range support returns shifted data")
+	rootCmd.PersistentFlags().BoolVar(&taskbarBar, "taskbar", false, "Show progress on the taskbar button (Windows) or the launcher icon via D-Bus (Linux desktops)")
+	rootCmd.PersistentFlags().BoolVar(&notifyDone, "notify", false, "Show a desktop notification when the download completes or fails (notify-send, macOS Notification Center or a Windows toast)")
+	rootCmd.PersistentFlags().IntVar(&autoMirrors, "auto-mirrors", 0, "Find the official mirrors of known sites (Debian, Ubuntu, Fedora and the config file's mirrors), time them and also download from the N fastest")
+	rootCmd.PersistentFlags().BoolVar(&useLAN, "lan", false, "Fetch files with a known checksum from warp-dl daemons on the local network that have them; the daemon also shares its own")
+	rootCmd.PersistentFlags().StringVar(&splitOutput, "split-output", "auto", "Write the output as name.001, name.002, ... volumes: auto (when the target file system can't hold it, e.g. FAT32), off or a volume size")
+	rootCmd.PersistentFlags().IntVar(&hostConns, "max-connections-per-host", 0, "Connections to one server at a time, over every part and download of the run (0 for no cap)")
+	rootCmd.PersistentFlags().IntVar(&pipeline, "pipeline", 0, "Fetch many small ranges one after another over N kept-alive HTTP/1.1 connections instead of a connection per part, for servers with strict connection limits (overrides --concurrent)")
+	rootCmd.PersistentFlags().StringArrayVar(&linkNames, "interface", nil, "Send connections out on this network interface (name or address); repeat it, e.g. for Wi-Fi and LTE, to spread the parts over both and add up their speed")
+	rootCmd.PersistentFlags().StringArrayVar(&connectTo, "connect-to", nil, "Connect to HOST at another address, host:ip or host:ip:port, keeping the Host header and TLS name, like curl --connect-to; repeatable, not through --proxy")
+	rootCmd.PersistentFlags().StringVar(&sniName, "sni-override", "", "Present this TLS server name (SNI) instead of the URL's host, keeping the Host header, e.g. a CDN's front domain")
+	rootCmd.PersistentFlags().StringVar(&tlsPrint, "tls-fingerprint", "", "Send a browser's TLS ClientHello on direct HTTPS connections, for filters that block Go's: "+strings.Join(downloader.TLSFingerprints(), ", "))
+	rootCmd.PersistentFlags().StringVar(&awsSigV4, "aws-sigv4", "", "Sign every request with AWS SigV4 for region/service, e.g. us-east-1/s3, to download from private S3 or MinIO buckets by URL. Credentials come from AWS_ACCESS_KEY_ID/AWS_SECRET_ACCESS_KEY, AWS_PROFILE or ~/.aws/credentials")
+	rootCmd.PersistentFlags().StringArrayVar(&pinFlags, "pin-sha256", nil, "Fail TLS connections unless the leaf certificate has one of these public keys, or chains up to a CA with one, sha256//BASE64 like curl --pinnedpubkey; repeatable. Certificates aren't checked otherwise, this detects interception")
+	rootCmd.PersistentFlags().BoolVar(&useTor, "tor", false, "Route every request through a local Tor SOCKS proxy, which also resolves the names, after checking with check.torproject.org; direct connections (sftp, torrents, LAN peers) are refused")
+	rootCmd.PersistentFlags().StringVar(&torAddr, "tor-proxy", downloader.DefaultTorProxy, "Tor SOCKS address for --tor, 127.0.0.1:9150 for Tor Browser")
+	rootCmd.PersistentFlags().DurationVar(&hostDelay, "delay-between-requests", 0, "Wait at least this long between two requests to the same server, e.g. 500ms")
+	rootCmd.PersistentFlags().StringVar(&refreshCmd, "refresh-cmd", "", "Shell command printing a fresh URL when the URL expires mid-download (401/403/410), e.g. a re-signed CDN link; {url} or $WARP_DL_URL is the expired one")
+	rootCmd.PersistentFlags().BoolVar(&holdHost, "throttle-host", false, "When a server answers 429/503 with Retry-After, pause every part to it, not only the one it answered")
+	rootCmd.PersistentFlags().DurationVar(&stallAfter, "stall-timeout", 30*time.Second, "Reconnect a part that receives no data for this long, keeping what it already has (0 waits forever)")
+	rootCmd.PersistentFlags().StringArrayVarP(&headerFlags, "header", "H", nil, "Extra request header \"Name: value\", repeatable; overrides the config file's headers, \"Name:\" drops one")
+	rootCmd.PersistentFlags().StringVar(&userAgent, "user-agent", "", "User-Agent for every request, over --header and the config file's")
+	rootCmd.PersistentFlags().StringVar(&uaPreset, "ua-preset", "", "Send a known client's User-Agent: "+strings.Join(downloader.UserAgentPresets(), ", "))
+	rootCmd.PersistentFlags().StringVar(&referer, "referer", "", "Referer for every request, * for the URL itself, for hosts that check where links come from")
+	rootCmd.PersistentFlags().StringVarP(&inputFile, "input-file", "i", "", "Download the URLs of this file (- for stdin), one per line with aria2-style options such as out=, dir=, referer= and header= on indented lines after it")
+	rootCmd.PersistentFlags().StringVar(&fromPage, "from-page", "", "Download the links of this HTML page or sitemap, those matching --accept or else every link that isn't to another page")
+	rootCmd.PersistentFlags().StringArrayVar(&acceptGlobs, "accept", nil, "With --from-page, only links whose file name matches this glob, e.g. '*.pdf', repeatable")
+	rootCmd.PersistentFlags().StringVar(&cookiesFrom, "cookies-from-browser", "", "Send the site's cookies from a browser's store: "+strings.Join(cookies.Browsers, ", ")+", optionally :PROFILE for another profile than the last used")
+	rootCmd.PersistentFlags().StringVar(&maxInFlight, "max-inflight", "32M", "Memory cap for data received but not yet written to disk")
+	rootCmd.PersistentFlags().StringVar(&bufSize, "buffer-size", "64K", "Data read from a connection per disk write, up to 16M; larger buffers help on fast links")
+	rootCmd.PersistentFlags().IntVar(&retries, "retries", downloader.DefaultRetry.Retries, "Retries per part, and for the whole download once its parts gave up")
+	rootCmd.PersistentFlags().DurationVar(&retryWait, "retry-wait", downloader.DefaultRetry.Wait, "Wait before the first retry, doubled (with jitter) for each one after")
+	rootCmd.PersistentFlags().DurationVar(&retryMax, "retry-max-wait", downloader.DefaultRetry.MaxWait, "Longest wait between retries")
+	rootCmd.PersistentFlags().StringVar(&logLevel, "log-level", "warn", "Log messages of this level and up: debug, info, warn or error")
+	rootCmd.PersistentFlags().StringVar(&logFile, "log-file", "", "Append the log to this file instead of stderr")
+	rootCmd.PersistentFlags().StringVar(&logFormat, "log-format", "text", "Log format: text or json")
+	rootCmd.PersistentFlags().CountVarP(&verbosity, "verbose", "v", "Log the engine's decisions, twice (-v -v) also every request and response")
+	rootCmd.PersistentFlags().BoolVar(&wireTrace, "trace", false, "Log the DNS, connect, TLS and time to first byte of every request, with the headers sent and received (credentials redacted); implies -v -v")
+	rootCmd.PersistentFlags().StringVar(&retryOn, "retry-on", "5xx,408,429,reset,timeout", "Failures to retry: HTTP status codes or classes (4xx, 5xx), reset (refused or dropped connections), timeout")
+	for _, f := range []string{"proxy", "pac", "wpad", "interface", "lan", "connect-to"} {
+		rootCmd.MarkFlagsMutuallyExclusive("tor", f)
+	}
+	rootCmd.MarkFlagsMutuallyExclusive("tls-fingerprint", "interface")
+	downloadFlags(rootCmd.Flags())
+}
+
+// downloadFlags defines the flags of a plain download, shared by the root
+// command and get
+func downloadFlags(fs *pflag.FlagSet) {
+	fs.BoolVarP(&follow, "follow", "f", false, "Keep polling the URL and append new data (tail -f over HTTP)")
+	fs.DurationVar(&followEvery, "follow-interval", 5*time.Second, "Poll period for --follow")
+	fs.BoolVar(&sequential, "sequential", false, "Download torrent pieces in order (for previewing)")
+	fs.Float64Var(&seedRatio, "seed-ratio", 0, "Keep seeding a torrent until uploaded/size reaches this ratio")
+	fs.IntVar(&torrentPort, "torrent-port", 6881, "Listen port for incoming torrent peers")
+	fs.StringVarP(&method, "method", "X", "", "HTTP method for the download request (default GET, POST with --data); non-GET downloads use one connection")
+	fs.StringVarP(&postData, "data", "d", "", "Form body for the request: a string, @file or @- for stdin")
+	fs.StringVar(&recordPath, "record", "", "Record the requests, responses and the engine's decisions to this file, for warp-dl replay and bug reports")
+	fs.StringVar(&recordBody, "record-body", "0", "Body bytes per response kept by --record, or all; the rest only gets hashed")
 }
 
 func main() {
+	registerCompletions()
 	if err := rootCmd.Execute(); err != nil {
 		fmt.Fprintln(os.Stderr, err)
 		os.Exit(1)
 	}
 }
 
-func runDownload(url string) {
-	cfg := downloader.Config{
-		URL:         url,
-		Concurrency: concurrency,
-		OutputName:  output,
-		UseDoH:      useDoH,
+func loadConfig() {
+	f, err := config.Load(configPath)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Failed to load config: %v\n", err)
+		os.Exit(1)
+	}
+	conf = f
+}
+
+// applyDoH turns the older --doh into its --dns mode, unless --dns is set too
+func applyDoH(cmd *cobra.Command) {
+	if !cmd.Flags().Changed("doh") || cmd.Flags().Changed("dns") {
+		return
+	}
+	dnsMode = "system"
+	if useDoH {
+		dnsMode = "doh"
+	}
+}
+
+// applyPriority lowers the process priority when asked. Platforms without
+// support only get a warning, the download itself is unaffected.
+func applyPriority(cmd *cobra.Command) {
+	if cmd.Flags().Changed("nice") {
+		if err := priority.SetNice(niceLevel); err != nil {
+			fmt.Fprintf(os.Stderr, "Warning: --nice: %v\n", err)
+		}
+	}
+	if ioPriority != "" {
+		p, err := priority.ParseIOPriority(ioPriority)
+		if err != nil {
+			fmt.Fprintln(os.Stderr, err)
+			os.Exit(1)
+		}
+		if err := priority.SetIO(p); err != nil {
+			fmt.Fprintf(os.Stderr, "Warning: --ionice: %v\n", err)
+		}
+	}
+}
+
+// baseConfig is the download of url with the flags given
+func baseConfig(url string) downloader.Config {
+	cfg, err := itemConfig(url, false)
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		os.Exit(1)
+	}
+	return cfg
+}
+
+// itemConfig is baseConfig for one item of a queue: a plugin or the
+// browser's cookie store failing fails the item, not the run. Guests'
+// items get none of the configured headers, cookies or signing.
+func itemConfig(url string, guest bool) (downloader.Config, error) {
+	setupPrint()
+	setupProgress()
+	url, err := pluginURL(url)
+	if err != nil {
+		return downloader.Config{}, err
+	}
+
+	inFlight, err := downloader.ParseSize(maxInFlight)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Invalid --max-inflight: %v\n", err)
+		os.Exit(1)
+	}
+
+	buffer, err := downloader.ParseSize(bufSize)
+	if err == nil && (buffer < 4096 || buffer > downloader.MaxBufferSize) {
+		err = fmt.Errorf("%s is not between 4K and 16M", bufSize)
+	}
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Invalid --buffer-size: %v\n", err)
+		os.Exit(1)
+	}
+
+	splitSize, err := downloader.ParseSize(minSplit)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Invalid --min-split-size: %v\n", err)
+		os.Exit(1)
+	}
+
+	// auto only applies to plain downloads, streams and torrents keep 16 workers
+	conns, auto := 16, concurrency == "auto"
+	if !auto {
+		if conns, err = strconv.Atoi(concurrency); err != nil || conns < 1 {
+			fmt.Fprintf(os.Stderr, "Invalid --concurrent %q: want a positive number or auto\n", concurrency)
+			os.Exit(1)
+		}
+	}
+
+	h2, err := downloader.ParseHTTP2Mode(http2Mode)
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		os.Exit(1)
+	}
+
+	dns, err := downloader.ParseDNSMode(dnsMode)
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		os.Exit(1)
+	}
+
+	alloc, err := downloader.ParseFileAllocation(fileAlloc)
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		os.Exit(1)
+	}
+
+	volumes, err := downloader.ParseSplitOutput(splitOutput)
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		os.Exit(1)
+	}
+
+	on, err := downloader.ParseRetryOn(retryOn)
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		os.Exit(1)
+	}
+	if retries < 0 {
+		fmt.Fprintln(os.Stderr, "Invalid --retries: must not be negative")
+		os.Exit(1)
+	}
+	if err := downloader.CheckTLSFingerprint(tlsPrint); err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		os.Exit(1)
+	}
+
+	pins, err := downloader.ParsePins(pinFlags)
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		os.Exit(1)
+	}
+	if awsSigV4 != "" {
+		if _, _, err := downloader.ParseAWSSigV4(awsSigV4); err != nil {
+			fmt.Fprintln(os.Stderr, err)
+			os.Exit(1)
+		}
+	}
+
+	var dialTo map[string]string
+	for _, c := range connectTo {
+		host, addr, err := downloader.ParseConnectTo(c)
+		if err != nil {
+			fmt.Fprintln(os.Stderr, err)
+			os.Exit(1)
+		}
+		if dialTo == nil {
+			dialTo = map[string]string{}
+		}
+		dialTo[host] = addr
+	}
+
+	var links []downloader.Link
+	for _, name := range linkNames {
+		l, err := downloader.ResolveLink(name)
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "Invalid --interface: %v\n", err)
+			os.Exit(1)
+		}
+		links = append(links, l)
+	}
+	if pipeline < 0 {
+		fmt.Fprintln(os.Stderr, "Invalid --pipeline: must not be negative")
+		os.Exit(1)
+	}
+
+	headers, err := requestHeaders(conf.Headers, headerFlags)
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		os.Exit(1)
+	}
+	byHost, err := hostHeaders(conf.Headers, conf.HostHeaders, headerFlags)
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		os.Exit(1)
+	}
+	ua, err := chosenUserAgent()
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		os.Exit(1)
+	}
+	setHeader(headers, byHost, "User-Agent", ua)
+	if referer == "*" {
+		setHeader(headers, byHost, "Referer", url)
+	} else {
+		setHeader(headers, byHost, "Referer", referer)
+	}
+	if err := pluginHeaders(url, headers, byHost); err != nil {
+		return downloader.Config{}, err
+	}
+
+	reqMethod, body, err := requestBody(method, postData)
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		os.Exit(1)
+	}
+
+	if err := downloader.CheckNameTemplate(nameFormat); err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		os.Exit(1)
+	}
+
+	conflict, err := downloader.ParseConflictMode(onConflict)
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		os.Exit(1)
+	}
+
+	if proxyURL != "" {
+		if _, err := downloader.ParseProxy(proxyURL); err != nil {
+			fmt.Fprintln(os.Stderr, err)
+			os.Exit(1)
+		}
+	}
+
+	motwMode := downloader.MOTWAuto
+	switch {
+	case motw:
+		motwMode = downloader.MOTWAlways
+	case noMOTW:
+		motwMode = downloader.MOTWNever
+	}
+
+	var jar []*http.Cookie
+	if !guest {
+		if jar, err = browserCookies(url); err != nil {
+			return downloader.Config{}, err
+		}
+	}
+
+	cfg := withTor(downloader.Config{
+		URL:             url,
+		Concurrency:     conns,
+		AutoConcurrency: auto,
+		OutputName:      output,
+		Dir:             downloader.ExpandHome(outDir),
+		NameTemplate:    nameFormat,
+		OnConflict:      conflict,
+		NewerOnly:       newerOnly,
+		DropPartial:     dropPartial,
+		Compressed:      compressed,
+		DNS:             dns,
+		DoHServers:      dohServers,
+		Quality:         quality,
+		Track:           track,
+		Platform:        platform,
+		SSHKey:          sshKey,
+		MaxInFlight:     inFlight,
+		BufferSize:      buffer,
+		MinSplitSize:    splitSize,
+		MOTW:            motwMode,
+		HTTP2:           h2,
+		SplitOutput:     volumes,
+		Proxy:           proxyURL,
+		PAC:             pacScript,
+		WPAD:            wpad,
+		Fsync:           fsync,
+		DropCache:       dropCache,
+		Allocation:      alloc,
+		Paranoid:        paranoid,
+		Pipeline:        pipeline,
+		Links:           links,
+		ConnectTo:       dialTo,
+		SNI:             sniName,
+		TLSFingerprint:  tlsPrint,
+		PinSHA256:       pins,
+		AWSSigV4:        awsSigV4,
+		Method:          reqMethod,
+		Body:            body,
+		Headers:         headers,
+		HostHeaders:     byHost,
+		Cookies:         jar,
+		StallTimeout:    stallAfter,
+		Hosts:           sharedHostLimits(),
+		ThrottleHost:    holdHost,
+		RefreshURL:      urlRefresher(),
+		OnDNSFallback:   warnDNSFallback,
+		Retry:           &downloader.RetryPolicy{Retries: retries, Wait: retryWait, MaxWait: retryMax, On: on},
+		Logger:          setupLogging(),
+		WireTrace:       wireTrace,
+
+		Follow:         follow,
+		FollowInterval: followEvery,
+	})
+	if guest {
+		cfg = cfg.WithoutCredentials()
+	}
+	return cfg, nil
+}
+
+var dnsWarned sync.Once
+
+// warnDNSFallback tells once per run that --dns auto gave up on DoH, the
+// system resolver's answers may be the ones an ISP filters
+func warnDNSFallback(host string, err error) {
+	dnsWarned.Do(func() {
+		fmt.Fprintf(os.Stderr, "Warning: DoH lookup of %s failed, using the system resolver: %v\n", host, err)
+	})
+}
+
+var (
+	hostLimits     *downloader.HostLimits
+	hostLimitsOnce sync.Once
+)
+
+// sharedHostLimits is the per host limits of every download of the run, the
+// daemon's included, nil without any
+func sharedHostLimits() *downloader.HostLimits {
+	hostLimitsOnce.Do(func() {
+		if hostConns > 0 || hostDelay > 0 {
+			hostLimits = downloader.NewHostLimits(hostConns, hostDelay)
+		}
+	})
+	return hostLimits
+}
+
+// requestHeaders layers the --header flags over the config file's headers
+func requestHeaders(defaults map[string]string, flags []string) (http.Header, error) {
+	h := http.Header{}
+	for name, value := range defaults {
+		if value != "" {
+			h.Set(name, value)
+		}
+	}
+	given := map[string]bool{}
+	for _, f := range flags {
+		name, value, err := downloader.ParseHeader(f)
+		if err != nil {
+			return nil, err
+		}
+		if !given[name] {
+			// The first flag for a name replaces the default
+			given[name] = true
+			h.Del(name)
+		}
+		if value != "" {
+			h.Add(name, value)
+		}
+	}
+	return h, nil
+}
+
+// requestBody resolves --method and --data the way curl does: a body makes
+// the request a POST unless a method is given
+func requestBody(method, data string) (string, []byte, error) {
+	method = strings.ToUpper(method)
+	if data == "" {
+		return method, nil, nil
+	}
+	if method == "" {
+		method = http.MethodPost
+	}
+	var body []byte
+	var err error
+	switch {
+	case data == "@-":
+		body, err = io.ReadAll(os.Stdin)
+	case strings.HasPrefix(data, "@"):
+		body, err = os.ReadFile(data[1:])
+	default:
+		body = []byte(data)
+	}
+	if err != nil {
+		return "", nil, fmt.Errorf("--data: %w", err)
+	}
+	return method, body, nil
+}
+
+// newTask picks the fetcher for the URL
+func newTask(cfg downloader.Config) downloader.Task {
+	switch {
+	case torrent.IsTorrent(cfg.URL):
+		return torrent.NewDownloader(torrent.Config{
+			Source:     cfg.URL,
+			OutputName: cfg.OutputName,
+			Dir:        cfg.Dir,
+			MaxPeers:   cfg.Concurrency,
+			Sequential: sequential,
+			SeedRatio:  seedRatio,
+			Port:       torrentPort,
+		}, downloader.NewClient(cfg))
+	case downloader.IsHLS(cfg.URL):
+		return downloader.NewHLSDownloader(cfg)
+	case downloader.IsDASH(cfg.URL):
+		return downloader.NewDASHDownloader(cfg)
+	case downloader.IsOCI(cfg.URL):
+		return downloader.NewOCIDownloader(cfg)
+	case cfg.Zsync != nil:
+		return downloader.NewZsyncDownloader(cfg)
+	}
+	return downloader.NewEngine(cfg)
+}
+
+func runMetalink(src string) {
+	cfg := baseConfig("")
+	files, err := downloader.LoadMetalink(context.Background(), downloader.NewClient(cfg), src)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Failed to load metalink: %v\n", err)
+		os.Exit(1)
+	}
+
+	if len(files) > 1 && output != "" {
+		fmt.Fprintln(os.Stderr, "--output cannot be used with a multi-file metalink")
+		os.Exit(1)
+	}
+	if len(files) > 1 && signature != "" {
+		fmt.Fprintln(os.Stderr, "--signature cannot be used with a multi-file metalink")
+		os.Exit(1)
+	}
+
+	for _, f := range files {
+		runDownload(f.Config(cfg))
+	}
+}
+
+// manifestLookupTimeout bounds fetching the checksum manifests and their
+// signatures, a queue item doesn't start until they are in
+const manifestLookupTimeout = time.Minute
+
+// withTrustedChecksum looks the URL up in the configured checksum
+// manifests. Trusted manifests win over whatever mirror serves the file.
+func withTrustedChecksum(cfg downloader.Config) (downloader.Config, error) {
+	if cfg.Checksum != nil || len(conf.ChecksumManifests) == 0 {
+		return cfg, nil
+	}
+	// Whatever the download does, the manifests are only as trustworthy
+	// as the connection they come over
+	verified := cfg
+	verified.VerifyTLS = true
+	ctx, cancel := context.WithTimeout(context.Background(), manifestLookupTimeout)
+	defer cancel()
+	sum, err := downloader.LookupTrustedChecksum(ctx, downloader.NewClient(verified), conf.ChecksumManifests, cfg.URL)
+	if err != nil {
+		return cfg, fmt.Errorf("checksum database: %w", err)
+	}
+	cfg.Checksum = sum
+	return cfg, nil
+}
+
+// withAutoChecksum looks for --auto-checksum's checksum files next to the
+// URL when nothing else gave the digest
+func withAutoChecksum(cfg downloader.Config) downloader.Config {
+	if !autoSum || cfg.Checksum != nil || torrent.IsTorrent(cfg.URL) || downloader.IsHLS(cfg.URL) || downloader.IsDASH(cfg.URL) || downloader.IsOCI(cfg.URL) {
+		return cfg
+	}
+	sum, from, err := downloader.FindSidecarChecksum(context.Background(), downloader.NewClient(cfg), cfg.URL)
+	switch {
+	case err != nil:
+		fmt.Fprintf(os.Stderr, "Warning: --auto-checksum: %v\n", err)
+	case sum == nil:
+		fmt.Fprintln(os.Stderr, "Warning: --auto-checksum: no checksum file next to the URL lists it, not verifying")
+	default:
+		fmt.Fprintf(msgOut, "Verifying against %s from %s\n", sum.Algo, from)
+		cfg.Checksum = sum
+	}
+	return cfg
+}
+
+// runURL downloads a URL, magnet, torrent, metalink or release asset with
+// the flags given
+func runURL(url string) {
+	if downloader.IsMetalink(url) {
+		runMetalink(url)
+		return
+	}
+	if downloader.IsRelease(url) {
+		runRelease(url)
+		return
+	}
+	runDownload(baseConfig(url))
+}
+
+func runDownload(cfg downloader.Config) {
+	waitSchedule()
+	cfg, err := withTrustedChecksum(cfg)
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		os.Exit(1)
+	}
+	cfg = withAutoChecksum(cfg)
+	cfg = withLANPeers(cfg)
+	cfg = withAutoMirrors(cfg)
+	if output == "-" {
+		if torrent.IsTorrent(cfg.URL) || downloader.IsHLS(cfg.URL) || downloader.IsDASH(cfg.URL) || downloader.IsOCI(cfg.URL) {
+			fmt.Fprintln(os.Stderr, "-o - only streams plain downloads, not torrents, HLS/DASH playlists or OCI images")
+			os.Exit(1)
+		}
+		cfg.OutputName, cfg.Stream = "", os.Stdout
+	}
+	if cfg, err = withSignature(cfg); err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		os.Exit(1)
+	}
+	if cfg, err = withZsync(cfg); err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		os.Exit(1)
+	}
+	if unpack && (cfg.Stream != nil || torrent.IsTorrent(cfg.URL)) {
+		fmt.Fprintln(os.Stderr, "--extract needs a single downloaded file, not -o - or a torrent")
+		os.Exit(1)
+	}
+
+	if err := downloader.ProxyError(cfg); err != nil {
+		fmt.Fprintf(os.Stderr, "Warning: no proxy auto-config, using the environment's proxy settings: %v\n", err)
+	}
+
+	cfg.OnDiskFull = promptDiskFull
+	cfg = withDedupe(cfg)
+	noteDownloadedBefore(cfg.URL)
+	if err := pluginPreDownload(cfg); err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		os.Exit(1)
+	}
+	startRecording(&cfg)
+	task := newTask(cfg)
+	started := time.Now()
+	err = runTask(task, ui.NewModel)
+	saveRecording(err)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Download failed: %v\n", err)
+		sendNotification(task, cfg, err)
+		if err := runHook(task, cfg, err); err != nil {
+			fmt.Fprintln(os.Stderr, err)
+		}
+		os.Exit(1)
+	}
+	if e, ok := task.(*downloader.Engine); ok && e.Skipped != "" {
+		fmt.Fprintf(msgOut, "Skipped, %s\n", e.Skipped)
+	}
+	if e, ok := task.(*downloader.Engine); ok && e.Signer != "" {
+		fmt.Fprintf(msgOut, "Good signature from %s\n", e.Signer)
+	}
+	if z, ok := task.(*downloader.ZsyncDownloader); ok && z.Config.Zsync.Length > 0 {
+		fmt.Fprintf(msgOut, "Reused %.0f%% of the existing file, fetched %.2f MB\n", float64(z.Reused)*100/float64(z.Config.Zsync.Length), float64(z.Fetched)/1024/1024)
+	}
+	if e, ok := task.(*downloader.Engine); ok && len(e.Volumes) > 0 {
+		fmt.Fprintf(msgOut, "Saved in %d volumes, join them with: cat %s.* > %s\n", len(e.Volumes), e.Config.OutputName, e.Config.OutputName)
+	}
+	var archive string
+	if e, ok := task.(*downloader.Engine); unpack && !(ok && e.Skipped != "") {
+		if archive, err = extractDownload(task, cfg); err != nil {
+			fmt.Fprintln(os.Stderr, err)
+			sendNotification(task, cfg, err)
+			os.Exit(1)
+		}
+	}
+	sendNotification(task, cfg, nil)
+	recordHistory(task, cfg, started)
+	if err := pluginPostDownload(task, cfg); err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		os.Exit(1)
+	}
+	if err := runHook(task, cfg, nil); err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		os.Exit(1)
+	}
+	if printField != "" {
+		if err := printResult(task, cfg); err != nil {
+			fmt.Fprintf(os.Stderr, "--print %s: %v\n", printField, err)
+			os.Exit(1)
+		}
+	}
+	if archive != "" && rmArchive {
+		// Last, the hook and --print still describe the archive
+		removeArchive(archive)
+	}
+}
+
+// program is the progress UI of the running task
+var program *tea.Program
+
+// promptDiskFull pauses the download on a prompt in the progress UI until
+// the user frees space and resumes, or quits
+func promptDiskFull(ctx context.Context, dir string, err error) error {
+	if program == nil {
+		// Nobody to ask without the interactive UI
+		return err
 	}
+	reply := make(chan bool, 1)
+	program.Send(ui.DiskFullMsg{Dir: dir, Reply: reply})
+	select {
+	case resume := <-reply:
+		if !resume {
+			return err
+		}
+		return nil
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
 
-	engine := downloader.NewEngine(cfg)
-	
+// runTask drives task in the background while the progress UI runs.
+// Interrupting the UI cancels the task and exits the process.
+func runTask(task downloader.Task, newModel func(*downloader.Stats) ui.Model) error {
+	setupProgress() // Already done by baseConfig for downloads, not for checksum
 	// Create context that can be canceled
 	ctx, cancel := context.WithCancel(context.Background())
 	defer cancel()
 
+	stopTaskbar := func() {}
+	if taskbarBar {
+		stopTaskbar = showTaskbarProgress(task.Progress())
+	}
+	defer stopTaskbar()
+
+	if progressFmt == "json" || plainProgress() {
+		program = nil
+		var (
+			interrupted bool
+			err         error
+		)
+		if progressFmt == "json" {
+			interrupted, err = runJSON(task)
+		} else {
+			interrupted, err = runPlain(task, newModel)
+		}
+		if interrupted {
+			exitInterrupted(task, stopTaskbar, err)
+		}
+		return err
+	}
+
 	// Initialise UI model
-	model := ui.NewModel(engine.Stats)
-	p := tea.NewProgram(model)
+	model := newModel(task.Progress())
+	if in, ok := task.(downloader.Inspector); ok {
+		model = model.WithSegments(in)
+	}
+	p := tea.NewProgram(model, tea.WithOutput(msgOut))
+	program = p
 
-	// Run download in background
+	// Run task in background
+	done := make(chan error, 1)
 	go func() {
-		if err := engine.Start(ctx); err != nil {
-			fmt.Printf("Download failed: %v\n", err)
-			p.Quit()
-			return
-		}
+		done <- task.Start(ctx)
+		p.Quit()
 	}()
 
 	// Run UI
-	// If user presses Ctrl+C, p.Run() returns, 
-	// defer cancel() is called, stopping the engine.
-	if _, err := p.Run(); err != nil {
+	// If user presses Ctrl+C, p.Run() returns,
+	// the task is canceled before exiting.
+	holdLogs()
+	final, err := p.Run()
+	releaseLogs()
+	if err != nil {
 		fmt.Printf("Alas, there's been an error: %v", err)
 		os.Exit(1)
 	}
+	if m, ok := final.(ui.Model); ok && m.Interrupted() {
+		cancel()
+		exitInterrupted(task, stopTaskbar, <-done)
+	}
+
+	return <-done
+}
+
+// exitInterrupted ends the process after Ctrl+C stopped the task, telling
+// how to carry on
+func exitInterrupted(task downloader.Task, stopTaskbar func(), err error) {
+	stopTaskbar()
+	saveRecording(err)
+	if e, ok := task.(*downloader.Engine); ok {
+		if state := e.ResumeState(); state != "" {
+			fmt.Fprintf(msgOut, "Interrupted, run the same command again to resume from %s\n", state)
+		} else if dropPartial {
+			fmt.Fprintln(msgOut, "Interrupted, partial files removed")
+		}
+	}
+	os.Exit(130)
 }
@@ -0,0 +1,176 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"net/url"
+	"os"
+	"path"
+	"path/filepath"
+	"strings"
+
+	"github.com/spf13/cobra"
+	"warp-dl/internal/downloader"
+)
+
+var (
+	mirrorInclude []string
+	mirrorExclude []string
+	mirrorDepth   int
+	mirrorDryRun  bool
+)
+
+var mirrorCmd = &cobra.Command{
+	Use:   "mirror <url>",
+	Short: "Download the files of an HTML directory index and its subdirectories",
+	Long: "Walk an nginx or Apache style directory index and download the files in\n" +
+		"it and its subdirectories one after another, to the same paths below --dir.\n" +
+		"Globs are matched against a file's name and its path below the URL; an\n" +
+		"excluded directory isn't walked. With --newer-only or --on-conflict skip a\n" +
+		"second run only fetches what changed.",
+	Example: "  warp-dl mirror https://example.com/pub/isos/ --include '*.iso' --dir isos\n" +
+		"  warp-dl mirror https://example.com/data/ --exclude 'old/' --depth 2 --dry-run",
+	Args: cobra.ExactArgs(1),
+	Run: func(cmd *cobra.Command, args []string) {
+		files, err := mirrorFiles(context.Background(), args[0])
+		if err != nil {
+			fmt.Fprintln(os.Stderr, err)
+			os.Exit(1)
+		}
+		if len(files) == 0 {
+			fmt.Fprintln(os.Stderr, "No files matched")
+			return
+		}
+		if mirrorDryRun {
+			for _, f := range files {
+				fmt.Println(f.rel)
+			}
+			return
+		}
+
+		root := downloader.ExpandHome(outDir)
+		defer func(was string) { output = was }(output)
+		for i, f := range files {
+			fmt.Fprintf(msgOut, "(%d/%d) %s\n", i+1, len(files), f.rel)
+			output = filepath.Join(root, filepath.FromSlash(f.rel))
+			if err := os.MkdirAll(filepath.Dir(output), 0o755); err != nil {
+				fmt.Fprintln(os.Stderr, err)
+				os.Exit(1)
+			}
+			runDownload(baseConfig(f.url))
+		}
+	},
+}
+
+// mirrorFile is a file to fetch and its path below the mirrored URL
+type mirrorFile struct {
+	url string
+	rel string
+}
+
+// mirrorFiles walks the index breadth first, --depth levels of
+// subdirectories deep, and returns the files the globs let through
+func mirrorFiles(ctx context.Context, start string) ([]mirrorFile, error) {
+	root, err := url.Parse(start)
+	if err != nil {
+		return nil, err
+	}
+	if !strings.HasSuffix(root.Path, "/") {
+		root.Path += "/"
+	}
+	client := downloader.NewClient(baseConfig(""))
+
+	type dir struct {
+		url   string
+		depth int
+	}
+	queue := []dir{{root.String(), 0}}
+	seen := map[string]bool{root.String(): true}
+	var files []mirrorFile
+	for len(queue) > 0 {
+		d := queue[0]
+		queue = queue[1:]
+		entries, err := downloader.ListDirectory(ctx, client, d.url)
+		if err != nil {
+			if d.depth == 0 {
+				return nil, err
+			}
+			fmt.Fprintf(os.Stderr, "Warning: skipping %s: %v\n", d.url, err)
+			continue
+		}
+		for _, e := range entries {
+			if seen[e.URL] {
+				continue
+			}
+			seen[e.URL] = true
+			rel, ok := relativePath(root, e.URL)
+			if !ok || mirrorExcluded(rel) {
+				continue
+			}
+			if e.IsDir {
+				if mirrorDepth == 0 || d.depth < mirrorDepth {
+					queue = append(queue, dir{e.URL, d.depth + 1})
+				}
+				continue
+			}
+			if mirrorIncluded(rel) {
+				files = append(files, mirrorFile{url: e.URL, rel: rel})
+			}
+		}
+	}
+	return files, nil
+}
+
+// relativePath is a link's unescaped path below the mirrored URL, false
+// when a segment isn't a usable file name
+func relativePath(root *url.URL, link string) (string, bool) {
+	u, err := url.Parse(link)
+	if err != nil || !strings.HasPrefix(u.Path, root.Path) {
+		return "", false
+	}
+	var segs []string
+	for _, s := range strings.Split(strings.Trim(strings.TrimPrefix(u.Path, root.Path), "/"), "/") {
+		if s == "" || s == "." || s == ".." || strings.Contains(s, "\\") {
+			return "", false
+		}
+		segs = append(segs, s)
+	}
+	rel := strings.Join(segs, "/")
+	if strings.HasSuffix(u.Path, "/") {
+		rel += "/"
+	}
+	return rel, rel != ""
+}
+
+// globMatch matches a glob against the name and the whole path below the
+// mirrored URL. Directories have a trailing slash, "old/" excludes one.
+func globMatch(globs []string, rel string) bool {
+	name := path.Base(rel)
+	if strings.HasSuffix(rel, "/") {
+		name += "/"
+	}
+	for _, g := range globs {
+		for _, s := range []string{name, rel} {
+			if ok, _ := path.Match(g, s); ok {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+func mirrorIncluded(rel string) bool {
+	return len(mirrorInclude) == 0 || globMatch(mirrorInclude, rel)
+}
+
+func mirrorExcluded(rel string) bool {
+	return globMatch(mirrorExclude, rel)
+}
+
+func init() {
+	mirrorCmd.Flags().StringArrayVar(&mirrorInclude, "include", nil, "Only download files matching this glob, e.g. '*.iso', repeatable")
+	mirrorCmd.Flags().StringArrayVar(&mirrorExclude, "exclude", nil, "Skip files and directories matching this glob, e.g. '*.txt' or 'old/', repeatable")
+	mirrorCmd.Flags().IntVar(&mirrorDepth, "depth", 5, "Levels of subdirectories to walk, 0 for no limit")
+	mirrorCmd.Flags().BoolVar(&mirrorDryRun, "dry-run", false, "List the files that would be downloaded and stop")
+	rootCmd.AddCommand(mirrorCmd)
+}
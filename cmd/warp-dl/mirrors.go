@@ -0,0 +1,47 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"os"
+	"time"
+
+	"warp-dl/internal/downloader"
+	"warp-dl/internal/mirrors"
+)
+
+// mirrorLookupTimeout bounds fetching the mirror list, probing has its own
+const mirrorLookupTimeout = 10 * time.Second
+
+// withAutoMirrors adds the fastest official mirrors of the URL's site to
+// the download. Mirrors are only a speedup, so failing to find or reach
+// them leaves the download as it was.
+func withAutoMirrors(cfg downloader.Config) downloader.Config {
+	if autoMirrors <= 0 || (cfg.Method != "" && cfg.Method != http.MethodGet) {
+		return cfg
+	}
+	client := downloader.NewClient(cfg)
+	providers := append([]mirrors.Provider{mirrors.Static(conf.Mirrors)}, mirrors.Builtin...)
+
+	ctx, cancel := context.WithTimeout(context.Background(), mirrorLookupTimeout)
+	defer cancel()
+	candidates, err := mirrors.Find(ctx, client, providers, cfg.URL)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Warning: --auto-mirrors: %v\n", err)
+	}
+	if len(candidates) == 0 {
+		return cfg
+	}
+
+	fastest := mirrors.Fastest(context.Background(), client, cfg.URL, candidates, autoMirrors)
+	if len(fastest) == 0 {
+		fmt.Fprintf(os.Stderr, "Warning: --auto-mirrors: none of %d mirrors serve this file\n", len(candidates))
+		return cfg
+	}
+	for _, p := range fastest {
+		fmt.Fprintf(msgOut, "Mirror %s (%.2f MB/s)\n", p.URL, p.Speed/1024/1024)
+		cfg.Mirrors = append(cfg.Mirrors, p.URL)
+	}
+	return cfg
+}
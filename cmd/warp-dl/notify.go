@@ -0,0 +1,33 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+
+	"warp-dl/internal/downloader"
+	"warp-dl/internal/notify"
+)
+
+// sendNotification tells the desktop a --notify download finished, or
+// failed when failure is set. Without a desktop session it only warns.
+func sendNotification(task downloader.Task, cfg downloader.Config, failure error) {
+	if !notifyDone {
+		return
+	}
+	if c, ok := taskConfig(task); ok {
+		cfg = c
+	}
+	name := filepath.Base(cfg.OutputName)
+	if cfg.OutputName == "" || cfg.Stream != nil {
+		name = redactURL(cfg.URL)
+	}
+
+	title, body := "Download complete", fmt.Sprintf("%s, %.2f MB", name, float64(task.Progress().GetDownloaded())/1024/1024)
+	if failure != nil {
+		title, body = "Download failed", fmt.Sprintf("%s: %v", name, failure)
+	}
+	if err := notify.Send(title, body); err != nil {
+		fmt.Fprintf(os.Stderr, "Warning: --notify: %v\n", err)
+	}
+}
@@ -0,0 +1,70 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"net/url"
+	"os"
+	"path"
+	"strings"
+
+	"warp-dl/internal/downloader"
+)
+
+var (
+	fromPage    string
+	acceptGlobs []string
+)
+
+// Links to these are pages rather than files, skipped without --accept
+var pageExts = map[string]bool{"": true, ".html": true, ".htm": true, ".php": true, ".asp": true, ".aspx": true, ".jsp": true}
+
+// runFromPage downloads the links of --from-page that --accept lets
+// through, one after another like an --input-file
+func runFromPage(page string) {
+	links, err := downloader.PageLinks(context.Background(), downloader.NewClient(baseConfig(page)), page)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Failed to read %s: %v\n", page, err)
+		os.Exit(1)
+	}
+	var files []string
+	for _, l := range links {
+		if acceptLink(l) {
+			files = append(files, l)
+		}
+	}
+	switch {
+	case len(files) == 0:
+		fmt.Fprintf(os.Stderr, "No links on %s matched\n", page)
+		os.Exit(1)
+	case len(files) > 1 && output != "":
+		fmt.Fprintln(os.Stderr, "--output cannot be used with several links, use --dir")
+		os.Exit(1)
+	case len(files) > 1 && signature != "":
+		fmt.Fprintln(os.Stderr, "--signature cannot be used with several links")
+		os.Exit(1)
+	}
+	for i, f := range files {
+		fmt.Fprintf(msgOut, "(%d/%d) %s\n", i+1, len(files), f)
+		runURL(f)
+	}
+}
+
+// acceptLink matches the link's file name against --accept, ignoring case.
+// Without it every link that isn't to another page is taken.
+func acceptLink(link string) bool {
+	u, err := url.Parse(link)
+	if err != nil || strings.HasSuffix(u.Path, "/") {
+		return false
+	}
+	name := strings.ToLower(path.Base(u.Path))
+	if len(acceptGlobs) == 0 {
+		return !pageExts[path.Ext(name)]
+	}
+	for _, g := range acceptGlobs {
+		if ok, _ := path.Match(strings.ToLower(g), name); ok {
+			return true
+		}
+	}
+	return false
+}
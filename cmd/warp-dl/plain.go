@@ -0,0 +1,61 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"os/signal"
+	"time"
+
+	"golang.org/x/term"
+	"warp-dl/internal/downloader"
+	"warp-dl/internal/ui"
+)
+
+// plainInterval is how often plain mode logs a progress line
+const plainInterval = 5 * time.Second
+
+// plainProgress reports whether to skip the interactive UI: when asked to,
+// or when its output isn't a terminal, e.g. in cron jobs, CI or a pipe
+func plainProgress() bool {
+	switch progressFmt {
+	case "plain", "none":
+		return true
+	case "tui":
+		return false
+	}
+	f, ok := msgOut.(*os.File)
+	return !ok || !term.IsTerminal(int(f.Fd()))
+}
+
+// runPlain runs the task without the interactive UI, logging a progress
+// line now and then unless --progress none. It reports whether Ctrl+C stopped it.
+func runPlain(task downloader.Task, newModel func(*downloader.Stats) ui.Model) (interrupted bool, err error) {
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt)
+	defer stop()
+
+	model := newModel(task.Progress())
+	done := make(chan error, 1)
+	go func() {
+		done <- task.Start(ctx)
+	}()
+
+	t := time.NewTicker(plainInterval)
+	defer t.Stop()
+	for {
+		select {
+		case err := <-done:
+			if ctx.Err() != nil {
+				return true, err
+			}
+			if progressFmt != "none" {
+				fmt.Fprintln(msgOut, model.Line(time.Now()))
+			}
+			return false, err
+		case <-t.C:
+			if progressFmt != "none" {
+				fmt.Fprintln(msgOut, model.Line(time.Now()))
+			}
+		}
+	}
+}
@@ -0,0 +1,88 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"os"
+
+	"warp-dl/internal/downloader"
+	"warp-dl/internal/plugin"
+)
+
+var pluginPaths []string
+
+// plugins are the config file's plugins followed by the --plugin ones
+func plugins() plugin.Chain {
+	var chain plugin.Chain
+	for _, path := range append(append([]string{}, conf.Plugins...), pluginPaths...) {
+		chain = append(chain, plugin.Plugin{Path: downloader.ExpandHome(path)})
+	}
+	return chain
+}
+
+// pluginURL runs the url-rewrite hook, before anything depends on the URL
+func pluginURL(url string) (string, error) {
+	chain := plugins()
+	if len(chain) == 0 || url == "" {
+		return url, nil
+	}
+	return chain.RewriteURL(context.Background(), url)
+}
+
+// pluginHeaders runs the header-injection hook, its headers go over the
+// config file's and the flags'
+func pluginHeaders(url string, headers http.Header, byHost map[string]http.Header) error {
+	chain := plugins()
+	if len(chain) == 0 || url == "" {
+		return nil
+	}
+	added, err := chain.Headers(context.Background(), url)
+	if err != nil {
+		return err
+	}
+	for name, value := range added {
+		setHeader(headers, byHost, name, value)
+	}
+	return nil
+}
+
+// pluginPreDownload runs the pre-download hook, a refusal is an error
+func pluginPreDownload(cfg downloader.Config) error {
+	chain := plugins()
+	if len(chain) == 0 {
+		return nil
+	}
+	return chain.PreDownload(context.Background(), cfg.URL, cfg.OutputName)
+}
+
+// pluginPostDownload runs the post-download hook with what --on-complete
+// gets to know
+func pluginPostDownload(task downloader.Task, cfg downloader.Config) error {
+	chain := plugins()
+	if len(chain) == 0 {
+		return nil
+	}
+	r, err := taskResult(task, cfg, false)
+	req := plugin.Request{URL: r.URL, Size: r.Size}
+	if err == nil && cfg.Stream == nil {
+		req.Output = r.Path
+		files := r.Volumes
+		if len(files) == 0 {
+			files = []string{r.Path}
+		}
+		// Directories from torrents have no single digest
+		if digest, err := downloader.HashFiles("sha256", files...); err == nil {
+			req.SHA256 = digest
+		}
+	}
+	return chain.PostDownload(context.Background(), req)
+}
+
+// pluginDone runs the post-download hook for a task of the daemon or the
+// clipboard watcher, which completed whatever the hook says
+func pluginDone(task downloader.Task) {
+	if err := pluginPostDownload(task, downloader.Config{}); err != nil {
+		fmt.Fprintf(os.Stderr, "Warning: %v\n", err)
+	}
+}
@@ -0,0 +1,120 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"sort"
+	"strings"
+
+	"github.com/spf13/cobra"
+	"github.com/spf13/pflag"
+	"warp-dl/internal/config"
+)
+
+var presetCmd = &cobra.Command{
+	Use:   "preset",
+	Short: "Manage named flag combinations for warp-dl get --preset",
+}
+
+var presetSaveCmd = &cobra.Command{
+	Use:   "save <name> [download flags]",
+	Short: "Save download flags under a name",
+	Example: "  warp-dl preset save fast-iso --concurrent 32 --http2 off\n" +
+		"  warp-dl get --preset fast-iso https://example.com/distro.iso",
+	// The flags belong to the preset, they are validated against get's below
+	DisableFlagParsing: true,
+	Run: func(cmd *cobra.Command, args []string) {
+		for _, a := range args {
+			if a == "-h" || a == "--help" {
+				cmd.Help()
+				return
+			}
+		}
+
+		// Parse with get's flags so typos and bad values fail now, and
+		// keep the raw values in the order given
+		fs := pflag.NewFlagSet("preset save", pflag.ContinueOnError)
+		fs.AddFlagSet(getCmd.LocalNonPersistentFlags())
+		fs.AddFlagSet(rootCmd.PersistentFlags())
+		p := config.Preset{}
+		err := fs.ParseAll(args, func(f *pflag.Flag, value string) error {
+			if err := fs.Set(f.Name, value); err != nil {
+				return err
+			}
+			switch f.Name {
+			case "config", "preset":
+			default:
+				p[f.Name] = append(p[f.Name], value)
+			}
+			return nil
+		})
+		if err == nil && fs.NArg() != 1 {
+			err = fmt.Errorf("usage: warp-dl preset save <name> [download flags]")
+		}
+		if err != nil {
+			fmt.Fprintln(os.Stderr, err)
+			os.Exit(1)
+		}
+		name := fs.Arg(0)
+		if len(p) == 0 {
+			fmt.Fprintln(os.Stderr, "Nothing to save, pass the flags the preset should set")
+			os.Exit(1)
+		}
+
+		if err := config.SavePreset(configPath, name, p); err != nil {
+			fmt.Fprintf(os.Stderr, "Failed to save preset: %v\n", err)
+			os.Exit(1)
+		}
+		fmt.Printf("Saved preset %s: %s\n", name, presetArgs(p))
+	},
+}
+
+var presetListCmd = &cobra.Command{
+	Use:   "list",
+	Short: "List saved presets",
+	Args:  cobra.NoArgs,
+	Run: func(cmd *cobra.Command, args []string) {
+		names := make([]string, 0, len(conf.Presets))
+		for name := range conf.Presets {
+			names = append(names, name)
+		}
+		sort.Strings(names)
+		for _, name := range names {
+			fmt.Printf("%s\t%s\n", name, presetArgs(conf.Presets[name]))
+		}
+	},
+}
+
+var presetDeleteCmd = &cobra.Command{
+	Use:   "delete <name>",
+	Short: "Delete a saved preset",
+	Args:  cobra.ExactArgs(1),
+	Run: func(cmd *cobra.Command, args []string) {
+		if err := config.DeletePreset(configPath, args[0]); err != nil {
+			fmt.Fprintln(os.Stderr, err)
+			os.Exit(1)
+		}
+	},
+}
+
+// presetArgs renders a preset as command line flags
+func presetArgs(p config.Preset) string {
+	flags := make([]string, 0, len(p))
+	for flag := range p {
+		flags = append(flags, flag)
+	}
+	sort.Strings(flags)
+
+	var out []string
+	for _, flag := range flags {
+		for _, v := range p[flag] {
+			out = append(out, "--"+flag+"="+v)
+		}
+	}
+	return strings.Join(out, " ")
+}
+
+func init() {
+	presetCmd.AddCommand(presetSaveCmd, presetListCmd, presetDeleteCmd)
+	rootCmd.AddCommand(presetCmd)
+}
@@ -0,0 +1,164 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/url"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"warp-dl/internal/downloader"
+	"warp-dl/internal/torrent"
+)
+
+// printFields are the values --print can pick
+var printFields = []string{"path", "url", "size", "hash", "json"}
+
+// msgOut gets the status messages of a download. With --print stdout only
+// carries the chosen value and with -o - the file, so they move to stderr.
+var msgOut io.Writer = os.Stdout
+
+// result is what a finished download reports to scripts
+type result struct {
+	Path    string   `json:"path"`
+	Volumes []string `json:"volumes,omitempty"`
+	URL     string   `json:"url"`
+	Size    int64    `json:"size"`
+	Hash    string   `json:"hash,omitempty"` // algo:hex, empty for directories
+}
+
+// setupPrint checks --print and moves status output out of its way, or
+// out of the way of the file streamed to stdout
+func setupPrint() {
+	if output == "-" {
+		if printField != "" {
+			fmt.Fprintln(os.Stderr, "--print and -o - both want stdout")
+			os.Exit(1)
+		}
+		msgOut = os.Stderr
+		return
+	}
+	if printField == "" {
+		return
+	}
+	for _, f := range printFields {
+		if f == printField {
+			msgOut = os.Stderr
+			return
+		}
+	}
+	fmt.Fprintf(os.Stderr, "Invalid --print %q: want one of %s\n", printField, strings.Join(printFields, ", "))
+	os.Exit(1)
+}
+
+// printResult writes the --print value of a finished task to stdout
+func printResult(task downloader.Task, cfg downloader.Config) error {
+	r, err := taskResult(task, cfg, printField == "hash" || printField == "json")
+	if err != nil {
+		return err
+	}
+	switch printField {
+	case "path":
+		fmt.Println(r.Path)
+	case "url":
+		fmt.Println(r.URL)
+	case "size":
+		fmt.Println(r.Size)
+	case "hash":
+		if r.Hash == "" {
+			return fmt.Errorf("%s is a directory, it has no single hash", r.Path)
+		}
+		_, digest, _ := strings.Cut(r.Hash, ":")
+		fmt.Println(digest)
+	case "json":
+		return json.NewEncoder(os.Stdout).Encode(r)
+	}
+	return nil
+}
+
+// taskConfig returns the configuration of downloads, false for other tasks
+// such as verifying local files
+func taskConfig(task downloader.Task) (downloader.Config, bool) {
+	switch t := task.(type) {
+	case *downloader.Engine:
+		return t.Config, true
+	case *downloader.HLSDownloader:
+		return t.Config, true
+	case *downloader.DASHDownloader:
+		return t.Config, true
+	case *downloader.ZsyncDownloader:
+		return t.Config, true
+	case *downloader.OCIDownloader:
+		return t.Config, true
+	case *torrent.Downloader:
+		return downloader.Config{URL: t.Config.Source, OutputName: t.Config.OutputName}, true
+	}
+	return downloader.Config{}, false
+}
+
+func taskResult(task downloader.Task, cfg downloader.Config, withHash bool) (result, error) {
+	if c, ok := taskConfig(task); ok {
+		// Names and checksums settled while downloading
+		cfg = c
+	}
+	r := result{Path: cfg.OutputName, URL: redactURL(cfg.URL)}
+	sum := cfg.Checksum
+	if e, ok := task.(*downloader.Engine); ok {
+		r.Volumes = e.Volumes
+		if e.FinalURL != "" {
+			r.URL = e.FinalURL
+		}
+	}
+	if abs, err := filepath.Abs(r.Path); err == nil {
+		r.Path = abs
+	}
+
+	files := r.Volumes
+	if len(files) == 0 {
+		files = []string{r.Path}
+	}
+	dir := false
+	for _, f := range files {
+		fi, err := os.Stat(f)
+		if err != nil {
+			return r, err
+		}
+		dir = dir || fi.IsDir()
+		r.Size += fi.Size()
+	}
+	if dir {
+		// A multi-file torrent
+		r.Size = task.Progress().GetTotal()
+		return r, nil
+	}
+
+	if withHash {
+		if sum != nil {
+			// Already verified against it
+			r.Hash = sum.String()
+		} else {
+			digest, err := downloader.HashFiles("sha256", files...)
+			if err != nil {
+				return r, err
+			}
+			if cfg.DropCache {
+				for _, f := range files {
+					downloader.DropFileCache(f)
+				}
+			}
+			r.Hash = "sha256:" + digest
+		}
+	}
+	return r, nil
+}
+
+// redactURL hides credentials in URLs shown to scripts
+func redactURL(raw string) string {
+	u, err := url.Parse(raw)
+	if err != nil {
+		return raw
+	}
+	return u.Redacted()
+}
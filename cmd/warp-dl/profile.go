@@ -0,0 +1,35 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"sort"
+	"strings"
+
+	"github.com/spf13/cobra"
+)
+
+// applyProfile sets the flags of the --profile and then the config file's
+// defaults, each only where nothing more specific set them
+func applyProfile(cmd *cobra.Command) {
+	if profileName != "" {
+		p, ok := conf.Profiles[profileName]
+		if !ok {
+			names := make([]string, 0, len(conf.Profiles))
+			for name := range conf.Profiles {
+				names = append(names, name)
+			}
+			sort.Strings(names)
+			fmt.Fprintf(os.Stderr, "No profile named %q in %s (profiles: %s)\n", profileName, configPath, strings.Join(names, ", "))
+			os.Exit(1)
+		}
+		if err := setFlags(cmd, fmt.Sprintf("profile %q", profileName), p); err != nil {
+			fmt.Fprintln(os.Stderr, err)
+			os.Exit(1)
+		}
+	}
+	if err := setFlags(cmd, "defaults", conf.Defaults); err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		os.Exit(1)
+	}
+}
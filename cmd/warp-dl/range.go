@@ -0,0 +1,36 @@
+package main
+
+import (
+	"fmt"
+	"os"
+
+	"github.com/spf13/cobra"
+	"warp-dl/internal/downloader"
+)
+
+var byteRange string
+
+var rangeCmd = &cobra.Command{
+	Use:   "range [url]",
+	Short: "Download only a byte window of a remote file",
+	Example: "  warp-dl range https://example.com/huge.iso --range 1000000-2000000 -o slice.bin\n" +
+		"  warp-dl range https://example.com/huge.zip --range -65536 -o tail.bin",
+	Args: cobra.ExactArgs(1),
+	Run: func(cmd *cobra.Command, args []string) {
+		r, err := downloader.ParseByteRange(byteRange)
+		if err != nil {
+			fmt.Fprintln(os.Stderr, err)
+			os.Exit(1)
+		}
+
+		cfg := baseConfig(args[0])
+		cfg.Range = r
+		runDownload(cfg)
+	},
+}
+
+func init() {
+	rangeCmd.Flags().StringVarP(&byteRange, "range", "r", "", "Byte window to fetch: start-end, start- or -suffix")
+	rangeCmd.MarkFlagRequired("range")
+	rootCmd.AddCommand(rangeCmd)
+}
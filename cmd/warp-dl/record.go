@@ -0,0 +1,148 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"sync"
+	"time"
+
+	"github.com/spf13/cobra"
+	"warp-dl/internal/downloader"
+	"warp-dl/internal/session"
+	"warp-dl/internal/torrent"
+	"warp-dl/internal/ui"
+)
+
+var (
+	recordPath   string
+	recordBody   string
+	replayTiming bool
+)
+
+// recording is the --record of the running download, nil without one
+var recording *session.Recorder
+
+var replayCmd = &cobra.Command{
+	Use:   "replay session.json",
+	Short: "Re-run a download recorded with --record against a local server that answers the same way",
+	Long: "Re-run a download recorded with --record. A local server answers every request with the recorded\n" +
+		"status, headers and timing, and breaks off or stalls bodies where the recorded ones did. Afterwards\n" +
+		"the engine's decisions during the recording and the replay are listed one after the other.",
+	Args: cobra.ExactArgs(1),
+	Run: func(cmd *cobra.Command, args []string) {
+		if err := runReplay(args[0]); err != nil {
+			fmt.Fprintln(os.Stderr, err)
+			os.Exit(1)
+		}
+	},
+}
+
+func init() {
+	replayCmd.Flags().BoolVar(&replayTiming, "timing", true, "Reproduce the recorded delays and transfer speeds, false answers at full speed")
+	rootCmd.AddCommand(replayCmd)
+}
+
+// startRecording hooks --record into the download cfg describes
+func startRecording(cfg *downloader.Config) {
+	if recordPath == "" {
+		return
+	}
+	keep := int64(-1)
+	if recordBody != "all" {
+		n, err := downloader.ParseSize(recordBody)
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "Invalid --record-body: %v\n", err)
+			os.Exit(1)
+		}
+		keep = n
+	}
+	if torrent.IsTorrent(cfg.URL) || downloader.IsHLS(cfg.URL) || downloader.IsDASH(cfg.URL) {
+		fmt.Fprintln(os.Stderr, "Warning: --record only covers plain downloads, the recording will be empty")
+	}
+	recording = session.NewRecorder(*cfg, keep)
+	cfg.WrapTransport = recording.Wrap
+	cfg.Trace = recording.Note
+}
+
+// saveRecording writes the --record file with how the download ended
+func saveRecording(result error) {
+	if recording == nil {
+		return
+	}
+	if err := recording.Save(recordPath, result); err != nil {
+		fmt.Fprintf(os.Stderr, "Failed to write --record: %v\n", err)
+		return
+	}
+	fmt.Fprintf(msgOut, "Recorded the session in %s, it includes the URLs and headers, redacted credentials aside\n", recordPath)
+}
+
+func runReplay(path string) error {
+	setupPrint()
+	s, err := session.Load(path)
+	if err != nil {
+		return err
+	}
+	srv, err := session.NewServer(s, replayTiming)
+	if err != nil {
+		return err
+	}
+	defer srv.Close()
+
+	cfg := downloader.Config{
+		URL:         srv.Rewrite(s.URL),
+		OutputName:  output,
+		HTTP2:       downloader.HTTP2Off,
+		MOTW:        downloader.MOTWNever,
+		SplitOutput: downloader.SplitNever,
+	}
+	if err := s.Settings.Apply(&cfg); err != nil {
+		return fmt.Errorf("%s: %w", path, err)
+	}
+	for i, m := range cfg.Mirrors {
+		cfg.Mirrors[i] = srv.Rewrite(m)
+	}
+	if output == "" {
+		// Only the behaviour is of interest, not the made up file
+		dir, err := os.MkdirTemp("", "warp-dl-replay")
+		if err != nil {
+			return err
+		}
+		defer os.RemoveAll(dir)
+		cfg.Dir = dir
+	}
+
+	var (
+		mu       sync.Mutex
+		replayed []session.Event
+		start    = time.Now()
+	)
+	cfg.Trace = func(msg string) {
+		mu.Lock()
+		replayed = append(replayed, session.Event{At: time.Since(start), Msg: msg})
+		mu.Unlock()
+	}
+	fmt.Fprintf(msgOut, "Replaying %d requests to %s, recorded %s\n", len(s.Exchanges), s.URL, s.Started.Format(time.RFC3339))
+	result := runTask(downloader.NewEngine(cfg), ui.NewModel)
+
+	mu.Lock()
+	defer mu.Unlock()
+	fmt.Fprintln(msgOut, "Recorded:")
+	printEvents(s.Events, s.Result)
+	fmt.Fprintln(msgOut, "Replayed:")
+	outcome := "ok"
+	if result != nil {
+		outcome = result.Error()
+	}
+	printEvents(replayed, outcome)
+	if n := srv.Unmatched(); n > 0 {
+		fmt.Fprintf(msgOut, "%d requests weren't in the recording and got made up answers, the replay took another path from there\n", n)
+	}
+	return nil
+}
+
+func printEvents(events []session.Event, result string) {
+	for _, ev := range events {
+		fmt.Fprintf(msgOut, "  %8.3fs  %s\n", ev.At.Seconds(), ev.Msg)
+	}
+	fmt.Fprintf(msgOut, "  result: %s\n", result)
+}
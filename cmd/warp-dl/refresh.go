@@ -0,0 +1,39 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"os"
+	"os/exec"
+	"strings"
+)
+
+var refreshCmd string
+
+// urlRefresher turns --refresh-cmd into Config.RefreshURL, nil without one.
+// The command goes to the shell with {url} replaced by the quoted expired
+// URL, also in WARP_DL_URL, and prints the fresh URL on its first line. It
+// is killed when the download stops while it runs.
+func urlRefresher() func(ctx context.Context, expired string) (string, error) {
+	if refreshCmd == "" {
+		return nil
+	}
+	return func(ctx context.Context, expired string) (string, error) {
+		cmd := shellCommand(ctx, strings.ReplaceAll(refreshCmd, "{url}", shellArg("WARP_DL_URL", expired)))
+		cmd.Env = append(os.Environ(), "WARP_DL_URL="+expired)
+		out, err := cmd.Output()
+		if err != nil {
+			var exit *exec.ExitError
+			if errors.As(err, &exit) && len(exit.Stderr) > 0 {
+				return "", fmt.Errorf("--refresh-cmd: %w: %s", err, strings.TrimSpace(string(exit.Stderr)))
+			}
+			return "", fmt.Errorf("--refresh-cmd: %w", err)
+		}
+		fresh, _, _ := strings.Cut(strings.TrimSpace(string(out)), "\n")
+		if fresh = strings.TrimSpace(fresh); fresh == "" {
+			return "", errors.New("--refresh-cmd printed no URL")
+		}
+		return fresh, nil
+	}
+}
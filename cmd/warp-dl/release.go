@@ -0,0 +1,27 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"os"
+
+	"warp-dl/internal/downloader"
+)
+
+var latestRelease bool
+
+// runRelease downloads the asset a gh: or gl: shorthand names, verified
+// against the digest the release publishes for it
+func runRelease(spec string) {
+	cfg := baseConfig("")
+	asset, err := downloader.ResolveRelease(context.Background(), downloader.NewClient(cfg), spec, latestRelease)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Failed to resolve %s: %v\n", spec, err)
+		os.Exit(1)
+	}
+	fmt.Fprintf(msgOut, "%s from %s %s\n", asset.Name, asset.Repo, asset.Tag)
+	if asset.Checksum != nil {
+		fmt.Fprintf(msgOut, "Verifying against %s from %s\n", asset.Checksum.Algo, asset.ChecksumFrom)
+	}
+	runDownload(asset.Config(cfg))
+}
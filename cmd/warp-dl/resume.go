@@ -0,0 +1,276 @@
+package main
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/url"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"github.com/spf13/cobra"
+	"warp-dl/internal/config"
+	"warp-dl/internal/daemon"
+	"warp-dl/internal/downloader"
+)
+
+// How many directory levels below the searched ones hold journals,
+// enough for --name-template's {host}/{date} layouts
+const searchDepth = 3
+
+var (
+	resumeDaemon string
+	resumeToken  string
+)
+
+var statusCmd = &cobra.Command{
+	Use:   "status [dir...]",
+	Short: "List interrupted downloads that warp-dl resume can continue",
+	Long: "List the interrupted downloads whose resume state is in the directories\n" +
+		"(default: the current one and --dir) or a few levels below them. With\n" +
+		"--daemon it lists the daemon's queue instead.",
+	Example: "  warp-dl status ~/Downloads\n" +
+		"  warp-dl status --daemon",
+	Args: cobra.ArbitraryArgs,
+	Run: func(cmd *cobra.Command, args []string) {
+		if resumeDaemon != "" {
+			items, err := daemonDownloads()
+			if err != nil {
+				fmt.Fprintln(os.Stderr, err)
+				os.Exit(1)
+			}
+			for _, it := range items {
+				where := it.Output
+				if where == "" {
+					where = it.Dir
+				}
+				fmt.Printf("%-4s %-8s %-22s %s\n     %s\n", it.ID, it.State, progressText(it.Downloaded, it.Total), where, it.URL)
+				if it.Error != "" {
+					fmt.Printf("     %s\n", it.Error)
+				}
+			}
+			return
+		}
+
+		found := findInterrupted(args)
+		if len(found) == 0 {
+			fmt.Fprintln(os.Stderr, "No interrupted downloads found")
+			return
+		}
+		for _, in := range found {
+			fmt.Printf("%s %-22s %s %s\n         %s\n", localID(in.Output), progressText(in.Downloaded, in.Total), in.Modified.Format("2006-01-02 15:04"), in.Output, in.URL)
+		}
+	},
+}
+
+var resumeCmd = &cobra.Command{
+	Use:   "resume <id> [dir...]",
+	Short: "Continue an interrupted download by its warp-dl status ID",
+	Long: "Continue the interrupted download with this ID from warp-dl status, with\n" +
+		"the URL and output file of the original run. Other flags aren't stored\n" +
+		"with the download, pass them again where they matter. The ID may also be\n" +
+		"the output file. With --daemon it queues a failed download of the daemon\n" +
+		"again.",
+	Example: "  warp-dl resume 3fa2c1d0\n" +
+		"  warp-dl resume --daemon 12",
+	Args: cobra.MinimumNArgs(1),
+	Run: func(cmd *cobra.Command, args []string) {
+		if resumeDaemon != "" {
+			if len(args) > 1 {
+				fmt.Fprintln(os.Stderr, "--daemon takes only the ID")
+				os.Exit(1)
+			}
+			var it daemon.ItemStatus
+			if err := daemonCall(http.MethodPost, "/api/downloads/"+url.PathEscape(args[0])+"/resume", &it); err != nil {
+				fmt.Fprintln(os.Stderr, err)
+				os.Exit(1)
+			}
+			fmt.Printf("Queued %s again: %s\n", it.ID, it.URL)
+			return
+		}
+
+		in, err := pickInterrupted(args[0], args[1:])
+		if err != nil {
+			fmt.Fprintln(os.Stderr, err)
+			os.Exit(1)
+		}
+		if u, err := url.Parse(in.URL); err == nil {
+			if _, hasPassword := u.User.Password(); hasPassword {
+				fmt.Fprintln(os.Stderr, "The URL's password isn't stored with the download, run the original command again")
+				os.Exit(1)
+			}
+		}
+		if in.RangeStart != 0 || strings.HasSuffix(in.Output, ".range") {
+			fmt.Fprintln(os.Stderr, "This was a --range download, run it again with the same --range")
+			os.Exit(1)
+		}
+		if cmd.Flags().Changed("output") {
+			fmt.Fprintln(os.Stderr, "--output can't be changed, the download carries on in "+in.Output)
+			os.Exit(1)
+		}
+		output, outDir = in.Output, ""
+		fmt.Fprintf(msgOut, "Resuming %s (%s)\n", in.Output, progressText(in.Downloaded, in.Total))
+		runURL(in.URL)
+	},
+}
+
+// findInterrupted searches dirs, by default the current directory and --dir
+func findInterrupted(dirs []string) []downloader.Interrupted {
+	if len(dirs) == 0 {
+		dirs = []string{"."}
+		if outDir != "" {
+			dirs = append(dirs, downloader.ExpandHome(outDir))
+		}
+	}
+	seen := map[string]bool{}
+	var all []downloader.Interrupted
+	for _, dir := range dirs {
+		found, err := downloader.FindInterrupted(dir, searchDepth)
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "Warning: %v\n", err)
+		}
+		for _, in := range found {
+			if abs, err := filepath.Abs(in.Output); err == nil {
+				in.Output = abs
+			}
+			if !seen[in.Output] {
+				seen[in.Output] = true
+				all = append(all, in)
+			}
+		}
+	}
+	return all
+}
+
+// pickInterrupted finds the download an ID or a prefix of it names, or the
+// one writing to the file id
+func pickInterrupted(id string, dirs []string) (downloader.Interrupted, error) {
+	if in, err := downloader.ReadInterrupted(strings.TrimSuffix(id, ".warp")); err == nil {
+		if abs, err := filepath.Abs(in.Output); err == nil {
+			in.Output = abs
+		}
+		return in, nil
+	}
+	var match []downloader.Interrupted
+	for _, in := range findInterrupted(dirs) {
+		if strings.HasPrefix(localID(in.Output), strings.ToLower(id)) {
+			match = append(match, in)
+		}
+	}
+	switch len(match) {
+	case 0:
+		return downloader.Interrupted{}, fmt.Errorf("no interrupted download %s, see warp-dl status (pass the directory if it is elsewhere)", id)
+	case 1:
+		return match[0], nil
+	}
+	return downloader.Interrupted{}, fmt.Errorf("ID %s is ambiguous, give more of it", id)
+}
+
+// localID names an interrupted download by its output file, which stays
+// the same across runs
+func localID(output string) string {
+	sum := sha256.Sum256([]byte(output))
+	return hex.EncodeToString(sum[:4])
+}
+
+func progressText(done, total int64) string {
+	if total <= 0 {
+		return fmt.Sprintf("%.2f MB", float64(done)/1024/1024)
+	}
+	return fmt.Sprintf("%.0f%% of %.2f MB", float64(done)*100/float64(total), float64(total)/1024/1024)
+}
+
+// daemonAddress is --daemon as a URL, the config file's listen address
+// when it was given without one
+func daemonAddress() string {
+	addr := resumeDaemon
+	if addr == "listen" {
+		addr = conf.Daemon.Listen
+		if addr == "" {
+			addr = "127.0.0.1:7800"
+		}
+	}
+	if !strings.Contains(addr, "://") {
+		addr = "http://" + addr
+	}
+	return strings.TrimSuffix(addr, "/")
+}
+
+func daemonDownloads() ([]daemon.ItemStatus, error) {
+	var items []daemon.ItemStatus
+	err := daemonCall(http.MethodGet, "/api/downloads", &items)
+	return items, err
+}
+
+// daemonCall calls the daemon's API with --token, or the config file's
+// first admin token, and decodes the answer into out
+func daemonCall(method, path string, out any) error {
+	token := resumeToken
+	for _, t := range conf.Daemon.Tokens {
+		if token == "" && t.Scope == daemon.ScopeAdmin {
+			token = t.Token
+		}
+	}
+	req, err := http.NewRequest(method, daemonAddress()+path, nil)
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Authorization", "Bearer "+token)
+	resp, err := (&http.Client{Timeout: 10 * time.Second}).Do(req)
+	if err != nil {
+		return fmt.Errorf("cannot reach the daemon: %w", err)
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode >= 300 {
+		var e struct {
+			Error string `json:"error"`
+		}
+		json.NewDecoder(resp.Body).Decode(&e)
+		if e.Error == "" {
+			e.Error = resp.Status
+		}
+		return fmt.Errorf("daemon: %s", e.Error)
+	}
+	return json.NewDecoder(resp.Body).Decode(out)
+}
+
+// completeDownloadIDs completes the IDs warp-dl status lists
+func completeDownloadIDs(cmd *cobra.Command, args []string, toComplete string) ([]string, cobra.ShellCompDirective) {
+	if len(args) > 0 {
+		return nil, cobra.ShellCompDirectiveFilterDirs
+	}
+	if f, err := config.Load(configPath); err == nil {
+		conf = f
+	}
+	var ids []string
+	if resumeDaemon != "" {
+		items, _ := daemonDownloads()
+		for _, it := range items {
+			if it.State == daemon.StateFailed || it.State == daemon.StateCanceled {
+				ids = append(ids, it.ID+"\t"+it.URL)
+			}
+		}
+	} else {
+		for _, in := range findInterrupted(nil) {
+			ids = append(ids, localID(in.Output)+"\t"+in.Output)
+		}
+	}
+	return ids, cobra.ShellCompDirectiveNoFileComp
+}
+
+func init() {
+	for _, cmd := range []*cobra.Command{statusCmd, resumeCmd} {
+		cmd.Flags().StringVar(&resumeDaemon, "daemon", "", "Use the queue of the daemon at this address (--daemon=HOST:PORT), alone the config file's daemon listen address")
+		cmd.Flags().Lookup("daemon").NoOptDefVal = "listen"
+		cmd.Flags().StringVar(&resumeToken, "token", "", "API token for --daemon (default: the config file's first admin token)")
+		rootCmd.AddCommand(cmd)
+	}
+	resumeCmd.ValidArgsFunction = completeDownloadIDs
+	statusCmd.ValidArgsFunction = func(cmd *cobra.Command, args []string, toComplete string) ([]string, cobra.ShellCompDirective) {
+		return nil, cobra.ShellCompDirectiveFilterDirs
+	}
+}
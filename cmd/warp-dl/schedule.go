@@ -0,0 +1,50 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"time"
+)
+
+// schedule is the --schedule start time
+var schedule string
+
+// parseSchedule reads a start time: a time of day, the next time it comes
+// round, or a date and time
+func parseSchedule(s string, now time.Time) (time.Time, error) {
+	if t, err := time.ParseInLocation("15:04", s, time.Local); err == nil {
+		at := time.Date(now.Year(), now.Month(), now.Day(), t.Hour(), t.Minute(), 0, 0, time.Local)
+		if !at.After(now) {
+			at = at.AddDate(0, 0, 1)
+		}
+		return at, nil
+	}
+	for _, layout := range []string{"2006-01-02 15:04", "2006-01-02T15:04", time.RFC3339} {
+		if t, err := time.ParseInLocation(layout, s, time.Local); err == nil {
+			return t, nil
+		}
+	}
+	return time.Time{}, fmt.Errorf("invalid --schedule %q: want HH:MM or YYYY-MM-DD HH:MM", s)
+}
+
+// waitSchedule holds the download back until the --schedule time, once
+func waitSchedule() {
+	if schedule == "" {
+		return
+	}
+	at, err := parseSchedule(schedule, time.Now())
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		os.Exit(1)
+	}
+	schedule = ""
+	wait := time.Until(at)
+	if wait <= 0 {
+		return
+	}
+	fmt.Fprintf(msgOut, "Waiting until %s to start, %s from now\n", at.Format("Mon 15:04"), wait.Round(time.Second))
+	// The clock, not a timer: a machine asleep in between starts on waking
+	for time.Now().Before(at) {
+		time.Sleep(min(time.Until(at), time.Minute))
+	}
+}
@@ -0,0 +1,37 @@
+package main
+
+import (
+	"context"
+	"errors"
+
+	"warp-dl/internal/downloader"
+	"warp-dl/internal/torrent"
+)
+
+var (
+	signature string
+	gpgKeys   []string
+)
+
+// withSignature loads --signature and --gpg-key for the download, which is
+// then only moved into place once the signature checks out
+func withSignature(cfg downloader.Config) (downloader.Config, error) {
+	if signature == "" {
+		if len(gpgKeys) > 0 {
+			return cfg, errors.New("--gpg-key needs --signature")
+		}
+		return cfg, nil
+	}
+	if cfg.Stream != nil || torrent.IsTorrent(cfg.URL) || downloader.IsHLS(cfg.URL) || downloader.IsDASH(cfg.URL) || downloader.IsOCI(cfg.URL) {
+		return cfg, errors.New("--signature only verifies plain downloads saved to a file, not -o -, torrents, HLS/DASH playlists or OCI images")
+	}
+	if len(gpgKeys) == 0 {
+		return cfg, errors.New("--signature needs the signer's public key, give it with --gpg-key")
+	}
+	sig, err := downloader.LoadSignature(context.Background(), downloader.NewClient(cfg), signature, gpgKeys)
+	if err != nil {
+		return cfg, err
+	}
+	cfg.Signature = sig
+	return cfg, nil
+}
@@ -0,0 +1,43 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"time"
+
+	"warp-dl/internal/downloader"
+	"warp-dl/internal/taskbar"
+)
+
+const taskbarInterval = 500 * time.Millisecond
+
+// showTaskbarProgress mirrors the download's progress in the desktop shell
+// until the returned stop is called. Without a desktop session it only
+// warns.
+func showTaskbarProgress(stats *downloader.Stats) (stop func()) {
+	bar, err := taskbar.Open()
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Warning: --taskbar: %v\n", err)
+		return func() {}
+	}
+	quit := make(chan struct{})
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		t := time.NewTicker(taskbarInterval)
+		defer t.Stop()
+		for {
+			bar.Set(stats.GetDownloaded(), stats.GetTotal())
+			select {
+			case <-quit:
+				return
+			case <-t.C:
+			}
+		}
+	}()
+	return func() {
+		close(quit)
+		<-done
+		bar.Close()
+	}
+}
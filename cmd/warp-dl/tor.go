@@ -0,0 +1,47 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"sync"
+
+	"warp-dl/internal/downloader"
+	"warp-dl/internal/torrent"
+)
+
+var (
+	useTor   bool
+	torAddr  string
+	torCheck sync.Once
+)
+
+// withTor sends the download through the Tor SOCKS proxy, after checking
+// once per run that requests really come out of the Tor network
+func withTor(cfg downloader.Config) downloader.Config {
+	if !useTor {
+		return cfg
+	}
+	if torrent.IsTorrent(cfg.URL) {
+		fmt.Fprintln(os.Stderr, "--tor can't carry torrents: peers and UDP trackers connect directly")
+		os.Exit(1)
+	}
+	proxy, err := downloader.TorProxy(torAddr)
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		os.Exit(1)
+	}
+	// Tor resolves the names, DoH would only be another way out
+	cfg.Proxy, cfg.Tor, cfg.DNS = proxy, true, downloader.DNSSystem
+	cfg.PAC, cfg.WPAD, cfg.Links = "", false, nil
+
+	torCheck.Do(func() {
+		exit, err := downloader.CheckTor(context.Background(), downloader.NewClient(cfg))
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "--tor: %v\n", err)
+			os.Exit(1)
+		}
+		fmt.Fprintf(msgOut, "Connected through Tor, exit %s\n", exit)
+	})
+	return cfg
+}
@@ -0,0 +1,57 @@
+package main
+
+import (
+	"net/http"
+
+	"warp-dl/internal/downloader"
+)
+
+var (
+	userAgent string
+	uaPreset  string
+)
+
+// hostHeaders builds the config file's per-domain headers: the common ones
+// with the domain's over them and the --header flags over both
+func hostHeaders(common map[string]string, hosts map[string]map[string]string, flags []string) (map[string]http.Header, error) {
+	if len(hosts) == 0 {
+		return nil, nil
+	}
+	out := make(map[string]http.Header, len(hosts))
+	for domain, set := range hosts {
+		merged := map[string]string{}
+		for name, value := range common {
+			merged[http.CanonicalHeaderKey(name)] = value
+		}
+		for name, value := range set {
+			merged[http.CanonicalHeaderKey(name)] = value
+		}
+		h, err := requestHeaders(merged, flags)
+		if err != nil {
+			return nil, err
+		}
+		out[domain] = h
+	}
+	return out, nil
+}
+
+// chosenUserAgent is --user-agent, or else --ua-preset's, empty for the
+// configured one
+func chosenUserAgent() (string, error) {
+	if userAgent != "" || uaPreset == "" {
+		return userAgent, nil
+	}
+	return downloader.PresetUserAgent(uaPreset)
+}
+
+// setHeader puts a flag's header in every header set, over what the config
+// file and --header have. An empty value leaves them alone.
+func setHeader(common http.Header, hosts map[string]http.Header, name, value string) {
+	if value == "" {
+		return
+	}
+	common.Set(name, value)
+	for _, h := range hosts {
+		h.Set(name, value)
+	}
+}
@@ -0,0 +1,46 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/url"
+	"os"
+
+	"warp-dl/internal/downloader"
+	"warp-dl/internal/torrent"
+)
+
+var zsyncSrc string
+
+// withZsync loads --zsync's control file, so the download only fetches
+// the blocks the existing output lacks. Alone, the flag tries URL.zsync
+// and falls back to a full download when there is none.
+func withZsync(cfg downloader.Config) (downloader.Config, error) {
+	if zsyncSrc == "" {
+		return cfg, nil
+	}
+	if cfg.Stream != nil || cfg.Range != nil || torrent.IsTorrent(cfg.URL) || downloader.IsHLS(cfg.URL) || downloader.IsDASH(cfg.URL) || downloader.IsOCI(cfg.URL) {
+		return cfg, errors.New("--zsync only updates plain downloads saved to a file, not -o -, --range, torrents, HLS/DASH playlists or OCI images")
+	}
+	src, auto := zsyncSrc, zsyncSrc == "auto"
+	if auto {
+		u, err := url.Parse(cfg.URL)
+		if err != nil {
+			return cfg, err
+		}
+		u.Path += ".zsync"
+		u.RawPath, u.RawQuery, u.Fragment = "", "", ""
+		src = u.String()
+	}
+	ctrl, err := downloader.LoadZsync(context.Background(), downloader.NewClient(cfg), src)
+	if err != nil {
+		if auto {
+			fmt.Fprintf(os.Stderr, "Warning: no zsync control file at %s (%v), downloading the whole file\n", src, err)
+			return cfg, nil
+		}
+		return cfg, fmt.Errorf("--zsync: %w", err)
+	}
+	cfg.Zsync = ctrl
+	return cfg, nil
+}
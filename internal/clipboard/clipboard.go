@@ -0,0 +1,67 @@
+// Package clipboard reads the text on the system clipboard and picks the
+// URLs out of it, for warp-dl watch-clipboard.
+package clipboard
+
+import (
+	"context"
+	"errors"
+	"regexp"
+	"strings"
+	"time"
+)
+
+// ErrUnavailable means there is no clipboard to read, e.g. over SSH or
+// without one of the tools it takes on Linux
+var ErrUnavailable = errors.New("no clipboard available")
+
+// Read returns the text on the clipboard, "" when it holds something else
+func Read() (string, error) {
+	return read()
+}
+
+// Watch sends the clipboard's text every time it changes, until ctx is
+// done. What is on it when Watch starts doesn't count as a change.
+func Watch(ctx context.Context, every time.Duration) (<-chan string, error) {
+	last, err := Read()
+	if err != nil {
+		return nil, err
+	}
+	ch := make(chan string)
+	go func() {
+		defer close(ch)
+		t := time.NewTicker(every)
+		defer t.Stop()
+		for {
+			select {
+			case <-ctx.Done():
+				return
+			case <-t.C:
+			}
+			// A read failing now and then, e.g. while another program
+			// holds the clipboard, only skips a beat
+			text, err := Read()
+			if err != nil || text == last {
+				continue
+			}
+			last = text
+			select {
+			case ch <- text:
+			case <-ctx.Done():
+				return
+			}
+		}
+	}()
+	return ch, nil
+}
+
+var urlPattern = regexp.MustCompile(`(?i)\b(?:https?|ftp|sftp|s3|gs)://[^\s<>"'` + "`" + `]+|\bmagnet:\?[^\s<>"'` + "`" + `]+`)
+
+// URLs returns the URLs and magnet links in text, in order
+func URLs(text string) []string {
+	found := urlPattern.FindAllString(text, -1)
+	for i, u := range found {
+		// Punctuation the URL was written in, not part of it
+		found[i] = strings.TrimRight(u, ".,;:!?)]}")
+	}
+	return found
+}
@@ -0,0 +1,37 @@
+//go:build !windows && !darwin
+
+package clipboard
+
+import (
+	"os"
+	"os/exec"
+)
+
+// readers are the clipboard tools of Wayland, X11 and Termux, tried in turn
+var readers = [][]string{
+	{"wl-paste", "--no-newline", "--type", "text"},
+	{"xclip", "-selection", "clipboard", "-out"},
+	{"xsel", "--clipboard", "--output"},
+	{"termux-clipboard-get"},
+}
+
+func read() (string, error) {
+	for _, r := range readers {
+		if r[0] == "wl-paste" && os.Getenv("WAYLAND_DISPLAY") == "" {
+			continue
+		}
+		if r[0] != "wl-paste" && r[0] != "termux-clipboard-get" && os.Getenv("DISPLAY") == "" {
+			continue
+		}
+		if _, err := exec.LookPath(r[0]); err != nil {
+			continue
+		}
+		out, err := exec.Command(r[0], r[1:]...).Output()
+		if err != nil {
+			// Empty or holding an image, nothing to see either way
+			return "", nil
+		}
+		return string(out), nil
+	}
+	return "", ErrUnavailable
+}
@@ -0,0 +1,48 @@
+package clipboard
+
+import (
+	"fmt"
+	"runtime"
+	"unsafe"
+
+	"golang.org/x/sys/windows"
+)
+
+var (
+	user32                         = windows.NewLazySystemDLL("user32.dll")
+	procOpenClipboard              = user32.NewProc("OpenClipboard")
+	procCloseClipboard             = user32.NewProc("CloseClipboard")
+	procGetClipboardData           = user32.NewProc("GetClipboardData")
+	procIsClipboardFormatAvailable = user32.NewProc("IsClipboardFormatAvailable")
+	kernel32                       = windows.NewLazySystemDLL("kernel32.dll")
+	procGlobalLock                 = kernel32.NewProc("GlobalLock")
+	procGlobalUnlock               = kernel32.NewProc("GlobalUnlock")
+)
+
+const cfUnicodeText = 13
+
+func read() (string, error) {
+	// The clipboard is opened for the calling thread
+	runtime.LockOSThread()
+	defer runtime.UnlockOSThread()
+
+	if ok, _, _ := procIsClipboardFormatAvailable.Call(cfUnicodeText); ok == 0 {
+		return "", nil
+	}
+	if ok, _, err := procOpenClipboard.Call(0); ok == 0 {
+		return "", fmt.Errorf("opening the clipboard: %w", err)
+	}
+	defer procCloseClipboard.Call()
+
+	h, _, _ := procGetClipboardData.Call(cfUnicodeText)
+	if h == 0 {
+		return "", nil
+	}
+	p, _, _ := procGlobalLock.Call(h)
+	if p == 0 {
+		return "", nil
+	}
+	defer procGlobalUnlock.Call(h)
+	// p points outside the Go heap, reinterpret it rather than convert it
+	return windows.UTF16PtrToString(*(**uint16)(unsafe.Pointer(&p))), nil
+}
@@ -0,0 +1,112 @@
+// Package config loads the user configuration file
+package config
+
+import (
+	"errors"
+	"fmt"
+	"io/fs"
+	"os"
+	"path/filepath"
+
+	"gopkg.in/yaml.v3"
+	"warp-dl/internal/daemon"
+	"warp-dl/internal/downloader"
+	"warp-dl/internal/ui"
+)
+
+// File is the content of config.yaml
+type File struct {
+	// Trusted checksum lists, downloads they cover are verified automatically
+	ChecksumManifests []downloader.ManifestSource `yaml:"checksum_manifests"`
+
+	// Headers sent with every request, e.g. Accept-Language, so CDNs that
+	// vary by them answer the same way on every machine. --header and
+	// presets add to or override them.
+	Headers map[string]string `yaml:"headers"`
+
+	// Headers for a domain and its subdomains, over Headers, for hosts that
+	// want a particular User-Agent or Referer. An empty value drops one of
+	// Headers there.
+	HostHeaders map[string]map[string]string `yaml:"host_headers"`
+
+	// Mirror lists for --auto-mirrors beyond the built-in distributions:
+	// a URL prefix and the prefixes of the same tree on its mirrors
+	Mirrors map[string][]string `yaml:"mirrors"`
+
+	Daemon daemon.Config `yaml:"daemon"`
+
+	// Commands run after every download, --on-complete and --on-error
+	// replace them
+	Hooks Hooks `yaml:"hooks"`
+
+	// Plugin executables called at the hooks of every download, in order,
+	// before the --plugin ones. See package plugin for the protocol.
+	Plugins []string `yaml:"plugins"`
+
+	// The links warp-dl watch-clipboard picks up, --ext and --host
+	// replace them
+	Clipboard Clipboard `yaml:"clipboard"`
+
+	// Colors and characters of the progress UI
+	Theme ui.ThemeConfig `yaml:"theme"`
+
+	// Where finished downloads are recorded for warp-dl history, off to
+	// keep no history. Default: history.jsonl in warp-dl's config directory.
+	History string `yaml:"history"`
+
+	// Flag values for every command, e.g. concurrent or dir. The command
+	// line, a preset and the --profile win over them.
+	Defaults Preset `yaml:"defaults"`
+
+	// Named sets of flag values selected with --profile, over the defaults
+	Profiles map[string]Preset `yaml:"profiles"`
+
+	// Named flag combinations for warp-dl get --preset
+	Presets map[string]Preset `yaml:"presets"`
+}
+
+// Hooks are shell commands run when a download ends, {file} stands for
+// the output path
+type Hooks struct {
+	OnComplete string `yaml:"on_complete"`
+	OnError    string `yaml:"on_error"`
+}
+
+// Clipboard selects copied URLs by file type or host. A URL matching
+// either is taken.
+type Clipboard struct {
+	Extensions []string `yaml:"extensions"` // e.g. iso or tar.gz
+	Hosts      []string `yaml:"hosts"`      // *.example.com takes the subdomains too
+}
+
+// DefaultPath is ~/.config/warp-dl/config.yaml (or the platform's
+// equivalent), overridable with WARP_DL_CONFIG
+func DefaultPath() string {
+	if p := os.Getenv("WARP_DL_CONFIG"); p != "" {
+		return p
+	}
+	dir, err := os.UserConfigDir()
+	if err != nil {
+		return ""
+	}
+	return filepath.Join(dir, "warp-dl", "config.yaml")
+}
+
+// Load reads the config file at path. A missing file is an empty config.
+func Load(path string) (*File, error) {
+	f := &File{}
+	if path == "" {
+		return f, nil
+	}
+	data, err := os.ReadFile(path)
+	if errors.Is(err, fs.ErrNotExist) {
+		return f, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	if err := yaml.Unmarshal(data, f); err != nil {
+		return nil, fmt.Errorf("%s: %w", path, err)
+	}
+	return f, nil
+}
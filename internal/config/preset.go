@@ -0,0 +1,137 @@
+package config
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"io/fs"
+	"os"
+	"path/filepath"
+
+	"gopkg.in/yaml.v3"
+)
+
+// Preset maps flag names (without dashes) to their values. A flag given
+// several times keeps every value in order.
+type Preset map[string]FlagValues
+
+// FlagValues is written as a plain scalar when there is a single value
+type FlagValues []string
+
+func (v *FlagValues) UnmarshalYAML(n *yaml.Node) error {
+	if n.Kind == yaml.ScalarNode {
+		*v = FlagValues{n.Value}
+		return nil
+	}
+	var list []string
+	if err := n.Decode(&list); err != nil {
+		return err
+	}
+	*v = list
+	return nil
+}
+
+func (v FlagValues) MarshalYAML() (interface{}, error) {
+	if len(v) == 1 {
+		return v[0], nil
+	}
+	return []string(v), nil
+}
+
+// SavePreset stores a preset in the config file at path, replacing one of
+// the same name. The rest of the file, comments included, is kept as is.
+func SavePreset(path, name string, p Preset) error {
+	return editFile(path, func(root *yaml.Node) error {
+		presets := mappingValue(root, "presets")
+		if presets == nil {
+			presets = &yaml.Node{Kind: yaml.MappingNode, Tag: "!!map"}
+			root.Content = append(root.Content, scalar("presets"), presets)
+		}
+		var value yaml.Node
+		if err := value.Encode(p); err != nil {
+			return err
+		}
+		if old := mappingValue(presets, name); old != nil {
+			*old = value
+			return nil
+		}
+		presets.Content = append(presets.Content, scalar(name), &value)
+		return nil
+	})
+}
+
+// DeletePreset removes a preset from the config file at path
+func DeletePreset(path, name string) error {
+	return editFile(path, func(root *yaml.Node) error {
+		presets := mappingValue(root, "presets")
+		if presets != nil {
+			for i := 0; i+1 < len(presets.Content); i += 2 {
+				if presets.Content[i].Value == name {
+					presets.Content = append(presets.Content[:i], presets.Content[i+2:]...)
+					return nil
+				}
+			}
+		}
+		return fmt.Errorf("no preset named %q", name)
+	})
+}
+
+// editFile applies edit to the top level mapping of the YAML file at path
+// and writes it back, creating the file if needed
+func editFile(path string, edit func(root *yaml.Node) error) error {
+	if path == "" {
+		return fmt.Errorf("no config file location, set WARP_DL_CONFIG or --config")
+	}
+	data, err := os.ReadFile(path)
+	if err != nil && !errors.Is(err, fs.ErrNotExist) {
+		return err
+	}
+
+	var doc yaml.Node
+	if err := yaml.Unmarshal(data, &doc); err != nil {
+		return fmt.Errorf("%s: %w", path, err)
+	}
+	if doc.Kind == 0 {
+		doc = yaml.Node{Kind: yaml.DocumentNode, Content: []*yaml.Node{{Kind: yaml.MappingNode, Tag: "!!map"}}}
+	}
+	root := doc.Content[0]
+	if root.Kind != yaml.MappingNode {
+		return fmt.Errorf("%s: top level is not a mapping", path)
+	}
+	if err := edit(root); err != nil {
+		return err
+	}
+
+	var buf bytes.Buffer
+	enc := yaml.NewEncoder(&buf)
+	enc.SetIndent(2)
+	if err := enc.Encode(&doc); err != nil {
+		return err
+	}
+	if err := os.MkdirAll(filepath.Dir(path), 0o755); err != nil {
+		return err
+	}
+	// Keep the permissions, the file may hold API tokens
+	mode := fs.FileMode(0o644)
+	if info, err := os.Stat(path); err == nil {
+		mode = info.Mode().Perm()
+	}
+	tmp := path + ".tmp"
+	if err := os.WriteFile(tmp, buf.Bytes(), mode); err != nil {
+		return err
+	}
+	return os.Rename(tmp, path)
+}
+
+func mappingValue(m *yaml.Node, key string) *yaml.Node {
+	for i := 0; i+1 < len(m.Content); i += 2 {
+		if m.Content[i].Value == key {
+			return m.Content[i+1]
+		}
+	}
+	return nil
+}
+
+func scalar(s string) *yaml.Node {
+	return &yaml.Node{Kind: yaml.ScalarNode, Tag: "!!str", Value: s}
+}
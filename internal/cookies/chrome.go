@@ -0,0 +1,144 @@
+package cookies
+
+import (
+	"bytes"
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/sha256"
+	"errors"
+	"path/filepath"
+	"strconv"
+	"time"
+)
+
+// chromeProducts are the names the Chromium based browsers store their
+// keys under
+var chromeProducts = map[string]string{
+	"chrome":   "Chrome",
+	"chromium": "Chromium",
+	"edge":     "Microsoft Edge",
+}
+
+// chromeEpoch is the Unix time of 1601-01-01, where the expiry times
+// count from in microseconds
+const chromeEpoch = -11644473600
+
+// chromeCookies reads the Cookies database of the profile, by default the
+// one used last, and decrypts the values
+func chromeCookies(browser, profile string) ([]browserCookie, error) {
+	var patterns []string
+	for _, root := range chromeRoots(browser) {
+		dir := filepath.Join(root, "*")
+		if profile != "" {
+			dir = profileDir(root, profile)
+		}
+		patterns = append(patterns, filepath.Join(dir, "Network", "Cookies"), filepath.Join(dir, "Cookies"))
+	}
+	path, err := newest(patterns...)
+	if err != nil {
+		return nil, err
+	}
+	// The user data directory above the profile has the key on Windows
+	root := filepath.Dir(filepath.Dir(path))
+	if filepath.Base(filepath.Dir(path)) == "Network" {
+		root = filepath.Dir(root)
+	}
+
+	db, err := openSQLite(path)
+	if err != nil {
+		return nil, err
+	}
+	cols, rows, err := db.table("cookies")
+	if err != nil {
+		return nil, err
+	}
+	secure := "is_secure"
+	if _, err := columns(cols, secure); err != nil {
+		secure = "secure"
+	}
+	idx, err := columns(cols, "host_key", "name", "value", "encrypted_value", "path", "expires_utc", secure)
+	if err != nil {
+		return nil, err
+	}
+
+	// Since version 24 the values start with a hash of the host
+	version := 0
+	if mcols, meta, err := db.table("meta"); err == nil {
+		if mi, err := columns(mcols, "key", "value"); err == nil {
+			for _, row := range meta {
+				if toString(row[mi[0]]) == "version" {
+					version, _ = strconv.Atoi(toString(row[mi[1]]))
+				}
+			}
+		}
+	}
+
+	decrypt := newDecrypter(browser, root)
+	var out []browserCookie
+	var failed error
+	for _, row := range rows {
+		c := browserCookie{
+			Host:   toString(row[idx[0]]),
+			Name:   toString(row[idx[1]]),
+			Value:  toString(row[idx[2]]),
+			Path:   toString(row[idx[4]]),
+			Secure: toInt(row[idx[6]]) != 0,
+		}
+		if enc, _ := row[idx[3]].([]byte); c.Value == "" && len(enc) > 0 {
+			v, err := decrypt(enc)
+			if err != nil {
+				failed = err
+				continue
+			}
+			if sum := sha256.Sum256([]byte(c.Host)); version >= 24 && bytes.HasPrefix(v, sum[:]) {
+				v = v[len(sum):]
+			}
+			c.Value = string(v)
+		}
+		if exp := toInt(row[idx[5]]); exp > 0 {
+			c.Expires = time.UnixMicro(exp + chromeEpoch*1e6)
+		}
+		out = append(out, c)
+	}
+	if len(out) == 0 && failed != nil {
+		return nil, failed
+	}
+	return out, nil
+}
+
+// newDecrypter returns the function that decrypts the values of the
+// browser's cookies. Keys are looked up on first use, keyrings may ask the
+// user for access.
+func newDecrypter(browser, root string) func([]byte) ([]byte, error) {
+	var key []byte
+	var keyErr error
+	looked := false
+	return func(enc []byte) ([]byte, error) {
+		if !looked {
+			key, keyErr = chromeKey(browser, root)
+			looked = true
+		}
+		return decryptValue(key, keyErr, enc)
+	}
+}
+
+var errDecrypt = errors.New("cannot decrypt the cookies, the key is wrong")
+
+// decryptCBC decrypts the AES-128-CBC values of Linux and macOS
+func decryptCBC(key, data []byte) ([]byte, error) {
+	if len(data) == 0 || len(data)%aes.BlockSize != 0 {
+		return nil, errDecrypt
+	}
+	block, err := aes.NewCipher(key)
+	if err != nil {
+		return nil, err
+	}
+	out := make([]byte, len(data))
+	cipher.NewCBCDecrypter(block, bytes.Repeat([]byte{' '}, aes.BlockSize)).CryptBlocks(out, data)
+	// PKCS#7 padding, wrong keys rarely give valid padding
+	pad := int(out[len(out)-1])
+	if pad == 0 || pad > aes.BlockSize || !bytes.Equal(out[len(out)-pad:], bytes.Repeat([]byte{byte(pad)}, pad)) {
+		return nil, errDecrypt
+	}
+	return out[:len(out)-pad], nil
+}
@@ -0,0 +1,123 @@
+// Package cookies reads the cookies of a site from the browsers installed
+// on the machine, so downloads that need a login don't need them exported.
+package cookies
+
+import (
+	"fmt"
+	"net/http"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"time"
+)
+
+// Browsers FromBrowser reads
+var Browsers = []string{"firefox", "chrome", "chromium", "edge"}
+
+// FromBrowser returns the cookies the browser would send to host. spec is
+// a browser name, optionally followed by :PROFILE for a profile other than
+// the one used last, as a directory name or path.
+func FromBrowser(spec, host string) ([]*http.Cookie, error) {
+	browser, profile, _ := strings.Cut(spec, ":")
+	browser = strings.ToLower(browser)
+	var all []browserCookie
+	var err error
+	switch browser {
+	case "firefox":
+		all, err = firefoxCookies(profile)
+	case "chrome", "chromium", "edge":
+		all, err = chromeCookies(browser, profile)
+	default:
+		return nil, fmt.Errorf("unknown browser %q: want %s", browser, strings.Join(Browsers, ", "))
+	}
+	if err != nil {
+		return nil, fmt.Errorf("cannot read the %s cookies: %w", browser, err)
+	}
+
+	host = strings.ToLower(strings.TrimSuffix(host, "."))
+	now := time.Now()
+	var out []*http.Cookie
+	for _, c := range all {
+		if !c.Expires.IsZero() && c.Expires.Before(now) {
+			continue
+		}
+		domain := strings.ToLower(c.Host)
+		hostOnly := !strings.HasPrefix(domain, ".")
+		domain = strings.TrimPrefix(domain, ".")
+		if host != domain && (hostOnly || !strings.HasSuffix(host, "."+domain)) {
+			continue
+		}
+		hc := &http.Cookie{Name: c.Name, Value: c.Value, Path: c.Path, Secure: c.Secure, Expires: c.Expires}
+		if !hostOnly {
+			hc.Domain = domain
+		}
+		out = append(out, hc)
+	}
+	return out, nil
+}
+
+// browserCookie is a row of a cookie store. Host starts with a dot for
+// cookies of the subdomains too.
+type browserCookie struct {
+	Host, Name, Value, Path string
+	Secure                  bool
+	Expires                 time.Time // Zero for session cookies
+}
+
+// newest returns the most recently written of the files matching the
+// patterns, the store of the profile that was used last
+func newest(patterns ...string) (string, error) {
+	var found []string
+	for _, p := range patterns {
+		m, _ := filepath.Glob(p)
+		found = append(found, m...)
+	}
+	if len(found) == 0 {
+		return "", fmt.Errorf("no cookie store found (looked for %s)", strings.Join(patterns, ", "))
+	}
+	mtime := func(p string) time.Time {
+		info, err := os.Stat(p)
+		if err != nil {
+			return time.Time{}
+		}
+		return info.ModTime()
+	}
+	sort.SliceStable(found, func(i, j int) bool { return mtime(found[i]).After(mtime(found[j])) })
+	return found[0], nil
+}
+
+// profileDir resolves a profile given by name below root, or as a path
+func profileDir(root, profile string) string {
+	if filepath.IsAbs(profile) || strings.ContainsRune(profile, os.PathSeparator) {
+		return profile
+	}
+	return filepath.Join(root, profile)
+}
+
+// columns returns the index of each of the names in cols
+func columns(cols []string, names ...string) ([]int, error) {
+	idx := make([]int, len(names))
+	for i, name := range names {
+		idx[i] = -1
+		for j, c := range cols {
+			if c == name {
+				idx[i] = j
+			}
+		}
+		if idx[i] < 0 {
+			return nil, fmt.Errorf("cookie table has no %s column", name)
+		}
+	}
+	return idx, nil
+}
+
+func toString(v any) string {
+	switch v := v.(type) {
+	case string:
+		return v
+	case []byte:
+		return string(v)
+	}
+	return ""
+}
@@ -0,0 +1,54 @@
+package cookies
+
+import (
+	"bytes"
+	"crypto/sha1"
+	"errors"
+	"fmt"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+
+	"golang.org/x/crypto/pbkdf2"
+)
+
+func firefoxRoots() []string {
+	home, _ := os.UserHomeDir()
+	return []string{filepath.Join(home, "Library", "Application Support", "Firefox", "Profiles")}
+}
+
+func chromeRoots(browser string) []string {
+	home, _ := os.UserHomeDir()
+	support := filepath.Join(home, "Library", "Application Support")
+	switch browser {
+	case "chrome":
+		return []string{filepath.Join(support, "Google", "Chrome")}
+	case "chromium":
+		return []string{filepath.Join(support, "Chromium")}
+	default:
+		return []string{filepath.Join(support, "Microsoft Edge")}
+	}
+}
+
+// chromeKey gets the key from the login keychain, macOS asks the user to
+// allow it
+func chromeKey(browser, root string) ([]byte, error) {
+	service := chromeProducts[browser] + " Safe Storage"
+	out, err := exec.Command("security", "find-generic-password", "-w", "-s", service).Output()
+	pass := strings.TrimRight(string(out), "\n")
+	if err != nil || pass == "" {
+		return nil, fmt.Errorf("no %s in the keychain", service)
+	}
+	return pbkdf2.Key([]byte(pass), []byte("saltysalt"), 1003, 16, sha1.New), nil
+}
+
+func decryptValue(key []byte, keyErr error, enc []byte) ([]byte, error) {
+	if !bytes.HasPrefix(enc, []byte("v10")) {
+		return nil, errors.New("unknown cookie encryption")
+	}
+	if keyErr != nil {
+		return nil, keyErr
+	}
+	return decryptCBC(key, enc[3:])
+}
@@ -0,0 +1,92 @@
+//go:build !windows && !darwin
+
+package cookies
+
+import (
+	"bytes"
+	"crypto/sha1"
+	"errors"
+	"fmt"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+
+	"golang.org/x/crypto/pbkdf2"
+)
+
+func firefoxRoots() []string {
+	home, _ := os.UserHomeDir()
+	return []string{
+		filepath.Join(home, ".mozilla", "firefox"),
+		filepath.Join(home, "snap", "firefox", "common", ".mozilla", "firefox"),
+		filepath.Join(home, ".var", "app", "org.mozilla.firefox", ".mozilla", "firefox"),
+	}
+}
+
+func chromeRoots(browser string) []string {
+	config, _ := os.UserConfigDir()
+	home, _ := os.UserHomeDir()
+	switch browser {
+	case "chrome":
+		return []string{filepath.Join(config, "google-chrome")}
+	case "chromium":
+		return []string{filepath.Join(config, "chromium"), filepath.Join(home, "snap", "chromium", "common", "chromium")}
+	default:
+		return []string{filepath.Join(config, "microsoft-edge")}
+	}
+}
+
+// keyringApps are the application attributes of the keys in the Secret
+// Service keyring
+var keyringApps = map[string]string{
+	"chrome":   "chrome",
+	"chromium": "chromium",
+	"edge":     "microsoft-edge",
+}
+
+func linuxKey(password string) []byte {
+	return pbkdf2.Key([]byte(password), []byte("saltysalt"), 1, 16, sha1.New)
+}
+
+// chromeKey gets the v11 key from the desktop's keyring. Browsers that
+// found no keyring use the fixed v10 one instead.
+func chromeKey(browser, root string) ([]byte, error) {
+	product := chromeProducts[browser]
+	tries := [][]string{
+		{"secret-tool", "lookup", "application", keyringApps[browser]},
+		{"kwallet-query", "--read-password", product + " Safe Storage", "--folder", product + " Keys", "kdewallet"},
+	}
+	for _, args := range tries {
+		if _, err := exec.LookPath(args[0]); err != nil {
+			continue
+		}
+		out, err := exec.Command(args[0], args[1:]...).Output()
+		if pass := strings.TrimRight(string(out), "\n"); err == nil && pass != "" && !strings.HasPrefix(pass, "Failed to read") {
+			return linuxKey(pass), nil
+		}
+	}
+	return nil, fmt.Errorf("no %s Safe Storage password in the keyring (tried secret-tool and kwallet-query)", product)
+}
+
+func decryptValue(key []byte, keyErr error, enc []byte) ([]byte, error) {
+	switch {
+	case bytes.HasPrefix(enc, []byte("v10")):
+		return decryptCBC(linuxKey("peanuts"), enc[3:])
+	case bytes.HasPrefix(enc, []byte("v11")):
+		if keyErr == nil {
+			if v, err := decryptCBC(key, enc[3:]); err == nil {
+				return v, nil
+			}
+		}
+		// Written while the keyring couldn't be reached
+		if v, err := decryptCBC(linuxKey(""), enc[3:]); err == nil {
+			return v, nil
+		}
+		if keyErr != nil {
+			return nil, keyErr
+		}
+		return nil, errDecrypt
+	}
+	return nil, errors.New("unknown cookie encryption")
+}
@@ -0,0 +1,92 @@
+package cookies
+
+import (
+	"bytes"
+	"crypto/aes"
+	"crypto/cipher"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"os"
+	"path/filepath"
+	"unsafe"
+
+	"golang.org/x/sys/windows"
+)
+
+func firefoxRoots() []string {
+	return []string{filepath.Join(os.Getenv("APPDATA"), "Mozilla", "Firefox", "Profiles")}
+}
+
+func chromeRoots(browser string) []string {
+	local := os.Getenv("LOCALAPPDATA")
+	switch browser {
+	case "chrome":
+		return []string{filepath.Join(local, "Google", "Chrome", "User Data")}
+	case "chromium":
+		return []string{filepath.Join(local, "Chromium", "User Data")}
+	default:
+		return []string{filepath.Join(local, "Microsoft", "Edge", "User Data")}
+	}
+}
+
+// chromeKey reads the AES key from Local State, protected with DPAPI for
+// the logged in user
+func chromeKey(browser, root string) ([]byte, error) {
+	data, err := os.ReadFile(filepath.Join(root, "Local State"))
+	if err != nil {
+		return nil, err
+	}
+	var state struct {
+		OSCrypt struct {
+			EncryptedKey string `json:"encrypted_key"`
+		} `json:"os_crypt"`
+	}
+	if err := json.Unmarshal(data, &state); err != nil {
+		return nil, err
+	}
+	key, err := base64.StdEncoding.DecodeString(state.OSCrypt.EncryptedKey)
+	if err != nil || !bytes.HasPrefix(key, []byte("DPAPI")) {
+		return nil, errors.New("no cookie key in Local State")
+	}
+	return unprotect(key[5:])
+}
+
+func unprotect(data []byte) ([]byte, error) {
+	if len(data) == 0 {
+		return nil, errDecrypt
+	}
+	in := windows.DataBlob{Size: uint32(len(data)), Data: &data[0]}
+	var out windows.DataBlob
+	if err := windows.CryptUnprotectData(&in, nil, nil, 0, nil, 0, &out); err != nil {
+		return nil, err
+	}
+	defer windows.LocalFree(windows.Handle(unsafe.Pointer(out.Data)))
+	return append([]byte(nil), unsafe.Slice(out.Data, out.Size)...), nil
+}
+
+func decryptValue(key []byte, keyErr error, enc []byte) ([]byte, error) {
+	switch {
+	case bytes.HasPrefix(enc, []byte("v20")):
+		return nil, errors.New("the cookies use app-bound encryption, which only the browser itself can decrypt")
+	case bytes.HasPrefix(enc, []byte("v10")), bytes.HasPrefix(enc, []byte("v11")):
+		if keyErr != nil {
+			return nil, keyErr
+		}
+		block, err := aes.NewCipher(key)
+		if err != nil {
+			return nil, err
+		}
+		gcm, err := cipher.NewGCM(block)
+		if err != nil {
+			return nil, err
+		}
+		if len(enc) < 3+gcm.NonceSize() {
+			return nil, errDecrypt
+		}
+		nonce, data := enc[3:3+gcm.NonceSize()], enc[3+gcm.NonceSize():]
+		return gcm.Open(nil, nonce, data, nil)
+	}
+	// Values from before Chrome 80 are DPAPI blobs
+	return unprotect(enc)
+}
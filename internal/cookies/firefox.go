@@ -0,0 +1,63 @@
+package cookies
+
+import (
+	"path/filepath"
+	"time"
+)
+
+// firefoxCookies reads cookies.sqlite of the profile, by default the one
+// used last
+func firefoxCookies(profile string) ([]browserCookie, error) {
+	var patterns []string
+	for _, root := range firefoxRoots() {
+		if profile != "" {
+			// Profile directories are named like abcd1234.default-release,
+			// the part after the dot will do
+			patterns = append(patterns, filepath.Join(profileDir(root, profile), "cookies.sqlite"),
+				filepath.Join(root, "*."+profile, "cookies.sqlite"))
+		} else {
+			patterns = append(patterns, filepath.Join(root, "*", "cookies.sqlite"))
+		}
+	}
+	path, err := newest(patterns...)
+	if err != nil {
+		return nil, err
+	}
+	db, err := openSQLite(path)
+	if err != nil {
+		return nil, err
+	}
+	cols, rows, err := db.table("moz_cookies")
+	if err != nil {
+		return nil, err
+	}
+	idx, err := columns(cols, "host", "name", "value", "path", "expiry", "isSecure")
+	if err != nil {
+		return nil, err
+	}
+	attrs, _ := columns(cols, "originAttributes")
+
+	var out []browserCookie
+	for _, row := range rows {
+		// Containers, private windows and third-party partitions keep
+		// their own cookies, the downloads get the ordinary ones
+		if attrs != nil && toString(row[attrs[0]]) != "" {
+			continue
+		}
+		c := browserCookie{
+			Host:   toString(row[idx[0]]),
+			Name:   toString(row[idx[1]]),
+			Value:  toString(row[idx[2]]),
+			Path:   toString(row[idx[3]]),
+			Secure: toInt(row[idx[5]]) != 0,
+		}
+		if exp := toInt(row[idx[4]]); exp > 1e11 {
+			// Newer versions store milliseconds
+			c.Expires = time.UnixMilli(exp)
+		} else if exp > 0 {
+			c.Expires = time.Unix(exp, 0)
+		}
+		out = append(out, c)
+	}
+	return out, nil
+}
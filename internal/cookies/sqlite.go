@@ -0,0 +1,392 @@
+package cookies
+
+import (
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"math"
+	"os"
+	"strings"
+)
+
+// sqliteDB reads the tables of a SQLite 3 file, enough for the browsers'
+// cookie stores. The file is read into memory in one go, with the pages of
+// its write-ahead log on top, so the browser can keep it open meanwhile.
+type sqliteDB struct {
+	data     []byte
+	pageSize int
+	usable   int            // Page size less the reserved bytes at the end of each page
+	wal      map[int][]byte // Newer versions of pages, from the -wal file
+}
+
+var errNotSQLite = errors.New("not a SQLite 3 database")
+
+func openSQLite(path string) (*sqliteDB, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	if len(data) < 100 || string(data[:16]) != "SQLite format 3\x00" {
+		return nil, errNotSQLite
+	}
+	db := &sqliteDB{data: data, pageSize: int(binary.BigEndian.Uint16(data[16:]))}
+	if db.pageSize == 1 {
+		db.pageSize = 65536
+	}
+	if db.pageSize < 512 || db.pageSize&(db.pageSize-1) != 0 {
+		return nil, errNotSQLite
+	}
+	db.usable = db.pageSize - int(data[20])
+	if enc := binary.BigEndian.Uint32(data[56:]); enc > 1 {
+		return nil, errors.New("SQLite database is not UTF-8")
+	}
+	if wal, err := os.ReadFile(path + "-wal"); err == nil {
+		db.wal = readWAL(wal, db.pageSize)
+	}
+	return db, nil
+}
+
+// readWAL returns the pages of the committed frames of a write-ahead log.
+// Frames past the last commit or of an older log generation are ignored.
+func readWAL(b []byte, pageSize int) map[int][]byte {
+	if len(b) < 32 {
+		return nil
+	}
+	magic := binary.BigEndian.Uint32(b)
+	if magic&^1 != 0x377f0682 || int(binary.BigEndian.Uint32(b[8:])) != pageSize {
+		return nil
+	}
+	var order binary.ByteOrder = binary.LittleEndian
+	if magic&1 == 1 {
+		order = binary.BigEndian
+	}
+	s0, s1 := walChecksum(order, 0, 0, b[:24])
+	if s0 != binary.BigEndian.Uint32(b[24:]) || s1 != binary.BigEndian.Uint32(b[28:]) {
+		return nil
+	}
+
+	pages, committed := map[int][]byte{}, map[int][]byte{}
+	for off := 32; off+24+pageSize <= len(b); off += 24 + pageSize {
+		h := b[off : off+24]
+		if string(h[8:16]) != string(b[16:24]) {
+			break
+		}
+		s0, s1 = walChecksum(order, s0, s1, h[:8])
+		s0, s1 = walChecksum(order, s0, s1, b[off+24:off+24+pageSize])
+		if s0 != binary.BigEndian.Uint32(h[16:]) || s1 != binary.BigEndian.Uint32(h[20:]) {
+			break
+		}
+		pages[int(binary.BigEndian.Uint32(h))] = b[off+24 : off+24+pageSize]
+		if binary.BigEndian.Uint32(h[4:]) != 0 {
+			// A commit, everything up to here is in the database
+			for n, p := range pages {
+				committed[n] = p
+			}
+		}
+	}
+	return committed
+}
+
+func walChecksum(order binary.ByteOrder, s0, s1 uint32, b []byte) (uint32, uint32) {
+	for i := 0; i+8 <= len(b); i += 8 {
+		s0 += order.Uint32(b[i:]) + s1
+		s1 += order.Uint32(b[i+4:]) + s0
+	}
+	return s0, s1
+}
+
+func (db *sqliteDB) page(n int) ([]byte, error) {
+	if p, ok := db.wal[n]; ok {
+		return p, nil
+	}
+	off := (n - 1) * db.pageSize
+	if n < 1 || off+db.pageSize > len(db.data) {
+		return nil, fmt.Errorf("SQLite page %d out of range", n)
+	}
+	return db.data[off : off+db.pageSize], nil
+}
+
+// table returns the column names and rows of a table. Rows written before
+// columns were added get nil for those.
+func (db *sqliteDB) table(name string) ([]string, [][]any, error) {
+	var root int
+	var sql string
+	err := db.walk(1, func(rowid int64, rec []any) error {
+		if len(rec) >= 5 && rec[0] == "table" && strings.EqualFold(fmt.Sprint(rec[1]), name) {
+			root = int(toInt(rec[3]))
+			sql, _ = rec[4].(string)
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, nil, err
+	}
+	if root == 0 {
+		return nil, nil, fmt.Errorf("no table %s in the database", name)
+	}
+	cols, rowidCol := parseColumns(sql)
+
+	var rows [][]any
+	err = db.walk(root, func(rowid int64, rec []any) error {
+		row := make([]any, len(cols))
+		copy(row, rec)
+		if rowidCol >= 0 {
+			// An INTEGER PRIMARY KEY is the rowid, stored as NULL
+			row[rowidCol] = rowid
+		}
+		rows = append(rows, row)
+		return nil
+	})
+	return cols, rows, err
+}
+
+// walk calls fn with each row of the table b-tree rooted at page root
+func (db *sqliteDB) walk(root int, fn func(rowid int64, rec []any) error) error {
+	visited := map[int]bool{}
+	var visit func(n int) error
+	visit = func(n int) error {
+		if visited[n] {
+			return errors.New("SQLite b-tree loops")
+		}
+		visited[n] = true
+		p, err := db.page(n)
+		if err != nil {
+			return err
+		}
+		h := p
+		if n == 1 {
+			h = p[100:]
+		}
+		cells := int(binary.BigEndian.Uint16(h[3:]))
+		switch h[0] {
+		case 5: // Interior: child pages left of each cell, and the right-most one
+			if 12+2*cells > len(h) {
+				return errCorrupt
+			}
+			ptrs := h[12:]
+			for i := 0; i < cells; i++ {
+				off := int(binary.BigEndian.Uint16(ptrs[2*i:]))
+				if off+4 > len(p) {
+					return errCorrupt
+				}
+				if err := visit(int(binary.BigEndian.Uint32(p[off:]))); err != nil {
+					return err
+				}
+			}
+			return visit(int(binary.BigEndian.Uint32(h[8:])))
+		case 13: // Leaf
+			if 8+2*cells > len(h) {
+				return errCorrupt
+			}
+			ptrs := h[8:]
+			for i := 0; i < cells; i++ {
+				rowid, rec, err := db.cell(p, int(binary.BigEndian.Uint16(ptrs[2*i:])))
+				if err != nil {
+					return err
+				}
+				if err := fn(rowid, rec); err != nil {
+					return err
+				}
+			}
+			return nil
+		}
+		return fmt.Errorf("SQLite page %d is not a table page", n)
+	}
+	return visit(root)
+}
+
+var errCorrupt = errors.New("SQLite database is corrupt")
+
+// cell decodes the table leaf cell at off of page p
+func (db *sqliteDB) cell(p []byte, off int) (int64, []any, error) {
+	if off >= len(p) {
+		return 0, nil, errCorrupt
+	}
+	size, n := varint(p[off:])
+	off += n
+	rowid, n := varint(p[off:])
+	off += n
+
+	// The start of a large payload is on the page, the rest on a chain of
+	// overflow pages
+	total := int(size)
+	local := total
+	u := db.usable
+	if most := u - 35; total > most {
+		least := (u-12)*32/255 - 23
+		local = least + (total-least)%(u-4)
+		if local > most {
+			local = least
+		}
+	}
+	if off+local > len(p) || total < 0 {
+		return 0, nil, errCorrupt
+	}
+	payload := p[off : off+local]
+	if local < total {
+		if off+local+4 > len(p) {
+			return 0, nil, errCorrupt
+		}
+		payload = append([]byte(nil), payload...)
+		next := int(binary.BigEndian.Uint32(p[off+local:]))
+		seen := map[int]bool{}
+		for len(payload) < total && next != 0 {
+			if seen[next] {
+				return 0, nil, errCorrupt
+			}
+			seen[next] = true
+			op, err := db.page(next)
+			if err != nil {
+				return 0, nil, err
+			}
+			next = int(binary.BigEndian.Uint32(op))
+			take := min(total-len(payload), u-4)
+			payload = append(payload, op[4:4+take]...)
+		}
+		if len(payload) < total {
+			return 0, nil, errCorrupt
+		}
+	}
+	rec, err := record(payload)
+	return int64(rowid), rec, err
+}
+
+// record decodes a row: a header of serial types, then the values
+func record(b []byte) ([]any, error) {
+	hsize, n := varint(b)
+	if n == 0 || hsize > uint64(len(b)) {
+		return nil, errCorrupt
+	}
+	var types []uint64
+	for off := n; off < int(hsize); {
+		t, n := varint(b[off:int(hsize)])
+		if n == 0 {
+			return nil, errCorrupt
+		}
+		types = append(types, t)
+		off += n
+	}
+
+	body := b[hsize:]
+	values := make([]any, len(types))
+	for i, t := range types {
+		var size int
+		switch {
+		case t == 0 || t == 8 || t == 9:
+		case t <= 4:
+			size = int(t)
+		case t == 5:
+			size = 6
+		case t == 6 || t == 7:
+			size = 8
+		case t >= 12:
+			size = int(t-12) / 2
+		default:
+			return nil, errCorrupt
+		}
+		if size < 0 || size > len(body) {
+			return nil, errCorrupt
+		}
+		v := body[:size]
+		body = body[size:]
+		switch {
+		case t == 0:
+			values[i] = nil
+		case t == 8:
+			values[i] = int64(0)
+		case t == 9:
+			values[i] = int64(1)
+		case t == 7:
+			values[i] = math.Float64frombits(binary.BigEndian.Uint64(v))
+		case t <= 6:
+			// Big-endian two's complement of the size
+			x := int64(int8(v[0]))
+			for _, c := range v[1:] {
+				x = x<<8 | int64(c)
+			}
+			values[i] = x
+		case t%2 == 0:
+			values[i] = append([]byte(nil), v...)
+		default:
+			values[i] = string(v)
+		}
+	}
+	return values, nil
+}
+
+// varint reads a SQLite variable length integer and returns its size, 0 if
+// b ends first
+func varint(b []byte) (uint64, int) {
+	var x uint64
+	for i := 0; i < 9 && i < len(b); i++ {
+		if i == 8 {
+			return x<<8 | uint64(b[i]), 9
+		}
+		x = x<<7 | uint64(b[i]&0x7f)
+		if b[i] < 0x80 {
+			return x, i + 1
+		}
+	}
+	return 0, 0
+}
+
+// parseColumns takes the column names from a CREATE TABLE statement and
+// the index of the one that is the rowid, -1 if none is
+func parseColumns(sql string) ([]string, int) {
+	open, end := strings.Index(sql, "("), strings.LastIndex(sql, ")")
+	if open < 0 || end < open {
+		return nil, -1
+	}
+	var defs []string
+	depth, start := 0, open+1
+	var quote byte
+	for i := open + 1; i < end; i++ {
+		c := sql[i]
+		switch {
+		case quote != 0:
+			if c == quote {
+				quote = 0
+			}
+		case c == '\'' || c == '"' || c == '`':
+			quote = c
+		case c == '[':
+			quote = ']'
+		case c == '(':
+			depth++
+		case c == ')':
+			depth--
+		case c == ',' && depth == 0:
+			defs = append(defs, sql[start:i])
+			start = i + 1
+		}
+	}
+	defs = append(defs, sql[start:end])
+
+	var cols []string
+	rowid := -1
+	for _, d := range defs {
+		fields := strings.Fields(d)
+		if len(fields) == 0 {
+			continue
+		}
+		switch strings.ToUpper(strings.SplitN(fields[0], "(", 2)[0]) {
+		case "CONSTRAINT", "PRIMARY", "UNIQUE", "CHECK", "FOREIGN":
+			continue
+		}
+		if strings.Contains(strings.ToUpper(strings.Join(fields, " ")), "INTEGER PRIMARY KEY") {
+			rowid = len(cols)
+		}
+		cols = append(cols, strings.Trim(fields[0], "\"`[]'"))
+	}
+	return cols, rowid
+}
+
+func toInt(v any) int64 {
+	switch v := v.(type) {
+	case int64:
+		return v
+	case float64:
+		return int64(v)
+	}
+	return 0
+}
@@ -0,0 +1,312 @@
+package cookies
+
+import (
+	"encoding/binary"
+	"math"
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+)
+
+const testPageSize = 512
+
+const testSchema = "CREATE TABLE cookies (id INTEGER PRIMARY KEY, host TEXT NOT NULL, value BLOB, expiry INTEGER, score REAL)"
+
+// sqliteVarint encodes x the way varint reads it, for x below 1<<56
+func sqliteVarint(x uint64) []byte {
+	b := []byte{byte(x & 0x7f)}
+	for x >>= 7; x > 0; x >>= 7 {
+		b = append([]byte{byte(x&0x7f) | 0x80}, b...)
+	}
+	return b
+}
+
+// testRecord encodes a row: nil, int64, float64, string or []byte values
+func testRecord(vals ...any) []byte {
+	var types, body []byte
+	for _, v := range vals {
+		switch v := v.(type) {
+		case nil:
+			types = append(types, 0)
+		case int64:
+			types = append(types, 6)
+			body = binary.BigEndian.AppendUint64(body, uint64(v))
+		case float64:
+			types = append(types, 7)
+			body = binary.BigEndian.AppendUint64(body, math.Float64bits(v))
+		case string:
+			types = append(types, sqliteVarint(uint64(13+2*len(v)))...)
+			body = append(body, v...)
+		case []byte:
+			types = append(types, sqliteVarint(uint64(12+2*len(v)))...)
+			body = append(body, v...)
+		}
+	}
+	// The header size counts itself, one byte for these small records
+	return append(append([]byte{byte(1 + len(types))}, types...), body...)
+}
+
+func testCell(rowid int64, vals ...any) []byte {
+	payload := testRecord(vals...)
+	cell := append(sqliteVarint(uint64(len(payload))), sqliteVarint(uint64(rowid))...)
+	return append(cell, payload...)
+}
+
+// testPage lays out a table leaf page, the first one after the file header
+func testPage(first bool, cells ...[]byte) []byte {
+	p := make([]byte, testPageSize)
+	h := p
+	if first {
+		h = p[100:]
+	}
+	h[0] = 13
+	binary.BigEndian.PutUint16(h[3:], uint16(len(cells)))
+	end := len(p)
+	for i, c := range cells {
+		end -= len(c)
+		copy(p[end:], c)
+		binary.BigEndian.PutUint16(h[8+2*i:], uint16(end))
+	}
+	binary.BigEndian.PutUint16(h[5:], uint16(end))
+	return p
+}
+
+// testDB is a database with the cookies table rooted at page 2
+func testDB(rows ...[]byte) []byte {
+	db := testPage(true, testCell(1, "table", "cookies", "cookies", int64(2), testSchema))
+	copy(db, "SQLite format 3\x00")
+	binary.BigEndian.PutUint16(db[16:], testPageSize)
+	binary.BigEndian.PutUint32(db[56:], 1) // UTF-8
+	return append(db, testPage(false, rows...)...)
+}
+
+type testFrame struct {
+	page   int
+	commit bool
+	data   []byte
+}
+
+// testWAL is a write-ahead log with big-endian checksums
+func testWAL(frames ...testFrame) []byte {
+	b := make([]byte, 32)
+	binary.BigEndian.PutUint32(b, 0x377f0683)
+	binary.BigEndian.PutUint32(b[4:], 3007000)
+	binary.BigEndian.PutUint32(b[8:], testPageSize)
+	copy(b[16:24], "saltsalt")
+	s0, s1 := walChecksum(binary.BigEndian, 0, 0, b[:24])
+	binary.BigEndian.PutUint32(b[24:], s0)
+	binary.BigEndian.PutUint32(b[28:], s1)
+	for _, f := range frames {
+		h := make([]byte, 24)
+		binary.BigEndian.PutUint32(h, uint32(f.page))
+		if f.commit {
+			binary.BigEndian.PutUint32(h[4:], 2)
+		}
+		copy(h[8:16], "saltsalt")
+		s0, s1 = walChecksum(binary.BigEndian, s0, s1, h[:8])
+		s0, s1 = walChecksum(binary.BigEndian, s0, s1, f.data)
+		binary.BigEndian.PutUint32(h[16:], s0)
+		binary.BigEndian.PutUint32(h[20:], s1)
+		b = append(append(b, h...), f.data...)
+	}
+	return b
+}
+
+func writeDB(t *testing.T, db, wal []byte) string {
+	t.Helper()
+	p := filepath.Join(t.TempDir(), "Cookies")
+	if err := os.WriteFile(p, db, 0o600); err != nil {
+		t.Fatal(err)
+	}
+	if wal != nil {
+		if err := os.WriteFile(p+"-wal", wal, 0o600); err != nil {
+			t.Fatal(err)
+		}
+	}
+	return p
+}
+
+func TestSQLiteTable(t *testing.T) {
+	db := testDB(
+		testCell(1, nil, "example.com", []byte("v1"), int64(1700000000), 0.5),
+		testCell(7, nil, ".example.org", []byte("v2"), int64(-1)),
+	)
+	newer := testPage(false, testCell(3, nil, "wal.example", []byte("v3"), int64(42), 1.5))
+	uncommitted := testPage(false, testCell(4, nil, "lost.example", []byte("v4"), int64(0), 0.0))
+
+	tests := []struct {
+		name string
+		wal  []byte
+		want [][]any
+	}{
+		{
+			name: "no log",
+			want: [][]any{
+				{int64(1), "example.com", []byte("v1"), int64(1700000000), 0.5},
+				{int64(7), ".example.org", []byte("v2"), int64(-1), nil},
+			},
+		},
+		{
+			name: "committed frame",
+			wal:  testWAL(testFrame{2, true, newer}, testFrame{2, false, uncommitted}),
+			want: [][]any{{int64(3), "wal.example", []byte("v3"), int64(42), 1.5}},
+		},
+		{
+			name: "truncated log",
+			wal:  testWAL(testFrame{2, true, newer})[:100],
+			want: [][]any{
+				{int64(1), "example.com", []byte("v1"), int64(1700000000), 0.5},
+				{int64(7), ".example.org", []byte("v2"), int64(-1), nil},
+			},
+		},
+	}
+	for _, tt := range tests {
+		sq, err := openSQLite(writeDB(t, db, tt.wal))
+		if err != nil {
+			t.Fatalf("%s: %v", tt.name, err)
+		}
+		cols, rows, err := sq.table("cookies")
+		if err != nil {
+			t.Errorf("%s: %v", tt.name, err)
+			continue
+		}
+		if want := []string{"id", "host", "value", "expiry", "score"}; !reflect.DeepEqual(cols, want) {
+			t.Errorf("%s: columns %q", tt.name, cols)
+		}
+		if !reflect.DeepEqual(rows, tt.want) {
+			t.Errorf("%s:\ngot  %v\nwant %v", tt.name, rows, tt.want)
+		}
+	}
+
+	// A log whose frames don't add up is ignored
+	wal := testWAL(testFrame{2, true, newer})
+	wal[100] ^= 0xff
+	sq, err := openSQLite(writeDB(t, db, wal))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, rows, err := sq.table("cookies"); err != nil || len(rows) != 2 {
+		t.Errorf("bad checksum: %v, %v", rows, err)
+	}
+}
+
+func TestSQLiteCorrupt(t *testing.T) {
+	good := testDB(testCell(1, nil, "example.com", []byte("v1"), int64(1), 0.5))
+	corrupt := func(change func(db []byte) []byte) []byte {
+		return change(append([]byte(nil), good...))
+	}
+	page2 := testPageSize
+
+	tests := []struct {
+		name string
+		db   []byte
+	}{
+		{"empty", nil},
+		{"truncated header", good[:60]},
+		{"not SQLite", corrupt(func(db []byte) []byte { copy(db, "SQLite format 2"); return db })},
+		{"odd page size", corrupt(func(db []byte) []byte { binary.BigEndian.PutUint16(db[16:], 1000); return db })},
+		{"UTF-16", corrupt(func(db []byte) []byte { binary.BigEndian.PutUint32(db[56:], 2); return db })},
+		{"truncated table page", good[:testPageSize+100]},
+		{"only the schema page", good[:testPageSize]},
+		{"not a table page", corrupt(func(db []byte) []byte { db[page2] = 10; return db })},
+		{"cell count past the page", corrupt(func(db []byte) []byte { binary.BigEndian.PutUint16(db[page2+3:], 0xffff); return db })},
+		{"cell past the page", corrupt(func(db []byte) []byte { binary.BigEndian.PutUint16(db[page2+8:], 0xffff); return db })},
+		{"b-tree loop", corrupt(func(db []byte) []byte {
+			db[page2] = 5
+			binary.BigEndian.PutUint16(db[page2+3:], 0)
+			binary.BigEndian.PutUint32(db[page2+8:], 2)
+			return db
+		})},
+		{"child past the end", corrupt(func(db []byte) []byte {
+			db[page2] = 5
+			binary.BigEndian.PutUint16(db[page2+3:], 0)
+			binary.BigEndian.PutUint32(db[page2+8:], 99)
+			return db
+		})},
+		{"payload past the page", corrupt(func(db []byte) []byte {
+			off := int(binary.BigEndian.Uint16(db[page2+8:]))
+			db[page2+off] = 0x7f
+			return db
+		})},
+		{"overflow chain loop", func() []byte {
+			// A payload larger than a page, whose chain comes back to itself
+			db := append([]byte(nil), good...)
+			local := (testPageSize-12)*32/255 - 23
+			local += (5000 - local) % (testPageSize - 4)
+			cell := append(sqliteVarint(5000), 1)
+			cell = append(cell, make([]byte, local+4)...)
+			binary.BigEndian.PutUint32(cell[3+local:], 3)
+			overflow := make([]byte, testPageSize)
+			binary.BigEndian.PutUint32(overflow, 3)
+			db = append(append(db[:page2], testPage(false, cell)...), overflow...)
+			return db
+		}()},
+	}
+	for _, tt := range tests {
+		sq, err := openSQLite(writeDB(t, tt.db, nil))
+		if err != nil {
+			continue
+		}
+		if cols, rows, err := sq.table("cookies"); err == nil {
+			t.Errorf("%s: read %q, %v", tt.name, cols, rows)
+		}
+	}
+
+	sq, err := openSQLite(writeDB(t, good, nil))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, _, err := sq.table("moz_cookies"); err == nil {
+		t.Error("read a missing table")
+	}
+}
+
+func TestRecord(t *testing.T) {
+	tests := []struct {
+		name string
+		b    []byte
+		want []any
+	}{
+		{"values", testRecord(nil, int64(-2), 2.5, "a", []byte{1}), []any{nil, int64(-2), 2.5, "a", []byte{1}}},
+		{"constants", []byte{3, 8, 9}, []any{int64(0), int64(1)}},
+		{"small ints", []byte{4, 1, 2, 5, 0xff, 0x01, 0x00, 0, 0, 0, 0, 0, 1}, []any{int64(-1), int64(256), int64(1)}},
+		{"empty", nil, nil},
+		{"header past the end", []byte{9, 1}, nil},
+		{"header size past 64 bits", []byte{0xff, 0xff, 0xff, 0xff, 0xff, 0xff, 0xff, 0xff, 0xff}, nil},
+		{"truncated header varint", []byte{3, 1, 0x81}, nil},
+		{"reserved type", []byte{2, 10}, nil},
+		{"value past the end", []byte{2, 19, 'a'}, nil},
+		{"huge blob", append([]byte{9}, sqliteVarint(1<<55)...), nil},
+		{"type past 63 bits", []byte{10, 0xff, 0xff, 0xff, 0xff, 0xff, 0xff, 0xff, 0xff, 0xff}, nil},
+	}
+	for _, tt := range tests {
+		got, err := record(tt.b)
+		if (err == nil) != (tt.want != nil) {
+			t.Errorf("%s: %v, %v", tt.name, got, err)
+			continue
+		}
+		if tt.want != nil && !reflect.DeepEqual(got, tt.want) {
+			t.Errorf("%s: got %v, want %v", tt.name, got, tt.want)
+		}
+	}
+}
+
+func TestParseColumns(t *testing.T) {
+	tests := []struct {
+		sql   string
+		cols  []string
+		rowid int
+	}{
+		{testSchema, []string{"id", "host", "value", "expiry", "score"}, 0},
+		{`CREATE TABLE moz_cookies (id INTEGER, "name" TEXT DEFAULT 'a,b', [host] TEXT, expiry DECIMAL(10, 2), PRIMARY KEY (id), UNIQUE (name, host))`, []string{"id", "name", "host", "expiry"}, -1},
+		{"CREATE TABLE t (`a` TEXT, b INTEGER PRIMARY KEY AUTOINCREMENT)", []string{"a", "b"}, 1},
+		{"CREATE TABLE t", nil, -1},
+	}
+	for _, tt := range tests {
+		cols, rowid := parseColumns(tt.sql)
+		if !reflect.DeepEqual(cols, tt.cols) || rowid != tt.rowid {
+			t.Errorf("parseColumns(%q) = %q, %d", tt.sql, cols, rowid)
+		}
+	}
+}
@@ -0,0 +1,126 @@
+package daemon
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/url"
+	"strings"
+)
+
+// ExtensionConfig lets browser extensions hand downloads to the daemon on
+// /api/extension, with the browser's cookies and headers so downloads from
+// sites the user is logged in to work
+type ExtensionConfig struct {
+	// Origins allowed to call it, e.g. "chrome-extension://<id>". Default
+	// is any extension, never web pages
+	Origins []string `yaml:"origins"`
+}
+
+// extensionSchemes are the origins of browser extensions
+var extensionSchemes = []string{"chrome-extension", "moz-extension", "safari-web-extension"}
+
+func (c ExtensionConfig) allows(origin string) bool {
+	if len(c.Origins) > 0 {
+		return contains(c.Origins, origin)
+	}
+	u, err := url.Parse(origin)
+	return err == nil && contains(extensionSchemes, u.Scheme)
+}
+
+// cors answers the preflight of the browser and refuses calls from pages
+// of origins that aren't allowed
+func (s *Server) cors(next http.HandlerFunc) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		h := w.Header()
+		h.Add("Vary", "Origin")
+		if origin := r.Header.Get("Origin"); origin != "" {
+			if !s.cfg.Extension.allows(origin) {
+				writeError(w, http.StatusForbidden, "origin "+origin+" may not use the extension API")
+				return
+			}
+			h.Set("Access-Control-Allow-Origin", origin)
+		}
+		if r.Method == http.MethodOptions {
+			h.Set("Access-Control-Allow-Methods", "POST")
+			h.Set("Access-Control-Allow-Headers", "Authorization, Content-Type")
+			h.Set("Access-Control-Max-Age", "600")
+			// Chrome asks before pages and extensions reach localhost
+			if r.Header.Get("Access-Control-Request-Private-Network") == "true" {
+				h.Set("Access-Control-Allow-Private-Network", "true")
+			}
+			w.WriteHeader(http.StatusNoContent)
+			return
+		}
+		next(w, r)
+	}
+}
+
+// browserCookie is a cookie as the extensions' cookies API returns it
+type browserCookie struct {
+	Name     string `json:"name"`
+	Value    string `json:"value"`
+	Domain   string `json:"domain"`
+	Path     string `json:"path"`
+	Secure   bool   `json:"secure"`
+	HostOnly bool   `json:"hostOnly"`
+}
+
+type extensionRequest struct {
+	addRequest
+	Referer   string            `json:"referer"`
+	UserAgent string            `json:"user_agent"`
+	Headers   map[string]string `json:"headers"`
+	Cookies   []browserCookie   `json:"cookies"`
+}
+
+// droppedHeaders are left to the downloader, which sets them per request,
+// or would follow redirects to other sites
+var droppedHeaders = map[string]bool{
+	"Host": true, "Content-Length": true, "Connection": true, "Keep-Alive": true,
+	"Transfer-Encoding": true, "Te": true, "Trailer": true, "Upgrade": true,
+	"Range": true, "If-Range": true, "Accept-Encoding": true,
+	"Authorization": true, "Proxy-Authorization": true,
+}
+
+func (s *Server) handleExtension(w http.ResponseWriter, r *http.Request, tok *Token) {
+	if r.Method != http.MethodPost {
+		writeError(w, http.StatusMethodNotAllowed, "use POST")
+		return
+	}
+	var req extensionRequest
+	if err := json.NewDecoder(http.MaxBytesReader(w, r.Body, 256*1024)).Decode(&req); err != nil {
+		writeError(w, http.StatusBadRequest, "invalid JSON body")
+		return
+	}
+	dir, name, category, status, err := s.resolveAdd(tok, req.addRequest)
+	if err != nil {
+		writeError(w, status, err.Error())
+		return
+	}
+
+	it := &Item{URL: req.URL, Category: category, Dir: dir, Name: name, Owner: tok.Name, Guest: tok.Scope == ScopeGuest, Headers: http.Header{}}
+	for k, v := range req.Headers {
+		k = http.CanonicalHeaderKey(strings.TrimSpace(k))
+		switch {
+		case k == "Cookie":
+			// Cookies of the page's request, for the URL's site only
+			it.Cookies = append(it.Cookies, (&http.Request{Header: http.Header{"Cookie": {v}}}).Cookies()...)
+		case !droppedHeaders[k] && !strings.HasPrefix(k, "Proxy-") && k != "":
+			it.Headers.Set(k, v)
+		}
+	}
+	if req.Referer != "" {
+		it.Headers.Set("Referer", req.Referer)
+	}
+	if req.UserAgent != "" {
+		it.Headers.Set("User-Agent", req.UserAgent)
+	}
+	for _, c := range req.Cookies {
+		domain := c.Domain
+		if c.HostOnly {
+			domain = ""
+		}
+		it.Cookies = append(it.Cookies, &http.Cookie{Name: c.Name, Value: c.Value, Domain: domain, Path: c.Path, Secure: c.Secure})
+	}
+	writeJSON(w, http.StatusCreated, s.m.add(it))
+}
@@ -0,0 +1,101 @@
+package daemon
+
+import (
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"sort"
+	"strings"
+
+	"warp-dl/internal/downloader"
+)
+
+// Metrics is a snapshot of the queue for monitoring
+type Metrics struct {
+	Downloaded int64              // Bytes downloaded since the daemon started
+	States     map[State]int      // Items in the queue by state
+	Finished   map[State]int64    // Items that ended since the start, by state
+	HostSpeed  map[string]float64 // Bytes per second of running items by host
+}
+
+// account adds what the item downloaded since the last call to the total,
+// with the manager lock held
+func (m *Manager) account(it *Item) {
+	if it.task == nil {
+		return
+	}
+	if d := it.task.Progress().GetDownloaded() - it.counted; d > 0 {
+		m.downloaded += d
+		it.counted += d
+	}
+}
+
+// Metrics returns the current totals of the queue
+func (m *Manager) Metrics() Metrics {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	mt := Metrics{
+		States:    map[State]int{},
+		Finished:  map[State]int64{},
+		HostSpeed: map[string]float64{},
+	}
+	for _, it := range m.items {
+		mt.States[it.State]++
+		if it.State != StateRunning {
+			continue
+		}
+		m.account(it)
+		if u, err := url.Parse(it.URL); err == nil && u.Hostname() != "" && it.meter != nil {
+			mt.HostSpeed[u.Hostname()] += it.meter.Rate(speedWindow)
+		}
+	}
+	mt.Downloaded = m.downloaded
+	for state, n := range m.finished {
+		mt.Finished[state] = n
+	}
+	return mt
+}
+
+// handleMetrics serves /metrics in the Prometheus text format
+func (s *Server) handleMetrics(w http.ResponseWriter, r *http.Request, tok *Token) {
+	mt := s.m.Metrics()
+	c := downloader.ReadCounts()
+	w.Header().Set("Content-Type", "text/plain; version=0.0.4; charset=utf-8")
+
+	metric(w, "warp_dl_downloaded_bytes_total", "counter", "Bytes downloaded by the daemon.")
+	fmt.Fprintf(w, "warp_dl_downloaded_bytes_total %d\n", mt.Downloaded)
+
+	metric(w, "warp_dl_downloads", "gauge", "Downloads in the queue by state.")
+	for _, state := range []State{StateQueued, StateRunning, StateDone, StateFailed, StateCanceled} {
+		fmt.Fprintf(w, "warp_dl_downloads{state=%q} %d\n", state, mt.States[state])
+	}
+
+	metric(w, "warp_dl_downloads_finished_total", "counter", "Downloads that ended, including removed ones, by result.")
+	for _, state := range []State{StateDone, StateFailed, StateCanceled} {
+		fmt.Fprintf(w, "warp_dl_downloads_finished_total{state=%q} %d\n", state, mt.Finished[state])
+	}
+
+	metric(w, "warp_dl_host_speed_bytes", "gauge", "Download speed in bytes per second by host, over the last few seconds.")
+	hosts := make([]string, 0, len(mt.HostSpeed))
+	for h := range mt.HostSpeed {
+		hosts = append(hosts, h)
+	}
+	sort.Strings(hosts)
+	for _, h := range hosts {
+		fmt.Fprintf(w, "warp_dl_host_speed_bytes{host=\"%s\"} %g\n", labelEscaper.Replace(h), mt.HostSpeed[h])
+	}
+
+	metric(w, "warp_dl_retries_total", "counter", "Parts, segments and downloads tried again.")
+	fmt.Fprintf(w, "warp_dl_retries_total %d\n", c.Retries)
+	metric(w, "warp_dl_doh_lookups_total", "counter", "Host names resolved over DNS-over-HTTPS.")
+	fmt.Fprintf(w, "warp_dl_doh_lookups_total %d\n", c.DoHLookups)
+	metric(w, "warp_dl_doh_failures_total", "counter", "DNS-over-HTTPS lookups that no server answered.")
+	fmt.Fprintf(w, "warp_dl_doh_failures_total %d\n", c.DoHFailures)
+}
+
+func metric(w io.Writer, name, kind, help string) {
+	fmt.Fprintf(w, "# HELP %s %s\n# TYPE %s %s\n", name, help, name, kind)
+}
+
+var labelEscaper = strings.NewReplacer(`\`, `\\`, `"`, `\"`, "\n", `\n`)
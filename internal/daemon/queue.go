@@ -0,0 +1,390 @@
+// Package daemon runs downloads from a shared queue and serves the remote
+// control API and web dashboard.
+package daemon
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"path"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"warp-dl/internal/downloader"
+)
+
+// State is the lifecycle stage of a queue item
+type State string
+
+const (
+	StateQueued   State = "queued"
+	StateRunning  State = "running"
+	StateDone     State = "done"
+	StateFailed   State = "failed"
+	StateCanceled State = "canceled"
+)
+
+// Item is one queued download
+type Item struct {
+	ID       string
+	URL      string
+	Category string
+	Dir      string // Destination directory
+	Name     string // File name, empty to let the download pick one
+	Owner    string // Name of the token that added it
+	Guest    bool   // Added with a guest token, downloaded without the daemon's credentials
+	Mirrors  []string
+	Checksum *downloader.Checksum // Known up front, e.g. from a metalink
+	Headers  http.Header          // Extra request headers, e.g. the browser's Referer
+	Cookies  []*http.Cookie       // The browser's session for the URL's site
+
+	State    State
+	Err      string
+	Added    time.Time
+	Started  time.Time
+	Finished time.Time
+
+	task    downloader.Task
+	cancel  context.CancelFunc
+	meter   *downloader.SpeedMeter
+	counted int64 // Bytes of the task already in Manager.downloaded
+}
+
+// ItemStatus is the JSON view of an item
+type ItemStatus struct {
+	ID         string     `json:"id"`
+	URL        string     `json:"url"`
+	Category   string     `json:"category,omitempty"`
+	Dir        string     `json:"dir"`
+	Output     string     `json:"output,omitempty"` // Empty until known, the name may come from the download itself
+	Owner      string     `json:"owner"`
+	State      State      `json:"state"`
+	Error      string     `json:"error,omitempty"`
+	Downloaded int64      `json:"downloaded"`
+	Total      int64      `json:"total"`
+	AddedAt    time.Time  `json:"added_at"`
+	StartedAt  *time.Time `json:"started_at,omitempty"`
+	FinishedAt *time.Time `json:"finished_at,omitempty"`
+
+	Title        string     `json:"title"`                   // One line summary, e.g. for a window title
+	Speed        float64    `json:"speed"`                   // Bytes per second over the last few seconds
+	ETA          *time.Time `json:"eta,omitempty"`           // Estimated completion time
+	SpeedSamples []float64  `json:"speed_samples,omitempty"` // Per-second speeds, oldest first
+
+	// Strategy, connections and per-part progress for downloads that
+	// support inspection
+	*downloader.Inspection
+}
+
+const (
+	sampleInterval = time.Second
+	sampleHistory  = 60
+	speedWindow    = 5 * time.Second
+)
+
+// TaskFactory builds the download for an item
+type TaskFactory func(it *Item) (downloader.Task, error)
+
+// Manager runs queued items, at most MaxActive at a time
+type Manager struct {
+	newTask   TaskFactory
+	maxActive int
+
+	// OnDone is called with every task that completed, set it before Run
+	OnDone func(downloader.Task)
+
+	mu         sync.Mutex
+	items      []*Item
+	nextID     int
+	wake       chan struct{}
+	downloaded int64           // Bytes of every item, also removed ones
+	finished   map[State]int64 // Items that ended, by final state
+}
+
+func NewManager(maxActive int, newTask TaskFactory) *Manager {
+	if maxActive <= 0 {
+		maxActive = 3
+	}
+	return &Manager{
+		newTask:   newTask,
+		maxActive: maxActive,
+		wake:      make(chan struct{}, 1),
+		finished:  map[State]int64{},
+	}
+}
+
+// Add queues a download and returns its status
+func (m *Manager) Add(url, category, dir, name, owner string) ItemStatus {
+	return m.add(&Item{URL: url, Category: category, Dir: dir, Name: name, Owner: owner})
+}
+
+// AddFile queues a file of a metalink, with its mirrors and checksum
+func (m *Manager) AddFile(f downloader.MetalinkFile, category, dir, owner string) ItemStatus {
+	return m.add(&Item{
+		URL:      f.URLs[0],
+		Mirrors:  f.URLs[1:],
+		Checksum: f.Checksum,
+		Category: category,
+		Dir:      dir,
+		Name:     f.Name,
+		Owner:    owner,
+	})
+}
+
+func (m *Manager) add(it *Item) ItemStatus {
+	m.mu.Lock()
+	m.nextID++
+	it.ID = strconv.Itoa(m.nextID)
+	it.State, it.Added = StateQueued, time.Now()
+	m.items = append(m.items, it)
+	status := it.status()
+	m.mu.Unlock()
+
+	m.poke()
+	return status
+}
+
+// Get returns the item with the given id
+func (m *Manager) Get(id string) (ItemStatus, bool) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if it := m.find(id); it != nil {
+		return it.status(), true
+	}
+	return ItemStatus{}, false
+}
+
+// List returns the items accepted by filter, oldest first
+func (m *Manager) List(filter func(ItemStatus) bool) []ItemStatus {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	list := []ItemStatus{}
+	for _, it := range m.items {
+		if s := it.status(); filter == nil || filter(s) {
+			list = append(list, s)
+		}
+	}
+	return list
+}
+
+// Remove cancels the item if it is still active and drops it from the queue
+func (m *Manager) Remove(id string) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	for i, it := range m.items {
+		if it.ID != id {
+			continue
+		}
+		if it.cancel != nil {
+			it.cancel()
+		}
+		m.account(it)
+		m.items = append(m.items[:i], m.items[i+1:]...)
+		return nil
+	}
+	return fmt.Errorf("no download with id %s", id)
+}
+
+// Resume queues a failed or canceled item again. The download carries on
+// from what its last run left on disk.
+func (m *Manager) Resume(id string) (ItemStatus, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	it := m.find(id)
+	if it == nil {
+		return ItemStatus{}, fmt.Errorf("no download with id %s", id)
+	}
+	if it.State != StateFailed && it.State != StateCanceled {
+		return ItemStatus{}, fmt.Errorf("download %s is %s, only failed and canceled ones resume", id, it.State)
+	}
+	m.account(it)
+	it.State, it.Err, it.Started, it.Finished = StateQueued, "", time.Time{}, time.Time{}
+	// counted stays, the next task starts from the bytes already on disk
+	it.task, it.meter = nil, nil
+	m.poke()
+	return it.status(), nil
+}
+
+// Run starts queued items as slots free up until ctx is canceled
+func (m *Manager) Run(ctx context.Context) {
+	var wg sync.WaitGroup
+	defer wg.Wait()
+
+	ticker := time.NewTicker(sampleInterval)
+	defer ticker.Stop()
+
+	for {
+		m.mu.Lock()
+		active := 0
+		for _, it := range m.items {
+			if it.State == StateRunning {
+				active++
+			}
+		}
+		for _, it := range m.items {
+			if active >= m.maxActive {
+				break
+			}
+			if it.State != StateQueued {
+				continue
+			}
+			active++
+			m.startLocked(ctx, it, &wg)
+		}
+		m.mu.Unlock()
+
+		select {
+		case <-ctx.Done():
+			return
+		case <-m.wake:
+		case now := <-ticker.C:
+			m.sample(now)
+		}
+	}
+}
+
+// sample records the progress of running items for speed and ETA
+func (m *Manager) sample(now time.Time) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	for _, it := range m.items {
+		if it.State == StateRunning && it.task != nil {
+			it.meter.Add(now, it.task.Progress().GetDownloaded())
+			m.account(it)
+		}
+	}
+}
+
+// startLocked marks it running and builds its task outside the lock, the
+// factory may run plugins or fetch checksum manifests and the rest of the
+// queue mustn't wait for them. Until then the item has no task.
+func (m *Manager) startLocked(ctx context.Context, it *Item, wg *sync.WaitGroup) {
+	tctx, cancel := context.WithCancel(ctx)
+	it.cancel = cancel
+	it.State, it.Started = StateRunning, time.Now()
+
+	wg.Add(1)
+	go func() {
+		defer wg.Done()
+		defer m.poke()
+		task, err := m.newTask(it)
+
+		m.mu.Lock()
+		if err != nil || tctx.Err() != nil {
+			// Removed while the task was built, or it couldn't be
+			it.Finished, it.cancel = time.Now(), nil
+			if tctx.Err() != nil {
+				it.State = StateCanceled
+			} else {
+				it.State, it.Err = StateFailed, err.Error()
+			}
+			m.finished[it.State]++
+			m.mu.Unlock()
+			cancel()
+			return
+		}
+		it.task = task
+		it.meter = downloader.NewSpeedMeter(sampleHistory + 1)
+		it.meter.Add(time.Now(), task.Progress().GetDownloaded())
+		m.mu.Unlock()
+
+		err = task.Start(tctx)
+
+		m.mu.Lock()
+		it.Finished = time.Now()
+		it.cancel = nil
+		switch {
+		case err == nil:
+			it.State = StateDone
+		case tctx.Err() != nil:
+			it.State = StateCanceled
+		default:
+			it.State, it.Err = StateFailed, err.Error()
+		}
+		m.account(it)
+		m.finished[it.State]++
+		m.mu.Unlock()
+		cancel()
+		if err == nil && m.OnDone != nil {
+			m.OnDone(task)
+		}
+	}()
+}
+
+func (m *Manager) poke() {
+	select {
+	case m.wake <- struct{}{}:
+	default:
+	}
+}
+
+func (m *Manager) find(id string) *Item {
+	for _, it := range m.items {
+		if it.ID == id {
+			return it
+		}
+	}
+	return nil
+}
+
+// status must be called with the manager lock held
+func (it *Item) status() ItemStatus {
+	s := ItemStatus{
+		ID:       it.ID,
+		URL:      it.URL,
+		Category: it.Category,
+		Dir:      it.Dir,
+		Owner:    it.Owner,
+		State:    it.State,
+		Error:    it.Err,
+		AddedAt:  it.Added,
+	}
+	if it.Name != "" {
+		s.Output = filepath.Join(it.Dir, it.Name)
+	}
+	if it.task != nil {
+		stats := it.task.Progress()
+		s.Downloaded, s.Total = stats.GetDownloaded(), stats.GetTotal()
+		if in, ok := it.task.(downloader.Inspector); ok {
+			details := in.Inspect()
+			s.Inspection = &details
+		}
+	}
+	if it.State == StateRunning && it.meter != nil {
+		s.Speed = it.meter.Rate(speedWindow)
+		s.SpeedSamples = it.meter.Samples()
+		if s.Total > 0 {
+			if eta, ok := downloader.ETA(time.Now(), s.Total-s.Downloaded, s.Speed); ok {
+				s.ETA = &eta
+			}
+		}
+	}
+	s.Title = it.title(s)
+	if !it.Started.IsZero() {
+		t := it.Started
+		s.StartedAt = &t
+	}
+	if !it.Finished.IsZero() {
+		t := it.Finished
+		s.FinishedAt = &t
+	}
+	return s
+}
+
+// title summarizes an item the way browsers title their download windows
+func (it *Item) title(s ItemStatus) string {
+	name := it.Name
+	if name == "" {
+		name = path.Base(strings.SplitN(it.URL, "?", 2)[0])
+	}
+	if it.State != StateRunning {
+		return fmt.Sprintf("%s (%s)", name, it.State)
+	}
+	if s.Total <= 0 {
+		return name
+	}
+	return fmt.Sprintf("%d%% %s", s.Downloaded*100/s.Total, name)
+}
@@ -0,0 +1,102 @@
+package daemon
+
+import (
+	"context"
+	"fmt"
+	"strings"
+	"time"
+
+	"warp-dl/internal/downloader"
+)
+
+// window is a daily span of wall clock time, as time since midnight. One
+// that ends before it starts runs over midnight.
+type window struct {
+	from, to time.Duration
+}
+
+func parseWindow(s string) (window, error) {
+	from, to, ok := strings.Cut(s, "-")
+	if !ok {
+		return window{}, fmt.Errorf("invalid off_peak window %q: want HH:MM-HH:MM", s)
+	}
+	var w window
+	for i, part := range []string{from, to} {
+		t, err := time.Parse("15:04", strings.TrimSpace(part))
+		if err != nil {
+			return window{}, fmt.Errorf("invalid off_peak window %q: want HH:MM-HH:MM", s)
+		}
+		d := time.Duration(t.Hour())*time.Hour + time.Duration(t.Minute())*time.Minute
+		if i == 0 {
+			w.from = d
+		} else {
+			w.to = d
+		}
+	}
+	return w, nil
+}
+
+func (w window) contains(t time.Time) bool {
+	d := time.Duration(t.Hour())*time.Hour + time.Duration(t.Minute())*time.Minute + time.Duration(t.Second())*time.Second
+	if w.from <= w.to {
+		return d >= w.from && d < w.to
+	}
+	return d >= w.from || d < w.to
+}
+
+// Bandwidth caps the daemon's downloads at peak_limit outside the off_peak
+// windows, for plans that are only unmetered at night
+type Bandwidth struct {
+	Limiter *downloader.Limiter // Shared by every download of the daemon
+
+	offPeak []window
+	limit   int64
+}
+
+// NewBandwidth returns nil when the config sets no peak_limit
+func NewBandwidth(cfg Config) (*Bandwidth, error) {
+	if cfg.PeakLimit == "" {
+		if len(cfg.OffPeak) > 0 {
+			return nil, fmt.Errorf("off_peak needs a peak_limit for the rest of the day")
+		}
+		return nil, nil
+	}
+	limit, err := downloader.ParseSize(cfg.PeakLimit)
+	if err != nil || limit <= 0 {
+		return nil, fmt.Errorf("invalid peak_limit %q: want a speed in bytes per second like 500K", cfg.PeakLimit)
+	}
+	b := &Bandwidth{limit: limit}
+	for _, s := range cfg.OffPeak {
+		w, err := parseWindow(s)
+		if err != nil {
+			return nil, err
+		}
+		b.offPeak = append(b.offPeak, w)
+	}
+	b.Limiter = downloader.NewLimiter(b.rate(time.Now()))
+	return b, nil
+}
+
+// rate is the cap at t, 0 inside an off-peak window
+func (b *Bandwidth) rate(t time.Time) int64 {
+	for _, w := range b.offPeak {
+		if w.contains(t) {
+			return 0
+		}
+	}
+	return b.limit
+}
+
+// Run switches the limiter between the rates as the windows open and close
+func (b *Bandwidth) Run(ctx context.Context) {
+	ticker := time.NewTicker(time.Minute)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case now := <-ticker.C:
+			b.Limiter.SetRate(b.rate(now))
+		}
+	}
+}
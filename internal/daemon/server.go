@@ -0,0 +1,300 @@
+package daemon
+
+import (
+	"crypto/subtle"
+	"embed"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/fs"
+	"net/http"
+	"net/url"
+	"path/filepath"
+	"sort"
+	"strings"
+)
+
+// Config is the daemon section of config.yaml
+type Config struct {
+	Listen      string            `yaml:"listen"`
+	DownloadDir string            `yaml:"download_dir"`
+	MaxActive   int               `yaml:"max_active"`
+	Categories  map[string]string `yaml:"categories"` // Name -> directory, relative paths are below download_dir
+	Tokens      []Token           `yaml:"tokens"`
+	LAN         LANConfig         `yaml:"lan"`
+	OffPeak     []string          `yaml:"off_peak"`   // Daily windows at full speed, e.g. "00:00-08:00"
+	PeakLimit   string            `yaml:"peak_limit"` // Bytes per second outside them, e.g. 500K
+	Watch       WatchConfig       `yaml:"watch"`
+	Extension   ExtensionConfig   `yaml:"extension"`
+}
+
+// LANConfig shares finished downloads with warp-dl daemons on the local
+// network. Peers need no token: anyone on the network who knows a file's
+// digest can fetch it.
+type LANConfig struct {
+	Enabled bool   `yaml:"enabled"`
+	Listen  string `yaml:"listen"` // Peer server address, default :7801
+}
+
+// Scopes a token can have
+const (
+	ScopeAdmin   = "admin"   // Manages every download
+	ScopeGuest   = "guest"   // Adds to and sees only its own categories
+	ScopeMetrics = "metrics" // Only scrapes /metrics
+)
+
+// Token grants API access
+type Token struct {
+	Name       string   `yaml:"name"`
+	Token      string   `yaml:"token"`
+	Scope      string   `yaml:"scope"`
+	Categories []string `yaml:"categories"` // Guest tokens only, the first is the default
+}
+
+//go:embed web
+var webFS embed.FS
+
+// Server is the HTTP remote control
+type Server struct {
+	cfg Config
+	m   *Manager
+}
+
+func NewServer(cfg Config, m *Manager) (*Server, error) {
+	if len(cfg.Tokens) == 0 {
+		return nil, errors.New("no API tokens configured")
+	}
+	for _, t := range cfg.Tokens {
+		if t.Token == "" {
+			return nil, fmt.Errorf("token %q has no secret", t.Name)
+		}
+		switch t.Scope {
+		case ScopeAdmin, ScopeMetrics:
+		case ScopeGuest:
+			if len(t.Categories) == 0 {
+				return nil, fmt.Errorf("guest token %q needs at least one category", t.Name)
+			}
+			for _, c := range t.Categories {
+				if _, ok := cfg.Categories[c]; !ok {
+					return nil, fmt.Errorf("guest token %q uses unknown category %q", t.Name, c)
+				}
+			}
+		default:
+			return nil, fmt.Errorf("token %q has unknown scope %q", t.Name, t.Scope)
+		}
+	}
+	return &Server{cfg: cfg, m: m}, nil
+}
+
+func (s *Server) Handler() http.Handler {
+	mux := http.NewServeMux()
+	mux.Handle("/", http.FileServer(http.FS(mustSub(webFS, "web"))))
+	mux.HandleFunc("/api/whoami", s.auth(s.handleWhoami))
+	mux.HandleFunc("/api/downloads", s.auth(s.handleDownloads))
+	mux.HandleFunc("/api/downloads/", s.auth(s.handleDownload))
+	mux.HandleFunc("/metrics", s.auth(s.handleMetrics))
+	mux.HandleFunc("/api/extension", s.cors(s.auth(s.handleExtension)))
+	return mux
+}
+
+type handlerFunc func(w http.ResponseWriter, r *http.Request, tok *Token)
+
+// auth resolves the bearer token, the dashboard passes the same token
+func (s *Server) auth(next handlerFunc) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		secret := strings.TrimPrefix(r.Header.Get("Authorization"), "Bearer ")
+		for i := range s.cfg.Tokens {
+			t := &s.cfg.Tokens[i]
+			if subtle.ConstantTimeCompare([]byte(secret), []byte(t.Token)) == 1 {
+				// Monitoring sees the whole queue, the API only for admins
+				if (t.Scope == ScopeMetrics) != (r.URL.Path == "/metrics") && t.Scope != ScopeAdmin {
+					writeError(w, http.StatusForbidden, fmt.Sprintf("a %s token may not use %s", t.Scope, r.URL.Path))
+					return
+				}
+				next(w, r, t)
+				return
+			}
+		}
+		writeError(w, http.StatusUnauthorized, "invalid or missing token")
+	}
+}
+
+func (s *Server) handleWhoami(w http.ResponseWriter, r *http.Request, tok *Token) {
+	categories := tok.Categories
+	if tok.Scope == ScopeAdmin {
+		categories = nil
+		for c := range s.cfg.Categories {
+			categories = append(categories, c)
+		}
+		sort.Strings(categories)
+	}
+	writeJSON(w, http.StatusOK, map[string]interface{}{
+		"name":       tok.Name,
+		"scope":      tok.Scope,
+		"categories": categories,
+	})
+}
+
+type addRequest struct {
+	URL      string `json:"url"`
+	Category string `json:"category"`
+	Name     string `json:"name"` // File name, default: taken from the URL
+}
+
+func (s *Server) handleDownloads(w http.ResponseWriter, r *http.Request, tok *Token) {
+	switch r.Method {
+	case http.MethodGet:
+		writeJSON(w, http.StatusOK, s.m.List(func(it ItemStatus) bool { return tok.canSee(it) }))
+
+	case http.MethodPost:
+		var req addRequest
+		if err := json.NewDecoder(http.MaxBytesReader(w, r.Body, 64*1024)).Decode(&req); err != nil {
+			writeError(w, http.StatusBadRequest, "invalid JSON body")
+			return
+		}
+		dir, name, category, status, err := s.resolveAdd(tok, req)
+		if err != nil {
+			writeError(w, status, err.Error())
+			return
+		}
+		writeJSON(w, http.StatusCreated, s.m.add(&Item{URL: req.URL, Category: category, Dir: dir, Name: name, Owner: tok.Name, Guest: tok.Scope == ScopeGuest}))
+
+	default:
+		writeError(w, http.StatusMethodNotAllowed, "use GET or POST")
+	}
+}
+
+// handleDownload serves /api/downloads/{id} and /api/downloads/{id}/resume
+func (s *Server) handleDownload(w http.ResponseWriter, r *http.Request, tok *Token) {
+	id, action, _ := strings.Cut(strings.TrimPrefix(r.URL.Path, "/api/downloads/"), "/")
+	it, ok := s.m.Get(id)
+	if !ok || !tok.canSee(it) || (action != "" && action != "resume") {
+		writeError(w, http.StatusNotFound, "no such download")
+		return
+	}
+
+	if action == "resume" {
+		if r.Method != http.MethodPost {
+			writeError(w, http.StatusMethodNotAllowed, "use POST")
+			return
+		}
+		if !tok.canManage(it) {
+			writeError(w, http.StatusForbidden, "only the owner or an admin can resume this download")
+			return
+		}
+		status, err := s.m.Resume(id)
+		if err != nil {
+			writeError(w, http.StatusConflict, err.Error())
+			return
+		}
+		writeJSON(w, http.StatusOK, status)
+		return
+	}
+
+	switch r.Method {
+	case http.MethodGet:
+		writeJSON(w, http.StatusOK, it)
+	case http.MethodDelete:
+		if !tok.canManage(it) {
+			writeError(w, http.StatusForbidden, "only the owner or an admin can remove this download")
+			return
+		}
+		if err := s.m.Remove(id); err != nil {
+			writeError(w, http.StatusNotFound, err.Error())
+			return
+		}
+		w.WriteHeader(http.StatusNoContent)
+	default:
+		writeError(w, http.StatusMethodNotAllowed, "use GET or DELETE")
+	}
+}
+
+// resolveAdd applies the token's scope to an add request and returns the
+// destination directory and file name
+func (s *Server) resolveAdd(tok *Token, req addRequest) (dir, name, category string, status int, err error) {
+	u, err := url.Parse(req.URL)
+	if err != nil || req.URL == "" {
+		return "", "", "", http.StatusBadRequest, errors.New("invalid URL")
+	}
+
+	category = req.Category
+	if tok.Scope == ScopeGuest {
+		// Guests can't reach the daemon's credentials (sftp keys, cloud
+		// config) or local files, only plain web and magnet downloads. Their
+		// items are marked Guest and go out without the configured headers,
+		// cookies and signing.
+		if u.Scheme != "http" && u.Scheme != "https" && u.Scheme != "magnet" {
+			return "", "", "", http.StatusForbidden, fmt.Errorf("guests may not use %s URLs", u.Scheme)
+		}
+		if category == "" {
+			category = tok.Categories[0]
+		}
+		if !contains(tok.Categories, category) {
+			return "", "", "", http.StatusForbidden, fmt.Errorf("token may not add to category %q", category)
+		}
+	}
+
+	dir, err = s.cfg.categoryDir(category)
+	if err != nil {
+		return "", "", "", http.StatusBadRequest, err
+	}
+
+	// Only a bare file name, callers can't leave the category directory
+	if req.Name != "" {
+		name = filepath.Base(filepath.Clean("/" + req.Name))
+		if name == string(filepath.Separator) || name == "." || name == ".." {
+			return "", "", "", http.StatusBadRequest, errors.New("invalid file name")
+		}
+	}
+	return dir, name, category, 0, nil
+}
+
+// categoryDir is the directory downloads of category go to
+func (c Config) categoryDir(category string) (string, error) {
+	if category == "" {
+		return c.DownloadDir, nil
+	}
+	catDir, ok := c.Categories[category]
+	if !ok {
+		return "", fmt.Errorf("unknown category %q", category)
+	}
+	if filepath.IsAbs(catDir) {
+		return catDir, nil
+	}
+	return filepath.Join(c.DownloadDir, catDir), nil
+}
+
+func (t *Token) canSee(it ItemStatus) bool {
+	return t.Scope == ScopeAdmin || contains(t.Categories, it.Category)
+}
+
+func (t *Token) canManage(it ItemStatus) bool {
+	return t.Scope == ScopeAdmin || it.Owner == t.Name
+}
+
+func contains(list []string, s string) bool {
+	for _, v := range list {
+		if v == s {
+			return true
+		}
+	}
+	return false
+}
+
+func writeJSON(w http.ResponseWriter, status int, v interface{}) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	json.NewEncoder(w).Encode(v)
+}
+
+func writeError(w http.ResponseWriter, status int, msg string) {
+	writeJSON(w, status, map[string]string{"error": msg})
+}
+
+func mustSub(fsys fs.FS, dir string) fs.FS {
+	sub, err := fs.Sub(fsys, dir)
+	if err != nil {
+		panic(err)
+	}
+	return sub
+}
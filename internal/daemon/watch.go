@@ -0,0 +1,207 @@
+package daemon
+
+import (
+	"bufio"
+	"context"
+	"fmt"
+	"log/slog"
+	"net/url"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"warp-dl/internal/downloader"
+)
+
+// WatchConfig is a folder the daemon takes downloads from: .torrent and
+// .metalink files, and text files with a URL per line
+type WatchConfig struct {
+	Dir       string `yaml:"dir"`
+	Processed string `yaml:"processed"` // Where handled files go, default <dir>/processed
+	Category  string `yaml:"category"`  // Category of the downloads, default none
+}
+
+const watchInterval = 2 * time.Second
+
+// Watcher starts downloads for the files dropped into the watch folder
+type Watcher struct {
+	cfg  WatchConfig
+	dest string
+	m    *Manager
+	log  *slog.Logger
+
+	// Size and time of the files at the last look, a file is only taken
+	// once it stopped changing
+	seen map[string]os.FileInfo
+}
+
+// NewWatcher returns nil when the config has no watch folder
+func NewWatcher(cfg Config, m *Manager, log *slog.Logger) (*Watcher, error) {
+	w := cfg.Watch
+	if w.Dir == "" {
+		return nil, nil
+	}
+	w.Dir = downloader.ExpandHome(w.Dir)
+	if w.Processed == "" {
+		w.Processed = filepath.Join(w.Dir, "processed")
+	}
+	w.Processed = downloader.ExpandHome(w.Processed)
+	dest, err := cfg.categoryDir(w.Category)
+	if err != nil {
+		return nil, fmt.Errorf("watch: %w", err)
+	}
+	for _, dir := range []string{w.Dir, w.Processed} {
+		if err := os.MkdirAll(dir, 0o755); err != nil {
+			return nil, fmt.Errorf("watch: %w", err)
+		}
+	}
+	return &Watcher{cfg: w, dest: dest, m: m, log: log, seen: map[string]os.FileInfo{}}, nil
+}
+
+// Run looks at the folder every few seconds until ctx is canceled
+func (w *Watcher) Run(ctx context.Context) {
+	t := time.NewTicker(watchInterval)
+	defer t.Stop()
+	for {
+		w.scan()
+		select {
+		case <-ctx.Done():
+			return
+		case <-t.C:
+		}
+	}
+}
+
+func (w *Watcher) scan() {
+	entries, err := os.ReadDir(w.cfg.Dir)
+	if err != nil {
+		w.log.Warn("cannot read the watch folder", "dir", w.cfg.Dir, "err", err)
+		return
+	}
+	seen := map[string]os.FileInfo{}
+	for _, e := range entries {
+		name := e.Name()
+		if e.IsDir() || strings.HasPrefix(name, ".") || watchKind(name) == "" {
+			continue
+		}
+		info, err := e.Info()
+		if err != nil {
+			continue
+		}
+		seen[name] = info
+		if last, ok := w.seen[name]; !ok || last.Size() != info.Size() || !last.ModTime().Equal(info.ModTime()) {
+			// Maybe still being written, wait for the next look
+			continue
+		}
+		delete(seen, name)
+		w.take(filepath.Join(w.cfg.Dir, name))
+	}
+	w.seen = seen
+}
+
+// watchKind tells the files the watcher takes by their name
+func watchKind(name string) string {
+	switch strings.ToLower(filepath.Ext(name)) {
+	case ".torrent":
+		return "torrent"
+	case ".metalink", ".meta4":
+		return "metalink"
+	case ".txt", ".url", ".urls":
+		return "urls"
+	}
+	return ""
+}
+
+// take queues the downloads of a file and moves it out of the way. Files
+// that can't be read are moved too, or they would fail every few seconds.
+func (w *Watcher) take(path string) {
+	kind := watchKind(path)
+	var metalink []downloader.MetalinkFile
+	var urls []string
+	var err error
+	switch kind {
+	case "metalink":
+		metalink, err = readMetalink(path)
+	case "urls":
+		urls, err = readURLs(path)
+	}
+
+	moved, merr := w.moveProcessed(path)
+	if merr != nil {
+		w.log.Warn("cannot move a watched file out of the folder, leaving it", "file", path, "err", merr)
+		return
+	}
+	if err != nil {
+		w.log.Warn("skipped a watched file", "file", moved, "err", err)
+		return
+	}
+
+	owner := "watch:" + filepath.Base(path)
+	switch kind {
+	case "torrent":
+		// The torrent stays in the processed folder, it is the download
+		w.m.Add(moved, w.cfg.Category, w.dest, "", owner)
+	case "metalink":
+		for _, f := range metalink {
+			w.m.AddFile(f, w.cfg.Category, w.dest, owner)
+		}
+	case "urls":
+		for _, u := range urls {
+			w.m.Add(u, w.cfg.Category, w.dest, "", owner)
+		}
+	}
+	w.log.Info("queued from the watch folder", "file", filepath.Base(path), "downloads", max(len(metalink)+len(urls), 1))
+}
+
+// moveProcessed moves path into the processed folder, under a new name if
+// it has one of that name already
+func (w *Watcher) moveProcessed(path string) (string, error) {
+	base := filepath.Base(path)
+	dst := filepath.Join(w.cfg.Processed, base)
+	if _, err := os.Lstat(dst); err == nil {
+		ext := filepath.Ext(base)
+		dst = filepath.Join(w.cfg.Processed, fmt.Sprintf("%s-%s%s", strings.TrimSuffix(base, ext), time.Now().Format("20060102-150405.000"), ext))
+	}
+	return dst, os.Rename(path, dst)
+}
+
+func readMetalink(path string) ([]downloader.MetalinkFile, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+	return downloader.ParseMetalink(f)
+}
+
+// readURLs takes a URL per line, # starts a comment. Windows .url
+// shortcuts work too, by their URL= line.
+func readURLs(path string) ([]string, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+	var urls []string
+	sc := bufio.NewScanner(f)
+	for sc.Scan() {
+		line := strings.TrimSpace(sc.Text())
+		line = strings.TrimPrefix(line, "URL=")
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+		u, err := url.Parse(line)
+		if err != nil || u.Scheme == "" || u.Scheme != "magnet" && u.Host == "" {
+			continue
+		}
+		urls = append(urls, line)
+	}
+	if err := sc.Err(); err != nil {
+		return nil, err
+	}
+	if len(urls) == 0 {
+		return nil, fmt.Errorf("no URLs in it")
+	}
+	return urls, nil
+}
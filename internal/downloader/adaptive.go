@@ -0,0 +1,199 @@
+package downloader
+
+import (
+	"context"
+	"net/url"
+	"sync"
+	"time"
+)
+
+// With --concurrent auto the file is cut into many small parts and a pool
+// of connections works through them. The pool starts small and grows while
+// every step still buys a meaningful speedup, then settles, so small files
+// and rate-limited hosts don't get a burst of connections they can't use.
+
+const (
+	autoStartConnections = 2
+	autoMaxConnections   = 32
+	autoPartsPerConn     = 2
+
+	autoSampleInterval = time.Second
+	autoSamples        = 2   // Measured intervals per step, after one warm-up interval
+	autoMinGain        = 0.1 // A step has to raise throughput by 10% to be kept
+)
+
+// autoPartCount is the number of segments for an auto-tuned download
+func autoPartCount(total, minSize int64) int {
+	n := total / minSize
+	if n > autoMaxConnections*autoPartsPerConn {
+		n = autoMaxConnections * autoPartsPerConn
+	}
+	if n < 1 {
+		n = 1
+	}
+	return int(n)
+}
+
+// connTuner hill-climbs the connection count on measured throughput
+type connTuner struct {
+	target, limit int
+	converged     bool
+
+	prev     int     // Count of the last step that paid off
+	prevRate float64 // Its throughput in bytes per interval
+
+	last  int64 // Downloaded bytes at the previous tick
+	ticks int   // Since the last change
+	sum   int64
+}
+
+func newConnTuner(start, limit int, downloaded int64) *connTuner {
+	if start > limit {
+		start = limit
+	}
+	if start < 1 {
+		start = 1
+	}
+	return &connTuner{target: start, limit: limit, prev: start, last: downloaded}
+}
+
+// observe is called every sample interval and returns the new target
+func (t *connTuner) observe(downloaded int64) int {
+	delta := downloaded - t.last
+	t.last = downloaded
+	if t.converged {
+		return t.target
+	}
+
+	// Skip the first interval after a change, new connections are still
+	// in their handshake and slow start
+	if t.ticks++; t.ticks == 1 {
+		return t.target
+	}
+	t.sum += delta
+	if t.ticks < 1+autoSamples {
+		return t.target
+	}
+	rate := float64(t.sum) / autoSamples
+	t.ticks, t.sum = 0, 0
+
+	if t.prevRate > 0 && rate < t.prevRate*(1+autoMinGain) {
+		// The last step didn't pay off, go back and stay there
+		t.target = t.prev
+		t.converged = true
+		return t.target
+	}
+	t.prev, t.prevRate = t.target, rate
+	if t.target >= t.limit {
+		t.converged = true
+		return t.target
+	}
+
+	step := t.target / 2
+	if step < 1 {
+		step = 1
+	}
+	t.target += step
+	if t.target > t.limit {
+		t.target = t.limit
+	}
+	return t.target
+}
+
+// runAdaptive downloads all parts over a tuned number of connections.
+// Shrinking takes effect as connections finish their current part.
+func (e *Engine) runAdaptive(ctx context.Context, errChan chan<- error) {
+	queue := make(chan *Part, len(e.Parts))
+	for _, p := range e.Parts {
+		queue <- p
+	}
+	close(queue)
+
+	limit := autoMaxConnections
+	if len(e.Parts) < limit {
+		limit = len(e.Parts)
+	}
+	host := ""
+	if u, err := url.Parse(e.Config.URL); err == nil {
+		host = u.Host
+	}
+	start := autoStartConnections
+	if n := loadHostCache()[host].Connections; n > 0 {
+		start = n
+	}
+	tuner := newConnTuner(start, limit, e.Stats.GetDownloaded())
+
+	var (
+		mu     sync.Mutex
+		active int
+		target = tuner.target
+		idle   = make(chan struct{})
+	)
+	// exitLocked retires a connection, must be called with mu held
+	exitLocked := func() {
+		if active--; active == 0 {
+			close(idle)
+		}
+	}
+	// spawn must be called with mu held
+	spawn := func() {
+		active++
+		go func() {
+			for {
+				mu.Lock()
+				if active > target {
+					exitLocked()
+					mu.Unlock()
+					return
+				}
+				mu.Unlock()
+
+				p, ok := <-queue
+				if ok {
+					if err := e.downloadPartWithRetry(ctx, p); err != nil {
+						errChan <- err
+						ok = false
+					}
+				}
+				if !ok {
+					mu.Lock()
+					exitLocked()
+					mu.Unlock()
+					return
+				}
+			}
+		}()
+	}
+	mu.Lock()
+	for active < target {
+		spawn()
+	}
+	mu.Unlock()
+
+	ticker := time.NewTicker(autoSampleInterval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-idle:
+			if tuner.converged && host != "" {
+				cache := loadHostCache()
+				entry := cache[host]
+				entry.Connections = tuner.target
+				cache[host] = entry
+				saveHostCache(cache)
+			}
+			return
+		case <-ticker.C:
+			n := tuner.observe(e.Stats.GetDownloaded())
+			mu.Lock()
+			if n != target {
+				e.trace("auto concurrency: %d connections", n)
+			}
+			target = n
+			for active < target && len(queue) > 0 {
+				spawn()
+			}
+			mu.Unlock()
+		}
+	}
+}
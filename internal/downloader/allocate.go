@@ -0,0 +1,70 @@
+package downloader
+
+import (
+	"fmt"
+	"os"
+)
+
+// FileAllocation controls how part files and the merged output get their
+// space before the data arrives
+type FileAllocation int
+
+const (
+	AllocNone     FileAllocation = iota // Grow as written
+	AllocTrunc                          // Set the full size up front, sparse where the file system can
+	AllocPrealloc                       // Write zeros over the full size, slow but reserves the space anywhere
+	AllocFalloc                         // Reserve the blocks with fallocate, as prealloc where that's unsupported
+)
+
+// ParseFileAllocation parses the --file-allocation flag
+func ParseFileAllocation(s string) (FileAllocation, error) {
+	switch s {
+	case "none", "":
+		return AllocNone, nil
+	case "trunc":
+		return AllocTrunc, nil
+	case "prealloc":
+		return AllocPrealloc, nil
+	case "falloc":
+		return AllocFalloc, nil
+	}
+	return 0, fmt.Errorf("invalid file allocation %q (want none, trunc, prealloc or falloc)", s)
+}
+
+// allocate gives f its full size the configured way. Allocated part files
+// are full length from the start, so only the journal tells how far they
+// got.
+func (e *Engine) allocate(f *os.File, size int64) error {
+	info, err := f.Stat()
+	if err != nil {
+		return err
+	}
+	if info.Size() >= size {
+		return nil
+	}
+	switch e.Config.Allocation {
+	case AllocTrunc:
+		return f.Truncate(size)
+	case AllocFalloc:
+		if fallocate(f, size) == nil {
+			return nil
+		}
+		fallthrough
+	case AllocPrealloc:
+		return writeZeros(f, info.Size(), size)
+	}
+	return nil
+}
+
+// writeZeros fills f with zeros from off to size
+func writeZeros(f *os.File, off, size int64) error {
+	zeros := make([]byte, 1<<20)
+	for off < size {
+		n := min(int64(len(zeros)), size-off)
+		if _, err := f.WriteAt(zeros[:n], off); err != nil {
+			return err
+		}
+		off += n
+	}
+	return nil
+}
@@ -0,0 +1,71 @@
+package downloader
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+)
+
+// ByteRange is an inclusive window of a remote file. End is -1 when the
+// window extends to the end of the file.
+type ByteRange struct {
+	Start int64
+	End   int64
+}
+
+// ParseByteRange parses "start-end", "start-" or "-suffix" (the last N bytes)
+func ParseByteRange(s string) (*ByteRange, error) {
+	startStr, endStr, ok := strings.Cut(strings.TrimSpace(s), "-")
+	if !ok || (startStr == "" && endStr == "") {
+		return nil, fmt.Errorf("invalid range %q, expected start-end", s)
+	}
+
+	if startStr == "" {
+		n, err := strconv.ParseInt(endStr, 10, 64)
+		if err != nil || n <= 0 {
+			return nil, fmt.Errorf("invalid suffix range %q", s)
+		}
+		return &ByteRange{Start: -n, End: -1}, nil
+	}
+
+	start, err := strconv.ParseInt(startStr, 10, 64)
+	if err != nil || start < 0 {
+		return nil, fmt.Errorf("invalid range start %q", startStr)
+	}
+	r := &ByteRange{Start: start, End: -1}
+	if endStr != "" {
+		r.End, err = strconv.ParseInt(endStr, 10, 64)
+		if err != nil || r.End < start {
+			return nil, fmt.Errorf("invalid range end %q", endStr)
+		}
+	}
+	return r, nil
+}
+
+// resolve clamps the range against the remote file size
+func (r ByteRange) resolve(total int64) (int64, int64, error) {
+	start, end := r.Start, r.End
+	if start < 0 {
+		start += total
+		if start < 0 {
+			start = 0
+		}
+	}
+	if end < 0 || end >= total {
+		end = total - 1
+	}
+	if start > end {
+		return 0, 0, fmt.Errorf("range %d-%d is outside the remote file (%d bytes)", r.Start, r.End, total)
+	}
+	return start, end, nil
+}
+
+func (r ByteRange) String() string {
+	if r.Start < 0 {
+		return fmt.Sprintf("%d", r.Start)
+	}
+	if r.End < 0 {
+		return fmt.Sprintf("%d-", r.Start)
+	}
+	return fmt.Sprintf("%d-%d", r.Start, r.End)
+}
@@ -0,0 +1,49 @@
+package downloader
+
+import "os"
+
+// With Config.DropCache written data is flushed and dropped from the page
+// cache every dropEvery bytes, so a download of tens of GB doesn't push
+// everything else out of memory.
+const dropEvery = 8 << 20
+
+// cacheDropper drops a file's sequentially written bytes from the cache.
+// A nil dropper does nothing.
+type cacheDropper struct {
+	f    *os.File
+	from int64 // Start of the bytes not dropped yet
+}
+
+func (e *Engine) newCacheDropper(f *os.File, from int64) *cacheDropper {
+	if !e.Config.DropCache {
+		return nil
+	}
+	return &cacheDropper{f: f, from: from}
+}
+
+// wrote notes that the file is written up to end
+func (d *cacheDropper) wrote(end int64) {
+	if d != nil && end-d.from >= dropEvery {
+		dropCache(d.f, d.from, end-d.from)
+		d.from = end
+	}
+}
+
+// flush drops the rest once the file is written up to end
+func (d *cacheDropper) flush(end int64) {
+	if d != nil && end > d.from {
+		dropCache(d.f, d.from, end-d.from)
+		d.from = end
+	}
+}
+
+// DropFileCache drops a whole file from the page cache, e.g. after reading it
+// back for verification
+func DropFileCache(path string) {
+	f, err := os.Open(path)
+	if err != nil {
+		return
+	}
+	defer f.Close()
+	dropCache(f, 0, 0)
+}
@@ -0,0 +1,13 @@
+package downloader
+
+import (
+	"os"
+
+	"golang.org/x/sys/unix"
+)
+
+// dropCache turns off caching for the file (F_NOCACHE), macOS has no way
+// to drop a range
+func dropCache(f *os.File, off, n int64) {
+	unix.FcntlInt(f.Fd(), unix.F_NOCACHE, 1)
+}
@@ -0,0 +1,15 @@
+package downloader
+
+import (
+	"os"
+
+	"golang.org/x/sys/unix"
+)
+
+// dropCache writes back n bytes at off, to the end for 0, and drops them
+// from the page cache, which only lets go of clean pages
+func dropCache(f *os.File, off, n int64) {
+	fd := int(f.Fd())
+	unix.SyncFileRange(fd, off, n, unix.SYNC_FILE_RANGE_WAIT_BEFORE|unix.SYNC_FILE_RANGE_WRITE|unix.SYNC_FILE_RANGE_WAIT_AFTER)
+	unix.Fadvise(fd, off, n, unix.FADV_DONTNEED)
+}
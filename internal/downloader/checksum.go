@@ -0,0 +1,179 @@
+package downloader
+
+import (
+	"bufio"
+	"crypto/md5"
+	"crypto/sha1"
+	"crypto/sha256"
+	"crypto/sha512"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"hash"
+	"io"
+	"os"
+	"regexp"
+	"strings"
+)
+
+// ErrChecksumMismatch means the download isn't the file Config.Checksum
+// names
+var ErrChecksumMismatch = errors.New("checksum mismatch")
+
+// Checksum is an expected file digest such as sha256:<hex>
+type Checksum struct {
+	Algo  string
+	Value string
+}
+
+// ParseChecksum parses an "algo:hex" string
+func ParseChecksum(s string) (*Checksum, error) {
+	algo, value, ok := strings.Cut(s, ":")
+	if !ok || value == "" {
+		return nil, fmt.Errorf("invalid checksum %q, expected algo:hex", s)
+	}
+	algo = normalizeHashAlgo(algo)
+	if _, err := newHash(algo); err != nil {
+		return nil, err
+	}
+	return &Checksum{Algo: algo, Value: strings.ToLower(value)}, nil
+}
+
+func (c *Checksum) String() string {
+	return c.Algo + ":" + c.Value
+}
+
+// Verify hashes the file at path and compares it with the expected digest
+func (c *Checksum) Verify(path string) error {
+	return c.VerifyFiles(path)
+}
+
+// VerifyFiles checks the digest of the concatenation of paths, such as the
+// volumes of a split output
+func (c *Checksum) VerifyFiles(paths ...string) error {
+	h, err := newHash(c.Algo)
+	if err != nil {
+		return err
+	}
+	for _, path := range paths {
+		if err := hashInto(h, path); err != nil {
+			return err
+		}
+	}
+	if sum := hex.EncodeToString(h.Sum(nil)); sum != c.Value {
+		return fmt.Errorf("%w for %s: expected %s %s, got %s", ErrChecksumMismatch, strings.Join(paths, " + "), c.Algo, c.Value, sum)
+	}
+	return nil
+}
+
+// HashFile returns the hex digest of the file at path
+func HashFile(path, algo string) (string, error) {
+	return HashFiles(algo, path)
+}
+
+// HashFiles returns the hex digest of the concatenation of paths
+func HashFiles(algo string, paths ...string) (string, error) {
+	h, err := newHash(algo)
+	if err != nil {
+		return "", err
+	}
+
+	for _, path := range paths {
+		if err := hashInto(h, path); err != nil {
+			return "", err
+		}
+	}
+	return hex.EncodeToString(h.Sum(nil)), nil
+}
+
+func hashInto(h hash.Hash, path string) error {
+	f, err := os.Open(path)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+
+	_, err = io.Copy(h, f)
+	return err
+}
+
+// normalizeHashAlgo maps names like "SHA-256" (used by metalink) to "sha256"
+func normalizeHashAlgo(algo string) string {
+	return strings.ReplaceAll(strings.ToLower(algo), "-", "")
+}
+
+func newHash(algo string) (hash.Hash, error) {
+	switch normalizeHashAlgo(algo) {
+	case "md5":
+		return md5.New(), nil
+	case "sha1":
+		return sha1.New(), nil
+	case "sha256":
+		return sha256.New(), nil
+	case "sha512":
+		return sha512.New(), nil
+	}
+	return nil, fmt.Errorf("unsupported hash algorithm: %s", algo)
+}
+
+// SumEntry is one line of a sums file such as SHA256SUMS
+type SumEntry struct {
+	Name     string
+	Checksum *Checksum
+}
+
+// ParseSums reads GNU ("<hex>  <name>", "<hex> *<name>") and BSD
+// ("SHA256 (<name>) = <hex>") style checksum lists. When algo is empty it
+// is inferred from the digest length.
+func ParseSums(r io.Reader, algo string) ([]SumEntry, error) {
+	var entries []SumEntry
+	sc := bufio.NewScanner(r)
+	for lineNo := 1; sc.Scan(); lineNo++ {
+		line := strings.TrimSpace(sc.Text())
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+
+		lineAlgo := algo
+		var name, sum string
+		if m := bsdSumLine.FindStringSubmatch(line); m != nil {
+			if lineAlgo == "" {
+				lineAlgo = m[1]
+			}
+			name, sum = m[2], m[3]
+		} else {
+			fields := strings.SplitN(line, " ", 2)
+			if len(fields) != 2 {
+				return nil, fmt.Errorf("line %d: malformed checksum entry", lineNo)
+			}
+			sum = fields[0]
+			name = strings.TrimPrefix(strings.TrimLeft(fields[1], " "), "*")
+		}
+
+		if lineAlgo == "" {
+			lineAlgo = algoForDigestLength(len(sum))
+		}
+		c, err := ParseChecksum(lineAlgo + ":" + sum)
+		if err != nil {
+			return nil, fmt.Errorf("line %d: %w", lineNo, err)
+		}
+		entries = append(entries, SumEntry{Name: name, Checksum: c})
+	}
+	return entries, sc.Err()
+}
+
+var bsdSumLine = regexp.MustCompile(`^([A-Za-z0-9-]+) \((.+)\) = ([0-9a-fA-F]+)$`)
+
+func algoForDigestLength(n int) string {
+	switch n {
+	case 32:
+		return "md5"
+	case 40:
+		return "sha1"
+	case 64:
+		return "sha256"
+	case 128:
+		return "sha512"
+	}
+	return ""
+}
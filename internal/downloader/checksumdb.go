@@ -0,0 +1,217 @@
+package downloader
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"os"
+	"path/filepath"
+	"regexp"
+	"strings"
+
+	"github.com/ProtonMail/go-crypto/openpgp"
+	"github.com/ProtonMail/go-crypto/openpgp/clearsign"
+)
+
+// ManifestSource is a trusted checksum list such as a distribution's
+// SHA256SUMS. Downloads whose URL it covers are verified against its
+// entries, whichever mirror actually serves the file.
+type ManifestSource struct {
+	URL       string   `yaml:"url"`       // Manifest URL or local path
+	Signature string   `yaml:"signature"` // Detached signature URL or path
+	Keyring   string   `yaml:"keyring"`   // OpenPGP public keys the signature must come from
+	Match     []string `yaml:"match"`     // URL globs the manifest covers, default: the manifest's directory
+	Algo      string   `yaml:"algo"`      // Hash algorithm, default: inferred from the digests
+	Unsigned  bool     `yaml:"unsigned"`  // Trust the manifest without a keyring to check it against
+}
+
+var errUnsignedManifest = errors.New("no keyring to check the manifest's signature with, set unsigned: true to trust it as is")
+
+// LookupTrustedChecksum returns the expected digest for rawURL from the
+// first manifest that covers it and lists its file name, or nil when no
+// manifest does. Manifests are only trusted once their signature checks
+// out, unless the source says they're unsigned. client should verify TLS
+// certificates, see Config.VerifyTLS.
+func LookupTrustedChecksum(ctx context.Context, client *http.Client, sources []ManifestSource, rawURL string) (*Checksum, error) {
+	u, err := url.Parse(rawURL)
+	if err != nil {
+		return nil, nil
+	}
+
+	for _, src := range sources {
+		if !src.covers(rawURL) {
+			continue
+		}
+
+		data, err := src.load(ctx, client)
+		if err != nil {
+			return nil, fmt.Errorf("checksum manifest %s: %w", src.URL, err)
+		}
+		entries, err := ParseSums(bytes.NewReader(data), src.Algo)
+		if err != nil {
+			return nil, fmt.Errorf("checksum manifest %s: %w", src.URL, err)
+		}
+
+		if best := matchSum(entries, u.Path); best != nil {
+			return best.Checksum, nil
+		}
+	}
+	return nil, nil
+}
+
+// matchSum returns the entry for the file at a URL path. Entries may carry
+// a relative directory, the longest match wins.
+func matchSum(entries []SumEntry, urlPath string) *SumEntry {
+	var best *SumEntry
+	for i, e := range entries {
+		name := strings.TrimPrefix(e.Name, "./")
+		if urlPath == name || strings.HasSuffix(urlPath, "/"+name) {
+			if best == nil || len(name) > len(strings.TrimPrefix(best.Name, "./")) {
+				best = &entries[i]
+			}
+		}
+	}
+	return best
+}
+
+// covers reports whether the manifest applies to rawURL
+func (s ManifestSource) covers(rawURL string) bool {
+	patterns := s.Match
+	if len(patterns) == 0 {
+		dir := s.URL
+		if i := strings.LastIndex(dir, "/"); i >= 0 {
+			dir = dir[:i]
+		}
+		patterns = []string{dir + "/*"}
+	}
+	for _, p := range patterns {
+		if globMatch(p, rawURL) {
+			return true
+		}
+	}
+	return false
+}
+
+// globMatch matches s against a pattern where '*' spans any run of
+// characters, including slashes, and '?' matches one character
+func globMatch(pattern, s string) bool {
+	var re strings.Builder
+	re.WriteString("^")
+	for _, r := range pattern {
+		switch r {
+		case '*':
+			re.WriteString(".*")
+		case '?':
+			re.WriteString(".")
+		default:
+			re.WriteString(regexp.QuoteMeta(string(r)))
+		}
+	}
+	re.WriteString("$")
+	ok, _ := regexp.MatchString(re.String(), s)
+	return ok
+}
+
+// load fetches the manifest and checks its signature. Clearsigned
+// manifests (Fedora's CHECKSUM files) carry the signature inline.
+func (s ManifestSource) load(ctx context.Context, client *http.Client) ([]byte, error) {
+	data, err := readSource(ctx, client, s.URL)
+	if err != nil {
+		return nil, err
+	}
+
+	block, _ := clearsign.Decode(data)
+	if s.Keyring == "" {
+		if s.Signature != "" {
+			return nil, fmt.Errorf("signature given without a keyring")
+		}
+		if !s.Unsigned {
+			return nil, errUnsignedManifest
+		}
+		if block != nil {
+			return block.Plaintext, nil
+		}
+		return data, nil
+	}
+
+	keyring, err := loadKeyring(s.Keyring)
+	if err != nil {
+		return nil, err
+	}
+
+	if block != nil {
+		if _, err := openpgp.CheckDetachedSignature(keyring, bytes.NewReader(block.Bytes), block.ArmoredSignature.Body, nil); err != nil {
+			return nil, fmt.Errorf("bad signature: %w", err)
+		}
+		return block.Plaintext, nil
+	}
+
+	if s.Signature == "" {
+		return nil, fmt.Errorf("keyring given but the manifest is not signed")
+	}
+	sig, err := readSource(ctx, client, s.Signature)
+	if err != nil {
+		return nil, fmt.Errorf("signature: %w", err)
+	}
+	if _, err := checkDetachedSignature(keyring, bytes.NewReader(data), sig); err != nil {
+		return nil, fmt.Errorf("bad signature: %w", err)
+	}
+	return data, nil
+}
+
+// checkDetachedSignature accepts armored and binary signatures and returns
+// the key that made it
+func checkDetachedSignature(keyring openpgp.EntityList, data io.Reader, sig []byte) (*openpgp.Entity, error) {
+	if bytes.HasPrefix(bytes.TrimSpace(sig), []byte("-----BEGIN")) {
+		return openpgp.CheckArmoredDetachedSignature(keyring, data, bytes.NewReader(sig), nil)
+	}
+	return openpgp.CheckDetachedSignature(keyring, data, bytes.NewReader(sig), nil)
+}
+
+// loadKeyring reads an armored or binary public keyring
+func loadKeyring(p string) (openpgp.EntityList, error) {
+	data, err := os.ReadFile(ExpandHome(p))
+	if err != nil {
+		return nil, err
+	}
+	if bytes.HasPrefix(bytes.TrimSpace(data), []byte("-----BEGIN")) {
+		return openpgp.ReadArmoredKeyRing(bytes.NewReader(data))
+	}
+	return openpgp.ReadKeyRing(bytes.NewReader(data))
+}
+
+// readSource reads an http(s) URL or a local file
+func readSource(ctx context.Context, client *http.Client, src string) ([]byte, error) {
+	if !strings.HasPrefix(src, "http://") && !strings.HasPrefix(src, "https://") {
+		return os.ReadFile(ExpandHome(src))
+	}
+
+	req, err := http.NewRequestWithContext(ctx, "GET", src, nil)
+	if err != nil {
+		return nil, err
+	}
+	req.Header.Set("User-Agent", defaultUserAgent)
+	resp, err := client.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("server returned %s", resp.Status)
+	}
+	return io.ReadAll(io.LimitReader(resp.Body, 64<<20))
+}
+
+// ExpandHome replaces a leading ~/ with the user's home directory
+func ExpandHome(p string) string {
+	if strings.HasPrefix(p, "~/") {
+		if home, err := os.UserHomeDir(); err == nil {
+			return filepath.Join(home, p[2:])
+		}
+	}
+	return p
+}
@@ -0,0 +1,187 @@
+package downloader
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"io"
+	"log"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/ProtonMail/go-crypto/openpgp"
+	"github.com/ProtonMail/go-crypto/openpgp/armor"
+	"github.com/ProtonMail/go-crypto/openpgp/clearsign"
+	"github.com/ProtonMail/go-crypto/openpgp/packet"
+)
+
+const testSums = "0123456789abcdef0123456789abcdef0123456789abcdef0123456789abcdef  disk.iso\n" +
+	"fedcba9876543210fedcba9876543210fedcba9876543210fedcba9876543210 *tools/app.tar.gz\n"
+
+// testSigner makes an OpenPGP key and writes its armored public key to dir
+func testSigner(t *testing.T, dir, name string) (*openpgp.Entity, string) {
+	t.Helper()
+	e, err := openpgp.NewEntity(name, "", name+"@example.com", &packet.Config{Algorithm: packet.PubKeyAlgoEdDSA})
+	if err != nil {
+		t.Fatal(err)
+	}
+	var buf bytes.Buffer
+	w, err := armor.Encode(&buf, openpgp.PublicKeyType, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := e.Serialize(w); err != nil {
+		t.Fatal(err)
+	}
+	w.Close()
+	p := filepath.Join(dir, name+".asc")
+	if err := os.WriteFile(p, buf.Bytes(), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	return e, p
+}
+
+func TestManifestSourceLoad(t *testing.T) {
+	dir := t.TempDir()
+	signer, keyring := testSigner(t, dir, "release")
+	stranger, _ := testSigner(t, dir, "stranger")
+	write := func(name string, data []byte) string {
+		p := filepath.Join(dir, name)
+		if err := os.WriteFile(p, data, 0o644); err != nil {
+			t.Fatal(err)
+		}
+		return p
+	}
+	detach := func(e *openpgp.Entity, data string, armored bool) []byte {
+		var sig bytes.Buffer
+		sign := openpgp.DetachSign
+		if armored {
+			sign = openpgp.ArmoredDetachSign
+		}
+		if err := sign(&sig, e, strings.NewReader(data), nil); err != nil {
+			t.Fatal(err)
+		}
+		return sig.Bytes()
+	}
+	var clear bytes.Buffer
+	w, err := clearsign.Encode(&clear, signer.PrivateKey, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	w.Write([]byte(testSums))
+	w.Close()
+
+	sums := write("SHA256SUMS", []byte(testSums))
+	tampered := write("tampered", []byte(strings.Replace(testSums, "0123", "4567", 1)))
+	armoredSig := write("armored.asc", detach(signer, testSums, true))
+	binarySig := write("binary.sig", detach(signer, testSums, false))
+	strangerSig := write("stranger.sig", detach(stranger, testSums, false))
+	binary := detach(signer, testSums, false)
+	truncatedSig := write("truncated.sig", binary[:len(binary)/2])
+	garbageSig := write("garbage.sig", []byte("-----BEGIN PGP SIGNATURE-----\n\nbm90IGEgc2lnbmF0dXJl\n-----END PGP SIGNATURE-----\n"))
+	clearsigned := write("CHECKSUM", clear.Bytes())
+	badClear := write("CHECKSUM.bad", bytes.Replace(clear.Bytes(), []byte("disk.iso"), []byte("evil.iso"), 1))
+	garbageKeyring := write("garbage.asc", []byte("not a keyring"))
+
+	tests := []struct {
+		name string
+		src  ManifestSource
+		ok   bool
+	}{
+		{"unsigned", ManifestSource{URL: sums}, false},
+		{"unsigned, opted in", ManifestSource{URL: sums, Unsigned: true}, true},
+		{"clearsigned, opted in to unsigned", ManifestSource{URL: clearsigned, Unsigned: true}, true},
+		{"signature without keyring", ManifestSource{URL: sums, Signature: armoredSig, Unsigned: true}, false},
+		{"armored signature", ManifestSource{URL: sums, Signature: armoredSig, Keyring: keyring}, true},
+		{"binary signature", ManifestSource{URL: sums, Signature: binarySig, Keyring: keyring}, true},
+		{"tampered manifest", ManifestSource{URL: tampered, Signature: binarySig, Keyring: keyring}, false},
+		{"someone else's key", ManifestSource{URL: sums, Signature: strangerSig, Keyring: keyring}, false},
+		{"truncated signature", ManifestSource{URL: sums, Signature: truncatedSig, Keyring: keyring}, false},
+		{"corrupt signature", ManifestSource{URL: sums, Signature: garbageSig, Keyring: keyring}, false},
+		{"missing signature", ManifestSource{URL: sums, Signature: filepath.Join(dir, "none.sig"), Keyring: keyring}, false},
+		{"keyring without signature", ManifestSource{URL: sums, Keyring: keyring}, false},
+		{"corrupt keyring", ManifestSource{URL: sums, Signature: binarySig, Keyring: garbageKeyring}, false},
+		{"clearsigned", ManifestSource{URL: clearsigned, Keyring: keyring}, true},
+		{"tampered clearsigned", ManifestSource{URL: badClear, Keyring: keyring}, false},
+		{"missing manifest", ManifestSource{URL: filepath.Join(dir, "none"), Unsigned: true}, false},
+	}
+	for _, tt := range tests {
+		data, err := tt.src.load(context.Background(), http.DefaultClient)
+		if (err == nil) != tt.ok {
+			t.Errorf("%s: err = %v", tt.name, err)
+			continue
+		}
+		if err == nil && strings.TrimSpace(string(data)) != strings.TrimSpace(testSums) {
+			t.Errorf("%s: loaded %q", tt.name, data)
+		}
+	}
+	if _, err := (ManifestSource{URL: sums}).load(context.Background(), http.DefaultClient); !errors.Is(err, errUnsignedManifest) {
+		t.Errorf("unsigned manifest: %v", err)
+	}
+}
+
+func TestLookupTrustedChecksum(t *testing.T) {
+	dir := t.TempDir()
+	write := func(name, data string) string {
+		p := filepath.Join(dir, name)
+		if err := os.WriteFile(p, []byte(data), 0o644); err != nil {
+			t.Fatal(err)
+		}
+		return p
+	}
+	sums := write("SHA256SUMS", testSums)
+	covers := []string{"https://mirror.example/releases/*"}
+
+	tests := []struct {
+		name    string
+		sources []ManifestSource
+		url     string
+		want    string // Digest, "" for none
+		ok      bool
+	}{
+		{"listed", []ManifestSource{{URL: sums, Match: covers, Unsigned: true}}, "https://mirror.example/releases/disk.iso", "0123456789abcdef0123456789abcdef0123456789abcdef0123456789abcdef", true},
+		{"listed in a directory", []ManifestSource{{URL: sums, Match: covers, Unsigned: true}}, "https://mirror.example/releases/tools/app.tar.gz", "fedcba9876543210fedcba9876543210fedcba9876543210fedcba9876543210", true},
+		{"not listed", []ManifestSource{{URL: sums, Match: covers, Unsigned: true}}, "https://mirror.example/releases/other.iso", "", true},
+		{"not covered", []ManifestSource{{URL: sums, Match: covers}}, "https://elsewhere.example/disk.iso", "", true},
+		{"unsigned", []ManifestSource{{URL: sums, Match: covers}}, "https://mirror.example/releases/disk.iso", "", false},
+		{"truncated line", []ManifestSource{{URL: write("truncated", testSums[:40]), Match: covers, Unsigned: true}}, "https://mirror.example/releases/disk.iso", "", false},
+		{"corrupt digest", []ManifestSource{{URL: write("corrupt", "zz0123  disk.iso\n"), Match: covers, Unsigned: true}}, "https://mirror.example/releases/disk.iso", "", false},
+	}
+	for _, tt := range tests {
+		sum, err := LookupTrustedChecksum(context.Background(), http.DefaultClient, tt.sources, tt.url)
+		if (err == nil) != tt.ok {
+			t.Errorf("%s: err = %v", tt.name, err)
+			continue
+		}
+		got := ""
+		if sum != nil {
+			got = sum.Value
+		}
+		if got != tt.want {
+			t.Errorf("%s: digest %q, want %q", tt.name, got, tt.want)
+		}
+	}
+}
+
+func TestLookupTrustedChecksumVerifiesTLS(t *testing.T) {
+	srv := httptest.NewUnstartedServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte(testSums))
+	}))
+	srv.Config.ErrorLog = log.New(io.Discard, "", 0) // The refused handshake
+	srv.StartTLS()
+	defer srv.Close()
+	sources := []ManifestSource{{URL: srv.URL + "/SHA256SUMS", Unsigned: true}}
+
+	// The test server's certificate isn't in the system roots
+	if _, err := LookupTrustedChecksum(context.Background(), NewClient(Config{URL: srv.URL, VerifyTLS: true}), sources, srv.URL+"/disk.iso"); err == nil {
+		t.Error("manifest fetched over an unverified connection")
+	}
+	sum, err := LookupTrustedChecksum(context.Background(), NewClient(Config{URL: srv.URL}), sources, srv.URL+"/disk.iso")
+	if err != nil || sum == nil {
+		t.Errorf("without VerifyTLS: %v, %v", sum, err)
+	}
+}
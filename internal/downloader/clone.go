@@ -0,0 +1,76 @@
+package downloader
+
+import (
+	"io"
+	"os"
+)
+
+// Part boundaries are rounded down to this, so that every part lands at an
+// output offset file systems can clone to
+const cloneAlign = 64 * 1024
+
+// mergeFile joins the parts into the single temporary output. Where the file
+// system shares extents between files (btrfs, XFS, APFS) the parts are
+// cloned into it, which turns the merge into a metadata operation;
+// elsewhere, or when a clone is refused, their bytes are copied.
+func (e *Engine) mergeFile() error {
+	out := tmpPath(e.Config.OutputName)
+	parts := e.Parts
+	var off int64
+
+	os.Remove(out)
+	cloned := len(parts) > 0 && cloneFile(parts[0].TempPath, out) == nil
+	if cloned {
+		info, err := os.Stat(out)
+		if err != nil {
+			return err
+		}
+		off = info.Size()
+		os.Remove(parts[0].TempPath)
+		parts = parts[1:]
+	}
+	f, err := os.OpenFile(out, os.O_WRONLY|os.O_CREATE, 0o644)
+	if err != nil {
+		return err
+	}
+	if !cloned {
+		if err := e.allocate(f, e.Stats.GetTotal()); err != nil {
+			f.Close()
+			return err
+		}
+	}
+	drop := e.newCacheDropper(f, off)
+	for _, part := range parts {
+		src, err := os.Open(part.TempPath)
+		if err != nil {
+			f.Close()
+			return err
+		}
+		n, err := appendPart(f, src, off)
+		src.Close()
+		if err != nil {
+			f.Close()
+			return err
+		}
+		off += n
+		drop.wrote(off)
+		os.Remove(part.TempPath)
+	}
+	drop.flush(off)
+	return f.Close()
+}
+
+// appendPart puts all of src at off in dst and returns its size
+func appendPart(dst, src *os.File, off int64) (int64, error) {
+	info, err := src.Stat()
+	if err != nil {
+		return 0, err
+	}
+	if info.Size() > 0 && cloneRange(dst, src, off, info.Size()) == nil {
+		return info.Size(), nil
+	}
+	if _, err := dst.Seek(off, io.SeekStart); err != nil {
+		return 0, err
+	}
+	return io.Copy(dst, src)
+}
@@ -0,0 +1,18 @@
+package downloader
+
+import (
+	"errors"
+	"os"
+
+	"golang.org/x/sys/unix"
+)
+
+// cloneFile creates dst as an APFS clone of src
+func cloneFile(src, dst string) error {
+	return unix.Clonefile(src, dst, unix.CLONE_NOFOLLOW)
+}
+
+// cloneRange has no macOS counterpart, clonefile only makes whole files
+func cloneRange(dst, src *os.File, off, n int64) error {
+	return errors.ErrUnsupported
+}
@@ -0,0 +1,36 @@
+package downloader
+
+import (
+	"os"
+
+	"golang.org/x/sys/unix"
+)
+
+// cloneFile creates dst sharing src's extents (FICLONE)
+func cloneFile(src, dst string) error {
+	in, err := os.Open(src)
+	if err != nil {
+		return err
+	}
+	defer in.Close()
+	out, err := os.OpenFile(dst, os.O_WRONLY|os.O_CREATE|os.O_EXCL, 0o644)
+	if err != nil {
+		return err
+	}
+	if err := unix.IoctlFileClone(int(out.Fd()), int(in.Fd())); err != nil {
+		out.Close()
+		os.Remove(dst)
+		return err
+	}
+	return out.Close()
+}
+
+// cloneRange shares the first n bytes of src at off in dst (FICLONERANGE).
+// off must be block aligned, and so must n unless it ends src.
+func cloneRange(dst, src *os.File, off, n int64) error {
+	return unix.IoctlFileCloneRange(int(dst.Fd()), &unix.FileCloneRange{
+		Src_fd:      int64(src.Fd()),
+		Src_length:  uint64(n),
+		Dest_offset: uint64(off),
+	})
+}
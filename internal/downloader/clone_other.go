@@ -0,0 +1,16 @@
+//go:build !linux && !darwin
+
+package downloader
+
+import (
+	"errors"
+	"os"
+)
+
+func cloneFile(src, dst string) error {
+	return errors.ErrUnsupported
+}
+
+func cloneRange(dst, src *os.File, off, n int64) error {
+	return errors.ErrUnsupported
+}
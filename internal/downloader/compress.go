@@ -0,0 +1,106 @@
+package downloader
+
+import (
+	"bufio"
+	"compress/flate"
+	"compress/gzip"
+	"compress/zlib"
+	"fmt"
+	"io"
+	"net/http"
+	"strings"
+
+	"github.com/klauspost/compress/zstd"
+)
+
+// Some servers only hand out a compressed representation. Byte ranges of it
+// can't be decoded on their own and its decoded size is unknown up front,
+// so a compressed response downloads over one connection without resume.
+
+// acceptEncoding is what Config.Compressed offers
+const acceptEncoding = "gzip, deflate, zstd"
+
+// offerEncodings asks for a compressed response. Setting Accept-Encoding
+// also stops net/http from asking for gzip and decoding it on its own.
+func (e *Engine) offerEncodings(req *http.Request) {
+	if e.Config.Compressed {
+		req.Header.Set("Accept-Encoding", acceptEncoding)
+	}
+}
+
+// encoded reports whether a response to offerEncodings came compressed
+func encoded(resp *http.Response) bool {
+	ce := strings.TrimSpace(resp.Header.Get("Content-Encoding"))
+	return ce != "" && !strings.EqualFold(ce, "identity")
+}
+
+// decode wraps a response body in the decoder for its Content-Encoding and
+// counts the bytes as received in Stats.WireBytes
+func (e *Engine) decode(resp *http.Response) (io.ReadCloser, error) {
+	if !e.Config.Compressed || !encoded(resp) {
+		return resp.Body, nil
+	}
+	if e.encoding == "" {
+		// The probe got the file as is, a size that doesn't hold for
+		// what the decoder puts out
+		e.encoding = resp.Header.Get("Content-Encoding")
+		e.Stats.SetTotal(0)
+	}
+	wire := bufio.NewReader(&wireCounter{r: resp.Body, stats: e.Stats})
+	var (
+		r      io.Reader
+		closer io.Closer = resp.Body
+		err    error
+	)
+	switch ce := strings.ToLower(strings.TrimSpace(resp.Header.Get("Content-Encoding"))); ce {
+	case "gzip", "x-gzip":
+		r, err = gzip.NewReader(wire)
+	case "deflate":
+		// Meant to be zlib wrapped, some servers send it raw
+		if head, perr := wire.Peek(2); perr == nil && head[0]&0x0f == 8 && (uint16(head[0])<<8|uint16(head[1]))%31 == 0 {
+			r, err = zlib.NewReader(wire)
+		} else {
+			r = flate.NewReader(wire)
+		}
+	case "zstd":
+		// RFC 9659 keeps HTTP encoders to an 8 MiB window, this leaves
+		// room for plain zstd files sent encoded
+		var zr *zstd.Decoder
+		if zr, err = zstd.NewReader(wire, zstd.WithDecoderConcurrency(1), zstd.WithDecoderMaxWindow(128<<20)); err == nil {
+			r, closer = zr, zstdCloser{zr, resp.Body}
+		}
+	default:
+		err = fmt.Errorf("unsupported Content-Encoding %q", ce)
+	}
+	if err != nil {
+		resp.Body.Close()
+		return nil, fmt.Errorf("failed to decode the response: %w", err)
+	}
+	return struct {
+		io.Reader
+		io.Closer
+	}{r, closer}, nil
+}
+
+// zstdCloser releases the decoder along with the body
+type zstdCloser struct {
+	zr   *zstd.Decoder
+	body io.Closer
+}
+
+func (c zstdCloser) Close() error {
+	c.zr.Close()
+	return c.body.Close()
+}
+
+// wireCounter counts the compressed bytes as they arrive
+type wireCounter struct {
+	r     io.Reader
+	stats *Stats
+}
+
+func (w *wireCounter) Read(p []byte) (int, error) {
+	n, err := w.r.Read(p)
+	w.stats.AddWire(int64(n))
+	return n, err
+}
@@ -0,0 +1,50 @@
+package downloader
+
+import (
+	"bytes"
+	"compress/gzip"
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestCompressedDownload(t *testing.T) {
+	data := bytes.Repeat([]byte("warp-dl compressed response "), 40000)
+	var gz bytes.Buffer
+	zw := gzip.NewWriter(&gz)
+	zw.Write(data)
+	zw.Close()
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Header.Get("Accept-Encoding") != acceptEncoding {
+			t.Errorf("Accept-Encoding = %q", r.Header.Get("Accept-Encoding"))
+		}
+		w.Header().Set("Content-Encoding", "gzip")
+		w.Header().Set("Accept-Ranges", "bytes")
+		if r.Method == http.MethodGet {
+			w.Write(gz.Bytes())
+		}
+	}))
+	defer srv.Close()
+
+	out := filepath.Join(t.TempDir(), "file.txt")
+	e := NewEngine(Config{URL: srv.URL + "/file.txt", OutputName: out, Concurrency: 4, Compressed: true})
+	if err := e.Start(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+	got, err := os.ReadFile(out)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(got, data) {
+		t.Fatalf("wrote %d bytes, want the %d decoded ones", len(got), len(data))
+	}
+	if total, done := e.Stats.GetTotal(), e.Stats.GetDownloaded(); total != int64(len(data)) || done != int64(len(data)) {
+		t.Errorf("total %d, downloaded %d, want %d", total, done, len(data))
+	}
+	if wire := e.Stats.WireBytes; wire != int64(gz.Len()) {
+		t.Errorf("wire bytes %d, want %d", wire, gz.Len())
+	}
+}
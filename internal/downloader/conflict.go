@@ -0,0 +1,129 @@
+package downloader
+
+import (
+	"fmt"
+	"hash/crc32"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+// ConflictMode decides what happens when the output file already exists
+type ConflictMode int
+
+const (
+	ConflictOverwrite ConflictMode = iota // Replace it once the download completes
+	ConflictSkip                          // Keep it and download nothing
+	ConflictRename                        // Save as "name (1).ext", the first free number
+	ConflictResume                        // Take it as the start of the remote file and fetch the rest
+)
+
+// ParseConflictMode parses the --on-conflict flag
+func ParseConflictMode(s string) (ConflictMode, error) {
+	switch s {
+	case "overwrite", "":
+		return ConflictOverwrite, nil
+	case "skip":
+		return ConflictSkip, nil
+	case "rename":
+		return ConflictRename, nil
+	case "resume":
+		return ConflictResume, nil
+	}
+	return 0, fmt.Errorf("invalid --on-conflict %q (want overwrite, skip, rename or resume)", s)
+}
+
+// resolveConflict applies Config.OnConflict to an output that already
+// exists. A resume journal next to it means an interrupted run of this
+// download, which carries on as usual.
+func (e *Engine) resolveConflict() error {
+	info, err := os.Stat(e.Config.OutputName)
+	if err != nil || info.IsDir() {
+		return nil
+	}
+	if _, err := os.Stat(statePath(e.Config.OutputName)); err == nil {
+		return nil
+	}
+
+	switch e.Config.OnConflict {
+	case ConflictSkip:
+		e.skip(info.Size(), "already exists")
+	case ConflictRename:
+		e.Config.OutputName = freeName(e.Config.OutputName)
+		e.trace("output exists, saving as %s", e.Config.OutputName)
+	case ConflictResume:
+		total := e.Stats.GetTotal()
+		switch size := info.Size(); {
+		case !e.IsResumable:
+			return fmt.Errorf("can't resume %s: the server doesn't support byte ranges", e.Config.OutputName)
+		case size > total:
+			return fmt.Errorf("can't resume %s: it is larger than the remote file (%d > %d bytes)", e.Config.OutputName, size, total)
+		case size == total:
+			e.skip(size, "is already complete")
+		default:
+			e.existing = size
+			e.trace("resuming %s from byte %d", e.Config.OutputName, size)
+		}
+	}
+	return nil
+}
+
+// skip leaves the existing output as it is
+func (e *Engine) skip(size int64, why string) {
+	e.Skipped = fmt.Sprintf("%s %s", e.Config.OutputName, why)
+	e.Stats.SetTotal(size)
+	e.Stats.SetDownloaded(size)
+	e.trace("skipped, %s", e.Skipped)
+}
+
+// freeName finds "name (n).ext" that doesn't exist yet
+func freeName(path string) string {
+	ext := filepath.Ext(path)
+	stem := strings.TrimSuffix(path, ext)
+	for n := 1; ; n++ {
+		candidate := fmt.Sprintf("%s (%d)%s", stem, n, ext)
+		if _, err := os.Stat(candidate); err != nil {
+			return candidate
+		}
+	}
+}
+
+// adoptExisting copies the existing output's bytes into the parts they
+// belong to, so the download only fetches what comes after them. The
+// original stays in place until the finished file replaces it.
+func (e *Engine) adoptExisting() error {
+	if e.existing == 0 {
+		return nil
+	}
+	if e.volumeSize > 0 {
+		return fmt.Errorf("can't resume %s into a split output", e.Config.OutputName)
+	}
+	src, err := os.Open(e.Config.OutputName)
+	if err != nil {
+		return err
+	}
+	defer src.Close()
+
+	for _, p := range e.Parts {
+		off := p.Start - e.rangeStart
+		n := min(e.existing-off, p.End-p.Start+1)
+		if n <= 0 {
+			break
+		}
+		dst, err := os.OpenFile(p.TempPath, os.O_WRONLY|os.O_CREATE|os.O_TRUNC, 0o644)
+		if err != nil {
+			return err
+		}
+		h := crc32.NewIEEE()
+		_, err = io.Copy(io.MultiWriter(dst, h), io.NewSectionReader(src, off, n))
+		if cerr := dst.Close(); err == nil {
+			err = cerr
+		}
+		if err != nil {
+			return fmt.Errorf("failed to copy %s into part %d: %w", e.Config.OutputName, p.ID, err)
+		}
+		p.Downloaded, p.crc = n, h.Sum32()
+	}
+	return nil
+}
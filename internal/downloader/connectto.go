@@ -0,0 +1,52 @@
+package downloader
+
+import (
+	"context"
+	"fmt"
+	"net"
+	"strings"
+)
+
+// Config.ConnectTo is curl's --connect-to: the connection for a host goes
+// to another address while the Host header, cookies and, unless Config.SNI
+// says otherwise, the TLS server name stay the URL's. That reaches a site
+// whose DNS is poisoned, or one front of a CDN. Through a proxy the proxy
+// connects, and the map doesn't apply.
+
+// ParseConnectTo parses HOST:ADDR, the address an IP or IP:port; without a
+// port the URL's is kept
+func ParseConnectTo(s string) (host, addr string, err error) {
+	host, addr, ok := strings.Cut(s, ":")
+	if !ok || host == "" || addr == "" {
+		return "", "", fmt.Errorf("invalid connect-to %q: want host:ip or host:ip:port", s)
+	}
+	ip := addr
+	if h, _, err := net.SplitHostPort(addr); err == nil {
+		ip = h
+	}
+	if net.ParseIP(strings.Trim(ip, "[]")) == nil {
+		return "", "", fmt.Errorf("invalid connect-to %q: %s is not an IP address", s, ip)
+	}
+	return strings.ToLower(host), addr, nil
+}
+
+// connectToDial sends the connections to a host in m to its address
+func connectToDial(m map[string]string, dial func(ctx context.Context, network, addr string) (net.Conn, error)) func(ctx context.Context, network, addr string) (net.Conn, error) {
+	if dial == nil {
+		dial = func(ctx context.Context, network, addr string) (net.Conn, error) {
+			d := linkDialer(ctx)
+			return d.DialContext(ctx, network, addr)
+		}
+	}
+	return func(ctx context.Context, network, addr string) (net.Conn, error) {
+		host, port, err := net.SplitHostPort(addr)
+		if to, ok := m[strings.ToLower(host)]; err == nil && ok {
+			if _, _, err := net.SplitHostPort(to); err == nil {
+				addr = to
+			} else {
+				addr = net.JoinHostPort(strings.Trim(to, "[]"), port)
+			}
+		}
+		return dial(ctx, network, addr)
+	}
+}
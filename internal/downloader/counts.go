@@ -0,0 +1,22 @@
+package downloader
+
+import "sync/atomic"
+
+// Counts are totals over every download of the process, for monitoring a
+// long running daemon
+type Counts struct {
+	Retries     int64 // Parts, segments and whole downloads tried again
+	DoHLookups  int64 // Host names resolved over DNS-over-HTTPS
+	DoHFailures int64 // Lookups no DoH server could answer
+}
+
+var counts Counts
+
+// ReadCounts returns the current totals
+func ReadCounts() Counts {
+	return Counts{
+		Retries:     atomic.LoadInt64(&counts.Retries),
+		DoHLookups:  atomic.LoadInt64(&counts.DoHLookups),
+		DoHFailures: atomic.LoadInt64(&counts.DoHFailures),
+	}
+}
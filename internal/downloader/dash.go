@@ -0,0 +1,469 @@
+package downloader
+
+import (
+	"context"
+	"encoding/xml"
+	"fmt"
+	"math"
+	"net/http"
+	"net/url"
+	"os"
+	"path/filepath"
+	"regexp"
+	"strconv"
+	"strings"
+)
+
+// DASHDownloader fetches one representation of an MPEG-DASH manifest and
+// concatenates its initialization and media segments into a single file
+type DASHDownloader struct {
+	Config Config
+	Stats  *Stats
+	Client *http.Client
+}
+
+type mpdXML struct {
+	Type     string      `xml:"type,attr"`
+	Duration string      `xml:"mediaPresentationDuration,attr"`
+	BaseURL  string      `xml:"BaseURL"`
+	Periods  []mpdPeriod `xml:"Period"`
+}
+
+type mpdPeriod struct {
+	Duration       string             `xml:"duration,attr"`
+	BaseURL        string             `xml:"BaseURL"`
+	AdaptationSets []mpdAdaptationSet `xml:"AdaptationSet"`
+}
+
+type mpdAdaptationSet struct {
+	ContentType     string              `xml:"contentType,attr"`
+	MimeType        string              `xml:"mimeType,attr"`
+	BaseURL         string              `xml:"BaseURL"`
+	SegmentTemplate *mpdSegmentTemplate `xml:"SegmentTemplate"`
+	SegmentList     *mpdSegmentList     `xml:"SegmentList"`
+	Representations []mpdRepresentation `xml:"Representation"`
+}
+
+type mpdRepresentation struct {
+	ID              string              `xml:"id,attr"`
+	Bandwidth       int64               `xml:"bandwidth,attr"`
+	Height          int                 `xml:"height,attr"`
+	MimeType        string              `xml:"mimeType,attr"`
+	BaseURL         string              `xml:"BaseURL"`
+	SegmentTemplate *mpdSegmentTemplate `xml:"SegmentTemplate"`
+	SegmentList     *mpdSegmentList     `xml:"SegmentList"`
+}
+
+type mpdSegmentTemplate struct {
+	Media          string `xml:"media,attr"`
+	Initialization string `xml:"initialization,attr"`
+	StartNumber    *int64 `xml:"startNumber,attr"`
+	Timescale      int64  `xml:"timescale,attr"`
+	Duration       int64  `xml:"duration,attr"`
+	Timeline       []struct {
+		T *int64 `xml:"t,attr"`
+		D int64  `xml:"d,attr"`
+		R int64  `xml:"r,attr"`
+	} `xml:"SegmentTimeline>S"`
+}
+
+type mpdSegmentList struct {
+	Initialization *struct {
+		SourceURL string `xml:"sourceURL,attr"`
+		Range     string `xml:"range,attr"`
+	} `xml:"Initialization"`
+	SegmentURLs []struct {
+		Media      string `xml:"media,attr"`
+		MediaRange string `xml:"mediaRange,attr"`
+	} `xml:"SegmentURL"`
+}
+
+var mpdTemplateVar = regexp.MustCompile(`\$(RepresentationID|Number|Time|Bandwidth)(?:%0(\d+)d)?\$`)
+
+// IsDASH reports whether url points at an MPD manifest
+func IsDASH(url string) bool {
+	if i := strings.IndexAny(url, "?#"); i >= 0 {
+		url = url[:i]
+	}
+	return strings.EqualFold(filepath.Ext(url), ".mpd")
+}
+
+// NewDASHDownloader creates a downloader for the manifest at cfg.URL
+func NewDASHDownloader(cfg Config) *DASHDownloader {
+	return &DASHDownloader{
+		Config: cfg,
+		Stats:  &Stats{},
+		Client: NewClient(cfg),
+	}
+}
+
+// Progress returns the live statistics of the download
+func (d *DASHDownloader) Progress() *Stats {
+	return d.Stats
+}
+
+// Start parses the manifest, downloads the selected representation and
+// writes the output
+func (d *DASHDownloader) Start(ctx context.Context) error {
+	body, err := fetchBody(ctx, d.Client, d.Config.URL)
+	if err != nil {
+		return fmt.Errorf("failed to fetch manifest: %w", err)
+	}
+
+	var mpd mpdXML
+	if err := xml.Unmarshal(body, &mpd); err != nil {
+		return fmt.Errorf("invalid manifest: %w", err)
+	}
+	if mpd.Type == "dynamic" {
+		return fmt.Errorf("live DASH streams are not supported")
+	}
+	if len(mpd.Periods) == 0 {
+		return fmt.Errorf("manifest has no periods")
+	}
+
+	base, err := url.Parse(d.Config.URL)
+	if err != nil {
+		return err
+	}
+	base, err = resolveBaseURL(base, mpd.BaseURL)
+	if err != nil {
+		return err
+	}
+
+	track := d.Config.Track
+	if track == "" {
+		track = "video"
+	}
+
+	var segments []mediaSegment
+	var mimeType string
+	for pi, period := range mpd.Periods {
+		dur := period.Duration
+		if dur == "" && len(mpd.Periods) == 1 {
+			dur = mpd.Duration
+		}
+		periodSecs, err := parseISODuration(dur)
+		if err != nil {
+			return fmt.Errorf("period %d: %w", pi, err)
+		}
+		periodBase, err := resolveBaseURL(base, period.BaseURL)
+		if err != nil {
+			return err
+		}
+
+		as := findAdaptationSet(period.AdaptationSets, track)
+		if as == nil {
+			return fmt.Errorf("period %d has no %s adaptation set", pi, track)
+		}
+		rep, err := selectRepresentation(as.Representations, d.Config.Quality)
+		if err != nil {
+			return fmt.Errorf("period %d: %w", pi, err)
+		}
+
+		if mimeType == "" {
+			mimeType = rep.MimeType
+			if mimeType == "" {
+				mimeType = as.MimeType
+			}
+		}
+
+		segs, err := representationSegments(periodBase, as, rep, periodSecs, pi == 0)
+		if err != nil {
+			return fmt.Errorf("period %d: %w", pi, err)
+		}
+		segments = append(segments, segs...)
+	}
+
+	if d.Config.OutputName == "" {
+		name := filepath.Base(strings.SplitN(d.Config.URL, "?", 2)[0])
+		d.Config.OutputName = d.Config.defaultOutput(strings.TrimSuffix(name, filepath.Ext(name)) + mimeExtension(mimeType, track))
+	}
+	if dir := filepath.Dir(d.Config.OutputName); dir != "." {
+		if err := os.MkdirAll(dir, 0o755); err != nil {
+			return err
+		}
+	}
+
+	paths := make([]string, len(segments))
+	for i := range segments {
+		paths[i] = fmt.Sprintf("%s.seg%d", d.Config.OutputName, i)
+	}
+
+	fetcher := &segmentFetcher{
+		client:      d.Client,
+		stats:       d.Stats,
+		concurrency: d.Config.Concurrency,
+		retry:       d.Config.retryPolicy(),
+		limiter:     d.Config.Limiter,
+	}
+	if err := fetcher.fetchAll(ctx, segments, paths); err != nil {
+		return err
+	}
+	tmp := tmpPath(d.Config.OutputName)
+	if err := concatFiles(tmp, paths); err != nil {
+		return fmt.Errorf("failed to concatenate segments: %w", err)
+	}
+
+	if d.Config.Checksum != nil {
+		if err := d.Config.Checksum.Verify(tmp); err != nil {
+			return fmt.Errorf("verification failed: %w", err)
+		}
+	}
+	return commitFile(tmp, d.Config.OutputName, d.Config.Fsync)
+}
+
+func findAdaptationSet(sets []mpdAdaptationSet, track string) *mpdAdaptationSet {
+	for i := range sets {
+		kind := sets[i].ContentType
+		if kind == "" {
+			mime := sets[i].MimeType
+			if mime == "" && len(sets[i].Representations) > 0 {
+				mime = sets[i].Representations[0].MimeType
+			}
+			kind, _, _ = strings.Cut(mime, "/")
+		}
+		if strings.EqualFold(kind, track) {
+			return &sets[i]
+		}
+	}
+	return nil
+}
+
+func selectRepresentation(reps []mpdRepresentation, quality string) (*mpdRepresentation, error) {
+	if len(reps) == 0 {
+		return nil, fmt.Errorf("adaptation set has no representations")
+	}
+	variants := make([]streamVariant, len(reps))
+	for i, r := range reps {
+		variants[i] = streamVariant{Bandwidth: r.Bandwidth, Height: r.Height, index: i}
+	}
+	v, err := selectVariant(variants, quality)
+	if err != nil {
+		return nil, err
+	}
+	return &reps[v.index], nil
+}
+
+// representationSegments lists the init segment (only for the first
+// period, later periods repeat it) followed by all media segments
+func representationSegments(base *url.URL, as *mpdAdaptationSet, rep *mpdRepresentation, periodSecs float64, withInit bool) ([]mediaSegment, error) {
+	base, err := resolveBaseURL(base, as.BaseURL)
+	if err != nil {
+		return nil, err
+	}
+	base, err = resolveBaseURL(base, rep.BaseURL)
+	if err != nil {
+		return nil, err
+	}
+
+	resolve := func(ref string, byteRange string) (mediaSegment, error) {
+		u, err := base.Parse(ref)
+		if err != nil {
+			return mediaSegment{}, err
+		}
+		seg := mediaSegment{URI: u.String(), Length: -1}
+		if byteRange != "" {
+			r, err := ParseByteRange(byteRange)
+			if err != nil || r.Start < 0 || r.End < 0 {
+				return mediaSegment{}, fmt.Errorf("invalid segment range %q", byteRange)
+			}
+			seg.Offset, seg.Length = r.Start, r.End-r.Start+1
+		}
+		return seg, nil
+	}
+
+	tmpl := rep.SegmentTemplate
+	if tmpl == nil {
+		tmpl = as.SegmentTemplate
+	}
+	list := rep.SegmentList
+	if list == nil {
+		list = as.SegmentList
+	}
+
+	var segs []mediaSegment
+	switch {
+	case tmpl != nil:
+		if withInit && tmpl.Initialization != "" {
+			seg, err := resolve(expandTemplate(tmpl.Initialization, rep, 0, 0), "")
+			if err != nil {
+				return nil, err
+			}
+			segs = append(segs, seg)
+		}
+		numbers, err := templateNumbers(tmpl, periodSecs)
+		if err != nil {
+			return nil, fmt.Errorf("representation %q: %w", rep.ID, err)
+		}
+		for _, nt := range numbers {
+			seg, err := resolve(expandTemplate(tmpl.Media, rep, nt[0], nt[1]), "")
+			if err != nil {
+				return nil, err
+			}
+			segs = append(segs, seg)
+		}
+
+	case list != nil:
+		if withInit && list.Initialization != nil {
+			ref := list.Initialization.SourceURL
+			if ref == "" {
+				ref = base.String()
+			}
+			seg, err := resolve(ref, list.Initialization.Range)
+			if err != nil {
+				return nil, err
+			}
+			segs = append(segs, seg)
+		}
+		for _, su := range list.SegmentURLs {
+			ref := su.Media
+			if ref == "" {
+				ref = base.String()
+			}
+			seg, err := resolve(ref, su.MediaRange)
+			if err != nil {
+				return nil, err
+			}
+			segs = append(segs, seg)
+		}
+
+	default:
+		// SegmentBase or plain BaseURL: the whole representation is one file
+		seg, err := resolve(base.String(), "")
+		if err != nil {
+			return nil, err
+		}
+		segs = append(segs, seg)
+	}
+
+	if len(segs) == 0 {
+		return nil, fmt.Errorf("representation %q has no segments", rep.ID)
+	}
+	return segs, nil
+}
+
+// maxDASHSegments bounds the segments a template expands to, a day of 1s
+// segments is far below it. A repeat count or duration from the server
+// could otherwise ask for more than memory holds.
+const maxDASHSegments = 1 << 20
+
+var errTooManySegments = fmt.Errorf("manifest lists more than %d segments", maxDASHSegments)
+
+// templateNumbers returns the ($Number$, $Time$) pairs of every segment
+func templateNumbers(tmpl *mpdSegmentTemplate, periodSecs float64) ([][2]int64, error) {
+	number := int64(1)
+	if tmpl.StartNumber != nil {
+		number = *tmpl.StartNumber
+	}
+	timescale := tmpl.Timescale
+	if timescale <= 0 {
+		timescale = 1
+	}
+	periodEnd := int64(periodSecs * float64(timescale))
+
+	var out [][2]int64
+	if len(tmpl.Timeline) > 0 {
+		var t int64
+		for i, s := range tmpl.Timeline {
+			if s.T != nil {
+				t = *s.T
+			}
+			repeat := s.R
+			if repeat < 0 {
+				// Repeat until the next S element or the end of the period
+				end := periodEnd
+				if i+1 < len(tmpl.Timeline) && tmpl.Timeline[i+1].T != nil {
+					end = *tmpl.Timeline[i+1].T
+				}
+				if s.D > 0 {
+					repeat = int64(math.Ceil(float64(end-t)/float64(s.D))) - 1
+				}
+			}
+			if repeat >= int64(maxDASHSegments-len(out)) {
+				return nil, errTooManySegments
+			}
+			for r := int64(0); r <= repeat; r++ {
+				out = append(out, [2]int64{number, t})
+				number++
+				t += s.D
+			}
+		}
+		return out, nil
+	}
+
+	if tmpl.Duration <= 0 {
+		return nil, nil
+	}
+	count := math.Ceil(float64(periodEnd) / float64(tmpl.Duration))
+	if count > maxDASHSegments {
+		return nil, errTooManySegments
+	}
+	for i := int64(0); i < int64(count); i++ {
+		out = append(out, [2]int64{number + i, i * tmpl.Duration})
+	}
+	return out, nil
+}
+
+func expandTemplate(tmpl string, rep *mpdRepresentation, number, time int64) string {
+	const escaped = "\x00"
+	s := strings.ReplaceAll(tmpl, "$$", escaped)
+	s = mpdTemplateVar.ReplaceAllStringFunc(s, func(m string) string {
+		sub := mpdTemplateVar.FindStringSubmatch(m)
+		var v int64
+		switch sub[1] {
+		case "RepresentationID":
+			return rep.ID
+		case "Number":
+			v = number
+		case "Time":
+			v = time
+		case "Bandwidth":
+			v = rep.Bandwidth
+		}
+		if sub[2] != "" {
+			width, _ := strconv.Atoi(sub[2])
+			return fmt.Sprintf("%0*d", width, v)
+		}
+		return strconv.FormatInt(v, 10)
+	})
+	return strings.ReplaceAll(s, escaped, "$")
+}
+
+func resolveBaseURL(base *url.URL, ref string) (*url.URL, error) {
+	ref = strings.TrimSpace(ref)
+	if ref == "" {
+		return base, nil
+	}
+	return base.Parse(ref)
+}
+
+var isoDuration = regexp.MustCompile(`^P(?:(\d+(?:\.\d+)?)D)?(?:T(?:(\d+(?:\.\d+)?)H)?(?:(\d+(?:\.\d+)?)M)?(?:(\d+(?:\.\d+)?)S)?)?$`)
+
+// parseISODuration parses the xs:duration subset used by MPDs, e.g. PT1H2M3.5S
+func parseISODuration(s string) (float64, error) {
+	if s == "" {
+		return 0, nil
+	}
+	m := isoDuration.FindStringSubmatch(strings.TrimSpace(s))
+	if m == nil {
+		return 0, fmt.Errorf("invalid duration %q", s)
+	}
+	var secs float64
+	for i, mult := range []float64{86400, 3600, 60, 1} {
+		if m[i+1] != "" {
+			v, _ := strconv.ParseFloat(m[i+1], 64)
+			secs += v * mult
+		}
+	}
+	return secs, nil
+}
+
+func mimeExtension(mime, track string) string {
+	switch {
+	case strings.Contains(mime, "webm"):
+		return ".webm"
+	case track == "audio":
+		return ".m4a"
+	}
+	return ".mp4"
+}
@@ -0,0 +1,120 @@
+package downloader
+
+import (
+	"encoding/xml"
+	"errors"
+	"reflect"
+	"testing"
+)
+
+func TestTemplateNumbers(t *testing.T) {
+	tests := []struct {
+		name   string
+		tmpl   string
+		period float64
+		want   [][2]int64
+		err    error
+	}{
+		{
+			name: "timeline repeats",
+			tmpl: `<SegmentTemplate startNumber="5"><SegmentTimeline><S t="100" d="10" r="2"/><S d="4"/></SegmentTimeline></SegmentTemplate>`,
+			want: [][2]int64{{5, 100}, {6, 110}, {7, 120}, {8, 130}},
+		},
+		{
+			name: "repeat to the next S",
+			tmpl: `<SegmentTemplate><SegmentTimeline><S t="0" d="3" r="-1"/><S t="10" d="5"/></SegmentTimeline></SegmentTemplate>`,
+			want: [][2]int64{{1, 0}, {2, 3}, {3, 6}, {4, 9}, {5, 10}},
+		},
+		{
+			name:   "repeat to the end of the period",
+			tmpl:   `<SegmentTemplate timescale="10"><SegmentTimeline><S t="0" d="20" r="-1"/></SegmentTimeline></SegmentTemplate>`,
+			period: 5,
+			want:   [][2]int64{{1, 0}, {2, 20}, {3, 40}},
+		},
+		{
+			name:   "fixed duration",
+			tmpl:   `<SegmentTemplate timescale="1000" duration="4000" startNumber="0"/>`,
+			period: 10,
+			want:   [][2]int64{{0, 0}, {1, 4000}, {2, 8000}},
+		},
+		{
+			name: "no duration",
+			tmpl: `<SegmentTemplate/>`,
+		},
+		{
+			name: "huge repeat",
+			tmpl: `<SegmentTemplate><SegmentTimeline><S d="1" r="1000000000000"/></SegmentTimeline></SegmentTemplate>`,
+			err:  errTooManySegments,
+		},
+		{
+			name: "repeats adding up",
+			tmpl: `<SegmentTemplate><SegmentTimeline><S d="1" r="600000"/><S d="1" r="600000"/></SegmentTimeline></SegmentTemplate>`,
+			err:  errTooManySegments,
+		},
+		{
+			name:   "open repeat over a huge period",
+			tmpl:   `<SegmentTemplate timescale="1000"><SegmentTimeline><S t="0" d="1" r="-1"/></SegmentTimeline></SegmentTemplate>`,
+			period: 1e9,
+			err:    errTooManySegments,
+		},
+		{
+			name:   "short duration over a huge period",
+			tmpl:   `<SegmentTemplate duration="1"/>`,
+			period: 1e15,
+			err:    errTooManySegments,
+		},
+	}
+	for _, tt := range tests {
+		var tmpl mpdSegmentTemplate
+		if err := xml.Unmarshal([]byte(tt.tmpl), &tmpl); err != nil {
+			t.Fatalf("%s: %v", tt.name, err)
+		}
+		got, err := templateNumbers(&tmpl, tt.period)
+		if !errors.Is(err, tt.err) {
+			t.Errorf("%s: err = %v, want %v", tt.name, err, tt.err)
+			continue
+		}
+		if !reflect.DeepEqual(got, tt.want) {
+			t.Errorf("%s: got %v, want %v", tt.name, got, tt.want)
+		}
+	}
+}
+
+func TestExpandTemplate(t *testing.T) {
+	rep := &mpdRepresentation{ID: "v1", Bandwidth: 800000}
+	tests := []struct {
+		tmpl, want string
+	}{
+		{"$RepresentationID$/seg-$Number$.m4s", "v1/seg-42.m4s"},
+		{"$RepresentationID$/$Number%05d$.m4s", "v1/00042.m4s"},
+		{"t$Time$-b$Bandwidth$.mp4", "t9000-b800000.mp4"},
+		{"cost$$$Number$", "cost$42"},
+		{"$Unknown$", "$Unknown$"},
+	}
+	for _, tt := range tests {
+		if got := expandTemplate(tt.tmpl, rep, 42, 9000); got != tt.want {
+			t.Errorf("expandTemplate(%q) = %q, want %q", tt.tmpl, got, tt.want)
+		}
+	}
+}
+
+func TestParseISODuration(t *testing.T) {
+	tests := []struct {
+		in   string
+		want float64
+		ok   bool
+	}{
+		{"", 0, true},
+		{"PT1H2M3.5S", 3723.5, true},
+		{"P1DT1S", 86401, true},
+		{"PT0.25S", 0.25, true},
+		{"1H", 0, false},
+		{"PT1Y", 0, false},
+	}
+	for _, tt := range tests {
+		got, err := parseISODuration(tt.in)
+		if (err == nil) != tt.ok || got != tt.want {
+			t.Errorf("parseISODuration(%q) = %v, %v", tt.in, got, err)
+		}
+	}
+}
@@ -0,0 +1,76 @@
+package downloader
+
+import (
+	"bytes"
+	"context"
+	"os"
+	"path/filepath"
+)
+
+// dedupeSpan is how much of each end of a file the duplicate check
+// compares, along with the size
+const dedupeSpan = 64 * 1024
+
+// checkDuplicate asks Config.OnDuplicate about a local copy of the remote
+// file and skips the download when it isn't wanted again. Interrupted runs
+// carry on without asking.
+func (e *Engine) checkDuplicate(ctx context.Context) {
+	if e.Config.OnDuplicate == nil || e.ResumeState() != "" {
+		return
+	}
+	dup := e.findDuplicate(ctx)
+	if dup == "" || e.Config.OnDuplicate(ctx, dup) {
+		return
+	}
+	e.Config.OutputName = dup
+	e.skip(e.Stats.GetTotal(), "is already downloaded")
+}
+
+// findDuplicate returns the first of the output and Config.Duplicates with
+// the remote file's size and first and last bytes, "" if none has. Only
+// whole files of servers that take ranges are compared.
+func (e *Engine) findDuplicate(ctx context.Context) string {
+	total := e.Stats.GetTotal()
+	if !e.IsResumable || e.Config.Range != nil || e.encoding != "" || total <= 0 {
+		return ""
+	}
+	span := min(int64(dedupeSpan), total)
+
+	var head, tail []byte
+	seen := map[string]bool{}
+	for _, path := range append([]string{e.Config.OutputName}, e.Config.Duplicates...) {
+		if abs, err := filepath.Abs(path); err == nil {
+			path = abs
+		}
+		if seen[path] {
+			continue
+		}
+		seen[path] = true
+		info, err := os.Stat(path)
+		if err != nil || !info.Mode().IsRegular() || info.Size() != total {
+			continue
+		}
+
+		if head == nil {
+			// Fetched once, for the first copy of the right size
+			if head, err = e.fetchRange(ctx, 0, span-1); err != nil {
+				e.trace("duplicate check: %v", err)
+				return ""
+			}
+			if tail, err = e.fetchRange(ctx, total-span, total-1); err != nil {
+				e.trace("duplicate check: %v", err)
+				return ""
+			}
+		}
+		local := make([]byte, span)
+		if readAt(path, local, 0) != nil || !bytes.Equal(local, head) {
+			continue
+		}
+		if readAt(path, local, total-span) != nil || !bytes.Equal(local, tail) {
+			continue
+		}
+		e.trace("%s has the same size, start and end", path)
+		return path
+	}
+	return ""
+}
@@ -0,0 +1,68 @@
+package downloader
+
+import (
+	"context"
+	"net"
+	"time"
+)
+
+// Geo-balanced CDNs answer with several addresses, some far away or dead
+// anycast nodes. Connecting to the first few at once and keeping whichever
+// answers first avoids both; the host then sticks to that address until a
+// connection to it fails.
+
+// dialRaceAddrs is how many of a host's addresses are raced
+const dialRaceAddrs = 3
+
+// raceDial connects to the first dialRaceAddrs ips at once and returns the
+// first connection made and its ip, closing the others
+func raceDial(ctx context.Context, network string, ips []string, port string) (net.Conn, string, error) {
+	if len(ips) > dialRaceAddrs {
+		ips = ips[:dialRaceAddrs]
+	}
+	if len(ips) == 1 {
+		conn, err := dialIP(ctx, network, ips[0], port)
+		return conn, ips[0], err
+	}
+
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+	type dialed struct {
+		conn net.Conn
+		ip   string
+		err  error
+	}
+	results := make(chan dialed, len(ips))
+	for _, ip := range ips {
+		go func(ip string) {
+			conn, err := dialIP(ctx, network, ip, port)
+			results <- dialed{conn, ip, err}
+		}(ip)
+	}
+
+	var err error
+	for left := len(ips); left > 0; left-- {
+		r := <-results
+		if r.err != nil {
+			err = r.err
+			continue
+		}
+		// Losers that connect anyway before the cancel reaches them
+		go func(left int) {
+			for ; left > 0; left-- {
+				if r := <-results; r.conn != nil {
+					r.conn.Close()
+				}
+			}
+		}(left - 1)
+		return r.conn, r.ip, nil
+	}
+	return nil, "", err
+}
+
+func dialIP(ctx context.Context, network, ip, port string) (net.Conn, error) {
+	d := linkDialer(ctx)
+	d.Timeout = 30 * time.Second
+	d.KeepAlive = 30 * time.Second
+	return d.DialContext(ctx, network, net.JoinHostPort(ip, port))
+}
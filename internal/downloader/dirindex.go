@@ -0,0 +1,144 @@
+package downloader
+
+import (
+	"context"
+	"fmt"
+	"html"
+	"io"
+	"net/http"
+	"net/url"
+	"regexp"
+	"strings"
+)
+
+// DirEntry is a link of a directory index: a file, or a subdirectory when
+// the URL ends in a slash
+type DirEntry struct {
+	URL   string
+	IsDir bool
+}
+
+var hrefPattern = regexp.MustCompile(`(?i)<a\s[^>]*href\s*=\s*(?:"([^"]*)"|'([^']*)'|([^\s>]+))`)
+
+// ListDirectory reads an HTML directory index such as nginx's or Apache's
+// autoindex and returns the links below the directory. Sort links, the
+// parent directory and links elsewhere are left out.
+func ListDirectory(ctx context.Context, client *http.Client, dirURL string) ([]DirEntry, error) {
+	base, err := url.Parse(dirURL)
+	if err != nil {
+		return nil, err
+	}
+	if !strings.HasSuffix(base.Path, "/") {
+		base.Path += "/"
+	}
+	base, page, err := fetchPage(ctx, client, base.String())
+	if err != nil {
+		return nil, err
+	}
+	if !isHTML(page) {
+		return nil, fmt.Errorf("%s is not a directory index", base)
+	}
+
+	var entries []DirEntry
+	for _, u := range pageLinks(base, page) {
+		u.RawQuery = ""
+		if u.Host != base.Host || !strings.HasPrefix(u.Path, base.Path) || u.Path == base.Path {
+			continue
+		}
+		entries = append(entries, DirEntry{URL: u.String(), IsDir: strings.HasSuffix(u.Path, "/")})
+	}
+	return dedupeEntries(entries), nil
+}
+
+// PageLinks returns the links of an HTML page resolved against it, or the
+// <loc> URLs of a sitemap, in order and each once
+func PageLinks(ctx context.Context, client *http.Client, pageURL string) ([]string, error) {
+	base, page, err := fetchPage(ctx, client, pageURL)
+	if err != nil {
+		return nil, err
+	}
+	var links []string
+	seen := map[string]bool{}
+	add := func(s string) {
+		if !seen[s] {
+			seen[s] = true
+			links = append(links, s)
+		}
+	}
+	if !isHTML(page) {
+		for _, m := range sitemapLoc.FindAllStringSubmatch(string(page), -1) {
+			if u, err := base.Parse(html.UnescapeString(strings.TrimSpace(m[1]))); err == nil {
+				add(u.String())
+			}
+		}
+		return links, nil
+	}
+	for _, u := range pageLinks(base, page) {
+		if u.String() != base.String() {
+			add(u.String())
+		}
+	}
+	return links, nil
+}
+
+var sitemapLoc = regexp.MustCompile(`(?is)<loc>(.*?)</loc>`)
+
+// fetchPage gets a page and the URL it ended up at after redirects
+func fetchPage(ctx context.Context, client *http.Client, pageURL string) (*url.URL, []byte, error) {
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, pageURL, nil)
+	if err != nil {
+		return nil, nil, err
+	}
+	req.Header.Set("User-Agent", defaultUserAgent)
+	resp, err := client.Do(req)
+	if err != nil {
+		return nil, nil, err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return nil, nil, fmt.Errorf("%s: %w", pageURL, newStatusError(resp))
+	}
+	page, err := io.ReadAll(io.LimitReader(resp.Body, 32<<20))
+	return resp.Request.URL, page, err
+}
+
+// isHTML tells pages from sitemaps by their content, servers label both
+// loosely
+func isHTML(page []byte) bool {
+	if len(page) > 1024 {
+		page = page[:1024]
+	}
+	head := strings.ToLower(strings.TrimSpace(string(page)))
+	return !strings.HasPrefix(head, "<?xml") || strings.Contains(head, "<html")
+}
+
+// pageLinks resolves the page's http(s) <a href> links, without fragments
+// and leaving out ones that only change the query of the page
+func pageLinks(base *url.URL, page []byte) []*url.URL {
+	var links []*url.URL
+	for _, m := range hrefPattern.FindAllStringSubmatch(string(page), -1) {
+		href := html.UnescapeString(strings.TrimSpace(m[1] + m[2] + m[3]))
+		if href == "" || strings.HasPrefix(href, "?") || strings.HasPrefix(href, "#") {
+			continue
+		}
+		u, err := base.Parse(href)
+		if err != nil || (u.Scheme != "http" && u.Scheme != "https") {
+			continue
+		}
+		u.Fragment = ""
+		links = append(links, u)
+	}
+	return links
+}
+
+func dedupeEntries(entries []DirEntry) []DirEntry {
+	seen := map[string]bool{}
+	out := entries[:0]
+	for _, e := range entries {
+		if !seen[e.URL] {
+			seen[e.URL] = true
+			out = append(out, e)
+		}
+	}
+	return out
+}
@@ -0,0 +1,60 @@
+package downloader
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"path/filepath"
+)
+
+// ErrDiskFull means the output's file system ran out of space
+var ErrDiskFull = errors.New("not enough disk space")
+
+// checkSpace fails before anything is downloaded when the target can't hold
+// the rest of the file. Merging copies one part at a time into the output
+// and deletes it afterwards, so on top of the data it needs room for the
+// largest part.
+func (e *Engine) checkSpace(small bool) error {
+	free := e.target.Free
+	total := e.Stats.GetTotal()
+	if free < 0 || total <= 0 {
+		return nil // Unknown, let the writes find out
+	}
+	need := total
+	var largest int64
+	for _, p := range e.Parts {
+		need -= p.Downloaded
+		if n := p.End - p.Start + 1; n > largest {
+			largest = n
+		}
+	}
+	if !small {
+		need += largest
+	}
+	if need <= free {
+		return nil
+	}
+	return fmt.Errorf("%w on %s: the download needs %s more, only %s free",
+		ErrDiskFull, e.outputDir(), formatSize(need), formatSize(free))
+}
+
+// diskFull is called by the disk writers when a write hits a full disk.
+// Config.OnDiskFull gets the chance to free space, otherwise the download
+// stops with the journal intact so a later run resumes it.
+func (e *Engine) diskFull(ctx context.Context, err error) error {
+	if e.Config.OnDiskFull != nil {
+		if hErr := e.Config.OnDiskFull(ctx, e.outputDir(), err); hErr != nil {
+			return fmt.Errorf("%w on %s: %v", ErrDiskFull, e.outputDir(), hErr)
+		}
+		return nil
+	}
+	return fmt.Errorf("%w on %s, free up space and run again to resume", ErrDiskFull, e.outputDir())
+}
+
+func (e *Engine) outputDir() string {
+	dir, err := filepath.Abs(filepath.Dir(e.Config.OutputName))
+	if err != nil {
+		return filepath.Dir(e.Config.OutputName)
+	}
+	return dir
+}
@@ -0,0 +1,13 @@
+//go:build !windows
+
+package downloader
+
+import (
+	"errors"
+	"syscall"
+)
+
+// isNoSpace reports whether a write failed for lack of space or quota
+func isNoSpace(err error) bool {
+	return errors.Is(err, syscall.ENOSPC) || errors.Is(err, syscall.EDQUOT)
+}
@@ -0,0 +1,169 @@
+package downloader
+
+import (
+	"context"
+	"crypto/tls"
+	"errors"
+	"fmt"
+	"net"
+	"net/http"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// DNSMode picks how host names are resolved. DoH and DoT get past ISPs
+// that block or rewrite plain DNS; auto uses DoH while it works and the
+// system resolver while it doesn't, so a DoH outage doesn't fail every
+// download.
+type DNSMode int
+
+const (
+	DNSSystem DNSMode = iota // The system resolver
+	DNSDoH                   // DNS over HTTPS, Config.DoHServers
+	DNSDoT                   // DNS over TLS to Cloudflare
+	DNSAuto                  // DoH, the system resolver when it fails
+)
+
+// ParseDNSMode parses the --dns flag
+func ParseDNSMode(s string) (DNSMode, error) {
+	switch s {
+	case "auto", "":
+		return DNSAuto, nil
+	case "doh":
+		return DNSDoH, nil
+	case "dot":
+		return DNSDoT, nil
+	case "system":
+		return DNSSystem, nil
+	}
+	return 0, fmt.Errorf("invalid DNS mode %q (want doh, dot, system or auto)", s)
+}
+
+// Cloudflare's DoT servers, by IP so finding them takes no DNS
+var dotServers = []string{"1.1.1.1:853", "1.0.0.1:853"}
+
+const dotServerName = "cloudflare-dns.com"
+
+// dnsFallbackFor is how long auto mode sticks to the system resolver after
+// DoH failed, rather than waiting out DoH's timeouts on every connection
+const dnsFallbackFor = time.Minute
+
+// newResolverTransport is NewDoHTransport resolving the way cfg.DNS says
+func newResolverTransport(cfg Config) *http.Transport {
+	t := NewDoHTransport(cfg.DoHServers...)
+	switch cfg.DNS {
+	case DNSDoT:
+		t.DialContext = resolvingDial(func(ctx context.Context, host string) ([]string, error) {
+			ips, err := resolveDoT(ctx, host)
+			if err != nil {
+				return nil, fmt.Errorf("DoT resolution failed for %s: %w", host, err)
+			}
+			return ips, nil
+		})
+	case DNSAuto:
+		doh := t.DialContext
+		var until atomic.Int64 // UnixNano up to which DoH is skipped
+		t.DialContext = func(ctx context.Context, network, addr string) (net.Conn, error) {
+			if time.Now().UnixNano() >= until.Load() {
+				conn, err := doh(ctx, network, addr)
+				var dnsErr *doHError
+				if !errors.As(err, &dnsErr) || ctx.Err() != nil {
+					return conn, err
+				}
+				until.Store(time.Now().Add(dnsFallbackFor).UnixNano())
+				if cfg.OnDNSFallback != nil {
+					cfg.OnDNSFallback(dnsErr.host, dnsErr.err)
+				}
+			}
+			d := linkDialer(ctx)
+			d.Timeout = 30 * time.Second
+			d.KeepAlive = 30 * time.Second
+			return d.DialContext(ctx, network, addr)
+		}
+	}
+	return t
+}
+
+// doHError is a failed DoH lookup, as opposed to failing to connect to
+// the address it found
+type doHError struct {
+	host string
+	err  error
+}
+
+func (e *doHError) Error() string {
+	return fmt.Sprintf("DoH resolution failed for %s: %v", e.host, e.err)
+}
+
+func (e *doHError) Unwrap() error { return e.err }
+
+// resolvingDial races the addresses resolve returns for the host and
+// keeps dialing the winner while it connects. IPs are dialed as they are.
+func resolvingDial(resolve func(ctx context.Context, host string) ([]string, error)) func(ctx context.Context, network, addr string) (net.Conn, error) {
+	var fastest sync.Map // Host name to the address that won its race
+	return func(ctx context.Context, network, addr string) (net.Conn, error) {
+		host, port, err := net.SplitHostPort(addr)
+		if err != nil {
+			return nil, err
+		}
+		if net.ParseIP(host) != nil {
+			return dialIP(ctx, network, host, port)
+		}
+		if ip, ok := fastest.Load(host); ok {
+			if conn, err := dialIP(ctx, network, ip.(string), port); err == nil {
+				return conn, nil
+			}
+			fastest.Delete(host)
+		}
+		ips, err := resolve(ctx, host)
+		if err != nil {
+			return nil, err
+		}
+		conn, ip, err := raceDial(ctx, network, ips, port)
+		if err != nil {
+			return nil, err
+		}
+		fastest.Store(host, ip)
+		return conn, nil
+	}
+}
+
+// dotResolver speaks DNS over TLS: Go's resolver frames its queries for a
+// stream when the connection isn't a packet one, which is all DoT is
+var dotResolver = &net.Resolver{
+	PreferGo: true,
+	Dial: func(ctx context.Context, _, _ string) (net.Conn, error) {
+		var err error
+		for _, server := range dotServers {
+			d := linkDialer(ctx)
+			d.Timeout = 5 * time.Second
+			var raw net.Conn
+			if raw, err = d.DialContext(ctx, "tcp", server); err != nil {
+				continue
+			}
+			conn := tls.Client(raw, &tls.Config{ServerName: dotServerName})
+			if err = conn.HandshakeContext(ctx); err != nil {
+				raw.Close()
+				continue
+			}
+			return conn, nil
+		}
+		return nil, err
+	},
+}
+
+// resolveDoT returns the host's IPv4 addresses over DoT
+func resolveDoT(ctx context.Context, host string) ([]string, error) {
+	ctx, cancel := context.WithTimeout(ctx, 10*time.Second)
+	defer cancel()
+	ips, err := dotResolver.LookupIP(ctx, "ip4", host)
+	if err != nil {
+		return nil, err
+	}
+	addrs := make([]string, len(ips))
+	for i, ip := range ips {
+		addrs[i] = ip.String()
+	}
+	return addrs, nil
+}
@@ -7,6 +7,7 @@ import (
 	"fmt"
 	"net"
 	"net/http"
+	"sync/atomic"
 	"time"
 )
 
@@ -24,37 +25,21 @@ type doHResponse struct {
 	Answer []doHAnswer `json:"Answer"`
 }
 
-// NewDoHTransport returns a custom http.Transport that uses DoH for DNS resolution
-func NewDoHTransport() *http.Transport {
+// NewDoHTransport returns a custom http.Transport that uses DoH for DNS resolution.
+// servers are DoH JSON endpoints tried in order, Cloudflare's when empty.
+func NewDoHTransport(servers ...string) *http.Transport {
+	if len(servers) == 0 {
+		servers = []string{cloudflareDoH}
+	}
 	return &http.Transport{
 		Proxy: http.ProxyFromEnvironment,
-		DialContext: func(ctx context.Context, network, addr string) (net.Conn, error) {
-			host, port, err := net.SplitHostPort(addr)
-			if err != nil {
-				return nil, err
-			}
-
-			// Check if host is already an IP
-			if net.ParseIP(host) != nil {
-				var d net.Dialer
-				d.Timeout = 30 * time.Second
-				d.KeepAlive = 30 * time.Second
-				return d.DialContext(ctx, network, addr)
-			}
-
-			// Resolve IP via DoH
-			ip, err := resolveDoH(ctx, host)
+		DialContext: resolvingDial(func(ctx context.Context, host string) ([]string, error) {
+			ips, err := resolveDoH(ctx, servers, host)
 			if err != nil {
-				return nil, fmt.Errorf("DoH resolution failed for %s: %w", host, err)
+				return nil, &doHError{host: host, err: err}
 			}
-
-			// Dial the resolved IP directly
-			targetAddr := net.JoinHostPort(ip, port)
-			var d net.Dialer
-			d.Timeout = 30 * time.Second
-			d.KeepAlive = 30 * time.Second
-			return d.DialContext(ctx, network, targetAddr)
-		},
+			return ips, nil
+		}),
 		TLSClientConfig:       &tls.Config{InsecureSkipVerify: true},
 		TLSNextProto:          map[string]func(string, *tls.Conn) http.RoundTripper{},
 		ForceAttemptHTTP2:     false,
@@ -64,17 +49,62 @@ func NewDoHTransport() *http.Transport {
 	}
 }
 
-func resolveDoH(ctx context.Context, domain string) (string, error) {
-	// Use 1.1.1.1 directly for the DoH request to avoid system DNS lookup for cloudflare-dns.com
-	// However, TLS verification might fail if we use IP in URL without proper Host header or if cert doesn't match IP.
-	// Cloudflare's cert is valid for cloudflare-dns.com.
-	// Common practice: Resolve cloudflare-dns.com once using system DNS (usually allowed) or hardcode IP.
-	// Let's rely on system DNS for the initial bootstrap of the DoH provider itself, 
-	// assuming the ISP blocks specific sites, not Cloudflare's public DNS service.
-	
-	req, err := http.NewRequestWithContext(ctx, "GET", cloudflareDoH, nil)
+// dohBootstrap has the addresses of well-known DoH servers, so reaching
+// them doesn't take the system DNS that DoH is there to avoid
+var dohBootstrap = map[string][]string{
+	"cloudflare-dns.com": {"1.1.1.1", "1.0.0.1"},
+	"dns.google":         {"8.8.8.8", "8.8.4.4"},
+	"dns.quad9.net":      {"9.9.9.9", "149.112.112.112"},
+}
+
+// dohClient sends the DoH queries. Servers in dohBootstrap are dialed by
+// IP, their certificate still has to be valid for the server's name.
+var dohClient = &http.Client{
+	Timeout: 5 * time.Second,
+	Transport: &http.Transport{
+		Proxy: http.ProxyFromEnvironment,
+		DialContext: func(ctx context.Context, network, addr string) (net.Conn, error) {
+			d := linkDialer(ctx)
+			d.Timeout = 5 * time.Second
+			host, port, err := net.SplitHostPort(addr)
+			ips, ok := dohBootstrap[host]
+			if err != nil || !ok {
+				return d.DialContext(ctx, network, addr)
+			}
+			for _, ip := range ips {
+				var conn net.Conn
+				if conn, err = d.DialContext(ctx, network, net.JoinHostPort(ip, port)); err == nil {
+					return conn, nil
+				}
+			}
+			return nil, err
+		},
+		ForceAttemptHTTP2:   true,
+		MaxIdleConns:        10,
+		IdleConnTimeout:     90 * time.Second,
+		TLSHandshakeTimeout: 5 * time.Second,
+	},
+}
+
+// resolveDoH asks the servers in turn until one answers
+func resolveDoH(ctx context.Context, servers []string, domain string) ([]string, error) {
+	atomic.AddInt64(&counts.DoHLookups, 1)
+	var err error
+	for _, server := range servers {
+		var ips []string
+		if ips, err = queryDoH(ctx, server, domain); err == nil {
+			return ips, nil
+		}
+	}
+	atomic.AddInt64(&counts.DoHFailures, 1)
+	return nil, err
+}
+
+// queryDoH returns the domain's A records in the order the server gave them
+func queryDoH(ctx context.Context, server, domain string) ([]string, error) {
+	req, err := http.NewRequestWithContext(ctx, "GET", server, nil)
 	if err != nil {
-		return "", err
+		return nil, err
 	}
 
 	q := req.URL.Query()
@@ -82,39 +112,41 @@ func resolveDoH(ctx context.Context, domain string) (string, error) {
 	q.Add("type", "A") // IPv4 only for simplicity
 	req.URL.RawQuery = q.Encode()
 	req.Header.Set("Accept", "application/dns-json")
-	req.Header.Set("User-Agent", "Mozilla/5.0 (Windows NT 10.0; Win64; x64) AppleWebKit/537.36 (KHTML, like Gecko) Chrome/120.0.0.0 Safari/537.36")
+	req.Header.Set("User-Agent", defaultUserAgent)
 
-	// Use a clean client for the DNS query
-	client := &http.Client{Timeout: 5 * time.Second}
-	resp, err := client.Do(req)
+	resp, err := dohClient.Do(req)
 	if err != nil {
-		return "", err
+		return nil, err
 	}
 	defer resp.Body.Close()
 
 	if resp.StatusCode != http.StatusOK {
-		return "", fmt.Errorf("DoH server returned status: %s", resp.Status)
+		return nil, fmt.Errorf("DoH server returned status: %s", resp.Status)
 	}
 
 	var dohResp doHResponse
 	if err := json.NewDecoder(resp.Body).Decode(&dohResp); err != nil {
-		return "", err
+		return nil, err
 	}
 
 	if dohResp.Status != 0 {
-		return "", fmt.Errorf("DNS error code: %d", dohResp.Status)
+		return nil, fmt.Errorf("DNS error code: %d", dohResp.Status)
 	}
 
 	if len(dohResp.Answer) == 0 {
-		return "", fmt.Errorf("no DNS answer found for %s", domain)
+		return nil, fmt.Errorf("no DNS answer found for %s", domain)
 	}
 
-	// Return the first A record (Type 1)
+	// A records are Type 1, CNAMEs on the way there are skipped
+	var ips []string
 	for _, ans := range dohResp.Answer {
 		if ans.Type == 1 {
-			return ans.Data, nil
+			ips = append(ips, ans.Data)
 		}
 	}
+	if len(ips) > 0 {
+		return ips, nil
+	}
 
-	return "", fmt.Errorf("no A record found for %s", domain)
+	return nil, fmt.Errorf("no A record found for %s", domain)
 }
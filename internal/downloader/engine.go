@@ -3,62 +3,303 @@ package downloader
 import (
 	"context"
 	"crypto/tls"
+	"errors"
 	"fmt"
+	"hash/crc32"
 	"io"
 	"net/http"
+	"net/http/cookiejar"
+	"net/url"
 	"os"
 	"path/filepath"
 	"strconv"
 	"strings"
 	"sync"
+	"sync/atomic"
 	"time"
+
+	"golang.org/x/net/publicsuffix"
 )
 
+// Browser-like UA, some servers refuse unknown clients
+const defaultUserAgent = "Mozilla/5.0 (Windows NT 10.0; Win64; x64) AppleWebKit/537.36 (KHTML, like Gecko) Chrome/120.0.0.0 Safari/537.36"
+
+// Parts smaller than this cost more in requests and temp files than the
+// extra connection gains
+const defaultMinSplitSize = 1 << 20
+
 // NewEngine creates a new download engine
 func NewEngine(cfg Config) *Engine {
+	e := &Engine{
+		Config: cfg,
+		Stats:  &Stats{},
+		Client: NewClient(cfg),
+		log:    cfg.logger(),
+	}
+	rt := e.Client.Transport
+	if h, ok := rt.(*hostTransport); ok {
+		rt = h.base
+	}
+	if h, ok := rt.(*headerTransport); ok {
+		rt = h.base
+	}
+	if s, ok := rt.(*sigV4Transport); ok {
+		rt = s.base
+	}
+	e.proto, _ = rt.(*protoTransport)
+	e.links, _ = rt.(*linkTransport)
+	if e.fetcher = registeredFetcher(cfg); e.fetcher == nil {
+		e.fetcher = &httpFetcher{e: e}
+	}
+	if isObjectStoreURL(cfg.URL) {
+		e.Config.URL, e.Client.Transport = objectStoreTransport(cfg.URL, e.Client.Transport)
+	}
+	if direct, ok := shareLinkURL(cfg.URL); ok {
+		e.Config.URL = direct
+		e.Client.Transport = &driveTransport{base: e.Client.Transport}
+	}
+	if cfg.Logger != nil {
+		e.Client.Transport = &logTransport{base: e.Client.Transport, log: cfg.Logger, wire: cfg.WireTrace}
+	}
+	if cfg.WrapTransport != nil {
+		e.Client.Transport = cfg.WrapTransport(e.Client.Transport)
+	}
+	if len(cfg.Peers) > 0 || cfg.FindPeers != nil {
+		e.peerClient = newPeerClient(cfg)
+	}
+	return e
+}
+
+// trace reports a decision to Config.Trace and the log
+func (e *Engine) trace(format string, args ...any) {
+	msg := fmt.Sprintf(format, args...)
+	if e.Config.Trace != nil {
+		e.Config.Trace(msg)
+	}
+	e.log.Info(msg)
+}
+
+// NewClient builds the HTTP client used for all requests of a download
+func NewClient(cfg Config) *http.Client {
 	client := &http.Client{
 		Timeout: 0,
 	}
-	
-	if cfg.UseDoH {
-		client.Transport = NewDoHTransport()
+
+	var transport *http.Transport
+	if cfg.Tor {
+		transport = newTorTransport(cfg.Proxy)
+	} else if cfg.DNS != DNSSystem {
+		transport = newResolverTransport(cfg)
 	} else {
 		// Even without DoH, we want to skip TLS verification as requested
-		client.Transport = &http.Transport{
-			Proxy: http.ProxyFromEnvironment,
-			TLSClientConfig: &tls.Config{InsecureSkipVerify: true},
-			TLSNextProto:    map[string]func(string, *tls.Conn) http.RoundTripper{},
+		transport = &http.Transport{
+			Proxy:             http.ProxyFromEnvironment,
+			TLSClientConfig:   &tls.Config{InsecureSkipVerify: true},
+			TLSNextProto:      map[string]func(string, *tls.Conn) http.RoundTripper{},
 			ForceAttemptHTTP2: false,
 		}
 	}
 
-	return &Engine{
-		Config: cfg,
-		Stats:  &Stats{},
-		Client: client,
+	if len(cfg.ConnectTo) > 0 {
+		transport.DialContext = connectToDial(cfg.ConnectTo, transport.DialContext)
+	}
+	if cfg.SNI != "" || len(cfg.PinSHA256) > 0 {
+		transport.TLSClientConfig = transport.TLSClientConfig.Clone()
+		transport.TLSClientConfig.ServerName = cfg.SNI
+		if len(cfg.PinSHA256) > 0 {
+			transport.TLSClientConfig.VerifyConnection = verifyPins(cfg.PinSHA256)
+		}
+	}
+	if cfg.VerifyTLS && transport.TLSClientConfig != nil {
+		transport.TLSClientConfig = transport.TLSClientConfig.Clone()
+		transport.TLSClientConfig.InsecureSkipVerify = false
+	}
+	transport.Proxy = proxyFunc(cfg)
+	if cfg.Pipeline > 0 {
+		// Every connection goes back to the pool between its parts
+		transport.MaxConnsPerHost = cfg.Pipeline
+		transport.MaxIdleConnsPerHost = cfg.Pipeline
+	}
+	client.Transport = transport
+	switch {
+	case len(cfg.Links) > 0 && !cfg.Tor:
+		// Aggregating links takes a connection on each, HTTP/1.1 only
+		client.Transport = newLinkTransport(transport, cfg.Links)
+	case cfg.TLSFingerprint != "":
+		client.Transport = newFingerprintTransport(transport, cfg.TLSFingerprint, cfg.HTTP2 == HTTP2Off)
+	case cfg.HTTP2 != HTTP2Off:
+		client.Transport = newProtoTransport(transport)
+	}
+	if cfg.AWSSigV4 != "" {
+		// Inside the header transport, so the x-amz-* headers of
+		// Config.Headers are signed too
+		client.Transport = newSigV4Transport(cfg.AWSSigV4, client.Transport)
 	}
+	if len(cfg.Headers) > 0 || len(cfg.HostHeaders) > 0 {
+		client.Transport = &headerTransport{base: client.Transport, header: cfg.Headers, hosts: cfg.HostHeaders}
+	}
+	if cfg.Hosts != nil {
+		client.Transport = &hostTransport{base: client.Transport, limits: cfg.Hosts}
+	}
+	// Cookies the probe and its redirects set, like CDN affinity, go with
+	// the part requests too. Config.Cookies are in the jar rather than a
+	// header, so redirects to other sites and the mirrors don't get the
+	// session. The public suffix list keeps a server from setting cookies
+	// for all of co.uk or github.io.
+	jar, err := cookiejar.New(&cookiejar.Options{PublicSuffixList: publicsuffix.List})
+	if err != nil {
+		// Without a jar Config.Cookies would be lost, fail the requests
+		// rather than download without the session
+		return &http.Client{Transport: failingTransport{fmt.Errorf("cookie jar: %w", err)}}
+	}
+	if u, err := url.Parse(cfg.URL); err == nil && len(cfg.Cookies) > 0 {
+		jar.SetCookies(u, cfg.Cookies)
+	}
+	client.Jar = jar
+	return client
+}
+
+// Progress returns the live statistics of the download
+func (e *Engine) Progress() *Stats {
+	return e.Stats
 }
 
-// Start initiates the download process
+// Start initiates the download process. When it fails in a way the retry
+// policy lists, it starts over and carries on from the resume state.
 func (e *Engine) Start(ctx context.Context) error {
+	policy := e.Config.retryPolicy()
+	for attempt := 0; ; attempt++ {
+		err := e.run(ctx)
+		if err == nil {
+			break
+		}
+		if e.fallBackToOneConnection(ctx, err) || e.dropPeers(ctx, err) {
+			attempt--
+			continue
+		}
+		if ctx.Err() != nil || attempt >= policy.Retries || !policy.retryDownload(err) {
+			e.stopped()
+			return err
+		}
+		e.warn("download attempt %d failed, starting over: %v", attempt+1, err)
+		if err := policy.sleep(ctx, attempt); err != nil {
+			e.stopped()
+			return err
+		}
+		// The next run counts what is on disk again
+		e.Stats.SetDownloaded(0)
+	}
+
+	if e.Config.Follow && e.Skipped == "" {
+		return e.follow(ctx)
+	}
+	return nil
+}
+
+func (e *Engine) run(ctx context.Context) error {
+	ctx, e.abort = context.WithCancel(ctx)
+	defer e.abort()
+
 	// 1. Probe the URL (Try HEAD first, then GET)
-	totalBytes, resumable, err := e.probeURL(ctx)
+	if c, ok := e.fetcher.(io.Closer); ok {
+		defer c.Close()
+	}
+	defer func() {
+		if e.first != nil {
+			e.first.Body.Close()
+		}
+	}()
+	totalBytes, resumable, err := e.fetcher.Probe(ctx)
+	if errors.Is(err, errNotModified) {
+		e.Config.OutputName = e.localCopy()
+		e.skip(e.localSize(), "is up to date")
+		return nil
+	}
 	if err != nil {
 		return fmt.Errorf("failed to probe URL: %w", err)
 	}
 
-	e.Stats.TotalBytes = totalBytes
-	e.IsResumable = resumable && e.Stats.TotalBytes > 0
+	e.Stats.SetTotal(totalBytes)
+	e.IsResumable = resumable && e.Stats.TotalBytes > 0 && !e.noRanges
+	e.trace("probed: %d bytes, ranges %v", totalBytes, e.IsResumable)
+	if e.encoding != "" {
+		e.trace("compressed with %s, one connection", e.encoding)
+	}
+
+	if e.Config.Range != nil {
+		if e.encoding != "" {
+			return fmt.Errorf("the server sends the file compressed, byte ranges of it can't be decoded without the rest (drop --compressed)")
+		}
+		if !e.IsResumable {
+			return fmt.Errorf("server does not support byte ranges")
+		}
+		start, end, err := e.Config.Range.resolve(totalBytes)
+		if err != nil {
+			return err
+		}
+		e.rangeStart = start
+		e.Stats.SetTotal(end - start + 1)
+	}
+
+	e.tuneProtocols(ctx)
+	if e.Config.FindPeers != nil && e.Config.Peers == nil && e.Config.Checksum != nil && e.Config.Range == nil && e.fetcher.Capabilities().Mirrors && e.plainGET() {
+		e.Config.Peers = append(e.Config.Peers, e.Config.FindPeers(ctx, e.Config.Checksum)...)
+	}
+	if e.Config.Stream != nil {
+		if e.stream == nil {
+			if e.stream, err = newStreamer(e.Config); err != nil {
+				return err
+			}
+		}
+		return e.runStream(ctx)
+	}
 
 	// Handle output filename
 	if e.Config.OutputName == "" {
-		e.Config.OutputName = filepath.Base(e.Config.URL)
+		e.Config.OutputName = e.Config.defaultOutput(e.defaultName())
+		if e.Config.Range != nil {
+			// Don't let a slice masquerade as the complete file
+			e.Config.OutputName += ".range"
+		}
+	}
+	if dir := filepath.Dir(e.Config.OutputName); dir != "." {
+		if err := os.MkdirAll(dir, 0o755); err != nil {
+			return err
+		}
+	}
+	if size, ok := e.upToDate(); ok {
+		e.skip(size, "is up to date")
+		return nil
+	}
+	if e.checkDuplicate(ctx); e.Skipped != "" {
+		return nil
+	}
+	if err := e.resolveConflict(); err != nil || e.Skipped != "" {
+		return err
 	}
 
-	// 2. Segmentation
-	if e.IsResumable {
-		e.calculateSegments()
-	} else {
+	if err := e.planOutput(); err != nil {
+		return err
+	}
+
+	// 2. Segmentation, continuing an interrupted download if possible.
+	// Small files skip it and go straight to the output in one request,
+	// unless an earlier run with other flags left parts to continue.
+	small := e.Stats.TotalBytes > 0 && e.Stats.TotalBytes < e.minSplitSize() && e.volumeSize == 0 && e.ResumeState() == ""
+	resumed := false
+	switch {
+	case small:
+		e.Parts = []*Part{{
+			ID:       0,
+			Start:    e.rangeStart,
+			End:      e.rangeStart + e.Stats.TotalBytes - 1,
+			TempPath: tmpPath(e.Config.OutputName),
+		}}
+	case e.IsResumable:
+		if resumed = e.loadState(); !resumed {
+			e.calculateSegments()
+		}
+	default:
 		// Fallback to single connection
 		e.Parts = []*Part{{
 			ID:       0,
@@ -67,49 +308,200 @@ func (e *Engine) Start(ctx context.Context) error {
 			TempPath: fmt.Sprintf("%s.part0", e.Config.OutputName),
 		}}
 	}
+	switch {
+	case small:
+		e.trace("small file, one request")
+	case resumed:
+		e.trace("resuming %d parts from the journal", len(e.Parts))
+	default:
+		e.trace("split into %d parts", len(e.Parts))
+	}
+	// Before any part files exist, so failing leaves nothing behind
+	if err := e.checkSpace(small); err != nil {
+		return err
+	}
+	if small {
+		if err := e.adoptExisting(); err != nil {
+			return err
+		}
+		e.Stats.AddDownloaded(e.Parts[0].Downloaded)
+	}
+	if e.IsResumable && !small {
+		if !resumed {
+			if err := e.createPartFiles(); err != nil {
+				return err
+			}
+			if err := e.adoptExisting(); err != nil {
+				return err
+			}
+		}
+		for _, p := range e.Parts {
+			e.Stats.AddDownloaded(p.Downloaded)
+		}
+		if e.journal, err = e.openJournal(); err != nil {
+			return fmt.Errorf("failed to write resume state: %w", err)
+		}
+	}
+
+	e.layout.Store(e.Parts)
 
 	// 3. Download Parts
+	if err := e.downloadParts(ctx); err != nil {
+		if e.journal != nil {
+			e.journal.close()
+		}
+		if small || e.journal == nil {
+			// Nothing to resume from without a journal
+			e.dropPartial()
+		}
+		return err
+	}
+
+	if e.Config.Paranoid && !small {
+		if err := e.checkBoundaries(ctx); err != nil {
+			if e.journal != nil {
+				e.journal.close()
+			}
+			return err
+		}
+	}
+
+	// 4. Merge Files
+	pending := []string{tmpPath(e.Config.OutputName)}
+	if small {
+		// Already written to the temporary output
+	} else if pending, err = e.mergeParts(); err != nil {
+		if e.journal != nil {
+			e.journal.close()
+		}
+		return fmt.Errorf("failed to merge files: %w", err)
+	}
+	if e.journal != nil {
+		e.journal.remove()
+	}
+
+	// 5. Verify
+	if e.Config.Checksum != nil {
+		if err := e.Config.Checksum.VerifyFiles(pending...); err != nil {
+			return fmt.Errorf("verification failed: %w", err)
+		}
+	}
+	if e.Config.Signature != nil {
+		if e.Signer, err = e.Config.Signature.VerifyFiles(pending...); err != nil {
+			return fmt.Errorf("verification failed: %w", err)
+		}
+		e.trace("Good signature from %s", e.Signer)
+	}
+	if err := e.commit(pending); err != nil {
+		return fmt.Errorf("failed to move the output into place: %w", err)
+	}
+	if err := e.setModTime(); err != nil {
+		return fmt.Errorf("failed to set the modification time: %w", err)
+	}
+
+	if err := e.markOfTheWeb(); err != nil {
+		return fmt.Errorf("failed to write Zone.Identifier: %w", err)
+	}
+	return nil
+}
+
+// downloadParts runs all parts to the end and returns the first error
+func (e *Engine) downloadParts(ctx context.Context) error {
+	e.Stats.BeginRate(time.Now())
+	e.queue = newWriteQueue(e.Config.MaxInFlight, e.Config.BufferSize, func(err error) error {
+		return e.diskFull(ctx, err)
+	})
 	var wg sync.WaitGroup
 	errChan := make(chan error, len(e.Parts))
 
-	for _, part := range e.Parts {
-		wg.Add(1)
-		go func(p *Part) {
-			defer wg.Done()
-			if err := e.downloadPartWithRetry(ctx, p); err != nil {
-				errChan <- err
-			}
-		}(part)
+	if e.Config.Pipeline > 0 && len(e.Parts) > 1 {
+		e.runPipelined(ctx, errChan)
+	} else if e.Config.AutoConcurrency && len(e.Parts) > 1 {
+		e.runAdaptive(ctx, errChan)
+	} else {
+		for _, part := range e.Parts {
+			wg.Add(1)
+			go func(p *Part) {
+				defer wg.Done()
+				if err := e.downloadPartWithRetry(ctx, p); err != nil {
+					errChan <- err
+				}
+			}(part)
+		}
 	}
 
 	// Wait for all parts to finish
 	wg.Wait()
+	e.queue.close()
 	close(errChan)
 
-	// Check for errors
 	if len(errChan) > 0 {
-		return <-errChan // Return the first error encountered
+		return firstError(errChan)
+	}
+	if e.encoding != "" {
+		// The decoded size is only known now, see decode
+		e.Stats.SetTotal(e.Stats.GetDownloaded())
 	}
+	return nil
+}
 
-	// 4. Merge Files
-	if err := e.mergeParts(); err != nil {
-		return fmt.Errorf("failed to merge files: %w", err)
+// defaultName prefers the name the server gave over the requested URL's,
+// which is often an opaque token like /download?id=123
+func (e *Engine) defaultName() string {
+	if e.remoteName != "" {
+		return e.remoteName
+	}
+	if u, err := url.Parse(e.Config.URL); err == nil {
+		if name := urlFileName(u); name != "" {
+			return name
+		}
 	}
+	return filepath.Base(e.Config.URL)
+}
 
-	return nil
+// sources returns the primary URL followed by any mirrors
+func (e *Engine) sources() []string {
+	return append([]string{e.currentURL()}, e.Config.Mirrors...)
+}
+
+// sourceFor spreads parts across sources and moves a part to the next
+// source on every retry, so a broken mirror only costs one attempt
+func (e *Engine) sourceFor(part *Part, attempt int) string {
+	if !e.fetcher.Capabilities().Mirrors {
+		return e.Config.URL
+	}
+	if n := len(e.Config.Peers); n > 0 && attempt == 0 {
+		// The internet only for parts a peer failed to deliver
+		return e.Config.Peers[part.ID%n]
+	}
+	srcs := e.sources()
+	return srcs[(part.ID+attempt)%len(srcs)]
 }
 
-func (e *Engine) probeURL(ctx context.Context) (int64, bool, error) {
+func (e *Engine) probeURL(ctx context.Context, url string) (int64, bool, error) {
 	// Try HEAD first
-	req, err := http.NewRequestWithContext(ctx, "HEAD", e.Config.URL, nil)
+	req, err := http.NewRequestWithContext(ctx, "HEAD", url, nil)
 	if err != nil {
 		return 0, false, err
 	}
-	req.Header.Set("User-Agent", "Mozilla/5.0 (Windows NT 10.0; Win64; x64) AppleWebKit/537.36 (KHTML, like Gecko) Chrome/120.0.0.0 Safari/537.36")
+	req.Header.Set("User-Agent", defaultUserAgent)
+	e.ifModifiedSince(req)
+	e.offerEncodings(req)
 
 	resp, err := e.Client.Do(req)
+	if err == nil && resp.StatusCode == http.StatusNotModified {
+		resp.Body.Close()
+		return 0, false, errNotModified
+	}
 	if err == nil && resp.StatusCode == http.StatusOK {
 		defer resp.Body.Close()
+		e.remoteName = responseFileName(resp)
+		e.FinalURL = resp.Request.URL.Redacted()
+		e.validator, e.validatorURL = responseValidator(resp), url
+		if e.Config.Compressed && encoded(resp) {
+			e.encoding = resp.Header.Get("Content-Encoding")
+			return 0, false, nil
+		}
 		return resp.ContentLength, resp.Header.Get("Accept-Ranges") == "bytes", nil
 	}
 	if resp != nil {
@@ -117,18 +509,32 @@ func (e *Engine) probeURL(ctx context.Context) (int64, bool, error) {
 	}
 
 	// If HEAD fails, try GET with Range: bytes=0-0
-	req, err = http.NewRequestWithContext(ctx, "GET", e.Config.URL, nil)
+	req, err = http.NewRequestWithContext(ctx, "GET", url, nil)
 	if err != nil {
 		return 0, false, err
 	}
-	req.Header.Set("User-Agent", "Mozilla/5.0 (Windows NT 10.0; Win64; x64) AppleWebKit/537.36 (KHTML, like Gecko) Chrome/120.0.0.0 Safari/537.36")
+	req.Header.Set("User-Agent", defaultUserAgent)
 	req.Header.Set("Range", "bytes=0-0")
+	e.ifModifiedSince(req)
+	e.offerEncodings(req)
 
 	resp, err = e.Client.Do(req)
 	if err != nil {
 		return 0, false, err
 	}
 	defer resp.Body.Close()
+	if resp.StatusCode == http.StatusNotModified {
+		return 0, false, errNotModified
+	}
+	if resp.StatusCode == http.StatusPartialContent || resp.StatusCode == http.StatusOK {
+		e.remoteName = responseFileName(resp)
+		e.FinalURL = resp.Request.URL.Redacted()
+		e.validator, e.validatorURL = responseValidator(resp), url
+		if e.Config.Compressed && encoded(resp) {
+			e.encoding = resp.Header.Get("Content-Encoding")
+			return 0, false, nil
+		}
+	}
 
 	if resp.StatusCode == http.StatusPartialContent {
 		// Parse Content-Range: bytes 0-0/123456
@@ -145,19 +551,59 @@ func (e *Engine) probeURL(ctx context.Context) (int64, bool, error) {
 		return resp.ContentLength, false, nil
 	}
 
-	return 0, false, fmt.Errorf("probe failed with status: %s", resp.Status)
+	return 0, false, newStatusError(resp)
 }
 
 func (e *Engine) calculateSegments() {
-	partSize := e.Stats.TotalBytes / int64(e.Config.Concurrency)
-	e.Parts = make([]*Part, e.Config.Concurrency)
+	if e.Config.Pipeline > 0 {
+		e.splitSegments(pipelinePartCount(e.Stats.TotalBytes, e.minSplitSize(), e.Config.Pipeline))
+		return
+	}
+	if e.Config.AutoConcurrency {
+		e.splitSegments(autoPartCount(e.Stats.TotalBytes, e.minSplitSize()))
+		return
+	}
+	e.splitSegments(e.Config.Concurrency)
+}
+
+// minSplitSize is the smallest part worth its own connection
+func (e *Engine) minSplitSize() int64 {
+	if e.Config.MinSplitSize > 0 {
+		return e.Config.MinSplitSize
+	}
+	return defaultMinSplitSize
+}
+
+// splitSegments divides the download into at most n equally sized parts
+// of at least minSplitSize bytes
+func (e *Engine) splitSegments(n int) {
+	if max := e.Stats.TotalBytes / e.minSplitSize(); int64(n) > max {
+		n = int(max)
+	}
+	if n < 1 {
+		n = 1
+	}
+	// Temp parts share the output's file system, and its file size cap
+	if limit := e.target.MaxFileSize; limit > 0 {
+		if min := (e.Stats.TotalBytes + limit - 1) / limit; int64(n) < min {
+			n = int(min)
+		}
+	}
+	if int64(n) > e.Stats.TotalBytes {
+		n = int(e.Stats.TotalBytes)
+	}
+	partSize := e.Stats.TotalBytes / int64(n)
+	if partSize > cloneAlign && e.target.MaxFileSize == 0 {
+		partSize -= partSize % cloneAlign
+	}
+	e.Parts = make([]*Part, n)
 
-	for i := 0; i < e.Config.Concurrency; i++ {
-		start := int64(i) * partSize
+	for i := 0; i < n; i++ {
+		start := e.rangeStart + int64(i)*partSize
 		end := start + partSize - 1
 
-		if i == e.Config.Concurrency-1 {
-			end = e.Stats.TotalBytes - 1
+		if i == n-1 {
+			end = e.rangeStart + e.Stats.TotalBytes - 1
 		}
 
 		e.Parts[i] = &Part{
@@ -170,105 +616,278 @@ func (e *Engine) calculateSegments() {
 }
 
 func (e *Engine) downloadPartWithRetry(ctx context.Context, part *Part) error {
-	maxRetries := 3
+	policy := e.Config.retryPolicy()
 	var err error
 
-	for i := 0; i < maxRetries; i++ {
-		err = e.downloadPart(ctx, part)
+	part.setState(PartActive)
+	defer func() {
+		if part.getState() == PartActive {
+			part.setState(PartFailed)
+		}
+	}()
+	attempt, throttles, refreshes := 0, 0, 0
+	for ; ; attempt++ {
+		before := atomic.LoadInt64(&part.Downloaded)
+		src := e.sourceFor(part, attempt)
+		if err := e.waitHeld(ctx, part, src); err != nil {
+			return err
+		}
+		err = e.downloadPart(ctx, part, src)
 		if err == nil {
+			part.setState(PartDone)
 			return nil
 		}
+		if atomic.LoadInt64(&part.Downloaded) > before {
+			throttles, refreshes = 0, 0
+		}
+		if expired(err) && ctx.Err() == nil && refreshes < maxRefreshes && e.refreshURL(ctx, src) {
+			refreshes++
+			attempt--
+			continue
+		}
+		if d := throttled(err); d > 0 && ctx.Err() == nil && throttles < maxThrottles {
+			// The server said when to come back, waiting for it isn't a
+			// failed attempt
+			throttles++
+			e.trace("part %d throttled by the server, waiting %s", part.ID, d)
+			if err := e.throttle(ctx, part, src, d); err != nil {
+				return err
+			}
+			attempt--
+			continue
+		}
+		if errors.Is(err, ErrStalled) && ctx.Err() == nil && atomic.LoadInt64(&part.Downloaded) > before {
+			// It was moving before it went quiet, reconnect from where it
+			// stopped without using up a retry
+			e.trace("part %d stalled at %d bytes, reconnecting", part.ID, atomic.LoadInt64(&part.Downloaded))
+			attempt--
+			continue
+		}
+		if errors.Is(err, ErrRemoteChanged) || errors.Is(err, ErrDiskFull) || errors.Is(err, errRangeIgnored) {
+			// Retrying can't help, stop the other parts too
+			e.abort()
+			return err
+		}
 		// If context canceled, don't retry
-		select {
-		case <-ctx.Done():
+		if ctx.Err() != nil {
 			return ctx.Err()
-		default:
-			// Backoff simple
-			time.Sleep(time.Duration(i+1) * time.Second)
 		}
+		if attempt >= policy.Retries || !policy.retryPart(err) {
+			break
+		}
+		e.warn("part %d attempt %d failed, retrying: %v", part.ID, attempt+1, err)
+		if err := policy.sleep(ctx, attempt); err != nil {
+			return err
+		}
+	}
+	if e.stream != nil {
+		// The parts after it would wait for its bytes forever
+		e.abort()
 	}
-	return fmt.Errorf("failed to download part %d after %d retries: %w", part.ID, maxRetries, err)
+	return fmt.Errorf("failed to download part %d after %d attempts: %w", part.ID, attempt+1, err)
 }
 
-func (e *Engine) downloadPart(ctx context.Context, part *Part) error {
-	req, err := http.NewRequestWithContext(ctx, "GET", e.Config.URL, nil)
-	if err != nil {
-		return err
+func (e *Engine) downloadPart(ctx context.Context, part *Part, url string) error {
+	length := part.End - part.Start + 1
+	if e.IsResumable && part.Downloaded >= length {
+		return nil // Completed in an earlier run
+	}
+	if !e.IsResumable && part.Downloaded > 0 {
+		// Without ranges every attempt starts over
+		e.Stats.AddDownloaded(-part.Downloaded)
+		atomic.StoreInt64(&part.Downloaded, 0)
+		atomic.StoreInt64(&e.Stats.WireBytes, 0)
+		part.crc = 0
 	}
 
-	req.Header.Set("User-Agent", "Mozilla/5.0 (Windows NT 10.0; Win64; x64) AppleWebKit/537.36 (KHTML, like Gecko) Chrome/120.0.0.0 Safari/537.36")
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+	watch := newStallWatch(e.Config.StallTimeout, cancel)
+	defer watch.stop()
 
-	if e.IsResumable {
-		req.Header.Set("Range", fmt.Sprintf("bytes=%d-%d", part.Start, part.End))
+	body, err := e.fetcher.FetchRange(withStallWatch(withPart(ctx, part), watch), url, part.Start+part.Downloaded, part.End)
+	if err != nil {
+		return watch.err(err)
+	}
+	// Time spent held back by the limiter isn't a stall
+	body = limitBody(ctx, e.Config.Limiter, watch.reader(body))
+	defer body.Close()
+
+	// Never write past the part, whatever the server sends
+	known := e.Stats.GetTotal() > 0
+	if known {
+		body = struct {
+			io.Reader
+			io.Closer
+		}{io.LimitReader(body, length-part.Downloaded), body}
 	}
 
-	resp, err := e.Client.Do(req)
-	if err != nil {
-		return err
+	write := e.writePart
+	if e.stream != nil {
+		write = e.streamPart
+	}
+	if err := write(ctx, part, body); err != nil {
+		return watch.err(err)
+	}
+	if known && part.Downloaded != length {
+		// Retried from where it stopped
+		return fmt.Errorf("part %d is truncated: got %d of %d bytes", part.ID, part.Downloaded, length)
+	}
+	return nil
+}
+
+// checkPartResponse makes sure the body holds bytes from-to. A whole file
+// answer is only usable for a part at byte 0, which skips what it already
+// has. part is nil for ranges outside the parts.
+func checkPartResponse(resp *http.Response, part *Part, from, to int64) error {
+	what, partStart := "range", from
+	if part != nil {
+		what, partStart = fmt.Sprintf("part %d", part.ID), part.Start
+	}
+	if resp.StatusCode == http.StatusOK {
+		if partStart > 0 {
+			return fmt.Errorf("%s: %w", what, errRangeIgnored)
+		}
+		return nil
 	}
-	defer resp.Body.Close()
 
-	if resp.StatusCode != http.StatusPartialContent && resp.StatusCode != http.StatusOK {
-		return fmt.Errorf("server returned unexpected status: %s", resp.Status)
+	start, end, ok := contentRangeSpan(resp.Header.Get("Content-Range"))
+	if !ok {
+		return fmt.Errorf("%s: invalid Content-Range %q", what, resp.Header.Get("Content-Range"))
+	}
+	if start != from || end > to {
+		return fmt.Errorf("%s: requested bytes %d-%d, server sent %d-%d", what, from, to, start, end)
+	}
+	if resp.ContentLength >= 0 && resp.ContentLength != end-start+1 {
+		return fmt.Errorf("%s: Content-Length %d does not match range %d-%d", what, resp.ContentLength, start, end)
 	}
+	return nil
+}
 
-	file, err := os.Create(part.TempPath)
+// writePart appends body to the part's temp file, tracking progress. Reads
+// go through the write queue, progress and the CRC only advance once a chunk
+// is on disk so checkpoints never cover unwritten data.
+func (e *Engine) writePart(ctx context.Context, part *Part, body io.Reader) error {
+	file, err := os.OpenFile(part.TempPath, os.O_WRONLY|os.O_CREATE, 0o644)
 	if err != nil {
 		return err
 	}
 	defer file.Close()
 
-	// Create a proxy reader to update progress
-	buf := make([]byte, 32*1024) // 32KB buffer
+	// Drop anything past the last trusted byte, then append. An allocated
+	// file keeps its size, the bytes past it get overwritten.
+	if e.Config.Allocation == AllocNone {
+		if err := file.Truncate(part.Downloaded); err != nil {
+			return err
+		}
+	}
+
+	// Shared with the disk writer completing this part's chunks
+	var (
+		mu             sync.Mutex
+		writeErr       error
+		pending        sync.WaitGroup
+		lastCheckpoint = time.Now()
+	)
+	offset := part.Downloaded
+	drop := e.newCacheDropper(file, offset)
+	defer e.checkpoint(part)
+	defer func() { drop.flush(offset) }()
+	defer pending.Wait()
+
 	for {
-		select {
-		case <-ctx.Done():
-			return ctx.Err()
-		default:
-			n, err := resp.Body.Read(buf)
-			if n > 0 {
-				nw, wErr := file.Write(buf[:n])
-				if wErr != nil {
-					return wErr
+		buf, err := e.queue.acquire(ctx)
+		if err != nil {
+			return err
+		}
+
+		// Fill the chunk, a read alone returns about a TLS record
+		n, rErr := io.ReadFull(body, buf)
+		if rErr == io.ErrUnexpectedEOF {
+			rErr = io.EOF
+		}
+		if n > 0 {
+			data := buf[:n]
+			pending.Add(1)
+			e.queue.submit(part.ID, writeJob{f: file, off: offset, buf: buf, data: data, drop: drop, done: func(err error) {
+				defer pending.Done()
+				mu.Lock()
+				defer mu.Unlock()
+				if writeErr != nil {
+					return
 				}
-				if n != nw {
-					return io.ErrShortWrite
+				if err != nil {
+					writeErr = err
+					return
 				}
-				e.Stats.AddDownloaded(int64(n))
-			}
-			if err != nil {
-				if err == io.EOF {
-					return nil
+				atomic.AddInt64(&part.Downloaded, int64(len(data)))
+				part.crc = crc32.Update(part.crc, crc32.IEEETable, data)
+				e.Stats.AddDownloaded(int64(len(data)))
+
+				if time.Since(lastCheckpoint) >= time.Second {
+					e.checkpoint(part)
+					lastCheckpoint = time.Now()
 				}
-				return err
+			}})
+			offset += int64(n)
+		} else {
+			e.queue.release(buf)
+		}
+
+		mu.Lock()
+		wErr := writeErr
+		mu.Unlock()
+		if wErr != nil {
+			return wErr
+		}
+		if rErr != nil {
+			if rErr == io.EOF {
+				pending.Wait()
+				return writeErr
 			}
+			return rErr
+		}
+		if ctx.Err() != nil {
+			return ctx.Err()
 		}
 	}
 }
 
-func (e *Engine) mergeParts() error {
-	finalFile, err := os.Create(e.Config.OutputName)
-	if err != nil {
-		return err
+// checkpoint records part progress in the resume journal
+func (e *Engine) checkpoint(part *Part) {
+	if e.journal != nil {
+		e.journal.record(part.ID, part.Downloaded, part.crc)
+	}
+}
+
+// mergeParts joins the parts into the temporary output and returns its
+// files, one per volume when splitting
+func (e *Engine) mergeParts() ([]string, error) {
+	if e.volumeSize == 0 {
+		if err := e.mergeFile(); err != nil {
+			return nil, err
+		}
+		return []string{tmpPath(e.Config.OutputName)}, nil
 	}
+	finalFile := e.openVolumes()
 	defer finalFile.Close()
 
 	for _, part := range e.Parts {
 		partFile, err := os.Open(part.TempPath)
 		if err != nil {
 			finalFile.Close() // Close before returning
-			return err
+			return nil, err
 		}
-		
+
 		_, err = io.Copy(finalFile, partFile)
 		partFile.Close()
 		if err != nil {
-			return err
+			return nil, err
 		}
 
 		// Cleanup temp file
 		os.Remove(part.TempPath)
 	}
 
-	return nil
+	return finalFile.paths, nil
 }
@@ -0,0 +1,25 @@
+package downloader
+
+import (
+	"os"
+
+	"golang.org/x/sys/unix"
+)
+
+// fallocate reserves size bytes for f, contiguous if possible, and sets
+// its size
+func fallocate(f *os.File, size int64) error {
+	info, err := f.Stat()
+	if err != nil {
+		return err
+	}
+	// From the end of what's allocated already
+	store := &unix.Fstore_t{Flags: unix.F_ALLOCATECONTIG | unix.F_ALLOCATEALL, Posmode: unix.F_PEOFPOSMODE, Length: size - info.Size()}
+	if err := unix.FcntlFstore(f.Fd(), unix.F_PREALLOCATE, store); err != nil {
+		store.Flags = unix.F_ALLOCATEALL
+		if err := unix.FcntlFstore(f.Fd(), unix.F_PREALLOCATE, store); err != nil {
+			return err
+		}
+	}
+	return f.Truncate(size)
+}
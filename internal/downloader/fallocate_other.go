@@ -0,0 +1,12 @@
+//go:build !linux && !darwin
+
+package downloader
+
+import (
+	"errors"
+	"os"
+)
+
+func fallocate(f *os.File, size int64) error {
+	return errors.ErrUnsupported
+}
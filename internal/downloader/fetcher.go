@@ -0,0 +1,61 @@
+package downloader
+
+import (
+	"context"
+	"io"
+	"net/url"
+	"strings"
+	"sync"
+)
+
+// Fetcher serves byte ranges of a file over one protocol. The engine does
+// the rest the same for all of them: splitting, scheduling, retries,
+// resume, merging and verification. HTTP(S) is built in, other schemes
+// plug in with RegisterFetcher. A Fetcher that is an io.Closer is closed
+// when a download attempt ends.
+type Fetcher interface {
+	// Probe returns the file's size, 0 when unknown, and whether it can be
+	// fetched in ranges, which splitting and resuming need
+	Probe(ctx context.Context) (size int64, ranges bool, err error)
+	// FetchRange reads bytes start to end of the file, end included, from
+	// src: Config.URL or, with Capabilities.Mirrors, a mirror or peer. It
+	// is called by all parts at once.
+	FetchRange(ctx context.Context, src string, start, end int64) (io.ReadCloser, error)
+	Capabilities() Capabilities
+}
+
+// Capabilities tell the engine what a Fetcher can do besides ranges
+type Capabilities struct {
+	Protocol string // Shown by Inspect, e.g. "sftp"
+	Mirrors  bool   // FetchRange takes Config.Mirrors and the LAN peers as src
+}
+
+var (
+	fetchersMu sync.RWMutex
+	fetchers   = map[string]func(cfg Config) Fetcher{}
+)
+
+// RegisterFetcher makes URLs of the scheme download through the Fetcher
+// newFetcher returns for the download's Config, which is called once per
+// download. Registering a scheme again replaces the earlier one.
+func RegisterFetcher(scheme string, newFetcher func(cfg Config) Fetcher) {
+	fetchersMu.Lock()
+	defer fetchersMu.Unlock()
+	fetchers[strings.ToLower(scheme)] = newFetcher
+}
+
+// registeredFetcher returns the Fetcher registered for the URL's scheme,
+// nil for HTTP
+func registeredFetcher(cfg Config) Fetcher {
+	u, err := url.Parse(cfg.URL)
+	if err != nil {
+		return nil
+	}
+	fetchersMu.RLock()
+	newFetcher := fetchers[strings.ToLower(u.Scheme)]
+	fetchersMu.RUnlock()
+	if newFetcher == nil {
+		return nil
+	}
+	return newFetcher(cfg)
+}
@@ -0,0 +1,99 @@
+package downloader
+
+import (
+	"fmt"
+	"mime"
+	"net/http"
+	"net/url"
+	"path"
+	"path/filepath"
+	"regexp"
+	"strings"
+	"time"
+)
+
+// NameTokens are the placeholders of Config.NameTemplate
+var NameTokens = []string{"{host}", "{filename}", "{name}", "{ext}", "{date}"}
+
+var tokenPattern = regexp.MustCompile(`\{[^{}]*\}`)
+
+// CheckNameTemplate rejects a name template with unknown placeholders
+func CheckNameTemplate(tmpl string) error {
+	for _, tok := range tokenPattern.FindAllString(tmpl, -1) {
+		known := false
+		for _, t := range NameTokens {
+			known = known || t == tok
+		}
+		if !known {
+			return fmt.Errorf("unknown placeholder %s in name template, want one of %s", tok, strings.Join(NameTokens, " "))
+		}
+	}
+	return nil
+}
+
+// defaultOutput is where a download saved under its own name goes: the
+// name template filled in with that name, below Dir
+func (c Config) defaultOutput(name string) string {
+	if c.NameTemplate != "" {
+		name = expandName(c.NameTemplate, c.URL, name, time.Now())
+	}
+	return filepath.Join(c.Dir, name)
+}
+
+// expandName fills in a name template. {host} is the URL's host name,
+// {filename} the file's name, {name} and {ext} that name without and just
+// its extension (without the dot), {date} today as 2006-01-02.
+func expandName(tmpl, rawURL, filename string, now time.Time) string {
+	host := ""
+	if u, err := url.Parse(rawURL); err == nil {
+		host = u.Hostname()
+	}
+	if host == "" {
+		host = "unknown-host"
+	}
+	ext := filepath.Ext(filename)
+	return strings.NewReplacer(
+		"{host}", host,
+		"{filename}", filename,
+		"{name}", strings.TrimSuffix(filename, ext),
+		"{ext}", strings.TrimPrefix(ext, "."),
+		"{date}", now.Format("2006-01-02"),
+	).Replace(tmpl)
+}
+
+// responseFileName picks the server's name for a download: the
+// Content-Disposition filename, else the last path segment of the URL the
+// redirects ended at. It returns "" when neither gives a usable name.
+func responseFileName(resp *http.Response) string {
+	if cd := resp.Header.Get("Content-Disposition"); cd != "" {
+		// mime decodes RFC 5987 filename*= and prefers it over filename=
+		if _, params, err := mime.ParseMediaType(cd); err == nil {
+			if name := safeFileName(params["filename"]); name != "" {
+				return name
+			}
+		}
+	}
+	if resp.Request != nil {
+		return urlFileName(resp.Request.URL)
+	}
+	return ""
+}
+
+// urlFileName is the unescaped last path segment of u
+func urlFileName(u *url.URL) string {
+	if u == nil {
+		return ""
+	}
+	return safeFileName(path.Base(u.Path))
+}
+
+// safeFileName strips any directory part a server slipped into the name
+func safeFileName(name string) string {
+	name = strings.ReplaceAll(name, "\\", "/")
+	name = strings.TrimSpace(path.Base(name))
+	switch name {
+	case "", ".", "..", "/":
+		return ""
+	}
+	return filepath.Clean(name)
+}
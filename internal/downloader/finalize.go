@@ -0,0 +1,63 @@
+package downloader
+
+import (
+	"os"
+	"path/filepath"
+	"runtime"
+)
+
+// Outputs are assembled as <output>.warp-tmp and renamed into place only
+// once complete and verified, so a file under the final name is always
+// whole. An interrupted merge leaves nothing that looks finished.
+
+const tmpSuffix = ".warp-tmp"
+
+func tmpPath(path string) string {
+	return path + tmpSuffix
+}
+
+// commitFile renames a finished temporary file to its final name. With
+// sync the data reaches the disk before the rename and the new directory
+// entry after it, so a power cut can't leave a renamed but empty file.
+func commitFile(tmp, final string, sync bool) error {
+	if sync {
+		if err := syncPath(tmp); err != nil {
+			return err
+		}
+	}
+	if err := os.Rename(tmp, final); err != nil {
+		return err
+	}
+	if sync && runtime.GOOS != "windows" {
+		// Windows can't open directories for syncing, NTFS journals the rename
+		return syncPath(filepath.Dir(final))
+	}
+	return nil
+}
+
+func syncPath(path string) error {
+	f, err := os.Open(path)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+	return f.Sync()
+}
+
+// commit moves the engine's finished outputs into place
+func (e *Engine) commit(pending []string) error {
+	for _, tmp := range pending {
+		final := tmp[:len(tmp)-len(tmpSuffix)]
+		if e.Config.DropCache {
+			// Read back by the verification
+			DropFileCache(tmp)
+		}
+		if err := commitFile(tmp, final, e.Config.Fsync); err != nil {
+			return err
+		}
+		if e.volumeSize > 0 {
+			e.Volumes = append(e.Volumes, final)
+		}
+	}
+	return nil
+}
@@ -0,0 +1,257 @@
+package downloader
+
+import (
+	"context"
+	"crypto/tls"
+	"fmt"
+	"net"
+	"net/http"
+	"sort"
+	"strings"
+	"sync"
+
+	utls "github.com/refraction-networking/utls"
+	"golang.org/x/net/http2"
+)
+
+// Some CDNs and filters block Go's TLS ClientHello whatever the User-Agent
+// says. With Config.TLSFingerprint direct HTTPS connections send a
+// browser's ClientHello instead, ALPN included, and speak HTTP/2 when the
+// server picks it like the browser would. Through a proxy the proxy hop is
+// plain Go TLS.
+
+var tlsFingerprints = map[string]utls.ClientHelloID{
+	"chrome":     utls.HelloChrome_Auto,
+	"firefox":    utls.HelloFirefox_Auto,
+	"safari":     utls.HelloSafari_Auto,
+	"edge":       utls.HelloEdge_Auto,
+	"ios":        utls.HelloIOS_Auto,
+	"randomized": utls.HelloRandomized,
+}
+
+// TLSFingerprints returns the names Config.TLSFingerprint takes, sorted
+func TLSFingerprints() []string {
+	names := make([]string, 0, len(tlsFingerprints))
+	for name := range tlsFingerprints {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+// CheckTLSFingerprint checks a --tls-fingerprint name
+func CheckTLSFingerprint(name string) error {
+	if _, ok := tlsFingerprints[name]; !ok && name != "" {
+		return fmt.Errorf("unknown TLS fingerprint %q (want %s)", name, strings.Join(TLSFingerprints(), ", "))
+	}
+	return nil
+}
+
+// fingerprintTransport dials HTTPS itself with uTLS and hands the
+// connection to an HTTP/2 client connection or to the HTTP/1.1 transport,
+// whichever the server negotiated
+type fingerprintTransport struct {
+	hello      utls.ClientHelloID
+	serverName string // Config.SNI, "" for the URL's host
+	insecure   bool
+	verify     func(tls.ConnectionState) error // Config.PinSHA256
+	noH2       bool
+	dial       func(ctx context.Context, network, addr string) (net.Conn, error)
+	h1         *http.Transport
+	h2         *http2.Transport
+
+	mu      sync.Mutex
+	h2conns map[string]*http2.ClientConn
+	h1hosts map[string]bool
+	pending map[string][]net.Conn // Handshakes that chose HTTP/1.1, for h1 to pick up
+}
+
+func newFingerprintTransport(base *http.Transport, name string, noH2 bool) *fingerprintTransport {
+	t := &fingerprintTransport{
+		hello:   tlsFingerprints[name],
+		noH2:    noH2,
+		dial:    base.DialContext,
+		h2:      &http2.Transport{StrictMaxConcurrentStreams: true},
+		h2conns: map[string]*http2.ClientConn{},
+		h1hosts: map[string]bool{},
+		pending: map[string][]net.Conn{},
+	}
+	if c := base.TLSClientConfig; c != nil {
+		t.serverName, t.insecure, t.verify = c.ServerName, c.InsecureSkipVerify, c.VerifyConnection
+	}
+	if t.dial == nil {
+		t.dial = func(ctx context.Context, network, addr string) (net.Conn, error) {
+			d := linkDialer(ctx)
+			return d.DialContext(ctx, network, addr)
+		}
+	}
+	t.h2.DisableCompression = base.DisableCompression
+	t.h1 = base.Clone()
+	t.h1.DialTLSContext = func(ctx context.Context, network, addr string) (net.Conn, error) {
+		if c := t.takePending(addr); c != nil {
+			return c, nil
+		}
+		// The host already chose HTTP/1.1, don't offer it HTTP/2 on a
+		// connection that can't speak it
+		c, err := t.dialTLS(ctx, network, addr, true)
+		if err != nil {
+			return nil, err
+		}
+		return c, nil
+	}
+	return t
+}
+
+func (t *fingerprintTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	if req.URL.Scheme != "https" || t.proxied(req) {
+		return t.h1.RoundTrip(req)
+	}
+	addr := canonicalAddr(req.URL.Hostname(), req.URL.Port())
+
+	t.mu.Lock()
+	cc, h1 := t.h2conns[addr], t.h1hosts[addr]
+	t.mu.Unlock()
+	if cc != nil && cc.CanTakeNewRequest() {
+		return t.roundTripH2(cc, addr, req)
+	}
+	if h1 {
+		return t.h1.RoundTrip(req)
+	}
+
+	conn, err := t.dialTLS(req.Context(), "tcp", addr, t.noH2)
+	if err != nil {
+		return nil, err
+	}
+	if conn.ConnectionState().NegotiatedProtocol != "h2" {
+		t.mu.Lock()
+		t.h1hosts[addr] = true
+		t.pending[addr] = append(t.pending[addr], conn)
+		t.mu.Unlock()
+		return t.h1.RoundTrip(req)
+	}
+
+	fresh, err := t.h2.NewClientConn(conn)
+	if err != nil {
+		conn.Close()
+		return nil, err
+	}
+	t.mu.Lock()
+	if cc = t.h2conns[addr]; cc != nil && cc.CanTakeNewRequest() {
+		// Another request got there first, share its connection
+		fresh.Close()
+	} else {
+		cc = fresh
+		t.h2conns[addr] = cc
+	}
+	t.mu.Unlock()
+	return t.roundTripH2(cc, addr, req)
+}
+
+func (t *fingerprintTransport) roundTripH2(cc *http2.ClientConn, addr string, req *http.Request) (*http.Response, error) {
+	req, sent := holdStallUntilSent(req)
+	resp, err := cc.RoundTrip(req)
+	sent()
+	if err != nil && !cc.CanTakeNewRequest() {
+		t.mu.Lock()
+		if t.h2conns[addr] == cc {
+			delete(t.h2conns, addr)
+		}
+		t.mu.Unlock()
+	}
+	return resp, err
+}
+
+// proxied tells whether the request goes through a proxy, which the h1
+// transport connects to
+func (t *fingerprintTransport) proxied(req *http.Request) bool {
+	if t.h1.Proxy == nil {
+		return false
+	}
+	u, err := t.h1.Proxy(req)
+	return err != nil || u != nil
+}
+
+func (t *fingerprintTransport) takePending(addr string) net.Conn {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	conns := t.pending[addr]
+	if len(conns) == 0 {
+		return nil
+	}
+	t.pending[addr] = conns[1:]
+	return conns[0]
+}
+
+// dialTLS connects and shakes hands with the browser's ClientHello, only
+// offering HTTP/1.1 with h1Only
+func (t *fingerprintTransport) dialTLS(ctx context.Context, network, addr string, h1Only bool) (*utls.UConn, error) {
+	raw, err := t.dial(ctx, network, addr)
+	if err != nil {
+		return nil, err
+	}
+	name := t.serverName
+	if name == "" {
+		name, _, _ = net.SplitHostPort(addr)
+	}
+	conf := &utls.Config{ServerName: name, InsecureSkipVerify: t.insecure}
+	if t.verify != nil {
+		conf.VerifyConnection = func(cs utls.ConnectionState) error {
+			return t.verify(tls.ConnectionState{ServerName: cs.ServerName, PeerCertificates: cs.PeerCertificates})
+		}
+	}
+
+	var conn *utls.UConn
+	if !h1Only {
+		conn = utls.UClient(raw, conf, t.hello)
+	} else if t.hello == utls.HelloRandomized {
+		conf.NextProtos = []string{"http/1.1"}
+		conn = utls.UClient(raw, conf, t.hello)
+	} else {
+		spec, err := utls.UTLSIdToSpec(t.hello)
+		if err != nil {
+			raw.Close()
+			return nil, err
+		}
+		for _, ext := range spec.Extensions {
+			if alpn, ok := ext.(*utls.ALPNExtension); ok {
+				alpn.AlpnProtocols = []string{"http/1.1"}
+			}
+		}
+		conn = utls.UClient(raw, conf, utls.HelloCustom)
+		if err := conn.ApplyPreset(&spec); err != nil {
+			raw.Close()
+			return nil, err
+		}
+	}
+	if err := conn.HandshakeContext(ctx); err != nil {
+		raw.Close()
+		return nil, fmt.Errorf("TLS handshake with %s: %w", addr, err)
+	}
+	return conn, nil
+}
+
+func (t *fingerprintTransport) CloseIdleConnections() {
+	t.h1.CloseIdleConnections()
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	for addr, conns := range t.pending {
+		for _, c := range conns {
+			c.Close()
+		}
+		delete(t.pending, addr)
+	}
+	for addr, cc := range t.h2conns {
+		if cc.State().StreamsActive == 0 {
+			cc.Close()
+			delete(t.h2conns, addr)
+		}
+	}
+}
+
+// canonicalAddr is host:port with the scheme's default port for HTTPS
+func canonicalAddr(host, port string) string {
+	if port == "" {
+		port = "443"
+	}
+	return net.JoinHostPort(host, port)
+}
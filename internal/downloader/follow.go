@@ -0,0 +1,135 @@
+package downloader
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// follow keeps appending whatever the remote file gained since the last poll
+// (like tail -f over HTTP) until ctx is cancelled
+func (e *Engine) follow(ctx context.Context) error {
+	if !e.IsResumable {
+		return fmt.Errorf("--follow requires a server that supports byte ranges")
+	}
+
+	f, err := os.OpenFile(e.Config.OutputName, os.O_WRONLY|os.O_APPEND, 0)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+
+	info, err := f.Stat()
+	if err != nil {
+		return err
+	}
+	offset := info.Size()
+
+	interval := e.Config.FollowInterval
+	if interval <= 0 {
+		interval = 5 * time.Second
+	}
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	maxFailures := 3
+	failures := 0
+	for {
+		select {
+		case <-ctx.Done():
+			return nil
+		case <-ticker.C:
+		}
+
+		n, err := e.pollGrowth(ctx, f, offset)
+		offset += n
+		if err != nil {
+			if ctx.Err() != nil {
+				return nil
+			}
+			if failures++; failures >= maxFailures {
+				return fmt.Errorf("follow failed after %d attempts: %w", failures, err)
+			}
+			continue
+		}
+		failures = 0
+	}
+}
+
+// pollGrowth fetches bytes from offset onwards and appends them to f
+func (e *Engine) pollGrowth(ctx context.Context, f *os.File, offset int64) (int64, error) {
+	req, err := http.NewRequestWithContext(ctx, "GET", e.Config.URL, nil)
+	if err != nil {
+		return 0, err
+	}
+	req.Header.Set("User-Agent", defaultUserAgent)
+	req.Header.Set("Range", fmt.Sprintf("bytes=%d-", offset))
+
+	resp, err := e.Client.Do(req)
+	if err != nil {
+		return 0, err
+	}
+	defer resp.Body.Close()
+
+	body := io.Reader(resp.Body)
+	switch resp.StatusCode {
+	case http.StatusRequestedRangeNotSatisfiable:
+		// No growth, unless the file was truncated or rotated
+		if total := contentRangeTotal(resp.Header.Get("Content-Range")); total >= 0 && total < offset {
+			return 0, fmt.Errorf("remote file shrank from %d to %d bytes", offset, total)
+		}
+		return 0, nil
+	case http.StatusPartialContent:
+	case http.StatusOK:
+		// Range ignored for this request, skip what we already have
+		if _, err := io.CopyN(io.Discard, resp.Body, offset); err != nil {
+			if err == io.EOF {
+				return 0, nil
+			}
+			return 0, err
+		}
+	default:
+		return 0, newStatusError(resp)
+	}
+
+	n, err := io.Copy(f, &countingReader{r: body, stats: e.Stats})
+	if n > 0 {
+		e.Stats.SetTotal(e.Stats.GetTotal() + n)
+	}
+	return n, err
+}
+
+// contentRangeTotal extracts the complete length from a Content-Range
+// header, -1 if it is absent or unknown
+func contentRangeTotal(cr string) int64 {
+	_, totalStr, ok := strings.Cut(cr, "/")
+	if !ok {
+		return -1
+	}
+	total, err := strconv.ParseInt(strings.TrimSpace(totalStr), 10, 64)
+	if err != nil {
+		return -1
+	}
+	return total
+}
+
+// contentRangeSpan extracts the first and last byte position from a
+// Content-Range header
+func contentRangeSpan(cr string) (start, end int64, ok bool) {
+	span, _, _ := strings.Cut(strings.TrimPrefix(strings.TrimSpace(cr), "bytes "), "/")
+	first, last, found := strings.Cut(span, "-")
+	if !found {
+		return 0, 0, false
+	}
+	start, err1 := strconv.ParseInt(strings.TrimSpace(first), 10, 64)
+	end, err2 := strconv.ParseInt(strings.TrimSpace(last), 10, 64)
+	if err1 != nil || err2 != nil || end < start {
+		return 0, 0, false
+	}
+	return start, end, true
+}
@@ -0,0 +1,14 @@
+package downloader
+
+const (
+	fsFAT = "FAT"
+	// fatMaxFileSize is the largest file FAT12/16/32 can store (4 GiB - 1)
+	fatMaxFileSize = 1<<32 - 1
+)
+
+// fsLimit describes the file system an output is written to
+type fsLimit struct {
+	Name        string // File system type, empty if unknown
+	MaxFileSize int64  // 0 when there is no practical limit
+	Free        int64  // Bytes available to us, -1 if unknown
+}
@@ -0,0 +1,23 @@
+//go:build darwin || freebsd || dragonfly
+
+package downloader
+
+import (
+	"bytes"
+
+	"golang.org/x/sys/unix"
+)
+
+// targetFS reports the file system dir lives on
+func targetFS(dir string) fsLimit {
+	var st unix.Statfs_t
+	if err := unix.Statfs(dir, &st); err != nil {
+		return fsLimit{Free: -1}
+	}
+	fs := fsLimit{Free: int64(st.Bavail) * int64(st.Bsize)}
+	name := string(bytes.TrimRight(st.Fstypename[:], "\x00"))
+	if name == "msdos" || name == "msdosfs" {
+		fs.Name, fs.MaxFileSize = fsFAT, fatMaxFileSize
+	}
+	return fs
+}
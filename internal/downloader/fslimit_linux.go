@@ -0,0 +1,18 @@
+package downloader
+
+import "golang.org/x/sys/unix"
+
+const msdosSuperMagic = 0x4d44
+
+// targetFS reports the file system dir lives on
+func targetFS(dir string) fsLimit {
+	var st unix.Statfs_t
+	if err := unix.Statfs(dir, &st); err != nil {
+		return fsLimit{Free: -1}
+	}
+	fs := fsLimit{Free: int64(st.Bavail) * int64(st.Bsize)}
+	if st.Type == msdosSuperMagic {
+		fs.Name, fs.MaxFileSize = fsFAT, fatMaxFileSize
+	}
+	return fs
+}
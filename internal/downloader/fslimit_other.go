@@ -0,0 +1,7 @@
+//go:build !linux && !windows && !darwin && !freebsd && !dragonfly
+
+package downloader
+
+func targetFS(dir string) fsLimit {
+	return fsLimit{Free: -1}
+}
@@ -0,0 +1,38 @@
+package downloader
+
+import (
+	"path/filepath"
+	"strings"
+
+	"golang.org/x/sys/windows"
+)
+
+// targetFS reports the file system dir lives on
+func targetFS(dir string) fsLimit {
+	abs, err := filepath.Abs(dir)
+	if err != nil {
+		return fsLimit{Free: -1}
+	}
+	fs := fsLimit{Free: -1}
+	if p, err := windows.UTF16PtrFromString(abs); err == nil {
+		var avail, total, totalFree uint64
+		if windows.GetDiskFreeSpaceEx(p, &avail, &total, &totalFree) == nil {
+			fs.Free = int64(avail)
+		}
+	}
+
+	root := filepath.VolumeName(abs) + `\`
+	rootPtr, err := windows.UTF16PtrFromString(root)
+	if err != nil {
+		return fs
+	}
+	var name [windows.MAX_PATH + 1]uint16
+	if err := windows.GetVolumeInformation(rootPtr, nil, 0, nil, nil, nil, &name[0], uint32(len(name))); err != nil {
+		return fs
+	}
+	// FAT, FAT32, but not exFAT
+	if n := windows.UTF16ToString(name[:]); strings.HasPrefix(strings.ToUpper(n), fsFAT) {
+		fs.Name, fs.MaxFileSize = fsFAT, fatMaxFileSize
+	}
+	return fs
+}
@@ -0,0 +1,56 @@
+package downloader
+
+import (
+	"fmt"
+	"net/http"
+	"strings"
+)
+
+// headerTransport adds the configured headers to every request of a
+// download: probes, parts, playlists and segments alike. Headers the
+// request sets itself win, except for the built-in User-Agent.
+type headerTransport struct {
+	base   http.RoundTripper
+	header http.Header
+	hosts  map[string]http.Header // Config.HostHeaders
+}
+
+func (t *headerTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	out := req
+	for name, values := range t.headerFor(req.URL.Hostname()) {
+		if have := req.Header.Get(name); have != "" && !(name == "User-Agent" && have == defaultUserAgent) {
+			continue
+		}
+		if out == req {
+			out = req.Clone(req.Context())
+		}
+		out.Header[name] = values
+	}
+	return t.base.RoundTrip(out)
+}
+
+// headerFor returns the headers of the most specific domain host is or is
+// under, the common ones when there is none. Redirects to another host get
+// that host's.
+func (t *headerTransport) headerFor(host string) http.Header {
+	host = strings.ToLower(host)
+	h, best := t.header, ""
+	for domain, set := range t.hosts {
+		d := strings.TrimPrefix(strings.TrimPrefix(strings.ToLower(domain), "*"), ".")
+		if (host == d || strings.HasSuffix(host, "."+d)) && len(d) > len(best) {
+			h, best = set, d
+		}
+	}
+	return h
+}
+
+// ParseHeader parses a "Name: value" header flag. An empty value cancels a
+// default header of that name.
+func ParseHeader(s string) (name, value string, err error) {
+	name, value, ok := strings.Cut(s, ":")
+	name = strings.TrimSpace(name)
+	if !ok || name == "" || strings.ContainsAny(name, " \t") {
+		return "", "", fmt.Errorf("invalid header %q: want \"Name: value\"", s)
+	}
+	return http.CanonicalHeaderKey(name), strings.TrimSpace(value), nil
+}
@@ -0,0 +1,450 @@
+package downloader
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"crypto/aes"
+	"crypto/cipher"
+	"encoding/binary"
+	"encoding/hex"
+	"fmt"
+	"net/http"
+	"net/url"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+)
+
+// HLSDownloader fetches every segment of an HLS (m3u8) playlist and
+// concatenates them into a single output file
+type HLSDownloader struct {
+	Config Config
+	Stats  *Stats
+	Client *http.Client
+
+	keyMu sync.Mutex
+	keys  map[string][]byte
+}
+
+// streamVariant is one selectable rendition of an HLS or DASH stream
+type streamVariant struct {
+	URI       string
+	Bandwidth int64
+	Height    int
+	index     int // Position in the source manifest
+}
+
+type hlsKey struct {
+	Method string
+	URI    string
+	IV     []byte // nil means derive from the media sequence number
+}
+
+type hlsSegment struct {
+	mediaSegment
+	Seq int64
+	Key *hlsKey
+}
+
+type hlsPlaylist struct {
+	Variants []streamVariant
+	Init     *hlsSegment // EXT-X-MAP, present for fragmented MP4 streams
+	Segments []hlsSegment
+}
+
+// IsHLS reports whether url points at an m3u8 playlist
+func IsHLS(url string) bool {
+	if i := strings.IndexAny(url, "?#"); i >= 0 {
+		url = url[:i]
+	}
+	return strings.EqualFold(filepath.Ext(url), ".m3u8")
+}
+
+// NewHLSDownloader creates a downloader for the playlist at cfg.URL
+func NewHLSDownloader(cfg Config) *HLSDownloader {
+	return &HLSDownloader{
+		Config: cfg,
+		Stats:  &Stats{},
+		Client: NewClient(cfg),
+		keys:   make(map[string][]byte),
+	}
+}
+
+// Progress returns the live statistics of the download
+func (h *HLSDownloader) Progress() *Stats {
+	return h.Stats
+}
+
+// Start resolves the playlist, downloads all segments and writes the output
+func (h *HLSDownloader) Start(ctx context.Context) error {
+	playlistURL := h.Config.URL
+	pl, err := h.fetchPlaylist(ctx, playlistURL)
+	if err != nil {
+		return fmt.Errorf("failed to fetch playlist: %w", err)
+	}
+
+	// Master playlist: pick a variant and load its media playlist
+	if len(pl.Variants) > 0 {
+		v, err := selectVariant(pl.Variants, h.Config.Quality)
+		if err != nil {
+			return err
+		}
+		playlistURL = v.URI
+		if pl, err = h.fetchPlaylist(ctx, playlistURL); err != nil {
+			return fmt.Errorf("failed to fetch variant playlist: %w", err)
+		}
+	}
+	if len(pl.Segments) == 0 {
+		return fmt.Errorf("playlist has no segments")
+	}
+
+	ext := ".ts"
+	if pl.Init != nil {
+		ext = ".mp4"
+	}
+	if h.Config.OutputName == "" {
+		base := filepath.Base(strings.SplitN(h.Config.URL, "?", 2)[0])
+		h.Config.OutputName = h.Config.defaultOutput(strings.TrimSuffix(base, filepath.Ext(base)) + ext)
+	}
+	if dir := filepath.Dir(h.Config.OutputName); dir != "." {
+		if err := os.MkdirAll(dir, 0o755); err != nil {
+			return err
+		}
+	}
+
+	// Writing TS segments into an .mp4/.mkv needs a real remux
+	remuxFormat := remuxFormats[strings.ToLower(filepath.Ext(h.Config.OutputName))]
+	remux := ext == ".ts" && remuxFormat != ""
+	if remux {
+		if _, err := exec.LookPath("ffmpeg"); err != nil {
+			return fmt.Errorf("remuxing to %s requires ffmpeg in PATH", filepath.Ext(h.Config.OutputName))
+		}
+	}
+
+	segments := pl.Segments
+	if pl.Init != nil {
+		segments = append([]hlsSegment{*pl.Init}, segments...)
+	}
+	media := make([]mediaSegment, len(segments))
+	paths := make([]string, len(segments))
+	for i, seg := range segments {
+		media[i] = seg.mediaSegment
+		paths[i] = fmt.Sprintf("%s.seg%d", h.Config.OutputName, i)
+	}
+
+	fetcher := &segmentFetcher{
+		client:      h.Client,
+		stats:       h.Stats,
+		concurrency: h.Config.Concurrency,
+		retry:       h.Config.retryPolicy(),
+		limiter:     h.Config.Limiter,
+		transform: func(ctx context.Context, i int, data []byte) ([]byte, error) {
+			if key := segments[i].Key; key != nil && key.Method == "AES-128" {
+				return h.decrypt(ctx, segments[i], data)
+			}
+			return data, nil
+		},
+	}
+	if err := fetcher.fetchAll(ctx, media, paths); err != nil {
+		return err
+	}
+
+	tmp := tmpPath(h.Config.OutputName)
+	target := tmp
+	if remux {
+		target += ".ts"
+	}
+	if err := concatFiles(target, paths); err != nil {
+		return fmt.Errorf("failed to concatenate segments: %w", err)
+	}
+
+	if remux {
+		// The format is named explicitly, ffmpeg can't tell it from the
+		// temporary extension
+		cmd := exec.CommandContext(ctx, "ffmpeg", "-y", "-loglevel", "error", "-i", target, "-c", "copy", "-f", remuxFormat, tmp)
+		if out, err := cmd.CombinedOutput(); err != nil {
+			return fmt.Errorf("ffmpeg remux failed: %v: %s", err, bytes.TrimSpace(out))
+		}
+		os.Remove(target)
+	}
+
+	if h.Config.Checksum != nil {
+		if err := h.Config.Checksum.Verify(tmp); err != nil {
+			return fmt.Errorf("verification failed: %w", err)
+		}
+	}
+	return commitFile(tmp, h.Config.OutputName, h.Config.Fsync)
+}
+
+func (h *HLSDownloader) decrypt(ctx context.Context, seg hlsSegment, data []byte) ([]byte, error) {
+	key, err := h.fetchKey(ctx, seg.Key.URI)
+	if err != nil {
+		return nil, fmt.Errorf("failed to fetch key: %w", err)
+	}
+
+	iv := seg.Key.IV
+	if iv == nil {
+		iv = make([]byte, aes.BlockSize)
+		binary.BigEndian.PutUint64(iv[8:], uint64(seg.Seq))
+	}
+
+	block, err := aes.NewCipher(key)
+	if err != nil {
+		return nil, err
+	}
+	if len(data)%aes.BlockSize != 0 {
+		return nil, fmt.Errorf("encrypted segment %d is not block aligned", seg.Seq)
+	}
+	cipher.NewCBCDecrypter(block, iv).CryptBlocks(data, data)
+
+	// Strip PKCS#7 padding
+	if len(data) > 0 {
+		pad := int(data[len(data)-1])
+		if pad > 0 && pad <= aes.BlockSize && pad <= len(data) {
+			data = data[:len(data)-pad]
+		}
+	}
+	return data, nil
+}
+
+func (h *HLSDownloader) fetchKey(ctx context.Context, uri string) ([]byte, error) {
+	h.keyMu.Lock()
+	defer h.keyMu.Unlock()
+
+	if key, ok := h.keys[uri]; ok {
+		return key, nil
+	}
+
+	body, err := fetchBody(ctx, h.Client, uri)
+	if err != nil {
+		return nil, err
+	}
+	if len(body) != 16 {
+		return nil, fmt.Errorf("invalid AES-128 key length %d", len(body))
+	}
+	h.keys[uri] = body
+	return body, nil
+}
+
+func (h *HLSDownloader) fetchPlaylist(ctx context.Context, uri string) (*hlsPlaylist, error) {
+	body, err := fetchBody(ctx, h.Client, uri)
+	if err != nil {
+		return nil, err
+	}
+	base, err := url.Parse(uri)
+	if err != nil {
+		return nil, err
+	}
+	return parseHLSPlaylist(body, base)
+}
+
+func parseHLSPlaylist(data []byte, base *url.URL) (*hlsPlaylist, error) {
+	sc := bufio.NewScanner(bytes.NewReader(data))
+	sc.Buffer(make([]byte, 64*1024), 1024*1024)
+
+	if !sc.Scan() || !strings.HasPrefix(strings.TrimSpace(strings.TrimPrefix(sc.Text(), "\ufeff")), "#EXTM3U") {
+		return nil, fmt.Errorf("not an m3u8 playlist")
+	}
+
+	resolve := func(ref string) (string, error) {
+		u, err := base.Parse(ref)
+		if err != nil {
+			return "", err
+		}
+		return u.String(), nil
+	}
+
+	pl := &hlsPlaylist{}
+	var (
+		seq        int64
+		key        *hlsKey
+		pendingVar *streamVariant
+		nextOffset int64
+		byteRange  = [2]int64{-1, 0} // length, offset
+	)
+
+	for sc.Scan() {
+		line := strings.TrimSpace(sc.Text())
+		if line == "" {
+			continue
+		}
+
+		tag, value, _ := strings.Cut(line, ":")
+		switch tag {
+		case "#EXT-X-MEDIA-SEQUENCE":
+			seq, _ = strconv.ParseInt(value, 10, 64)
+
+		case "#EXT-X-STREAM-INF":
+			attrs := parseAttributes(value)
+			v := streamVariant{}
+			v.Bandwidth, _ = strconv.ParseInt(attrs["BANDWIDTH"], 10, 64)
+			if _, hStr, ok := strings.Cut(attrs["RESOLUTION"], "x"); ok {
+				v.Height, _ = strconv.Atoi(hStr)
+			}
+			pendingVar = &v
+
+		case "#EXT-X-KEY":
+			attrs := parseAttributes(value)
+			switch attrs["METHOD"] {
+			case "NONE":
+				key = nil
+			case "AES-128":
+				uri, err := resolve(attrs["URI"])
+				if err != nil {
+					return nil, err
+				}
+				key = &hlsKey{Method: "AES-128", URI: uri}
+				if iv := attrs["IV"]; iv != "" {
+					b, err := hex.DecodeString(strings.TrimPrefix(strings.TrimPrefix(iv, "0x"), "0X"))
+					if err != nil || len(b) != aes.BlockSize {
+						return nil, fmt.Errorf("invalid key IV %q", iv)
+					}
+					key.IV = b
+				}
+			default:
+				return nil, fmt.Errorf("unsupported encryption method %q", attrs["METHOD"])
+			}
+
+		case "#EXT-X-MAP":
+			attrs := parseAttributes(value)
+			uri, err := resolve(attrs["URI"])
+			if err != nil {
+				return nil, err
+			}
+			pl.Init = &hlsSegment{mediaSegment: mediaSegment{URI: uri, Length: -1}}
+			if br := attrs["BYTERANGE"]; br != "" {
+				pl.Init.Length, pl.Init.Offset = parseHLSByteRange(br, 0)
+			}
+
+		case "#EXT-X-BYTERANGE":
+			byteRange[0], byteRange[1] = parseHLSByteRange(value, nextOffset)
+
+		default:
+			if strings.HasPrefix(line, "#") {
+				continue
+			}
+			uri, err := resolve(line)
+			if err != nil {
+				return nil, err
+			}
+
+			if pendingVar != nil {
+				pendingVar.URI = uri
+				pl.Variants = append(pl.Variants, *pendingVar)
+				pendingVar = nil
+				continue
+			}
+
+			seg := hlsSegment{
+				mediaSegment: mediaSegment{URI: uri, Length: byteRange[0], Offset: byteRange[1]},
+				Seq:          seq,
+				Key:          key,
+			}
+			if seg.Length >= 0 {
+				nextOffset = seg.Offset + seg.Length
+			}
+			pl.Segments = append(pl.Segments, seg)
+			byteRange = [2]int64{-1, 0}
+			seq++
+		}
+	}
+	return pl, sc.Err()
+}
+
+// parseHLSByteRange parses "<n>[@<o>]"; without an offset the sub-range
+// starts where the previous one ended
+func parseHLSByteRange(s string, prevEnd int64) (int64, int64) {
+	nStr, oStr, hasOffset := strings.Cut(s, "@")
+	n, _ := strconv.ParseInt(nStr, 10, 64)
+	if !hasOffset {
+		return n, prevEnd
+	}
+	o, _ := strconv.ParseInt(oStr, 10, 64)
+	return n, o
+}
+
+// parseAttributes parses an m3u8 attribute list, honouring quoted values
+func parseAttributes(s string) map[string]string {
+	attrs := make(map[string]string)
+	for s != "" {
+		name, rest, ok := strings.Cut(s, "=")
+		if !ok {
+			break
+		}
+		var value string
+		if strings.HasPrefix(rest, `"`) {
+			end := strings.IndexByte(rest[1:], '"')
+			if end < 0 {
+				value, rest = rest[1:], ""
+			} else {
+				value, rest = rest[1:end+1], rest[end+2:]
+			}
+		} else {
+			value, rest, _ = strings.Cut(rest, ",")
+		}
+		attrs[strings.ToUpper(strings.TrimSpace(name))] = value
+		s = strings.TrimPrefix(strings.TrimSpace(rest), ",")
+	}
+	return attrs
+}
+
+// selectVariant picks a stream by quality: "best" (default), "worst",
+// a height like "720p", or a maximum bandwidth in bits/s
+func selectVariant(variants []streamVariant, quality string) (streamVariant, error) {
+	sorted := append([]streamVariant(nil), variants...)
+	sort.SliceStable(sorted, func(i, j int) bool {
+		if sorted[i].Height != sorted[j].Height {
+			return sorted[i].Height > sorted[j].Height
+		}
+		return sorted[i].Bandwidth > sorted[j].Bandwidth
+	})
+
+	q := strings.ToLower(strings.TrimSpace(quality))
+	switch {
+	case q == "" || q == "best":
+		return sorted[0], nil
+	case q == "worst":
+		return sorted[len(sorted)-1], nil
+	case strings.HasSuffix(q, "p"):
+		height, err := strconv.Atoi(strings.TrimSuffix(q, "p"))
+		if err != nil {
+			break
+		}
+		// Highest variant not exceeding the requested height
+		for _, v := range sorted {
+			if v.Height > 0 && v.Height <= height {
+				return v, nil
+			}
+		}
+		return streamVariant{}, fmt.Errorf("no variant at or below %dp", height)
+	default:
+		bw, err := strconv.ParseInt(q, 10, 64)
+		if err != nil {
+			break
+		}
+		best := -1
+		for i, v := range sorted {
+			if v.Bandwidth <= bw && (best < 0 || v.Bandwidth > sorted[best].Bandwidth) {
+				best = i
+			}
+		}
+		if best < 0 {
+			return streamVariant{}, fmt.Errorf("no variant at or below %d bit/s", bw)
+		}
+		return sorted[best], nil
+	}
+	return streamVariant{}, fmt.Errorf("invalid quality %q, expected best, worst, <height>p or <bandwidth>", quality)
+}
+
+// remuxFormats maps container extensions to their ffmpeg muxer
+var remuxFormats = map[string]string{
+	".mp4": "mp4",
+	".mkv": "matroska",
+	".mov": "mov",
+	".m4v": "ipod",
+}
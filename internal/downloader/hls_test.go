@@ -0,0 +1,129 @@
+package downloader
+
+import (
+	"bytes"
+	"net/url"
+	"reflect"
+	"testing"
+)
+
+func TestParseHLSMaster(t *testing.T) {
+	base, _ := url.Parse("https://cdn.example/live/master.m3u8")
+	pl, err := parseHLSPlaylist([]byte("\ufeff#EXTM3U\n"+
+		"#EXT-X-STREAM-INF:BANDWIDTH=800000,RESOLUTION=640x360,CODECS=\"avc1.4d401e,mp4a.40.2\"\n"+
+		"low/index.m3u8\n"+
+		"\n"+
+		"#EXT-X-STREAM-INF:BANDWIDTH=5000000,RESOLUTION=1920x1080\n"+
+		"https://other.example/hd.m3u8\n"), base)
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := []streamVariant{
+		{URI: "https://cdn.example/live/low/index.m3u8", Bandwidth: 800000, Height: 360},
+		{URI: "https://other.example/hd.m3u8", Bandwidth: 5000000, Height: 1080},
+	}
+	if !reflect.DeepEqual(pl.Variants, want) || len(pl.Segments) != 0 {
+		t.Errorf("variants %+v, %d segments", pl.Variants, len(pl.Segments))
+	}
+}
+
+func TestParseHLSMedia(t *testing.T) {
+	base, _ := url.Parse("https://cdn.example/v/index.m3u8")
+	pl, err := parseHLSPlaylist([]byte(`#EXTM3U
+#EXT-X-VERSION:7
+#EXT-X-MEDIA-SEQUENCE:10
+#EXT-X-MAP:URI="init.mp4",BYTERANGE="720@0"
+#EXTINF:4.0,
+seg10.m4s
+#EXT-X-KEY:METHOD=AES-128,URI="/keys/k1",IV=0x000102030405060708090A0B0C0D0E0F
+#EXT-X-BYTERANGE:1000@720
+#EXTINF:4.0,
+all.m4s
+#EXT-X-BYTERANGE:500
+#EXTINF:4.0,
+all.m4s
+#EXT-X-KEY:METHOD=NONE
+#EXTINF:4.0,
+seg13.m4s
+#EXT-X-ENDLIST
+`), base)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if pl.Init == nil || pl.Init.URI != "https://cdn.example/v/init.mp4" || pl.Init.Offset != 0 || pl.Init.Length != 720 {
+		t.Errorf("init %+v", pl.Init)
+	}
+	key := &hlsKey{Method: "AES-128", URI: "https://cdn.example/keys/k1", IV: []byte{0, 1, 2, 3, 4, 5, 6, 7, 8, 9, 10, 11, 12, 13, 14, 15}}
+	want := []hlsSegment{
+		{mediaSegment{URI: "https://cdn.example/v/seg10.m4s", Length: -1}, 10, nil},
+		{mediaSegment{URI: "https://cdn.example/v/all.m4s", Offset: 720, Length: 1000}, 11, key},
+		{mediaSegment{URI: "https://cdn.example/v/all.m4s", Offset: 1720, Length: 500}, 12, key},
+		{mediaSegment{URI: "https://cdn.example/v/seg13.m4s", Length: -1}, 13, nil},
+	}
+	if !reflect.DeepEqual(pl.Segments, want) {
+		t.Errorf("segments:\ngot  %+v\nwant %+v", pl.Segments, want)
+	}
+}
+
+func TestParseHLSRejects(t *testing.T) {
+	base, _ := url.Parse("https://cdn.example/index.m3u8")
+	tests := map[string]string{
+		"not a playlist":   "<html></html>",
+		"empty":            "",
+		"unknown method":   "#EXTM3U\n#EXT-X-KEY:METHOD=SAMPLE-AES,URI=\"k\"\nseg.ts\n",
+		"short IV":         "#EXTM3U\n#EXT-X-KEY:METHOD=AES-128,URI=\"k\",IV=0x0102\nseg.ts\n",
+		"line over 1 MiB":  "#EXTM3U\n" + string(bytes.Repeat([]byte("a"), 2<<20)) + "\n",
+		"invalid segment":  "#EXTM3U\nhttp://[::1\n",
+		"invalid map URI":  "#EXTM3U\n#EXT-X-MAP:URI=\"http://[::1\"\n",
+		"invalid key URI":  "#EXTM3U\n#EXT-X-KEY:METHOD=AES-128,URI=\"http://[::1\"\n",
+		"invalid IV digit": "#EXTM3U\n#EXT-X-KEY:METHOD=AES-128,URI=\"k\",IV=0xZZ0102030405060708090A0B0C0D0E\n",
+	}
+	for name, src := range tests {
+		if pl, err := parseHLSPlaylist([]byte(src), base); err == nil {
+			t.Errorf("%s: parsed %+v", name, pl)
+		}
+	}
+}
+
+func TestParseAttributes(t *testing.T) {
+	got := parseAttributes(`BANDWIDTH=1280000, codecs="avc1.4d401e,mp4a.40.2",RESOLUTION=1280x720,NAME="a=b",UNCLOSED="x`)
+	want := map[string]string{
+		"BANDWIDTH":  "1280000",
+		"CODECS":     "avc1.4d401e,mp4a.40.2",
+		"RESOLUTION": "1280x720",
+		"NAME":       "a=b",
+		"UNCLOSED":   "x",
+	}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("parseAttributes = %v", got)
+	}
+}
+
+func TestSelectVariant(t *testing.T) {
+	variants := []streamVariant{
+		{URI: "360", Bandwidth: 800000, Height: 360},
+		{URI: "1080", Bandwidth: 5000000, Height: 1080},
+		{URI: "720", Bandwidth: 2500000, Height: 720},
+		{URI: "720hi", Bandwidth: 3000000, Height: 720},
+	}
+	tests := []struct {
+		quality, want string
+	}{
+		{"", "1080"},
+		{"best", "1080"},
+		{"worst", "360"},
+		{"720p", "720hi"},
+		{"1000p", "720hi"},
+		{"1080p", "1080"},
+		{"2600000", "720"},
+		{"100p", ""},
+		{"1000", ""},
+		{"fast", ""},
+	}
+	for _, tt := range tests {
+		v, err := selectVariant(variants, tt.quality)
+		if (err == nil) != (tt.want != "") || v.URI != tt.want {
+			t.Errorf("selectVariant(%q) = %q, %v", tt.quality, v.URI, err)
+		}
+	}
+}
@@ -0,0 +1,56 @@
+package downloader
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"time"
+)
+
+// The host cache remembers what was learned about a server between runs
+type hostEntry struct {
+	Proto       string    `json:"proto,omitempty"` // "h2" or "http/1.1", see tuneProtocols
+	Checked     time.Time `json:"checked"`
+	Connections int       `json:"connections,omitempty"` // Converged --concurrent auto count
+}
+
+func hostCachePath() string {
+	dir, err := os.UserCacheDir()
+	if err != nil {
+		return ""
+	}
+	return filepath.Join(dir, "warp-dl", "hosts.json")
+}
+
+func loadHostCache() map[string]hostEntry {
+	cache := map[string]hostEntry{}
+	if path := hostCachePath(); path != "" {
+		if data, err := os.ReadFile(path); err == nil {
+			json.Unmarshal(data, &cache)
+		}
+	}
+	return cache
+}
+
+// saveHostCache is best effort, a lost entry only costs another measurement
+func saveHostCache(cache map[string]hostEntry) {
+	path := hostCachePath()
+	if path == "" {
+		return
+	}
+	data, err := json.MarshalIndent(cache, "", "  ")
+	if err != nil {
+		return
+	}
+	if err := os.MkdirAll(filepath.Dir(path), 0o755); err != nil {
+		return
+	}
+	tmp := fmt.Sprintf("%s.%d.tmp", path, os.Getpid())
+	if err := os.WriteFile(tmp, data, 0o644); err != nil {
+		return
+	}
+	if err := os.Rename(tmp, path); err != nil {
+		os.Remove(tmp)
+	}
+}
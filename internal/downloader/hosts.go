@@ -0,0 +1,121 @@
+package downloader
+
+import (
+	"context"
+	"io"
+	"net/http"
+	"sync"
+	"time"
+)
+
+// HostLimits caps the connections to each host and spaces out the requests
+// sent to it, across the downloads sharing it, so that a batch from one
+// server doesn't trip its rate limits. A connection is held from the request
+// until its body is read or closed.
+type HostLimits struct {
+	maxConns int
+	delay    time.Duration
+
+	mu    sync.Mutex
+	hosts map[string]*hostSlots
+}
+
+type hostSlots struct {
+	conns chan struct{} // nil without a cap
+	next  time.Time     // Earliest start of the next request
+}
+
+// NewHostLimits allows maxConns connections per host, 0 for any number, and
+// one request start per delay
+func NewHostLimits(maxConns int, delay time.Duration) *HostLimits {
+	return &HostLimits{maxConns: maxConns, delay: delay, hosts: map[string]*hostSlots{}}
+}
+
+// acquire waits for a connection to host and the turn of its request, and
+// returns the release of the connection
+func (l *HostLimits) acquire(ctx context.Context, host string) (func(), error) {
+	l.mu.Lock()
+	h := l.hosts[host]
+	if h == nil {
+		h = &hostSlots{}
+		if l.maxConns > 0 {
+			h.conns = make(chan struct{}, l.maxConns)
+		}
+		l.hosts[host] = h
+	}
+	l.mu.Unlock()
+
+	release := func() {}
+	if h.conns != nil {
+		select {
+		case h.conns <- struct{}{}:
+		case <-ctx.Done():
+			return nil, ctx.Err()
+		}
+		var once sync.Once
+		release = func() { once.Do(func() { <-h.conns }) }
+	}
+	if l.delay > 0 {
+		l.mu.Lock()
+		start := h.next
+		if now := time.Now(); start.Before(now) {
+			start = now
+		}
+		h.next = start.Add(l.delay)
+		l.mu.Unlock()
+
+		if wait := time.Until(start); wait > 0 {
+			t := time.NewTimer(wait)
+			select {
+			case <-t.C:
+			case <-ctx.Done():
+				t.Stop()
+				release()
+				return nil, ctx.Err()
+			}
+		}
+	}
+	return release, nil
+}
+
+// hostTransport holds every request to HostLimits
+type hostTransport struct {
+	base   http.RoundTripper
+	limits *HostLimits
+}
+
+func (t *hostTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	// Waiting for a turn isn't a stalled connection
+	resume := holdStall(req.Context())
+	release, err := t.limits.acquire(req.Context(), req.URL.Host)
+	resume()
+	if err != nil {
+		return nil, err
+	}
+	resp, err := t.base.RoundTrip(req)
+	if err != nil {
+		release()
+		return nil, err
+	}
+	resp.Body = &releaseBody{ReadCloser: resp.Body, release: release}
+	return resp, nil
+}
+
+// releaseBody gives the connection back at the end of the body
+type releaseBody struct {
+	io.ReadCloser
+	release func()
+}
+
+func (b *releaseBody) Read(p []byte) (int, error) {
+	n, err := b.ReadCloser.Read(p)
+	if err == io.EOF {
+		b.release()
+	}
+	return n, err
+}
+
+func (b *releaseBody) Close() error {
+	b.release()
+	return b.ReadCloser.Close()
+}
@@ -0,0 +1,215 @@
+package downloader
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"net/http"
+	"net/http/httptrace"
+	"net/url"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"golang.org/x/net/http2"
+)
+
+// HTTP2Mode controls how parts are spread over connections. Over HTTP/2
+// every part is a stream on one multiplexed connection, which saves the
+// handshakes and is friendlier to servers, but a single TCP connection can
+// lose to several on lossy or per-connection shaped links. Parts beyond the
+// server's MAX_CONCURRENT_STREAMS wait for a stream to free up rather than
+// opening a second connection.
+type HTTP2Mode int
+
+const (
+	HTTP2On    HTTP2Mode = iota // Prefer HTTP/2, falling back to HTTP/1.1 connections per host when measurably faster
+	HTTP2Force                  // Always multiplex over HTTP/2 when the server offers it
+	HTTP2Off                    // HTTP/1.1 only, one connection per part
+)
+
+// ParseHTTP2Mode parses the --http2 flag
+func ParseHTTP2Mode(s string) (HTTP2Mode, error) {
+	switch s {
+	case "on", "":
+		return HTTP2On, nil
+	case "force":
+		return HTTP2Force, nil
+	case "off":
+		return HTTP2Off, nil
+	}
+	return 0, fmt.Errorf("invalid HTTP/2 mode %q (want on, force or off)", s)
+}
+
+const (
+	// Files smaller than this aren't worth spending benchmark traffic on
+	protoBenchMinSize = 64 << 20
+
+	protoBenchStreams = 8
+	protoBenchSample  = 256 << 10 // Bytes per stream
+	protoBenchTimeout = 5 * time.Second
+
+	// HTTP/1.1 has to win by this factor, ties go to the single connection
+	protoBenchMargin = 1.2
+
+	protoCacheTTL = 7 * 24 * time.Hour
+)
+
+// protoTransport sends requests over HTTP/2 unless a host was switched to
+// HTTP/1.1, and remembers which hosts negotiated HTTP/2
+type protoTransport struct {
+	h1, h2 *http.Transport
+
+	mu      sync.Mutex
+	useH1   map[string]bool
+	spokeH2 map[string]bool
+}
+
+func newProtoTransport(h1 *http.Transport) *protoTransport {
+	h2 := h1.Clone()
+	h2.TLSNextProto = nil
+	if t2, err := http2.ConfigureTransports(h2); err == nil {
+		t2.StrictMaxConcurrentStreams = true
+	} else {
+		h2.ForceAttemptHTTP2 = true
+	}
+	return &protoTransport{h1: h1, h2: h2, useH1: map[string]bool{}, spokeH2: map[string]bool{}}
+}
+
+func (t *protoTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	host := req.URL.Host
+	t.mu.Lock()
+	h1 := t.useH1[host]
+	t.mu.Unlock()
+	if h1 {
+		return t.h1.RoundTrip(req)
+	}
+
+	req, sent := holdStallUntilSent(req)
+	resp, err := t.h2.RoundTrip(req)
+	sent()
+	if err == nil && resp.ProtoMajor == 2 {
+		t.mu.Lock()
+		t.spokeH2[host] = true
+		t.mu.Unlock()
+	}
+	return resp, err
+}
+
+// holdStallUntilSent pauses the request's stall watch until its headers are
+// written: a part queued for a free stream isn't stalled. Call the func
+// once RoundTrip returns.
+func holdStallUntilSent(req *http.Request) (*http.Request, func()) {
+	var once sync.Once
+	resume := holdStall(req.Context())
+	sent := func() { once.Do(resume) }
+	return req.WithContext(httptrace.WithClientTrace(req.Context(), &httptrace.ClientTrace{WroteHeaders: sent})), sent
+}
+
+func (t *protoTransport) setH1(host string, on bool) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	t.useH1[host] = on
+}
+
+// protocolFor names the protocol requests to host currently use
+func (t *protoTransport) protocolFor(host string) string {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	if t.spokeH2[host] && !t.useH1[host] {
+		return "h2"
+	}
+	return "http/1.1"
+}
+
+func (t *protoTransport) negotiatedH2(host string) bool {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	return t.spokeH2[host]
+}
+
+// tuneProtocols picks HTTP/2 multiplexing or HTTP/1.1 connections for every
+// source host that offered HTTP/2 during the probe. Earlier measurements are
+// reused from the host cache, new ones are only taken for large downloads.
+func (e *Engine) tuneProtocols(ctx context.Context) {
+	if e.proto == nil || e.Config.HTTP2 != HTTP2On || !e.IsResumable {
+		return
+	}
+
+	cache := loadHostCache()
+	dirty := false
+	for _, src := range e.sources() {
+		u, err := url.Parse(src)
+		if err != nil || !e.proto.negotiatedH2(u.Host) {
+			continue
+		}
+
+		if entry, ok := cache[u.Host]; ok && entry.Proto != "" && time.Since(entry.Checked) < protoCacheTTL {
+			e.proto.setH1(u.Host, entry.Proto == "http/1.1")
+			continue
+		}
+		if e.Stats.GetTotal() < protoBenchMinSize || e.Config.Concurrency < 2 {
+			continue
+		}
+
+		h2 := e.measureThroughput(ctx, src)
+		e.proto.setH1(u.Host, true)
+		h1 := e.measureThroughput(ctx, src)
+		if ctx.Err() != nil {
+			return
+		}
+
+		entry := cache[u.Host]
+		entry.Proto, entry.Checked = "h2", time.Now()
+		if h1 > h2*protoBenchMargin {
+			entry.Proto = "http/1.1"
+		} else {
+			e.proto.setH1(u.Host, false)
+			e.proto.h1.CloseIdleConnections()
+		}
+		cache[u.Host] = entry
+		dirty = true
+	}
+	if dirty {
+		saveHostCache(cache)
+	}
+}
+
+// measureThroughput fetches a few ranges spread over the file in parallel
+// and returns the aggregate rate in bytes per second
+func (e *Engine) measureThroughput(ctx context.Context, src string) float64 {
+	ctx, cancel := context.WithTimeout(ctx, protoBenchTimeout)
+	defer cancel()
+
+	streams := protoBenchStreams
+	if e.Config.Concurrency < streams {
+		streams = e.Config.Concurrency
+	}
+	stride := e.Stats.GetTotal() / int64(streams)
+
+	var received int64
+	var wg sync.WaitGroup
+	start := time.Now()
+	for i := 0; i < streams; i++ {
+		off := e.rangeStart + int64(i)*stride
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			req, err := http.NewRequestWithContext(ctx, "GET", src, nil)
+			if err != nil {
+				return
+			}
+			req.Header.Set("User-Agent", defaultUserAgent)
+			req.Header.Set("Range", fmt.Sprintf("bytes=%d-%d", off, off+protoBenchSample-1))
+			resp, err := e.Client.Do(req)
+			if err != nil {
+				return
+			}
+			defer resp.Body.Close()
+			n, _ := io.Copy(io.Discard, io.LimitReader(resp.Body, protoBenchSample))
+			atomic.AddInt64(&received, n)
+		}()
+	}
+	wg.Wait()
+	return float64(received) / time.Since(start).Seconds()
+}
@@ -0,0 +1,97 @@
+package downloader
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+)
+
+// httpFetcher is the built-in Fetcher for HTTP(S) and the object stores
+// rewritten to it. Conditional requests, compression, URL refreshes and
+// requests other than GET need the engine's state, so it works on it.
+type httpFetcher struct {
+	e *Engine
+}
+
+func (f *httpFetcher) Capabilities() Capabilities {
+	return Capabilities{Protocol: "http", Mirrors: true}
+}
+
+// Probe tries HEAD, then a one byte GET, on the URL and then the mirrors
+// until one answers. Other methods send the request itself once, its
+// response is read by the first part.
+func (f *httpFetcher) Probe(ctx context.Context) (int64, bool, error) {
+	e := f.e
+	if !e.plainGET() {
+		size, err := e.sendFirst(ctx)
+		return size, false, err
+	}
+	var (
+		size   int64
+		ranges bool
+		err    error
+	)
+	for i, src := range e.sources() {
+		size, ranges, err = e.probeURL(ctx, src)
+		if i == 0 && expired(err) && e.refreshURL(ctx, src) {
+			size, ranges, err = e.probeURL(ctx, e.currentURL())
+		}
+		if err == nil || errors.Is(err, errNotModified) {
+			break
+		}
+	}
+	return size, ranges, err
+}
+
+func (f *httpFetcher) FetchRange(ctx context.Context, src string, start, end int64) (io.ReadCloser, error) {
+	e := f.e
+	if e.first != nil {
+		rc, err := e.decode(e.first)
+		e.first = nil
+		return rc, err
+	}
+	req, err := e.newRequest(ctx, src)
+	if err != nil {
+		return nil, err
+	}
+
+	// Validators are per server, mirrors can't be checked against them
+	checked := e.isValidatorURL(src)
+	if e.IsResumable {
+		req.Header.Set("Range", fmt.Sprintf("bytes=%d-%d", start, end))
+		if checked {
+			e.validator.setConditional(req)
+		}
+	}
+
+	resp, err := e.clientFor(src).Do(req)
+	if err != nil {
+		return nil, err
+	}
+	if checked {
+		if err := e.validator.check(resp); err != nil {
+			resp.Body.Close()
+			return nil, err
+		}
+	}
+
+	if resp.StatusCode != http.StatusPartialContent && resp.StatusCode != http.StatusOK {
+		resp.Body.Close()
+		return nil, newStatusError(resp)
+	}
+	if err := checkPartResponse(resp, partOf(ctx), start, end); err != nil {
+		resp.Body.Close()
+		return nil, err
+	}
+	if resp.StatusCode == http.StatusOK && start > 0 {
+		// The whole file again, skip what's already on disk rather than
+		// writing it twice
+		if _, err := io.CopyN(io.Discard, resp.Body, start); err != nil {
+			resp.Body.Close()
+			return nil, err
+		}
+	}
+	return e.decode(resp)
+}
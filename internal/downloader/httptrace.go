@@ -0,0 +1,105 @@
+package downloader
+
+import (
+	"crypto/tls"
+	"log/slog"
+	"net/http"
+	"net/http/httptrace"
+	"sort"
+	"sync"
+	"time"
+)
+
+// secretHeaders are logged as "redacted" by Config.WireTrace
+var secretHeaders = map[string]bool{
+	"Authorization":        true,
+	"Proxy-Authorization":  true,
+	"Cookie":               true,
+	"Set-Cookie":           true,
+	"X-Amz-Security-Token": true,
+}
+
+// wireTrace times the phases of one request and collects the header lines
+// as they go out
+type wireTrace struct {
+	mu                         sync.Mutex
+	start                      time.Time
+	dnsStart, connStart, tlsAt time.Time
+	dns, connect, tls, ttfb    time.Duration
+	reused                     bool
+	sent                       []any
+}
+
+func newWireTrace() *wireTrace {
+	return &wireTrace{start: time.Now()}
+}
+
+func (w *wireTrace) clientTrace() *httptrace.ClientTrace {
+	lock := func(f func()) {
+		w.mu.Lock()
+		f()
+		w.mu.Unlock()
+	}
+	return &httptrace.ClientTrace{
+		DNSStart: func(httptrace.DNSStartInfo) { lock(func() { w.dnsStart = time.Now() }) },
+		DNSDone:  func(httptrace.DNSDoneInfo) { lock(func() { w.dns = time.Since(w.dnsStart) }) },
+		ConnectStart: func(string, string) {
+			lock(func() { w.connStart = time.Now() })
+		},
+		ConnectDone: func(string, string, error) {
+			lock(func() { w.connect = time.Since(w.connStart) })
+		},
+		TLSHandshakeStart: func() { lock(func() { w.tlsAt = time.Now() }) },
+		TLSHandshakeDone: func(tls.ConnectionState, error) {
+			lock(func() { w.tls = time.Since(w.tlsAt) })
+		},
+		GotConn: func(info httptrace.GotConnInfo) { lock(func() { w.reused = info.Reused }) },
+		GotFirstResponseByte: func() {
+			lock(func() { w.ttfb = time.Since(w.start) })
+		},
+		WroteHeaderField: func(key string, value []string) {
+			lock(func() { w.sent = append(w.sent, headerAttr(key, value)) })
+		},
+	}
+}
+
+// attrs are the timings and the request headers, for the response record
+func (w *wireTrace) attrs() []any {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	attrs := []any{"reused", w.reused}
+	for _, d := range []struct {
+		key string
+		d   time.Duration
+	}{{"dns", w.dns}, {"connect", w.connect}, {"tls", w.tls}, {"ttfb", w.ttfb}} {
+		if d.d > 0 {
+			attrs = append(attrs, d.key, d.d)
+		}
+	}
+	return append(attrs, slog.Group("sent", w.sent...))
+}
+
+// receivedAttr groups the response headers
+func receivedAttr(h http.Header) slog.Attr {
+	keys := make([]string, 0, len(h))
+	for key := range h {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	var attrs []any
+	for _, key := range keys {
+		attrs = append(attrs, headerAttr(key, h[key]))
+	}
+	return slog.Group("received", attrs...)
+}
+
+func headerAttr(key string, value []string) slog.Attr {
+	key = http.CanonicalHeaderKey(key)
+	if secretHeaders[key] {
+		return slog.String(key, "redacted")
+	}
+	if len(value) == 1 {
+		return slog.String(key, value[0])
+	}
+	return slog.Any(key, value)
+}
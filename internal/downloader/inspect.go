@@ -0,0 +1,117 @@
+package downloader
+
+import (
+	"net/http"
+	"net/url"
+	"sync/atomic"
+)
+
+// Inspector is implemented by tasks that can describe how they download,
+// for remote UIs that want more than the byte counters in Stats
+type Inspector interface {
+	Inspect() Inspection
+}
+
+// Inspection is a point in time view of a running download
+type Inspection struct {
+	Strategy    string       `json:"strategy"` // "single", "segments", "adaptive" or "pipelined"
+	Connections int          `json:"connections"`
+	Protocol    string       `json:"protocol,omitempty"` // "h2", "http/1.1" or a Fetcher's, e.g. "sftp"
+	Mirrors     []string     `json:"mirrors,omitempty"`
+	Peers       []string     `json:"peers,omitempty"` // LAN peers serving the file
+	Proxy       string       `json:"proxy,omitempty"`
+	Links       []LinkStatus `json:"links,omitempty"` // Local interfaces the connections are spread over
+	Parts       []PartStatus `json:"parts,omitempty"`
+}
+
+// PartState is the lifecycle of a segment
+type PartState int32
+
+const (
+	PartPending PartState = iota
+	PartActive
+	PartDone
+	PartFailed
+	PartThrottled // Waiting out the server's Retry-After
+)
+
+func (s PartState) String() string {
+	switch s {
+	case PartActive:
+		return "active"
+	case PartDone:
+		return "done"
+	case PartFailed:
+		return "failed"
+	case PartThrottled:
+		return "throttled"
+	}
+	return "pending"
+}
+
+func (s PartState) MarshalText() ([]byte, error) {
+	return []byte(s.String()), nil
+}
+
+type PartStatus struct {
+	ID         int       `json:"id"`
+	Start      int64     `json:"start"`
+	End        int64     `json:"end"`
+	Downloaded int64     `json:"downloaded"`
+	State      PartState `json:"state"`
+}
+
+func (p *Part) setState(s PartState) {
+	atomic.StoreInt32(&p.state, int32(s))
+}
+
+func (p *Part) getState() PartState {
+	return PartState(atomic.LoadInt32(&p.state))
+}
+
+// Inspect is safe to call while Start runs
+func (e *Engine) Inspect() Inspection {
+	in := Inspection{Strategy: "segments", Protocol: "http/1.1"}
+	for _, m := range e.Config.Mirrors {
+		in.Mirrors = append(in.Mirrors, redactURL(m))
+	}
+
+	u, err := url.Parse(e.Config.URL)
+	_, builtin := e.fetcher.(*httpFetcher)
+	switch {
+	case !builtin:
+		in.Protocol = e.fetcher.Capabilities().Protocol
+	case err == nil:
+		if e.proto != nil {
+			in.Protocol = e.proto.protocolFor(u.Host)
+		}
+		if proxy, err := proxyFunc(e.Config)(&http.Request{URL: u}); err == nil && proxy != nil {
+			in.Proxy = proxy.Redacted()
+		}
+	}
+
+	if e.links != nil {
+		in.Links = e.links.status()
+	}
+
+	parts, _ := e.layout.Load().([]*Part)
+	if parts != nil {
+		in.Peers = e.Config.Peers // Settled before the layout
+	}
+	switch {
+	case len(parts) == 1:
+		in.Strategy = "single"
+	case e.Config.Pipeline > 0:
+		in.Strategy = "pipelined"
+	case e.Config.AutoConcurrency:
+		in.Strategy = "adaptive"
+	}
+	for _, p := range parts {
+		st := PartStatus{ID: p.ID, Start: p.Start, End: p.End, Downloaded: atomic.LoadInt64(&p.Downloaded), State: p.getState()}
+		if st.State == PartActive {
+			in.Connections++
+		}
+		in.Parts = append(in.Parts, st)
+	}
+	return in
+}
@@ -0,0 +1,117 @@
+package downloader
+
+import (
+	"context"
+	"io"
+	"sync"
+	"time"
+)
+
+// limitChunk caps single reads under a Limiter, so that slow rates are
+// spread out instead of waiting long after every big read
+const limitChunk = 16 << 10
+
+// Limiter caps the combined speed of the downloads sharing it, in bytes per
+// second. The rate can change while they run, 0 lifts the cap.
+type Limiter struct {
+	mu     sync.Mutex
+	rate   int64
+	tokens float64 // Bytes that may be read now, negative when owed
+	last   time.Time
+	change chan struct{} // Closed by SetRate to wake the waiting readers
+}
+
+func NewLimiter(rate int64) *Limiter {
+	return &Limiter{rate: rate, last: time.Now(), change: make(chan struct{})}
+}
+
+// SetRate changes the cap for every download using the limiter
+func (l *Limiter) SetRate(rate int64) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	if rate == l.rate {
+		return
+	}
+	l.refill(time.Now())
+	l.rate = rate
+	if rate <= 0 {
+		l.tokens = 0
+	}
+	close(l.change)
+	l.change = make(chan struct{})
+}
+
+// Rate returns the current cap, 0 for none
+func (l *Limiter) Rate() int64 {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	return l.rate
+}
+
+// refill adds the bytes earned since the last call, at most a second's
+// worth. Called with the lock held.
+func (l *Limiter) refill(now time.Time) {
+	if l.rate > 0 {
+		l.tokens = min(l.tokens+now.Sub(l.last).Seconds()*float64(l.rate), float64(l.rate))
+	}
+	l.last = now
+}
+
+// take books n bytes that were read and waits until the rate covers them
+func (l *Limiter) take(ctx context.Context, n int) error {
+	l.mu.Lock()
+	if l.rate <= 0 {
+		l.mu.Unlock()
+		return nil
+	}
+	l.refill(time.Now())
+	l.tokens -= float64(n)
+	for l.rate > 0 && l.tokens < 0 {
+		wait := time.Duration(-l.tokens / float64(l.rate) * float64(time.Second))
+		change := l.change
+		l.mu.Unlock()
+
+		t := time.NewTimer(wait)
+		select {
+		case <-ctx.Done():
+			t.Stop()
+			return ctx.Err()
+		case <-change:
+		case <-t.C:
+		}
+		t.Stop()
+
+		l.mu.Lock()
+		l.refill(time.Now())
+	}
+	l.mu.Unlock()
+	return nil
+}
+
+// limitedReader reads a body no faster than its limiter allows
+type limitedReader struct {
+	io.ReadCloser
+	ctx context.Context
+	l   *Limiter
+}
+
+// limitBody wraps body in l, if there is one
+func limitBody(ctx context.Context, l *Limiter, body io.ReadCloser) io.ReadCloser {
+	if l == nil {
+		return body
+	}
+	return &limitedReader{ReadCloser: body, ctx: ctx, l: l}
+}
+
+func (r *limitedReader) Read(p []byte) (int, error) {
+	if len(p) > limitChunk && r.l.Rate() > 0 {
+		p = p[:limitChunk]
+	}
+	n, err := r.ReadCloser.Read(p)
+	if n > 0 {
+		if werr := r.l.take(r.ctx, n); werr != nil {
+			return n, werr
+		}
+	}
+	return n, err
+}
@@ -0,0 +1,237 @@
+package downloader
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// With several uplinks (Wi-Fi and LTE, two ISPs) every connection is bound
+// to one of the Config.Links, so the parts spread over all of them and the
+// rates add up. A request goes out on the healthy link with the fewest
+// requests in flight. A link whose connections fail is left out for a
+// while, twice as long each time, so a dropped LTE signal costs the parts
+// on it a retry elsewhere and not the download.
+
+const (
+	linkDownFor    = 5 * time.Second
+	linkMaxDownFor = 2 * time.Minute
+)
+
+// Link is a local network interface connections can be bound to
+type Link struct {
+	Name  string `json:"name"`
+	Addr  net.IP `json:"addr"` // Source address where the OS can't bind to the device itself
+	Index int    `json:"-"`
+}
+
+// ResolveLink finds a network interface by name or by one of its addresses
+func ResolveLink(spec string) (Link, error) {
+	ifaces, err := net.Interfaces()
+	if err != nil {
+		return Link{}, err
+	}
+	ip := net.ParseIP(spec)
+	for _, iface := range ifaces {
+		addrs, err := iface.Addrs()
+		if err != nil {
+			continue
+		}
+		var v4, v6, match net.IP
+		for _, a := range addrs {
+			n, ok := a.(*net.IPNet)
+			if !ok || n.IP.IsLinkLocalUnicast() {
+				continue
+			}
+			switch {
+			case ip != nil && n.IP.Equal(ip):
+				match = n.IP
+			case n.IP.To4() != nil && v4 == nil:
+				v4 = n.IP
+			case n.IP.To4() == nil && v6 == nil:
+				v6 = n.IP
+			}
+		}
+		if ip != nil && match == nil || ip == nil && iface.Name != spec {
+			continue
+		}
+		if iface.Flags&net.FlagUp == 0 {
+			return Link{}, fmt.Errorf("interface %s is down", iface.Name)
+		}
+		for _, a := range []net.IP{match, v4, v6} {
+			if a != nil {
+				return Link{Name: iface.Name, Addr: a, Index: iface.Index}, nil
+			}
+		}
+		return Link{}, fmt.Errorf("interface %s has no address", iface.Name)
+	}
+	if ip != nil {
+		return Link{}, fmt.Errorf("no interface has the address %s", spec)
+	}
+	return Link{}, fmt.Errorf("no interface named %s", spec)
+}
+
+// LinkStatus is a link's share of a download
+type LinkStatus struct {
+	Link
+	Up     bool  `json:"up"`
+	Active int   `json:"active"` // Requests in flight
+	Bytes  int64 `json:"bytes"`
+}
+
+type linkKey struct{}
+
+// linkDialer is the dialer for a connection of ctx's request: bound to its
+// link, if it has one
+func linkDialer(ctx context.Context) net.Dialer {
+	if l, ok := ctx.Value(linkKey{}).(*link); ok {
+		return l.dialer()
+	}
+	return net.Dialer{}
+}
+
+type link struct {
+	Link
+	rt    *http.Transport
+	bytes int64 // Atomic
+
+	// Guarded by the linkTransport's mu
+	active    int
+	failures  int
+	downUntil time.Time
+}
+
+// before orders links up ones first, then by requests in flight, and the
+// ones down by when they are back
+func (l *link) before(o *link, now time.Time) bool {
+	up, oUp := !now.Before(l.downUntil), !now.Before(o.downUntil)
+	switch {
+	case up != oUp:
+		return up
+	case up:
+		return l.active < o.active
+	}
+	return l.downUntil.Before(o.downUntil)
+}
+
+func (l *link) dialer() net.Dialer {
+	d := net.Dialer{Timeout: 30 * time.Second, KeepAlive: 30 * time.Second, Control: bindControl(l.Link)}
+	if !bindsDevice {
+		d.LocalAddr = &net.TCPAddr{IP: l.Addr}
+	}
+	return d
+}
+
+// linkTransport sends every request over one of several links, each with
+// its own connection pool
+type linkTransport struct {
+	mu    sync.Mutex
+	links []*link
+}
+
+func newLinkTransport(base *http.Transport, links []Link) *linkTransport {
+	t := &linkTransport{}
+	for _, li := range links {
+		l := &link{Link: li}
+		l.rt = base.Clone()
+		if dial := base.DialContext; dial != nil {
+			l.rt.DialContext = func(ctx context.Context, network, addr string) (net.Conn, error) {
+				return dial(context.WithValue(ctx, linkKey{}, l), network, addr)
+			}
+		} else {
+			d := l.dialer()
+			l.rt.DialContext = d.DialContext
+		}
+		t.links = append(t.links, l)
+	}
+	return t
+}
+
+func (t *linkTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	l := t.pick()
+	resp, err := l.rt.RoundTrip(req)
+	if err != nil {
+		t.release(l, req.Context().Err() == nil)
+		return nil, err
+	}
+	t.mu.Lock()
+	l.failures = 0
+	t.mu.Unlock()
+	resp.Body = &linkBody{ReadCloser: resp.Body, t: t, l: l, ctx: req.Context()}
+	return resp, nil
+}
+
+// pick takes the healthy link with the fewest requests in flight, or when
+// every link is down the one back soonest
+func (t *linkTransport) pick() *link {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	now := time.Now()
+	var best *link
+	for _, l := range t.links {
+		if best == nil || l.before(best, now) {
+			best = l
+		}
+	}
+	best.active++
+	return best
+}
+
+// release ends a request on l, failed takes the link out for a while
+func (t *linkTransport) release(l *link, failed bool) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	l.active--
+	if !failed {
+		return
+	}
+	l.failures++
+	down := linkDownFor << (l.failures - 1)
+	if down > linkMaxDownFor || down <= 0 {
+		down = linkMaxDownFor
+	}
+	l.downUntil = time.Now().Add(down)
+	l.rt.CloseIdleConnections()
+}
+
+func (t *linkTransport) status() []LinkStatus {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	now := time.Now()
+	out := make([]LinkStatus, len(t.links))
+	for i, l := range t.links {
+		out[i] = LinkStatus{Link: l.Link, Up: !now.Before(l.downUntil), Active: l.active, Bytes: atomic.LoadInt64(&l.bytes)}
+	}
+	return out
+}
+
+// linkBody counts a response's bytes for its link and releases the link
+// when closed, a read error counts as the link's failure
+type linkBody struct {
+	io.ReadCloser
+	t      *linkTransport
+	l      *link
+	ctx    context.Context
+	failed bool
+	once   sync.Once
+}
+
+func (b *linkBody) Read(p []byte) (int, error) {
+	n, err := b.ReadCloser.Read(p)
+	atomic.AddInt64(&b.l.bytes, int64(n))
+	if err != nil && !errors.Is(err, io.EOF) && b.ctx.Err() == nil {
+		b.failed = true
+	}
+	return n, err
+}
+
+func (b *linkBody) Close() error {
+	b.once.Do(func() { b.t.release(b.l, b.failed) })
+	return b.ReadCloser.Close()
+}
@@ -0,0 +1,31 @@
+package downloader
+
+import (
+	"fmt"
+	"syscall"
+
+	"golang.org/x/sys/unix"
+)
+
+// IP_BOUND_IF scopes the socket to the interface, for both address families
+const bindsDevice = true
+
+func bindControl(l Link) func(network, address string, c syscall.RawConn) error {
+	return func(network, address string, c syscall.RawConn) error {
+		var err error
+		cerr := c.Control(func(fd uintptr) {
+			if network == "tcp6" || network == "udp6" {
+				err = unix.SetsockoptInt(int(fd), unix.IPPROTO_IPV6, unix.IPV6_BOUND_IF, l.Index)
+			} else {
+				err = unix.SetsockoptInt(int(fd), unix.IPPROTO_IP, unix.IP_BOUND_IF, l.Index)
+			}
+		})
+		if cerr != nil {
+			return cerr
+		}
+		if err != nil {
+			return fmt.Errorf("failed to bind to %s: %w", l.Name, err)
+		}
+		return nil
+	}
+}
@@ -0,0 +1,25 @@
+package downloader
+
+import (
+	"fmt"
+	"syscall"
+
+	"golang.org/x/sys/unix"
+)
+
+// SO_BINDTODEVICE picks the interface for both address families and
+// bypasses the routing table, so a second default route isn't needed
+const bindsDevice = true
+
+func bindControl(l Link) func(network, address string, c syscall.RawConn) error {
+	return func(network, address string, c syscall.RawConn) error {
+		var err error
+		if cerr := c.Control(func(fd uintptr) { err = unix.BindToDevice(int(fd), l.Name) }); cerr != nil {
+			return cerr
+		}
+		if err != nil {
+			return fmt.Errorf("failed to bind to %s: %w", l.Name, err)
+		}
+		return nil
+	}
+}
@@ -0,0 +1,13 @@
+//go:build !linux && !darwin
+
+package downloader
+
+import "syscall"
+
+// Elsewhere connections only get the link's source address, which the
+// routing table has to send out on that interface
+const bindsDevice = false
+
+func bindControl(l Link) func(network, address string, c syscall.RawConn) error {
+	return nil
+}
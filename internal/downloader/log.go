@@ -0,0 +1,101 @@
+package downloader
+
+import (
+	"context"
+	"fmt"
+	"log/slog"
+	"net/http"
+	"net/http/httptrace"
+	"time"
+)
+
+// Config.Logger gets the engine's decisions at info level, failures worth
+// a retry at warn level and every request and response at debug level.
+
+// discardHandler drops every record, the logger of a Config without one
+type discardHandler struct{}
+
+func (discardHandler) Enabled(context.Context, slog.Level) bool  { return false }
+func (discardHandler) Handle(context.Context, slog.Record) error { return nil }
+func (h discardHandler) WithAttrs([]slog.Attr) slog.Handler      { return h }
+func (h discardHandler) WithGroup(string) slog.Handler           { return h }
+
+// logger returns Config.Logger, or one that drops everything
+func (c Config) logger() *slog.Logger {
+	if c.Logger != nil {
+		return c.Logger
+	}
+	return slog.New(discardHandler{})
+}
+
+// warn reports a failure that gets retried to Config.Trace and the log
+func (e *Engine) warn(format string, args ...any) {
+	msg := fmt.Sprintf(format, args...)
+	if e.Config.Trace != nil {
+		e.Config.Trace(msg)
+	}
+	e.log.Warn(msg, "url", redactURL(e.Config.URL))
+}
+
+type partKey struct{}
+
+// withPart tags the requests made with ctx as those of a part
+func withPart(ctx context.Context, part *Part) context.Context {
+	return context.WithValue(ctx, partKey{}, part)
+}
+
+// partOf returns the part ctx is tagged with, nil for none
+func partOf(ctx context.Context) *Part {
+	p, _ := ctx.Value(partKey{}).(*Part)
+	return p
+}
+
+// logTransport logs the requests of a download and their responses, with
+// Config.WireTrace also their timings and headers
+type logTransport struct {
+	base http.RoundTripper
+	log  *slog.Logger
+	wire bool
+}
+
+func (t *logTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	ctx := req.Context()
+	if !t.log.Enabled(ctx, slog.LevelDebug) {
+		return t.base.RoundTrip(req)
+	}
+	attrs := []any{"method", req.Method, "url", req.URL.Redacted()}
+	if p := partOf(ctx); p != nil {
+		attrs = append(attrs, "part", p.ID)
+	}
+	if r := req.Header.Get("Range"); r != "" {
+		attrs = append(attrs, "range", r)
+	}
+	t.log.DebugContext(ctx, "request", attrs...)
+
+	var wire *wireTrace
+	if t.wire {
+		wire = newWireTrace()
+		req = req.WithContext(httptrace.WithClientTrace(ctx, wire.clientTrace()))
+	}
+	start := time.Now()
+	resp, err := t.base.RoundTrip(req)
+	if wire != nil {
+		attrs = append(attrs, wire.attrs()...)
+	}
+	if err != nil {
+		t.log.DebugContext(ctx, "request failed", append(attrs, "err", err, "elapsed", time.Since(start))...)
+		return nil, err
+	}
+	attrs = append(attrs, "status", resp.StatusCode, "proto", resp.Proto, "length", resp.ContentLength)
+	for _, h := range [][2]string{{"Content-Range", "content_range"}, {"Content-Encoding", "encoding"}, {"Accept-Ranges", "accept_ranges"}, {"ETag", "etag"}} {
+		if v := resp.Header.Get(h[0]); v != "" {
+			attrs = append(attrs, h[1], v)
+		}
+	}
+	attrs = append(attrs, "elapsed", time.Since(start))
+	if wire != nil {
+		attrs = append(attrs, receivedAttr(resp.Header))
+	}
+	t.log.DebugContext(ctx, "response", attrs...)
+	return resp, nil
+}
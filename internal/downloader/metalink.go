@@ -0,0 +1,176 @@
+package downloader
+
+import (
+	"context"
+	"encoding/xml"
+	"fmt"
+	"io"
+	"math"
+	"net/http"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+)
+
+// MetalinkFile describes a single file entry of a metalink document
+type MetalinkFile struct {
+	Name     string
+	Size     int64
+	URLs     []string // Ordered by preference, best first
+	Checksum *Checksum
+}
+
+// Metalink documents come in two flavours: RFC 5854 (.meta4) puts hashes and
+// urls directly under <file>, the older 3.0 format (.metalink) nests them.
+type metalinkXML struct {
+	Files   []metalinkFileXML `xml:"file"`
+	V3Files []metalinkFileXML `xml:"files>file"`
+}
+
+type metalinkFileXML struct {
+	Name     string            `xml:"name,attr"`
+	Size     int64             `xml:"size"`
+	Hashes   []metalinkHashXML `xml:"hash"`
+	V3Hashes []metalinkHashXML `xml:"verification>hash"`
+	URLs     []metalinkURLXML  `xml:"url"`
+	V3URLs   []metalinkURLXML  `xml:"resources>url"`
+}
+
+type metalinkHashXML struct {
+	Type  string `xml:"type,attr"`
+	Value string `xml:",chardata"`
+}
+
+type metalinkURLXML struct {
+	Priority   int    `xml:"priority,attr"`   // RFC 5854: lower is better
+	Preference int    `xml:"preference,attr"` // 3.0: higher is better
+	Value      string `xml:",chardata"`
+}
+
+// Strongest hash first
+var metalinkHashPreference = []string{"sha512", "sha256", "sha1", "md5"}
+
+// IsMetalink reports whether src looks like a metalink document
+func IsMetalink(src string) bool {
+	if i := strings.IndexAny(src, "?#"); i >= 0 {
+		src = src[:i]
+	}
+	ext := strings.ToLower(filepath.Ext(src))
+	return ext == ".metalink" || ext == ".meta4"
+}
+
+// LoadMetalink reads a metalink document from a local path or an HTTP(S) URL
+func LoadMetalink(ctx context.Context, client *http.Client, src string) ([]MetalinkFile, error) {
+	if !strings.HasPrefix(src, "http://") && !strings.HasPrefix(src, "https://") {
+		f, err := os.Open(src)
+		if err != nil {
+			return nil, err
+		}
+		defer f.Close()
+		return ParseMetalink(f)
+	}
+
+	req, err := http.NewRequestWithContext(ctx, "GET", src, nil)
+	if err != nil {
+		return nil, err
+	}
+	req.Header.Set("User-Agent", defaultUserAgent)
+	req.Header.Set("Accept", "application/metalink4+xml, application/metalink+xml;q=0.9, */*;q=0.1")
+
+	resp, err := client.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("metalink fetch returned status: %s", resp.Status)
+	}
+	return ParseMetalink(resp.Body)
+}
+
+// ParseMetalink parses a metalink 3.0 or RFC 5854 document
+func ParseMetalink(r io.Reader) ([]MetalinkFile, error) {
+	var doc metalinkXML
+	if err := xml.NewDecoder(r).Decode(&doc); err != nil {
+		return nil, fmt.Errorf("invalid metalink: %w", err)
+	}
+
+	var files []MetalinkFile
+	for _, fx := range append(doc.Files, doc.V3Files...) {
+		name, err := sanitizeMetalinkName(fx.Name)
+		if err != nil {
+			return nil, err
+		}
+
+		file := MetalinkFile{Name: name, Size: fx.Size}
+
+		urls := fx.URLs
+		if len(fx.V3URLs) > 0 {
+			// Normalise 3.0 preference (0-100, higher first) to priority order
+			urls = fx.V3URLs
+			for i := range urls {
+				urls[i].Priority = 101 - urls[i].Preference
+			}
+		} else {
+			// RFC 5854 priorities start at 1, without one a mirror comes last
+			for i := range urls {
+				if urls[i].Priority <= 0 {
+					urls[i].Priority = math.MaxInt
+				}
+			}
+		}
+		sort.SliceStable(urls, func(i, j int) bool {
+			return urls[i].Priority < urls[j].Priority
+		})
+		for _, u := range urls {
+			if v := strings.TrimSpace(u.Value); v != "" {
+				file.URLs = append(file.URLs, v)
+			}
+		}
+		if len(file.URLs) == 0 {
+			return nil, fmt.Errorf("metalink file %q has no urls", name)
+		}
+
+		hashes := make(map[string]string)
+		for _, h := range append(fx.Hashes, fx.V3Hashes...) {
+			hashes[normalizeHashAlgo(h.Type)] = strings.ToLower(strings.TrimSpace(h.Value))
+		}
+		for _, algo := range metalinkHashPreference {
+			if v, ok := hashes[algo]; ok && v != "" {
+				file.Checksum = &Checksum{Algo: algo, Value: v}
+				break
+			}
+		}
+
+		files = append(files, file)
+	}
+
+	if len(files) == 0 {
+		return nil, fmt.Errorf("metalink contains no files")
+	}
+	return files, nil
+}
+
+// Config derives a download configuration for this file from base
+func (f MetalinkFile) Config(base Config) Config {
+	cfg := base
+	cfg.URL = f.URLs[0]
+	cfg.Mirrors = f.URLs[1:]
+	cfg.Checksum = f.Checksum
+	if cfg.OutputName == "" {
+		cfg.OutputName = f.Name
+	}
+	return cfg
+}
+
+// The name attribute may contain a relative path but must not escape the
+// download directory (RFC 5854 section 4.1.2.1)
+func sanitizeMetalinkName(name string) (string, error) {
+	clean := filepath.Clean(filepath.FromSlash(name))
+	if name == "" || filepath.IsAbs(clean) || clean == ".." || strings.HasPrefix(clean, ".."+string(filepath.Separator)) {
+		return "", fmt.Errorf("unsafe metalink file name %q", name)
+	}
+	return clean, nil
+}
@@ -0,0 +1,109 @@
+package downloader
+
+import (
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestParseMetalink(t *testing.T) {
+	tests := []struct {
+		name string
+		doc  string
+		want []MetalinkFile
+	}{
+		{
+			name: "RFC 5854",
+			doc: `<?xml version="1.0" encoding="UTF-8"?>
+<metalink xmlns="urn:ietf:params:xml:ns:metalink">
+  <file name="dir/example.iso">
+    <size>1024</size>
+    <hash type="md5">0123</hash>
+    <hash type="sha-256">ABCD</hash>
+    <url>http://unranked.example/example.iso</url>
+    <url priority="2">http://second.example/example.iso</url>
+    <url priority="1">http://first.example/example.iso</url>
+  </file>
+</metalink>`,
+			want: []MetalinkFile{{
+				Name: "dir/example.iso",
+				Size: 1024,
+				URLs: []string{
+					"http://first.example/example.iso",
+					"http://second.example/example.iso",
+					"http://unranked.example/example.iso",
+				},
+				Checksum: &Checksum{Algo: "sha256", Value: "abcd"},
+			}},
+		},
+		{
+			name: "metalink 3.0",
+			doc: `<?xml version="1.0" encoding="UTF-8"?>
+<metalink version="3.0" xmlns="http://www.metalinker.org/">
+  <files>
+    <file name="a.bin">
+      <size>10</size>
+      <verification><hash type="sha1">FFFF</hash></verification>
+      <resources>
+        <url type="http">http://none.example/a.bin</url>
+        <url type="http" preference="10">http://low.example/a.bin</url>
+        <url type="http" preference="100">http://high.example/a.bin</url>
+      </resources>
+    </file>
+    <file name="b.bin">
+      <resources><url type="http"> http://b.example/b.bin </url></resources>
+    </file>
+  </files>
+</metalink>`,
+			want: []MetalinkFile{
+				{
+					Name:     "a.bin",
+					Size:     10,
+					URLs:     []string{"http://high.example/a.bin", "http://low.example/a.bin", "http://none.example/a.bin"},
+					Checksum: &Checksum{Algo: "sha1", Value: "ffff"},
+				},
+				{Name: "b.bin", URLs: []string{"http://b.example/b.bin"}},
+			},
+		},
+	}
+	for _, tt := range tests {
+		got, err := ParseMetalink(strings.NewReader(tt.doc))
+		if err != nil {
+			t.Errorf("%s: %v", tt.name, err)
+			continue
+		}
+		if !reflect.DeepEqual(got, tt.want) {
+			t.Errorf("%s:\ngot  %+v\nwant %+v", tt.name, got, tt.want)
+		}
+	}
+}
+
+func TestParseMetalinkRejects(t *testing.T) {
+	tests := map[string]string{
+		"not XML":       "not a metalink",
+		"no files":      `<metalink xmlns="urn:ietf:params:xml:ns:metalink"></metalink>`,
+		"no urls":       `<metalink><file name="a"><size>1</size></file></metalink>`,
+		"absolute name": `<metalink><file name="/etc/passwd"><url>http://x/a</url></file></metalink>`,
+		"escaping name": `<metalink><file name="../a"><url>http://x/a</url></file></metalink>`,
+		"no name":       `<metalink><file><url>http://x/a</url></file></metalink>`,
+	}
+	for name, doc := range tests {
+		if files, err := ParseMetalink(strings.NewReader(doc)); err == nil {
+			t.Errorf("%s: parsed %+v", name, files)
+		}
+	}
+}
+
+func TestIsMetalink(t *testing.T) {
+	for src, want := range map[string]bool{
+		"http://example.com/file.meta4":          true,
+		"http://example.com/file.METALINK?x=1":   true,
+		"/tmp/list.metalink":                     true,
+		"http://example.com/file.meta4.iso":      false,
+		"http://example.com/get?name=file.meta4": false,
+	} {
+		if got := IsMetalink(src); got != want {
+			t.Errorf("IsMetalink(%q) = %v", src, got)
+		}
+	}
+}
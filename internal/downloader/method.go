@@ -0,0 +1,73 @@
+package downloader
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"io"
+	"net/http"
+)
+
+// Export endpoints and report generators hand out files in answer to a
+// POST. Such a request isn't repeatable per range, so the response to the
+// first request is the download: one connection, no resume.
+
+// plainGET reports whether the download uses the default method
+func (e *Engine) plainGET() bool {
+	return e.Config.Method == "" || e.Config.Method == http.MethodGet
+}
+
+// newRequest builds a request for the download itself, with the configured
+// method and body
+func (e *Engine) newRequest(ctx context.Context, url string) (*http.Request, error) {
+	method := e.Config.Method
+	if method == "" {
+		method = http.MethodGet
+	}
+	var body io.Reader
+	if e.Config.Body != nil {
+		body = bytes.NewReader(e.Config.Body)
+	}
+	req, err := http.NewRequestWithContext(ctx, method, url, body)
+	if err != nil {
+		return nil, err
+	}
+	if body != nil {
+		// Like curl --data
+		req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	}
+	req.Header.Set("User-Agent", defaultUserAgent)
+	if !e.IsResumable {
+		// No ranges, so a compressed answer is usable
+		e.offerEncodings(req)
+	}
+	return req, nil
+}
+
+// sendFirst makes the first request in place of a probe and keeps its
+// response for the download. It returns the length, or -1 if unknown.
+func (e *Engine) sendFirst(ctx context.Context) (int64, error) {
+	if e.Config.Follow {
+		return 0, fmt.Errorf("--follow polls with ranged GET requests, it can't be used with %s", e.Config.Method)
+	}
+	req, err := e.newRequest(ctx, e.Config.URL)
+	if err != nil {
+		return 0, err
+	}
+	resp, err := e.Client.Do(req)
+	if err != nil {
+		return 0, err
+	}
+	if resp.StatusCode != http.StatusOK {
+		resp.Body.Close()
+		return 0, newStatusError(resp)
+	}
+	e.remoteName = responseFileName(resp)
+	e.FinalURL = resp.Request.URL.Redacted()
+	e.first = resp
+	if e.Config.Compressed && encoded(resp) {
+		e.encoding = resp.Header.Get("Content-Encoding")
+		return -1, nil
+	}
+	return resp.ContentLength, nil
+}
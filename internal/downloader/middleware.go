@@ -0,0 +1,47 @@
+package downloader
+
+import "net/http"
+
+// Middleware wraps the transport of a download's requests, for embedders
+// adding signing such as AWS SigV4, tracing or a cache
+type Middleware func(next http.RoundTripper) http.RoundTripper
+
+// UseRoundTripper adds a middleware around every request of the download,
+// from the probe to the last part. Call it before Start. Each one wraps
+// those added before it, so the last one added sees requests first.
+func (e *Engine) UseRoundTripper(mw Middleware) {
+	use(e.Client, mw)
+}
+
+// UseRoundTripper adds a middleware around the playlist, key and segment
+// requests, see Engine.UseRoundTripper
+func (d *HLSDownloader) UseRoundTripper(mw Middleware) {
+	use(d.Client, mw)
+}
+
+// UseRoundTripper adds a middleware around the manifest and segment
+// requests, see Engine.UseRoundTripper
+func (d *DASHDownloader) UseRoundTripper(mw Middleware) {
+	use(d.Client, mw)
+}
+
+// UseRoundTripper adds a middleware around the block requests, see
+// Engine.UseRoundTripper
+func (z *ZsyncDownloader) UseRoundTripper(mw Middleware) {
+	use(z.Client, mw)
+}
+
+// UseRoundTripper adds a middleware around the manifest requests and those
+// of every blob, see Engine.UseRoundTripper
+func (d *OCIDownloader) UseRoundTripper(mw Middleware) {
+	use(d.Client, mw)
+	d.middleware = append(d.middleware, mw)
+}
+
+func use(client *http.Client, mw Middleware) {
+	base := client.Transport
+	if base == nil {
+		base = http.DefaultTransport
+	}
+	client.Transport = mw(base)
+}
@@ -1,44 +1,187 @@
 package downloader
 
 import (
+	"context"
+	"io"
+	"log/slog"
 	"net/http"
 	"os"
+	"sync"
 	"sync/atomic"
+	"time"
 )
 
 // Config holds the configuration for the download
 type Config struct {
-	URL         string
-	Concurrency int
-	OutputName  string
-	UseDoH      bool
+	URL             string
+	Mirrors         []string // Additional URLs serving the same content
+	Peers           []string // LAN peers holding the file, tried before the URL and mirrors
+	Concurrency     int
+	AutoConcurrency bool // Tune the connection count while downloading, Concurrency still sizes HLS/DASH/torrent workers
+	OutputName      string
+	Dir             string // Directory for the default output name, ignored when OutputName is set
+	NameTemplate    string // Default output name built from NameTokens, may contain directories
+	DNS             DNSMode
+	DoHServers      []string   // DoH JSON endpoints tried in order, Cloudflare's when empty
+	Checksum        *Checksum  // Expected digest of the final file, verified after merge
+	Signature       *Signature // Detached OpenPGP signature the final file must match, verified after merge
+	Range           *ByteRange // Only fetch this window of the remote file
+	Quality         string     // Stream variant selection for playlists
+	Track           string     // DASH adaptation set: video or audio
+	Platform        string     // OCI image variant, os/arch[/variant]; linux and this machine's architecture when empty
+	SSHKey          string     // Private key for sftp:// URLs
+	MaxInFlight     int64      // Bytes read but not yet written to disk, 0 for the default
+	BufferSize      int64      // Bytes read from a connection per disk write, 0 for the default
+	MinSplitSize    int64      // Smallest part worth a connection, smaller files are fetched in one request. 0 for the default
+	MOTW            MOTWMode   // Windows Zone.Identifier marking of the output
+	HTTP2           HTTP2Mode  // Multiplex parts over one HTTP/2 connection
+	SplitOutput     int64      // Volume size for the output, or SplitAuto/SplitNever
+	Proxy           string     // Proxy URL for every request, over PAC and the environment. ProxyDirect for none
+	PAC             string     // Proxy auto-config script URL or path
+	WPAD            bool       // Discover the PAC script on the network when PAC is empty
+	Fsync           bool       // Flush the output to disk before it is renamed into place
+	DropCache       bool       // Keep written data out of the page cache, for huge downloads
+	Paranoid        bool       // Cross-check the bytes at part boundaries with extra range requests
+	Method          string     // Request method, default GET. Anything else downloads over one connection
+	Body            []byte     // Request body, sent form encoded
+	Stream          io.Writer  // Write the file here in order instead of saving it, OutputName is ignored
+
+	// OnDiskFull is called when the output's disk fills up mid-download, with
+	// writes paused. Returning nil retries them, an error stops the download.
+	// Without it the download stops right away, resumable.
+	OnDiskFull func(ctx context.Context, dir string, err error) error
+	// OnDuplicate is called when the output or one of Duplicates already
+	// holds the remote file, by size and its first and last bytes. It
+	// returns true to download it again; otherwise the download is skipped
+	// with that file as its output. nil downloads without checking.
+	OnDuplicate func(ctx context.Context, path string) bool
+	Duplicates  []string // Local copies to check besides the output, e.g. earlier downloads of the URL
+	// RefreshURL is called when the URL answers 401, 403 or 410 mid-download,
+	// like signed URLs that expired, and returns a fresh URL for the same
+	// file. The parts continue their ranges from it. nil fails the parts.
+	RefreshURL func(ctx context.Context, expired string) (string, error)
+	// OnDNSFallback is called when DNSAuto falls back to the system resolver
+	// because the DoH lookup of host failed
+	OnDNSFallback func(host string, err error)
+	// FindPeers looks up Peers by checksum once the download starts
+	FindPeers func(ctx context.Context, sum *Checksum) []string
+	// WrapTransport wraps the transport of plain HTTP downloads, outermost,
+	// e.g. to record the requests
+	WrapTransport func(http.RoundTripper) http.RoundTripper
+	// Trace is told the engine's decisions as they are made: how the file
+	// was split, retries, reconnects
+	Trace func(msg string)
+	// Logger gets the decisions too, retried failures as warnings, and at
+	// debug level every request and response. nil logs nothing
+	Logger *slog.Logger
+	// WireTrace adds DNS, connect, TLS and first byte timings and the
+	// headers, credentials redacted, to the request log
+	WireTrace bool
+	// Headers for the requests to a domain and its subdomains, instead of
+	// Headers; the most specific domain wins
+	HostHeaders map[string]http.Header
+	// ConnectTo dials these hosts at another address, an IP or IP:port,
+	// keeping the Host header and the TLS server name
+	ConnectTo map[string]string
+	// SNI is the TLS server name presented instead of the URL's host
+	SNI string
+	// TLSFingerprint makes direct HTTPS connections send this browser's
+	// ClientHello, one of TLSFingerprints; "" for Go's
+	TLSFingerprint string
+
+	Retry        *RetryPolicy   // nil for DefaultRetry
+	StallTimeout time.Duration  // Reconnect a part that receives nothing for this long, 0 to wait forever
+	Headers      http.Header    // Sent with every request, e.g. Accept-Language
+	Cookies      []*http.Cookie // Sent to the URL's site, e.g. a browser's session
+	OnConflict   ConflictMode   // What to do when the output file exists
+	Allocation   FileAllocation // How the files get their space before the data arrives
+	Zsync        *ZsyncControl  // Update the output from this control file, fetching only the blocks that changed
+	Pipeline     int            // Work through many small parts over this many kept-alive connections, instead of a connection per part
+	Links        []Link         // Spread the connections over these local interfaces, for link aggregation
+	Tor          bool           // Connect only to Proxy, the Tor SOCKS port, which also resolves the names
+	PinSHA256    []string       // Base64 SHA-256 public key pins, see ParsePins; TLS connections fail unless the leaf has one, or a CA of them in the chain issued it
+	VerifyTLS    bool           // Check certificates against the system roots, which downloads otherwise skip
+	AWSSigV4     string         // Sign every request with AWS SigV4 for this region/service, see ParseAWSSigV4
+	NewerOnly    bool           // Skip the download unless the remote file is newer than the output
+	DropPartial  bool           // Delete the part files and resume state of a download that stops unfinished
+	Compressed   bool           // Accept gzip, deflate and zstd responses and decode them, over one connection
+	Limiter      *Limiter       // Caps the speed, shared by the downloads it applies to. nil for full speed
+	ThrottleHost bool           // A 429/503 Retry-After pauses every part to the host, not only the one it answered
+	Hosts        *HostLimits    // Caps connections and spaces out requests per host, shared like Limiter. nil for none
+
+	Follow         bool          // Keep polling for appended data after completion
+	FollowInterval time.Duration // Poll period in follow mode
+}
+
+// Task is a download driven by the CLI and UI
+type Task interface {
+	Start(ctx context.Context) error
+	Progress() *Stats
 }
 
 // Stats holds real-time statistics
 type Stats struct {
-	TotalBytes      int64
+	TotalBytes      int64 // Atomic, may be an estimate until the download completes
 	DownloadedBytes int64 // Atomic
-	Speed           float64
-	Progress        float64
+	WireBytes       int64 // Atomic, bytes received before decoding, 0 unless the response was compressed
+
+	rate rateTracker // See Rates
 }
 
 // Part represents a segment of the file to download
 type Part struct {
-	ID        int
-	Start     int64
-	End       int64
-	TempPath  string
+	// First so atomic access is 64-bit aligned on 32-bit platforms
 	Downloaded int64
+
+	ID       int
+	Start    int64
+	End      int64
+	TempPath string
+
+	crc   uint32 // Running CRC-32 of the bytes written to TempPath
+	state int32  // PartState, atomic
 }
 
 // Engine handles the download process
 type Engine struct {
-	Config     Config
-	Stats      *Stats
-	Client     *http.Client
-	Parts      []*Part
-	PartFiles  []*os.File
+	Config      Config
+	Stats       *Stats
+	Client      *http.Client
+	Parts       []*Part
+	PartFiles   []*os.File
 	IsResumable bool
+	Volumes     []string // Files the output was split into, see SplitOutput
+	FinalURL    string   // The URL after redirects, without credentials
+	Skipped     string   // Why the existing output was kept, see OnConflict and NewerOnly
+	Signer      string   // Who made the Config.Signature the output was verified against
+
+	rangeStart   int64     // Remote offset of byte 0 of the output
+	remoteName   string    // File name from the probe response, see defaultName
+	validator    validator // ETag/Last-Modified seen by the probe of validatorURL
+	validatorURL string
+	abort        context.CancelFunc // Stops all parts of the running download
+	layout       atomic.Value       // []*Part once segmented, for Inspect
+	journal      *journal           // Resume state, nil when the server can't resume
+	fetcher      Fetcher            // Serves the ranges, httpFetcher unless the scheme has a RegisterFetcher one
+	queue        *writeQueue        // Disk writers shared by all parts
+	proto        *protoTransport    // HTTP/2 vs HTTP/1.1 selection, nil with --http2=off
+	links        *linkTransport     // Connections spread over local interfaces, nil without Config.Links
+	target       fsLimit            // File system the output is written to
+	volumeSize   int64              // Split the output into volumes of this size, 0 for one file
+	first        *http.Response     // Response to a non-GET request, read by the first part, see sendFirst
+	existing     int64              // Bytes of an existing output to resume from, see ConflictResume
+	encoding     string             // Content-Encoding the probe saw, see Config.Compressed
+	stream       *streamer          // Delivers the parts to Config.Stream, nil when saving to a file
+	noRanges     bool               // The server answered a part with the whole file, see errRangeIgnored
+	peerClient   *http.Client       // Part requests to Config.Peers, see newPeerClient
+	log          *slog.Logger       // Config.Logger or a silent one
+
+	heldMu sync.Mutex
+	held   map[string]time.Time // Hosts a Retry-After holds until then, see Config.ThrottleHost
+
+	urlMu     sync.Mutex      // Guards these and validatorURL once parts run
+	refreshed string          // Config.URL as replaced by RefreshURL
+	replaced  map[string]bool // Earlier URLs RefreshURL replaced
 }
 
 // UpdateDownloaded atomically updates the downloaded bytes count
@@ -46,7 +189,32 @@ func (s *Stats) AddDownloaded(n int64) {
 	atomic.AddInt64(&s.DownloadedBytes, n)
 }
 
+// SetDownloaded atomically replaces the downloaded bytes count
+func (s *Stats) SetDownloaded(n int64) {
+	atomic.StoreInt64(&s.DownloadedBytes, n)
+}
+
 // GetDownloaded atomically gets the downloaded bytes count
 func (s *Stats) GetDownloaded() int64 {
 	return atomic.LoadInt64(&s.DownloadedBytes)
 }
+
+// AddWire atomically adds to the bytes received before decoding
+func (s *Stats) AddWire(n int64) {
+	atomic.AddInt64(&s.WireBytes, n)
+}
+
+// GetWire atomically gets the bytes received before decoding
+func (s *Stats) GetWire() int64 {
+	return atomic.LoadInt64(&s.WireBytes)
+}
+
+// SetTotal atomically updates the expected total size
+func (s *Stats) SetTotal(n int64) {
+	atomic.StoreInt64(&s.TotalBytes, n)
+}
+
+// GetTotal atomically gets the expected total size
+func (s *Stats) GetTotal() int64 {
+	return atomic.LoadInt64(&s.TotalBytes)
+}
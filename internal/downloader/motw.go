@@ -0,0 +1,55 @@
+package downloader
+
+import (
+	"path/filepath"
+	"strings"
+)
+
+// MOTWMode controls the Windows Mark-of-the-Web (Zone.Identifier stream)
+type MOTWMode int
+
+const (
+	MOTWAuto   MOTWMode = iota // Mark executables and other risky types, like browsers
+	MOTWAlways                 // Mark every download
+	MOTWNever                  // Never mark
+)
+
+// Extensions SmartScreen and Office treat as needing a zone check.
+// Archives and disk images are included because extractors propagate the
+// mark to their contents.
+var motwExtensions = map[string]bool{
+	".exe": true, ".msi": true, ".msix": true, ".appx": true, ".com": true, ".scr": true,
+	".bat": true, ".cmd": true, ".ps1": true, ".vbs": true, ".vbe": true, ".js": true,
+	".jse": true, ".wsf": true, ".hta": true, ".lnk": true, ".url": true, ".cpl": true,
+	".dll": true, ".jar": true, ".reg": true, ".chm": true,
+	".zip": true, ".7z": true, ".rar": true, ".iso": true, ".img": true, ".vhd": true, ".vhdx": true,
+	".doc": true, ".docm": true, ".xls": true, ".xlsm": true, ".ppt": true, ".pptm": true, ".pdf": true,
+}
+
+// zoneIdentifier is the stream content browsers write for Internet downloads
+func zoneIdentifier(source string) string {
+	return "[ZoneTransfer]\r\nZoneId=3\r\nHostUrl=" + source + "\r\n"
+}
+
+// markOfTheWeb tags the finished output as downloaded from the Internet
+func (e *Engine) markOfTheWeb() error {
+	switch e.Config.MOTW {
+	case MOTWNever:
+		return nil
+	case MOTWAuto:
+		if !motwExtensions[strings.ToLower(filepath.Ext(e.Config.OutputName))] {
+			return nil
+		}
+	}
+	if e.target.Name == fsFAT {
+		// No alternate data streams to put the mark in
+		return nil
+	}
+	zone := zoneIdentifier(redactURL(e.Config.URL))
+	for _, path := range e.outputs() {
+		if err := writeZoneIdentifier(path, zone); err != nil {
+			return err
+		}
+	}
+	return nil
+}
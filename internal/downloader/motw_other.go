@@ -0,0 +1,8 @@
+//go:build !windows
+
+package downloader
+
+// Mark-of-the-Web only exists on Windows
+func writeZoneIdentifier(path, content string) error {
+	return nil
+}
@@ -0,0 +1,9 @@
+package downloader
+
+import "os"
+
+// writeZoneIdentifier stores the mark in the NTFS alternate data stream.
+// Volumes without stream support (FAT32, some network shares) fail here.
+func writeZoneIdentifier(path, content string) error {
+	return os.WriteFile(path+":Zone.Identifier", []byte(content), 0o644)
+}
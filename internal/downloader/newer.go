@@ -0,0 +1,88 @@
+package downloader
+
+import (
+	"errors"
+	"net/http"
+	"net/url"
+	"os"
+	"time"
+)
+
+// errNotModified is a probe answered 304 to Config.NewerOnly's
+// If-Modified-Since
+var errNotModified = errors.New("not modified")
+
+// localCopy is the file Config.NewerOnly compares with before the probe
+// picks the name: the output when given, else the name the URL suggests
+func (e *Engine) localCopy() string {
+	if e.Config.Stream != nil {
+		return ""
+	}
+	if e.Config.OutputName != "" {
+		return e.Config.OutputName
+	}
+	u, err := url.Parse(e.Config.URL)
+	if err != nil {
+		return ""
+	}
+	if name := urlFileName(u); name != "" {
+		return e.Config.defaultOutput(name)
+	}
+	return ""
+}
+
+func (e *Engine) localSize() int64 {
+	info, err := os.Stat(e.Config.OutputName)
+	if err != nil {
+		return 0
+	}
+	return info.Size()
+}
+
+// ifModifiedSince makes a probe conditional on a newer remote file than
+// the local copy
+func (e *Engine) ifModifiedSince(req *http.Request) {
+	if !e.Config.NewerOnly {
+		return
+	}
+	path := e.localCopy()
+	if path == "" {
+		return
+	}
+	if info, err := os.Stat(path); err == nil && info.Mode().IsRegular() {
+		req.Header.Set("If-Modified-Since", info.ModTime().UTC().Format(http.TimeFormat))
+	}
+}
+
+// upToDate checks the output against the probe's Last-Modified, for
+// servers that ignore If-Modified-Since and names only the probe reveals.
+// A server without Last-Modified always counts as newer.
+func (e *Engine) upToDate() (size int64, ok bool) {
+	if !e.Config.NewerOnly {
+		return 0, false
+	}
+	info, err := os.Stat(e.Config.OutputName)
+	if err != nil || !info.Mode().IsRegular() {
+		return 0, false
+	}
+	modified, err := http.ParseTime(e.validator.LastModified)
+	if err != nil || modified.After(info.ModTime()) {
+		return 0, false
+	}
+	return info.Size(), true
+}
+
+// setModTime dates the finished output to the remote file's Last-Modified,
+// so the next Config.NewerOnly run can compare with it
+func (e *Engine) setModTime() error {
+	modified, err := http.ParseTime(e.validator.LastModified)
+	if err != nil {
+		return nil
+	}
+	for _, path := range e.outputs() {
+		if err := os.Chtimes(path, time.Now(), modified); err != nil {
+			return err
+		}
+	}
+	return nil
+}
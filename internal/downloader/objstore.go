@@ -0,0 +1,501 @@
+package downloader
+
+import (
+	"context"
+	"crypto"
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/sha256"
+	"crypto/x509"
+	"encoding/base64"
+	"encoding/json"
+	"encoding/pem"
+	"fmt"
+	"net/http"
+	"net/url"
+	"os"
+	"path/filepath"
+	"runtime"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Object store URLs (s3://bucket/key, gs://bucket/object and
+// az://account/container/blob) are rewritten to their HTTPS endpoints and
+// downloaded by the regular segmented engine. The transports below add the
+// credentials to every request, so each ranged GET is signed on its own.
+
+// isObjectStoreURL reports whether raw uses one of the object store schemes
+func isObjectStoreURL(raw string) bool {
+	for _, scheme := range []string{"s3://", "gs://", "az://"} {
+		if strings.HasPrefix(raw, scheme) {
+			return true
+		}
+	}
+	return false
+}
+
+// objectStoreTransport returns the HTTPS URL for raw and a transport that
+// authenticates requests to it. A malformed URL yields a transport that
+// fails every request, which surfaces the error when probing.
+func objectStoreTransport(raw string, base http.RoundTripper) (string, http.RoundTripper) {
+	u, err := url.Parse(raw)
+	if err != nil || u.Host == "" || strings.Trim(u.Path, "/") == "" {
+		return raw, failingTransport{fmt.Errorf("invalid object store URL %q", raw)}
+	}
+	key := strings.TrimPrefix(u.Path, "/")
+
+	switch u.Scheme {
+	case "s3":
+		return newS3Transport(u.Host, key, base)
+	case "gs":
+		return "https://storage.googleapis.com/" + u.Host + "/" + key, &gcsTransport{base: base}
+	case "az":
+		// az://account/container/blob
+		if _, _, ok := strings.Cut(key, "/"); !ok {
+			return raw, failingTransport{fmt.Errorf("expected az://account/container/blob, got %q", raw)}
+		}
+		return newAzureTransport(u.Host, key, base)
+	}
+	return raw, failingTransport{fmt.Errorf("unsupported object store URL %q", raw)}
+}
+
+type failingTransport struct{ err error }
+
+func (t failingTransport) RoundTrip(*http.Request) (*http.Response, error) {
+	return nil, t.err
+}
+
+// advertiseRanges marks successful HEAD responses as range capable. All
+// three stores support ranged GETs but don't always say so on HEAD.
+func advertiseRanges(resp *http.Response) {
+	if resp.Request.Method == "HEAD" && resp.StatusCode == http.StatusOK && resp.Header.Get("Accept-Ranges") == "" {
+		resp.Header.Set("Accept-Ranges", "bytes")
+	}
+}
+
+// Amazon S3 and S3 compatible stores
+
+type s3Transport struct {
+	base   http.RoundTripper
+	bucket string
+	custom bool // AWS_ENDPOINT_URL points at a non-AWS store
+
+	mu     sync.Mutex
+	host   string
+	signer *sigV4Signer // nil for anonymous access to public buckets
+}
+
+func newS3Transport(bucket, key string, base http.RoundTripper) (string, http.RoundTripper) {
+	t := &s3Transport{base: base, bucket: bucket}
+	region := awsRegion()
+	if creds, ok := loadAWSCredentials(); ok {
+		t.signer = &sigV4Signer{creds: creds, region: region, service: "s3"}
+	}
+
+	// Custom endpoints (MinIO, R2, ...) use path-style addressing
+	endpoint := os.Getenv("AWS_ENDPOINT_URL_S3")
+	if endpoint == "" {
+		endpoint = os.Getenv("AWS_ENDPOINT_URL")
+	}
+	if endpoint != "" {
+		t.custom = true
+		return strings.TrimSuffix(endpoint, "/") + "/" + bucket + "/" + key, t
+	}
+
+	t.host = t.regionHost(region)
+	if strings.Contains(bucket, ".") {
+		// Dotted bucket names break the wildcard certificate
+		return "https://" + t.host + "/" + bucket + "/" + key, t
+	}
+	return "https://" + t.host + "/" + key, t
+}
+
+func (t *s3Transport) regionHost(region string) string {
+	if strings.Contains(t.bucket, ".") {
+		return "s3." + region + ".amazonaws.com"
+	}
+	return t.bucket + ".s3." + region + ".amazonaws.com"
+}
+
+func (t *s3Transport) RoundTrip(req *http.Request) (*http.Response, error) {
+	resp, err := t.do(req)
+	if err != nil || t.custom {
+		return resp, err
+	}
+
+	// A bucket in another region answers with a redirect naming the right
+	// one. Switch over for this and all later requests.
+	region := resp.Header.Get("X-Amz-Bucket-Region")
+	if region == "" || (resp.StatusCode != http.StatusMovedPermanently && resp.StatusCode != http.StatusBadRequest) {
+		return resp, nil
+	}
+	t.mu.Lock()
+	if t.signer != nil {
+		if t.signer.region == region {
+			t.mu.Unlock()
+			return resp, nil
+		}
+		signer := *t.signer
+		signer.region = region
+		t.signer = &signer
+	}
+	t.host = t.regionHost(region)
+	t.mu.Unlock()
+
+	resp.Body.Close()
+	return t.do(req)
+}
+
+func (t *s3Transport) do(req *http.Request) (*http.Response, error) {
+	r := req.Clone(req.Context())
+	t.mu.Lock()
+	if !t.custom {
+		r.URL.Host = t.host
+		r.Host = t.host
+	}
+	signer := t.signer
+	t.mu.Unlock()
+
+	if signer != nil {
+		signer.sign(r, time.Now())
+	}
+	resp, err := t.base.RoundTrip(r)
+	if err == nil {
+		advertiseRanges(resp)
+	}
+	return resp, err
+}
+
+// Google Cloud Storage (XML API)
+
+type gcsTransport struct {
+	base http.RoundTripper
+
+	once   sync.Once
+	tokens *gcsTokenSource // nil for anonymous access
+	err    error
+}
+
+func (t *gcsTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	t.once.Do(func() {
+		t.tokens, t.err = loadGCSCredentials(t.base)
+	})
+	if t.err != nil {
+		return nil, t.err
+	}
+
+	r := req.Clone(req.Context())
+	if t.tokens != nil {
+		token, err := t.tokens.token(req.Context())
+		if err != nil {
+			return nil, fmt.Errorf("gcs authentication failed: %w", err)
+		}
+		r.Header.Set("Authorization", "Bearer "+token)
+	}
+	resp, err := t.base.RoundTrip(r)
+	if err == nil {
+		advertiseRanges(resp)
+	}
+	return resp, err
+}
+
+const gcsScope = "https://www.googleapis.com/auth/devstorage.read_only"
+
+// gcsTokenSource caches OAuth access tokens until shortly before expiry
+type gcsTokenSource struct {
+	client *http.Client
+	fetch  func(ctx context.Context, client *http.Client) (string, time.Duration, error)
+
+	mu      sync.Mutex
+	current string
+	expires time.Time
+}
+
+func (s *gcsTokenSource) token(ctx context.Context) (string, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if s.current != "" && time.Until(s.expires) > time.Minute {
+		return s.current, nil
+	}
+	token, ttl, err := s.fetch(ctx, s.client)
+	if err != nil {
+		return "", err
+	}
+	s.current, s.expires = token, time.Now().Add(ttl)
+	return token, nil
+}
+
+type gcsCredentialsFile struct {
+	Type string `json:"type"`
+
+	// service_account
+	ClientEmail string `json:"client_email"`
+	PrivateKey  string `json:"private_key"`
+	TokenURI    string `json:"token_uri"`
+
+	// authorized_user (gcloud auth application-default login)
+	ClientID     string `json:"client_id"`
+	ClientSecret string `json:"client_secret"`
+	RefreshToken string `json:"refresh_token"`
+}
+
+// loadGCSCredentials looks for an access token in GOOGLE_OAUTH_ACCESS_TOKEN,
+// then for credentials in GOOGLE_APPLICATION_CREDENTIALS and the gcloud
+// application default location. No credentials means anonymous access.
+func loadGCSCredentials(base http.RoundTripper) (*gcsTokenSource, error) {
+	client := &http.Client{Transport: base, Timeout: 30 * time.Second}
+
+	if token := os.Getenv("GOOGLE_OAUTH_ACCESS_TOKEN"); token != "" {
+		return &gcsTokenSource{current: token, expires: time.Now().Add(100 * 365 * 24 * time.Hour)}, nil
+	}
+
+	path := os.Getenv("GOOGLE_APPLICATION_CREDENTIALS")
+	explicit := path != ""
+	if !explicit {
+		path = gcloudADCPath()
+	}
+	data, err := os.ReadFile(path)
+	if err != nil {
+		if explicit {
+			return nil, err
+		}
+		return nil, nil
+	}
+
+	var cf gcsCredentialsFile
+	if err := json.Unmarshal(data, &cf); err != nil {
+		return nil, fmt.Errorf("invalid credentials file %s: %w", path, err)
+	}
+
+	switch cf.Type {
+	case "service_account":
+		block, _ := pem.Decode([]byte(cf.PrivateKey))
+		if block == nil {
+			return nil, fmt.Errorf("service account key in %s is not PEM", path)
+		}
+		parsed, err := x509.ParsePKCS8PrivateKey(block.Bytes)
+		if err != nil {
+			return nil, err
+		}
+		key, ok := parsed.(*rsa.PrivateKey)
+		if !ok {
+			return nil, fmt.Errorf("service account key in %s is not RSA", path)
+		}
+		if cf.TokenURI == "" {
+			cf.TokenURI = "https://oauth2.googleapis.com/token"
+		}
+		return &gcsTokenSource{client: client, fetch: func(ctx context.Context, c *http.Client) (string, time.Duration, error) {
+			assertion, err := signJWT(key, cf.ClientEmail, cf.TokenURI)
+			if err != nil {
+				return "", 0, err
+			}
+			return requestOAuthToken(ctx, c, cf.TokenURI, url.Values{
+				"grant_type": {"urn:ietf:params:oauth:grant-type:jwt-bearer"},
+				"assertion":  {assertion},
+			})
+		}}, nil
+
+	case "authorized_user":
+		return &gcsTokenSource{client: client, fetch: func(ctx context.Context, c *http.Client) (string, time.Duration, error) {
+			return requestOAuthToken(ctx, c, "https://oauth2.googleapis.com/token", url.Values{
+				"grant_type":    {"refresh_token"},
+				"client_id":     {cf.ClientID},
+				"client_secret": {cf.ClientSecret},
+				"refresh_token": {cf.RefreshToken},
+			})
+		}}, nil
+	}
+	return nil, fmt.Errorf("unsupported credentials type %q in %s", cf.Type, path)
+}
+
+func gcloudADCPath() string {
+	if runtime.GOOS == "windows" {
+		return filepath.Join(os.Getenv("APPDATA"), "gcloud", "application_default_credentials.json")
+	}
+	home, _ := os.UserHomeDir()
+	return filepath.Join(home, ".config", "gcloud", "application_default_credentials.json")
+}
+
+// signJWT builds the RS256 assertion for the service account token exchange
+func signJWT(key *rsa.PrivateKey, email, audience string) (string, error) {
+	now := time.Now()
+	header := base64.RawURLEncoding.EncodeToString([]byte(`{"alg":"RS256","typ":"JWT"}`))
+	claims, err := json.Marshal(map[string]interface{}{
+		"iss":   email,
+		"scope": gcsScope,
+		"aud":   audience,
+		"iat":   now.Unix(),
+		"exp":   now.Add(time.Hour).Unix(),
+	})
+	if err != nil {
+		return "", err
+	}
+	unsigned := header + "." + base64.RawURLEncoding.EncodeToString(claims)
+	digest := sha256.Sum256([]byte(unsigned))
+	sig, err := rsa.SignPKCS1v15(rand.Reader, key, crypto.SHA256, digest[:])
+	if err != nil {
+		return "", err
+	}
+	return unsigned + "." + base64.RawURLEncoding.EncodeToString(sig), nil
+}
+
+func requestOAuthToken(ctx context.Context, client *http.Client, tokenURL string, form url.Values) (string, time.Duration, error) {
+	req, err := http.NewRequestWithContext(ctx, "POST", tokenURL, strings.NewReader(form.Encode()))
+	if err != nil {
+		return "", 0, err
+	}
+	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	resp, err := client.Do(req)
+	if err != nil {
+		return "", 0, err
+	}
+	defer resp.Body.Close()
+
+	var body struct {
+		AccessToken string `json:"access_token"`
+		ExpiresIn   int    `json:"expires_in"`
+		Error       string `json:"error_description"`
+	}
+	if err := json.NewDecoder(resp.Body).Decode(&body); err != nil {
+		return "", 0, fmt.Errorf("token endpoint returned %s", resp.Status)
+	}
+	if resp.StatusCode != http.StatusOK || body.AccessToken == "" {
+		return "", 0, fmt.Errorf("token endpoint returned %s: %s", resp.Status, body.Error)
+	}
+	return body.AccessToken, time.Duration(body.ExpiresIn) * time.Second, nil
+}
+
+// Azure Blob Storage
+
+const azureAPIVersion = "2021-08-06"
+
+type azureTransport struct {
+	base    http.RoundTripper
+	account string
+	key     []byte     // Shared Key, nil when using SAS or anonymous access
+	sas     url.Values // SAS token query parameters
+}
+
+// newAzureTransport reads AZURE_STORAGE_CONNECTION_STRING, or
+// AZURE_STORAGE_KEY / AZURE_STORAGE_SAS_TOKEN, for the account
+func newAzureTransport(account, path string, base http.RoundTripper) (string, http.RoundTripper) {
+	t := &azureTransport{base: base, account: account}
+	endpoint := "https://" + account + ".blob.core.windows.net"
+
+	keyStr := os.Getenv("AZURE_STORAGE_KEY")
+	sas := os.Getenv("AZURE_STORAGE_SAS_TOKEN")
+	if cs := os.Getenv("AZURE_STORAGE_CONNECTION_STRING"); cs != "" {
+		fields := map[string]string{}
+		for _, part := range strings.Split(cs, ";") {
+			if k, v, ok := strings.Cut(part, "="); ok {
+				fields[strings.ToLower(k)] = v
+			}
+		}
+		if name := fields["accountname"]; name == "" || strings.EqualFold(name, account) {
+			if fields["accountkey"] != "" {
+				keyStr = fields["accountkey"]
+			}
+			if fields["sharedaccesssignature"] != "" {
+				sas = fields["sharedaccesssignature"]
+			}
+			if fields["blobendpoint"] != "" {
+				endpoint = strings.TrimSuffix(fields["blobendpoint"], "/")
+			}
+		}
+	}
+
+	if sas != "" {
+		values, err := url.ParseQuery(strings.TrimPrefix(sas, "?"))
+		if err != nil {
+			return endpoint + "/" + path, failingTransport{fmt.Errorf("invalid Azure SAS token: %w", err)}
+		}
+		t.sas = values
+	} else if keyStr != "" {
+		key, err := base64.StdEncoding.DecodeString(keyStr)
+		if err != nil {
+			return endpoint + "/" + path, failingTransport{fmt.Errorf("invalid Azure storage key: %w", err)}
+		}
+		t.key = key
+	}
+	return endpoint + "/" + path, t
+}
+
+func (t *azureTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	r := req.Clone(req.Context())
+	r.Header.Set("X-Ms-Version", azureAPIVersion)
+	r.Header.Set("X-Ms-Date", time.Now().UTC().Format(http.TimeFormat))
+
+	if t.sas != nil {
+		q := r.URL.Query()
+		for k, v := range t.sas {
+			q[k] = v
+		}
+		r.URL.RawQuery = q.Encode()
+	} else if t.key != nil {
+		t.signSharedKey(r)
+	}
+
+	resp, err := t.base.RoundTrip(r)
+	if err == nil {
+		advertiseRanges(resp)
+	}
+	return resp, err
+}
+
+// signSharedKey implements the Shared Key authorization scheme
+func (t *azureTransport) signSharedKey(req *http.Request) {
+	h := req.Header
+	contentLength := h.Get("Content-Length")
+	if contentLength == "0" {
+		contentLength = ""
+	}
+
+	var msHeaders []string
+	for name := range h {
+		if lower := strings.ToLower(name); strings.HasPrefix(lower, "x-ms-") {
+			msHeaders = append(msHeaders, lower)
+		}
+	}
+	sort.Strings(msHeaders)
+	var canonicalHeaders strings.Builder
+	for _, name := range msHeaders {
+		canonicalHeaders.WriteString(name + ":" + strings.TrimSpace(h.Get(name)) + "\n")
+	}
+
+	resource := "/" + t.account + req.URL.EscapedPath()
+	q := req.URL.Query()
+	params := make([]string, 0, len(q))
+	for k := range q {
+		params = append(params, k)
+	}
+	sort.Strings(params)
+	for _, k := range params {
+		values := append([]string(nil), q[k]...)
+		sort.Strings(values)
+		resource += "\n" + strings.ToLower(k) + ":" + strings.Join(values, ",")
+	}
+
+	toSign := strings.Join([]string{
+		req.Method,
+		h.Get("Content-Encoding"),
+		h.Get("Content-Language"),
+		contentLength,
+		h.Get("Content-MD5"),
+		h.Get("Content-Type"),
+		"", // Date, x-ms-date is used instead
+		h.Get("If-Modified-Since"),
+		h.Get("If-Match"),
+		h.Get("If-None-Match"),
+		h.Get("If-Unmodified-Since"),
+		h.Get("Range"),
+	}, "\n") + "\n" + canonicalHeaders.String() + resource
+
+	mac := hmac.New(sha256.New, t.key)
+	mac.Write([]byte(toSign))
+	h.Set("Authorization", "SharedKey "+t.account+":"+base64.StdEncoding.EncodeToString(mac.Sum(nil)))
+}
@@ -0,0 +1,510 @@
+package downloader
+
+import (
+	"archive/tar"
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io"
+	"io/fs"
+	"mime"
+	"net"
+	"net/http"
+	"os"
+	"path"
+	"path/filepath"
+	"runtime"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+)
+
+// OCI images, oci://registry/repository:tag or @digest, are pulled over
+// the registry v2 API: the manifest for Config.Platform, then its config
+// and layer blobs all at once, each by a segmented engine of its own and
+// verified against its digest. The output is an OCI image layout
+// directory, or a tarball of one when the name ends in .tar, for podman
+// load, docker load and skopeo. Blobs already in the layout are kept, so
+// pulling a newer tag into it only fetches the layers that changed.
+
+const (
+	ociIndexType       = "application/vnd.oci.image.index.v1+json"
+	ociManifestType    = "application/vnd.oci.image.manifest.v1+json"
+	dockerListType     = "application/vnd.docker.distribution.manifest.list.v2+json"
+	dockerManifestType = "application/vnd.docker.distribution.manifest.v2+json"
+)
+
+// IsOCI reports whether url names an OCI image
+func IsOCI(url string) bool {
+	return strings.HasPrefix(url, "oci://")
+}
+
+// OCIDownloader pulls an OCI or Docker image from a registry
+type OCIDownloader struct {
+	Config Config
+	Stats  *Stats
+	Client *http.Client
+	Digest string // The image manifest's digest, once resolved
+
+	auth       *registryAuth
+	middleware []Middleware
+}
+
+// NewOCIDownloader creates a downloader for the image at cfg.URL
+func NewOCIDownloader(cfg Config) *OCIDownloader {
+	d := &OCIDownloader{
+		Config: cfg,
+		Stats:  &Stats{},
+		Client: NewClient(cfg),
+		auth:   &registryAuth{},
+	}
+	use(d.Client, d.auth.wrap)
+	return d
+}
+
+// Progress returns the live statistics of the download
+func (d *OCIDownloader) Progress() *Stats {
+	return d.Stats
+}
+
+// ociRef is a parsed oci:// URL
+type ociRef struct {
+	Registry string // host[:port], registry-1.docker.io for Docker Hub
+	Repo     string
+	Ref      string // Tag or digest
+	scheme   string
+}
+
+func parseOCIRef(raw string) (ociRef, error) {
+	invalid := fmt.Errorf("invalid OCI image %q: want oci://registry/repository:tag or @sha256:digest", raw)
+	host, repo, ok := strings.Cut(strings.TrimPrefix(raw, "oci://"), "/")
+	if !ok || host == "" || repo == "" {
+		return ociRef{}, invalid
+	}
+	r := ociRef{Registry: host, Ref: "latest", scheme: "https"}
+	if name, digest, ok := strings.Cut(repo, "@"); ok {
+		r.Repo, r.Ref = name, digest
+	} else if i := strings.LastIndex(repo, ":"); i > strings.LastIndex(repo, "/") {
+		r.Repo, r.Ref = repo[:i], repo[i+1:]
+	} else {
+		r.Repo = repo
+	}
+	if r.Repo == "" || r.Ref == "" || strings.Contains(r.Ref, "/") || strings.ContainsAny(r.Repo, ":@ ") {
+		return ociRef{}, invalid
+	}
+
+	switch host {
+	case "docker.io", "index.docker.io", "registry-1.docker.io":
+		r.Registry = "registry-1.docker.io"
+		if !strings.Contains(r.Repo, "/") {
+			r.Repo = "library/" + r.Repo
+		}
+	}
+	// Like docker, registries on this machine speak plain HTTP
+	name := r.Registry
+	if h, _, err := net.SplitHostPort(name); err == nil {
+		name = h
+	}
+	if ip := net.ParseIP(name); name == "localhost" || (ip != nil && ip.IsLoopback()) {
+		r.scheme = "http"
+	}
+	return r, nil
+}
+
+func (r ociRef) url(kind, ref string) string {
+	return r.scheme + "://" + r.Registry + "/v2/" + r.Repo + "/" + kind + "/" + ref
+}
+
+// name is the default output name: the repository's last element and the
+// tag, or the start of the digest
+func (r ociRef) name() string {
+	ref := r.Ref
+	if _, digest, ok := strings.Cut(ref, ":"); ok {
+		ref = digest[:min(12, len(digest))]
+	}
+	return path.Base(r.Repo) + "_" + ref
+}
+
+type ociDescriptor struct {
+	MediaType   string            `json:"mediaType"`
+	Digest      string            `json:"digest"`
+	Size        int64             `json:"size"`
+	Platform    *ociPlatform      `json:"platform,omitempty"`
+	Annotations map[string]string `json:"annotations,omitempty"`
+}
+
+type ociPlatform struct {
+	Architecture string `json:"architecture"`
+	OS           string `json:"os"`
+	Variant      string `json:"variant,omitempty"`
+}
+
+func (p ociPlatform) String() string {
+	s := p.OS + "/" + p.Architecture
+	if p.Variant != "" {
+		s += "/" + p.Variant
+	}
+	return s
+}
+
+// ociManifest is an image manifest or, with Manifests, an index of them
+type ociManifest struct {
+	MediaType string          `json:"mediaType"`
+	Manifests []ociDescriptor `json:"manifests"`
+	Config    ociDescriptor   `json:"config"`
+	Layers    []ociDescriptor `json:"layers"`
+}
+
+// Start resolves the image, downloads its blobs and writes the layout
+func (d *OCIDownloader) Start(ctx context.Context) error {
+	ref, err := parseOCIRef(d.Config.URL)
+	if err != nil {
+		return err
+	}
+	d.auth.registry, d.auth.scope = ref.Registry, "repository:"+ref.Repo+":pull"
+
+	manifest, desc, body, err := d.resolve(ctx, ref)
+	if err != nil {
+		return err
+	}
+	d.Digest = desc.Digest
+
+	if d.Config.OutputName == "" {
+		d.Config.OutputName = d.Config.defaultOutput(ref.name())
+	}
+	out := d.Config.OutputName
+	tarball := strings.EqualFold(filepath.Ext(out), ".tar")
+	layout := out
+	if tarball {
+		layout = tmpPath(out) + ".layout"
+	}
+
+	// Older images repeat the empty layer
+	var blobs []ociDescriptor
+	var total int64
+	seen := map[string]bool{}
+	for _, b := range append([]ociDescriptor{desc, manifest.Config}, manifest.Layers...) {
+		if !seen[b.Digest] {
+			seen[b.Digest] = true
+			blobs = append(blobs, b)
+			total += b.Size
+		}
+	}
+	d.Stats.SetTotal(total)
+	if err := writeBlob(layout, desc, body); err != nil {
+		return err
+	}
+	if err := d.fetchBlobs(ctx, ref, layout, blobs[1:], desc.Size); err != nil {
+		return err
+	}
+
+	// The index goes last, a layout without one is an unfinished pull
+	desc.Annotations = map[string]string{
+		"org.opencontainers.image.ref.name": ref.Ref,
+		"io.containerd.image.name":          strings.TrimPrefix(d.Config.URL, "oci://"),
+	}
+	index, err := json.Marshal(map[string]any{"schemaVersion": 2, "mediaType": ociIndexType, "manifests": []ociDescriptor{desc}})
+	if err != nil {
+		return err
+	}
+	if err := writeLayoutFile(filepath.Join(layout, "oci-layout"), []byte(`{"imageLayoutVersion":"1.0.0"}`), d.Config.Fsync); err != nil {
+		return err
+	}
+	if err := writeLayoutFile(filepath.Join(layout, "index.json"), index, d.Config.Fsync); err != nil {
+		return err
+	}
+	if !tarball {
+		return nil
+	}
+
+	if err := tarDir(layout, tmpPath(out)); err != nil {
+		return fmt.Errorf("failed to write %s: %w", out, err)
+	}
+	if err := commitFile(tmpPath(out), out, d.Config.Fsync); err != nil {
+		return err
+	}
+	return os.RemoveAll(layout)
+}
+
+// resolve fetches the image manifest, through the index for the platform
+// when the reference is a multi-platform image
+func (d *OCIDownloader) resolve(ctx context.Context, ref ociRef) (ociManifest, ociDescriptor, []byte, error) {
+	body, desc, err := d.fetchManifest(ctx, ref, ref.Ref)
+	if err != nil {
+		return ociManifest{}, ociDescriptor{}, nil, err
+	}
+	var m ociManifest
+	if err := json.Unmarshal(body, &m); err != nil {
+		return ociManifest{}, ociDescriptor{}, nil, fmt.Errorf("invalid manifest: %w", err)
+	}
+	if desc.MediaType == ociIndexType || desc.MediaType == dockerListType || len(m.Manifests) > 0 {
+		pick, err := selectPlatform(m.Manifests, d.Config.Platform)
+		if err != nil {
+			return ociManifest{}, ociDescriptor{}, nil, err
+		}
+		if body, desc, err = d.fetchManifest(ctx, ref, pick.Digest); err != nil {
+			return ociManifest{}, ociDescriptor{}, nil, err
+		}
+		desc.Platform = pick.Platform
+		m = ociManifest{}
+		if err := json.Unmarshal(body, &m); err != nil {
+			return ociManifest{}, ociDescriptor{}, nil, fmt.Errorf("invalid manifest: %w", err)
+		}
+	}
+	if m.Config.Digest == "" {
+		return ociManifest{}, ociDescriptor{}, nil, fmt.Errorf("%s is not an image manifest (%s)", desc.Digest, desc.MediaType)
+	}
+	return m, desc, body, nil
+}
+
+// fetchManifest gets a manifest by tag or digest and checks its digest
+func (d *OCIDownloader) fetchManifest(ctx context.Context, ref ociRef, reference string) ([]byte, ociDescriptor, error) {
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, ref.url("manifests", reference), nil)
+	if err != nil {
+		return nil, ociDescriptor{}, err
+	}
+	req.Header.Set("User-Agent", defaultUserAgent)
+	req.Header.Set("Accept", strings.Join([]string{ociIndexType, ociManifestType, dockerListType, dockerManifestType}, ", "))
+	resp, err := d.Client.Do(req)
+	if err != nil {
+		return nil, ociDescriptor{}, err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode == http.StatusNotFound {
+		return nil, ociDescriptor{}, fmt.Errorf("%s/%s has no image %s", ref.Registry, ref.Repo, reference)
+	}
+	if resp.StatusCode != http.StatusOK {
+		return nil, ociDescriptor{}, fmt.Errorf("manifest %s: %w", reference, newStatusError(resp))
+	}
+	body, err := io.ReadAll(io.LimitReader(resp.Body, 4<<20))
+	if err != nil {
+		return nil, ociDescriptor{}, err
+	}
+
+	sum := sha256.Sum256(body)
+	desc := ociDescriptor{Digest: "sha256:" + hex.EncodeToString(sum[:]), Size: int64(len(body))}
+	desc.MediaType, _, _ = mime.ParseMediaType(resp.Header.Get("Content-Type"))
+	for _, want := range []string{reference, resp.Header.Get("Docker-Content-Digest")} {
+		if strings.HasPrefix(want, "sha256:") && want != desc.Digest {
+			return nil, ociDescriptor{}, fmt.Errorf("manifest %s: digest mismatch, got %s", want, desc.Digest)
+		}
+	}
+	var typed struct {
+		MediaType string `json:"mediaType"`
+	}
+	if json.Unmarshal(body, &typed) == nil && typed.MediaType != "" {
+		desc.MediaType = typed.MediaType
+	}
+	return body, desc, nil
+}
+
+// selectPlatform picks the index entry for an os/arch[/variant] platform,
+// linux and this machine's architecture when it is empty
+func selectPlatform(manifests []ociDescriptor, want string) (ociDescriptor, error) {
+	if want == "" {
+		want = "linux/" + runtime.GOARCH
+	}
+	parts := strings.Split(want, "/")
+	if len(parts) < 2 || len(parts) > 3 {
+		return ociDescriptor{}, fmt.Errorf("invalid platform %q: want os/arch or os/arch/variant", want)
+	}
+	var have []string
+	for _, m := range manifests {
+		p := m.Platform
+		if p == nil || p.OS == "unknown" {
+			// Attestations and other artifacts
+			continue
+		}
+		if p.OS == parts[0] && p.Architecture == parts[1] && (len(parts) == 2 || p.Variant == parts[2]) {
+			return m, nil
+		}
+		have = append(have, p.String())
+	}
+	return ociDescriptor{}, fmt.Errorf("the image has no %s variant, only %s", want, strings.Join(have, ", "))
+}
+
+// fetchBlobs downloads the blobs missing from the layout, all at once
+func (d *OCIDownloader) fetchBlobs(ctx context.Context, ref ociRef, layout string, blobs []ociDescriptor, have int64) error {
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+
+	var engines []*Engine
+	for _, b := range blobs {
+		p, sum, err := blobPath(layout, b.Digest)
+		if err != nil {
+			return err
+		}
+		if fi, err := os.Stat(p); err == nil && fi.Size() == b.Size && sum.Verify(p) == nil {
+			have += b.Size
+			continue
+		}
+		if err := os.MkdirAll(filepath.Dir(p), 0o755); err != nil {
+			return err
+		}
+		e := NewEngine(d.blobConfig(ref.url("blobs", b.Digest), p, sum))
+		for _, mw := range d.middleware {
+			e.UseRoundTripper(mw)
+		}
+		engines = append(engines, e)
+	}
+
+	// Blobs already there don't count as speed
+	d.Stats.SetDownloaded(have)
+	d.Stats.BeginRate(time.Now())
+	progress := func() {
+		n := have
+		for _, e := range engines {
+			n += e.Stats.GetDownloaded()
+		}
+		d.Stats.SetDownloaded(n)
+	}
+	stop := make(chan struct{})
+	go func() {
+		tick := time.NewTicker(200 * time.Millisecond)
+		defer tick.Stop()
+		for {
+			select {
+			case <-stop:
+				return
+			case <-tick.C:
+				progress()
+			}
+		}
+	}()
+
+	var (
+		wg    sync.WaitGroup
+		errMu sync.Mutex
+		first error
+	)
+	for _, e := range engines {
+		wg.Add(1)
+		go func(e *Engine) {
+			defer wg.Done()
+			if err := e.Start(ctx); err != nil {
+				errMu.Lock()
+				if first == nil {
+					first = fmt.Errorf("blob %s: %w", e.Config.Checksum, err)
+				}
+				errMu.Unlock()
+				cancel()
+			}
+		}(e)
+	}
+	wg.Wait()
+	close(stop)
+	progress()
+	return first
+}
+
+// blobConfig is the download's Config for one blob, without what only
+// applies to the image as a whole
+func (d *OCIDownloader) blobConfig(blobURL, path string, sum *Checksum) Config {
+	cfg := d.Config
+	cfg.URL, cfg.OutputName, cfg.Checksum = blobURL, path, sum
+	cfg.Mirrors, cfg.Peers, cfg.FindPeers = nil, nil, nil
+	cfg.Signature, cfg.Range, cfg.Zsync, cfg.Stream = nil, nil, nil, nil
+	cfg.Method, cfg.Body = "", nil
+	cfg.OnDuplicate, cfg.Duplicates, cfg.RefreshURL = nil, nil, nil
+	cfg.OnConflict, cfg.SplitOutput, cfg.MOTW = ConflictOverwrite, SplitNever, MOTWNever
+	cfg.NewerOnly, cfg.Follow = false, false
+
+	wrap := d.Config.WrapTransport
+	cfg.WrapTransport = func(rt http.RoundTripper) http.RoundTripper {
+		rt = d.auth.wrap(rt)
+		if wrap != nil {
+			rt = wrap(rt)
+		}
+		return rt
+	}
+	return cfg
+}
+
+// blobPath is where a blob goes in the layout, blobs/<algo>/<hex>
+func blobPath(layout, digest string) (string, *Checksum, error) {
+	sum, err := ParseChecksum(digest)
+	if err == nil {
+		_, err = hex.DecodeString(sum.Value)
+	}
+	if err != nil || len(sum.Value) < 64 {
+		return "", nil, fmt.Errorf("invalid digest %q in the manifest", digest)
+	}
+	return filepath.Join(layout, "blobs", sum.Algo, sum.Value), sum, nil
+}
+
+func writeBlob(layout string, desc ociDescriptor, body []byte) error {
+	p, _, err := blobPath(layout, desc.Digest)
+	if err != nil {
+		return err
+	}
+	if err := os.MkdirAll(filepath.Dir(p), 0o755); err != nil {
+		return err
+	}
+	return writeLayoutFile(p, body, false)
+}
+
+func writeLayoutFile(path string, data []byte, sync bool) error {
+	if err := os.WriteFile(tmpPath(path), data, 0o644); err != nil {
+		return err
+	}
+	return commitFile(tmpPath(path), path, sync)
+}
+
+// tarDir writes the files below dir to a tarball, in a stable order
+func tarDir(dir, target string) error {
+	var files []string
+	err := filepath.WalkDir(dir, func(p string, entry fs.DirEntry, err error) error {
+		if err == nil && p != dir {
+			files = append(files, p)
+		}
+		return err
+	})
+	if err != nil {
+		return err
+	}
+	sort.Strings(files)
+
+	f, err := os.Create(target)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+	tw := tar.NewWriter(f)
+	for _, p := range files {
+		fi, err := os.Stat(p)
+		if err != nil {
+			return err
+		}
+		hdr, err := tar.FileInfoHeader(fi, "")
+		if err != nil {
+			return err
+		}
+		rel, _ := filepath.Rel(dir, p)
+		hdr.Name = filepath.ToSlash(rel)
+		if fi.IsDir() {
+			hdr.Name += "/"
+		}
+		if err := tw.WriteHeader(hdr); err != nil {
+			return err
+		}
+		if fi.IsDir() {
+			continue
+		}
+		src, err := os.Open(p)
+		if err != nil {
+			return err
+		}
+		_, err = io.Copy(tw, src)
+		src.Close()
+		if err != nil {
+			return err
+		}
+	}
+	if err := tw.Close(); err != nil {
+		return err
+	}
+	return f.Close()
+}
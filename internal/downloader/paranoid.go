@@ -0,0 +1,89 @@
+package downloader
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"sync/atomic"
+)
+
+// ErrRangeMismatch means the server sent different bytes for the same
+// offsets depending on the range asked for. Broken CDN range support does
+// this, and the output would be silently corrupt.
+var ErrRangeMismatch = errors.New("server returned inconsistent data for overlapping ranges")
+
+// boundaryOverlap is how far the paranoid check reaches into the parts on
+// each side of a boundary
+const boundaryOverlap = 4096
+
+// checkBoundaries fetches a small range across every part boundary and
+// compares it with what the parts wrote there. It only reads, the parts are
+// left alone unless a boundary disagrees. Then both parts next to it are
+// reset, as there's no telling which one is wrong, and the next run fetches
+// them again.
+func (e *Engine) checkBoundaries(ctx context.Context) error {
+	for i := 1; i < len(e.Parts); i++ {
+		a, b := e.Parts[i-1], e.Parts[i]
+		before, after := int64(boundaryOverlap), int64(boundaryOverlap)
+		if n := a.End - a.Start + 1; n < before {
+			before = n
+		}
+		if n := b.End - b.Start + 1; n < after {
+			after = n
+		}
+		from, to := b.Start-before, b.Start+after-1
+
+		remote, err := e.fetchRange(ctx, from, to)
+		if err != nil {
+			return fmt.Errorf("paranoid check of bytes %d-%d: %w", from, to, err)
+		}
+		local := make([]byte, before+after)
+		if err := readAt(a.TempPath, local[:before], a.End-a.Start+1-before); err != nil {
+			return err
+		}
+		if err := readAt(b.TempPath, local[before:], 0); err != nil {
+			return err
+		}
+		if !bytes.Equal(remote, local) {
+			e.resetPart(a)
+			e.resetPart(b)
+			return fmt.Errorf("%w: bytes %d-%d between parts %d and %d", ErrRangeMismatch, from, to, a.ID, b.ID)
+		}
+	}
+	return nil
+}
+
+// fetchRange downloads bytes from-to of the primary source into memory
+func (e *Engine) fetchRange(ctx context.Context, from, to int64) ([]byte, error) {
+	body, err := e.fetcher.FetchRange(ctx, e.Config.URL, from, to)
+	if err != nil {
+		return nil, err
+	}
+	defer body.Close()
+
+	buf := make([]byte, to-from+1)
+	if _, err := io.ReadFull(body, buf); err != nil {
+		return nil, err
+	}
+	return buf, nil
+}
+
+// resetPart forgets what a part downloaded so it starts over
+func (e *Engine) resetPart(part *Part) {
+	e.Stats.AddDownloaded(-atomic.SwapInt64(&part.Downloaded, 0))
+	part.crc = 0
+	e.checkpoint(part)
+}
+
+func readAt(path string, buf []byte, off int64) error {
+	f, err := os.Open(path)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+	_, err = f.ReadAt(buf, off)
+	return err
+}
@@ -0,0 +1,112 @@
+package downloader
+
+import (
+	"fmt"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"time"
+)
+
+// ResumeState is the resume journal an unfinished download left behind, ""
+// when there is nothing to continue from. Running the same download again
+// picks it up.
+func (e *Engine) ResumeState() string {
+	if e.Config.OutputName == "" {
+		return ""
+	}
+	path := statePath(e.Config.OutputName)
+	if _, err := os.Stat(path); err != nil {
+		return ""
+	}
+	return path
+}
+
+// Interrupted is an unfinished download found by its resume journal
+type Interrupted struct {
+	Output     string // The output file it was writing
+	URL        string // With any password redacted
+	Total      int64
+	Downloaded int64 // Recorded in the journal, the part files are checked on resume
+	RangeStart int64 // Where a --range download started, 0 otherwise
+	Modified   time.Time
+}
+
+// ReadInterrupted reads the resume journal of the download of output
+func ReadInterrupted(output string) (Interrupted, error) {
+	path := statePath(output)
+	f, err := os.Open(path)
+	if err != nil {
+		return Interrupted{}, err
+	}
+	defer f.Close()
+	snap, err := readState(f)
+	if err != nil {
+		return Interrupted{}, fmt.Errorf("%s: %w", path, err)
+	}
+	in := Interrupted{Output: output, URL: snap.URL, Total: snap.Total, RangeStart: snap.RangeStart}
+	for _, p := range snap.Parts {
+		in.Downloaded += p.Done
+	}
+	if info, err := f.Stat(); err == nil {
+		in.Modified = info.ModTime()
+	}
+	return in, nil
+}
+
+// FindInterrupted looks for the resume journals in dir and depth levels of
+// directories below it, e.g. for --name-template layouts, newest first.
+// Unreadable journals are skipped.
+func FindInterrupted(dir string, depth int) ([]Interrupted, error) {
+	root := filepath.Clean(dir)
+	var found []Interrupted
+	err := filepath.WalkDir(root, func(path string, d fs.DirEntry, err error) error {
+		if err != nil {
+			if path == root {
+				return err
+			}
+			return nil
+		}
+		if d.IsDir() {
+			if path == root {
+				return nil
+			}
+			rel, _ := filepath.Rel(root, path)
+			if strings.HasPrefix(d.Name(), ".") || strings.Count(rel, string(filepath.Separator)) >= depth {
+				return filepath.SkipDir
+			}
+			return nil
+		}
+		if output, ok := strings.CutSuffix(path, ".warp"); ok && d.Type().IsRegular() {
+			if in, err := ReadInterrupted(output); err == nil {
+				found = append(found, in)
+			}
+		}
+		return nil
+	})
+	sort.SliceStable(found, func(i, j int) bool { return found[i].Modified.After(found[j].Modified) })
+	return found, err
+}
+
+// stopped cleans up after a download that gave up or was cancelled, the
+// journal already holds the parts' final offsets
+func (e *Engine) stopped() {
+	if e.Config.DropPartial {
+		e.dropPartial()
+	}
+}
+
+// dropPartial deletes what an unfinished download wrote: the part files,
+// the resume state and the temporary output
+func (e *Engine) dropPartial() {
+	if e.Config.OutputName == "" {
+		return
+	}
+	for _, p := range e.Parts {
+		os.Remove(p.TempPath)
+	}
+	os.Remove(statePath(e.Config.OutputName))
+	os.Remove(tmpPath(e.Config.OutputName))
+}
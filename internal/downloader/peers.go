@@ -0,0 +1,58 @@
+package downloader
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"slices"
+)
+
+// LAN peers announce themselves over mDNS, which anyone on the network can
+// answer. They get plain part requests, none of the headers, cookies or
+// signing meant for the download's servers, and the checksum decides
+// whether what they sent is the file.
+
+// WithoutCredentials is the config with its network settings but none of
+// the headers, cookies or signing, for requests to parties that aren't
+// trusted with them
+func (c Config) WithoutCredentials() Config {
+	c.Headers, c.HostHeaders, c.Cookies, c.AWSSigV4 = nil, nil, nil, ""
+	return c
+}
+
+// newPeerClient is the client of part requests to Config.Peers: the
+// download's network settings without its credentials
+func newPeerClient(cfg Config) *http.Client {
+	client := NewClient(cfg.WithoutCredentials())
+	if cfg.Logger != nil {
+		client.Transport = &logTransport{base: client.Transport, log: cfg.Logger, wire: cfg.WireTrace}
+	}
+	if cfg.WrapTransport != nil {
+		client.Transport = cfg.WrapTransport(client.Transport)
+	}
+	return client
+}
+
+// clientFor is the client to send a part request to src with
+func (e *Engine) clientFor(src string) *http.Client {
+	if e.peerClient != nil && slices.Contains(e.Config.Peers, src) {
+		return e.peerClient
+	}
+	return e.Client
+}
+
+// dropPeers prepares the run after one from peers that failed the
+// checksum, and reports whether there is one. Which peer sent the wrong
+// bytes doesn't show, so the download starts over from the URL and the
+// mirrors alone.
+func (e *Engine) dropPeers(ctx context.Context, err error) bool {
+	if !errors.Is(err, ErrChecksumMismatch) || len(e.Config.Peers) == 0 || ctx.Err() != nil || e.Config.Stream != nil {
+		return false
+	}
+	e.warn("the download from LAN peers failed verification, downloading it again without them: %v", err)
+	e.Config.Peers, e.Config.FindPeers = nil, nil
+	e.dropPartial()
+	e.Parts, e.journal = nil, nil
+	e.Stats.SetDownloaded(0)
+	return true
+}
@@ -0,0 +1,204 @@
+package downloader
+
+import (
+	"context"
+	"os"
+	"sync"
+)
+
+// Network reads and disk writes are decoupled by a bounded queue: readers
+// fill pooled chunks and hand them to a small set of disk writers. The number
+// of chunks in flight is capped, so when the disk can't keep up (SMR drives,
+// NFS) readers block, TCP flow control throttles the servers, and memory
+// stays at roughly the configured limit instead of growing with the link.
+// A writer that falls behind joins the consecutive chunks waiting in its lane
+// into one larger write, which is what slow disks are best at.
+
+const (
+	// Default and largest chunk read from a connection per disk write
+	DefaultBufferSize = 64 * 1024
+	MaxBufferSize     = 16 << 20
+
+	// Default cap on bytes read from the network but not yet on disk
+	defaultMaxInFlight = 32 << 20
+
+	diskWriters = 4
+
+	// Largest write made of chunks joined together
+	coalesceSize = 1 << 20
+)
+
+type writeJob struct {
+	f    *os.File
+	off  int64
+	buf  []byte // Pooled chunk
+	data []byte // Filled prefix of buf
+	drop *cacheDropper
+	done func(error)
+}
+
+type writeQueue struct {
+	size  int // Of a chunk
+	slots chan struct{}
+	lanes []chan writeJob
+	pool  sync.Pool
+	wg    sync.WaitGroup
+
+	// onFull decides what to do when a write finds the disk full: nil
+	// retries, an error fails the write. Writers that hit it together wait
+	// for a single call instead of asking once each.
+	onFull  func(error) error
+	fullMu  sync.Mutex
+	fullGen int   // Bumped each time onFull clears a full disk
+	fullErr error // onFull's verdict once it gave up
+}
+
+func newWriteQueue(maxInFlight, chunkSize int64, onFull func(error) error) *writeQueue {
+	if maxInFlight <= 0 {
+		maxInFlight = defaultMaxInFlight
+	}
+	chunkSize = bufferSize(chunkSize)
+	n := maxInFlight / chunkSize
+	if n < 1 {
+		n = 1
+	}
+
+	q := &writeQueue{size: int(chunkSize), slots: make(chan struct{}, n), onFull: onFull}
+	q.pool.New = func() interface{} { return make([]byte, q.size) }
+	for i := 0; i < diskWriters; i++ {
+		lane := make(chan writeJob, n)
+		q.lanes = append(q.lanes, lane)
+		q.wg.Add(1)
+		go q.writer(lane)
+	}
+	return q
+}
+
+// bufferSize is Config.BufferSize with the default filled in and the
+// maximum applied
+func bufferSize(n int64) int64 {
+	switch {
+	case n <= 0:
+		return DefaultBufferSize
+	case n > MaxBufferSize:
+		return MaxBufferSize
+	}
+	return n
+}
+
+// acquire waits for room in the in-flight budget and returns an empty chunk
+func (q *writeQueue) acquire(ctx context.Context) ([]byte, error) {
+	select {
+	case q.slots <- struct{}{}:
+		return q.pool.Get().([]byte), nil
+	case <-ctx.Done():
+		return nil, ctx.Err()
+	}
+}
+
+// release returns an unused chunk from acquire
+func (q *writeQueue) release(buf []byte) {
+	q.pool.Put(buf)
+	<-q.slots
+}
+
+// submit queues a write. Jobs with the same lane are written and completed
+// in order, so a part always maps to one lane.
+func (q *writeQueue) submit(lane int, job writeJob) {
+	q.lanes[lane%len(q.lanes)] <- job
+}
+
+func (q *writeQueue) writer(lane chan writeJob) {
+	defer q.wg.Done()
+	var (
+		scratch []byte // For joined writes, allocated on the first one
+		next    *writeJob
+	)
+	for {
+		job, ok := writeJob{}, true
+		if next != nil {
+			job, next = *next, nil
+		} else if job, ok = <-lane; !ok {
+			return
+		}
+
+		// Take the chunks already waiting that continue this one
+		batch := []writeJob{job}
+		size := len(job.data)
+	gather:
+		for size < coalesceSize {
+			select {
+			case j, ok := <-lane:
+				if !ok {
+					break gather
+				}
+				last := batch[len(batch)-1]
+				if j.f != last.f || j.off != last.off+int64(len(last.data)) || size+len(j.data) > coalesceSize {
+					next = &j
+					break gather
+				}
+				batch = append(batch, j)
+				size += len(j.data)
+			default:
+				break gather
+			}
+		}
+
+		if len(batch) > 1 {
+			if scratch == nil {
+				scratch = make([]byte, coalesceSize)
+			}
+			data := scratch[:0]
+			for _, j := range batch {
+				data = append(data, j.data...)
+			}
+			job = writeJob{f: job.f, off: job.off, data: data, drop: job.drop}
+		}
+		err := q.write(job)
+		if err == nil {
+			job.drop.wrote(job.off + int64(len(job.data)))
+		}
+		for _, j := range batch {
+			j.done(err)
+			q.release(j.buf)
+		}
+	}
+}
+
+// write writes a job, waiting on onFull and retrying the rest whenever the
+// disk fills up, so a full disk pauses the download rather than failing
+// every part into its retry loop
+func (q *writeQueue) write(job writeJob) error {
+	data, off := job.data, job.off
+	for {
+		q.fullMu.Lock()
+		gen, fullErr := q.fullGen, q.fullErr
+		q.fullMu.Unlock()
+		if fullErr != nil {
+			return fullErr
+		}
+
+		n, err := job.f.WriteAt(data, off)
+		if err == nil || !isNoSpace(err) || q.onFull == nil {
+			return err
+		}
+		data, off = data[n:], off+int64(n)
+
+		q.fullMu.Lock()
+		if q.fullGen == gen && q.fullErr == nil {
+			// First to notice, the others wait here for the answer
+			if q.fullErr = q.onFull(err); q.fullErr == nil {
+				q.fullGen++
+			}
+		}
+		q.fullMu.Unlock()
+	}
+}
+
+// close waits for queued writes to finish and stops the writers
+func (q *writeQueue) close() {
+	for _, lane := range q.lanes {
+		close(lane)
+	}
+	q.wg.Wait()
+}
@@ -0,0 +1,59 @@
+package downloader
+
+import (
+	"context"
+	"sync"
+)
+
+// With --pipeline N the file is cut into many small ranges and N workers
+// request them one after another. The client keeps at most N connections
+// per host and each goes back to the pool after a range, so a host that
+// allows only a couple of connections still gets every part without a new
+// handshake each time. Go's HTTP/1.1 client doesn't pipeline, a connection
+// carries the next request once the previous response has been read.
+
+// Ranges queued per connection
+const pipelinePartsPerConn = 16
+
+// pipelinePartCount is the number of segments for a pipelined download
+func pipelinePartCount(total, minSize int64, conns int) int {
+	n := total / minSize
+	if max := int64(conns * pipelinePartsPerConn); n > max {
+		n = max
+	}
+	if n < 1 {
+		n = 1
+	}
+	return int(n)
+}
+
+// runPipelined downloads all parts over Config.Pipeline kept-alive
+// connections, each working through the queue in order
+func (e *Engine) runPipelined(ctx context.Context, errChan chan<- error) {
+	queue := make(chan *Part, len(e.Parts))
+	for _, p := range e.Parts {
+		queue <- p
+	}
+	close(queue)
+
+	conns := e.Config.Pipeline
+	if len(e.Parts) < conns {
+		conns = len(e.Parts)
+	}
+	e.trace("pipelined: %d parts over %d connections", len(e.Parts), conns)
+
+	var wg sync.WaitGroup
+	for i := 0; i < conns; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for p := range queue {
+				if err := e.downloadPartWithRetry(ctx, p); err != nil {
+					errChan <- err
+					return
+				}
+			}
+		}()
+	}
+	wg.Wait()
+}
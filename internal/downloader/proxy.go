@@ -0,0 +1,78 @@
+package downloader
+
+import (
+	"fmt"
+	"net/http"
+	"net/url"
+	"sync"
+
+	"warp-dl/internal/pac"
+)
+
+// PAC resolvers by script location, "" for WPAD. Shared so a download that
+// builds several clients loads and discovers the script only once.
+var (
+	pacMu        sync.Mutex
+	pacResolvers = map[string]*pac.Resolver{}
+)
+
+// ProxyDirect as Config.Proxy connects directly, ignoring the environment
+const ProxyDirect = "direct"
+
+// ParseProxy checks a proxy URL for Config.Proxy: http, https or socks5,
+// or ProxyDirect
+func ParseProxy(s string) (*url.URL, error) {
+	if s == ProxyDirect {
+		return nil, nil
+	}
+	u, err := url.Parse(s)
+	if err != nil || u.Host == "" {
+		return nil, fmt.Errorf("invalid proxy %q: want a URL like http://host:3128 or socks5://host:1080", s)
+	}
+	switch u.Scheme {
+	case "http", "https", "socks5", "socks5h":
+		return u, nil
+	}
+	return nil, fmt.Errorf("invalid proxy %q: scheme must be http, https or socks5", s)
+}
+
+// proxyFunc uses the configured proxy, then a PAC script when one is
+// configured, and the environment otherwise or when the script can't be
+// loaded
+func proxyFunc(cfg Config) func(*http.Request) (*url.URL, error) {
+	if cfg.Proxy != "" {
+		u, err := ParseProxy(cfg.Proxy)
+		if err != nil || u == nil {
+			return nil
+		}
+		return http.ProxyURL(u)
+	}
+	r := pacResolver(cfg)
+	if r == nil {
+		return http.ProxyFromEnvironment
+	}
+	return r.Proxy
+}
+
+func pacResolver(cfg Config) *pac.Resolver {
+	if cfg.Proxy != "" || cfg.PAC == "" && !cfg.WPAD {
+		return nil
+	}
+	pacMu.Lock()
+	defer pacMu.Unlock()
+	r, ok := pacResolvers[cfg.PAC]
+	if !ok {
+		r = &pac.Resolver{Location: cfg.PAC, Fallback: http.ProxyFromEnvironment}
+		pacResolvers[cfg.PAC] = r
+	}
+	return r
+}
+
+// ProxyError reports why the configured PAC script isn't in use, so the
+// CLI can warn before downloads silently go direct
+func ProxyError(cfg Config) error {
+	if r := pacResolver(cfg); r != nil {
+		return r.Err()
+	}
+	return nil
+}
@@ -0,0 +1,27 @@
+package downloader
+
+import (
+	"context"
+	"errors"
+)
+
+// errRangeIgnored means a part past the start got the whole file back, the
+// server advertised ranges but doesn't honor them. The download starts over
+// in one connection instead of fetching the whole file once per part.
+var errRangeIgnored = errors.New("server ignored the range request")
+
+// fallBackToOneConnection prepares the run after one that failed with
+// errRangeIgnored, and reports whether there is one. A --range window or a
+// stream that already went out can't start over.
+func (e *Engine) fallBackToOneConnection(ctx context.Context, err error) bool {
+	if !errors.Is(err, errRangeIgnored) || e.noRanges || ctx.Err() != nil ||
+		e.Config.Range != nil || e.Config.Stream != nil {
+		return false
+	}
+	e.warn("the server ignores range requests, downloading over one connection")
+	e.noRanges = true
+	e.dropPartial()
+	e.Parts, e.journal = nil, nil
+	e.Stats.SetDownloaded(0)
+	return true
+}
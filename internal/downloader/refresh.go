@@ -0,0 +1,85 @@
+package downloader
+
+import (
+	"context"
+	"errors"
+	"net/http"
+)
+
+// Signed CDN URLs expire, often mid-download. With Config.RefreshURL a part
+// whose URL stops working asks for a fresh one, once for all parts, and
+// every part carries on its range from it. The resume state keeps the
+// URL the download was started with.
+
+// Refreshes a part asks for in a row without progress before the answer
+// counts as a failure
+const maxRefreshes = 3
+
+// expired reports whether err is how servers turn away an expired signed
+// URL
+func expired(err error) bool {
+	var se *StatusError
+	if !errors.As(err, &se) {
+		return false
+	}
+	switch se.Code {
+	case http.StatusUnauthorized, http.StatusForbidden, http.StatusGone:
+		return true
+	}
+	return false
+}
+
+// currentURL is Config.URL, or what RefreshURL replaced it with
+func (e *Engine) currentURL() string {
+	e.urlMu.Lock()
+	defer e.urlMu.Unlock()
+	if e.refreshed != "" {
+		return e.refreshed
+	}
+	return e.Config.URL
+}
+
+// isValidatorURL reports whether the probe's validators apply to url
+func (e *Engine) isValidatorURL(url string) bool {
+	e.urlMu.Lock()
+	defer e.urlMu.Unlock()
+	return url == e.validatorURL
+}
+
+// refreshURL replaces the URL src turned out expired, unless another part
+// already did, and reports whether there is a new one to try. Mirrors
+// aren't refreshed.
+func (e *Engine) refreshURL(ctx context.Context, src string) bool {
+	if e.Config.RefreshURL == nil {
+		return false
+	}
+	e.urlMu.Lock()
+	defer e.urlMu.Unlock()
+	cur := e.Config.URL
+	if e.refreshed != "" {
+		cur = e.refreshed
+	}
+	if src != cur {
+		return e.replaced[src]
+	}
+
+	fresh, err := e.Config.RefreshURL(ctx, cur)
+	if err == nil && (fresh == "" || fresh == cur) {
+		err = errors.New("got the same URL back")
+	}
+	if err != nil {
+		e.warn("failed to refresh the expired URL: %v", err)
+		return false
+	}
+	e.trace("the URL expired, continuing from a fresh one")
+	if e.replaced == nil {
+		e.replaced = map[string]bool{}
+	}
+	e.replaced[cur] = true
+	if e.validatorURL == cur {
+		// Still the same file, the validators keep checking that
+		e.validatorURL = fresh
+	}
+	e.refreshed = fresh
+	return true
+}
@@ -0,0 +1,177 @@
+package downloader
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"os"
+	"path/filepath"
+	"regexp"
+	"strings"
+	"sync"
+)
+
+// registryAuth answers a registry's 401 challenges: Bearer with a token
+// from the registry's token service, anonymous or with the credentials of
+// docker login, or Basic with those credentials. One token serves every
+// request of the image and is fetched again when the registry turns it
+// down, as it does once it expires mid-pull.
+type registryAuth struct {
+	registry string // host[:port] the challenges come from
+	scope    string // repository:name:pull
+
+	mu    sync.Mutex
+	authz string // Authorization for the registry, "" before the first challenge
+}
+
+func (a *registryAuth) wrap(next http.RoundTripper) http.RoundTripper {
+	return &registryTransport{base: next, auth: a}
+}
+
+func (a *registryAuth) header() string {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	return a.authz
+}
+
+type registryTransport struct {
+	base http.RoundTripper
+	auth *registryAuth
+}
+
+func (t *registryTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	// Blobs redirect to storage elsewhere, which must not see the token
+	if req.URL.Host != t.auth.registry {
+		return t.base.RoundTrip(req)
+	}
+	sent := t.auth.header()
+	resp, err := t.base.RoundTrip(withAuthorization(req, sent))
+	if err != nil || resp.StatusCode != http.StatusUnauthorized || (req.Body != nil && req.Body != http.NoBody) {
+		return resp, err
+	}
+	challenge := resp.Header.Get("Www-Authenticate")
+	resp.Body.Close()
+	if err := t.auth.authorize(req, t.base, challenge, sent); err != nil {
+		return nil, err
+	}
+	return t.base.RoundTrip(withAuthorization(req, t.auth.header()))
+}
+
+func withAuthorization(req *http.Request, authz string) *http.Request {
+	if authz == "" {
+		return req
+	}
+	r := req.Clone(req.Context())
+	r.Header.Set("Authorization", authz)
+	return r
+}
+
+var challengeParam = regexp.MustCompile(`(\w+)="([^"]*)"`)
+
+// authorize gets the Authorization the challenge asks for, unless another
+// request already replaced the stale one it was sent with
+func (a *registryAuth) authorize(req *http.Request, rt http.RoundTripper, challenge, stale string) error {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	if a.authz != stale {
+		return nil
+	}
+	creds := dockerCredentials(a.registry)
+	scheme, params, _ := strings.Cut(challenge, " ")
+	switch strings.ToLower(scheme) {
+	case "basic":
+		if creds == "" {
+			return fmt.Errorf("registry %s needs a login, run docker login %[1]s", a.registry)
+		}
+		a.authz = "Basic " + creds
+		return nil
+	case "bearer":
+	default:
+		return fmt.Errorf("registry %s: unsupported authentication %q", a.registry, challenge)
+	}
+
+	p := map[string]string{}
+	for _, m := range challengeParam.FindAllStringSubmatch(params, -1) {
+		p[strings.ToLower(m[1])] = m[2]
+	}
+	realm, err := url.Parse(p["realm"])
+	if err != nil || realm.Host == "" {
+		return fmt.Errorf("registry %s: invalid token realm %q", a.registry, p["realm"])
+	}
+	q := realm.Query()
+	if p["service"] != "" {
+		q.Set("service", p["service"])
+	}
+	scope := p["scope"]
+	if scope == "" {
+		scope = a.scope
+	}
+	q.Set("scope", scope)
+	realm.RawQuery = q.Encode()
+
+	tokenReq, err := http.NewRequestWithContext(req.Context(), http.MethodGet, realm.String(), nil)
+	if err != nil {
+		return err
+	}
+	tokenReq.Header.Set("User-Agent", defaultUserAgent)
+	if creds != "" {
+		tokenReq.Header.Set("Authorization", "Basic "+creds)
+	}
+	resp, err := (&http.Client{Transport: rt}).Do(tokenReq)
+	if err != nil {
+		return fmt.Errorf("registry token: %w", err)
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return fmt.Errorf("registry token for %s: %w", scope, newStatusError(resp))
+	}
+	var token struct {
+		Token       string `json:"token"`
+		AccessToken string `json:"access_token"`
+	}
+	if err := json.NewDecoder(io.LimitReader(resp.Body, 1<<20)).Decode(&token); err != nil {
+		return fmt.Errorf("registry token: %w", err)
+	}
+	if token.Token == "" {
+		token.Token = token.AccessToken
+	}
+	if token.Token == "" {
+		return fmt.Errorf("registry %s sent no token", a.registry)
+	}
+	a.authz = "Bearer " + token.Token
+	return nil
+}
+
+// dockerCredentials returns the base64 user:password docker login saved
+// for the registry in config.json, "" for none. Credential helpers aren't
+// asked.
+func dockerCredentials(registry string) string {
+	dir := os.Getenv("DOCKER_CONFIG")
+	if dir == "" {
+		dir = ExpandHome("~/.docker")
+	}
+	data, err := os.ReadFile(filepath.Join(dir, "config.json"))
+	if err != nil {
+		return ""
+	}
+	var cfg struct {
+		Auths map[string]struct {
+			Auth string `json:"auth"`
+		} `json:"auths"`
+	}
+	if json.Unmarshal(data, &cfg) != nil {
+		return ""
+	}
+	keys := []string{registry, "https://" + registry, "http://" + registry}
+	if registry == "registry-1.docker.io" {
+		keys = append(keys, "https://index.docker.io/v1/", "index.docker.io", "docker.io")
+	}
+	for _, key := range keys {
+		if a, ok := cfg.Auths[key]; ok && a.Auth != "" {
+			return a.Auth
+		}
+	}
+	return ""
+}
@@ -0,0 +1,356 @@
+package downloader
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"os"
+	"path"
+	"strings"
+)
+
+// Release shorthands name a file attached to a GitHub or GitLab release:
+// gh:owner/repo@tag/asset and gl:group/project@tag/asset, or without
+// @tag for the latest release. The asset may be a glob like
+// app_*_linux_amd64.tar.gz, for names that carry the version.
+// GITHUB_TOKEN (or GH_TOKEN) and GITLAB_TOKEN authenticate the API calls
+// and the downloads of private assets; GITHUB_API_URL and GITLAB_HOST point
+// them at GitHub Enterprise and self-hosted GitLab.
+
+// IsRelease reports whether s is a release shorthand
+func IsRelease(s string) bool {
+	return strings.HasPrefix(s, "gh:") || strings.HasPrefix(s, "gl:")
+}
+
+// ReleaseAsset is the file a release shorthand names
+type ReleaseAsset struct {
+	Repo         string
+	Tag          string
+	Name         string
+	URL          string
+	Header       http.Header // For requests to the URL's host, the token of private assets
+	Checksum     *Checksum   // The published digest, nil when the release has none
+	ChecksumFrom string      // GitHub, or the checksum file of the release it is from
+}
+
+// Config returns base set up to download the asset
+func (a ReleaseAsset) Config(base Config) Config {
+	cfg := base
+	cfg.URL = a.URL
+	cfg.Mirrors = nil
+	if a.Checksum != nil {
+		cfg.Checksum = a.Checksum
+	}
+	if u, err := url.Parse(a.URL); err == nil && len(a.Header) > 0 {
+		// Host headers replace the common ones rather than adding to them
+		h := cfg.Headers.Clone()
+		if h == nil {
+			h = http.Header{}
+		}
+		for name, values := range a.Header {
+			h[name] = values
+		}
+		hosts := map[string]http.Header{u.Hostname(): h}
+		for domain, set := range cfg.HostHeaders {
+			if _, ok := hosts[domain]; !ok {
+				hosts[domain] = set
+			}
+		}
+		cfg.HostHeaders = hosts
+	}
+	return cfg
+}
+
+// release is what the forges' APIs say about a release
+type release struct {
+	Tag    string
+	Assets []releaseFile
+	host   string      // The API's host and port
+	header http.Header // Authenticates the API, and the assets served from its host
+}
+
+// headerFor returns the release's header for requests to rawURL, nil for
+// other hosts than the API's, which must not see the token
+func (r release) headerFor(rawURL string) http.Header {
+	if hostOf(rawURL) != r.host {
+		return nil
+	}
+	return r.header
+}
+
+type releaseFile struct {
+	Name   string
+	URL    string
+	Digest string // "sha256:hex", GitHub's
+}
+
+var errNoRelease = errors.New("no such release")
+
+// ResolveRelease looks up the asset a release shorthand names, in the
+// latest release when latest is set instead of a tag
+func ResolveRelease(ctx context.Context, client *http.Client, spec string, latest bool) (ReleaseAsset, error) {
+	forge, repo, tag, pattern, err := parseRelease(spec)
+	if err != nil {
+		return ReleaseAsset{}, err
+	}
+	switch {
+	case tag == "" && !latest:
+		return ReleaseAsset{}, fmt.Errorf("%s names no release: add @tag after the repository, or --latest", spec)
+	case tag != "" && latest:
+		return ReleaseAsset{}, fmt.Errorf("%s names release %s, drop it or --latest", spec, tag)
+	}
+
+	var rel release
+	if forge == "gh" {
+		rel, err = githubRelease(ctx, client, repo, tag)
+	} else {
+		rel, err = gitlabRelease(ctx, client, repo, tag)
+	}
+	if errors.Is(err, errNoRelease) {
+		tokens := "GITHUB_TOKEN"
+		if forge == "gl" {
+			tokens = "GITLAB_TOKEN"
+		}
+		if tag == "" {
+			tag = "latest"
+		}
+		return ReleaseAsset{}, fmt.Errorf("%s has no release %s, or is private and %s isn't set", repo, tag, tokens)
+	}
+	if err != nil {
+		return ReleaseAsset{}, err
+	}
+
+	file, err := pickAsset(rel, pattern)
+	if err != nil {
+		return ReleaseAsset{}, err
+	}
+	a := ReleaseAsset{Repo: repo, Tag: rel.Tag, Name: file.Name, URL: file.URL, Header: rel.headerFor(file.URL)}
+	a.Checksum, a.ChecksumFrom, err = releaseChecksum(ctx, client, rel, file)
+	return a, err
+}
+
+// parseRelease splits forge:repo[@tag]/asset. Without a tag the asset is
+// the last path element, GitLab projects may be in nested groups.
+func parseRelease(spec string) (forge, repo, tag, asset string, err error) {
+	forge, rest, _ := strings.Cut(spec, ":")
+	if before, after, ok := strings.Cut(rest, "@"); ok {
+		repo = before
+		tag, asset, _ = strings.Cut(after, "/")
+	} else if i := strings.LastIndex(rest, "/"); i >= 0 {
+		repo, asset = rest[:i], rest[i+1:]
+	}
+	parts := strings.Split(repo, "/")
+	if asset == "" || len(parts) < 2 || (forge == "gh" && len(parts) != 2) || strings.Contains(repo, "//") || strings.Contains(asset, "/") {
+		return "", "", "", "", fmt.Errorf("invalid release %q: want %s:%s@tag/asset", spec, forge, map[string]string{"gh": "owner/repo", "gl": "group/project"}[forge])
+	}
+	return forge, repo, tag, asset, nil
+}
+
+func githubAPI() string {
+	if api := os.Getenv("GITHUB_API_URL"); api != "" {
+		return strings.TrimSuffix(api, "/")
+	}
+	return "https://api.github.com"
+}
+
+func gitlabURL() string {
+	host := os.Getenv("GITLAB_HOST")
+	if host == "" {
+		host = "gitlab.com"
+	}
+	if !strings.Contains(host, "://") {
+		host = "https://" + host
+	}
+	return strings.TrimSuffix(host, "/")
+}
+
+func githubRelease(ctx context.Context, client *http.Client, repo, tag string) (release, error) {
+	rel := release{host: hostOf(githubAPI()), header: http.Header{
+		"Accept":               {"application/vnd.github+json"},
+		"X-Github-Api-Version": {"2022-11-28"},
+	}}
+	token := os.Getenv("GITHUB_TOKEN")
+	if token == "" {
+		token = os.Getenv("GH_TOKEN")
+	}
+	if token != "" {
+		rel.header.Set("Authorization", "Bearer "+token)
+	}
+	endpoint := githubAPI() + "/repos/" + repo + "/releases/latest"
+	if tag != "" {
+		endpoint = githubAPI() + "/repos/" + repo + "/releases/tags/" + url.PathEscape(tag)
+	}
+
+	var r struct {
+		TagName string `json:"tag_name"`
+		Assets  []struct {
+			Name               string `json:"name"`
+			URL                string `json:"url"`
+			BrowserDownloadURL string `json:"browser_download_url"`
+			Digest             string `json:"digest"`
+		} `json:"assets"`
+	}
+	if err := getReleaseJSON(ctx, client, endpoint, rel.header, &r); err != nil {
+		return release{}, err
+	}
+	rel.Tag = r.TagName
+	for _, a := range r.Assets {
+		f := releaseFile{Name: a.Name, URL: a.BrowserDownloadURL, Digest: a.Digest}
+		if token != "" {
+			// Private assets only come from the API, which redirects to
+			// the file for this Accept
+			f.URL = a.URL
+		}
+		rel.Assets = append(rel.Assets, f)
+	}
+	if token != "" {
+		rel.header = http.Header{"Authorization": {"Bearer " + token}, "Accept": {"application/octet-stream"}}
+	} else {
+		rel.header = nil
+	}
+	return rel, nil
+}
+
+func gitlabRelease(ctx context.Context, client *http.Client, project, tag string) (release, error) {
+	// A bearer token rather than PRIVATE-TOKEN, which redirects to other
+	// hosts would carry along
+	rel := release{host: hostOf(gitlabURL())}
+	if token := os.Getenv("GITLAB_TOKEN"); token != "" {
+		rel.header = http.Header{"Authorization": {"Bearer " + token}}
+	}
+	endpoint := gitlabURL() + "/api/v4/projects/" + url.PathEscape(project) + "/releases/permalink/latest"
+	if tag != "" {
+		endpoint = gitlabURL() + "/api/v4/projects/" + url.PathEscape(project) + "/releases/" + url.PathEscape(tag)
+	}
+
+	var r struct {
+		TagName string `json:"tag_name"`
+		Assets  struct {
+			Links []struct {
+				Name string `json:"name"`
+				URL  string `json:"url"`
+			} `json:"links"`
+		} `json:"assets"`
+	}
+	if err := getReleaseJSON(ctx, client, endpoint, rel.header, &r); err != nil {
+		return release{}, err
+	}
+	rel.Tag = r.TagName
+	for _, l := range r.Assets.Links {
+		rel.Assets = append(rel.Assets, releaseFile{Name: l.Name, URL: l.URL})
+	}
+	return rel, nil
+}
+
+// pickAsset finds the asset by name, or the one asset the glob matches
+func pickAsset(rel release, pattern string) (releaseFile, error) {
+	var matches []releaseFile
+	var names []string
+	for _, f := range rel.Assets {
+		if f.Name == pattern {
+			return f, nil
+		}
+		if ok, _ := path.Match(pattern, f.Name); ok {
+			matches = append(matches, f)
+		}
+		names = append(names, f.Name)
+	}
+	switch {
+	case len(matches) == 1:
+		return matches[0], nil
+	case len(matches) > 1:
+		names = names[:0]
+		for _, f := range matches {
+			names = append(names, f.Name)
+		}
+		return releaseFile{}, fmt.Errorf("%q matches several assets of %s: %s", pattern, rel.Tag, strings.Join(names, ", "))
+	case len(names) == 0:
+		return releaseFile{}, fmt.Errorf("release %s has no assets", rel.Tag)
+	}
+	return releaseFile{}, fmt.Errorf("release %s has no asset %q, it has %s", rel.Tag, pattern, strings.Join(names, ", "))
+}
+
+// releaseChecksum returns the digest GitHub publishes for the asset or,
+// without one, the asset's entry in a checksum file of the release, like
+// name.sha256, SHA256SUMS or app_1.2.3_checksums.txt
+func releaseChecksum(ctx context.Context, client *http.Client, rel release, file releaseFile) (*Checksum, string, error) {
+	if file.Digest != "" {
+		sum, err := ParseChecksum(file.Digest)
+		if err != nil {
+			return nil, "", fmt.Errorf("digest of %s: %w", file.Name, err)
+		}
+		return sum, "GitHub", nil
+	}
+	for _, f := range rel.Assets {
+		name, lower := f.Name, strings.ToLower(f.Name)
+		switch {
+		case strings.HasPrefix(f.Name, file.Name+"."):
+			name = strings.TrimPrefix(f.Name, file.Name)
+			if !isSidecarName(name) {
+				continue
+			}
+		case strings.Contains(lower, "checksums") || strings.Contains(lower, "sha256sums") || strings.Contains(lower, "sha512sums"):
+		default:
+			continue
+		}
+		data, err := getRelease(ctx, client, f.URL, rel.headerFor(f.URL), 4<<20)
+		if err != nil {
+			if ctx.Err() != nil {
+				return nil, "", ctx.Err()
+			}
+			continue
+		}
+		if sum := sidecarSum(data, name, "/"+file.Name, file.Name); sum != nil {
+			return sum, f.Name, nil
+		}
+	}
+	return nil, "", nil
+}
+
+func isSidecarName(name string) bool {
+	for _, n := range sidecarNames {
+		if n == name {
+			return true
+		}
+	}
+	return false
+}
+
+func getReleaseJSON(ctx context.Context, client *http.Client, endpoint string, header http.Header, v any) error {
+	data, err := getRelease(ctx, client, endpoint, header, 32<<20)
+	if err != nil {
+		return err
+	}
+	if err := json.Unmarshal(data, v); err != nil {
+		return fmt.Errorf("%s: %w", endpoint, err)
+	}
+	return nil
+}
+
+// getRelease reads an API answer or a checksum file
+func getRelease(ctx context.Context, client *http.Client, rawURL string, header http.Header, limit int64) ([]byte, error) {
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, rawURL, nil)
+	if err != nil {
+		return nil, err
+	}
+	for name, values := range header {
+		req.Header[name] = values
+	}
+	req.Header.Set("User-Agent", defaultUserAgent)
+	resp, err := client.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode == http.StatusNotFound {
+		return nil, errNoRelease
+	}
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("%s: %w", rawURL, newStatusError(resp))
+	}
+	return io.ReadAll(io.LimitReader(resp.Body, limit))
+}
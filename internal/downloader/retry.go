@@ -0,0 +1,163 @@
+package downloader
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"math/rand"
+	"net"
+	"net/http"
+	"strconv"
+	"strings"
+	"sync/atomic"
+	"time"
+)
+
+// RetryPolicy decides how often and how patiently failures are retried.
+// It applies to every part and, once the parts gave up, to the download as
+// a whole, which then carries on from its resume state.
+type RetryPolicy struct {
+	Retries int           // Attempts after the first one
+	Wait    time.Duration // Backoff before the first retry, doubled for each one after
+	MaxWait time.Duration // Cap on the backoff
+	On      RetryOn
+}
+
+// RetryOn lists the failures worth another attempt. Errors it can't
+// classify, like a truncated body, are retried per part but don't restart
+// the download.
+type RetryOn struct {
+	Codes   []int // HTTP statuses, e.g. 429
+	Classes []int // Status classes, 5 for 5xx
+	Reset   bool  // Refused, reset or cut off connections
+	Timeout bool  // Timeouts connecting or reading
+}
+
+// DefaultRetry is used when Config.Retry is nil
+var DefaultRetry = RetryPolicy{
+	Retries: 3,
+	Wait:    time.Second,
+	MaxWait: 30 * time.Second,
+	On:      RetryOn{Codes: []int{408, 429}, Classes: []int{5}, Reset: true, Timeout: true},
+}
+
+// StatusError is an HTTP response the download can't use
+type StatusError struct {
+	Code       int
+	Status     string
+	RetryAfter time.Duration // The wait a 429 or 503 asked for, 0 without one
+}
+
+func newStatusError(resp *http.Response) *StatusError {
+	return &StatusError{Code: resp.StatusCode, Status: resp.Status, RetryAfter: retryAfter(resp)}
+}
+
+func (e *StatusError) Error() string {
+	if e.RetryAfter > 0 {
+		return fmt.Sprintf("server returned unexpected status: %s (retry after %s)", e.Status, e.RetryAfter)
+	}
+	return "server returned unexpected status: " + e.Status
+}
+
+// ParseRetryOn parses the --retry-on list, e.g. "5xx,429,reset,timeout"
+func ParseRetryOn(s string) (RetryOn, error) {
+	var on RetryOn
+	for _, f := range strings.Split(s, ",") {
+		switch f = strings.ToLower(strings.TrimSpace(f)); {
+		case f == "":
+		case f == "reset":
+			on.Reset = true
+		case f == "timeout":
+			on.Timeout = true
+		case len(f) == 3 && strings.HasSuffix(f, "xx") && f[0] >= '1' && f[0] <= '5':
+			on.Classes = append(on.Classes, int(f[0]-'0'))
+		default:
+			code, err := strconv.Atoi(f)
+			if err != nil || code < 100 || code > 599 {
+				return RetryOn{}, fmt.Errorf("invalid --retry-on %q: want status codes, classes like 5xx, reset or timeout", f)
+			}
+			on.Codes = append(on.Codes, code)
+		}
+	}
+	return on, nil
+}
+
+func (c Config) retryPolicy() RetryPolicy {
+	if c.Retry != nil {
+		return *c.Retry
+	}
+	return DefaultRetry
+}
+
+// classify reports whether err is one of the failures in the list, and
+// whether it could tell at all
+func (on RetryOn) classify(err error) (retry, known bool) {
+	var se *StatusError
+	if errors.As(err, &se) {
+		for _, c := range on.Codes {
+			if c == se.Code {
+				return true, true
+			}
+		}
+		for _, c := range on.Classes {
+			if c == se.Code/100 {
+				return true, true
+			}
+		}
+		return false, true
+	}
+	var ne net.Error
+	if errors.Is(err, ErrStalled) {
+		return on.Timeout, true
+	}
+	if (errors.As(err, &ne) && ne.Timeout()) || errors.Is(err, context.DeadlineExceeded) {
+		return on.Timeout, true
+	}
+	if isConnReset(err) || errors.Is(err, io.ErrUnexpectedEOF) {
+		return on.Reset, true
+	}
+	return false, false
+}
+
+// retryPart decides for a failed part, which may also have hit bad data
+func (p RetryPolicy) retryPart(err error) bool {
+	retry, known := p.On.classify(err)
+	return retry || !known
+}
+
+// retryDownload decides for a failed download, only for listed failures
+func (p RetryPolicy) retryDownload(err error) bool {
+	retry, _ := p.On.classify(err)
+	return retry
+}
+
+// backoff is the wait before retry n (from 0): exponential, capped, and
+// jittered over its upper half so parts that failed together don't retry
+// in lockstep
+func (p RetryPolicy) backoff(n int) time.Duration {
+	d := p.Wait
+	for i := 0; i < n && d < p.MaxWait; i++ {
+		d *= 2
+	}
+	if p.MaxWait > 0 && d > p.MaxWait {
+		d = p.MaxWait
+	}
+	if d <= 1 {
+		return d
+	}
+	return d/2 + time.Duration(rand.Int63n(int64(d/2)))
+}
+
+// sleep waits out the backoff before retry n and counts the retry
+func (p RetryPolicy) sleep(ctx context.Context, n int) error {
+	atomic.AddInt64(&counts.Retries, 1)
+	t := time.NewTimer(p.backoff(n))
+	defer t.Stop()
+	select {
+	case <-ctx.Done():
+		return ctx.Err()
+	case <-t.C:
+		return nil
+	}
+}
@@ -0,0 +1,16 @@
+package downloader
+
+import (
+	"errors"
+
+	"golang.org/x/sys/windows"
+)
+
+// isConnReset reports whether the connection was refused, reset or
+// aborted by the other side
+func isConnReset(err error) bool {
+	return errors.Is(err, windows.WSAECONNRESET) ||
+		errors.Is(err, windows.WSAECONNREFUSED) ||
+		errors.Is(err, windows.WSAECONNABORTED) ||
+		errors.Is(err, windows.ERROR_BROKEN_PIPE)
+}
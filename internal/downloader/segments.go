@@ -0,0 +1,216 @@
+package downloader
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"sync"
+	"sync/atomic"
+)
+
+// mediaSegment is one independently fetchable piece of a stream
+type mediaSegment struct {
+	URI    string
+	Offset int64
+	Length int64 // -1 for the whole resource
+}
+
+// segmentFetcher downloads stream segments concurrently into temp files.
+// It is shared by the playlist based downloaders (HLS, DASH).
+type segmentFetcher struct {
+	client      *http.Client
+	stats       *Stats
+	concurrency int
+	retry       RetryPolicy
+	limiter     *Limiter
+
+	// transform optionally post-processes the body of segment i before it
+	// is written, e.g. for decryption
+	transform func(ctx context.Context, i int, data []byte) ([]byte, error)
+}
+
+// fetchAll downloads segs[i] into paths[i]
+func (f *segmentFetcher) fetchAll(ctx context.Context, segs []mediaSegment, paths []string) error {
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+
+	// The total is unknown until every segment arrived, so extrapolate it
+	// from the average size of the finished ones
+	var doneCount, doneBytes int64
+	estimate := func() {
+		n := atomic.LoadInt64(&doneCount)
+		if n == 0 {
+			return
+		}
+		total := atomic.LoadInt64(&doneBytes) / n * int64(len(segs))
+		if floor := f.stats.GetDownloaded() + 1; total < floor && n < int64(len(segs)) {
+			total = floor
+		}
+		f.stats.SetTotal(total)
+	}
+
+	workers := f.concurrency
+	if workers < 1 {
+		workers = 1
+	}
+	jobs := make(chan int)
+	errChan := make(chan error, len(segs))
+	var wg sync.WaitGroup
+
+	for w := 0; w < workers; w++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for i := range jobs {
+				n, err := f.fetchWithRetry(ctx, i, segs[i], paths[i])
+				if err != nil {
+					errChan <- err
+					cancel()
+					continue
+				}
+				atomic.AddInt64(&doneBytes, n)
+				atomic.AddInt64(&doneCount, 1)
+				estimate()
+			}
+		}()
+	}
+
+	for i := range segs {
+		select {
+		case jobs <- i:
+		case <-ctx.Done():
+		}
+	}
+	close(jobs)
+	wg.Wait()
+	close(errChan)
+
+	if len(errChan) > 0 {
+		for _, p := range paths {
+			os.Remove(p)
+		}
+		return <-errChan
+	}
+	f.stats.SetTotal(atomic.LoadInt64(&doneBytes))
+	return ctx.Err()
+}
+
+func (f *segmentFetcher) fetchWithRetry(ctx context.Context, i int, seg mediaSegment, path string) (int64, error) {
+	var err error
+	attempt := 0
+	for ; ; attempt++ {
+		var n int64
+		n, err = f.fetch(ctx, i, seg, path)
+		if err == nil {
+			return n, nil
+		}
+		f.stats.AddDownloaded(-n)
+		if ctx.Err() != nil {
+			return 0, ctx.Err()
+		}
+		if attempt >= f.retry.Retries || !f.retry.retryPart(err) {
+			break
+		}
+		if err := f.retry.sleep(ctx, attempt); err != nil {
+			return 0, err
+		}
+	}
+	return 0, fmt.Errorf("failed to download segment %d after %d attempts: %w", i, attempt+1, err)
+}
+
+// fetch returns the number of network bytes counted towards Stats so a
+// failed attempt can be rolled back
+func (f *segmentFetcher) fetch(ctx context.Context, i int, seg mediaSegment, path string) (int64, error) {
+	req, err := http.NewRequestWithContext(ctx, "GET", seg.URI, nil)
+	if err != nil {
+		return 0, err
+	}
+	req.Header.Set("User-Agent", defaultUserAgent)
+	if seg.Length >= 0 {
+		req.Header.Set("Range", fmt.Sprintf("bytes=%d-%d", seg.Offset, seg.Offset+seg.Length-1))
+	}
+
+	resp, err := f.client.Do(req)
+	if err != nil {
+		return 0, err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK && resp.StatusCode != http.StatusPartialContent {
+		return 0, newStatusError(resp)
+	}
+
+	var buf bytes.Buffer
+	n, err := io.Copy(&buf, &countingReader{r: limitBody(ctx, f.limiter, resp.Body), stats: f.stats})
+	if err != nil {
+		return n, err
+	}
+
+	data := buf.Bytes()
+	if f.transform != nil {
+		if data, err = f.transform(ctx, i, data); err != nil {
+			return n, err
+		}
+	}
+	return n, os.WriteFile(path, data, 0o644)
+}
+
+// fetchBody GETs a small resource such as a playlist or key into memory
+func fetchBody(ctx context.Context, client *http.Client, uri string) ([]byte, error) {
+	req, err := http.NewRequestWithContext(ctx, "GET", uri, nil)
+	if err != nil {
+		return nil, err
+	}
+	req.Header.Set("User-Agent", defaultUserAgent)
+
+	resp, err := client.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		return nil, newStatusError(resp)
+	}
+	return io.ReadAll(resp.Body)
+}
+
+// concatFiles writes the given files into dst in order and removes them
+func concatFiles(dst string, paths []string) error {
+	out, err := os.Create(dst)
+	if err != nil {
+		return err
+	}
+	defer out.Close()
+
+	for _, p := range paths {
+		in, err := os.Open(p)
+		if err != nil {
+			return err
+		}
+		_, err = io.Copy(out, in)
+		in.Close()
+		if err != nil {
+			return err
+		}
+		os.Remove(p)
+	}
+	return nil
+}
+
+// countingReader reports bytes to Stats as they are read
+type countingReader struct {
+	r     io.Reader
+	stats *Stats
+}
+
+func (c *countingReader) Read(p []byte) (int, error) {
+	n, err := c.r.Read(p)
+	if n > 0 {
+		c.stats.AddDownloaded(int64(n))
+	}
+	return n, err
+}
@@ -0,0 +1,245 @@
+package downloader
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"net"
+	"net/url"
+	"os"
+	"path/filepath"
+	"sync"
+	"time"
+
+	"github.com/pkg/sftp"
+	"golang.org/x/crypto/ssh"
+	"golang.org/x/crypto/ssh/agent"
+	"golang.org/x/crypto/ssh/knownhosts"
+)
+
+func init() {
+	RegisterFetcher("sftp", func(cfg Config) Fetcher { return newSFTPFetcher(cfg) })
+}
+
+// sftpFetcher reads ranges of a file over SFTP. A single SSH connection is
+// shared by all parts, SFTP multiplexes concurrent reads over it.
+type sftpFetcher struct {
+	cfg Config
+
+	once   sync.Once
+	err    error
+	conn   *ssh.Client
+	client *sftp.Client
+	path   string
+}
+
+func newSFTPFetcher(cfg Config) *sftpFetcher {
+	return &sftpFetcher{cfg: cfg}
+}
+
+func (s *sftpFetcher) connect(ctx context.Context) error {
+	s.once.Do(func() {
+		s.err = s.dial(ctx)
+	})
+	return s.err
+}
+
+func (s *sftpFetcher) dial(ctx context.Context) error {
+	if s.cfg.Tor {
+		// SSH doesn't go through the SOCKS proxy
+		return fmt.Errorf("%w: sftp:// connects directly", ErrNotTor)
+	}
+	u, err := url.Parse(s.cfg.URL)
+	if err != nil {
+		return err
+	}
+	s.path = u.Path
+
+	user := u.User.Username()
+	if user == "" {
+		user = os.Getenv("USER")
+	}
+
+	auths, password, err := s.authMethods(u)
+	if err != nil {
+		return err
+	}
+
+	host := u.Host
+	if u.Port() == "" {
+		host = net.JoinHostPort(u.Hostname(), "22")
+	}
+
+	hostKeys, err := hostKeyCallback(password)
+	if err != nil {
+		return err
+	}
+
+	d := net.Dialer{Timeout: 30 * time.Second, KeepAlive: 30 * time.Second}
+	raw, err := d.DialContext(ctx, "tcp", host)
+	if err != nil {
+		return err
+	}
+	sshConn, chans, reqs, err := ssh.NewClientConn(raw, host, &ssh.ClientConfig{
+		User:            user,
+		Auth:            auths,
+		HostKeyCallback: hostKeys,
+		Timeout:         30 * time.Second,
+	})
+	if err != nil {
+		raw.Close()
+		return fmt.Errorf("ssh handshake with %s failed: %w", host, err)
+	}
+	s.conn = ssh.NewClient(sshConn, chans, reqs)
+
+	s.client, err = sftp.NewClient(s.conn, sftp.UseConcurrentReads(true))
+	if err != nil {
+		s.conn.Close()
+		return fmt.Errorf("failed to start sftp session: %w", err)
+	}
+	return nil
+}
+
+// authMethods tries, in order: the SSH agent, --ssh-key (or the default
+// identities in ~/.ssh), and a password from the URL or WARP_DL_SSH_PASSWORD.
+// password tells whether the last is among them.
+func (s *sftpFetcher) authMethods(u *url.URL) (methods []ssh.AuthMethod, password bool, err error) {
+	if sock := os.Getenv("SSH_AUTH_SOCK"); sock != "" {
+		if conn, err := net.Dial("unix", sock); err == nil {
+			methods = append(methods, ssh.PublicKeysCallback(agent.NewClient(conn).Signers))
+		}
+	}
+
+	keyFiles := []string{s.cfg.SSHKey}
+	if s.cfg.SSHKey == "" {
+		keyFiles = nil
+		if home, err := os.UserHomeDir(); err == nil {
+			for _, name := range []string{"id_ed25519", "id_ecdsa", "id_rsa"} {
+				keyFiles = append(keyFiles, filepath.Join(home, ".ssh", name))
+			}
+		}
+	}
+	var signers []ssh.Signer
+	for _, path := range keyFiles {
+		signer, err := loadSSHKey(path)
+		if err != nil {
+			if s.cfg.SSHKey != "" {
+				return nil, false, err // Explicitly requested, so it must work
+			}
+			continue
+		}
+		signers = append(signers, signer)
+	}
+	if len(signers) > 0 {
+		methods = append(methods, ssh.PublicKeys(signers...))
+	}
+
+	secret, ok := u.User.Password()
+	if !ok {
+		secret, ok = os.LookupEnv("WARP_DL_SSH_PASSWORD")
+	}
+	if ok {
+		methods = append(methods,
+			ssh.Password(secret),
+			ssh.KeyboardInteractive(func(_, _ string, questions []string, _ []bool) ([]string, error) {
+				answers := make([]string, len(questions))
+				for i := range answers {
+					answers[i] = secret
+				}
+				return answers, nil
+			}))
+	}
+
+	if len(methods) == 0 {
+		return nil, false, fmt.Errorf("no SSH credentials: start an agent, pass --ssh-key or set WARP_DL_SSH_PASSWORD")
+	}
+	return methods, ok, nil
+}
+
+func loadSSHKey(path string) (ssh.Signer, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	signer, err := ssh.ParsePrivateKey(data)
+	if _, ok := err.(*ssh.PassphraseMissingError); ok {
+		passphrase := os.Getenv("WARP_DL_SSH_PASSPHRASE")
+		if passphrase == "" {
+			return nil, fmt.Errorf("%s is encrypted, set WARP_DL_SSH_PASSPHRASE", path)
+		}
+		return ssh.ParsePrivateKeyWithPassphrase(data, []byte(passphrase))
+	}
+	if err != nil {
+		return nil, fmt.Errorf("invalid SSH key %s: %w", path, err)
+	}
+	return signer, nil
+}
+
+// hostKeyCallback checks ~/.ssh/known_hosts when it exists. Without it
+// hosts are accepted unverified for key authentication, whose signatures
+// are no use to a man in the middle, but refused when a password would go
+// to them.
+func hostKeyCallback(password bool) (ssh.HostKeyCallback, error) {
+	path := ""
+	if home, err := os.UserHomeDir(); err == nil {
+		path = filepath.Join(home, ".ssh", "known_hosts")
+	}
+	if _, err := os.Stat(path); err == nil {
+		return knownhosts.New(path)
+	}
+	if !password {
+		return ssh.InsecureIgnoreHostKey(), nil
+	}
+	return func(host string, _ net.Addr, _ ssh.PublicKey) error {
+		return fmt.Errorf("no ~/.ssh/known_hosts to verify %s with, not sending it the password: connect once with ssh to add its key", host)
+	}, nil
+}
+
+func (s *sftpFetcher) Capabilities() Capabilities {
+	return Capabilities{Protocol: "sftp"}
+}
+
+func (s *sftpFetcher) Probe(ctx context.Context) (int64, bool, error) {
+	if err := s.connect(ctx); err != nil {
+		return 0, false, err
+	}
+	info, err := s.client.Stat(s.path)
+	if err != nil {
+		return 0, false, err
+	}
+	if info.IsDir() {
+		return 0, false, fmt.Errorf("%s is a directory", s.path)
+	}
+	// Any offset can be read, so SFTP is always resumable
+	return info.Size(), true, nil
+}
+
+func (s *sftpFetcher) FetchRange(ctx context.Context, _ string, start, end int64) (io.ReadCloser, error) {
+	if err := s.connect(ctx); err != nil {
+		return nil, err
+	}
+	f, err := s.client.Open(s.path)
+	if err != nil {
+		return nil, err
+	}
+	if _, err := f.Seek(start, io.SeekStart); err != nil {
+		f.Close()
+		return nil, err
+	}
+	return &limitedReadCloser{Reader: io.LimitReader(f, end-start+1), Closer: f}, nil
+}
+
+func (s *sftpFetcher) Close() error {
+	if s.client != nil {
+		s.client.Close()
+	}
+	if s.conn != nil {
+		return s.conn.Close()
+	}
+	return nil
+}
+
+type limitedReadCloser struct {
+	io.Reader
+	io.Closer
+}
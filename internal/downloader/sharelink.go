@@ -0,0 +1,209 @@
+package downloader
+
+import (
+	"encoding/base64"
+	"errors"
+	"html"
+	"io"
+	"net/http"
+	"net/url"
+	"regexp"
+	"strings"
+	"sync"
+)
+
+// Share links of cloud drives (Google Drive, Dropbox, OneDrive and
+// SharePoint) open a web page rather than the file. They are rewritten to
+// the drives' direct download URLs, and Google Drive's warning page for
+// files too large to scan for viruses is confirmed by driveTransport, once
+// for all requests of the download.
+
+// shareLinkURL returns the direct download URL for a share link
+func shareLinkURL(raw string) (string, bool) {
+	u, err := url.Parse(raw)
+	if err != nil || (u.Scheme != "http" && u.Scheme != "https") {
+		return "", false
+	}
+	host := strings.ToLower(u.Hostname())
+	q := u.Query()
+	switch {
+	case host == "drive.google.com" || host == "docs.google.com":
+		id := driveFileID(u)
+		if id == "" {
+			return "", false
+		}
+		return "https://drive.usercontent.google.com/download?export=download&id=" + url.QueryEscape(id), true
+
+	case host == "dropbox.com" || host == "www.dropbox.com":
+		// Files at /s/ and /scl/fi/, folders at /sh/ and /scl/fo/ as a zip
+		shared := false
+		for _, prefix := range []string{"/s/", "/sh/", "/scl/fi/", "/scl/fo/"} {
+			shared = shared || strings.HasPrefix(u.Path, prefix)
+		}
+		if !shared || q.Get("dl") == "1" || q.Has("raw") {
+			return "", false
+		}
+		q.Set("dl", "1")
+		u.RawQuery = q.Encode()
+		return u.String(), true
+
+	case host == "onedrive.live.com" && (u.Path == "/redir" || u.Path == "/embed"):
+		u.Path = "/download"
+		return u.String(), true
+
+	case host == "1drv.ms" || host == "onedrive.live.com":
+		if u.Path == "/download" {
+			return "", false
+		}
+		// The shares API takes the link itself, base64url encoded
+		return "https://api.onedrive.com/v1.0/shares/u!" + base64.RawURLEncoding.EncodeToString([]byte(raw)) + "/root/content", true
+
+	case strings.HasSuffix(host, ".sharepoint.com"):
+		// Sharing links look like /:u:/g/personal/...
+		if !strings.HasPrefix(u.Path, "/:") || q.Get("download") == "1" {
+			return "", false
+		}
+		q.Set("download", "1")
+		u.RawQuery = q.Encode()
+		return u.String(), true
+	}
+	return "", false
+}
+
+// driveFileID is the file ID of /file/d/ID/view, /open?id=ID and /uc?id=ID
+// links
+func driveFileID(u *url.URL) string {
+	if rest, ok := strings.CutPrefix(u.Path, "/file/d/"); ok {
+		id, _, _ := strings.Cut(rest, "/")
+		return id
+	}
+	if u.Path == "/open" || u.Path == "/uc" {
+		return u.Query().Get("id")
+	}
+	return ""
+}
+
+func isDriveDownload(u *url.URL) bool {
+	return strings.EqualFold(u.Hostname(), "drive.usercontent.google.com")
+}
+
+// errDriveNotShared is a Drive page without a confirmation form
+var errDriveNotShared = errors.New("Google Drive sent a page instead of the file: it isn't shared with anyone with the link, or its download quota is exceeded for today")
+
+// driveTransport confirms Google Drive's virus scan warning. The warning
+// page is a form submitting to the URL that serves the file, which the
+// requests of every part are sent to from then on.
+type driveTransport struct {
+	base http.RoundTripper
+
+	mu        sync.Mutex
+	confirmed map[string]*url.URL // Download URLs to their confirmed URL
+}
+
+func (t *driveTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	if !isDriveDownload(req.URL) {
+		return t.base.RoundTrip(req)
+	}
+	orig := req.URL.String()
+	t.mu.Lock()
+	confirmed := t.confirmed[orig]
+	t.mu.Unlock()
+	if confirmed != nil {
+		return t.base.RoundTrip(withURL(req, confirmed))
+	}
+
+	resp, err := t.base.RoundTrip(req)
+	if err != nil || !isDrivePage(resp) {
+		return resp, err
+	}
+	if req.Method != http.MethodGet || req.Header.Get("Range") != "" {
+		// Read the page whole, whatever was asked for
+		resp.Body.Close()
+		page := withURL(req, req.URL)
+		page.Method = http.MethodGet
+		page.Header.Del("Range")
+		if resp, err = t.base.RoundTrip(page); err != nil {
+			return nil, err
+		}
+		if !isDrivePage(resp) {
+			return resp, nil
+		}
+	}
+	defer resp.Body.Close()
+	body, err := io.ReadAll(io.LimitReader(resp.Body, 1<<20))
+	if err != nil {
+		return nil, err
+	}
+	confirmed, err = driveConfirmURL(req.URL, body)
+	if err != nil {
+		return nil, err
+	}
+
+	t.mu.Lock()
+	if t.confirmed == nil {
+		t.confirmed = map[string]*url.URL{}
+	}
+	t.confirmed[orig] = confirmed
+	t.mu.Unlock()
+	return t.base.RoundTrip(withURL(req, confirmed))
+}
+
+// isDrivePage tells Drive's own pages from shared files that are HTML,
+// which come as attachments
+func isDrivePage(resp *http.Response) bool {
+	return resp.StatusCode == http.StatusOK &&
+		strings.HasPrefix(resp.Header.Get("Content-Type"), "text/html") &&
+		resp.Header.Get("Content-Disposition") == ""
+}
+
+// withURL is req sent to another URL
+func withURL(req *http.Request, u *url.URL) *http.Request {
+	r := req.Clone(req.Context())
+	r.URL = u
+	r.Host = ""
+	return r
+}
+
+var (
+	driveForm  = regexp.MustCompile(`(?is)<form\b([^>]*\bid="download-form"[^>]*)>(.*?)</form>`)
+	driveInput = regexp.MustCompile(`(?i)<input\b[^>]*>`)
+	htmlAttr   = regexp.MustCompile(`(?i)\b(action|type|name|value)\s*=\s*"([^"]*)"`)
+	// Older pages link to the file with a confirmation token instead
+	driveConfirm = regexp.MustCompile(`confirm=([0-9A-Za-z_-]+)`)
+)
+
+// driveConfirmURL is the URL the warning page's form submits to
+func driveConfirmURL(base *url.URL, page []byte) (*url.URL, error) {
+	if m := driveForm.FindSubmatch(page); m != nil {
+		action, err := base.Parse(html.UnescapeString(attrs(m[1])["action"]))
+		if err != nil {
+			return nil, err
+		}
+		q := url.Values{}
+		for _, input := range driveInput.FindAll(m[2], -1) {
+			a := attrs(input)
+			if strings.EqualFold(a["type"], "hidden") && a["name"] != "" {
+				q.Set(html.UnescapeString(a["name"]), html.UnescapeString(a["value"]))
+			}
+		}
+		action.RawQuery = q.Encode()
+		return action, nil
+	}
+	if m := driveConfirm.FindSubmatch([]byte(html.UnescapeString(string(page)))); m != nil {
+		u := *base
+		q := u.Query()
+		q.Set("confirm", string(m[1]))
+		u.RawQuery = q.Encode()
+		return &u, nil
+	}
+	return nil, errDriveNotShared
+}
+
+// attrs are the quoted attributes of an HTML tag, lower cased names
+func attrs(tag []byte) map[string]string {
+	a := map[string]string{}
+	for _, m := range htmlAttr.FindAllSubmatch(tag, -1) {
+		a[strings.ToLower(string(m[1]))] = string(m[2])
+	}
+	return a
+}
@@ -0,0 +1,110 @@
+package downloader
+
+import (
+	"bytes"
+	"context"
+	"io"
+	"net/http"
+	"net/url"
+	"path"
+	"strings"
+)
+
+// Checksum files published next to downloads, tried in order. The ones
+// with a leading dot follow the file's own name.
+var sidecarNames = []string{
+	".sha256", ".sha512", ".sha256sum", ".sha512sum",
+	"SHA256SUMS", "SHA512SUMS", "CHECKSUMS.txt", "checksums.txt", "sha256sums.txt",
+}
+
+// FindSidecarChecksum looks for the file's digest in the checksum files
+// conventionally published beside it: name.sha256 and the directory's
+// SHA256SUMS or CHECKSUMS.txt. It returns the digest and the file it came
+// from, nil when none lists the file. Such files come from the same
+// server, they catch corruption, not tampering.
+func FindSidecarChecksum(ctx context.Context, client *http.Client, rawURL string) (*Checksum, string, error) {
+	u, err := url.Parse(rawURL)
+	if err != nil || (u.Scheme != "http" && u.Scheme != "https") || strings.HasSuffix(u.Path, "/") {
+		return nil, "", nil
+	}
+	u.RawQuery, u.Fragment = "", ""
+	file := path.Base(u.Path)
+
+	for _, name := range sidecarNames {
+		side := *u
+		if strings.HasPrefix(name, ".") {
+			side.Path += name
+		} else {
+			side.Path = path.Join(path.Dir(u.Path), name)
+		}
+		data, err := fetchSidecar(ctx, client, side.String())
+		if err != nil {
+			if ctx.Err() != nil {
+				return nil, "", ctx.Err()
+			}
+			continue
+		}
+		if data == nil {
+			continue
+		}
+		if sum := sidecarSum(data, name, u.Path, file); sum != nil {
+			return sum, side.String(), nil
+		}
+	}
+	return nil, "", nil
+}
+
+// sidecarSum finds the file's digest in a checksum file. A name.sha256
+// may hold just the digest.
+func sidecarSum(data []byte, name, urlPath, file string) *Checksum {
+	algo := ""
+	switch {
+	case strings.Contains(strings.ToLower(name), "sha256"):
+		algo = "sha256"
+	case strings.Contains(strings.ToLower(name), "sha512"):
+		algo = "sha512"
+	}
+	if fields := strings.Fields(string(data)); strings.HasPrefix(name, ".") && len(fields) == 1 {
+		if c, err := ParseChecksum(algoOr(algo, len(fields[0])) + ":" + fields[0]); err == nil {
+			return c
+		}
+		return nil
+	}
+	entries, err := ParseSums(bytes.NewReader(data), algo)
+	if err != nil {
+		return nil
+	}
+	if strings.HasPrefix(name, ".") && len(entries) == 1 && path.Base(entries[0].Name) == file {
+		return entries[0].Checksum
+	}
+	if e := matchSum(entries, urlPath); e != nil {
+		return e.Checksum
+	}
+	return nil
+}
+
+func algoOr(algo string, digestLen int) string {
+	if algo != "" {
+		return algo
+	}
+	return algoForDigestLength(digestLen)
+}
+
+// fetchSidecar returns the body of a checksum file, nil when the server
+// has none or answers with something other than text
+func fetchSidecar(ctx context.Context, client *http.Client, rawURL string) ([]byte, error) {
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, rawURL, nil)
+	if err != nil {
+		return nil, err
+	}
+	req.Header.Set("User-Agent", defaultUserAgent)
+	resp, err := client.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK || strings.HasPrefix(resp.Header.Get("Content-Type"), "text/html") {
+		return nil, nil
+	}
+	return io.ReadAll(io.LimitReader(resp.Body, 4<<20))
+}
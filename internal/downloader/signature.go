@@ -0,0 +1,69 @@
+package downloader
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"strings"
+
+	"github.com/ProtonMail/go-crypto/openpgp"
+	pgperrors "github.com/ProtonMail/go-crypto/openpgp/errors"
+)
+
+// Signature is a detached OpenPGP signature of a release artifact and the
+// public keys it may come from
+type Signature struct {
+	Source  string // Where the signature came from, for messages
+	Data    []byte // Armored or binary
+	Keyring openpgp.EntityList
+}
+
+// LoadSignature reads a detached signature from a URL or file and the
+// keyrings that may have made it
+func LoadSignature(ctx context.Context, client *http.Client, src string, keyrings []string) (*Signature, error) {
+	if len(keyrings) == 0 {
+		return nil, errors.New("no public key to check the signature against")
+	}
+	data, err := readSource(ctx, client, src)
+	if err != nil {
+		return nil, fmt.Errorf("signature: %w", err)
+	}
+	sig := &Signature{Source: src, Data: data}
+	for _, p := range keyrings {
+		keys, err := loadKeyring(p)
+		var unsupported pgperrors.UnsupportedError
+		if errors.As(err, &unsupported) {
+			return nil, fmt.Errorf("key %s: %w (RSA, DSA and ECDSA keys are supported, not Ed25519)", p, err)
+		}
+		if err != nil {
+			return nil, fmt.Errorf("key %s: %w", p, err)
+		}
+		sig.Keyring = append(sig.Keyring, keys...)
+	}
+	return sig, nil
+}
+
+// VerifyFiles checks the signature over the concatenation of paths, the
+// volumes of a split output in order, and names the key that made it
+func (s *Signature) VerifyFiles(paths ...string) (string, error) {
+	readers := make([]io.Reader, 0, len(paths))
+	for _, path := range paths {
+		f, err := os.Open(path)
+		if err != nil {
+			return "", err
+		}
+		defer f.Close()
+		readers = append(readers, f)
+	}
+	signer, err := checkDetachedSignature(s.Keyring, io.MultiReader(readers...), s.Data)
+	if err != nil {
+		return "", fmt.Errorf("bad signature %s for %s: %w", s.Source, strings.Join(paths, " + "), err)
+	}
+	for name := range signer.Identities {
+		return name, nil
+	}
+	return signer.PrimaryKey.KeyIdString(), nil
+}
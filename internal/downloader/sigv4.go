@@ -0,0 +1,326 @@
+package downloader
+
+import (
+	"bufio"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"time"
+)
+
+// AWS Signature Version 4 request signing
+
+type awsCredentials struct {
+	AccessKeyID     string
+	SecretAccessKey string
+	SessionToken    string
+}
+
+type sigV4Signer struct {
+	creds   awsCredentials
+	region  string
+	service string
+}
+
+const unsignedPayload = "UNSIGNED-PAYLOAD"
+
+// sign adds the SigV4 Authorization header. Only host and the x-amz-*
+// headers are signed, so per-request headers like Range can change freely.
+func (s *sigV4Signer) sign(req *http.Request, now time.Time) {
+	now = now.UTC()
+	amzDate := now.Format("20060102T150405Z")
+	day := now.Format("20060102")
+
+	req.Header.Set("X-Amz-Date", amzDate)
+	if req.Header.Get("X-Amz-Content-Sha256") == "" {
+		req.Header.Set("X-Amz-Content-Sha256", unsignedPayload)
+	}
+	if s.creds.SessionToken != "" {
+		req.Header.Set("X-Amz-Security-Token", s.creds.SessionToken)
+	}
+
+	// Send the path exactly as it is signed
+	path := req.URL.Path
+	if path == "" {
+		path = "/"
+	}
+	canonicalURI := awsURIEncode(path, false)
+	req.URL.RawPath = canonicalURI
+
+	host := req.Host
+	if host == "" {
+		host = req.URL.Host
+	}
+	headers := map[string]string{"host": host}
+	for name, values := range req.Header {
+		if lower := strings.ToLower(name); strings.HasPrefix(lower, "x-amz-") {
+			headers[lower] = strings.TrimSpace(strings.Join(values, ","))
+		}
+	}
+	names := make([]string, 0, len(headers))
+	for name := range headers {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	var canonicalHeaders strings.Builder
+	for _, name := range names {
+		canonicalHeaders.WriteString(name + ":" + headers[name] + "\n")
+	}
+	signedHeaders := strings.Join(names, ";")
+
+	canonical := strings.Join([]string{
+		req.Method,
+		canonicalURI,
+		awsCanonicalQuery(req),
+		canonicalHeaders.String(),
+		signedHeaders,
+		req.Header.Get("X-Amz-Content-Sha256"),
+	}, "\n")
+
+	scope := day + "/" + s.region + "/" + s.service + "/aws4_request"
+	hash := sha256.Sum256([]byte(canonical))
+	toSign := "AWS4-HMAC-SHA256\n" + amzDate + "\n" + scope + "\n" + hex.EncodeToString(hash[:])
+
+	key := hmacSHA256([]byte("AWS4"+s.creds.SecretAccessKey), day)
+	key = hmacSHA256(key, s.region)
+	key = hmacSHA256(key, s.service)
+	key = hmacSHA256(key, "aws4_request")
+	signature := hex.EncodeToString(hmacSHA256(key, toSign))
+
+	req.Header.Set("Authorization", "AWS4-HMAC-SHA256 Credential="+s.creds.AccessKeyID+"/"+scope+
+		", SignedHeaders="+signedHeaders+", Signature="+signature)
+}
+
+func hmacSHA256(key []byte, data string) []byte {
+	h := hmac.New(sha256.New, key)
+	h.Write([]byte(data))
+	return h.Sum(nil)
+}
+
+func awsCanonicalQuery(req *http.Request) string {
+	q := req.URL.Query()
+	keys := make([]string, 0, len(q))
+	for k := range q {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+
+	var parts []string
+	for _, k := range keys {
+		values := append([]string(nil), q[k]...)
+		sort.Strings(values)
+		for _, v := range values {
+			parts = append(parts, awsURIEncode(k, true)+"="+awsURIEncode(v, true))
+		}
+	}
+	return strings.Join(parts, "&")
+}
+
+// awsURIEncode percent-encodes everything but the unreserved characters,
+// keeping '/' unless encodeSlash is set
+func awsURIEncode(s string, encodeSlash bool) string {
+	const hexDigits = "0123456789ABCDEF"
+	var b strings.Builder
+	for i := 0; i < len(s); i++ {
+		c := s[i]
+		switch {
+		case 'A' <= c && c <= 'Z', 'a' <= c && c <= 'z', '0' <= c && c <= '9',
+			c == '-', c == '_', c == '.', c == '~':
+			b.WriteByte(c)
+		case c == '/' && !encodeSlash:
+			b.WriteByte(c)
+		default:
+			b.WriteByte('%')
+			b.WriteByte(hexDigits[c>>4])
+			b.WriteByte(hexDigits[c&15])
+		}
+	}
+	return b.String()
+}
+
+// awsProfile is the shared config profile in use
+func awsProfile() string {
+	if p := os.Getenv("AWS_PROFILE"); p != "" {
+		return p
+	}
+	return "default"
+}
+
+// loadAWSCredentials reads credentials from the environment, then from the
+// shared credentials and config files. ok is false when none are found.
+func loadAWSCredentials() (awsCredentials, bool) {
+	creds := awsCredentials{
+		AccessKeyID:     os.Getenv("AWS_ACCESS_KEY_ID"),
+		SecretAccessKey: os.Getenv("AWS_SECRET_ACCESS_KEY"),
+		SessionToken:    os.Getenv("AWS_SESSION_TOKEN"),
+	}
+	if creds.AccessKeyID != "" && creds.SecretAccessKey != "" {
+		return creds, true
+	}
+
+	profile := awsProfile()
+	for _, src := range []struct{ path, section string }{
+		{awsSharedFile("AWS_SHARED_CREDENTIALS_FILE", "credentials"), profile},
+		{awsSharedFile("AWS_CONFIG_FILE", "config"), awsConfigSection(profile)},
+	} {
+		values := readINISection(src.path, src.section)
+		creds = awsCredentials{
+			AccessKeyID:     values["aws_access_key_id"],
+			SecretAccessKey: values["aws_secret_access_key"],
+			SessionToken:    values["aws_session_token"],
+		}
+		if creds.AccessKeyID != "" && creds.SecretAccessKey != "" {
+			return creds, true
+		}
+	}
+	return awsCredentials{}, false
+}
+
+// awsRegion follows the SDK precedence: environment, shared config, default
+func awsRegion() string {
+	for _, env := range []string{"AWS_REGION", "AWS_DEFAULT_REGION"} {
+		if r := os.Getenv(env); r != "" {
+			return r
+		}
+	}
+	if r := readINISection(awsSharedFile("AWS_CONFIG_FILE", "config"), awsConfigSection(awsProfile()))["region"]; r != "" {
+		return r
+	}
+	return "us-east-1"
+}
+
+func awsSharedFile(env, name string) string {
+	if p := os.Getenv(env); p != "" {
+		return p
+	}
+	home, err := os.UserHomeDir()
+	if err != nil {
+		return ""
+	}
+	return filepath.Join(home, ".aws", name)
+}
+
+// Profiles other than default are "[profile name]" in the config file
+func awsConfigSection(profile string) string {
+	if profile == "default" {
+		return profile
+	}
+	return "profile " + profile
+}
+
+// readINISection returns the key/value pairs of one section of an INI file
+func readINISection(path, section string) map[string]string {
+	values := map[string]string{}
+	if path == "" {
+		return values
+	}
+	f, err := os.Open(path)
+	if err != nil {
+		return values
+	}
+	defer f.Close()
+
+	in := false
+	sc := bufio.NewScanner(f)
+	for sc.Scan() {
+		line := strings.TrimSpace(sc.Text())
+		if line == "" || line[0] == '#' || line[0] == ';' {
+			continue
+		}
+		if strings.HasPrefix(line, "[") && strings.HasSuffix(line, "]") {
+			in = strings.TrimSpace(line[1:len(line)-1]) == section
+			continue
+		}
+		if !in {
+			continue
+		}
+		if k, v, ok := strings.Cut(line, "="); ok {
+			values[strings.ToLower(strings.TrimSpace(k))] = strings.TrimSpace(v)
+		}
+	}
+	return values
+}
+
+// ErrNoAWSCredentials is Config.AWSSigV4 without credentials to sign with
+var ErrNoAWSCredentials = errors.New("no AWS credentials: set AWS_ACCESS_KEY_ID and AWS_SECRET_ACCESS_KEY or add them to ~/.aws/credentials")
+
+// ParseAWSSigV4 parses --aws-sigv4 region/service. The service defaults
+// to s3, and the region to the environment's or shared config's for "".
+func ParseAWSSigV4(spec string) (region, service string, err error) {
+	region, service, _ = strings.Cut(spec, "/")
+	if strings.ContainsAny(region, " /") || strings.ContainsAny(service, " /") {
+		return "", "", fmt.Errorf("invalid --aws-sigv4 %q: want region/service, e.g. us-east-1/s3", spec)
+	}
+	if region == "" {
+		region = awsRegion()
+	}
+	if service == "" {
+		service = "s3"
+	}
+	return region, service, nil
+}
+
+// sigV4Transport signs every request for Config.AWSSigV4, e.g. to private
+// buckets of S3 compatible stores reached by their HTTPS URL
+type sigV4Transport struct {
+	base   http.RoundTripper
+	signer *sigV4Signer
+}
+
+func newSigV4Transport(spec string, base http.RoundTripper) http.RoundTripper {
+	region, service, err := ParseAWSSigV4(spec)
+	if err != nil {
+		return failingTransport{err}
+	}
+	creds, ok := loadAWSCredentials()
+	if !ok {
+		return failingTransport{ErrNoAWSCredentials}
+	}
+	return &sigV4Transport{base: base, signer: &sigV4Signer{creds: creds, region: region, service: service}}
+}
+
+func (t *sigV4Transport) RoundTrip(req *http.Request) (*http.Response, error) {
+	r := req.Clone(req.Context())
+	if t.signer.service != "s3" {
+		// Only S3 takes unsigned payloads
+		sum, err := payloadHash(r)
+		if err != nil {
+			return nil, err
+		}
+		r.Header.Set("X-Amz-Content-Sha256", sum)
+	}
+	t.signer.sign(r, time.Now())
+	resp, err := t.base.RoundTrip(r)
+	if err == nil && t.signer.service == "s3" {
+		advertiseRanges(resp)
+	}
+	return resp, err
+}
+
+// payloadHash is the hex SHA-256 of the request body, read from a copy
+func payloadHash(req *http.Request) (string, error) {
+	h := sha256.New()
+	if req.Body != nil && req.Body != http.NoBody {
+		if req.GetBody == nil {
+			return unsignedPayload, nil
+		}
+		body, err := req.GetBody()
+		if err != nil {
+			return "", err
+		}
+		defer body.Close()
+		if _, err := io.Copy(h, body); err != nil {
+			return "", err
+		}
+	}
+	return hex.EncodeToString(h.Sum(nil)), nil
+}
@@ -0,0 +1,142 @@
+package downloader
+
+import (
+	"math"
+	"sync"
+	"time"
+)
+
+// SpeedMeter turns periodic readings of the downloaded byte count into
+// rates. It keeps the last size readings and is not safe for concurrent use.
+type SpeedMeter struct {
+	size     int
+	readings []speedReading
+}
+
+type speedReading struct {
+	at    time.Time
+	bytes int64
+}
+
+func NewSpeedMeter(size int) *SpeedMeter {
+	if size < 2 {
+		size = 2
+	}
+	return &SpeedMeter{size: size}
+}
+
+// Add records the downloaded byte count at a point in time
+func (m *SpeedMeter) Add(at time.Time, downloaded int64) {
+	if len(m.readings) == m.size {
+		m.readings = append(m.readings[:0], m.readings[1:]...)
+	}
+	m.readings = append(m.readings, speedReading{at, downloaded})
+}
+
+// Rate is the average speed in bytes per second over the last window
+func (m *SpeedMeter) Rate(window time.Duration) float64 {
+	if len(m.readings) < 2 {
+		return 0
+	}
+	last := m.readings[len(m.readings)-1]
+	first := m.readings[0]
+	for _, r := range m.readings {
+		if last.at.Sub(r.at) <= window {
+			first = r
+			break
+		}
+	}
+	elapsed := last.at.Sub(first.at).Seconds()
+	if elapsed <= 0 {
+		return 0
+	}
+	return float64(last.bytes-first.bytes) / elapsed
+}
+
+// Samples returns the speed between consecutive readings, oldest first
+func (m *SpeedMeter) Samples() []float64 {
+	var out []float64
+	for i := 1; i < len(m.readings); i++ {
+		elapsed := m.readings[i].at.Sub(m.readings[i-1].at).Seconds()
+		if elapsed <= 0 {
+			continue
+		}
+		out = append(out, float64(m.readings[i].bytes-m.readings[i-1].bytes)/elapsed)
+	}
+	return out
+}
+
+// ETA estimates when the remaining bytes arrive at the given speed. It
+// reports false while there is no speed to go by.
+func ETA(now time.Time, remaining int64, rate float64) (time.Time, bool) {
+	if rate <= 0 || remaining < 0 {
+		return time.Time{}, false
+	}
+	return now.Add(time.Duration(float64(remaining) / rate * float64(time.Second))), true
+}
+
+const (
+	// speedSmoothing is the time constant of the current speed, readings
+	// older than that weigh in less and less
+	speedSmoothing = 3 * time.Second
+	// minSampleGap keeps quick successive reads from turning single chunks
+	// into speed spikes
+	minSampleGap = 250 * time.Millisecond
+)
+
+// rateTracker keeps the speed of a Stats: an exponentially weighted moving
+// average for the current speed and the overall average since it began
+type rateTracker struct {
+	mu      sync.Mutex
+	start   time.Time // Zero until the first reading
+	base    int64     // Bytes already there at start, e.g. resumed
+	last    time.Time
+	lastN   int64
+	current float64
+	primed  bool // current holds at least one reading
+}
+
+// BeginRate starts measuring speed from the current byte count, so bytes
+// restored from resume state don't count as speed. Without it measuring
+// starts on the first call to Rates.
+func (s *Stats) BeginRate(now time.Time) {
+	n := s.GetDownloaded()
+	r := &s.rate
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.start, r.base, r.last, r.lastN = now, n, now, n
+	r.current, r.primed = 0, false
+}
+
+// Rates returns the current speed, smoothed over the last few seconds, and
+// the average since measuring began, both in bytes per second. Readings
+// are taken on the way, so it's cheap to call from a UI tick.
+func (s *Stats) Rates(now time.Time) (current, average float64) {
+	n := s.GetDownloaded()
+	r := &s.rate
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	if r.start.IsZero() {
+		r.start, r.base, r.last, r.lastN = now, n, now, n
+		return 0, 0
+	}
+	if n < r.lastN {
+		// Started over, e.g. a retry without ranges
+		r.base, r.lastN = n, n
+	}
+	if dt := now.Sub(r.last); dt >= minSampleGap {
+		instant := float64(n-r.lastN) / dt.Seconds()
+		if r.primed {
+			alpha := 1 - math.Exp(-dt.Seconds()/speedSmoothing.Seconds())
+			r.current += alpha * (instant - r.current)
+		} else {
+			r.current, r.primed = instant, true
+		}
+		r.last, r.lastN = now, n
+	}
+	if elapsed := now.Sub(r.start).Seconds(); elapsed > 0 {
+		average = float64(n-r.base) / elapsed
+	}
+	return r.current, average
+}
@@ -0,0 +1,160 @@
+package downloader
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+)
+
+// Split output writes the download as numbered volumes (name.001,
+// name.002, ...) that concatenate back to the original, for targets that
+// can't hold it in one file. FAT32 is the common case: a USB stick takes a
+// 6 GB image only in pieces, and finding out at 4 GB after hours of
+// transfer is too late.
+
+const (
+	SplitAuto  int64 = 0  // Split only when the target file system can't hold the file
+	SplitNever int64 = -1 // Fail before downloading instead
+)
+
+// ParseSplitOutput parses the --split-output flag: auto, off or a size
+func ParseSplitOutput(s string) (int64, error) {
+	switch s {
+	case "auto", "":
+		return SplitAuto, nil
+	case "off":
+		return SplitNever, nil
+	}
+	n, err := ParseSize(s)
+	if err != nil || n <= 0 {
+		return 0, fmt.Errorf("invalid --split-output %q (want auto, off or a size like 2G)", s)
+	}
+	return n, nil
+}
+
+// planOutput checks the output against the target file system limits and
+// decides the volume size before anything is downloaded
+func (e *Engine) planOutput() error {
+	e.target = targetFS(filepath.Dir(e.Config.OutputName))
+	total := e.Stats.GetTotal()
+	limit := e.target.MaxFileSize
+
+	switch split := e.Config.SplitOutput; {
+	case split > 0:
+		if limit > 0 && split > limit {
+			return fmt.Errorf("--split-output %s exceeds the %s limit of %s per file", formatSize(split), e.target.Name, formatSize(limit))
+		}
+		if total > split {
+			e.volumeSize = split
+		}
+	case limit > 0 && total > limit:
+		if split == SplitNever {
+			return fmt.Errorf("%s is %s but %s only holds files up to %s, use --split-output to write volumes",
+				e.Config.OutputName, formatSize(total), e.target.Name, formatSize(limit))
+		}
+		e.volumeSize = limit
+	}
+
+	if e.volumeSize > 0 && e.Config.Follow {
+		return fmt.Errorf("--follow can't append to a split output")
+	}
+	if limit > 0 && total > limit && !e.IsResumable {
+		// Without ranges the whole file goes through a single temp part
+		return fmt.Errorf("%s only holds files up to %s and the server can't resume, so %s can't be split there",
+			e.target.Name, formatSize(limit), formatSize(total))
+	}
+	return nil
+}
+
+// outputs lists the files the download ends up in
+func (e *Engine) outputs() []string {
+	if e.volumeSize == 0 {
+		return []string{e.Config.OutputName}
+	}
+	return e.Volumes
+}
+
+// volumePath names the n-th volume, counting from 1
+func volumePath(output string, n int) string {
+	return fmt.Sprintf("%s.%03d", output, n)
+}
+
+// volumeWriter cuts a sequential stream into volumes of at most size bytes,
+// each under its temporary name
+type volumeWriter struct {
+	output  string
+	size    int64
+	f       *os.File
+	written int64 // In the current volume
+	paths   []string
+}
+
+func (w *volumeWriter) Write(p []byte) (int, error) {
+	total := 0
+	for len(p) > 0 {
+		if w.f == nil || w.written == w.size {
+			if err := w.next(); err != nil {
+				return total, err
+			}
+		}
+		chunk := p
+		if room := w.size - w.written; int64(len(chunk)) > room {
+			chunk = chunk[:room]
+		}
+		n, err := w.f.Write(chunk)
+		total += n
+		w.written += int64(n)
+		if err != nil {
+			return total, err
+		}
+		p = p[n:]
+	}
+	return total, nil
+}
+
+func (w *volumeWriter) next() error {
+	if w.f != nil {
+		if err := w.f.Close(); err != nil {
+			return err
+		}
+	}
+	path := tmpPath(volumePath(w.output, len(w.paths)+1))
+	f, err := os.Create(path)
+	if err != nil {
+		return err
+	}
+	w.f, w.written = f, 0
+	w.paths = append(w.paths, path)
+	return nil
+}
+
+func (w *volumeWriter) Close() error {
+	if w.f == nil {
+		return nil
+	}
+	return w.f.Close()
+}
+
+// openVolumes starts the temporary volumes of a split merge
+func (e *Engine) openVolumes() *volumeWriter {
+	// Leftovers of an earlier, larger split would look like part of this one
+	for n := 1; ; n++ {
+		if os.Remove(volumePath(e.Config.OutputName, n)) != nil {
+			break
+		}
+	}
+	return &volumeWriter{output: e.Config.OutputName, size: e.volumeSize}
+}
+
+func formatSize(n int64) string {
+	const unit = 1024
+	if n < unit {
+		return fmt.Sprintf("%d B", n)
+	}
+	div, exp := int64(unit), 0
+	for m := n / unit; m >= unit; m /= unit {
+		div *= unit
+		exp++
+	}
+	return fmt.Sprintf("%.1f %ciB", float64(n)/float64(div), "KMGTPE"[exp])
+}
@@ -0,0 +1,113 @@
+package downloader
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// ErrStalled means a connection delivered nothing for the stall timeout
+var ErrStalled = errors.New("connection stalled")
+
+// stallWatch cuts off a part's connection once it goes quiet. Only time
+// spent waiting for the network counts, a part held up by a slow disk
+// isn't stalled. A nil watch does nothing.
+type stallWatch struct {
+	timeout time.Duration
+	timer   *time.Timer
+	cancel  func()
+	stalled atomic.Bool
+
+	mu   sync.Mutex
+	body io.Closer
+}
+
+// newStallWatch starts watching right away, so a server that never answers
+// counts as stalled too
+func newStallWatch(timeout time.Duration, cancel func()) *stallWatch {
+	if timeout <= 0 {
+		return nil
+	}
+	w := &stallWatch{timeout: timeout, cancel: cancel}
+	w.timer = time.AfterFunc(timeout, w.fire)
+	return w
+}
+
+func (w *stallWatch) fire() {
+	w.stalled.Store(true)
+	w.cancel()
+	// Not every body honors the context, e.g. sftp or a response read
+	// under another context
+	w.mu.Lock()
+	if w.body != nil {
+		w.body.Close()
+	}
+	w.mu.Unlock()
+}
+
+func (w *stallWatch) pause() {
+	if w != nil {
+		w.timer.Stop()
+	}
+}
+
+func (w *stallWatch) stop() {
+	w.pause()
+}
+
+// reader times every read of body
+func (w *stallWatch) reader(body io.ReadCloser) io.ReadCloser {
+	if w == nil {
+		return body
+	}
+	w.pause()
+	w.mu.Lock()
+	w.body = body
+	w.mu.Unlock()
+	return &stallReader{ReadCloser: body, w: w}
+}
+
+type stallKey struct{}
+
+// withStallWatch lets waits inside the part's request hold off the watch
+func withStallWatch(ctx context.Context, w *stallWatch) context.Context {
+	if w == nil {
+		return ctx
+	}
+	return context.WithValue(ctx, stallKey{}, w)
+}
+
+// holdStall pauses the request's stall watch, if any, for a wait that isn't
+// the network's, and returns the func starting it again
+func holdStall(ctx context.Context) func() {
+	w, _ := ctx.Value(stallKey{}).(*stallWatch)
+	if w == nil {
+		return func() {}
+	}
+	w.pause()
+	return func() { w.timer.Reset(w.timeout) }
+}
+
+// err replaces the error a cut off connection failed with
+func (w *stallWatch) err(err error) error {
+	if w != nil && w.stalled.Load() {
+		return fmt.Errorf("%w: no data for %s", ErrStalled, w.timeout)
+	}
+	return err
+}
+
+type stallReader struct {
+	io.ReadCloser
+	w *stallWatch
+}
+
+func (r *stallReader) Read(p []byte) (int, error) {
+	r.w.timer.Reset(r.w.timeout)
+	n, err := r.ReadCloser.Read(p)
+	r.w.timer.Stop()
+	return n, err
+}
@@ -0,0 +1,191 @@
+package downloader
+
+import (
+	"context"
+	"encoding/hex"
+	"fmt"
+	"hash"
+	"io"
+	"sync"
+	"sync/atomic"
+)
+
+// With Config.Stream the parts still download in parallel, but into memory
+// instead of part files: the part holding the next byte writes straight
+// through, later parts read ahead into a buffer bounded by MaxInFlight and
+// wait once it is full. Nothing is saved, so there is no resume, but bytes
+// the writer already has are never sent twice, whatever the retries.
+
+// streamer delivers the download to the writer in order
+type streamer struct {
+	w     io.Writer
+	sum   hash.Hash // Of everything written, for Config.Checksum
+	limit int64
+
+	mu    sync.Mutex
+	cond  *sync.Cond
+	begun bool
+	next  int64            // Remote offset of the next byte for w
+	ahead map[int64][]byte // Chunks of later parts by remote offset
+	held  int64            // Bytes in ahead
+	err   error            // The writer failed, nothing more gets through
+}
+
+func newStreamer(cfg Config) (*streamer, error) {
+	s := &streamer{w: cfg.Stream, limit: cfg.MaxInFlight}
+	if s.limit <= 0 {
+		s.limit = defaultMaxInFlight
+	}
+	s.cond = sync.NewCond(&s.mu)
+	if cfg.Checksum != nil {
+		h, err := newHash(cfg.Checksum.Algo)
+		if err != nil {
+			return nil, err
+		}
+		s.sum = h
+	}
+	return s, nil
+}
+
+// begin readies the streamer for a run of the download starting at the
+// remote offset start and returns the offset of the next byte it needs.
+// Later runs carry on from what was written and drop the read-ahead, the
+// parts fetch it again.
+func (s *streamer) begin(start int64) int64 {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if !s.begun {
+		s.next, s.begun = start, true
+	}
+	s.ahead, s.held = map[int64][]byte{}, 0
+	s.cond.Broadcast()
+	return s.next
+}
+
+// write hands over data read at the remote offset off. Data the writer has
+// already is dropped, data past the next byte waits in memory, and when
+// that is full write blocks until the writer catches up.
+func (s *streamer) write(ctx context.Context, off int64, data []byte) error {
+	stop := context.AfterFunc(ctx, func() {
+		s.mu.Lock()
+		s.cond.Broadcast()
+		s.mu.Unlock()
+	})
+	defer stop()
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if end := off + int64(len(data)); end <= s.next {
+		return nil
+	}
+	if off < s.next {
+		data, off = data[s.next-off:], s.next
+	}
+	for off != s.next && s.held+int64(len(data)) > s.limit && s.err == nil && ctx.Err() == nil {
+		s.cond.Wait()
+	}
+	switch {
+	case s.err != nil:
+		return s.err
+	case ctx.Err() != nil:
+		return ctx.Err()
+	case off != s.next:
+		s.ahead[off] = append([]byte(nil), data...)
+		s.held += int64(len(data))
+		return nil
+	}
+
+	if err := s.emit(data); err != nil {
+		return err
+	}
+	// Whatever was waiting for these bytes can follow them now
+	for {
+		chunk, ok := s.ahead[s.next]
+		if !ok {
+			break
+		}
+		delete(s.ahead, s.next)
+		s.held -= int64(len(chunk))
+		if err := s.emit(chunk); err != nil {
+			return err
+		}
+	}
+	s.cond.Broadcast()
+	return nil
+}
+
+func (s *streamer) emit(data []byte) error {
+	if _, err := s.w.Write(data); err != nil {
+		s.err = fmt.Errorf("failed to write the stream: %w", err)
+		s.cond.Broadcast()
+		return s.err
+	}
+	if s.sum != nil {
+		s.sum.Write(data)
+	}
+	s.next += int64(len(data))
+	return nil
+}
+
+// verify compares the digest of the stream with Config.Checksum. The
+// reader has the bytes by then, the error is all that's left to give.
+func (s *streamer) verify(c *Checksum) error {
+	if c == nil {
+		return nil
+	}
+	if sum := hex.EncodeToString(s.sum.Sum(nil)); sum != c.Value {
+		return fmt.Errorf("%w for the stream: expected %s %s, got %s", ErrChecksumMismatch, c.Algo, c.Value, sum)
+	}
+	return nil
+}
+
+// runStream downloads the parts into the streamer rather than files
+func (e *Engine) runStream(ctx context.Context) error {
+	if e.Config.Follow {
+		return fmt.Errorf("--follow can't be used when streaming the output")
+	}
+	switch {
+	case e.IsResumable && e.Stats.TotalBytes >= e.minSplitSize():
+		e.calculateSegments()
+	default:
+		e.Parts = []*Part{{ID: 0, Start: e.rangeStart, End: e.rangeStart + e.Stats.TotalBytes - 1}}
+	}
+	e.trace("streaming %d parts in order", len(e.Parts))
+	if next := e.stream.begin(e.rangeStart); e.IsResumable {
+		// A run after a failed one starts where the stream is
+		for _, p := range e.Parts {
+			p.Downloaded = min(max(next-p.Start, 0), p.End-p.Start+1)
+			e.Stats.AddDownloaded(p.Downloaded)
+		}
+	}
+	e.layout.Store(e.Parts)
+
+	if err := e.downloadParts(ctx); err != nil {
+		return err
+	}
+	if total := e.Stats.GetTotal(); total > 0 && e.stream.next-e.rangeStart != total {
+		return fmt.Errorf("stream is truncated: wrote %d of %d bytes", e.stream.next-e.rangeStart, total)
+	}
+	return e.stream.verify(e.Config.Checksum)
+}
+
+// streamPart reads a part's body into the streamer
+func (e *Engine) streamPart(ctx context.Context, part *Part, body io.Reader) error {
+	buf := make([]byte, bufferSize(e.Config.BufferSize))
+	for {
+		n, rErr := body.Read(buf)
+		if n > 0 {
+			if err := e.stream.write(ctx, part.Start+part.Downloaded, buf[:n]); err != nil {
+				return err
+			}
+			atomic.AddInt64(&part.Downloaded, int64(n))
+			e.Stats.AddDownloaded(int64(n))
+		}
+		if rErr == io.EOF {
+			return nil
+		}
+		if rErr != nil {
+			return rErr
+		}
+	}
+}
@@ -0,0 +1,99 @@
+package downloader
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
+)
+
+const (
+	// Longest Retry-After that is waited out, a server asking for more
+	// fails the request like any other status
+	maxRetryAfter = 15 * time.Minute
+
+	// Retry-After waits a part takes in a row without progress before the
+	// answer counts as a failure
+	maxThrottles = 10
+)
+
+// retryAfter reads the wait a 429 or 503 asked for, in seconds or until a
+// date, 0 without a usable one
+func retryAfter(resp *http.Response) time.Duration {
+	if resp.StatusCode != http.StatusTooManyRequests && resp.StatusCode != http.StatusServiceUnavailable {
+		return 0
+	}
+	v := strings.TrimSpace(resp.Header.Get("Retry-After"))
+	var d time.Duration
+	if secs, err := strconv.Atoi(v); err == nil {
+		d = time.Duration(secs) * time.Second
+	} else if t, err := http.ParseTime(v); err == nil {
+		d = time.Until(t)
+	} else {
+		return 0
+	}
+	if d > maxRetryAfter {
+		return 0
+	}
+	return max(d, time.Second)
+}
+
+// throttled is the Retry-After of a failed request, 0 for other failures
+func throttled(err error) time.Duration {
+	var se *StatusError
+	if errors.As(err, &se) {
+		return se.RetryAfter
+	}
+	return 0
+}
+
+// throttle pauses the part for the server's Retry-After, with
+// Config.ThrottleHost also the other parts to the same host
+func (e *Engine) throttle(ctx context.Context, part *Part, src string, d time.Duration) error {
+	until := time.Now().Add(d)
+	if e.Config.ThrottleHost {
+		e.heldMu.Lock()
+		if e.held == nil {
+			e.held = map[string]time.Time{}
+		}
+		if host := hostOf(src); until.After(e.held[host]) {
+			e.held[host] = until
+		}
+		e.heldMu.Unlock()
+	}
+	return waitThrottled(ctx, part, until)
+}
+
+// waitHeld waits while a throttled part holds the host of src
+func (e *Engine) waitHeld(ctx context.Context, part *Part, src string) error {
+	e.heldMu.Lock()
+	until := e.held[hostOf(src)]
+	e.heldMu.Unlock()
+	if time.Until(until) <= 0 {
+		return nil
+	}
+	return waitThrottled(ctx, part, until)
+}
+
+func waitThrottled(ctx context.Context, part *Part, until time.Time) error {
+	part.setState(PartThrottled)
+	defer part.setState(PartActive)
+	t := time.NewTimer(time.Until(until))
+	defer t.Stop()
+	select {
+	case <-ctx.Done():
+		return ctx.Err()
+	case <-t.C:
+		return nil
+	}
+}
+
+func hostOf(src string) string {
+	if u, err := url.Parse(src); err == nil {
+		return u.Host
+	}
+	return src
+}
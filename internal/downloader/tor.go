@@ -0,0 +1,97 @@
+package downloader
+
+import (
+	"context"
+	"crypto/tls"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net"
+	"net/http"
+	"net/url"
+	"strings"
+	"time"
+)
+
+// With Config.Tor every connection goes to the Tor SOCKS proxy and host
+// names travel to it unresolved, so no DNS query, system or DoH, leaves the
+// machine. The transport refuses to dial anything but the proxy: a code
+// path that would bypass it fails instead of leaking.
+
+// DefaultTorProxy is the SOCKS port of a local tor daemon, Tor Browser's
+// is 127.0.0.1:9150
+const DefaultTorProxy = "127.0.0.1:9050"
+
+const torCheckURL = "https://check.torproject.org/api/ip"
+
+// ErrNotTor is returned for a connection Config.Tor doesn't allow
+var ErrNotTor = errors.New("refusing a connection outside Tor")
+
+// TorProxy is the Config.Proxy for a Tor SOCKS address, host:port
+func TorProxy(addr string) (string, error) {
+	if addr == "" {
+		addr = DefaultTorProxy
+	}
+	host, _, err := net.SplitHostPort(addr)
+	if err != nil {
+		return "", fmt.Errorf("invalid Tor proxy %q: want host:port like %s", addr, DefaultTorProxy)
+	}
+	if net.ParseIP(host) == nil {
+		// Resolving it would be the one lookup outside Tor
+		return "", fmt.Errorf("invalid Tor proxy %q: want an IP address, not a host name", addr)
+	}
+	return "socks5h://" + addr, nil
+}
+
+// newTorTransport dials only the proxy, the transport's Proxy sends
+// everything there
+func newTorTransport(proxy string) *http.Transport {
+	u, err := url.Parse(proxy)
+	allowed := ""
+	if err == nil {
+		allowed = u.Host
+	}
+	return &http.Transport{
+		DialContext: func(ctx context.Context, network, addr string) (net.Conn, error) {
+			if addr != allowed || !strings.HasPrefix(network, "tcp") {
+				return nil, fmt.Errorf("%w: %s", ErrNotTor, addr)
+			}
+			d := net.Dialer{Timeout: 30 * time.Second, KeepAlive: 30 * time.Second}
+			return d.DialContext(ctx, network, addr)
+		},
+		TLSClientConfig:       &tls.Config{InsecureSkipVerify: true},
+		TLSNextProto:          map[string]func(string, *tls.Conn) http.RoundTripper{},
+		TLSHandshakeTimeout:   30 * time.Second,
+		ExpectContinueTimeout: 1 * time.Second,
+	}
+}
+
+// CheckTor asks the Tor Project whether the client's requests come out of
+// the Tor network and returns the exit's address
+func CheckTor(ctx context.Context, client *http.Client) (string, error) {
+	ctx, cancel := context.WithTimeout(ctx, time.Minute)
+	defer cancel()
+	req, err := http.NewRequestWithContext(ctx, "GET", torCheckURL, nil)
+	if err != nil {
+		return "", err
+	}
+	resp, err := client.Do(req)
+	if err != nil {
+		return "", fmt.Errorf("Tor isn't reachable: %w", err)
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return "", fmt.Errorf("Tor check failed: %s", resp.Status)
+	}
+	var check struct {
+		IsTor bool
+		IP    string
+	}
+	if err := json.NewDecoder(resp.Body).Decode(&check); err != nil {
+		return "", fmt.Errorf("Tor check failed: %w", err)
+	}
+	if !check.IsTor {
+		return "", fmt.Errorf("requests leave from %s, which isn't a Tor exit", check.IP)
+	}
+	return check.IP, nil
+}
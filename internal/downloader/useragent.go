@@ -0,0 +1,35 @@
+package downloader
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+)
+
+// UserAgents are the --ua-preset User-Agents, for hosts that block or serve
+// other content to some clients
+var UserAgents = map[string]string{
+	"chrome":  defaultUserAgent,
+	"firefox": "Mozilla/5.0 (Windows NT 10.0; Win64; x64; rv:121.0) Gecko/20100101 Firefox/121.0",
+	"wget":    "Wget/1.21.4",
+	"curl":    "curl/8.5.0",
+}
+
+// UserAgentPresets returns the names of UserAgents, sorted
+func UserAgentPresets() []string {
+	names := make([]string, 0, len(UserAgents))
+	for name := range UserAgents {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+// PresetUserAgent returns the User-Agent of a preset name
+func PresetUserAgent(name string) (string, error) {
+	ua, ok := UserAgents[strings.ToLower(name)]
+	if !ok {
+		return "", fmt.Errorf("unknown User-Agent preset %q: want %s", name, strings.Join(UserAgentPresets(), ", "))
+	}
+	return ua, nil
+}
@@ -0,0 +1,75 @@
+package downloader
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"strings"
+)
+
+// ErrRemoteChanged means the file on the server was replaced while parts
+// of the old one were already downloaded. Merging would mix both versions.
+var ErrRemoteChanged = errors.New("remote file changed mid-download")
+
+// validator identifies one version of the remote file
+type validator struct {
+	ETag         string
+	LastModified string
+}
+
+func responseValidator(resp *http.Response) validator {
+	return validator{ETag: resp.Header.Get("ETag"), LastModified: resp.Header.Get("Last-Modified")}
+}
+
+func (v validator) strongETag() bool {
+	return v.ETag != "" && !strings.HasPrefix(v.ETag, "W/")
+}
+
+func (v validator) String() string {
+	if v.ETag != "" {
+		return v.ETag
+	}
+	return v.LastModified
+}
+
+// setConditional makes a range request fail instead of returning a newer
+// version. If-Range only accepts strong ETags, otherwise the date is used.
+func (v validator) setConditional(req *http.Request) {
+	switch {
+	case v.strongETag():
+		req.Header.Set("If-Range", v.ETag)
+		req.Header.Set("If-Match", v.ETag)
+	case v.LastModified != "":
+		req.Header.Set("If-Range", v.LastModified)
+	}
+}
+
+// check rejects a part response for a different version than the probe
+// saw. A 200 to If-Range is the current file whole, which is a new version
+// only when its validators say so: servers that ignore ranges answer the
+// same way, and are left to errRangeIgnored.
+func (v validator) check(resp *http.Response) error {
+	if resp.StatusCode == http.StatusPreconditionFailed {
+		return ErrRemoteChanged
+	}
+	got := responseValidator(resp)
+	if v.ETag != "" && got.ETag != "" && got.ETag != v.ETag {
+		return ErrRemoteChanged
+	}
+	if v.LastModified != "" && got.LastModified != "" && got.LastModified != v.LastModified {
+		return ErrRemoteChanged
+	}
+	return nil
+}
+
+// firstError drains errs, preferring a real failure over the cancellations
+// it caused in the other parts
+func firstError(errs <-chan error) error {
+	var first error
+	for err := range errs {
+		if first == nil || (errors.Is(first, context.Canceled) && !errors.Is(err, context.Canceled)) {
+			first = err
+		}
+	}
+	return first
+}
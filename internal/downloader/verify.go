@@ -0,0 +1,148 @@
+package downloader
+
+import (
+	"context"
+	"encoding/hex"
+	"io"
+	"os"
+	"path/filepath"
+	"sync"
+)
+
+// VerifyStatus is the outcome of checking one local file
+type VerifyStatus int
+
+const (
+	VerifyOK VerifyStatus = iota
+	VerifyMismatch
+	VerifyMissing
+	VerifyError
+)
+
+func (s VerifyStatus) String() string {
+	switch s {
+	case VerifyOK:
+		return "OK"
+	case VerifyMismatch:
+		return "FAILED"
+	case VerifyMissing:
+		return "MISSING"
+	}
+	return "ERROR"
+}
+
+// VerifyResult pairs a sums entry with its outcome
+type VerifyResult struct {
+	Entry  SumEntry
+	Status VerifyStatus
+	Err    error
+}
+
+// SumsVerifier checks files of a directory against a sums list in parallel.
+// It implements Task so the progress UI can display hashing progress.
+type SumsVerifier struct {
+	Dir     string
+	Entries []SumEntry
+	Workers int
+	Stats   *Stats
+	Results []VerifyResult // Same order as Entries, filled by Start
+}
+
+// NewSumsVerifier creates a verifier for entries relative to dir
+func NewSumsVerifier(dir string, entries []SumEntry, workers int) *SumsVerifier {
+	if workers < 1 {
+		workers = 1
+	}
+	return &SumsVerifier{
+		Dir:     dir,
+		Entries: entries,
+		Workers: workers,
+		Stats:   &Stats{},
+	}
+}
+
+// Progress returns the number of bytes hashed so far
+func (v *SumsVerifier) Progress() *Stats {
+	return v.Stats
+}
+
+// Start hashes every file and records the results
+func (v *SumsVerifier) Start(ctx context.Context) error {
+	var total int64
+	for _, e := range v.Entries {
+		if info, err := os.Stat(v.path(e)); err == nil {
+			total += info.Size()
+		}
+	}
+	v.Stats.SetTotal(total)
+
+	v.Results = make([]VerifyResult, len(v.Entries))
+	jobs := make(chan int)
+	var wg sync.WaitGroup
+	for w := 0; w < v.Workers; w++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for i := range jobs {
+				v.Results[i] = v.verify(ctx, v.Entries[i])
+			}
+		}()
+	}
+
+	for i := range v.Entries {
+		select {
+		case jobs <- i:
+		case <-ctx.Done():
+		}
+	}
+	close(jobs)
+	wg.Wait()
+	return ctx.Err()
+}
+
+func (v *SumsVerifier) path(e SumEntry) string {
+	return filepath.Join(v.Dir, filepath.FromSlash(e.Name))
+}
+
+func (v *SumsVerifier) verify(ctx context.Context, e SumEntry) VerifyResult {
+	res := VerifyResult{Entry: e}
+
+	f, err := os.Open(v.path(e))
+	if err != nil {
+		res.Status, res.Err = VerifyError, err
+		if os.IsNotExist(err) {
+			res.Status = VerifyMissing
+		}
+		return res
+	}
+	defer f.Close()
+
+	h, err := newHash(e.Checksum.Algo)
+	if err != nil {
+		res.Status, res.Err = VerifyError, err
+		return res
+	}
+	r := &countingReader{r: &ctxReader{ctx: ctx, r: f}, stats: v.Stats}
+	if _, err := io.Copy(h, r); err != nil {
+		res.Status, res.Err = VerifyError, err
+		return res
+	}
+
+	if hex.EncodeToString(h.Sum(nil)) != e.Checksum.Value {
+		res.Status = VerifyMismatch
+	}
+	return res
+}
+
+// ctxReader stops long reads once ctx is cancelled
+type ctxReader struct {
+	ctx context.Context
+	r   io.Reader
+}
+
+func (c *ctxReader) Read(p []byte) (int, error) {
+	if err := c.ctx.Err(); err != nil {
+		return 0, err
+	}
+	return c.r.Read(p)
+}
@@ -0,0 +1,507 @@
+package downloader
+
+import (
+	"bytes"
+	"context"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"golang.org/x/crypto/md4"
+)
+
+// Missing blocks are fetched in ranges of up to this much
+const zsyncRangeSize = 8 << 20
+
+// zsyncMaxBlockSize bounds the control file's Blocksize, zsyncmake picks
+// a few KB and matching keeps windows of two blocks in memory
+const zsyncMaxBlockSize = 1 << 20
+
+// ZsyncControl is a parsed .zsync control file: the target's length and
+// SHA-1 and a weak rolling and a strong MD4 checksum per block, to find the
+// blocks an older copy of the file already has
+type ZsyncControl struct {
+	Filename  string
+	URL       string // Target URL from the control file, resolved against its location
+	Length    int64
+	BlockSize int
+	SHA1      string
+
+	seqMatches int // Consecutive blocks that must match, 1 or 2
+	rsumBytes  int // Bytes of the rolling checksum kept per block
+	sumBytes   int // Bytes of the MD4 kept per block
+	blocks     []zsyncBlock
+}
+
+type zsyncBlock struct {
+	rsum uint32 // a<<16 | b, masked to rsumBytes
+	sum  []byte
+}
+
+// LoadZsync reads a .zsync control file from a URL or a local file
+func LoadZsync(ctx context.Context, client *http.Client, src string) (*ZsyncControl, error) {
+	data, err := readSource(ctx, client, src)
+	if err != nil {
+		return nil, err
+	}
+	c, err := parseZsync(data)
+	if err != nil {
+		return nil, fmt.Errorf("%s: %w", src, err)
+	}
+	if base, err := url.Parse(src); err == nil && c.URL != "" && (base.Scheme == "http" || base.Scheme == "https") {
+		if ref, err := base.Parse(c.URL); err == nil {
+			c.URL = ref.String()
+		}
+	}
+	return c, nil
+}
+
+// parseZsync reads the header lines up to the first empty one, then the
+// checksums of every block
+func parseZsync(data []byte) (*ZsyncControl, error) {
+	header, body, ok := bytes.Cut(data, []byte("\n\n"))
+	if !ok {
+		return nil, errors.New("not a zsync control file")
+	}
+	c := &ZsyncControl{seqMatches: 1, rsumBytes: 4, sumBytes: 16}
+	var err error
+	for _, line := range strings.Split(string(header), "\n") {
+		key, value, _ := strings.Cut(line, ":")
+		value = strings.TrimSpace(value)
+		switch key {
+		case "Filename":
+			c.Filename = safeFileName(value)
+		case "URL":
+			if c.URL == "" {
+				c.URL = value
+			}
+		case "Length":
+			c.Length, err = strconv.ParseInt(value, 10, 64)
+		case "Blocksize":
+			c.BlockSize, err = strconv.Atoi(value)
+		case "SHA-1":
+			c.SHA1 = strings.ToLower(value)
+		case "Hash-Lengths":
+			_, err = fmt.Sscanf(value, "%d,%d,%d", &c.seqMatches, &c.rsumBytes, &c.sumBytes)
+		}
+		if err != nil {
+			return nil, fmt.Errorf("%s: %w", key, err)
+		}
+	}
+	if c.BlockSize <= 0 || c.BlockSize > zsyncMaxBlockSize || c.Length < 0 || c.seqMatches < 1 || c.seqMatches > 2 ||
+		c.rsumBytes < 1 || c.rsumBytes > 4 || c.sumBytes < 3 || c.sumBytes > 16 {
+		return nil, errors.New("unsupported zsync header")
+	}
+
+	// Length+BlockSize-1 could overflow, the body bounds the count anyway
+	n := c.Length / int64(c.BlockSize)
+	if c.Length%int64(c.BlockSize) != 0 {
+		n++
+	}
+	entry := c.rsumBytes + c.sumBytes
+	if n > int64(len(body)/entry) {
+		return nil, fmt.Errorf("control file lists %d of %d blocks", len(body)/entry, n)
+	}
+	c.blocks = make([]zsyncBlock, n)
+	for i := range c.blocks {
+		e := body[i*entry : (i+1)*entry]
+		var rsum [4]byte
+		copy(rsum[4-c.rsumBytes:], e[:c.rsumBytes])
+		c.blocks[i] = zsyncBlock{rsum: binary.BigEndian.Uint32(rsum[:]), sum: e[c.rsumBytes:]}
+	}
+	return c, nil
+}
+
+// zsyncRsum is zsync's weak checksum of a block: a is the sum of its bytes
+// and b the sum of the running a, both mod 2^16
+func zsyncRsum(block []byte) (a, b uint16) {
+	for _, c := range block {
+		a += uint16(c)
+		b += a
+	}
+	return a, b
+}
+
+func (c *ZsyncControl) mask() uint32 {
+	if c.rsumBytes == 4 {
+		return ^uint32(0)
+	}
+	return 1<<(8*c.rsumBytes) - 1
+}
+
+// strongMatch checks a window against block i's MD4
+func (c *ZsyncControl) strongMatch(window []byte, i int) bool {
+	sum := md4.New()
+	sum.Write(window)
+	return bytes.Equal(sum.Sum(nil)[:c.sumBytes], c.blocks[i].sum)
+}
+
+// matchSeed rolls over a local file for windows whose checksums match the
+// control file's blocks and returns where in it each block is, -1 for the
+// ones to fetch. With two sequence matches the weak checksums of a block
+// and the next one are looked up together, like zsync does.
+func (c *ZsyncControl) matchSeed(ctx context.Context, path string) ([]int64, error) {
+	have := make([]int64, len(c.blocks))
+	for i := range have {
+		have[i] = -1
+	}
+	f, err := os.Open(path)
+	if errors.Is(err, os.ErrNotExist) {
+		return have, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+	info, err := f.Stat()
+	if err != nil || len(c.blocks) == 0 {
+		return have, err
+	}
+
+	bs, n, mask := c.BlockSize, len(c.blocks), c.mask()
+	key := func(r1, r2 uint32) uint64 { return uint64(r1&mask)<<32 | uint64(r2&mask) }
+	index := map[uint64][]int32{}
+	for i := 0; i < n; i++ {
+		var next uint32
+		if c.seqMatches > 1 && i+1 < n {
+			next = c.blocks[i+1].rsum
+		}
+		k := key(c.blocks[i].rsum, next)
+		index[k] = append(index[k], int32(i))
+	}
+	// The last block has no next one to pair with, looked up alone
+	last := c.blocks[n-1].rsum & mask
+
+	w := &seedWindow{f: f, size: info.Size(), buf: make([]byte, 0, 4<<20+2*bs+1)}
+	var a1, b1, a2, b2 uint16
+	fresh := true
+	for pos, steps := int64(0), 0; pos < w.size; steps++ {
+		if steps&(1<<20-1) == 0 && ctx.Err() != nil {
+			return nil, ctx.Err()
+		}
+		win, err := w.at(pos, 2*bs+1)
+		if err != nil {
+			return nil, err
+		}
+		if fresh {
+			a1, b1 = zsyncRsum(win[:bs])
+			a2, b2 = zsyncRsum(win[bs : 2*bs])
+			fresh = false
+		}
+		r1, r2 := uint32(a1)<<16|uint32(b1), uint32(a2)<<16|uint32(b2)
+		if c.seqMatches == 1 {
+			r2 = 0
+		}
+		matched := false
+		for _, i := range index[key(r1, r2)] {
+			if have[i] < 0 && c.strongMatch(win[:bs], int(i)) &&
+				(c.seqMatches == 1 || int(i)+1 == n || c.strongMatch(win[bs:2*bs], int(i)+1)) {
+				have[i] = pos
+				matched = true
+			}
+		}
+		if r1&mask == last && have[n-1] < 0 && c.strongMatch(win[:bs], n-1) {
+			have[n-1] = pos
+			matched = true
+		}
+		if matched {
+			pos += int64(bs)
+			fresh = true
+			continue
+		}
+
+		// Roll both windows a byte on
+		out, mid, in := uint16(win[0]), uint16(win[bs]), uint16(win[2*bs])
+		a1 += mid - out
+		b1 += a1 - uint16(bs)*out
+		a2 += in - mid
+		b2 += a2 - uint16(bs)*mid
+		pos++
+	}
+	return have, nil
+}
+
+// seedWindow reads a file through a large buffer for matchSeed's windows,
+// zero past its end like zsync pads the last block
+type seedWindow struct {
+	f    *os.File
+	size int64
+	buf  []byte
+	base int64 // File offset of buf[0]
+}
+
+func (s *seedWindow) at(pos int64, n int) ([]byte, error) {
+	if pos+int64(n) > s.base+int64(len(s.buf)) && s.base+int64(len(s.buf)) < s.size {
+		s.buf = s.buf[:cap(s.buf)]
+		read, err := s.f.ReadAt(s.buf, pos)
+		if err != nil && err != io.EOF {
+			return nil, err
+		}
+		s.buf, s.base = s.buf[:read], pos
+	}
+	off := int(pos - s.base)
+	if off+n <= len(s.buf) {
+		return s.buf[off : off+n], nil
+	}
+	win := make([]byte, n)
+	copy(win, s.buf[off:])
+	return win, nil
+}
+
+// ZsyncDownloader updates an older copy of a file to the one a .zsync
+// control file describes: blocks the copy already has are reused and only
+// the rest is fetched with range requests
+type ZsyncDownloader struct {
+	Config  Config
+	Stats   *Stats
+	Client  *http.Client
+	Reused  int64 // Bytes taken from the old copy
+	Fetched int64 // Bytes requested from the server
+}
+
+// NewZsyncDownloader creates the update of cfg.OutputName, or the control
+// file's name in cfg.Dir, from cfg.Zsync
+func NewZsyncDownloader(cfg Config) *ZsyncDownloader {
+	return &ZsyncDownloader{
+		Config: cfg,
+		Stats:  &Stats{},
+		Client: NewClient(cfg),
+	}
+}
+
+// Progress returns the live statistics of the download
+func (z *ZsyncDownloader) Progress() *Stats {
+	return z.Stats
+}
+
+// Start finds the reusable blocks, builds the new file beside the old one
+// from them and the fetched ranges, verifies it and moves it into place
+func (z *ZsyncDownloader) Start(ctx context.Context) error {
+	c := z.Config.Zsync
+	if z.Config.URL == "" {
+		z.Config.URL = c.URL
+	}
+	if z.Config.OutputName == "" {
+		name := c.Filename
+		if u, err := url.Parse(z.Config.URL); name == "" && err == nil {
+			name = urlFileName(u)
+		}
+		if name == "" {
+			return errors.New("no output name, give one with --output")
+		}
+		z.Config.OutputName = z.Config.defaultOutput(name)
+	}
+	out := z.Config.OutputName
+	log := z.Config.logger()
+
+	have, err := c.matchSeed(ctx, out)
+	if err != nil {
+		return fmt.Errorf("reading %s: %w", out, err)
+	}
+	z.Stats.SetTotal(c.Length)
+
+	if dir := filepath.Dir(out); dir != "" {
+		if err := os.MkdirAll(dir, 0o755); err != nil {
+			return err
+		}
+	}
+	tmp := tmpPath(out)
+	f, err := os.Create(tmp)
+	if err != nil {
+		return err
+	}
+	defer func() {
+		if f != nil {
+			f.Close()
+			os.Remove(tmp)
+		}
+	}()
+	if err := f.Truncate(c.Length); err != nil {
+		return err
+	}
+
+	reuse, fetch := z.plan(have)
+	if err := z.copyBlocks(out, f, reuse); err != nil {
+		return fmt.Errorf("copying from %s: %w", out, err)
+	}
+	msg := fmt.Sprintf("zsync: %d of %d bytes reused from %s, fetching %d in %d ranges", z.Reused, c.Length, out, z.Fetched, len(fetch))
+	if z.Config.Trace != nil {
+		z.Config.Trace(msg)
+	}
+	log.Info(msg)
+	z.Stats.BeginRate(time.Now())
+	if err := z.fetchRanges(ctx, f, fetch); err != nil {
+		return err
+	}
+
+	if err := f.Close(); err != nil {
+		return err
+	}
+	if c.SHA1 != "" {
+		sum := &Checksum{Algo: "sha1", Value: c.SHA1}
+		if err := sum.Verify(tmp); err != nil {
+			return fmt.Errorf("verification failed: %w", err)
+		}
+	}
+	if z.Config.Checksum != nil {
+		if err := z.Config.Checksum.Verify(tmp); err != nil {
+			return fmt.Errorf("verification failed: %w", err)
+		}
+	}
+	if err := commitFile(tmp, out, z.Config.Fsync); err != nil {
+		return fmt.Errorf("failed to move the output into place: %w", err)
+	}
+	f = nil
+	return nil
+}
+
+// byteSpan is a window of the target file, with where it is in the old
+// copy for the reused ones
+type byteSpan struct {
+	off, n, from int64
+}
+
+// plan merges consecutive blocks into spans to copy and ranges to fetch
+func (z *ZsyncDownloader) plan(have []int64) (reuse, fetch []byteSpan) {
+	c := z.Config.Zsync
+	bs := int64(c.BlockSize)
+	for i, from := range have {
+		off := int64(i) * bs
+		n := bs
+		if off+n > c.Length {
+			n = c.Length - off
+		}
+		if from >= 0 {
+			z.Reused += n
+			if k := len(reuse) - 1; k >= 0 && reuse[k].off+reuse[k].n == off && reuse[k].from+reuse[k].n == from {
+				reuse[k].n += n
+			} else {
+				reuse = append(reuse, byteSpan{off: off, n: n, from: from})
+			}
+			continue
+		}
+		z.Fetched += n
+		if k := len(fetch) - 1; k >= 0 && fetch[k].off+fetch[k].n == off && fetch[k].n < zsyncRangeSize {
+			fetch[k].n += n
+		} else {
+			fetch = append(fetch, byteSpan{off: off, n: n})
+		}
+	}
+	return reuse, fetch
+}
+
+func (z *ZsyncDownloader) copyBlocks(seed string, dst *os.File, reuse []byteSpan) error {
+	if len(reuse) == 0 {
+		return nil
+	}
+	src, err := os.Open(seed)
+	if err != nil {
+		return err
+	}
+	defer src.Close()
+	for _, s := range reuse {
+		if _, err := io.Copy(io.NewOffsetWriter(dst, s.off), io.NewSectionReader(src, s.from, s.n)); err != nil {
+			return err
+		}
+		z.Stats.AddDownloaded(s.n)
+	}
+	return nil
+}
+
+// fetchRanges downloads the missing spans over Config.Concurrency
+// connections, retrying each like a part
+func (z *ZsyncDownloader) fetchRanges(ctx context.Context, dst *os.File, spans []byteSpan) error {
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+	workers := z.Config.Concurrency
+	if workers < 1 {
+		workers = 1
+	}
+	if workers > len(spans) {
+		workers = len(spans)
+	}
+	jobs := make(chan byteSpan)
+	errc := make(chan error, workers)
+	var wg sync.WaitGroup
+	for w := 0; w < workers; w++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for s := range jobs {
+				if err := z.fetchWithRetry(ctx, dst, s); err != nil {
+					errc <- err
+					cancel()
+					return
+				}
+			}
+		}()
+	}
+	for _, s := range spans {
+		select {
+		case jobs <- s:
+		case <-ctx.Done():
+		}
+	}
+	close(jobs)
+	wg.Wait()
+	close(errc)
+	if err := <-errc; err != nil {
+		return err
+	}
+	return ctx.Err()
+}
+
+func (z *ZsyncDownloader) fetchWithRetry(ctx context.Context, dst *os.File, s byteSpan) error {
+	policy := z.Config.retryPolicy()
+	for attempt := 0; ; attempt++ {
+		n, err := z.fetchRange(ctx, dst, s)
+		if err == nil {
+			return nil
+		}
+		// The retry fetches the whole span again
+		z.Stats.AddDownloaded(-n)
+		if ctx.Err() != nil || attempt >= policy.Retries || !policy.retryPart(err) {
+			return fmt.Errorf("bytes %d-%d: %w", s.off, s.off+s.n-1, err)
+		}
+		if err := policy.sleep(ctx, attempt); err != nil {
+			return err
+		}
+	}
+}
+
+func (z *ZsyncDownloader) fetchRange(ctx context.Context, dst *os.File, s byteSpan) (int64, error) {
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, z.Config.URL, nil)
+	if err != nil {
+		return 0, err
+	}
+	req.Header.Set("User-Agent", defaultUserAgent)
+	req.Header.Set("Range", fmt.Sprintf("bytes=%d-%d", s.off, s.off+s.n-1))
+	resp, err := z.Client.Do(req)
+	if err != nil {
+		return 0, err
+	}
+	defer resp.Body.Close()
+	switch {
+	case resp.StatusCode == http.StatusOK:
+		return 0, errors.New("the server ignores range requests, which zsync needs")
+	case resp.StatusCode != http.StatusPartialContent:
+		return 0, newStatusError(resp)
+	case !strings.HasPrefix(resp.Header.Get("Content-Range"), fmt.Sprintf("bytes %d-", s.off)):
+		return 0, fmt.Errorf("server sent %q for bytes %d-%d", resp.Header.Get("Content-Range"), s.off, s.off+s.n-1)
+	}
+	body := &countingReader{r: io.LimitReader(resp.Body, s.n), stats: z.Stats}
+	n, err := io.Copy(io.NewOffsetWriter(dst, s.off), body)
+	if err == nil && n < s.n {
+		err = io.ErrUnexpectedEOF
+	}
+	return n, err
+}
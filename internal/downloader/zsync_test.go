@@ -0,0 +1,111 @@
+package downloader
+
+import (
+	"bytes"
+	"context"
+	"crypto/sha1"
+	"encoding/hex"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"golang.org/x/crypto/md4"
+)
+
+// makeZsync builds the control file of data the way zsyncmake does, with
+// full 4-byte weak and 16-byte strong checksums per block
+func makeZsync(data []byte, bs int, seqMatches int) []byte {
+	sha := sha1.Sum(data)
+	var b bytes.Buffer
+	fmt.Fprintf(&b, "zsync: 0.6.2\nFilename: file.bin\nBlocksize: %d\nLength: %d\nHash-Lengths: %d,4,16\nURL: file.bin\nSHA-1: %s\n\n",
+		bs, len(data), seqMatches, hex.EncodeToString(sha[:]))
+	for off := 0; off < len(data); off += bs {
+		block := make([]byte, bs)
+		copy(block, data[off:])
+		a, s := zsyncRsum(block)
+		b.Write([]byte{byte(a >> 8), byte(a), byte(s >> 8), byte(s)})
+		sum := md4.New()
+		sum.Write(block)
+		b.Write(sum.Sum(nil))
+	}
+	return b.Bytes()
+}
+
+func TestParseZsync(t *testing.T) {
+	data := bytes.Repeat([]byte("0123456789abcdef"), 40)
+	c, err := parseZsync(makeZsync(data, 64, 2))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if c.Filename != "file.bin" || c.URL != "file.bin" || c.Length != int64(len(data)) || c.BlockSize != 64 {
+		t.Errorf("header = %+v", c)
+	}
+	if len(c.blocks) != 10 || c.seqMatches != 2 || c.rsumBytes != 4 || c.sumBytes != 16 {
+		t.Errorf("%d blocks, hash lengths %d,%d,%d", len(c.blocks), c.seqMatches, c.rsumBytes, c.sumBytes)
+	}
+	if !c.strongMatch(data[64:128], 1) || c.strongMatch(data[1:65], 0) {
+		t.Error("strong checksums don't match their blocks")
+	}
+}
+
+func TestParseZsyncRejects(t *testing.T) {
+	tests := []struct {
+		name, header, body string
+	}{
+		{"no body separator", "Blocksize: 64\nLength: 1", ""},
+		{"no block size", "Length: 1", "xxxxxxxx"},
+		{"negative length", "Blocksize: 64\nLength: -1", ""},
+		{"huge block size", "Blocksize: 1073741824\nLength: 1", strings.Repeat("x", 20)},
+		{"too short sums", "Blocksize: 64\nLength: 1\nHash-Lengths: 1,4,2", strings.Repeat("x", 6)},
+		{"three sequence matches", "Blocksize: 64\nLength: 1\nHash-Lengths: 3,4,16", strings.Repeat("x", 20)},
+		{"blocks missing", "Blocksize: 64\nLength: 129", strings.Repeat("x", 40)},
+		{"length overflowing the block count", "Blocksize: 1\nLength: 9223372036854775807\nHash-Lengths: 1,1,3", strings.Repeat("x", 8)},
+		{"length not a number", "Blocksize: 64\nLength: ten", ""},
+	}
+	for _, tt := range tests {
+		src := tt.header
+		if tt.name != "no body separator" {
+			src += "\n\n" + tt.body
+		}
+		if c, err := parseZsync([]byte(src)); err == nil {
+			t.Errorf("%s: parsed %d blocks", tt.name, len(c.blocks))
+		}
+	}
+}
+
+func TestZsyncMatchSeed(t *testing.T) {
+	bs := 32
+	data := make([]byte, 10*bs)
+	for i := range data {
+		data[i] = byte(i * 7 % 251)
+	}
+	// The seed has blocks 2 and 3 shifted by 5 bytes and then zeros. With
+	// two sequence matches block 3 needs block 4 after it, which isn't
+	// there.
+	seed := append(append([]byte("hello"), data[2*bs:4*bs]...), bytes.Repeat([]byte{0}, bs)...)
+	path := filepath.Join(t.TempDir(), "seed")
+	if err := os.WriteFile(path, seed, 0o644); err != nil {
+		t.Fatal(err)
+	}
+	for _, seq := range []int{1, 2} {
+		c, err := parseZsync(makeZsync(data, bs, seq))
+		if err != nil {
+			t.Fatal(err)
+		}
+		have, err := c.matchSeed(context.Background(), path)
+		if err != nil {
+			t.Fatal(err)
+		}
+		for i, at := range have {
+			want := int64(-1)
+			if i == 2 || i == 3 && seq == 1 {
+				want = int64(5 + (i-2)*bs)
+			}
+			if at != want {
+				t.Errorf("seq %d: block %d at %d, want %d", seq, i, at, want)
+			}
+		}
+	}
+}
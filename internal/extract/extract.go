@@ -0,0 +1,251 @@
+// Package extract unpacks downloaded archives: zip and tar, plain or
+// compressed with gzip, bzip2, zstd or xz, and 7z. Entries that would land
+// outside the destination are refused.
+package extract
+
+import (
+	"bufio"
+	"bytes"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+// Format is an archive type Extract knows
+type Format int
+
+const (
+	Unknown Format = iota
+	Zip
+	Tar
+	TarGzip
+	TarBzip2
+	TarXz
+	TarZstd
+	SevenZip
+)
+
+func (f Format) String() string {
+	switch f {
+	case Zip:
+		return "zip"
+	case Tar:
+		return "tar"
+	case TarGzip:
+		return "tar.gz"
+	case TarBzip2:
+		return "tar.bz2"
+	case TarXz:
+		return "tar.xz"
+	case TarZstd:
+		return "tar.zst"
+	case SevenZip:
+		return "7z"
+	}
+	return "unknown"
+}
+
+var (
+	magicZip   = []byte("PK\x03\x04")
+	magicGzip  = []byte{0x1f, 0x8b}
+	magicBzip2 = []byte("BZh")
+	magicXz    = []byte{0xfd, '7', 'z', 'X', 'Z', 0}
+	magicZstd  = []byte{0x28, 0xb5, 0x2f, 0xfd}
+	magic7z    = []byte{'7', 'z', 0xbc, 0xaf, 0x27, 0x1c}
+)
+
+// Detect tells the format of the file at path from its content, not its
+// name. A compressed file only counts as an archive when it holds a tar.
+func Detect(path string) (Format, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return Unknown, err
+	}
+	defer f.Close()
+	head := make([]byte, 512)
+	n, err := io.ReadFull(f, head)
+	if err != nil && err != io.ErrUnexpectedEOF {
+		return Unknown, err
+	}
+	head = head[:n]
+
+	var format Format
+	switch {
+	case bytes.HasPrefix(head, magicZip):
+		return Zip, nil
+	case bytes.HasPrefix(head, magic7z):
+		return SevenZip, nil
+	case isTar(head):
+		return Tar, nil
+	case bytes.HasPrefix(head, magicGzip):
+		format = TarGzip
+	case bytes.HasPrefix(head, magicBzip2):
+		format = TarBzip2
+	case bytes.HasPrefix(head, magicZstd):
+		format = TarZstd
+	case bytes.HasPrefix(head, magicXz):
+		// Decoding needs the xz tool, take the name's word for it
+		if strings.HasSuffix(path, ".tar.xz") || strings.HasSuffix(path, ".txz") {
+			return TarXz, nil
+		}
+		return Unknown, nil
+	default:
+		return Unknown, nil
+	}
+
+	if _, err := f.Seek(0, io.SeekStart); err != nil {
+		return Unknown, err
+	}
+	r, closeFn, err := decompress(format, bufio.NewReader(f), path)
+	if err != nil {
+		return Unknown, nil
+	}
+	defer closeFn()
+	inner := make([]byte, 512)
+	n, _ = io.ReadFull(r, inner)
+	if isTar(inner[:n]) {
+		return format, nil
+	}
+	return Unknown, nil
+}
+
+// isTar looks for the ustar magic of POSIX and GNU tar headers
+func isTar(head []byte) bool {
+	return len(head) >= 262 && bytes.Equal(head[257:262], []byte("ustar"))
+}
+
+// Extract unpacks the archive at path into dir, which is created if needed,
+// and returns the number of entries written
+func Extract(path, dir string) (int, error) {
+	format, err := Detect(path)
+	if err != nil {
+		return 0, err
+	}
+	if err := os.MkdirAll(dir, 0o755); err != nil {
+		return 0, err
+	}
+	switch format {
+	case Zip:
+		return extractZip(path, dir)
+	case SevenZip:
+		return extract7z(path, dir)
+	case Unknown:
+		return 0, fmt.Errorf("%s is not an archive warp-dl can extract (zip, tar, tar.gz, tar.bz2, tar.xz, tar.zst or 7z)", path)
+	}
+
+	f, err := os.Open(path)
+	if err != nil {
+		return 0, err
+	}
+	defer f.Close()
+	r, closeFn, err := decompress(format, bufio.NewReader(f), path)
+	if err != nil {
+		return 0, err
+	}
+	n, err := extractTar(r, dir)
+	if cerr := closeFn(); err == nil {
+		err = cerr
+	}
+	return n, err
+}
+
+// target resolves an entry name inside dir, refusing absolute names and
+// names that climb out of it
+func target(dir, name string) (string, error) {
+	clean := filepath.Clean(filepath.FromSlash(name))
+	if filepath.IsAbs(clean) || filepath.VolumeName(clean) != "" || clean == ".." || strings.HasPrefix(clean, ".."+string(filepath.Separator)) {
+		return "", fmt.Errorf("archive entry %q points outside the destination", name)
+	}
+	return filepath.Join(dir, clean), nil
+}
+
+// checkParents refuses to write path through a symlink the archive made
+// earlier, which could lead anywhere
+func checkParents(dir, path string) error {
+	rel, err := filepath.Rel(dir, filepath.Dir(path))
+	if err != nil || rel == "." {
+		return err
+	}
+	at := dir
+	for _, elem := range strings.Split(rel, string(filepath.Separator)) {
+		at = filepath.Join(at, elem)
+		info, err := os.Lstat(at)
+		if os.IsNotExist(err) {
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+		if info.Mode()&os.ModeSymlink != 0 {
+			return fmt.Errorf("archive entry %s goes through the symlink %s", path, at)
+		}
+	}
+	return nil
+}
+
+// maxLinkHops is how many symlinks resolveInside follows for one path
+const maxLinkHops = 40
+
+// resolveInside follows path, below dir, the way the OS will: through each
+// symlink on its way, as far as the files exist. It fails when that leaves
+// dir, so a chain of links, each harmless on its own, can't lead out.
+func resolveInside(dir, path string) error {
+	dir = filepath.Clean(dir)
+	rel, err := filepath.Rel(dir, path)
+	if err != nil {
+		return err
+	}
+	todo := strings.Split(rel, string(filepath.Separator))
+	at, hops := dir, 0
+	for len(todo) > 0 {
+		elem := todo[0]
+		todo = todo[1:]
+		switch elem {
+		case "", ".":
+			continue
+		case "..":
+			if at == dir {
+				return fmt.Errorf("archive entry %s resolves outside the destination", path)
+			}
+			at = filepath.Dir(at)
+			continue
+		}
+		next := filepath.Join(at, elem)
+		info, err := os.Lstat(next)
+		if err != nil || info.Mode()&os.ModeSymlink == 0 {
+			at = next
+			continue
+		}
+		link, err := os.Readlink(next)
+		if err != nil {
+			return err
+		}
+		if hops++; hops > maxLinkHops || filepath.IsAbs(link) || filepath.VolumeName(link) != "" {
+			return fmt.Errorf("archive entry %s resolves outside the destination", path)
+		}
+		todo = append(strings.Split(filepath.FromSlash(link), string(filepath.Separator)), todo...)
+	}
+	return nil
+}
+
+// writeFile creates an entry's file below dir, replacing what is there
+func writeFile(dir, path string, r io.Reader, mode os.FileMode) error {
+	if err := checkParents(dir, path); err != nil {
+		return err
+	}
+	if err := os.MkdirAll(filepath.Dir(path), 0o755); err != nil {
+		return err
+	}
+	os.Remove(path)
+	f, err := os.OpenFile(path, os.O_WRONLY|os.O_CREATE|os.O_EXCL, mode.Perm()|0o200)
+	if err != nil {
+		return err
+	}
+	_, err = io.Copy(f, r)
+	if cerr := f.Close(); err == nil {
+		err = cerr
+	}
+	return err
+}
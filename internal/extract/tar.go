@@ -0,0 +1,165 @@
+package extract
+
+import (
+	"archive/tar"
+	"bufio"
+	"compress/bzip2"
+	"compress/gzip"
+	"fmt"
+	"io"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"time"
+
+	"github.com/klauspost/compress/zstd"
+)
+
+// zstdMaxWindow is the largest window a .tar.zst frame may ask for,
+// zstd --long=27 and below
+const zstdMaxWindow = 128 << 20
+
+// decompress opens the tar stream inside a compressed file. xz has no
+// decoder in the standard library, the xz tool does that part.
+func decompress(format Format, r *bufio.Reader, path string) (io.Reader, func() error, error) {
+	none := func() error { return nil }
+	switch format {
+	case Tar:
+		return r, none, nil
+	case TarGzip:
+		zr, err := gzip.NewReader(r)
+		if err != nil {
+			return nil, nil, err
+		}
+		return zr, zr.Close, nil
+	case TarBzip2:
+		return bzip2.NewReader(r), none, nil
+	case TarZstd:
+		zr, err := zstd.NewReader(r, zstd.WithDecoderConcurrency(1), zstd.WithDecoderMaxWindow(zstdMaxWindow))
+		if err != nil {
+			return nil, nil, err
+		}
+		return zr, func() error { zr.Close(); return nil }, nil
+	case TarXz:
+		cmd := exec.Command("xz", "-dc", path)
+		out, err := cmd.StdoutPipe()
+		if err != nil {
+			return nil, nil, err
+		}
+		cmd.Stderr = os.Stderr
+		if err := cmd.Start(); err != nil {
+			return nil, nil, fmt.Errorf("tar.xz needs the xz tool: %w", err)
+		}
+		return out, func() error {
+			// Drain so xz isn't stuck writing when tar stopped early
+			io.Copy(io.Discard, out)
+			return cmd.Wait()
+		}, nil
+	}
+	return nil, nil, fmt.Errorf("not a tar format: %s", format)
+}
+
+// extractTar writes the entries of a tar stream below dir. Links may only
+// point inside dir, also when followed through the archive's other links.
+func extractTar(r io.Reader, dir string) (int, error) {
+	tr := tar.NewReader(r)
+	type dirTime struct {
+		path string
+		mod  time.Time
+	}
+	var dirs []dirTime
+	var links []string // Symlinks made, checked again at the end
+	n := 0
+	for {
+		h, err := tr.Next()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return n, err
+		}
+		path, err := target(dir, h.Name)
+		if err != nil {
+			return n, err
+		}
+		if path == filepath.Clean(dir) {
+			continue
+		}
+
+		switch h.Typeflag {
+		case tar.TypeDir:
+			if err := checkParents(dir, path); err != nil {
+				return n, err
+			}
+			if err := os.MkdirAll(path, h.FileInfo().Mode().Perm()|0o700); err != nil {
+				return n, err
+			}
+			dirs = append(dirs, dirTime{path, h.ModTime})
+			continue
+		case tar.TypeReg:
+			if err := writeFile(dir, path, tr, h.FileInfo().Mode()); err != nil {
+				return n, err
+			}
+		case tar.TypeSymlink:
+			if _, err := target(dir, filepath.Join(filepath.Dir(h.Name), h.Linkname)); err != nil || filepath.IsAbs(h.Linkname) {
+				return n, fmt.Errorf("archive entry %s links outside the destination: %s", h.Name, h.Linkname)
+			}
+			if err := checkParents(dir, path); err != nil {
+				return n, err
+			}
+			if err := os.MkdirAll(filepath.Dir(path), 0o755); err != nil {
+				return n, err
+			}
+			os.Remove(path)
+			if err := os.Symlink(h.Linkname, path); err != nil {
+				return n, err
+			}
+			if err := resolveInside(dir, path); err != nil {
+				os.Remove(path)
+				return n, err
+			}
+			links = append(links, path)
+			n++
+			continue
+		case tar.TypeLink:
+			old, err := target(dir, h.Linkname)
+			if err != nil {
+				return n, err
+			}
+			// The source is linked as it is, never through a symlink, and
+			// a symlink linked elsewhere would resolve from there
+			if err := checkParents(dir, old); err != nil {
+				return n, err
+			}
+			if info, err := os.Lstat(old); err == nil && !info.Mode().IsRegular() {
+				return n, fmt.Errorf("archive entry %s hard links %s, which is not a file", h.Name, h.Linkname)
+			}
+			if err := checkParents(dir, path); err != nil {
+				return n, err
+			}
+			os.Remove(path)
+			if err := os.Link(old, path); err != nil {
+				return n, err
+			}
+			n++
+			continue
+		default:
+			// Devices, FIFOs and the like have no place in a download
+			continue
+		}
+		os.Chtimes(path, h.ModTime, h.ModTime)
+		n++
+	}
+	// A link made later may have redirected one made earlier
+	for _, l := range links {
+		if err := resolveInside(dir, l); err != nil {
+			os.Remove(l)
+			return n, err
+		}
+	}
+	// Last, writing their files changed them
+	for _, d := range dirs {
+		os.Chtimes(d.path, d.mod, d.mod)
+	}
+	return n, nil
+}
@@ -0,0 +1,72 @@
+package extract
+
+import (
+	"archive/zip"
+	"fmt"
+	"os"
+	"os/exec"
+	"path/filepath"
+)
+
+// extractZip writes the files of a zip archive below dir. Symlinks stored
+// in it are skipped, they are rare in zips and a common trick.
+func extractZip(path, dir string) (int, error) {
+	zr, err := zip.OpenReader(path)
+	if err != nil {
+		return 0, err
+	}
+	defer zr.Close()
+	n := 0
+	for _, f := range zr.File {
+		dst, err := target(dir, f.Name)
+		if err != nil {
+			return n, err
+		}
+		mode := f.Mode()
+		switch {
+		case mode.IsDir():
+			if err := checkParents(dir, dst); err != nil {
+				return n, err
+			}
+			if err := os.MkdirAll(dst, mode.Perm()|0o700); err != nil {
+				return n, err
+			}
+			continue
+		case !mode.IsRegular():
+			continue
+		}
+		rc, err := f.Open()
+		if err != nil {
+			return n, err
+		}
+		err = writeFile(dir, dst, rc, mode)
+		rc.Close()
+		if err != nil {
+			return n, fmt.Errorf("failed to extract %s: %w", f.Name, err)
+		}
+		os.Chtimes(dst, f.Modified, f.Modified)
+		n++
+	}
+	return n, nil
+}
+
+// extract7z hands a 7z archive to whichever 7-Zip command is installed.
+// The count is unknown then, it returns the entries now in dir instead.
+func extract7z(path, dir string) (int, error) {
+	var tool string
+	for _, name := range []string{"7z", "7zz", "7za"} {
+		if p, err := exec.LookPath(name); err == nil {
+			tool = p
+			break
+		}
+	}
+	if tool == "" {
+		return 0, fmt.Errorf("extracting 7z needs 7-Zip (7z, 7zz or 7za) in PATH")
+	}
+	cmd := exec.Command(tool, "x", "-y", "-bd", "-o"+dir, path)
+	if out, err := cmd.CombinedOutput(); err != nil {
+		return 0, fmt.Errorf("%s failed: %w\n%s", filepath.Base(tool), err, out)
+	}
+	entries, err := os.ReadDir(dir)
+	return len(entries), err
+}
@@ -0,0 +1,228 @@
+// Package history keeps a record of finished downloads, for looking them
+// up later and for noticing a URL that was downloaded before.
+package history
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+)
+
+// Entry is one finished download
+type Entry struct {
+	URL      string        `json:"url"` // As requested, with any password redacted
+	FinalURL string        `json:"final_url,omitempty"`
+	Path     string        `json:"path"`
+	Size     int64         `json:"size"`
+	Checksum string        `json:"checksum,omitempty"` // algo:hex, empty for directories
+	Duration time.Duration `json:"duration"`
+	Speed    float64       `json:"speed"` // Average bytes per second
+	Finished time.Time     `json:"finished"`
+}
+
+// DB is the history file: a JSON entry per line, appended as downloads
+// finish, so concurrent warp-dl runs don't lose each other's entries.
+// Lines that don't parse, e.g. a write cut short by a crash, are skipped.
+type DB struct {
+	path string
+}
+
+// maxLine bounds an entry's line, longer ones are skipped like corrupt ones
+const maxLine = 1 << 20
+
+// DefaultPath is history.jsonl next to the config file
+func DefaultPath() string {
+	dir, err := os.UserConfigDir()
+	if err != nil {
+		return ""
+	}
+	return filepath.Join(dir, "warp-dl", "history.jsonl")
+}
+
+// Open returns the history kept at path, which is created with the first
+// entry
+func Open(path string) *DB {
+	return &DB{path: path}
+}
+
+// Path is where the history is kept
+func (db *DB) Path() string {
+	return db.path
+}
+
+// Add appends an entry
+func (db *DB) Add(e Entry) error {
+	line, err := json.Marshal(e)
+	if err != nil {
+		return err
+	}
+	unlock, err := db.lock(false)
+	if err != nil {
+		return err
+	}
+	defer unlock()
+	f, err := os.OpenFile(db.path, os.O_WRONLY|os.O_CREATE|os.O_APPEND, 0o600)
+	if err != nil {
+		return err
+	}
+	// One write per entry, O_APPEND keeps the lines of concurrent
+	// writers whole
+	if _, err := f.Write(append(line, '\n')); err != nil {
+		f.Close()
+		return err
+	}
+	return f.Close()
+}
+
+// All returns every entry, oldest first. A missing history is empty.
+func (db *DB) All() ([]Entry, error) {
+	f, err := os.Open(db.path)
+	if errors.Is(err, fs.ErrNotExist) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	var entries []Entry
+	r := bufio.NewReaderSize(f, 64*1024)
+	for {
+		line, err := readLine(r)
+		var e Entry
+		if len(line) > 0 && json.Unmarshal(line, &e) == nil && e.URL != "" {
+			entries = append(entries, e)
+		}
+		if err == io.EOF {
+			return entries, nil
+		}
+		if err != nil {
+			return nil, fmt.Errorf("%s: %w", db.path, err)
+		}
+	}
+}
+
+// readLine returns the next line without its newline, or nil for one
+// longer than maxLine, which is read past
+func readLine(r *bufio.Reader) ([]byte, error) {
+	var line []byte
+	long := false
+	for {
+		chunk, err := r.ReadSlice('\n')
+		if !long && len(line)+len(chunk) > maxLine+1 {
+			long, line = true, nil
+		} else if !long {
+			line = append(line, chunk...)
+		}
+		if err == bufio.ErrBufferFull {
+			continue
+		}
+		return bytes.TrimSuffix(line, []byte("\n")), err
+	}
+}
+
+// lock takes the lock file next to the history until the returned func is
+// called. Adds share it and Clear takes it alone, so no entry goes to the
+// file Clear is about to replace.
+func (db *DB) lock(exclusive bool) (func(), error) {
+	if err := os.MkdirAll(filepath.Dir(db.path), 0o755); err != nil {
+		return nil, err
+	}
+	f, err := os.OpenFile(db.path+".lock", os.O_RDWR|os.O_CREATE, 0o600)
+	if err != nil {
+		return nil, err
+	}
+	if err := lockFile(f, exclusive); err != nil {
+		f.Close()
+		return nil, fmt.Errorf("failed to lock %s: %w", f.Name(), err)
+	}
+	return func() { f.Close() }, nil
+}
+
+// Search returns the entries whose URL or path contains text, ignoring
+// case, oldest first
+func (db *DB) Search(text string) ([]Entry, error) {
+	all, err := db.All()
+	if err != nil {
+		return nil, err
+	}
+	text = strings.ToLower(text)
+	var found []Entry
+	for _, e := range all {
+		if strings.Contains(strings.ToLower(e.URL), text) || strings.Contains(strings.ToLower(e.FinalURL), text) ||
+			strings.Contains(strings.ToLower(e.Path), text) {
+			found = append(found, e)
+		}
+	}
+	return found, nil
+}
+
+// Lookup returns the downloads of url, newest first
+func (db *DB) Lookup(url string) ([]Entry, error) {
+	all, err := db.All()
+	if err != nil {
+		return nil, err
+	}
+	var found []Entry
+	for i := len(all) - 1; i >= 0; i-- {
+		if all[i].URL == url || all[i].FinalURL == url {
+			found = append(found, all[i])
+		}
+	}
+	return found, nil
+}
+
+// Clear deletes the entries that finished before the given time, all of
+// them for the zero time, and returns how many went. The rest is rewritten
+// aside and renamed into place.
+func (db *DB) Clear(before time.Time) (int, error) {
+	unlock, err := db.lock(true)
+	if err != nil {
+		return 0, err
+	}
+	defer unlock()
+	all, err := db.All()
+	if err != nil {
+		return 0, err
+	}
+	if before.IsZero() {
+		if err := os.Remove(db.path); err != nil && !errors.Is(err, fs.ErrNotExist) {
+			return 0, err
+		}
+		return len(all), nil
+	}
+
+	var keep []byte
+	removed := 0
+	for _, e := range all {
+		if e.Finished.Before(before) {
+			removed++
+			continue
+		}
+		line, err := json.Marshal(e)
+		if err != nil {
+			return 0, err
+		}
+		keep = append(append(keep, line...), '\n')
+	}
+	if removed == 0 {
+		return 0, nil
+	}
+	tmp := fmt.Sprintf("%s.%d.tmp", db.path, os.Getpid())
+	if err := os.WriteFile(tmp, keep, 0o600); err != nil {
+		return 0, err
+	}
+	if err := os.Rename(tmp, db.path); err != nil {
+		os.Remove(tmp)
+		return 0, err
+	}
+	return removed, nil
+}
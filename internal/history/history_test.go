@@ -0,0 +1,98 @@
+package history
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+func TestAllSkipsBadLines(t *testing.T) {
+	db := Open(filepath.Join(t.TempDir(), "history.jsonl"))
+	if all, err := db.All(); err != nil || len(all) != 0 {
+		t.Fatalf("missing history: %v, %v", all, err)
+	}
+	day := time.Date(2024, 5, 1, 12, 0, 0, 0, time.UTC)
+	if err := db.Add(Entry{URL: "http://a/1", Path: "/tmp/1", Finished: day}); err != nil {
+		t.Fatal(err)
+	}
+	f, err := os.OpenFile(db.Path(), os.O_WRONLY|os.O_APPEND, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	// A line over the limit, one cut short and one without a URL
+	fmt.Fprintf(f, `{"url":"http://long/%s"}`+"\n", strings.Repeat("x", 3*maxLine))
+	fmt.Fprint(f, `{"url":"http://cut`+"\n")
+	fmt.Fprint(f, `{"path":"/tmp/none"}`+"\n")
+	f.Close()
+	if err := db.Add(Entry{URL: "http://a/2", Path: "/tmp/2", Finished: day.Add(time.Hour)}); err != nil {
+		t.Fatal(err)
+	}
+
+	all, err := db.All()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(all) != 2 || all[0].URL != "http://a/1" || all[1].URL != "http://a/2" {
+		t.Fatalf("All = %+v", all)
+	}
+	found, err := db.Search("/TMP/2")
+	if err != nil || len(found) != 1 || found[0].URL != "http://a/2" {
+		t.Errorf("Search = %+v, %v", found, err)
+	}
+	found, err = db.Lookup("http://a/1")
+	if err != nil || len(found) != 1 {
+		t.Errorf("Lookup = %+v, %v", found, err)
+	}
+
+	removed, err := db.Clear(day.Add(time.Minute))
+	if err != nil || removed != 1 {
+		t.Fatalf("Clear = %d, %v", removed, err)
+	}
+	if all, _ := db.All(); len(all) != 1 || all[0].URL != "http://a/2" {
+		t.Errorf("after Clear: %+v", all)
+	}
+	if removed, err := db.Clear(time.Time{}); err != nil || removed != 1 {
+		t.Errorf("Clear all = %d, %v", removed, err)
+	}
+	if all, _ := db.All(); len(all) != 0 {
+		t.Errorf("after clearing all: %+v", all)
+	}
+}
+
+func TestClearKeepsConcurrentAdds(t *testing.T) {
+	db := Open(filepath.Join(t.TempDir(), "history.jsonl"))
+	old := time.Date(2000, 1, 1, 0, 0, 0, 0, time.UTC)
+	var wg sync.WaitGroup
+	const adds = 200
+	for i := 0; i < adds; i++ {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			finished := time.Now()
+			if i%2 == 0 {
+				finished = old
+			}
+			if err := db.Add(Entry{URL: fmt.Sprintf("http://a/%d", i), Finished: finished}); err != nil {
+				t.Error(err)
+			}
+		}(i)
+	}
+	for i := 0; i < 20; i++ {
+		if _, err := db.Clear(old.Add(time.Hour)); err != nil {
+			t.Fatal(err)
+		}
+	}
+	wg.Wait()
+	db.Clear(old.Add(time.Hour))
+	all, err := db.All()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(all) != adds/2 {
+		t.Errorf("%d recent entries kept, want %d", len(all), adds/2)
+	}
+}
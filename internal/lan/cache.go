@@ -0,0 +1,121 @@
+// Package lan shares finished downloads between warp-dl daemons on the same
+// network. Daemons find each other over multicast DNS and serve the files
+// they hold by content digest, so a household or lab fetching the same
+// large artifact pulls it from the internet only once.
+package lan
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"io"
+	"mime"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"time"
+)
+
+// pathPrefix is where peers serve files: /lan/v1/<algo>/<hex digest>
+const pathPrefix = "/lan/v1/"
+
+// Cache is the set of files a daemon offers to its peers
+type Cache struct {
+	mu    sync.Mutex
+	files map[string]entry // "algo:digest" -> file
+}
+
+type entry struct {
+	path    string
+	size    int64
+	modTime time.Time
+}
+
+func NewCache() *Cache {
+	return &Cache{files: map[string]entry{}}
+}
+
+// Add offers the file at path under a digest that is already known, e.g.
+// the checksum the download was verified against
+func (c *Cache) Add(algo, digest, path string) error {
+	fi, err := os.Stat(path)
+	if err != nil {
+		return err
+	}
+	if !fi.Mode().IsRegular() {
+		return fmt.Errorf("%s is not a regular file", path)
+	}
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.files[key(algo, digest)] = entry{path: path, size: fi.Size(), modTime: fi.ModTime()}
+	return nil
+}
+
+// AddFile hashes the file at path with SHA-256 and offers it under that
+func (c *Cache) AddFile(path string) error {
+	f, err := os.Open(path)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+	h := sha256.New()
+	if _, err := io.Copy(h, f); err != nil {
+		return err
+	}
+	return c.Add("sha256", hex.EncodeToString(h.Sum(nil)), path)
+}
+
+// Handler serves the offered files, with ranges so a peer can split the
+// transfer like any other download
+func (c *Cache) Handler() http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodGet && r.Method != http.MethodHead {
+			http.Error(w, "use GET or HEAD", http.StatusMethodNotAllowed)
+			return
+		}
+		algo, digest, ok := strings.Cut(strings.TrimPrefix(r.URL.Path, pathPrefix), "/")
+		if !ok || !strings.HasPrefix(r.URL.Path, pathPrefix) {
+			http.NotFound(w, r)
+			return
+		}
+		f, e, ok := c.open(algo, digest)
+		if !ok {
+			http.NotFound(w, r)
+			return
+		}
+		defer f.Close()
+
+		w.Header().Set("Content-Disposition", mime.FormatMediaType("attachment", map[string]string{"filename": filepath.Base(e.path)}))
+		http.ServeContent(w, r, filepath.Base(e.path), e.modTime, f)
+	})
+}
+
+// open returns the file for a digest, forgetting it if it was changed or
+// removed since it was offered
+func (c *Cache) open(algo, digest string) (*os.File, entry, bool) {
+	k := key(algo, digest)
+	c.mu.Lock()
+	e, ok := c.files[k]
+	c.mu.Unlock()
+	if !ok {
+		return nil, entry{}, false
+	}
+	f, err := os.Open(e.path)
+	if err == nil {
+		if fi, err := f.Stat(); err == nil && fi.Size() == e.size && fi.ModTime().Equal(e.modTime) {
+			return f, e, true
+		}
+		f.Close()
+	}
+	c.mu.Lock()
+	delete(c.files, k)
+	c.mu.Unlock()
+	return nil, entry{}, false
+}
+
+func key(algo, digest string) string {
+	algo = strings.ReplaceAll(strings.ToLower(algo), "-", "")
+	return algo + ":" + strings.ToLower(digest)
+}
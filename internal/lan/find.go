@@ -0,0 +1,57 @@
+package lan
+
+import (
+	"context"
+	"net/http"
+	"strings"
+	"sync"
+	"time"
+)
+
+const (
+	browseWait  = time.Second
+	peerTimeout = 2 * time.Second
+)
+
+// Find asks the daemons on the network for a file by digest and returns
+// download URLs of the peers that have it. Peers are on the local network,
+// so they are asked directly, never through a proxy.
+func Find(ctx context.Context, algo, digest string) []string {
+	addrs, err := browse(ctx, browseWait)
+	if err != nil || len(addrs) == 0 {
+		return nil
+	}
+
+	path := pathPrefix + strings.ToLower(algo) + "/" + strings.ToLower(digest)
+	client := &http.Client{
+		Timeout:   peerTimeout,
+		Transport: &http.Transport{Proxy: nil},
+	}
+	var (
+		mu    sync.Mutex
+		found []string
+		wg    sync.WaitGroup
+	)
+	for _, addr := range addrs {
+		wg.Add(1)
+		go func(u string) {
+			defer wg.Done()
+			req, err := http.NewRequestWithContext(ctx, http.MethodHead, u, nil)
+			if err != nil {
+				return
+			}
+			resp, err := client.Do(req)
+			if err != nil {
+				return
+			}
+			resp.Body.Close()
+			if resp.StatusCode == http.StatusOK {
+				mu.Lock()
+				found = append(found, u)
+				mu.Unlock()
+			}
+		}("http://" + addr + path)
+	}
+	wg.Wait()
+	return found
+}
@@ -0,0 +1,272 @@
+package lan
+
+import (
+	"context"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"net"
+	"os"
+	"strings"
+	"time"
+)
+
+// Daemons announce themselves as _warp-dl._tcp.local over multicast DNS.
+// Only the few records this needs are encoded: PTR, SRV, TXT and A.
+
+const (
+	serviceName = "_warp-dl._tcp.local."
+	mdnsPort    = 5353
+	mdnsTTL     = 120
+
+	typeA   = 1
+	typePTR = 12
+	typeTXT = 16
+	typeSRV = 33
+	typeANY = 255
+
+	classIN    = 1
+	cacheFlush = 0x8000 // High bit of the class, "unique record"
+	unicastQU  = 0x8000 // High bit of the question class, "answer me directly"
+)
+
+var mdnsGroup = net.IPv4(224, 0, 0, 251)
+
+// Advertise answers service queries on the local network with this host's
+// peer server port until ctx is canceled
+func Advertise(ctx context.Context, port int) error {
+	conn, err := net.ListenMulticastUDP("udp4", nil, &net.UDPAddr{IP: mdnsGroup, Port: mdnsPort})
+	if err != nil {
+		return err
+	}
+	go func() {
+		<-ctx.Done()
+		conn.Close()
+	}()
+
+	instance := instanceName(port)
+	buf := make([]byte, 9000)
+	for {
+		n, from, err := conn.ReadFromUDP(buf)
+		if err != nil {
+			if ctx.Err() != nil {
+				return nil
+			}
+			return err
+		}
+		if !asksForService(buf[:n]) {
+			continue
+		}
+		reply := announcement(instance, port, localIPFor(from))
+		to := from
+		if from.Port == mdnsPort {
+			// A full mDNS querier, answer the group
+			to = &net.UDPAddr{IP: mdnsGroup, Port: mdnsPort}
+		}
+		conn.WriteToUDP(reply, to)
+	}
+}
+
+// browse asks the network for daemons and returns their peer server
+// addresses as host:port, collecting answers for wait
+func browse(ctx context.Context, wait time.Duration) ([]string, error) {
+	conn, err := net.ListenUDP("udp4", nil)
+	if err != nil {
+		return nil, err
+	}
+	defer conn.Close()
+
+	if _, err := conn.WriteToUDP(query(), &net.UDPAddr{IP: mdnsGroup, Port: mdnsPort}); err != nil {
+		return nil, err
+	}
+	deadline := time.Now().Add(wait)
+	if d, ok := ctx.Deadline(); ok && d.Before(deadline) {
+		deadline = d
+	}
+	conn.SetReadDeadline(deadline)
+
+	var peers []string
+	seen := map[string]bool{}
+	buf := make([]byte, 9000)
+	for {
+		n, from, err := conn.ReadFromUDP(buf)
+		if err != nil {
+			break // Deadline
+		}
+		port, ip, ok := parseAnnouncement(buf[:n])
+		if !ok {
+			continue
+		}
+		if ip == nil {
+			ip = from.IP
+		}
+		addr := net.JoinHostPort(ip.String(), fmt.Sprint(port))
+		if !seen[addr] {
+			seen[addr] = true
+			peers = append(peers, addr)
+		}
+	}
+	return peers, nil
+}
+
+// instanceName identifies this daemon among the others on the network
+func instanceName(port int) string {
+	host, err := os.Hostname()
+	if err != nil || host == "" {
+		host = "warp-dl"
+	}
+	host, _, _ = strings.Cut(host, ".")
+	return fmt.Sprintf("%s-%d.%s", host, port, serviceName)
+}
+
+// localIPFor is the address of the interface that reaches peer
+func localIPFor(peer *net.UDPAddr) net.IP {
+	c, err := net.DialUDP("udp4", nil, peer)
+	if err != nil {
+		return nil
+	}
+	defer c.Close()
+	return c.LocalAddr().(*net.UDPAddr).IP.To4()
+}
+
+func query() []byte {
+	msg := make([]byte, 12)
+	binary.BigEndian.PutUint16(msg[4:], 1) // One question
+	msg = appendName(msg, serviceName)
+	return binary.BigEndian.AppendUint16(binary.BigEndian.AppendUint16(msg, typePTR), classIN|unicastQU)
+}
+
+func announcement(instance string, port int, ip net.IP) []byte {
+	target := strings.TrimSuffix(instance, serviceName) + "local."
+	msg := make([]byte, 12)
+	binary.BigEndian.PutUint16(msg[2:], 0x8400) // Response, authoritative
+
+	answers := 3
+	msg = appendRecord(msg, serviceName, typePTR, classIN, appendName(nil, instance))
+	srv := binary.BigEndian.AppendUint16(nil, 0) // Priority
+	srv = binary.BigEndian.AppendUint16(srv, 0)  // Weight
+	srv = binary.BigEndian.AppendUint16(srv, uint16(port))
+	msg = appendRecord(msg, instance, typeSRV, classIN|cacheFlush, appendName(srv, target))
+	msg = appendRecord(msg, instance, typeTXT, classIN|cacheFlush, []byte("\x09txtvers=1"))
+	if ip != nil {
+		answers++
+		msg = appendRecord(msg, target, typeA, classIN|cacheFlush, ip.To4())
+	}
+	binary.BigEndian.PutUint16(msg[6:], uint16(answers))
+	return msg
+}
+
+func appendRecord(msg []byte, name string, typ, class uint16, data []byte) []byte {
+	msg = appendName(msg, name)
+	msg = binary.BigEndian.AppendUint16(msg, typ)
+	msg = binary.BigEndian.AppendUint16(msg, class)
+	msg = binary.BigEndian.AppendUint32(msg, mdnsTTL)
+	msg = binary.BigEndian.AppendUint16(msg, uint16(len(data)))
+	return append(msg, data...)
+}
+
+// appendName encodes a dotted name as length-prefixed labels
+func appendName(msg []byte, name string) []byte {
+	for _, label := range strings.Split(strings.TrimSuffix(name, "."), ".") {
+		if len(label) > 63 {
+			label = label[:63]
+		}
+		msg = append(msg, byte(len(label)))
+		msg = append(msg, label...)
+	}
+	return append(msg, 0)
+}
+
+var errMalformed = errors.New("malformed DNS message")
+
+// readName decodes the name at off, following compression pointers, and
+// returns it with the offset just past it
+func readName(msg []byte, off int) (string, int, error) {
+	var labels []string
+	end := -1
+	for jumps := 0; ; {
+		if off >= len(msg) {
+			return "", 0, errMalformed
+		}
+		n := int(msg[off])
+		switch {
+		case n == 0:
+			if end < 0 {
+				end = off + 1
+			}
+			return strings.Join(labels, ".") + ".", end, nil
+		case n&0xc0 == 0xc0:
+			if off+1 >= len(msg) || jumps > 16 {
+				return "", 0, errMalformed
+			}
+			if end < 0 {
+				end = off + 2
+			}
+			off = int(binary.BigEndian.Uint16(msg[off:]) & 0x3fff)
+			jumps++
+		default:
+			if off+1+n > len(msg) {
+				return "", 0, errMalformed
+			}
+			labels = append(labels, string(msg[off+1:off+1+n]))
+			off += 1 + n
+		}
+	}
+}
+
+// asksForService reports whether msg is a query for our service
+func asksForService(msg []byte) bool {
+	if len(msg) < 12 || msg[2]&0x80 != 0 {
+		return false
+	}
+	off := 12
+	for i := 0; i < int(binary.BigEndian.Uint16(msg[4:])); i++ {
+		name, next, err := readName(msg, off)
+		if err != nil || next+4 > len(msg) {
+			return false
+		}
+		typ := binary.BigEndian.Uint16(msg[next:])
+		if strings.EqualFold(name, serviceName) && (typ == typePTR || typ == typeANY) {
+			return true
+		}
+		off = next + 4
+	}
+	return false
+}
+
+// parseAnnouncement extracts the SRV port and, if present, the A record
+// from a response about our service
+func parseAnnouncement(msg []byte) (port int, ip net.IP, ok bool) {
+	if len(msg) < 12 || msg[2]&0x80 == 0 {
+		return 0, nil, false
+	}
+	off := 12
+	for i := 0; i < int(binary.BigEndian.Uint16(msg[4:])); i++ {
+		_, next, err := readName(msg, off)
+		if err != nil {
+			return 0, nil, false
+		}
+		off = next + 4
+	}
+	records := int(binary.BigEndian.Uint16(msg[6:])) + int(binary.BigEndian.Uint16(msg[8:])) + int(binary.BigEndian.Uint16(msg[10:]))
+	for i := 0; i < records; i++ {
+		name, next, err := readName(msg, off)
+		if err != nil || next+10 > len(msg) {
+			break
+		}
+		typ := binary.BigEndian.Uint16(msg[next:])
+		length := int(binary.BigEndian.Uint16(msg[next+8:]))
+		data := next + 10
+		if data+length > len(msg) {
+			break
+		}
+		switch {
+		case typ == typeSRV && length >= 6 && strings.HasSuffix(strings.ToLower(name), serviceName):
+			port, ok = int(binary.BigEndian.Uint16(msg[data+4:])), true
+		case typ == typeA && length == 4:
+			ip = net.IP(append([]byte(nil), msg[data:data+4]...))
+		}
+		off = data + length
+	}
+	return port, ip, ok
+}
@@ -0,0 +1,127 @@
+package mirrors
+
+import (
+	"context"
+	"math/rand"
+	"net/http"
+	"net/url"
+	"path"
+	"strings"
+)
+
+const (
+	debianMasterlist = "https://salsa.debian.org/mirror-team/masterlist/-/raw/master/Mirrors.masterlist"
+	ubuntuMirrorList = "http://mirrors.ubuntu.com/mirrors.txt"
+	fedoraMirrorList = "https://mirrors.fedoraproject.org/mirrorlist"
+)
+
+// debian covers the package archive and the cdimage ISO tree, using the
+// mirror team's masterlist
+type debian struct{}
+
+func (debian) Mirrors(ctx context.Context, client *http.Client, u *url.URL) ([]string, bool, error) {
+	var field, rest string
+	switch host := strings.ToLower(u.Hostname()); {
+	case debianArchiveHost(host) && strings.HasPrefix(u.Path, "/debian/"):
+		field, rest = "Archive-http", strings.TrimPrefix(u.Path, "/debian/")
+	case host == "cdimage.debian.org" && strings.HasPrefix(u.Path, "/debian-cd/"):
+		field, rest = "CDImage-http", strings.TrimPrefix(u.Path, "/debian-cd/")
+	default:
+		return nil, false, nil
+	}
+
+	lines, err := fetchList(ctx, client, debianMasterlist)
+	if err != nil {
+		return nil, true, err
+	}
+	country := localCountry()
+	var near, far []string
+	site := map[string]string{}
+	flush := func() {
+		if site["Site"] != "" && site[field] != "" {
+			m := joinPrefix("http://"+site["Site"]+site[field], rest)
+			if country != "" && strings.HasPrefix(site["Country"], country+" ") {
+				near = append(near, m)
+			} else {
+				far = append(far, m)
+			}
+		}
+		site = map[string]string{}
+	}
+	// Stanzas of "Key: value" lines, separated by blank lines
+	for _, line := range lines {
+		if line == "" {
+			flush()
+			continue
+		}
+		if k, v, ok := strings.Cut(line, ":"); ok {
+			site[strings.TrimSpace(k)] = strings.TrimSpace(v)
+		}
+	}
+	flush()
+
+	// Hundreds of mirrors worldwide, try the local ones first and a random
+	// sample of the rest
+	shuffle(near)
+	shuffle(far)
+	return append(near, far...), true, nil
+}
+
+// debianArchiveHost is deb.debian.org or one of the ftp.<country>.debian.org
+// mirrors run by the project
+func debianArchiveHost(host string) bool {
+	return host == "deb.debian.org" || strings.HasPrefix(host, "ftp.") && strings.HasSuffix(host, ".debian.org")
+}
+
+// ubuntu covers the package archive. mirrors.ubuntu.com answers with the
+// mirrors of the country the request comes from.
+type ubuntu struct{}
+
+func (ubuntu) Mirrors(ctx context.Context, client *http.Client, u *url.URL) ([]string, bool, error) {
+	host := strings.ToLower(u.Hostname())
+	if (host != "archive.ubuntu.com" && !strings.HasSuffix(host, ".archive.ubuntu.com")) || !strings.HasPrefix(u.Path, "/ubuntu/") {
+		return nil, false, nil
+	}
+	lines, err := fetchList(ctx, client, ubuntuMirrorList)
+	if err != nil {
+		return nil, true, err
+	}
+	var urls []string
+	for _, line := range lines {
+		if strings.HasPrefix(line, "http://") || strings.HasPrefix(line, "https://") {
+			urls = append(urls, joinPrefix(line, strings.TrimPrefix(u.Path, "/ubuntu/")))
+		}
+	}
+	return urls, true, nil
+}
+
+// fedora covers everything under /pub on the Fedora download servers,
+// asking MirrorManager for the mirrors that carry the file's directory
+type fedora struct{}
+
+func (fedora) Mirrors(ctx context.Context, client *http.Client, u *url.URL) ([]string, bool, error) {
+	host := strings.ToLower(u.Hostname())
+	if (host != "download.fedoraproject.org" && host != "dl.fedoraproject.org") || !strings.HasPrefix(u.Path, "/pub/") {
+		return nil, false, nil
+	}
+	dir, file := path.Split(u.Path)
+	lines, err := fetchList(ctx, client, fedoraMirrorList+"?path="+url.QueryEscape(strings.TrimPrefix(dir, "/")))
+	if err != nil {
+		return nil, true, err
+	}
+	var urls []string
+	for _, line := range lines {
+		if strings.HasPrefix(line, "#") || !strings.Contains(line, "://") {
+			continue
+		}
+		if !strings.HasSuffix(line, "/"+file) {
+			line = joinPrefix(line, file)
+		}
+		urls = append(urls, line)
+	}
+	return urls, true, nil
+}
+
+func shuffle(s []string) {
+	rand.Shuffle(len(s), func(i, j int) { s[i], s[j] = s[j], s[i] })
+}
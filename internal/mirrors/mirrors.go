@@ -0,0 +1,117 @@
+// Package mirrors finds the official mirrors of well known download sites
+// and picks the fastest of them for a download. Providers know where a
+// project publishes its mirror list; fixed lists from the config file cover
+// any other site, such as a CPAN or PyPI mirror network.
+package mirrors
+
+import (
+	"bufio"
+	"context"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"os"
+	"sort"
+	"strings"
+)
+
+// maxListSize caps a downloaded mirror list
+const maxListSize = 4 << 20
+
+// Provider discovers the mirrors of one site
+type Provider interface {
+	// Mirrors returns URLs of the same file on other servers. ok is false
+	// when the URL isn't on this provider's site.
+	Mirrors(ctx context.Context, client *http.Client, u *url.URL) (urls []string, ok bool, err error)
+}
+
+// Builtin are the providers for Debian, Ubuntu and Fedora
+var Builtin = []Provider{debian{}, ubuntu{}, fedora{}}
+
+// Find asks the providers in order for mirrors of rawURL, the first one
+// that knows the site answers
+func Find(ctx context.Context, client *http.Client, providers []Provider, rawURL string) ([]string, error) {
+	u, err := url.Parse(rawURL)
+	if err != nil || (u.Scheme != "http" && u.Scheme != "https") {
+		return nil, nil
+	}
+	for _, p := range providers {
+		urls, ok, err := p.Mirrors(ctx, client, u)
+		if ok {
+			return urls, err
+		}
+	}
+	return nil, nil
+}
+
+// Static maps URL prefixes to the same tree on mirrors, e.g.
+// "https://www.cpan.org/" to a list of CPAN mirrors. The longest matching
+// prefix wins.
+type Static map[string][]string
+
+func (s Static) Mirrors(ctx context.Context, client *http.Client, u *url.URL) ([]string, bool, error) {
+	raw := u.String()
+	prefixes := make([]string, 0, len(s))
+	for prefix := range s {
+		prefixes = append(prefixes, prefix)
+	}
+	sort.Slice(prefixes, func(i, j int) bool { return len(prefixes[i]) > len(prefixes[j]) })
+	for _, prefix := range prefixes {
+		if !strings.HasPrefix(raw, prefix) {
+			continue
+		}
+		rest := strings.TrimPrefix(raw, prefix)
+		var urls []string
+		for _, m := range s[prefix] {
+			urls = append(urls, joinPrefix(m, rest))
+		}
+		return urls, true, nil
+	}
+	return nil, false, nil
+}
+
+// joinPrefix puts rest below a mirror prefix, with exactly one slash
+// between them
+func joinPrefix(prefix, rest string) string {
+	return strings.TrimSuffix(prefix, "/") + "/" + strings.TrimPrefix(rest, "/")
+}
+
+// fetchList downloads a mirror list and returns its lines
+func fetchList(ctx context.Context, client *http.Client, listURL string) ([]string, error) {
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, listURL, nil)
+	if err != nil {
+		return nil, err
+	}
+	resp, err := client.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("mirror list %s: %s", listURL, resp.Status)
+	}
+	var lines []string
+	sc := bufio.NewScanner(io.LimitReader(resp.Body, maxListSize))
+	for sc.Scan() {
+		lines = append(lines, strings.TrimSpace(sc.Text()))
+	}
+	return lines, sc.Err()
+}
+
+// localCountry guesses the user's country code from the locale, e.g. DE
+// for de_DE.UTF-8, to try nearby mirrors first
+func localCountry() string {
+	for _, env := range []string{"LC_ALL", "LC_MESSAGES", "LANG"} {
+		v := os.Getenv(env)
+		if v == "" {
+			continue
+		}
+		v, _, _ = strings.Cut(v, ".")
+		if _, region, ok := strings.Cut(v, "_"); ok && len(region) == 2 {
+			return strings.ToUpper(region)
+		}
+		return ""
+	}
+	return ""
+}
@@ -0,0 +1,119 @@
+package mirrors
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+const (
+	probeBytes   = 256 << 10 // Downloaded from each candidate to time it
+	probeTimeout = 4 * time.Second
+	maxProbes    = 24 // Candidates tried per download, in list order
+)
+
+// Probe is the measured speed of one candidate
+type Probe struct {
+	URL   string
+	Speed float64 // Bytes per second, including the time to connect
+	Err   error
+}
+
+// Fastest times the first bytes of the file on origin and the candidates
+// in parallel and returns the n fastest mirrors, fastest first. Mirrors
+// only count if they serve ranges and the same file size as the origin,
+// so a stale mirror with an older release is left out.
+func Fastest(ctx context.Context, client *http.Client, origin string, candidates []string, n int) []Probe {
+	if len(candidates) > maxProbes {
+		candidates = candidates[:maxProbes]
+	}
+	urls := append([]string{origin}, candidates...)
+	probes := make([]Probe, len(urls))
+	sizes := make([]int64, len(urls))
+
+	ctx, cancel := context.WithTimeout(ctx, probeTimeout)
+	defer cancel()
+	var wg sync.WaitGroup
+	for i, u := range urls {
+		wg.Add(1)
+		go func(i int, u string) {
+			defer wg.Done()
+			probes[i].URL = u
+			probes[i].Speed, sizes[i], probes[i].Err = probe(ctx, client, u)
+		}(i, u)
+	}
+	wg.Wait()
+
+	want := sizes[0]
+	if probes[0].Err != nil {
+		want = commonSize(sizes, probes)
+	}
+	var ok []Probe
+	for i, p := range probes[1:] {
+		if p.Err == nil && sizes[i+1] == want && want > 0 {
+			ok = append(ok, p)
+		}
+	}
+	sort.Slice(ok, func(i, j int) bool { return ok[i].Speed > ok[j].Speed })
+	if len(ok) > n {
+		ok = ok[:n]
+	}
+	return ok
+}
+
+// probe downloads the start of the file and returns the speed and the
+// file's total size from Content-Range
+func probe(ctx context.Context, client *http.Client, u string) (float64, int64, error) {
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, u, nil)
+	if err != nil {
+		return 0, 0, err
+	}
+	req.Header.Set("Range", fmt.Sprintf("bytes=0-%d", probeBytes-1))
+	start := time.Now()
+	resp, err := client.Do(req)
+	if err != nil {
+		return 0, 0, err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusPartialContent {
+		return 0, 0, fmt.Errorf("no range support: %s", resp.Status)
+	}
+	_, total, ok := strings.Cut(resp.Header.Get("Content-Range"), "/")
+	size, err := strconv.ParseInt(total, 10, 64)
+	if !ok || err != nil {
+		return 0, 0, fmt.Errorf("invalid Content-Range %q", resp.Header.Get("Content-Range"))
+	}
+	n, err := io.Copy(io.Discard, resp.Body)
+	if err != nil && n == 0 {
+		return 0, 0, err
+	}
+	// Cut off by the timeout still tells the speed so far
+	elapsed := time.Since(start).Seconds()
+	if elapsed <= 0 {
+		elapsed = 1e-3
+	}
+	return float64(n) / elapsed, size, nil
+}
+
+// commonSize is the size most reachable candidates agree on, for when the
+// origin itself couldn't be probed
+func commonSize(sizes []int64, probes []Probe) int64 {
+	votes := map[int64]int{}
+	var best int64
+	for i, s := range sizes {
+		if probes[i].Err != nil {
+			continue
+		}
+		votes[s]++
+		if votes[s] > votes[best] {
+			best = s
+		}
+	}
+	return best
+}
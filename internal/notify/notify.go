@@ -0,0 +1,26 @@
+// Package notify shows desktop notifications: through notify-send on Linux
+// and the BSDs, Notification Center on macOS and a toast on Windows.
+package notify
+
+import (
+	"fmt"
+	"os/exec"
+	"path/filepath"
+	"strings"
+)
+
+// Send shows a notification with a title and a line of text. It fails
+// without a desktop session or the tool that shows it, e.g. over SSH.
+func Send(title, body string) error {
+	return send(title, body)
+}
+
+// run runs the command that shows the notification, with what it printed
+// as the error when it fails
+func run(cmd *exec.Cmd) error {
+	out, err := cmd.CombinedOutput()
+	if msg := strings.TrimSpace(string(out)); err != nil && msg != "" {
+		return fmt.Errorf("%s: %s", filepath.Base(cmd.Path), msg)
+	}
+	return err
+}
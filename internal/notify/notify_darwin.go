@@ -0,0 +1,16 @@
+package notify
+
+import (
+	"os/exec"
+	"strings"
+)
+
+func send(title, body string) error {
+	script := "display notification " + appleString(body) + " with title " + appleString(title)
+	return run(exec.Command("osascript", "-e", script))
+}
+
+// appleString quotes s as an AppleScript string literal
+func appleString(s string) string {
+	return `"` + strings.NewReplacer(`\`, `\\`, `"`, `\"`).Replace(s) + `"`
+}
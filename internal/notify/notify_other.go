@@ -0,0 +1,16 @@
+//go:build !windows && !darwin
+
+package notify
+
+import (
+	"errors"
+	"os/exec"
+)
+
+func send(title, body string) error {
+	path, err := exec.LookPath("notify-send")
+	if err != nil {
+		return errors.New("needs notify-send (libnotify) installed")
+	}
+	return run(exec.Command(path, "--app-name=warp-dl", "--", title, body))
+}
@@ -0,0 +1,32 @@
+package notify
+
+import (
+	"os"
+	"os/exec"
+	"strings"
+	"syscall"
+)
+
+// toastScript shows a toast through the WinRT API from PowerShell. Toasts
+// need the ID of an installed app, PowerShell's own is always there. The
+// texts come in through the environment, so they need no quoting.
+const toastScript = `
+$ErrorActionPreference = 'Stop'
+[Windows.UI.Notifications.ToastNotificationManager, Windows.UI.Notifications, ContentType = WindowsRuntime] | Out-Null
+[Windows.Data.Xml.Dom.XmlDocument, Windows.Data.Xml.Dom.XmlDocument, ContentType = WindowsRuntime] | Out-Null
+$title = [Security.SecurityElement]::Escape($env:WARP_DL_NOTIFY_TITLE)
+$body = [Security.SecurityElement]::Escape($env:WARP_DL_NOTIFY_BODY)
+$xml = New-Object Windows.Data.Xml.Dom.XmlDocument
+$xml.LoadXml("<toast><visual><binding template=""ToastGeneric""><text>$title</text><text>$body</text></binding></visual></toast>")
+$app = '{1AC14E77-02E7-4E5D-B744-2EB1AE5198B7}\WindowsPowerShell\v1.0\powershell.exe'
+[Windows.UI.Notifications.ToastNotificationManager]::CreateToastNotifier($app).Show([Windows.UI.Notifications.ToastNotification]::new($xml))
+`
+
+func send(title, body string) error {
+	cmd := exec.Command("powershell.exe", "-NoProfile", "-NonInteractive", "-Command", "-")
+	cmd.Env = append(os.Environ(), "WARP_DL_NOTIFY_TITLE="+title, "WARP_DL_NOTIFY_BODY="+body)
+	cmd.Stdin = strings.NewReader(toastScript)
+	// No console window flashing up for it
+	cmd.SysProcAttr = &syscall.SysProcAttr{HideWindow: true}
+	return run(cmd)
+}
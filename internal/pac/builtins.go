@@ -0,0 +1,267 @@
+package pac
+
+import (
+	"context"
+	"encoding/binary"
+	"net"
+	"regexp"
+	"strings"
+	"time"
+)
+
+// The predefined functions of the Netscape PAC specification
+
+// dnsTimeout bounds every lookup a script makes, slow DNS must not stall
+// the proxy decision for long
+const dnsTimeout = 2 * time.Second
+
+func (s *Script) builtins() map[string]value {
+	return map[string]value{
+		"isPlainHostName": builtin(func(args []value) (value, error) {
+			return !strings.Contains(toString(arg(args, 0)), "."), nil
+		}),
+		"dnsDomainIs": builtin(func(args []value) (value, error) {
+			host, domain := strings.ToLower(toString(arg(args, 0))), strings.ToLower(toString(arg(args, 1)))
+			return strings.HasSuffix(host, domain), nil
+		}),
+		"localHostOrDomainIs": builtin(func(args []value) (value, error) {
+			host, hostdom := strings.ToLower(toString(arg(args, 0))), strings.ToLower(toString(arg(args, 1)))
+			if host == hostdom {
+				return true, nil
+			}
+			return !strings.Contains(host, ".") && strings.HasPrefix(hostdom, host+"."), nil
+		}),
+		"isResolvable": builtin(func(args []value) (value, error) {
+			return s.resolve(toString(arg(args, 0))) != nil, nil
+		}),
+		"dnsResolve": builtin(func(args []value) (value, error) {
+			if ip := s.resolve(toString(arg(args, 0))); ip != nil {
+				return ip.String(), nil
+			}
+			return nil, nil
+		}),
+		"isInNet": builtin(func(args []value) (value, error) {
+			ip := net.ParseIP(toString(arg(args, 0))).To4()
+			if ip == nil {
+				ip = s.resolve(toString(arg(args, 0)))
+			}
+			pattern := net.ParseIP(toString(arg(args, 1))).To4()
+			mask := net.ParseIP(toString(arg(args, 2))).To4()
+			if ip == nil || pattern == nil || mask == nil {
+				return false, nil
+			}
+			m := net.IPMask(mask)
+			return ip.Mask(m).Equal(pattern.Mask(m)), nil
+		}),
+		"myIpAddress": builtin(func(args []value) (value, error) {
+			return s.localIP().String(), nil
+		}),
+		"dnsDomainLevels": builtin(func(args []value) (value, error) {
+			return float64(strings.Count(toString(arg(args, 0)), ".")), nil
+		}),
+		"shExpMatch": builtin(func(args []value) (value, error) {
+			return shExpMatch(toString(arg(args, 0)), toString(arg(args, 1))), nil
+		}),
+		"convert_addr": builtin(func(args []value) (value, error) {
+			ip := net.ParseIP(toString(arg(args, 0))).To4()
+			if ip == nil {
+				return float64(0), nil
+			}
+			return float64(binary.BigEndian.Uint32(ip)), nil
+		}),
+		"weekdayRange": builtin(func(args []value) (value, error) {
+			return weekdayRange(time.Now(), args), nil
+		}),
+		"dateRange": builtin(func(args []value) (value, error) {
+			return dateRange(time.Now(), args), nil
+		}),
+		"timeRange": builtin(func(args []value) (value, error) {
+			return timeRange(time.Now(), args), nil
+		}),
+		"alert": builtin(func(args []value) (value, error) {
+			return undefined, nil
+		}),
+	}
+}
+
+// resolve returns the first IPv4 address of host, nil if it has none
+func (s *Script) resolve(host string) net.IP {
+	if ip := net.ParseIP(host); ip != nil {
+		return ip.To4()
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), dnsTimeout)
+	defer cancel()
+	ips, err := net.DefaultResolver.LookupIP(ctx, "ip4", host)
+	if err != nil || len(ips) == 0 {
+		return nil
+	}
+	return ips[0].To4()
+}
+
+// localIP is the address of the interface used for outbound traffic.
+// Dialing UDP sends nothing, it only selects the route.
+func (s *Script) localIP() net.IP {
+	if c, err := net.Dial("udp4", "198.51.100.1:53"); err == nil {
+		defer c.Close()
+		return c.LocalAddr().(*net.UDPAddr).IP
+	}
+	return net.IPv4(127, 0, 0, 1)
+}
+
+// shExpMatch matches a shell expression where * and ? also match dots and
+// slashes, unlike path.Match
+func shExpMatch(str, exp string) bool {
+	var re strings.Builder
+	re.WriteString("^")
+	for _, r := range exp {
+		switch r {
+		case '*':
+			re.WriteString(".*")
+		case '?':
+			re.WriteString(".")
+		default:
+			re.WriteString(regexp.QuoteMeta(string(r)))
+		}
+	}
+	re.WriteString("$")
+	ok, _ := regexp.MatchString(re.String(), str)
+	return ok
+}
+
+var (
+	weekdays = []string{"SUN", "MON", "TUE", "WED", "THU", "FRI", "SAT"}
+	months   = []string{"JAN", "FEB", "MAR", "APR", "MAY", "JUN", "JUL", "AUG", "SEP", "OCT", "NOV", "DEC"}
+)
+
+// gmtArgs strips a trailing "GMT" argument and returns the time to
+// compare against
+func gmtArgs(now time.Time, args []value) (time.Time, []value) {
+	if n := len(args); n > 0 && toString(args[n-1]) == "GMT" {
+		return now.UTC(), args[:n-1]
+	}
+	return now, args
+}
+
+func indexOf(list []string, s string) int {
+	for i, v := range list {
+		if v == strings.ToUpper(s) {
+			return i
+		}
+	}
+	return -1
+}
+
+func weekdayRange(now time.Time, args []value) bool {
+	now, args = gmtArgs(now, args)
+	if len(args) == 0 {
+		return false
+	}
+	today := int(now.Weekday())
+	from := indexOf(weekdays, toString(args[0]))
+	to := from
+	if len(args) > 1 {
+		to = indexOf(weekdays, toString(args[1]))
+	}
+	if from < 0 || to < 0 {
+		return false
+	}
+	return inRange(today, from, to)
+}
+
+// inRange is an inclusive range check that wraps, FRI..MON includes SUN
+func inRange(v, from, to int) bool {
+	if from <= to {
+		return from <= v && v <= to
+	}
+	return v >= from || v <= to
+}
+
+// dateRange takes days (1-31), month names and years (four digits) in the
+// forms the specification lists: one value, or a from/to pair of the same
+// shape such as dateRange(1, "JAN", 15, "MAR")
+func dateRange(now time.Time, args []value) bool {
+	now, args = gmtArgs(now, args)
+	type field struct{ kind, v int } // kind 0 day, 1 month, 2 year
+	var fields []field
+	for _, a := range args {
+		if m := indexOf(months, toString(a)); m >= 0 {
+			fields = append(fields, field{1, m})
+			continue
+		}
+		n := int(toNumber(a))
+		switch {
+		case n >= 1 && n <= 31:
+			fields = append(fields, field{0, n})
+		case n > 31:
+			fields = append(fields, field{2, n})
+		default:
+			return false
+		}
+	}
+	current := [3]int{now.Day(), int(now.Month()) - 1, now.Year()}
+
+	// key orders a date by year, month and day, using only the fields given
+	key := func(fs []field) (k [3]int, kinds [3]bool) {
+		for _, f := range fs {
+			k[2-f.kind], kinds[f.kind] = f.v, true
+		}
+		return k, kinds
+	}
+	if len(fields) == 1 {
+		return current[fields[0].kind] == fields[0].v
+	}
+	if len(fields)%2 != 0 {
+		return false
+	}
+	from, kinds := key(fields[:len(fields)/2])
+	to, toKinds := key(fields[len(fields)/2:])
+	if kinds != toKinds {
+		return false
+	}
+	var cur [3]int
+	for kind, used := range kinds {
+		if used {
+			cur[2-kind] = current[kind]
+		}
+	}
+	less := func(a, b [3]int) bool {
+		for i := range a {
+			if a[i] != b[i] {
+				return a[i] < b[i]
+			}
+		}
+		return false
+	}
+	if !less(to, from) {
+		return !less(cur, from) && !less(to, cur)
+	}
+	// Wraps around the end of the year, or the month
+	return !less(cur, from) || !less(to, cur)
+}
+
+// timeRange takes hours, hour/minute pairs or hour/minute/second triples
+func timeRange(now time.Time, args []value) bool {
+	now, args = gmtArgs(now, args)
+	n := make([]int, len(args))
+	for i, a := range args {
+		n[i] = int(toNumber(a))
+	}
+	sec := now.Hour()*3600 + now.Minute()*60 + now.Second()
+	var from, to int
+	switch len(n) {
+	case 1:
+		return now.Hour() == n[0]
+	case 2:
+		if n[0] == n[1] {
+			return now.Hour() == n[0]
+		}
+		from, to = n[0]*3600, n[1]*3600-1
+	case 4:
+		from, to = n[0]*3600+n[1]*60, n[2]*3600+n[3]*60+59
+	case 6:
+		from, to = n[0]*3600+n[1]*60+n[2], n[3]*3600+n[4]*60+n[5]
+	default:
+		return false
+	}
+	return inRange(sec, from, to)
+}
@@ -0,0 +1,117 @@
+package pac
+
+import (
+	"testing"
+	"time"
+)
+
+func TestShExpMatch(t *testing.T) {
+	tests := []struct {
+		str, exp string
+		want     bool
+	}{
+		{"http://home.netscape.com/people/ari/index.html", "*/ari/*", true},
+		{"http://home.netscape.com/people/montulli/index.html", "*/ari/*", false},
+		{"www.example.com", "*.example.com", true},
+		{"example.com", "*.example.com", false},
+		{"a.b.c", "a?b?c", true},
+		{"abc", "a?c", true},
+		{"ac", "a?c", false},
+		{"10.1.2.3", "10.*", true},
+		{"10x1.2.3", "10.*", false},
+		{"a+b(c)", "a+b(c)", true},
+		{"[x]", "[x]", true},
+		{"x", "[x]", false},
+		{"", "*", true},
+	}
+	for _, tt := range tests {
+		if got := shExpMatch(tt.str, tt.exp); got != tt.want {
+			t.Errorf("shExpMatch(%q, %q) = %v", tt.str, tt.exp, got)
+		}
+	}
+}
+
+func TestWeekdayRange(t *testing.T) {
+	// A Wednesday, and still Tuesday in New York
+	now := time.Date(2024, time.March, 13, 2, 30, 0, 0, time.UTC)
+	ny := now.In(time.FixedZone("EDT", -4*3600))
+	tests := []struct {
+		now  time.Time
+		args []value
+		want bool
+	}{
+		{now, []value{"WED"}, true},
+		{now, []value{"MON", "FRI"}, true},
+		{now, []value{"THU", "SAT"}, false},
+		{now, []value{"FRI", "WED"}, true}, // Wraps over the weekend
+		{now, []value{"FRI", "TUE"}, false},
+		{ny, []value{"TUE"}, true},
+		{ny, []value{"WED", "GMT"}, true},
+		{now, []value{"wed"}, true},
+		{now, []value{"XYZ"}, false},
+		{now, []value{}, false},
+	}
+	for _, tt := range tests {
+		if got := weekdayRange(tt.now, tt.args); got != tt.want {
+			t.Errorf("weekdayRange(%s, %v) = %v", tt.now.Weekday(), tt.args, got)
+		}
+	}
+}
+
+func TestDateRange(t *testing.T) {
+	now := time.Date(2024, time.March, 13, 12, 0, 0, 0, time.UTC)
+	tests := []struct {
+		args []value
+		want bool
+	}{
+		{[]value{float64(13)}, true},
+		{[]value{float64(14)}, false},
+		{[]value{"MAR"}, true},
+		{[]value{float64(2024)}, true},
+		{[]value{float64(1), float64(15)}, true},
+		{[]value{float64(20), float64(5)}, false},
+		{[]value{float64(20), float64(15)}, true}, // Wraps over the month end
+		{[]value{"JAN", "MAR"}, true},
+		{[]value{"APR", "DEC"}, false},
+		{[]value{"NOV", "MAR"}, true},
+		{[]value{float64(2020), float64(2023)}, false},
+		{[]value{float64(1), "MAR", float64(13), "MAR"}, true},
+		{[]value{float64(14), "MAR", float64(1), "APR"}, false},
+		{[]value{float64(1), "DEC", float64(2023), float64(31), "MAR", float64(2024)}, true},
+		{[]value{"MAR", float64(2023), "FEB", float64(2024)}, false},
+		{[]value{float64(13), "MAR"}, false}, // Mixed shapes
+		{[]value{float64(1), float64(2), float64(3)}, false},
+		{[]value{float64(0)}, false},
+	}
+	for _, tt := range tests {
+		if got := dateRange(now, tt.args); got != tt.want {
+			t.Errorf("dateRange(%v) = %v", tt.args, got)
+		}
+	}
+}
+
+func TestTimeRange(t *testing.T) {
+	now := time.Date(2024, time.March, 13, 17, 45, 30, 0, time.UTC)
+	tests := []struct {
+		args []value
+		want bool
+	}{
+		{[]value{float64(17)}, true},
+		{[]value{float64(18)}, false},
+		{[]value{float64(9), float64(18)}, true},
+		{[]value{float64(9), float64(17)}, false}, // Up to 16:59:59
+		{[]value{float64(17), float64(17)}, true},
+		{[]value{float64(22), float64(6)}, false},
+		{[]value{float64(17), float64(0), float64(17), float64(45)}, true},
+		{[]value{float64(17), float64(46), float64(18), float64(0)}, false},
+		{[]value{float64(17), float64(45), float64(31), float64(17), float64(50), float64(0)}, false},
+		{[]value{float64(17), float64(45), float64(0), float64(17), float64(45), float64(30)}, true},
+		{[]value{float64(17), "GMT"}, true},
+		{[]value{float64(1), float64(2), float64(3)}, false},
+	}
+	for _, tt := range tests {
+		if got := timeRange(now, tt.args); got != tt.want {
+			t.Errorf("timeRange(%v) = %v", tt.args, got)
+		}
+	}
+}
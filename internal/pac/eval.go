@@ -0,0 +1,671 @@
+package pac
+
+import (
+	"errors"
+	"fmt"
+	"math"
+	"strconv"
+	"strings"
+)
+
+// Values are float64, string, bool, nil (null), undefined, *array,
+// *closure and builtin
+
+type value interface{}
+
+type undefinedType struct{}
+
+var undefined value = undefinedType{}
+
+type array struct{ elems []value }
+
+type closure struct {
+	params []string
+	body   []stmt
+	env    *scope
+}
+
+type builtin func(args []value) (value, error)
+
+// maxSteps bounds the work of one call, a proxy lookup must not hang a
+// download on a script stuck in a loop
+const maxSteps = 1_000_000
+
+var errTooManySteps = errors.New("script did not finish, too many steps")
+
+// maxCallDepth bounds recursion in the script, each call takes Go stack
+const maxCallDepth = 200
+
+var errTooDeep = errors.New("script recursed too deeply")
+
+// maxString bounds the strings a script builds, s += s in a loop would
+// otherwise take all memory well within maxSteps
+const maxString = 1 << 20
+
+var errStringTooLong = errors.New("script built too long a string")
+
+type scope struct {
+	vars   map[string]value
+	parent *scope
+}
+
+func newScope(parent *scope) *scope {
+	return &scope{vars: map[string]value{}, parent: parent}
+}
+
+func (s *scope) lookup(name string) (*scope, bool) {
+	for ; s != nil; s = s.parent {
+		if _, ok := s.vars[name]; ok {
+			return s, true
+		}
+	}
+	return nil, false
+}
+
+type control int
+
+const (
+	ctrlNone control = iota
+	ctrlReturn
+	ctrlBreak
+	ctrlContinue
+)
+
+type interp struct {
+	steps int
+	depth int // Calls of closures in progress
+}
+
+func (in *interp) step() error {
+	if in.steps++; in.steps > maxSteps {
+		return errTooManySteps
+	}
+	return nil
+}
+
+// hoist declares the functions and variables of a body before it runs
+func hoist(body []stmt, env *scope) {
+	for _, s := range body {
+		hoistStmt(s, env)
+	}
+}
+
+func hoistStmt(s stmt, env *scope) {
+	switch s := s.(type) {
+	case *funcDecl:
+		env.vars[s.name] = &closure{params: s.params, body: s.body, env: env}
+	case *varDecl:
+		for _, name := range s.names {
+			if _, ok := env.vars[name]; !ok {
+				env.vars[name] = undefined
+			}
+		}
+	case *blockStmt:
+		hoist(s.body, env)
+	case *ifStmt:
+		hoistStmt(s.then, env)
+		if s.els != nil {
+			hoistStmt(s.els, env)
+		}
+	case *forStmt:
+		if s.init != nil {
+			hoistStmt(s.init, env)
+		}
+		hoistStmt(s.body, env)
+	case *whileStmt:
+		hoistStmt(s.body, env)
+	}
+}
+
+func (in *interp) execBody(body []stmt, env *scope) (control, value, error) {
+	for _, s := range body {
+		c, v, err := in.exec(s, env)
+		if err != nil || c != ctrlNone {
+			return c, v, err
+		}
+	}
+	return ctrlNone, nil, nil
+}
+
+func (in *interp) exec(s stmt, env *scope) (control, value, error) {
+	if err := in.step(); err != nil {
+		return ctrlNone, nil, err
+	}
+	switch s := s.(type) {
+	case *funcDecl:
+		// Hoisted
+	case *varDecl:
+		for i, name := range s.names {
+			if s.inits[i] == nil {
+				continue
+			}
+			v, err := in.eval(s.inits[i], env)
+			if err != nil {
+				return ctrlNone, nil, err
+			}
+			in.set(env, name, v)
+		}
+	case *exprStmt:
+		if _, err := in.eval(s.x, env); err != nil {
+			return ctrlNone, nil, err
+		}
+	case *blockStmt:
+		return in.execBody(s.body, env)
+	case *ifStmt:
+		cond, err := in.eval(s.cond, env)
+		if err != nil {
+			return ctrlNone, nil, err
+		}
+		if truthy(cond) {
+			return in.exec(s.then, env)
+		} else if s.els != nil {
+			return in.exec(s.els, env)
+		}
+	case *returnStmt:
+		if s.value == nil {
+			return ctrlReturn, undefined, nil
+		}
+		v, err := in.eval(s.value, env)
+		return ctrlReturn, v, err
+	case *breakStmt:
+		return ctrlBreak, nil, nil
+	case *continueStmt:
+		return ctrlContinue, nil, nil
+	case *whileStmt:
+		return in.loop(s.cond, nil, s.body, env)
+	case *forStmt:
+		if s.init != nil {
+			if _, _, err := in.exec(s.init, env); err != nil {
+				return ctrlNone, nil, err
+			}
+		}
+		return in.loop(s.cond, s.post, s.body, env)
+	default:
+		return ctrlNone, nil, fmt.Errorf("unexpected statement %T", s)
+	}
+	return ctrlNone, nil, nil
+}
+
+func (in *interp) loop(cond, post expr, body stmt, env *scope) (control, value, error) {
+	for {
+		if cond != nil {
+			v, err := in.eval(cond, env)
+			if err != nil {
+				return ctrlNone, nil, err
+			}
+			if !truthy(v) {
+				return ctrlNone, nil, nil
+			}
+		}
+		c, v, err := in.exec(body, env)
+		if err != nil || c == ctrlReturn {
+			return c, v, err
+		}
+		if c == ctrlBreak {
+			return ctrlNone, nil, nil
+		}
+		if post != nil {
+			if _, err := in.eval(post, env); err != nil {
+				return ctrlNone, nil, err
+			}
+		}
+	}
+}
+
+// set assigns to the nearest declaration of name, or creates a global
+func (in *interp) set(env *scope, name string, v value) {
+	if s, ok := env.lookup(name); ok {
+		s.vars[name] = v
+		return
+	}
+	for env.parent != nil {
+		env = env.parent
+	}
+	env.vars[name] = v
+}
+
+func (in *interp) eval(x expr, env *scope) (value, error) {
+	if err := in.step(); err != nil {
+		return nil, err
+	}
+	switch x := x.(type) {
+	case *literal:
+		return x.v, nil
+	case *ident:
+		if x.name == "undefined" {
+			return undefined, nil
+		}
+		s, ok := env.lookup(x.name)
+		if !ok {
+			return nil, fmt.Errorf("%s is not defined", x.name)
+		}
+		return s.vars[x.name], nil
+	case *arrayLit:
+		a := &array{}
+		for _, e := range x.elems {
+			v, err := in.eval(e, env)
+			if err != nil {
+				return nil, err
+			}
+			a.elems = append(a.elems, v)
+		}
+		return a, nil
+	case *funcLit:
+		return &closure{params: x.params, body: x.body, env: env}, nil
+	case *unaryExpr:
+		if x.op == "typeof" {
+			if id, ok := x.x.(*ident); ok {
+				if _, found := env.lookup(id.name); !found {
+					return "undefined", nil
+				}
+			}
+		}
+		v, err := in.eval(x.x, env)
+		if err != nil {
+			return nil, err
+		}
+		switch x.op {
+		case "!":
+			return !truthy(v), nil
+		case "-":
+			return -toNumber(v), nil
+		case "+":
+			return toNumber(v), nil
+		}
+		return typeOf(v), nil
+	case *binaryExpr:
+		return in.binary(x, env)
+	case *conditional:
+		c, err := in.eval(x.cond, env)
+		if err != nil {
+			return nil, err
+		}
+		if truthy(c) {
+			return in.eval(x.a, env)
+		}
+		return in.eval(x.b, env)
+	case *assign:
+		v, err := in.eval(x.value, env)
+		if err != nil {
+			return nil, err
+		}
+		if x.op != "=" {
+			old, err := in.eval(x.target, env)
+			if err != nil {
+				return nil, err
+			}
+			op := strings.TrimSuffix(x.op, "=")
+			if v, err = arith(op, old, v); err != nil {
+				return nil, err
+			}
+		}
+		return v, in.store(x.target, v, env)
+	case *update:
+		old, err := in.eval(x.target, env)
+		if err != nil {
+			return nil, err
+		}
+		n := toNumber(old)
+		updated := n + 1
+		if x.op == "--" {
+			updated = n - 1
+		}
+		if err := in.store(x.target, updated, env); err != nil {
+			return nil, err
+		}
+		if x.prefix {
+			return updated, nil
+		}
+		return n, nil
+	case *member:
+		obj, err := in.eval(x.obj, env)
+		if err != nil {
+			return nil, err
+		}
+		prop, err := in.eval(x.prop, env)
+		if err != nil {
+			return nil, err
+		}
+		return getMember(obj, prop)
+	case *call:
+		fn, err := in.eval(x.fn, env)
+		if err != nil {
+			return nil, err
+		}
+		args := make([]value, len(x.args))
+		for i, a := range x.args {
+			if args[i], err = in.eval(a, env); err != nil {
+				return nil, err
+			}
+		}
+		return in.call(fn, args)
+	}
+	return nil, fmt.Errorf("unexpected expression %T", x)
+}
+
+func (in *interp) call(fn value, args []value) (value, error) {
+	switch fn := fn.(type) {
+	case builtin:
+		return fn(args)
+	case *closure:
+		if in.depth >= maxCallDepth {
+			return nil, errTooDeep
+		}
+		in.depth++
+		defer func() { in.depth-- }()
+		env := newScope(fn.env)
+		for i, name := range fn.params {
+			env.vars[name] = undefined
+			if i < len(args) {
+				env.vars[name] = args[i]
+			}
+		}
+		hoist(fn.body, env)
+		c, v, err := in.execBody(fn.body, env)
+		if err != nil {
+			return nil, err
+		}
+		if c != ctrlReturn {
+			return undefined, nil
+		}
+		return v, nil
+	}
+	return nil, fmt.Errorf("%s is not a function", toString(fn))
+}
+
+// store writes v to an identifier or array element
+func (in *interp) store(target expr, v value, env *scope) error {
+	switch t := target.(type) {
+	case *ident:
+		in.set(env, t.name, v)
+		return nil
+	case *member:
+		obj, err := in.eval(t.obj, env)
+		if err != nil {
+			return err
+		}
+		prop, err := in.eval(t.prop, env)
+		if err != nil {
+			return err
+		}
+		a, ok := obj.(*array)
+		i, isIndex := arrayIndex(prop)
+		if !ok || !isIndex {
+			return fmt.Errorf("cannot set property %s of %s", toString(prop), toString(obj))
+		}
+		for len(a.elems) <= i {
+			a.elems = append(a.elems, undefined)
+		}
+		a.elems[i] = v
+		return nil
+	}
+	return fmt.Errorf("invalid assignment target")
+}
+
+func (in *interp) binary(x *binaryExpr, env *scope) (value, error) {
+	l, err := in.eval(x.l, env)
+	if err != nil {
+		return nil, err
+	}
+	switch x.op {
+	case "&&":
+		if !truthy(l) {
+			return l, nil
+		}
+		return in.eval(x.r, env)
+	case "||":
+		if truthy(l) {
+			return l, nil
+		}
+		return in.eval(x.r, env)
+	}
+	r, err := in.eval(x.r, env)
+	if err != nil {
+		return nil, err
+	}
+	switch x.op {
+	case ",":
+		return r, nil
+	case "===":
+		return strictEqual(l, r), nil
+	case "!==":
+		return !strictEqual(l, r), nil
+	case "==":
+		return looseEqual(l, r), nil
+	case "!=":
+		return !looseEqual(l, r), nil
+	case "<", ">", "<=", ">=":
+		return compare(x.op, l, r), nil
+	}
+	return arith(x.op, l, r)
+}
+
+func arith(op string, l, r value) (value, error) {
+	if op == "+" {
+		_, ls := l.(string)
+		_, rs := r.(string)
+		_, la := l.(*array)
+		_, ra := r.(*array)
+		if ls || rs || la || ra {
+			ls, rs := toString(l), toString(r)
+			if len(ls)+len(rs) > maxString {
+				return nil, errStringTooLong
+			}
+			return ls + rs, nil
+		}
+	}
+	a, b := toNumber(l), toNumber(r)
+	switch op {
+	case "+":
+		return a + b, nil
+	case "-":
+		return a - b, nil
+	case "*":
+		return a * b, nil
+	case "/":
+		return a / b, nil
+	case "%":
+		return math.Mod(a, b), nil
+	}
+	return nil, fmt.Errorf("unknown operator %s", op)
+}
+
+func compare(op string, l, r value) bool {
+	if ls, ok := l.(string); ok {
+		if rs, ok := r.(string); ok {
+			switch op {
+			case "<":
+				return ls < rs
+			case ">":
+				return ls > rs
+			case "<=":
+				return ls <= rs
+			}
+			return ls >= rs
+		}
+	}
+	a, b := toNumber(l), toNumber(r)
+	switch op {
+	case "<":
+		return a < b
+	case ">":
+		return a > b
+	case "<=":
+		return a <= b
+	}
+	return a >= b
+}
+
+func strictEqual(l, r value) bool {
+	switch l := l.(type) {
+	case *array:
+		ra, ok := r.(*array)
+		return ok && l == ra
+	case *closure:
+		rc, ok := r.(*closure)
+		return ok && l == rc
+	case builtin:
+		return false
+	}
+	if _, ok := r.(builtin); ok {
+		return false
+	}
+	if _, ok := r.(*array); ok {
+		return false
+	}
+	if _, ok := r.(*closure); ok {
+		return false
+	}
+	return l == r
+}
+
+func looseEqual(l, r value) bool {
+	nullish := func(v value) bool { return v == nil || v == undefined }
+	switch {
+	case nullish(l) || nullish(r):
+		return nullish(l) && nullish(r)
+	case strictEqual(l, r):
+		return true
+	}
+	switch l.(type) {
+	case *array, *closure, builtin:
+		if _, ok := r.(string); ok {
+			return toString(l) == r
+		}
+		return false
+	}
+	switch r.(type) {
+	case *array, *closure, builtin:
+		if _, ok := l.(string); ok {
+			return toString(r) == l
+		}
+		return false
+	}
+	return toNumber(l) == toNumber(r)
+}
+
+func truthy(v value) bool {
+	switch v := v.(type) {
+	case bool:
+		return v
+	case float64:
+		return v != 0 && !math.IsNaN(v)
+	case string:
+		return v != ""
+	case nil, undefinedType:
+		return false
+	}
+	return true
+}
+
+func toNumber(v value) float64 {
+	switch v := v.(type) {
+	case float64:
+		return v
+	case bool:
+		if v {
+			return 1
+		}
+		return 0
+	case nil:
+		return 0
+	case string:
+		s := strings.TrimSpace(v)
+		if s == "" {
+			return 0
+		}
+		if strings.HasPrefix(s, "0x") || strings.HasPrefix(s, "0X") {
+			if n, err := strconv.ParseUint(s[2:], 16, 64); err == nil {
+				return float64(n)
+			}
+			return math.NaN()
+		}
+		if n, err := strconv.ParseFloat(s, 64); err == nil {
+			return n
+		}
+	case *array:
+		return toNumber(toString(v))
+	}
+	return math.NaN()
+}
+
+func toString(v value) string {
+	switch v := v.(type) {
+	case string:
+		return v
+	case float64:
+		switch {
+		case math.IsNaN(v):
+			return "NaN"
+		case math.IsInf(v, 1):
+			return "Infinity"
+		case math.IsInf(v, -1):
+			return "-Infinity"
+		}
+		return strconv.FormatFloat(v, 'f', -1, 64)
+	case bool:
+		return strconv.FormatBool(v)
+	case nil:
+		return "null"
+	case undefinedType:
+		return "undefined"
+	case *array:
+		return v.join(",", nil)
+	}
+	return "function"
+}
+
+// join is Array.prototype.join. Arrays containing themselves join to ""
+// there, as in browsers, and output stops a little past maxString so
+// callers can reject it without building all of it.
+func (a *array) join(sep string, seen map[*array]bool) string {
+	if seen[a] {
+		return ""
+	}
+	if seen == nil {
+		seen = map[*array]bool{}
+	}
+	seen[a] = true
+	defer delete(seen, a)
+	var b strings.Builder
+	for i, e := range a.elems {
+		if b.Len() > maxString {
+			break
+		}
+		if i > 0 {
+			b.WriteString(sep)
+		}
+		switch e := e.(type) {
+		case *array:
+			b.WriteString(e.join(",", seen))
+		case nil, undefinedType:
+		default:
+			b.WriteString(toString(e))
+		}
+	}
+	return b.String()
+}
+
+func typeOf(v value) string {
+	switch v.(type) {
+	case string:
+		return "string"
+	case float64:
+		return "number"
+	case bool:
+		return "boolean"
+	case undefinedType:
+		return "undefined"
+	case *closure, builtin:
+		return "function"
+	}
+	return "object"
+}
+
+func arrayIndex(v value) (int, bool) {
+	n := toNumber(v)
+	if n < 0 || n != math.Trunc(n) || n > math.MaxInt32 {
+		return 0, false
+	}
+	return int(n), true
+}
@@ -0,0 +1,204 @@
+package pac
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+	"unicode/utf8"
+)
+
+type tokenKind int
+
+const (
+	tokEOF tokenKind = iota
+	tokNumber
+	tokString
+	tokIdent
+	tokPunct
+)
+
+type token struct {
+	kind tokenKind
+	text string  // Identifier, punctuator or decoded string literal
+	num  float64 // Value of a number literal
+	pos  int     // Byte offset in the script
+	nl   bool    // Preceded by a line break, for automatic semicolons
+}
+
+// Longest first, so "===" isn't read as "==" followed by "="
+var punctuators = []string{
+	"===", "!==",
+	"==", "!=", "<=", ">=", "&&", "||", "++", "--", "+=", "-=",
+	"{", "}", "(", ")", "[", "]", ";", ",", ".", "?", ":", "=", "<", ">", "+", "-", "*", "/", "%", "!",
+}
+
+// tokenize splits a script into tokens, ending with a tokEOF
+func tokenize(src string) ([]token, error) {
+	var toks []token
+	nl := false
+	for i := 0; i < len(src); {
+		c := src[i]
+		switch {
+		case c == '\n' || c == '\r':
+			nl = true
+			i++
+			continue
+		case c == ' ' || c == '\t' || c == '\f' || c == '\v':
+			i++
+			continue
+		case strings.HasPrefix(src[i:], "//"):
+			for i < len(src) && src[i] != '\n' {
+				i++
+			}
+			continue
+		case strings.HasPrefix(src[i:], "/*"):
+			end := strings.Index(src[i+2:], "*/")
+			if end < 0 {
+				return nil, syntaxError(src, i, "unterminated comment")
+			}
+			if strings.ContainsAny(src[i:i+2+end], "\r\n") {
+				nl = true
+			}
+			i += 2 + end + 2
+			continue
+		}
+
+		tok := token{pos: i, nl: nl}
+		nl = false
+		switch {
+		case isDigit(c) || c == '.' && i+1 < len(src) && isDigit(src[i+1]):
+			j := i
+			if strings.HasPrefix(src[i:], "0x") || strings.HasPrefix(src[i:], "0X") {
+				j += 2
+				for j < len(src) && strings.IndexByte("0123456789abcdefABCDEF", src[j]) >= 0 {
+					j++
+				}
+				n, err := strconv.ParseUint(src[i+2:j], 16, 64)
+				if err != nil {
+					return nil, syntaxError(src, i, "invalid number")
+				}
+				tok.num = float64(n)
+			} else {
+				for j < len(src) && (isDigit(src[j]) || src[j] == '.') {
+					j++
+				}
+				if j < len(src) && (src[j] == 'e' || src[j] == 'E') {
+					j++
+					if j < len(src) && (src[j] == '+' || src[j] == '-') {
+						j++
+					}
+					for j < len(src) && isDigit(src[j]) {
+						j++
+					}
+				}
+				n, err := strconv.ParseFloat(src[i:j], 64)
+				if err != nil {
+					return nil, syntaxError(src, i, "invalid number")
+				}
+				tok.num = n
+			}
+			tok.kind, tok.text = tokNumber, src[i:j]
+			i = j
+		case c == '"' || c == '\'':
+			s, n, err := readString(src[i:])
+			if err != nil {
+				return nil, syntaxError(src, i, err.Error())
+			}
+			tok.kind, tok.text = tokString, s
+			i += n
+		case isIdentStart(c):
+			j := i
+			for j < len(src) && (isIdentStart(src[j]) || isDigit(src[j])) {
+				j++
+			}
+			tok.kind, tok.text = tokIdent, src[i:j]
+			i = j
+		default:
+			for _, p := range punctuators {
+				if strings.HasPrefix(src[i:], p) {
+					tok.kind, tok.text = tokPunct, p
+					break
+				}
+			}
+			if tok.kind != tokPunct {
+				r, _ := utf8.DecodeRuneInString(src[i:])
+				return nil, syntaxError(src, i, fmt.Sprintf("unexpected character %q", r))
+			}
+			i += len(tok.text)
+		}
+		toks = append(toks, tok)
+	}
+	return append(toks, token{kind: tokEOF, pos: len(src), nl: true}), nil
+}
+
+// readString decodes the quoted literal at the start of s and returns it
+// with the number of bytes consumed
+func readString(s string) (string, int, error) {
+	quote := s[0]
+	var b strings.Builder
+	for i := 1; i < len(s); i++ {
+		c := s[i]
+		switch {
+		case c == quote:
+			return b.String(), i + 1, nil
+		case c == '\n':
+			return "", 0, fmt.Errorf("unterminated string")
+		case c != '\\':
+			b.WriteByte(c)
+			continue
+		}
+		if i++; i == len(s) {
+			break
+		}
+		switch c := s[i]; c {
+		case 'n':
+			b.WriteByte('\n')
+		case 't':
+			b.WriteByte('\t')
+		case 'r':
+			b.WriteByte('\r')
+		case 'b':
+			b.WriteByte('\b')
+		case 'f':
+			b.WriteByte('\f')
+		case 'v':
+			b.WriteByte('\v')
+		case '0':
+			b.WriteByte(0)
+		case 'x', 'u':
+			digits := 2
+			if c == 'u' {
+				digits = 4
+			}
+			if i+digits >= len(s) {
+				return "", 0, fmt.Errorf("invalid escape")
+			}
+			n, err := strconv.ParseUint(s[i+1:i+1+digits], 16, 32)
+			if err != nil {
+				return "", 0, fmt.Errorf("invalid escape")
+			}
+			b.WriteRune(rune(n))
+			i += digits
+		case '\n':
+			// Line continuation
+		default:
+			b.WriteByte(c)
+		}
+	}
+	return "", 0, fmt.Errorf("unterminated string")
+}
+
+func isDigit(c byte) bool {
+	return c >= '0' && c <= '9'
+}
+
+func isIdentStart(c byte) bool {
+	return c >= 'a' && c <= 'z' || c >= 'A' && c <= 'Z' || c == '_' || c == '$'
+}
+
+// syntaxError reports a position as line:column
+func syntaxError(src string, pos int, msg string) error {
+	line := 1 + strings.Count(src[:pos], "\n")
+	col := pos - strings.LastIndex(src[:pos], "\n")
+	return fmt.Errorf("line %d:%d: %s", line, col, msg)
+}
@@ -0,0 +1,204 @@
+package pac
+
+import (
+	"fmt"
+	"math"
+	"strings"
+)
+
+// getMember resolves obj[prop] for the string and array properties PAC
+// scripts use. Methods come back bound to their receiver.
+func getMember(obj, prop value) (value, error) {
+	switch o := obj.(type) {
+	case string:
+		if i, ok := prop.(float64); ok {
+			r := []rune(o)
+			if n, ok := arrayIndex(i); ok && n < len(r) {
+				return string(r[n]), nil
+			}
+			return undefined, nil
+		}
+		name := toString(prop)
+		if name == "length" {
+			return float64(len([]rune(o))), nil
+		}
+		if m, ok := stringMethods[name]; ok {
+			return builtin(func(args []value) (value, error) { return checkLength(m(o, args)) }), nil
+		}
+		return undefined, nil
+	case *array:
+		if n, ok := arrayIndex(prop); ok {
+			if _, isNum := prop.(float64); isNum || toString(prop) == toString(float64(n)) {
+				if n < len(o.elems) {
+					return o.elems[n], nil
+				}
+				return undefined, nil
+			}
+		}
+		name := toString(prop)
+		if name == "length" {
+			return float64(len(o.elems)), nil
+		}
+		if m, ok := arrayMethods[name]; ok {
+			return builtin(func(args []value) (value, error) { return checkLength(m(o, args)) }), nil
+		}
+		return undefined, nil
+	case nil, undefinedType:
+		return nil, fmt.Errorf("cannot read property %s of %s", toString(prop), toString(obj))
+	}
+	return undefined, nil
+}
+
+// checkLength fails method results past maxString, such as the string a
+// replace of s with itself doubles on every turn of a loop
+func checkLength(v value) (value, error) {
+	if s, ok := v.(string); ok && len(s) > maxString {
+		return nil, errStringTooLong
+	}
+	return v, nil
+}
+
+func arg(args []value, i int) value {
+	if i < len(args) {
+		return args[i]
+	}
+	return undefined
+}
+
+// intArg converts an optional index argument like JavaScript's ToInteger
+func intArg(args []value, i, def int) int {
+	v := arg(args, i)
+	if v == undefined {
+		return def
+	}
+	n := toNumber(v)
+	switch {
+	case math.IsNaN(n):
+		return 0
+	case n > math.MaxInt32:
+		return math.MaxInt32
+	case n < math.MinInt32:
+		return math.MinInt32
+	}
+	return int(n)
+}
+
+// clamp limits i to [0, n], counting negative values from the end when
+// fromEnd is set
+func clamp(i, n int, fromEnd bool) int {
+	if i < 0 && fromEnd {
+		i += n
+	}
+	if i < 0 {
+		return 0
+	}
+	if i > n {
+		return n
+	}
+	return i
+}
+
+var stringMethods = map[string]func(s string, args []value) value{
+	"toLowerCase": func(s string, _ []value) value { return strings.ToLower(s) },
+	"toUpperCase": func(s string, _ []value) value { return strings.ToUpper(s) },
+	"trim":        func(s string, _ []value) value { return strings.TrimSpace(s) },
+	"toString":    func(s string, _ []value) value { return s },
+	"charAt": func(s string, args []value) value {
+		r := []rune(s)
+		if i := intArg(args, 0, 0); i >= 0 && i < len(r) {
+			return string(r[i])
+		}
+		return ""
+	},
+	"charCodeAt": func(s string, args []value) value {
+		r := []rune(s)
+		if i := intArg(args, 0, 0); i >= 0 && i < len(r) {
+			return float64(r[i])
+		}
+		return math.NaN()
+	},
+	"indexOf": func(s string, args []value) value {
+		r := []rune(s)
+		from := clamp(intArg(args, 1, 0), len(r), false)
+		i := strings.Index(string(r[from:]), toString(arg(args, 0)))
+		if i < 0 {
+			return float64(-1)
+		}
+		return float64(from + len([]rune(string(r[from:])[:i])))
+	},
+	"lastIndexOf": func(s string, args []value) value {
+		i := strings.LastIndex(s, toString(arg(args, 0)))
+		if i < 0 {
+			return float64(-1)
+		}
+		return float64(len([]rune(s[:i])))
+	},
+	"substring": func(s string, args []value) value {
+		r := []rune(s)
+		a := clamp(intArg(args, 0, 0), len(r), false)
+		b := clamp(intArg(args, 1, len(r)), len(r), false)
+		if a > b {
+			a, b = b, a
+		}
+		return string(r[a:b])
+	},
+	"slice": func(s string, args []value) value {
+		r := []rune(s)
+		a := clamp(intArg(args, 0, 0), len(r), true)
+		b := clamp(intArg(args, 1, len(r)), len(r), true)
+		if a > b {
+			return ""
+		}
+		return string(r[a:b])
+	},
+	"substr": func(s string, args []value) value {
+		r := []rune(s)
+		a := clamp(intArg(args, 0, 0), len(r), true)
+		b := clamp(a+intArg(args, 1, len(r)), len(r), false)
+		if a > b {
+			return ""
+		}
+		return string(r[a:b])
+	},
+	"split": func(s string, args []value) value {
+		a := &array{}
+		sep := arg(args, 0)
+		if sep == undefined {
+			a.elems = []value{s}
+			return a
+		}
+		for _, part := range strings.Split(s, toString(sep)) {
+			a.elems = append(a.elems, part)
+		}
+		return a
+	},
+	"replace": func(s string, args []value) value {
+		return strings.Replace(s, toString(arg(args, 0)), toString(arg(args, 1)), 1)
+	},
+	"startsWith": func(s string, args []value) value { return strings.HasPrefix(s, toString(arg(args, 0))) },
+	"endsWith":   func(s string, args []value) value { return strings.HasSuffix(s, toString(arg(args, 0))) },
+	"includes":   func(s string, args []value) value { return strings.Contains(s, toString(arg(args, 0))) },
+}
+
+var arrayMethods = map[string]func(a *array, args []value) value{
+	"indexOf": func(a *array, args []value) value {
+		for i, e := range a.elems {
+			if strictEqual(e, arg(args, 0)) {
+				return float64(i)
+			}
+		}
+		return float64(-1)
+	},
+	"join": func(a *array, args []value) value {
+		sep := ","
+		if v := arg(args, 0); v != undefined {
+			sep = toString(v)
+		}
+		return a.join(sep, nil)
+	},
+	"push": func(a *array, args []value) value {
+		a.elems = append(a.elems, args...)
+		return float64(len(a.elems))
+	},
+	"toString": func(a *array, _ []value) value { return toString(a) },
+}
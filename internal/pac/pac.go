@@ -0,0 +1,239 @@
+// Package pac evaluates proxy auto-config scripts and discovers them with
+// WPAD, so downloads take the same proxy a browser on the network would
+package pac
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"os"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Script is a loaded PAC file. It is safe for concurrent use, calls are
+// serialised because scripts may keep state in globals.
+type Script struct {
+	mu     sync.Mutex
+	global *scope
+}
+
+// Parse compiles a PAC script and runs its top level code
+func Parse(src string) (*Script, error) {
+	body, err := parse(strings.TrimPrefix(src, "\ufeff"))
+	if err != nil {
+		return nil, err
+	}
+	s := &Script{global: newScope(nil)}
+	for name, fn := range s.builtins() {
+		s.global.vars[name] = fn
+	}
+	hoist(body, s.global)
+	if _, _, err := (&interp{}).execBody(body, s.global); err != nil {
+		return nil, err
+	}
+	if _, ok := s.global.vars["FindProxyForURL"].(*closure); !ok {
+		return nil, fmt.Errorf("script does not define FindProxyForURL")
+	}
+	return s, nil
+}
+
+// FindProxyForURL runs the script's function of the same name
+func (s *Script) FindProxyForURL(rawURL, host string) (string, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	in := &interp{}
+	v, err := in.call(s.global.vars["FindProxyForURL"], []value{rawURL, host})
+	if err != nil {
+		return "", err
+	}
+	if v == nil || v == undefined {
+		return "DIRECT", nil
+	}
+	return toString(v), nil
+}
+
+// Proxy is one entry of a FindProxyForURL result such as
+// "PROXY cache:3128; DIRECT"
+type Proxy struct {
+	Type string // DIRECT, PROXY, HTTP, HTTPS, SOCKS, SOCKS4 or SOCKS5
+	Addr string // host:port, empty for DIRECT
+}
+
+// ParseResult splits a FindProxyForURL result into its entries
+func ParseResult(s string) []Proxy {
+	var list []Proxy
+	for _, entry := range strings.Split(s, ";") {
+		fields := strings.Fields(entry)
+		switch {
+		case len(fields) == 0:
+		case len(fields) == 1:
+			list = append(list, Proxy{Type: strings.ToUpper(fields[0])})
+		default:
+			list = append(list, Proxy{Type: strings.ToUpper(fields[0]), Addr: fields[1]})
+		}
+	}
+	return list
+}
+
+// URL returns the proxy in the form http.Transport takes. ok is false for
+// types Go can't speak, such as SOCKS4.
+func (p Proxy) URL() (u *url.URL, ok bool) {
+	scheme := ""
+	switch p.Type {
+	case "DIRECT":
+		return nil, true
+	case "PROXY", "HTTP":
+		scheme = "http"
+	case "HTTPS":
+		scheme = "https"
+	case "SOCKS", "SOCKS5":
+		// Plain SOCKS means version 4, but servers nearly always speak 5 too
+		scheme = "socks5"
+	default:
+		return nil, false
+	}
+	if p.Addr == "" {
+		return nil, false
+	}
+	return &url.URL{Scheme: scheme, Host: p.Addr}, true
+}
+
+// Load reads a PAC script from an http(s) or file URL, or a local path.
+// Scripts are always fetched without a proxy.
+func Load(ctx context.Context, location string) (*Script, error) {
+	var data []byte
+	var err error
+	switch {
+	case strings.HasPrefix(location, "http://"), strings.HasPrefix(location, "https://"):
+		data, err = fetch(ctx, location)
+	case strings.HasPrefix(location, "file://"):
+		u, perr := url.Parse(location)
+		if perr != nil {
+			return nil, perr
+		}
+		data, err = os.ReadFile(u.Path)
+	default:
+		data, err = os.ReadFile(location)
+	}
+	if err != nil {
+		return nil, err
+	}
+	s, err := Parse(string(data))
+	if err != nil {
+		return nil, fmt.Errorf("%s: %w", location, err)
+	}
+	return s, nil
+}
+
+const fetchTimeout = 10 * time.Second
+
+// PAC files are small, anything beyond this is not one
+const maxScriptSize = 1 << 20
+
+func fetch(ctx context.Context, location string) ([]byte, error) {
+	ctx, cancel := context.WithTimeout(ctx, fetchTimeout)
+	defer cancel()
+	req, err := http.NewRequestWithContext(ctx, "GET", location, nil)
+	if err != nil {
+		return nil, err
+	}
+	client := &http.Client{Transport: &http.Transport{Proxy: nil}}
+	resp, err := client.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("%s: %s", location, resp.Status)
+	}
+	return io.ReadAll(io.LimitReader(resp.Body, maxScriptSize))
+}
+
+// Resolver picks the proxy for each request from a PAC script, loaded on
+// first use so discovery doesn't delay downloads that never need it
+type Resolver struct {
+	Location string                                // Script URL or path, empty to discover it with WPAD
+	Fallback func(*http.Request) (*url.URL, error) // Used when no script can be loaded or it fails
+
+	once   sync.Once
+	script *Script
+	err    error
+
+	mu    sync.Mutex
+	cache map[string]*url.URL // FindProxyForURL answers by the URL passed in
+}
+
+// Proxy has the signature of http.Transport.Proxy
+func (r *Resolver) Proxy(req *http.Request) (*url.URL, error) {
+	r.once.Do(r.load)
+	if r.script == nil {
+		return r.fallback(req)
+	}
+
+	// Like browsers, only show the script the origin of https URLs, the
+	// path and query can carry credentials
+	target := req.URL.String()
+	if req.URL.Scheme == "https" {
+		target = "https://" + req.URL.Host + "/"
+	}
+	r.mu.Lock()
+	u, ok := r.cache[target]
+	r.mu.Unlock()
+	if ok {
+		return u, nil
+	}
+
+	result, err := r.script.FindProxyForURL(target, req.URL.Hostname())
+	if err != nil {
+		return r.fallback(req)
+	}
+	u = nil
+	for _, p := range ParseResult(result) {
+		if pu, ok := p.URL(); ok {
+			u = pu
+			break
+		}
+	}
+	r.mu.Lock()
+	if r.cache == nil {
+		r.cache = map[string]*url.URL{}
+	}
+	r.cache[target] = u
+	r.mu.Unlock()
+	return u, nil
+}
+
+// Err reports why no script is in use, nil once one loaded
+func (r *Resolver) Err() error {
+	r.once.Do(r.load)
+	return r.err
+}
+
+func (r *Resolver) load() {
+	ctx := context.Background()
+	if r.Location != "" {
+		r.script, r.err = Load(ctx, r.Location)
+		return
+	}
+	candidates := Discover(ctx)
+	if len(candidates) == 0 {
+		r.err = fmt.Errorf("WPAD found no proxy configuration")
+		return
+	}
+	for _, c := range candidates {
+		if r.script, r.err = Load(ctx, c); r.err == nil {
+			return
+		}
+	}
+}
+
+func (r *Resolver) fallback(req *http.Request) (*url.URL, error) {
+	if r.Fallback == nil {
+		return nil, nil
+	}
+	return r.Fallback(req)
+}
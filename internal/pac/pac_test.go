@@ -0,0 +1,327 @@
+package pac
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func loadScript(t *testing.T, name string) *Script {
+	t.Helper()
+	s, err := Load(context.Background(), filepath.Join("testdata", name))
+	if err != nil {
+		t.Fatal(err)
+	}
+	return s
+}
+
+// TestScripts runs PAC files written the way they are found on networks.
+// Hosts other than plain names are IP literals or reach no DNS lookup.
+func TestScripts(t *testing.T) {
+	const (
+		corpProxy = "PROXY proxy.corp.example.com:8080; PROXY proxy-dr.corp.example.com:8080"
+		tunnel    = "SOCKS5 127.0.0.1:1080; SOCKS 127.0.0.1:1080"
+	)
+	tests := []struct {
+		file, url, host, want string
+	}{
+		{"corporate.pac", "http://wiki/page", "wiki", "DIRECT"},
+		{"corporate.pac", "https://hr.corp.example.com/", "HR.Corp.Example.com", "DIRECT"},
+		{"corporate.pac", "http://intranet/", "intranet", "DIRECT"},
+		{"corporate.pac", "http://intranet.example.com/", "intranet.example.com", "DIRECT"},
+		{"corporate.pac", "http://10.20.30.40/", "10.20.30.40", "DIRECT"},
+		{"corporate.pac", "http://172.31.0.1/", "172.31.0.1", "DIRECT"},
+		{"corporate.pac", "http://172.32.0.1/", "172.32.0.1", corpProxy},
+		{"corporate.pac", "http://192.168.1.1:8080/", "192.168.1.1", "DIRECT"},
+		{"corporate.pac", "http://8.8.8.8/", "8.8.8.8", corpProxy},
+		{"corporate.pac", "https://portal.partner.example.net/", "portal.partner.example.net", "PROXY extranet.corp.example.com:3128"},
+		{"corporate.pac", "ftp://files.example.org/pub/", "files.example.org", "PROXY ftp-gw.corp.example.com:2121"},
+		{"corporate.pac", "https://www.example.com/", "www.example.com", corpProxy},
+
+		{"mdn.pac", "http://localhost/", "localhost", "DIRECT"},
+		{"mdn.pac", "http://198.51.100.7/", "198.51.100.7", "DIRECT"},
+		{"mdn.pac", "http://203.0.113.7/", "203.0.113.7", "PROXY http-proxy.mydomain.com:8080"},
+		{"mdn.pac", "ftp://203.0.113.7/", "203.0.113.7", "PROXY ftp-proxy.mydomain.com:8080"},
+		{"mdn.pac", "https://203.0.113.7/", "203.0.113.7", "PROXY security-proxy.mydomain.com:8080"},
+		{"mdn.pac", "ws://203.0.113.7/", "203.0.113.7", "DIRECT"},
+
+		{"loadbalance.pac", "http://www.example.org/", "www.example.org", "DIRECT"},
+		{"loadbalance.pac", "http://example.org/", "example.org", "DIRECT"},
+		{"loadbalance.pac", "http://svc.test/", "svc.test", "DIRECT"},
+		{"loadbalance.pac", "http://www.example.com/", "www.example.com", "PROXY 10.1.0.11:3128; PROXY 10.1.0.12:3128; PROXY 10.1.0.13:3128; DIRECT"},
+		{"loadbalance.pac", "http://cdn.example.net/", "cdn.example.net", "PROXY 10.1.0.12:3128; PROXY 10.1.0.13:3128; PROXY 10.1.0.11:3128; DIRECT"},
+
+		{"blocklist.pac", "http://ads.example.com/a.js", "ads.example.com", "PROXY 127.0.0.1:9"},
+		{"blocklist.pac", "http://eu.ads.example.com/a.js", "EU.ads.example.com", "PROXY 127.0.0.1:9"},
+		{"blocklist.pac", "http://badads.example.com/", "badads.example.com", "DIRECT"},
+		{"blocklist.pac", "https://video.example/", "video.example", tunnel},
+		{"blocklist.pac", "https://www.news.example/", "www.news.example", tunnel},
+		{"blocklist.pac", "https://example.com/", "example.com", "DIRECT"},
+	}
+	scripts := map[string]*Script{}
+	for _, tt := range tests {
+		s, ok := scripts[tt.file]
+		if !ok {
+			s = loadScript(t, tt.file)
+			scripts[tt.file] = s
+		}
+		got, err := s.FindProxyForURL(tt.url, tt.host)
+		if err != nil {
+			t.Errorf("%s %s: %v", tt.file, tt.url, err)
+			continue
+		}
+		if got != tt.want {
+			t.Errorf("%s %s = %q, want %q", tt.file, tt.url, got, tt.want)
+		}
+	}
+
+	// Globals keep their values from one call to the next
+	if hits := scripts["blocklist.pac"].global.vars["hits"]; hits != float64(2) {
+		t.Errorf("blocklist hits = %v, want 2", hits)
+	}
+}
+
+func TestLanguage(t *testing.T) {
+	tests := []struct {
+		name, body, want string
+	}{
+		{"precedence", `return 1 + 2 * 3 - 4 / 2 % 3`, "5"},
+		{"concatenation", `return "a" + 1 + 2 + " " + (1 + 2)`, "a12 3"},
+		{"comparison", `return [1 < 2, "10" < "9", 10 < "9", null == undefined, null === undefined, "1" == 1]`, "true,true,false,true,false,true"},
+		{"logical", `return [0 || "x", 1 && "y", "" && z, !""]`, "x,y,,true"},
+		{"ternary", `var n = 5; return n > 3 ? n > 4 ? "big" : "mid" : "small"`, "big"},
+		{"typeof", `return [typeof 1, typeof "", typeof true, typeof nope, typeof null, typeof [], typeof isInNet, typeof function () {}]`, "number,string,boolean,undefined,object,object,function,function"},
+		{"compound assignment", `var s = "a"; s += "b"; var n = 10; n -= 3; n++; ++n; n--; return s + n`, "ab8"},
+		{"postfix value", `var i = 1; var j = i++; return [i, j, --i]`, "2,1,1"},
+		{"arrays", `var a = [1, "two", [3, 4]]; a.push(5); a[1] = 2; return a.length + ":" + a.join("-") + ":" + a.indexOf(5) + ":" + a[9]`, "4:1-2-3,4-5:3:undefined"},
+		{"strings", `var s = "Hello, World"; return [s.length, s.charAt(7), s.indexOf("o"), s.lastIndexOf("o"), s.substring(7, 5), s.slice(-5), s.substr(1, 3), s.toUpperCase(), s.split(", ").length, s.replace("l", "L"), s[0], s.startsWith("He"), s.endsWith("d"), s.includes(",")]`, "12,W,4,8,, ,World,ell,HELLO, WORLD,2,HeLlo, World,H,true,true,true"},
+		{"numbers", `return [0x1f, 1e3, .5, 7 / 2, -"3", +"", 1 / 0, "x" * 1]`, "31,1000,0.5,3.5,-3,0,Infinity,NaN"},
+		{"loops", `var n = 0; for (var i = 0; i < 10; i++) { if (i == 2) continue; if (i == 6) break; n += i } while (n < 100) n = n * 2; return n`, "104"},
+		{"closures", `function counter() { var c = 0; return function () { return ++c } } var f = counter(); f(); return f()`, "2"},
+		{"hoisting", `return later(); function later() { return v === undefined } var v = 1`, "true"},
+		{"recursion", `function fib(n) { return n < 2 ? n : fib(n - 1) + fib(n - 2) } return fib(15)`, "610"},
+		{"missing arguments", `function f(a, b) { return "" + b } return f(1)`, "undefined"},
+		{"escapes", `return "tab\tnewA\x42\'\"\\"`, "tab\tnewAB'\"\\"},
+		{"no semicolons", "var a = 1\nvar b = a\n++b\nreturn [a, b]", "1,2"},
+		{"comma", `var i, j; for (i = 0, j = 10; i < j; i++, j--); return i`, "5"},
+		{"self containing array", `var a = [1]; a.push(a); return a + ""`, "1,"},
+		{"builtins", `return [dnsDomainLevels("a.b.c"), convert_addr("10.0.0.1"), isInNet("10.1.2.3", "10.0.0.0", "255.0.0.0"), isInNet("11.1.2.3", "10.0.0.0", "255.0.0.0"), isPlainHostName("www"), localHostOrDomainIs("www", "www.example.com"), localHostOrDomainIs("home.example.com", "www.example.com"), dnsResolve("127.0.0.1"), isResolvable("192.0.2.1")]`, "2,167772161,true,false,true,true,false,127.0.0.1,true"},
+	}
+	for _, tt := range tests {
+		s, err := Parse("function FindProxyForURL(url, host) {\n" + tt.body + "\n}")
+		if err != nil {
+			t.Errorf("%s: %v", tt.name, err)
+			continue
+		}
+		got, err := s.FindProxyForURL("http://example.com/", "example.com")
+		if err != nil {
+			t.Errorf("%s: %v", tt.name, err)
+			continue
+		}
+		if got != tt.want {
+			t.Errorf("%s = %q, want %q", tt.name, got, tt.want)
+		}
+	}
+}
+
+func TestParseErrors(t *testing.T) {
+	tests := map[string]string{
+		"no FindProxyForURL": "function findProxyForURL(url, host) { return 'DIRECT' }",
+		"not a function":     "var FindProxyForURL = 'DIRECT'",
+		"object literal":     "var o = {a: 1}; function FindProxyForURL(u, h) { return o.a }",
+		"regular expression": "function FindProxyForURL(u, h) { return /a/.test(h) ? 'DIRECT' : '' }",
+		"switch":             "function FindProxyForURL(u, h) { switch (h) { case 'a': return 'DIRECT' } }",
+		"try":                "function FindProxyForURL(u, h) { try { return 'DIRECT' } catch (e) {} }",
+		"new":                "var a = new Array(); function FindProxyForURL(u, h) {}",
+		"for-in":             "function FindProxyForURL(u, h) { for (var k in [1]) {} }",
+		"unterminated":       "function FindProxyForURL(u, h) { return 'DIRECT }",
+		"unterminated block": "function FindProxyForURL(u, h) { return 'DIRECT'",
+		"comment":            "function FindProxyForURL(u, h) {} /* never closed",
+		"missing operand":    "function FindProxyForURL(u, h) { return 1 + }",
+		"top level throws":   "undefinedFunction(); function FindProxyForURL(u, h) {}",
+		"deep parentheses":   "var x = " + strings.Repeat("(", 100000) + "1" + strings.Repeat(")", 100000) + "; function FindProxyForURL(u, h) {}",
+		"deep blocks":        strings.Repeat("{", 100000) + strings.Repeat("}", 100000) + "function FindProxyForURL(u, h) {}",
+		"deep unary":         "var x = " + strings.Repeat("!", 100000) + "1; function FindProxyForURL(u, h) {}",
+		"deep arrays":        "var x = " + strings.Repeat("[", 100000) + strings.Repeat("]", 100000) + "; function FindProxyForURL(u, h) {}",
+	}
+	for name, src := range tests {
+		if _, err := Parse(src); err == nil {
+			t.Errorf("%s: parsed", name)
+		}
+	}
+
+	// Syntax errors point at the line they are on
+	_, err := Parse("function FindProxyForURL(url, host) {\n  if (host == ) return 'DIRECT'\n}")
+	if err == nil || !strings.Contains(err.Error(), "line 2") {
+		t.Errorf("err = %v, want it on line 2", err)
+	}
+}
+
+func TestRuntimeLimits(t *testing.T) {
+	tests := []struct {
+		name, body string
+		err        error
+	}{
+		{"endless loop", `while (true) {}`, errTooManySteps},
+		{"endless for", `for (;;) { host += "" }`, errTooManySteps},
+		{"runaway recursion", `function f(n) { return f(n + 1) } return f(0)`, errTooDeep},
+		{"doubling a string", `var s = "x"; while (true) s += s`, errStringTooLong},
+		{"doubling by replace", `var s = "xx"; while (true) s = s.replace("x", s)`, errStringTooLong},
+		{"doubling by join", `var a = ["x", "x"]; while (true) { var j = a.join(a.join("")); a = [j, j] }`, errStringTooLong},
+	}
+	for _, tt := range tests {
+		s, err := Parse("function FindProxyForURL(url, host) {\n" + tt.body + "\n}")
+		if err != nil {
+			t.Errorf("%s: %v", tt.name, err)
+			continue
+		}
+		if _, err := s.FindProxyForURL("http://example.com/", "example.com"); !errors.Is(err, tt.err) {
+			t.Errorf("%s: err = %v, want %v", tt.name, err, tt.err)
+		}
+	}
+
+	// A failed call leaves the script usable
+	s, err := Parse("var n = 0; function FindProxyForURL(url, host) { if (host == 'loop') for (;;) n++; return 'DIRECT' }")
+	if err != nil {
+		t.Fatal(err)
+	}
+	s.FindProxyForURL("http://loop/", "loop")
+	if got, err := s.FindProxyForURL("http://ok/", "ok"); err != nil || got != "DIRECT" {
+		t.Errorf("after a failed call: %q, %v", got, err)
+	}
+
+	for name, body := range map[string]string{
+		"undefined variable": `return nope`,
+		"undefined function": `return nope()`,
+		"not a function":     `return host()`,
+		"member of null":     `var x = null; return x.y`,
+		"assign to call":     `isInNet() = 1`,
+	} {
+		s, err := Parse("function FindProxyForURL(url, host) {\n" + body + "\n}")
+		if err != nil {
+			continue // Rejected up front is as good
+		}
+		if _, err := s.FindProxyForURL("http://example.com/", "example.com"); err == nil {
+			t.Errorf("%s: no error", name)
+		}
+	}
+}
+
+func TestParseResult(t *testing.T) {
+	tests := []struct {
+		in   string
+		want string // URL() of each entry, "!" for those Go can't use
+	}{
+		{"DIRECT", "direct"},
+		{"PROXY cache:3128; DIRECT", "http://cache:3128 direct"},
+		{" proxy a:1 ;;HTTPS b:443;  socks c:1080; SOCKS5 d:1; SOCKS4 e:2 ", "http://a:1 https://b:443 socks5://c:1080 socks5://d:1 !"},
+		{"HTTP [::1]:8080; PROXY", "http://[::1]:8080 !"},
+		{"", ""},
+	}
+	for _, tt := range tests {
+		var got []string
+		for _, p := range ParseResult(tt.in) {
+			u, ok := p.URL()
+			switch {
+			case !ok:
+				got = append(got, "!")
+			case u == nil:
+				got = append(got, "direct")
+			default:
+				got = append(got, u.String())
+			}
+		}
+		if strings.Join(got, " ") != tt.want {
+			t.Errorf("ParseResult(%q) = %s, want %s", tt.in, strings.Join(got, " "), tt.want)
+		}
+	}
+}
+
+func TestLoad(t *testing.T) {
+	src, err := os.ReadFile(filepath.Join("testdata", "mdn.pac"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path != "/proxy.pac" {
+			http.NotFound(w, r)
+			return
+		}
+		w.Header().Set("Content-Type", "application/x-ns-proxy-autoconfig")
+		w.Write(append([]byte("\ufeff"), src...))
+	}))
+	defer srv.Close()
+
+	abs, _ := filepath.Abs(filepath.Join("testdata", "mdn.pac"))
+	for _, loc := range []string{srv.URL + "/proxy.pac", "file://" + filepath.ToSlash(abs), abs} {
+		s, err := Load(context.Background(), loc)
+		if err != nil {
+			t.Errorf("%s: %v", loc, err)
+			continue
+		}
+		if got, _ := s.FindProxyForURL("http://203.0.113.7/", "203.0.113.7"); got != "PROXY http-proxy.mydomain.com:8080" {
+			t.Errorf("%s: got %q", loc, got)
+		}
+	}
+	if _, err := Load(context.Background(), srv.URL+"/missing.pac"); err == nil {
+		t.Error("loaded a 404")
+	}
+}
+
+func TestResolver(t *testing.T) {
+	dir := t.TempDir()
+	path := filepath.Join(dir, "proxy.pac")
+	script := `function FindProxyForURL(url, host) {
+		if (host == "direct.example") return "DIRECT";
+		if (host == "socks4.example") return "SOCKS4 s:1080; PROXY p:3128";
+		if (host == "broken.example") return nope();
+		return "PROXY p:3128; DIRECT " + url;
+	}`
+	if err := os.WriteFile(path, []byte(script), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	fallback, _ := url.Parse("http://fallback:1")
+	r := &Resolver{Location: path, Fallback: func(*http.Request) (*url.URL, error) { return fallback, nil }}
+
+	tests := []struct{ url, want string }{
+		{"http://direct.example/", ""},
+		{"http://socks4.example/", "http://p:3128"},
+		{"http://broken.example/", "http://fallback:1"},
+		{"https://other.example/secret?token=x", "http://p:3128"},
+	}
+	for _, tt := range tests {
+		req, _ := http.NewRequest("GET", tt.url, nil)
+		u, err := r.Proxy(req)
+		if err != nil {
+			t.Errorf("%s: %v", tt.url, err)
+			continue
+		}
+		if got := ""; u != nil {
+			got = u.String()
+			if got != tt.want {
+				t.Errorf("%s: proxy %s, want %s", tt.url, got, tt.want)
+			}
+		} else if tt.want != "" {
+			t.Errorf("%s: direct, want %s", tt.url, tt.want)
+		}
+	}
+	// https URLs are shown to the script as their origin only
+	for target := range r.cache {
+		if strings.Contains(target, "secret") {
+			t.Errorf("script saw %s", target)
+		}
+	}
+
+	// Without a script everything takes the fallback
+	r = &Resolver{Location: filepath.Join(dir, "missing.pac"), Fallback: func(*http.Request) (*url.URL, error) { return fallback, nil }}
+	req, _ := http.NewRequest("GET", "http://direct.example/", nil)
+	if u, _ := r.Proxy(req); u != fallback {
+		t.Errorf("missing script: proxy %v", u)
+	}
+}
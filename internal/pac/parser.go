@@ -0,0 +1,661 @@
+package pac
+
+import (
+	"fmt"
+)
+
+// The parser covers the part of JavaScript PAC files are written in:
+// functions, var/let/const, if/else, for and while loops, and expressions
+// over strings, numbers, booleans and arrays. Objects, regular expressions,
+// exceptions and classes are rejected with a syntax error.
+
+type stmt interface{}
+type expr interface{}
+
+type (
+	funcDecl struct {
+		name   string
+		params []string
+		body   []stmt
+	}
+	varDecl struct {
+		names []string
+		inits []expr // nil entries for declarations without a value
+	}
+	ifStmt struct {
+		cond      expr
+		then, els stmt
+	}
+	forStmt struct {
+		init stmt // nil, *varDecl or *exprStmt
+		cond expr // nil loops forever
+		post expr
+		body stmt
+	}
+	whileStmt struct {
+		cond expr
+		body stmt
+	}
+	returnStmt   struct{ value expr } // nil returns undefined
+	blockStmt    struct{ body []stmt }
+	exprStmt     struct{ x expr }
+	breakStmt    struct{}
+	continueStmt struct{}
+)
+
+type (
+	literal   struct{ v value }
+	ident     struct{ name string }
+	unaryExpr struct {
+		op string
+		x  expr
+	}
+	binaryExpr struct {
+		op   string
+		l, r expr
+	}
+	conditional struct{ cond, a, b expr }
+	assign      struct {
+		op     string // "=", "+=" or "-="
+		target expr   // *ident or *member
+		value  expr
+	}
+	update struct {
+		op     string // "++" or "--"
+		prefix bool
+		target expr
+	}
+	call struct {
+		fn   expr
+		args []expr
+	}
+	member struct {
+		obj  expr
+		prop expr // Property name as a string literal for a.b
+	}
+	arrayLit struct{ elems []expr }
+	funcLit  struct {
+		params []string
+		body   []stmt
+	}
+)
+
+type parser struct {
+	src   string
+	toks  []token
+	pos   int
+	depth int
+}
+
+// maxDepth bounds how deeply statements and expressions nest. Parsing and
+// evaluation recurse, and a script of a million "(" would otherwise run
+// the Go stack out, which no recover catches.
+const maxDepth = 500
+
+func parse(src string) ([]stmt, error) {
+	toks, err := tokenize(src)
+	if err != nil {
+		return nil, err
+	}
+	p := &parser{src: src, toks: toks}
+	var body []stmt
+	for p.peek().kind != tokEOF {
+		s, err := p.statement()
+		if err != nil {
+			return nil, err
+		}
+		body = append(body, s)
+	}
+	return body, nil
+}
+
+func (p *parser) peek() token {
+	return p.toks[p.pos]
+}
+
+func (p *parser) next() token {
+	t := p.toks[p.pos]
+	if t.kind != tokEOF {
+		p.pos++
+	}
+	return t
+}
+
+// is reports whether the next token is the punctuator or keyword s
+func (p *parser) is(s string) bool {
+	t := p.peek()
+	return (t.kind == tokPunct || t.kind == tokIdent) && t.text == s
+}
+
+func (p *parser) accept(s string) bool {
+	if p.is(s) {
+		p.pos++
+		return true
+	}
+	return false
+}
+
+func (p *parser) expect(s string) error {
+	if !p.accept(s) {
+		return p.errorf("expected %q", s)
+	}
+	return nil
+}
+
+func (p *parser) errorf(format string, args ...interface{}) error {
+	t := p.peek()
+	msg := fmt.Sprintf(format, args...)
+	switch t.kind {
+	case tokEOF:
+		msg += " at end of script"
+	case tokString:
+		msg += fmt.Sprintf(", found string %q", t.text)
+	default:
+		msg += fmt.Sprintf(", found %q", t.text)
+	}
+	return syntaxError(p.src, t.pos, msg)
+}
+
+// endStatement consumes a semicolon, or accepts its absence where
+// JavaScript would insert one
+func (p *parser) endStatement() error {
+	if p.accept(";") {
+		return nil
+	}
+	if t := p.peek(); t.nl || t.kind == tokEOF || p.is("}") {
+		return nil
+	}
+	return p.errorf("expected \";\"")
+}
+
+func (p *parser) identName() (string, error) {
+	t := p.peek()
+	if t.kind != tokIdent || keywords[t.text] {
+		return "", p.errorf("expected a name")
+	}
+	p.pos++
+	return t.text, nil
+}
+
+var keywords = map[string]bool{
+	"var": true, "let": true, "const": true, "function": true, "return": true, "if": true, "else": true,
+	"for": true, "while": true, "do": true, "break": true, "continue": true, "true": true, "false": true,
+	"null": true, "typeof": true, "new": true, "switch": true, "case": true, "default": true, "try": true,
+	"catch": true, "finally": true, "throw": true, "class": true, "this": true, "in": true, "instanceof": true,
+}
+
+// nest guards one level of recursion, callers defer p.depth--
+func (p *parser) nest() error {
+	if p.depth++; p.depth > maxDepth {
+		return p.errorf("nested too deeply")
+	}
+	return nil
+}
+
+func (p *parser) statement() (stmt, error) {
+	defer func() { p.depth-- }()
+	if err := p.nest(); err != nil {
+		return nil, err
+	}
+	t := p.peek()
+	if t.kind == tokIdent {
+		switch t.text {
+		case "function":
+			p.pos++
+			name, err := p.identName()
+			if err != nil {
+				return nil, err
+			}
+			params, body, err := p.function()
+			if err != nil {
+				return nil, err
+			}
+			return &funcDecl{name: name, params: params, body: body}, nil
+		case "var", "let", "const":
+			d, err := p.varDecl()
+			if err != nil {
+				return nil, err
+			}
+			return d, p.endStatement()
+		case "if":
+			return p.ifStatement()
+		case "for":
+			return p.forStatement()
+		case "while":
+			p.pos++
+			if err := p.expect("("); err != nil {
+				return nil, err
+			}
+			cond, err := p.expression()
+			if err != nil {
+				return nil, err
+			}
+			if err := p.expect(")"); err != nil {
+				return nil, err
+			}
+			body, err := p.statement()
+			if err != nil {
+				return nil, err
+			}
+			return &whileStmt{cond: cond, body: body}, nil
+		case "return":
+			p.pos++
+			r := &returnStmt{}
+			if n := p.peek(); !n.nl && n.kind != tokEOF && !p.is(";") && !p.is("}") {
+				v, err := p.expression()
+				if err != nil {
+					return nil, err
+				}
+				r.value = v
+			}
+			return r, p.endStatement()
+		case "break":
+			p.pos++
+			return &breakStmt{}, p.endStatement()
+		case "continue":
+			p.pos++
+			return &continueStmt{}, p.endStatement()
+		case "switch", "do", "try", "throw", "class", "new":
+			return nil, p.errorf("%q is not supported in PAC scripts", t.text)
+		}
+	}
+	if p.is("{") {
+		body, err := p.block()
+		if err != nil {
+			return nil, err
+		}
+		return &blockStmt{body: body}, nil
+	}
+	if p.accept(";") {
+		return &blockStmt{}, nil
+	}
+
+	x, err := p.expression()
+	if err != nil {
+		return nil, err
+	}
+	return &exprStmt{x: x}, p.endStatement()
+}
+
+func (p *parser) block() ([]stmt, error) {
+	if err := p.expect("{"); err != nil {
+		return nil, err
+	}
+	var body []stmt
+	for !p.accept("}") {
+		if p.peek().kind == tokEOF {
+			return nil, p.errorf("expected \"}\"")
+		}
+		s, err := p.statement()
+		if err != nil {
+			return nil, err
+		}
+		body = append(body, s)
+	}
+	return body, nil
+}
+
+// function parses a parameter list and body
+func (p *parser) function() ([]string, []stmt, error) {
+	if err := p.expect("("); err != nil {
+		return nil, nil, err
+	}
+	var params []string
+	for !p.accept(")") {
+		if len(params) > 0 {
+			if err := p.expect(","); err != nil {
+				return nil, nil, err
+			}
+		}
+		name, err := p.identName()
+		if err != nil {
+			return nil, nil, err
+		}
+		params = append(params, name)
+	}
+	body, err := p.block()
+	return params, body, err
+}
+
+func (p *parser) varDecl() (*varDecl, error) {
+	p.pos++ // var, let or const
+	d := &varDecl{}
+	for {
+		name, err := p.identName()
+		if err != nil {
+			return nil, err
+		}
+		var init expr
+		if p.accept("=") {
+			if init, err = p.assignment(); err != nil {
+				return nil, err
+			}
+		}
+		d.names = append(d.names, name)
+		d.inits = append(d.inits, init)
+		if !p.accept(",") {
+			return d, nil
+		}
+	}
+}
+
+func (p *parser) ifStatement() (stmt, error) {
+	p.pos++
+	if err := p.expect("("); err != nil {
+		return nil, err
+	}
+	cond, err := p.expression()
+	if err != nil {
+		return nil, err
+	}
+	if err := p.expect(")"); err != nil {
+		return nil, err
+	}
+	s := &ifStmt{cond: cond}
+	if s.then, err = p.statement(); err != nil {
+		return nil, err
+	}
+	if p.accept("else") {
+		if s.els, err = p.statement(); err != nil {
+			return nil, err
+		}
+	}
+	return s, nil
+}
+
+func (p *parser) forStatement() (stmt, error) {
+	p.pos++
+	if err := p.expect("("); err != nil {
+		return nil, err
+	}
+	s := &forStmt{}
+	var err error
+	switch {
+	case p.is(";"):
+	case p.is("var") || p.is("let") || p.is("const"):
+		if s.init, err = p.varDecl(); err != nil {
+			return nil, err
+		}
+	default:
+		x, err := p.expression()
+		if err != nil {
+			return nil, err
+		}
+		s.init = &exprStmt{x: x}
+	}
+	if p.is("in") || p.is("of") {
+		return nil, p.errorf("for-in and for-of loops are not supported in PAC scripts")
+	}
+	if err := p.expect(";"); err != nil {
+		return nil, err
+	}
+	if !p.is(";") {
+		if s.cond, err = p.expression(); err != nil {
+			return nil, err
+		}
+	}
+	if err := p.expect(";"); err != nil {
+		return nil, err
+	}
+	if !p.is(")") {
+		if s.post, err = p.expression(); err != nil {
+			return nil, err
+		}
+	}
+	if err := p.expect(")"); err != nil {
+		return nil, err
+	}
+	if s.body, err = p.statement(); err != nil {
+		return nil, err
+	}
+	return s, nil
+}
+
+// expression parses a comma separated sequence, evaluating to the last
+func (p *parser) expression() (expr, error) {
+	x, err := p.assignment()
+	for err == nil && p.accept(",") {
+		var r expr
+		if r, err = p.assignment(); err == nil {
+			x = &binaryExpr{op: ",", l: x, r: r}
+		}
+	}
+	return x, err
+}
+
+func (p *parser) assignment() (expr, error) {
+	x, err := p.conditional()
+	if err != nil {
+		return nil, err
+	}
+	for _, op := range []string{"=", "+=", "-="} {
+		if p.is(op) {
+			switch x.(type) {
+			case *ident, *member:
+			default:
+				return nil, p.errorf("invalid assignment target")
+			}
+			p.pos++
+			v, err := p.assignment()
+			if err != nil {
+				return nil, err
+			}
+			return &assign{op: op, target: x, value: v}, nil
+		}
+	}
+	return x, nil
+}
+
+func (p *parser) conditional() (expr, error) {
+	cond, err := p.binary(0)
+	if err != nil || !p.accept("?") {
+		return cond, err
+	}
+	a, err := p.assignment()
+	if err != nil {
+		return nil, err
+	}
+	if err := p.expect(":"); err != nil {
+		return nil, err
+	}
+	b, err := p.assignment()
+	if err != nil {
+		return nil, err
+	}
+	return &conditional{cond: cond, a: a, b: b}, nil
+}
+
+// Binary operators by increasing precedence
+var binaryLevels = [][]string{
+	{"||"},
+	{"&&"},
+	{"==", "!=", "===", "!=="},
+	{"<", ">", "<=", ">="},
+	{"+", "-"},
+	{"*", "/", "%"},
+}
+
+func (p *parser) binary(level int) (expr, error) {
+	if level == len(binaryLevels) {
+		return p.unary()
+	}
+	x, err := p.binary(level + 1)
+	if err != nil {
+		return nil, err
+	}
+	for {
+		op := ""
+		for _, o := range binaryLevels[level] {
+			if t := p.peek(); t.kind == tokPunct && t.text == o {
+				op = o
+				break
+			}
+		}
+		if op == "" {
+			return x, nil
+		}
+		p.pos++
+		r, err := p.binary(level + 1)
+		if err != nil {
+			return nil, err
+		}
+		x = &binaryExpr{op: op, l: x, r: r}
+	}
+}
+
+func (p *parser) unary() (expr, error) {
+	defer func() { p.depth-- }()
+	if err := p.nest(); err != nil {
+		return nil, err
+	}
+	for _, op := range []string{"!", "-", "+", "typeof"} {
+		if p.accept(op) {
+			x, err := p.unary()
+			if err != nil {
+				return nil, err
+			}
+			return &unaryExpr{op: op, x: x}, nil
+		}
+	}
+	for _, op := range []string{"++", "--"} {
+		if p.accept(op) {
+			x, err := p.unary()
+			if err != nil {
+				return nil, err
+			}
+			return p.update(op, true, x)
+		}
+	}
+
+	x, err := p.postfix()
+	if err != nil {
+		return nil, err
+	}
+	for _, op := range []string{"++", "--"} {
+		if !p.peek().nl && p.accept(op) {
+			return p.update(op, false, x)
+		}
+	}
+	return x, nil
+}
+
+func (p *parser) update(op string, prefix bool, target expr) (expr, error) {
+	switch target.(type) {
+	case *ident, *member:
+		return &update{op: op, prefix: prefix, target: target}, nil
+	}
+	return nil, p.errorf("invalid %s target", op)
+}
+
+// postfix parses calls, a.b and a[b] on top of a primary expression
+func (p *parser) postfix() (expr, error) {
+	x, err := p.primary()
+	if err != nil {
+		return nil, err
+	}
+	for {
+		switch {
+		case p.accept("("):
+			c := &call{fn: x}
+			for !p.accept(")") {
+				if len(c.args) > 0 {
+					if err := p.expect(","); err != nil {
+						return nil, err
+					}
+				}
+				arg, err := p.assignment()
+				if err != nil {
+					return nil, err
+				}
+				c.args = append(c.args, arg)
+			}
+			x = c
+		case p.accept("."):
+			t := p.next()
+			if t.kind != tokIdent {
+				p.pos--
+				return nil, p.errorf("expected a property name")
+			}
+			x = &member{obj: x, prop: &literal{v: t.text}}
+		case p.accept("["):
+			prop, err := p.expression()
+			if err != nil {
+				return nil, err
+			}
+			if err := p.expect("]"); err != nil {
+				return nil, err
+			}
+			x = &member{obj: x, prop: prop}
+		default:
+			return x, nil
+		}
+	}
+}
+
+func (p *parser) primary() (expr, error) {
+	t := p.peek()
+	switch t.kind {
+	case tokNumber:
+		p.pos++
+		return &literal{v: t.num}, nil
+	case tokString:
+		p.pos++
+		return &literal{v: t.text}, nil
+	case tokIdent:
+		switch t.text {
+		case "true", "false":
+			p.pos++
+			return &literal{v: t.text == "true"}, nil
+		case "null":
+			p.pos++
+			return &literal{v: nil}, nil
+		case "function":
+			p.pos++
+			if p.peek().kind == tokIdent {
+				p.pos++ // Name of a function expression, only visible inside it
+			}
+			params, body, err := p.function()
+			if err != nil {
+				return nil, err
+			}
+			return &funcLit{params: params, body: body}, nil
+		}
+		if keywords[t.text] {
+			return nil, p.errorf("%q is not supported in PAC scripts", t.text)
+		}
+		p.pos++
+		return &ident{name: t.text}, nil
+	}
+
+	switch {
+	case p.accept("("):
+		x, err := p.expression()
+		if err != nil {
+			return nil, err
+		}
+		return x, p.expect(")")
+	case p.accept("["):
+		a := &arrayLit{}
+		for !p.accept("]") {
+			if len(a.elems) > 0 {
+				if err := p.expect(","); err != nil {
+					return nil, err
+				}
+				if p.accept("]") {
+					break // Trailing comma
+				}
+			}
+			x, err := p.assignment()
+			if err != nil {
+				return nil, err
+			}
+			a.elems = append(a.elems, x)
+		}
+		return a, nil
+	case p.is("/"):
+		return nil, p.errorf("regular expressions are not supported in PAC scripts")
+	case p.is("{"):
+		return nil, p.errorf("object literals are not supported in PAC scripts")
+	}
+	return nil, p.errorf("expected an expression")
+}
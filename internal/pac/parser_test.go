@@ -0,0 +1,52 @@
+package pac
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestNestingLimit(t *testing.T) {
+	ok := "var x = " + strings.Repeat("(", 100) + "1" + strings.Repeat(")", 100)
+	if _, err := parse(ok); err != nil {
+		t.Errorf("100 parentheses: %v", err)
+	}
+	_, err := parse("var x = " + strings.Repeat("(", maxDepth) + "1" + strings.Repeat(")", maxDepth))
+	if err == nil || !strings.Contains(err.Error(), "nested too deeply") {
+		t.Errorf("err = %v", err)
+	}
+}
+
+// FuzzParse feeds the parser and interpreter what a WPAD server could
+// send. Nothing may panic or run the stack out, and a script that parses
+// must answer or fail within its limits.
+func FuzzParse(f *testing.F) {
+	files, _ := filepath.Glob(filepath.Join("testdata", "*.pac"))
+	for _, name := range files {
+		src, err := os.ReadFile(name)
+		if err != nil {
+			f.Fatal(err)
+		}
+		f.Add(string(src))
+	}
+	for _, src := range []string{
+		"function FindProxyForURL(url, host) { return 'DIRECT' }",
+		"function FindProxyForURL(u, h) { var a = [1, [2, 'x']]; a.push(a); return a.join(h) + u.substring(-1, 4) }",
+		"function FindProxyForURL(u, h) { for (var i = 0; i < 10; i++) if (i % 2) continue; else h += i; return h }",
+		"var f = function (a) { return a ? f(a - 1) : typeof a }; function FindProxyForURL(u, h) { return f(3) }",
+		"function FindProxyForURL(u, h) { return weekdayRange('MON', 'FRI') && timeRange(9, 17) ? 'PROXY p:1' : 'DIRECT' }",
+		"function FindProxyForURL(u, h) { return \"\\x41\\u0042\\n\" + 0x10 + 1e2 + .5 }",
+		"/* c */ // d\nfunction FindProxyForURL(u,h){return!h.length?-+1:h[0]}",
+	} {
+		f.Add(src)
+	}
+	f.Fuzz(func(t *testing.T, src string) {
+		s, err := Parse(src)
+		if err != nil {
+			return
+		}
+		s.FindProxyForURL("https://www.example.com/", "www.example.com")
+		s.FindProxyForURL("http://10.0.0.1/", "10.0.0.1")
+	})
+}
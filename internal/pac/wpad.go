@@ -0,0 +1,70 @@
+package pac
+
+import (
+	"context"
+	"net"
+	"os"
+	"strings"
+	"time"
+)
+
+// WPAD finds the PAC script of the local network. DHCP option 252 is asked
+// first, then the DNS names wpad.<domain> for the machine's domain and each
+// parent domain, the way browsers with "auto-detect proxy settings" do.
+
+const wpadDNSTimeout = 2 * time.Second
+
+// Discover returns possible PAC script URLs in order of preference
+func Discover(ctx context.Context) []string {
+	var found []string
+	seen := map[string]bool{}
+	add := func(u string) {
+		if u != "" && !seen[u] {
+			seen[u] = true
+			found = append(found, u)
+		}
+	}
+
+	for _, u := range dhcpWPAD(ctx) {
+		add(u)
+	}
+	for _, host := range wpadHosts(localDomains()) {
+		lctx, cancel := context.WithTimeout(ctx, wpadDNSTimeout)
+		addrs, err := net.DefaultResolver.LookupHost(lctx, host)
+		cancel()
+		if err == nil && len(addrs) > 0 {
+			add("http://" + host + "/wpad.dat")
+		}
+	}
+	return found
+}
+
+// wpadHosts lists wpad.<domain> for every domain and its parents. It stops
+// above the registrable domain, wpad.co.uk and the like belong to whoever
+// registered them, not to the local network.
+func wpadHosts(domains []string) []string {
+	var hosts []string
+	for _, d := range domains {
+		labels := strings.Split(strings.Trim(strings.ToLower(d), "."), ".")
+		for i := 0; len(labels)-i >= 2; i++ {
+			rest := labels[i:]
+			if len(rest) == 2 && len(rest[1]) == 2 && len(rest[0]) <= 3 {
+				break // co.uk, com.au, ...
+			}
+			hosts = append(hosts, "wpad."+strings.Join(rest, "."))
+		}
+	}
+	return hosts
+}
+
+// hostDomain is the domain part of the host name, if it is qualified
+func hostDomain() string {
+	name, err := os.Hostname()
+	if err != nil {
+		return ""
+	}
+	if _, domain, ok := strings.Cut(name, "."); ok {
+		return domain
+	}
+	return ""
+}
@@ -0,0 +1,199 @@
+//go:build !windows
+
+package pac
+
+import (
+	"bufio"
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"net"
+	"os"
+	"path/filepath"
+	"regexp"
+	"strings"
+	"time"
+)
+
+// localDomains are the search domains of the resolver and the host's own
+func localDomains() []string {
+	var domains []string
+	if d := hostDomain(); d != "" {
+		domains = append(domains, d)
+	}
+	f, err := os.Open("/etc/resolv.conf")
+	if err != nil {
+		return domains
+	}
+	defer f.Close()
+	sc := bufio.NewScanner(f)
+	for sc.Scan() {
+		fields := strings.Fields(sc.Text())
+		if len(fields) > 1 && (fields[0] == "search" || fields[0] == "domain") {
+			domains = append(domains, fields[1:]...)
+		}
+	}
+	return domains
+}
+
+// dhcpWPAD asks the DHCP server for option 252, falling back to what the
+// system's DHCP client recorded in its lease files
+func dhcpWPAD(ctx context.Context) []string {
+	var urls []string
+	if u := dhcpInform(ctx); u != "" {
+		urls = append(urls, u)
+	}
+	return append(urls, leaseWPAD()...)
+}
+
+const (
+	dhcpOptWPAD    = 252
+	dhcpInformWait = 2 * time.Second
+)
+
+// dhcpInform sends a DHCPINFORM for option 252 from the address of the
+// default route. Replies go to port 68, which needs root or
+// CAP_NET_BIND_SERVICE, so this quietly gives up without them.
+func dhcpInform(ctx context.Context) string {
+	ip, mac := defaultInterface()
+	if ip == nil || mac == nil {
+		return ""
+	}
+	conn, err := net.ListenPacket("udp4", ":68")
+	if err != nil {
+		return ""
+	}
+	defer conn.Close()
+
+	var xid [4]byte
+	rand.Read(xid[:])
+	// BOOTP header, then the magic cookie and options
+	msg := make([]byte, 240)
+	msg[0], msg[1], msg[2] = 1, 1, 6 // BOOTREQUEST over Ethernet
+	copy(msg[4:8], xid[:])
+	copy(msg[12:16], ip)
+	copy(msg[28:], mac)
+	copy(msg[236:], []byte{99, 130, 83, 99})
+	msg = append(msg,
+		53, 1, 8, // DHCPINFORM
+		55, 1, dhcpOptWPAD, // Parameter request list
+		255)
+
+	if _, err := conn.WriteTo(msg, &net.UDPAddr{IP: net.IPv4bcast, Port: 67}); err != nil {
+		return ""
+	}
+	deadline := time.Now().Add(dhcpInformWait)
+	if d, ok := ctx.Deadline(); ok && d.Before(deadline) {
+		deadline = d
+	}
+	conn.SetReadDeadline(deadline)
+	buf := make([]byte, 1500)
+	for {
+		n, _, err := conn.ReadFrom(buf)
+		if err != nil {
+			return ""
+		}
+		reply := buf[:n]
+		if n < 240 || reply[0] != 2 || string(reply[4:8]) != string(xid[:]) {
+			continue
+		}
+		if u := dhcpOption(reply[240:], dhcpOptWPAD); u != "" {
+			return u
+		}
+	}
+}
+
+// dhcpOption returns a text option from the options part of a message
+func dhcpOption(opts []byte, code byte) string {
+	for i := 0; i < len(opts); {
+		switch opts[i] {
+		case 0:
+			i++
+			continue
+		case 255:
+			return ""
+		}
+		if i+1 >= len(opts) {
+			return ""
+		}
+		length := int(opts[i+1])
+		if i+2+length > len(opts) {
+			return ""
+		}
+		if opts[i] == code {
+			return strings.TrimRight(string(opts[i+2:i+2+length]), "\x00")
+		}
+		i += 2 + length
+	}
+	return ""
+}
+
+// defaultInterface returns the IPv4 address and hardware address of the
+// interface outbound traffic leaves through
+func defaultInterface() (net.IP, net.HardwareAddr) {
+	c, err := net.Dial("udp4", "198.51.100.1:53")
+	if err != nil {
+		return nil, nil
+	}
+	ip := c.LocalAddr().(*net.UDPAddr).IP.To4()
+	c.Close()
+
+	ifaces, err := net.Interfaces()
+	if err != nil {
+		return nil, nil
+	}
+	for _, iface := range ifaces {
+		addrs, _ := iface.Addrs()
+		for _, a := range addrs {
+			if n, ok := a.(*net.IPNet); ok && n.IP.Equal(ip) && len(iface.HardwareAddr) == 6 {
+				return ip, iface.HardwareAddr
+			}
+		}
+	}
+	return nil, nil
+}
+
+// Lease files of dhclient (Linux and the BSDs) and systemd-networkd
+var leaseGlobs = []string{
+	"/var/lib/dhcp/dhclient*.leases",
+	"/var/lib/dhclient/*.lease*",
+	"/var/lib/NetworkManager/dhclient-*.lease",
+	"/var/db/dhclient.leases.*",
+	"/run/systemd/netif/leases/*",
+}
+
+var (
+	// dhclient writes option 252 by name when dhclient.conf declares it,
+	// otherwise by number
+	dhclientWPAD = regexp.MustCompile(`option (?:wpad|wpad-url|unknown-252|code-252|option-252) "([^"]+)"`)
+	// systemd-networkd keeps private options (224-254) as hex
+	networkdWPAD = regexp.MustCompile(`(?m)^OPTION_252=([0-9a-fA-F]+)$`)
+)
+
+// leaseWPAD collects option 252 from DHCP client lease files, most recent
+// lease first
+func leaseWPAD() []string {
+	var urls []string
+	for _, g := range leaseGlobs {
+		paths, _ := filepath.Glob(g)
+		for _, p := range paths {
+			data, err := os.ReadFile(p)
+			if err != nil {
+				continue
+			}
+			for _, m := range dhclientWPAD.FindAllSubmatch(data, -1) {
+				urls = append(urls, string(m[1]))
+			}
+			for _, m := range networkdWPAD.FindAllSubmatch(data, -1) {
+				if b, err := hex.DecodeString(string(m[1])); err == nil {
+					urls = append(urls, strings.TrimRight(string(b), "\x00"))
+				}
+			}
+		}
+	}
+	// The last lease is the current one
+	for i, j := 0, len(urls)-1; i < j; i, j = i+1, j-1 {
+		urls[i], urls[j] = urls[j], urls[i]
+	}
+	return urls
+}
@@ -0,0 +1,53 @@
+package pac
+
+import (
+	"context"
+	"unsafe"
+
+	"golang.org/x/sys/windows"
+	"golang.org/x/sys/windows/registry"
+)
+
+var (
+	winhttp                             = windows.NewLazySystemDLL("winhttp.dll")
+	procWinHttpDetectAutoProxyConfigUrl = winhttp.NewProc("WinHttpDetectAutoProxyConfigUrl")
+)
+
+const winhttpAutoDetectTypeDHCP = 0x1
+
+// localDomains is the primary DNS suffix of the machine
+func localDomains() []string {
+	var domains []string
+	if d := hostDomain(); d != "" {
+		domains = append(domains, d)
+	}
+	var buf [256]uint16
+	n := uint32(len(buf))
+	if windows.GetComputerNameEx(windows.ComputerNameDnsDomain, &buf[0], &n) == nil && n > 0 {
+		domains = append(domains, windows.UTF16ToString(buf[:n]))
+	}
+	return domains
+}
+
+// dhcpWPAD returns the script URL set in the Internet Options, usually by
+// group policy, and the one the DHCP server hands out through WinHTTP
+func dhcpWPAD(ctx context.Context) []string {
+	var urls []string
+	if k, err := registry.OpenKey(registry.CURRENT_USER, `Software\Microsoft\Windows\CurrentVersion\Internet Settings`, registry.QUERY_VALUE); err == nil {
+		if u, _, err := k.GetStringValue("AutoConfigURL"); err == nil && u != "" {
+			urls = append(urls, u)
+		}
+		k.Close()
+	}
+
+	if procWinHttpDetectAutoProxyConfigUrl.Find() != nil {
+		return urls
+	}
+	var out *uint16
+	r, _, _ := procWinHttpDetectAutoProxyConfigUrl.Call(winhttpAutoDetectTypeDHCP, uintptr(unsafe.Pointer(&out)))
+	if r != 0 && out != nil {
+		urls = append(urls, windows.UTF16PtrToString(out))
+		windows.LocalFree(windows.Handle(unsafe.Pointer(out)))
+	}
+	return urls
+}
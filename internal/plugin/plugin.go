@@ -0,0 +1,166 @@
+// Package plugin runs warp-dl plugins: executables called at hook points
+// of a download with the hook's name as their argument and a JSON Request
+// on stdin, answering with a JSON Response on stdout. A plugin that has
+// nothing to do for a hook prints nothing or {}. What it writes to stderr
+// is shown to the user.
+package plugin
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+	"time"
+)
+
+// Hook is a point of a download where plugins are called
+type Hook string
+
+const (
+	URLRewrite      Hook = "url-rewrite"      // Before anything else, Response.URL replaces the URL, e.g. a premium link
+	HeaderInjection Hook = "header-injection" // Response.Headers are added to every request
+	PreDownload     Hook = "pre-download"     // Response.Error refuses the download
+	PostDownload    Hook = "post-download"    // After the download is verified and in place, e.g. to upload it
+)
+
+// Timeout is how long a plugin gets per call
+const Timeout = 2 * time.Minute
+
+// Request describes the download to the plugin
+type Request struct {
+	Hook   Hook   `json:"hook"`
+	URL    string `json:"url"`
+	Output string `json:"output,omitempty"` // The output path, when known: pre-download and post-download
+	Size   int64  `json:"size,omitempty"`   // post-download
+	SHA256 string `json:"sha256,omitempty"` // post-download, hex
+}
+
+// Response is what a plugin answers. Fields that don't belong to the hook
+// are ignored.
+type Response struct {
+	URL     string            `json:"url,omitempty"`
+	Headers map[string]string `json:"headers,omitempty"`
+	Error   string            `json:"error,omitempty"`
+}
+
+// Plugin is a plugin executable
+type Plugin struct {
+	Path string
+}
+
+// Name is the plugin's file name, for messages
+func (p Plugin) Name() string {
+	return filepath.Base(p.Path)
+}
+
+// Call runs the plugin for a hook and returns its answer. A plugin that
+// fails or answers something other than JSON is an error; Response.Error
+// is left for the caller to act on.
+func (p Plugin) Call(ctx context.Context, req Request) (Response, error) {
+	in, err := json.Marshal(req)
+	if err != nil {
+		return Response{}, err
+	}
+	ctx, cancel := context.WithTimeout(ctx, Timeout)
+	defer cancel()
+
+	cmd := exec.CommandContext(ctx, p.Path, string(req.Hook))
+	cmd.Env = append(os.Environ(), "WARP_DL_HOOK="+string(req.Hook))
+	cmd.Stdin = bytes.NewReader(in)
+	cmd.Stderr = os.Stderr
+	out, err := cmd.Output()
+	if ctx.Err() == context.DeadlineExceeded {
+		return Response{}, fmt.Errorf("plugin %s: %s took longer than %s", p.Name(), req.Hook, Timeout)
+	}
+	if err != nil {
+		return Response{}, fmt.Errorf("plugin %s: %s: %w", p.Name(), req.Hook, err)
+	}
+
+	var resp Response
+	if out = bytes.TrimSpace(out); len(out) == 0 {
+		return resp, nil
+	}
+	if err := json.Unmarshal(out, &resp); err != nil {
+		return Response{}, fmt.Errorf("plugin %s: %s: invalid answer: %w", p.Name(), req.Hook, err)
+	}
+	return resp, nil
+}
+
+// Chain is the plugins of a run, called in order
+type Chain []Plugin
+
+// RewriteURL passes the URL through every plugin in turn
+func (c Chain) RewriteURL(ctx context.Context, url string) (string, error) {
+	for _, p := range c {
+		resp, err := p.Call(ctx, Request{Hook: URLRewrite, URL: url})
+		if err != nil {
+			return "", err
+		}
+		if resp.Error != "" {
+			return "", fmt.Errorf("plugin %s: %s", p.Name(), resp.Error)
+		}
+		if resp.URL != "" {
+			url = resp.URL
+		}
+	}
+	return url, nil
+}
+
+// Headers collects the headers the plugins add for the URL, later plugins
+// winning over earlier ones
+func (c Chain) Headers(ctx context.Context, url string) (map[string]string, error) {
+	headers := map[string]string{}
+	for _, p := range c {
+		resp, err := p.Call(ctx, Request{Hook: HeaderInjection, URL: url})
+		if err != nil {
+			return nil, err
+		}
+		if resp.Error != "" {
+			return nil, fmt.Errorf("plugin %s: %s", p.Name(), resp.Error)
+		}
+		for name, value := range resp.Headers {
+			if strings.ContainsAny(name, " \r\n:") || strings.ContainsAny(value, "\r\n") {
+				return nil, fmt.Errorf("plugin %s: invalid header %q", p.Name(), name)
+			}
+			headers[name] = value
+		}
+	}
+	return headers, nil
+}
+
+// PreDownload asks every plugin whether the download may start, the first
+// refusal stops it
+func (c Chain) PreDownload(ctx context.Context, url, output string) error {
+	for _, p := range c {
+		resp, err := p.Call(ctx, Request{Hook: PreDownload, URL: url, Output: output})
+		if err != nil {
+			return err
+		}
+		if resp.Error != "" {
+			return fmt.Errorf("plugin %s refused the download: %s", p.Name(), resp.Error)
+		}
+	}
+	return nil
+}
+
+// PostDownload tells every plugin about the finished download, even when
+// an earlier one fails, and returns their failures
+func (c Chain) PostDownload(ctx context.Context, req Request) error {
+	req.Hook = PostDownload
+	var errs []error
+	for _, p := range c {
+		resp, err := p.Call(ctx, req)
+		if err == nil && resp.Error != "" {
+			err = fmt.Errorf("plugin %s: %s", p.Name(), resp.Error)
+		}
+		if err != nil {
+			errs = append(errs, err)
+		}
+	}
+	return errors.Join(errs...)
+}
@@ -0,0 +1,75 @@
+// Package priority lowers the CPU and disk scheduling priority of the
+// process so long running downloads stay out of the way of the desktop.
+package priority
+
+import (
+	"errors"
+	"fmt"
+	"strconv"
+	"strings"
+)
+
+// IOClass follows the Linux I/O scheduling classes
+type IOClass int
+
+const (
+	IORealtime   IOClass = 1
+	IOBestEffort IOClass = 2
+	IOIdle       IOClass = 3
+)
+
+// IOPriority is an I/O class with a level from 0 (highest) to 7 (lowest).
+// The level is ignored for the idle class.
+type IOPriority struct {
+	Class IOClass
+	Level int
+}
+
+var errUnsupported = errors.New("not supported on this platform")
+
+// ParseIOPriority parses "idle", "best-effort[:level]" or
+// "realtime[:level]". The short forms "be" and "rt" work too, and a bare
+// level means best-effort.
+func ParseIOPriority(s string) (*IOPriority, error) {
+	name, levelStr, hasLevel := strings.Cut(strings.ToLower(strings.TrimSpace(s)), ":")
+	p := &IOPriority{Level: 4}
+
+	switch name {
+	case "idle":
+		return &IOPriority{Class: IOIdle}, nil
+	case "best-effort", "be":
+		p.Class = IOBestEffort
+	case "realtime", "rt":
+		p.Class = IORealtime
+	default:
+		if hasLevel {
+			return nil, fmt.Errorf("invalid I/O priority %q, expected idle, best-effort[:0-7] or realtime[:0-7]", s)
+		}
+		// Bare level
+		p.Class = IOBestEffort
+		levelStr, hasLevel = name, true
+	}
+
+	if hasLevel {
+		level, err := strconv.Atoi(levelStr)
+		if err != nil || level < 0 || level > 7 {
+			return nil, fmt.Errorf("invalid I/O priority level %q, expected 0-7", levelStr)
+		}
+		p.Level = level
+	}
+	return p, nil
+}
+
+// SetNice changes the CPU niceness of the process (-20 to 19, higher is
+// nicer). Raising priority above 0 usually needs privileges.
+func SetNice(nice int) error {
+	if nice < -20 || nice > 19 {
+		return fmt.Errorf("nice value %d out of range -20..19", nice)
+	}
+	return setNice(nice)
+}
+
+// SetIO changes the I/O scheduling priority of the process
+func SetIO(p *IOPriority) error {
+	return setIO(p)
+}
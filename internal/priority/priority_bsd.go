@@ -0,0 +1,13 @@
+//go:build darwin || freebsd || netbsd || openbsd || dragonfly
+
+package priority
+
+import "golang.org/x/sys/unix"
+
+func setNice(nice int) error {
+	return unix.Setpriority(unix.PRIO_PROCESS, 0, nice)
+}
+
+func setIO(p *IOPriority) error {
+	return errUnsupported
+}
@@ -0,0 +1,50 @@
+package priority
+
+import (
+	"os"
+	"strconv"
+
+	"golang.org/x/sys/unix"
+)
+
+const (
+	ioprioWhoProcess = 1
+	ioprioClassShift = 13
+)
+
+// Linux applies both priorities per thread. Every existing thread of the
+// process is updated, threads started later inherit from their creator.
+
+func setNice(nice int) error {
+	return eachThread(func(tid int) error {
+		return unix.Setpriority(unix.PRIO_PROCESS, tid, nice)
+	})
+}
+
+func setIO(p *IOPriority) error {
+	prio := uintptr(p.Class)<<ioprioClassShift | uintptr(p.Level)
+	return eachThread(func(tid int) error {
+		if _, _, errno := unix.Syscall(unix.SYS_IOPRIO_SET, ioprioWhoProcess, uintptr(tid), prio); errno != 0 {
+			return errno
+		}
+		return nil
+	})
+}
+
+func eachThread(fn func(tid int) error) error {
+	entries, err := os.ReadDir("/proc/self/task")
+	if err != nil {
+		// No procfs, settle for the calling thread
+		return fn(0)
+	}
+	for _, e := range entries {
+		tid, err := strconv.Atoi(e.Name())
+		if err != nil {
+			continue
+		}
+		if err := fn(tid); err != nil && err != unix.ESRCH {
+			return err
+		}
+	}
+	return nil
+}
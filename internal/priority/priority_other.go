@@ -0,0 +1,11 @@
+//go:build !linux && !windows && !darwin && !freebsd && !netbsd && !openbsd && !dragonfly
+
+package priority
+
+func setNice(nice int) error {
+	return errUnsupported
+}
+
+func setIO(p *IOPriority) error {
+	return errUnsupported
+}
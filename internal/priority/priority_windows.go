@@ -0,0 +1,29 @@
+package priority
+
+import "golang.org/x/sys/windows"
+
+// Windows has priority classes instead of nice values, map the range onto
+// the classes below and above normal
+func setNice(nice int) error {
+	class := uint32(windows.NORMAL_PRIORITY_CLASS)
+	switch {
+	case nice >= 15:
+		class = windows.IDLE_PRIORITY_CLASS
+	case nice > 0:
+		class = windows.BELOW_NORMAL_PRIORITY_CLASS
+	case nice <= -15:
+		class = windows.HIGH_PRIORITY_CLASS
+	case nice < 0:
+		class = windows.ABOVE_NORMAL_PRIORITY_CLASS
+	}
+	return windows.SetPriorityClass(windows.CurrentProcess(), class)
+}
+
+// Background processing mode lowers I/O (and memory) priority. There are no
+// finer levels, so only the idle class maps onto it.
+func setIO(p *IOPriority) error {
+	if p.Class != IOIdle {
+		return errUnsupported
+	}
+	return windows.SetPriorityClass(windows.CurrentProcess(), windows.PROCESS_MODE_BACKGROUND_BEGIN)
+}
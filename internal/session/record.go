@@ -0,0 +1,168 @@
+package session
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"hash"
+	"io"
+	"net/http"
+	"net/url"
+	"os"
+	"sync"
+	"time"
+
+	"warp-dl/internal/downloader"
+)
+
+// Recorder captures a download. Wrap goes in the engine's
+// Config.WrapTransport and Note in its Config.Trace.
+type Recorder struct {
+	mu      sync.Mutex
+	session Session
+	keep    int64 // Body bytes kept per response, -1 for all
+}
+
+// NewRecorder starts recording the download cfg describes. Each response
+// keeps the first keep bytes of its body, or all of it for -1. Only the
+// hash of the rest is recorded, a replay makes up bytes in its place.
+func NewRecorder(cfg downloader.Config, keep int64) *Recorder {
+	return &Recorder{
+		session: Session{
+			Version:  Version,
+			URL:      redactURL(cfg.URL),
+			Started:  time.Now(),
+			Settings: SettingsOf(cfg),
+		},
+		keep: keep,
+	}
+}
+
+// Wrap records the exchanges that go through rt
+func (r *Recorder) Wrap(rt http.RoundTripper) http.RoundTripper {
+	return &recordTransport{rec: r, base: rt}
+}
+
+// Note records a decision of the engine
+func (r *Recorder) Note(msg string) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.session.Events = append(r.session.Events, Event{At: r.since(), Msg: msg})
+}
+
+// Save writes the recording with the error the download ended with
+func (r *Recorder) Save(path string, result error) error {
+	r.mu.Lock()
+	r.session.Result = "ok"
+	if result != nil {
+		r.session.Result = result.Error()
+	}
+	data, err := json.MarshalIndent(&r.session, "", "  ")
+	r.mu.Unlock()
+	if err != nil {
+		return err
+	}
+	return os.WriteFile(path, append(data, '\n'), 0o644)
+}
+
+// since must be called with mu held
+func (r *Recorder) since() time.Duration {
+	return time.Since(r.session.Started)
+}
+
+type recordTransport struct {
+	rec  *Recorder
+	base http.RoundTripper
+}
+
+func (t *recordTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	r := t.rec
+	r.mu.Lock()
+	ex := &Exchange{
+		At:      r.since(),
+		Method:  req.Method,
+		URL:     req.URL.Redacted(),
+		Request: redact(req.Header),
+	}
+	r.session.Exchanges = append(r.session.Exchanges, ex)
+	r.mu.Unlock()
+
+	resp, err := t.base.RoundTrip(req)
+
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	ex.HeadersDone = r.since() - ex.At
+	if err != nil {
+		ex.Error = err.Error()
+		return nil, err
+	}
+	ex.Status = resp.StatusCode
+	ex.Header = redact(resp.Header)
+	resp.Body = &recordBody{rc: resp.Body, rec: r, ex: ex, sum: sha256.New()}
+	return resp, nil
+}
+
+// recordBody notes what the engine read of a response body and how the
+// body ended
+type recordBody struct {
+	rc   io.ReadCloser
+	rec  *Recorder
+	ex   *Exchange
+	sum  hash.Hash
+	done bool
+}
+
+func (b *recordBody) Read(p []byte) (int, error) {
+	n, err := b.rc.Read(p)
+	r := b.rec
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if b.done {
+		return n, err
+	}
+	b.sum.Write(p[:n])
+	kept := p[:n]
+	if room := r.keep - int64(len(b.ex.Body)); r.keep >= 0 && int64(len(kept)) > room {
+		kept = kept[:max(room, 0)]
+	}
+	b.ex.Body = append(b.ex.Body, kept...)
+	b.ex.BodySize += int64(n)
+	switch {
+	case err == io.EOF:
+		b.finish(endEOF, "")
+	case errors.Is(err, context.Canceled):
+		// The engine gave up on it, stalled or stopping
+		b.finish(endClosed, "")
+	case err != nil:
+		b.finish(endError, err.Error())
+	}
+	return n, err
+}
+
+func (b *recordBody) Close() error {
+	r := b.rec
+	r.mu.Lock()
+	if !b.done {
+		b.finish(endClosed, "")
+	}
+	r.mu.Unlock()
+	return b.rc.Close()
+}
+
+// finish must be called with the recorder's mu held
+func (b *recordBody) finish(end, msg string) {
+	b.done = true
+	b.ex.BodyEnd = end
+	b.ex.Error = msg
+	b.ex.BodyDone = b.rec.since() - b.ex.At
+	b.ex.BodySHA256 = hex.EncodeToString(b.sum.Sum(nil))
+}
+
+func redactURL(raw string) string {
+	if u, err := url.Parse(raw); err == nil {
+		return u.Redacted()
+	}
+	return raw
+}
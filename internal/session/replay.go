@@ -0,0 +1,257 @@
+package session
+
+import (
+	"context"
+	"fmt"
+	"net"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Server answers requests the way the recorded servers did: the same
+// statuses and headers, after the same delays, with bodies that break off
+// or stall where the recorded ones did. All hosts of the recording are
+// served from one local address, see Rewrite.
+type Server struct {
+	URL string // Base URL of the server
+
+	timing bool
+	srv    *http.Server
+
+	mu        sync.Mutex
+	pending   map[string][]*Exchange // By request, in recorded order
+	byURL     map[string]*Exchange   // Last exchange per method and URL
+	unmatched int
+}
+
+// NewServer starts serving the recording on a local port. Without timing
+// it answers right away and sends bodies at full speed.
+func NewServer(s *Session, timing bool) (*Server, error) {
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		return nil, err
+	}
+	srv := &Server{
+		URL:     "http://" + ln.Addr().String(),
+		timing:  timing,
+		pending: map[string][]*Exchange{},
+		byURL:   map[string]*Exchange{},
+	}
+	for _, ex := range s.Exchanges {
+		k := requestKey(ex.Method, ex.URL, ex.Request.Get("Range"))
+		srv.pending[k] = append(srv.pending[k], ex)
+		srv.byURL[requestKey(ex.Method, ex.URL, "")] = ex
+	}
+	srv.srv = &http.Server{Handler: srv}
+	go srv.srv.Serve(ln)
+	return srv, nil
+}
+
+// Close stops the server
+func (s *Server) Close() error {
+	return s.srv.Close()
+}
+
+// Unmatched counts the requests the recording had no exact match for.
+// They got a made up answer, so the replay went its own way from there.
+func (s *Server) Unmatched() int {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.unmatched
+}
+
+// Rewrite maps a recorded URL to the server, as /<scheme>/<host>/<path>
+func (s *Server) Rewrite(raw string) string {
+	u, err := url.Parse(raw)
+	if err != nil || u.Host == "" {
+		return raw
+	}
+	out := s.URL + "/" + u.Scheme + "/" + u.Host + u.EscapedPath()
+	if u.RawQuery != "" {
+		out += "?" + u.RawQuery
+	}
+	return out
+}
+
+func (s *Server) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	scheme, rest, _ := strings.Cut(strings.TrimPrefix(r.URL.EscapedPath(), "/"), "/")
+	host, path, _ := strings.Cut(rest, "/")
+	orig := scheme + "://" + host + "/" + path
+	if r.URL.RawQuery != "" {
+		orig += "?" + r.URL.RawQuery
+	}
+
+	ex := s.match(r.Method, orig, r.Header.Get("Range"))
+	if ex == nil {
+		http.Error(w, "not in the recording", http.StatusNotFound)
+		return
+	}
+	s.answer(w, r, ex)
+}
+
+// match finds the recorded answer to a request. Repeats of the same request
+// get the recorded answers in order, then the last one again.
+func (s *Server) match(method, rawURL, rng string) *Exchange {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	k := requestKey(method, rawURL, rng)
+	if q := s.pending[k]; len(q) > 0 {
+		if len(q) > 1 {
+			s.pending[k] = q[1:]
+		}
+		return q[0]
+	}
+	s.unmatched++
+	like := s.byURL[requestKey(method, rawURL, "")]
+	if like == nil {
+		return nil
+	}
+	return improvise(like, rng)
+}
+
+// improvise answers a range the recording doesn't have from another
+// exchange with the same URL: its headers, the range asked for, no delays
+// and no faults
+func improvise(like *Exchange, rng string) *Exchange {
+	total := int64(-1)
+	if _, t, ok := strings.Cut(like.Header.Get("Content-Range"), "/"); ok {
+		total, _ = strconv.ParseInt(t, 10, 64)
+	} else if like.Status == http.StatusOK {
+		total, _ = strconv.ParseInt(like.Header.Get("Content-Length"), 10, 64)
+	}
+	spec, ok := strings.CutPrefix(rng, "bytes=")
+	from, to, _ := strings.Cut(spec, "-")
+	start, err1 := strconv.ParseInt(from, 10, 64)
+	end, err2 := strconv.ParseInt(to, 10, 64)
+	if to == "" {
+		end, err2 = total-1, nil
+	}
+	if !ok || total <= 0 || err1 != nil || err2 != nil || start > end || end >= total {
+		return like
+	}
+
+	h := like.Header.Clone()
+	h.Set("Content-Range", fmt.Sprintf("bytes %d-%d/%d", start, end, total))
+	h.Set("Content-Length", strconv.FormatInt(end-start+1, 10))
+	return &Exchange{
+		Method:   like.Method,
+		URL:      like.URL,
+		Status:   http.StatusPartialContent,
+		Header:   h,
+		BodySize: end - start + 1,
+		BodyEnd:  endEOF,
+	}
+}
+
+func (s *Server) answer(w http.ResponseWriter, r *http.Request, ex *Exchange) {
+	ctx := r.Context()
+	if !s.wait(ctx, time.Now().Add(ex.HeadersDone)) {
+		return
+	}
+	if ex.Status == 0 {
+		// The request failed, drop the connection
+		panic(http.ErrAbortHandler)
+	}
+	h := w.Header()
+	for name, values := range ex.Header {
+		h[name] = values
+	}
+	if loc := h.Get("Location"); loc != "" {
+		h.Set("Location", s.rewriteRef(ex.URL, loc))
+	}
+	w.WriteHeader(ex.Status)
+	if r.Method == http.MethodHead || ex.Status == http.StatusNoContent || ex.Status == http.StatusNotModified {
+		return
+	}
+
+	// The recorded bytes, then stand-ins that are the same for every range
+	// covering an offset
+	offset := int64(0)
+	if cr := ex.Header.Get("Content-Range"); cr != "" {
+		fmt.Sscanf(cr, "bytes %d-", &offset)
+	}
+	start := time.Now()
+	span := ex.BodyDone - ex.HeadersDone
+	buf := make([]byte, 32<<10)
+	for sent := int64(0); sent < ex.BodySize; {
+		n := min(int64(len(buf)), ex.BodySize-sent)
+		for i := int64(0); i < n; i++ {
+			if at := sent + i; at < int64(len(ex.Body)) {
+				buf[i] = ex.Body[at]
+			} else {
+				buf[i] = filler(offset + at)
+			}
+		}
+		if _, err := w.Write(buf[:n]); err != nil {
+			return
+		}
+		if f, ok := w.(http.Flusher); ok {
+			f.Flush()
+		}
+		sent += n
+		if span > 0 && !s.wait(ctx, start.Add(time.Duration(float64(span)*float64(sent)/float64(ex.BodySize)))) {
+			return
+		}
+	}
+
+	switch ex.BodyEnd {
+	case endEOF:
+	case endError:
+		// The connection broke off here
+		panic(http.ErrAbortHandler)
+	default:
+		// The engine stopped reading, went quiet on it or stopped
+		// altogether. Stay quiet for as long as it waits.
+		if length, err := strconv.ParseInt(ex.Header.Get("Content-Length"), 10, 64); err != nil || ex.BodySize < length {
+			<-ctx.Done()
+		}
+	}
+}
+
+// wait sleeps until t when replaying the timing, false when the client went
+// away first
+func (s *Server) wait(ctx context.Context, t time.Time) bool {
+	d := time.Until(t)
+	if !s.timing || d <= 0 {
+		return ctx.Err() == nil
+	}
+	timer := time.NewTimer(d)
+	defer timer.Stop()
+	select {
+	case <-timer.C:
+		return true
+	case <-ctx.Done():
+		return false
+	}
+}
+
+// rewriteRef maps a Location relative to the recorded URL base to the server
+func (s *Server) rewriteRef(base, ref string) string {
+	b, err := url.Parse(base)
+	if err != nil {
+		return ref
+	}
+	u, err := b.Parse(ref)
+	if err != nil {
+		return ref
+	}
+	return s.Rewrite(u.String())
+}
+
+// requestKey identifies a request regardless of credentials in the URL
+func requestKey(method, rawURL, rng string) string {
+	if u, err := url.Parse(rawURL); err == nil {
+		u.User = nil
+		rawURL = u.String()
+	}
+	return method + " " + rawURL + " " + rng
+}
+
+// filler stands in for a body byte that wasn't recorded
+func filler(offset int64) byte {
+	return byte(offset % 251)
+}
@@ -0,0 +1,162 @@
+// Package session records what happens during a download, the HTTP
+// exchanges with their timing and the engine's decisions, and replays a
+// recording against a local server that answers the same way. An
+// intermittent segmentation or retry bug then reproduces on a maintainer's
+// machine from the file attached to the bug report.
+package session
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"os"
+	"time"
+
+	"warp-dl/internal/downloader"
+)
+
+// Version is the format of the recordings this build writes and reads
+const Version = 1
+
+// Headers that carry credentials, recorded as redacted
+var secretHeaders = []string{"Authorization", "Proxy-Authorization", "Cookie", "Set-Cookie"}
+
+// Session is a recorded download
+type Session struct {
+	Version   int         `json:"version"`
+	URL       string      `json:"url"` // Without credentials
+	Started   time.Time   `json:"started"`
+	Settings  Settings    `json:"settings"`
+	Exchanges []*Exchange `json:"exchanges"`
+	Events    []Event     `json:"events"`
+	Result    string      `json:"result"` // "ok" or the error the download ended with
+}
+
+// Exchange is one request and the response to it. Times are offsets from
+// the start of the session.
+type Exchange struct {
+	At      time.Duration `json:"at"`
+	Method  string        `json:"method"`
+	URL     string        `json:"url"`
+	Request http.Header   `json:"request_header,omitempty"`
+
+	Status      int           `json:"status,omitempty"` // 0 when the request failed
+	Header      http.Header   `json:"header,omitempty"`
+	HeadersDone time.Duration `json:"headers_done,omitempty"`
+	Error       string        `json:"error,omitempty"` // Why the request failed or the body broke off
+
+	BodySize   int64         `json:"body_size"` // Bytes the engine read
+	BodyDone   time.Duration `json:"body_done,omitempty"`
+	BodyEnd    string        `json:"body_end,omitempty"` // eof, closed by the engine or error
+	BodySHA256 string        `json:"body_sha256,omitempty"`
+	Body       []byte        `json:"body,omitempty"` // The first bytes, see NewRecorder
+}
+
+// How a response body ended
+const (
+	endEOF    = "eof"
+	endClosed = "closed"
+	endError  = "error"
+)
+
+// Event is a decision of the engine, see downloader.Config.Trace
+type Event struct {
+	At  time.Duration `json:"at"`
+	Msg string        `json:"msg"`
+}
+
+// Settings are the options that shape the engine's requests. A replay runs
+// with the same ones.
+type Settings struct {
+	Concurrency     int                     `json:"concurrency"`
+	AutoConcurrency bool                    `json:"auto_concurrency,omitempty"`
+	MinSplitSize    int64                   `json:"min_split_size,omitempty"`
+	MaxInFlight     int64                   `json:"max_inflight,omitempty"`
+	Mirrors         []string                `json:"mirrors,omitempty"`
+	Range           string                  `json:"range,omitempty"`
+	Method          string                  `json:"method,omitempty"`
+	Body            []byte                  `json:"body,omitempty"`
+	Headers         http.Header             `json:"headers,omitempty"`
+	Retry           *downloader.RetryPolicy `json:"retry,omitempty"`
+	StallTimeout    time.Duration           `json:"stall_timeout,omitempty"`
+	Paranoid        bool                    `json:"paranoid,omitempty"`
+	Compressed      bool                    `json:"compressed,omitempty"`
+}
+
+// SettingsOf picks the recorded settings out of a download's config
+func SettingsOf(cfg downloader.Config) Settings {
+	s := Settings{
+		Concurrency:     cfg.Concurrency,
+		AutoConcurrency: cfg.AutoConcurrency,
+		MinSplitSize:    cfg.MinSplitSize,
+		MaxInFlight:     cfg.MaxInFlight,
+		Method:          cfg.Method,
+		Body:            cfg.Body,
+		Headers:         redact(cfg.Headers),
+		Retry:           cfg.Retry,
+		StallTimeout:    cfg.StallTimeout,
+		Paranoid:        cfg.Paranoid,
+		Compressed:      cfg.Compressed,
+	}
+	for _, m := range cfg.Mirrors {
+		s.Mirrors = append(s.Mirrors, redactURL(m))
+	}
+	if cfg.Range != nil {
+		s.Range = cfg.Range.String()
+	}
+	return s
+}
+
+// Apply sets the recorded settings on cfg
+func (s Settings) Apply(cfg *downloader.Config) error {
+	cfg.Concurrency = s.Concurrency
+	cfg.AutoConcurrency = s.AutoConcurrency
+	cfg.MinSplitSize = s.MinSplitSize
+	cfg.MaxInFlight = s.MaxInFlight
+	cfg.Mirrors = s.Mirrors
+	cfg.Method = s.Method
+	cfg.Body = s.Body
+	cfg.Headers = s.Headers
+	cfg.Retry = s.Retry
+	cfg.StallTimeout = s.StallTimeout
+	cfg.Paranoid = s.Paranoid
+	cfg.Compressed = s.Compressed
+	if s.Range != "" {
+		r, err := downloader.ParseByteRange(s.Range)
+		if err != nil {
+			return err
+		}
+		cfg.Range = r
+	}
+	return nil
+}
+
+// Load reads a recording
+func Load(path string) (*Session, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	var s Session
+	if err := json.Unmarshal(data, &s); err != nil {
+		return nil, fmt.Errorf("%s: %w", path, err)
+	}
+	if s.Version != Version {
+		return nil, fmt.Errorf("%s: recording format %d, this build reads %d", path, s.Version, Version)
+	}
+	return &s, nil
+}
+
+// redact copies h with the credentials blanked out
+func redact(h http.Header) http.Header {
+	if h == nil {
+		return nil
+	}
+	h = h.Clone()
+	for _, name := range secretHeaders {
+		if _, ok := h[name]; ok {
+			h[name] = []string{"redacted"}
+		}
+	}
+	return h
+}
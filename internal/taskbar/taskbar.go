@@ -0,0 +1,33 @@
+// Package taskbar shows download progress in the desktop shell, the way
+// browsers do: as a progress bar over the taskbar button on Windows and the
+// launcher or dock icon on Linux desktops.
+package taskbar
+
+import "errors"
+
+// Progress is the progress indicator of one running download
+type Progress interface {
+	// Set shows done out of total bytes, total is 0 when unknown
+	Set(done, total int64)
+	// Close removes the indicator
+	Close()
+}
+
+var errUnsupported = errors.New("not supported on this platform")
+
+// Open connects to the desktop shell. It fails without a desktop session,
+// e.g. over SSH, and the download should then carry on without it.
+func Open() (Progress, error) {
+	return open()
+}
+
+// fraction is done/total clamped to [0, 1], 0 while the size is unknown
+func fraction(done, total int64) float64 {
+	if total <= 0 || done <= 0 {
+		return 0
+	}
+	if done >= total {
+		return 1
+	}
+	return float64(done) / float64(total)
+}
@@ -0,0 +1,7 @@
+//go:build !linux && !windows
+
+package taskbar
+
+func open() (Progress, error) {
+	return nil, errUnsupported
+}
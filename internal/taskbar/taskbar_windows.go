@@ -0,0 +1,133 @@
+package taskbar
+
+import (
+	"errors"
+	"fmt"
+	"runtime"
+	"syscall"
+	"unsafe"
+
+	"golang.org/x/sys/windows"
+)
+
+var (
+	ole32                = windows.NewLazySystemDLL("ole32.dll")
+	procCoCreateInstance = ole32.NewProc("CoCreateInstance")
+	kernel32             = windows.NewLazySystemDLL("kernel32.dll")
+	procGetConsoleWindow = kernel32.NewProc("GetConsoleWindow")
+)
+
+var (
+	clsidTaskbarList = windows.GUID{Data1: 0x56fdf344, Data2: 0xfd6d, Data3: 0x11d0, Data4: [8]byte{0x95, 0x8a, 0x00, 0x60, 0x97, 0xc9, 0xa0, 0x90}}
+	iidTaskbarList3  = windows.GUID{Data1: 0xea1afb91, Data2: 0x9e28, Data3: 0x4b86, Data4: [8]byte{0x90, 0xe9, 0x9e, 0x9f, 0x8a, 0x5e, 0xef, 0xaf}}
+)
+
+const (
+	clsctxInprocServer = 0x1
+	sFalse             = 1 // CoInitializeEx: already initialized on this thread
+
+	tbpfNoProgress    = 0x0
+	tbpfIndeterminate = 0x1
+
+	// ITaskbarList3 vtable slots, after IUnknown, ITaskbarList and
+	// ITaskbarList2
+	slotRelease          = 2
+	slotHrInit           = 3
+	slotSetProgressValue = 9
+	slotSetProgressState = 10
+
+	// Progress is passed in steps of a thousandth, which keeps the values
+	// in 32 bits
+	steps = 1000
+)
+
+// taskbarList drives ITaskbarList3 for the console window. The COM object
+// belongs to the thread that created it, so one locked goroutine owns it
+// and Set hands it the latest values.
+type taskbarList struct {
+	updates chan [2]int64
+	done    chan struct{}
+}
+
+func open() (Progress, error) {
+	hwnd, _, _ := procGetConsoleWindow.Call()
+	if hwnd == 0 {
+		return nil, errors.New("no console window")
+	}
+	t := &taskbarList{updates: make(chan [2]int64, 1), done: make(chan struct{})}
+	ready := make(chan error, 1)
+	go t.run(hwnd, ready)
+	if err := <-ready; err != nil {
+		return nil, err
+	}
+	return t, nil
+}
+
+func (t *taskbarList) Set(done, total int64) {
+	// Replace a value the owner hasn't picked up yet
+	select {
+	case <-t.updates:
+	default:
+	}
+	t.updates <- [2]int64{done, total}
+}
+
+func (t *taskbarList) Close() {
+	close(t.updates)
+	<-t.done
+}
+
+func (t *taskbarList) run(hwnd uintptr, ready chan<- error) {
+	runtime.LockOSThread()
+	defer runtime.UnlockOSThread()
+	defer close(t.done)
+
+	if err := windows.CoInitializeEx(0, windows.COINIT_APARTMENTTHREADED); err != nil && err != syscall.Errno(sFalse) {
+		ready <- fmt.Errorf("initializing COM: %w", err)
+		return
+	}
+	defer windows.CoUninitialize()
+
+	var list *comObject
+	r, _, _ := procCoCreateInstance.Call(
+		uintptr(unsafe.Pointer(&clsidTaskbarList)), 0, clsctxInprocServer,
+		uintptr(unsafe.Pointer(&iidTaskbarList3)), uintptr(unsafe.Pointer(&list)))
+	if r != 0 {
+		ready <- fmt.Errorf("creating the taskbar list: %w", syscall.Errno(r))
+		return
+	}
+	defer list.call(slotRelease)
+	if r := list.call(slotHrInit); r != 0 {
+		ready <- fmt.Errorf("initializing the taskbar list: %w", syscall.Errno(r))
+		return
+	}
+	ready <- nil
+
+	for u := range t.updates {
+		if u[1] <= 0 {
+			list.call(slotSetProgressState, hwnd, tbpfIndeterminate)
+			continue
+		}
+		args := append([]uintptr{hwnd}, ulonglong(uint32(fraction(u[0], u[1])*steps))...)
+		list.call(slotSetProgressValue, append(args, ulonglong(steps)...)...)
+	}
+	list.call(slotSetProgressState, hwnd, tbpfNoProgress)
+}
+
+type comObject struct {
+	vtbl *[16]uintptr
+}
+
+func (o *comObject) call(slot int, args ...uintptr) uintptr {
+	r, _, _ := syscall.SyscallN(o.vtbl[slot], append([]uintptr{uintptr(unsafe.Pointer(o))}, args...)...)
+	return r
+}
+
+// ulonglong passes a 64-bit argument, which takes two slots on 32-bit
+// Windows
+func ulonglong(v uint32) []uintptr {
+	if unsafe.Sizeof(uintptr(0)) == 8 {
+		return []uintptr{uintptr(v)}
+	}
+	return []uintptr{uintptr(v), 0}
+}
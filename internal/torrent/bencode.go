@@ -0,0 +1,174 @@
+package torrent
+
+import (
+	"bytes"
+	"fmt"
+	"sort"
+	"strconv"
+)
+
+// Decoded bencode values are int64, string, []interface{} and
+// map[string]interface{}
+
+type decoder struct {
+	data []byte
+	pos  int
+
+	// Byte span of the top level "info" dictionary, needed for the info hash
+	infoStart, infoEnd int
+}
+
+func bdecode(data []byte) (interface{}, error) {
+	d := &decoder{data: data}
+	v, err := d.value(0)
+	if err != nil {
+		return nil, err
+	}
+	return v, nil
+}
+
+func (d *decoder) value(depth int) (interface{}, error) {
+	if d.pos >= len(d.data) {
+		return nil, fmt.Errorf("bencode: unexpected end of data")
+	}
+	if depth > 64 {
+		return nil, fmt.Errorf("bencode: nesting too deep")
+	}
+
+	switch c := d.data[d.pos]; {
+	case c == 'i':
+		end := bytes.IndexByte(d.data[d.pos:], 'e')
+		if end < 0 {
+			return nil, fmt.Errorf("bencode: unterminated integer")
+		}
+		n, err := strconv.ParseInt(string(d.data[d.pos+1:d.pos+end]), 10, 64)
+		if err != nil {
+			return nil, fmt.Errorf("bencode: invalid integer: %w", err)
+		}
+		d.pos += end + 1
+		return n, nil
+
+	case c == 'l':
+		d.pos++
+		list := []interface{}{}
+		for d.pos < len(d.data) && d.data[d.pos] != 'e' {
+			v, err := d.value(depth + 1)
+			if err != nil {
+				return nil, err
+			}
+			list = append(list, v)
+		}
+		if d.pos >= len(d.data) {
+			return nil, fmt.Errorf("bencode: unterminated list")
+		}
+		d.pos++
+		return list, nil
+
+	case c == 'd':
+		d.pos++
+		dict := map[string]interface{}{}
+		for d.pos < len(d.data) && d.data[d.pos] != 'e' {
+			key, err := d.str()
+			if err != nil {
+				return nil, err
+			}
+			start := d.pos
+			v, err := d.value(depth + 1)
+			if err != nil {
+				return nil, err
+			}
+			if depth == 0 && key == "info" {
+				d.infoStart, d.infoEnd = start, d.pos
+			}
+			dict[key] = v
+		}
+		if d.pos >= len(d.data) {
+			return nil, fmt.Errorf("bencode: unterminated dictionary")
+		}
+		d.pos++
+		return dict, nil
+
+	case c >= '0' && c <= '9':
+		return d.str()
+	}
+	return nil, fmt.Errorf("bencode: invalid byte %q at %d", d.data[d.pos], d.pos)
+}
+
+func (d *decoder) str() (string, error) {
+	colon := bytes.IndexByte(d.data[d.pos:], ':')
+	if colon < 0 {
+		return "", fmt.Errorf("bencode: invalid string")
+	}
+	n, err := strconv.Atoi(string(d.data[d.pos : d.pos+colon]))
+	if err != nil || n < 0 {
+		return "", fmt.Errorf("bencode: invalid string length")
+	}
+	start := d.pos + colon + 1
+	if n > len(d.data)-start {
+		return "", fmt.Errorf("bencode: string exceeds data")
+	}
+	d.pos = start + n
+	return string(d.data[start:d.pos]), nil
+}
+
+func bencode(v interface{}) []byte {
+	var buf bytes.Buffer
+	encodeTo(&buf, v)
+	return buf.Bytes()
+}
+
+func encodeTo(buf *bytes.Buffer, v interface{}) {
+	switch v := v.(type) {
+	case int:
+		fmt.Fprintf(buf, "i%de", v)
+	case int64:
+		fmt.Fprintf(buf, "i%de", v)
+	case string:
+		fmt.Fprintf(buf, "%d:%s", len(v), v)
+	case []byte:
+		fmt.Fprintf(buf, "%d:", len(v))
+		buf.Write(v)
+	case []interface{}:
+		buf.WriteByte('l')
+		for _, item := range v {
+			encodeTo(buf, item)
+		}
+		buf.WriteByte('e')
+	case map[string]interface{}:
+		keys := make([]string, 0, len(v))
+		for k := range v {
+			keys = append(keys, k)
+		}
+		sort.Strings(keys)
+		buf.WriteByte('d')
+		for _, k := range keys {
+			encodeTo(buf, k)
+			encodeTo(buf, v[k])
+		}
+		buf.WriteByte('e')
+	default:
+		panic(fmt.Sprintf("bencode: unsupported type %T", v))
+	}
+}
+
+// Typed accessors for decoded dictionaries
+
+func dictString(d map[string]interface{}, key string) string {
+	s, _ := d[key].(string)
+	return s
+}
+
+func dictInt(d map[string]interface{}, key string) int64 {
+	n, _ := d[key].(int64)
+	return n
+}
+
+func dictDict(d map[string]interface{}, key string) map[string]interface{} {
+	m, _ := d[key].(map[string]interface{})
+	return m
+}
+
+func dictList(d map[string]interface{}, key string) []interface{} {
+	l, _ := d[key].([]interface{})
+	return l
+}
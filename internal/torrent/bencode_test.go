@@ -0,0 +1,130 @@
+package torrent
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestBdecode(t *testing.T) {
+	tests := []struct {
+		in   string
+		want interface{}
+	}{
+		{"i42e", int64(42)},
+		{"i-7e", int64(-7)},
+		{"i0e", int64(0)},
+		{"0:", ""},
+		{"4:spam", "spam"},
+		{"3:a:b", "a:b"},
+		{"le", []interface{}{}},
+		{"l4:spami3ee", []interface{}{"spam", int64(3)}},
+		{"de", map[string]interface{}{}},
+		{"d3:cow3:moo4:spaml1:a1:bee", map[string]interface{}{"cow": "moo", "spam": []interface{}{"a", "b"}}},
+		{"d1:xd1:yi1eee", map[string]interface{}{"x": map[string]interface{}{"y": int64(1)}}},
+	}
+	for _, tt := range tests {
+		got, err := bdecode([]byte(tt.in))
+		if err != nil {
+			t.Errorf("bdecode(%q): %v", tt.in, err)
+			continue
+		}
+		if !reflect.DeepEqual(got, tt.want) {
+			t.Errorf("bdecode(%q) = %#v, want %#v", tt.in, got, tt.want)
+		}
+	}
+}
+
+func TestBdecodeInvalid(t *testing.T) {
+	for _, in := range []string{
+		"",
+		"i",
+		"i42",
+		"ie",
+		"iabce",
+		"5:abc",
+		"-1:",
+		"9223372036854775807:x",
+		"l",
+		"li1e",
+		"d",
+		"d3:key",
+		"di1ei2ee", // Keys are strings
+		"x",
+		"l" + nest(70) + "e",
+	} {
+		if v, err := bdecode([]byte(in)); err == nil {
+			t.Errorf("bdecode(%q) = %#v, want an error", in, v)
+		}
+	}
+}
+
+func nest(n int) string {
+	s := ""
+	for i := 0; i < n; i++ {
+		s += "l"
+	}
+	for i := 0; i < n; i++ {
+		s += "e"
+	}
+	return s
+}
+
+func TestBencode(t *testing.T) {
+	tests := []struct {
+		in   interface{}
+		want string
+	}{
+		{42, "i42e"},
+		{int64(-1), "i-1e"},
+		{"spam", "4:spam"},
+		{[]byte{0, 1}, "2:\x00\x01"},
+		{[]interface{}{"a", 1}, "l1:ai1ee"},
+		// Keys are sorted, as the info hash depends on it
+		{map[string]interface{}{"z": 1, "a": "x", "m": []interface{}{}}, "d1:a1:x1:mle1:zi1ee"},
+	}
+	for _, tt := range tests {
+		if got := string(bencode(tt.in)); got != tt.want {
+			t.Errorf("bencode(%#v) = %q, want %q", tt.in, got, tt.want)
+		}
+	}
+}
+
+func TestDecoderInfoSpan(t *testing.T) {
+	data := []byte("d8:announce3:url4:infod4:name1:xe5:otheri1ee")
+	d := &decoder{data: data}
+	if _, err := d.value(0); err != nil {
+		t.Fatal(err)
+	}
+	if got := string(data[d.infoStart:d.infoEnd]); got != "d4:name1:xe" {
+		t.Errorf("info span = %q", got)
+	}
+
+	// Only the top level info dictionary counts
+	d = &decoder{data: []byte("d1:ad4:infod1:bi1eeee")}
+	if _, err := d.value(0); err != nil {
+		t.Fatal(err)
+	}
+	if d.infoEnd != 0 {
+		t.Errorf("nested info dictionary was taken for the top level one")
+	}
+}
+
+func FuzzBdecode(f *testing.F) {
+	for _, seed := range []string{"i42e", "4:spam", "l4:spami3ee", "d3:cow3:moo4:spaml1:a1:bee", "d4:infod4:name1:xee"} {
+		f.Add([]byte(seed))
+	}
+	f.Fuzz(func(t *testing.T, data []byte) {
+		v, err := bdecode(data)
+		if err != nil {
+			return
+		}
+		// Whatever decodes encodes again, and to the same value
+		again, err := bdecode(bencode(v))
+		if err != nil {
+			t.Fatalf("re-decoding %q: %v", bencode(v), err)
+		}
+		if !reflect.DeepEqual(v, again) {
+			t.Fatalf("round trip changed %#v to %#v", v, again)
+		}
+	})
+}
@@ -0,0 +1,952 @@
+package torrent
+
+import (
+	"bytes"
+	"context"
+	"crypto/rand"
+	"crypto/sha1"
+	"encoding/binary"
+	"fmt"
+	"io"
+	mrand "math/rand"
+	"net"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"warp-dl/internal/downloader"
+)
+
+const (
+	// Outstanding block requests per peer
+	maxBacklog = 10
+
+	// A peer that delivers nothing for this long loses its piece
+	snubTimeout = time.Minute
+
+	// Consecutive bad pieces before a peer is dropped
+	maxBadPieces = 3
+
+	metadataPieceSize = 16 * 1024
+)
+
+// Config holds the torrent specific settings
+type Config struct {
+	Source     string // Magnet link, .torrent path or .torrent URL
+	OutputName string // File (single-file) or directory (multi-file), defaults to the torrent name
+	Dir        string // Directory for the default name
+	MaxPeers   int
+	Sequential bool    // Download pieces in order instead of rarest first
+	SeedRatio  float64 // Keep seeding until uploaded/size reaches this, 0 stops at completion
+	Port       int     // Listen port for incoming peers
+}
+
+// Downloader fetches a torrent from its swarm
+type Downloader struct {
+	Config Config
+	Stats  *downloader.Stats
+	Client *http.Client // For trackers and remote .torrent files
+
+	meta     *MetaInfo
+	peerID   [20]byte
+	port     int
+	uploaded atomic.Int64 // Aligned on 32-bit platforms, unlike a plain int64 field
+
+	mu        sync.Mutex
+	ready     chan struct{} // Closed once metadata and storage are set up
+	fatal     chan error
+	complete  chan struct{} // Closed once every piece is verified
+	info      *Info
+	metadata  []byte // Raw info dictionary
+	store     *storage
+	have      bitfield
+	haveCount int
+	pending   map[int]int // Piece index -> peers working on it
+	peers     map[*peerState]struct{}
+	known     map[string]bool
+	dialing   int
+
+	// ut_metadata download state for magnet links
+	metaSize   int
+	metaPieces [][]byte
+	metaDone   bool
+
+	wg sync.WaitGroup
+}
+
+type peerState struct {
+	*peerConn
+
+	bits           bitfield // Guarded by Downloader.mu
+	peerChoking    bool
+	peerInterested bool
+	amChoking      bool
+	amInterested   bool
+
+	work      *pieceWork
+	lastBlock time.Time
+	badPieces int
+	metaAsked time.Time
+}
+
+type pieceWork struct {
+	index     int
+	buf       []byte
+	requested int64 // Next offset to request
+	received  int64
+	blocks    []bool
+}
+
+func NewDownloader(cfg Config, client *http.Client) *Downloader {
+	if cfg.MaxPeers <= 0 {
+		cfg.MaxPeers = 30
+	}
+	if cfg.Port <= 0 {
+		cfg.Port = 6881
+	}
+	d := &Downloader{
+		Config:   cfg,
+		Stats:    &downloader.Stats{},
+		Client:   client,
+		ready:    make(chan struct{}),
+		complete: make(chan struct{}),
+		fatal:    make(chan error, 1),
+		pending:  make(map[int]int),
+		peers:    make(map[*peerState]struct{}),
+		known:    make(map[string]bool),
+	}
+	copy(d.peerID[:], "-WD0100-")
+	rand.Read(d.peerID[8:])
+	return d
+}
+
+func (d *Downloader) Progress() *downloader.Stats {
+	return d.Stats
+}
+
+func (d *Downloader) Start(ctx context.Context) error {
+	meta, err := d.loadMeta(ctx)
+	if err != nil {
+		return err
+	}
+	d.meta = meta
+	if len(meta.Trackers) == 0 {
+		return fmt.Errorf("torrent has no trackers (DHT is not supported)")
+	}
+
+	ctx, cancel := context.WithCancel(ctx)
+	defer func() {
+		cancel()
+		d.wg.Wait()
+		d.mu.Lock()
+		if d.store != nil {
+			d.store.close()
+		}
+		d.mu.Unlock()
+	}()
+
+	if meta.Info != nil {
+		if err := d.prepare(meta.Info, meta.raw); err != nil {
+			return err
+		}
+	}
+
+	ln, err := net.Listen("tcp", fmt.Sprintf(":%d", d.Config.Port))
+	if err != nil {
+		// Port taken (another client?), any port still allows seeding
+		if ln, err = net.Listen("tcp", ":0"); err != nil {
+			return err
+		}
+	}
+	d.port = ln.Addr().(*net.TCPAddr).Port
+	d.wg.Add(1)
+	go d.acceptLoop(ctx, ln)
+
+	for _, tr := range meta.Trackers {
+		d.wg.Add(1)
+		go d.announceLoop(ctx, tr)
+	}
+
+	select {
+	case <-d.complete:
+	case err := <-d.fatal:
+		d.announceStopped()
+		return err
+	case <-ctx.Done():
+		d.announceStopped()
+		return ctx.Err()
+	}
+
+	if d.Config.SeedRatio > 0 {
+		if err := d.seed(ctx); err != nil {
+			d.announceStopped()
+			return err
+		}
+	}
+	d.announceStopped()
+	return nil
+}
+
+// loadMeta reads the .torrent file (local or remote) or parses the magnet link
+func (d *Downloader) loadMeta(ctx context.Context) (*MetaInfo, error) {
+	src := d.Config.Source
+	if strings.HasPrefix(src, "magnet:") {
+		return ParseMagnet(src)
+	}
+
+	var data []byte
+	var err error
+	if strings.HasPrefix(src, "http://") || strings.HasPrefix(src, "https://") {
+		req, rerr := http.NewRequestWithContext(ctx, "GET", src, nil)
+		if rerr != nil {
+			return nil, rerr
+		}
+		resp, rerr := d.Client.Do(req)
+		if rerr != nil {
+			return nil, rerr
+		}
+		defer resp.Body.Close()
+		if resp.StatusCode != http.StatusOK {
+			return nil, fmt.Errorf("failed to fetch torrent: %s", resp.Status)
+		}
+		data, err = io.ReadAll(io.LimitReader(resp.Body, 16<<20))
+	} else {
+		data, err = os.ReadFile(src)
+	}
+	if err != nil {
+		return nil, err
+	}
+	return ParseTorrent(data)
+}
+
+// prepare opens the storage for a known info dictionary and rechecks any
+// data already on disk, which is how interrupted downloads resume
+func (d *Downloader) prepare(info *Info, raw []byte) error {
+	root := d.Config.OutputName
+	if root == "" {
+		root = filepath.Join(d.Config.Dir, info.Name)
+		d.Config.OutputName = root
+	}
+	store, err := openStorage(root, info)
+	if err != nil {
+		return err
+	}
+
+	d.Stats.SetTotal(info.TotalLength())
+	have := newBitfield(len(info.Pieces))
+	count := 0
+	for i := range info.Pieces {
+		buf := make([]byte, info.pieceSize(i))
+		if err := store.readAt(buf, int64(i)*info.PieceLength); err != nil {
+			continue
+		}
+		if sha1.Sum(buf) == info.Pieces[i] {
+			have.set(i)
+			count++
+			d.Stats.AddDownloaded(int64(len(buf)))
+		}
+	}
+
+	d.mu.Lock()
+	d.info = info
+	d.metadata = raw
+	d.store = store
+	d.have = have
+	d.haveCount = count
+	d.metaPieces = nil
+	d.metaDone = true
+	d.mu.Unlock()
+
+	close(d.ready)
+	if count == len(info.Pieces) {
+		close(d.complete)
+	}
+	return nil
+}
+
+// seed keeps serving peers until the upload ratio is reached
+func (d *Downloader) seed(ctx context.Context) error {
+	target := int64(d.Config.SeedRatio * float64(d.Stats.GetTotal()))
+	ticker := time.NewTicker(time.Second)
+	defer ticker.Stop()
+	for d.uploaded.Load() < target {
+		select {
+		case <-ctx.Done():
+			return ctx.Err()
+		case <-ticker.C:
+		}
+	}
+	return nil
+}
+
+// Trackers
+
+func (d *Downloader) announceParams(event string) announceParams {
+	p := announceParams{
+		InfoHash: d.meta.InfoHash,
+		PeerID:   d.peerID,
+		Port:     d.port,
+		Uploaded: d.uploaded.Load(),
+		Event:    event,
+	}
+	done := d.Stats.GetDownloaded()
+	p.Downloaded = done
+	if total := d.Stats.GetTotal(); total > 0 {
+		p.Left = total - done
+	} else {
+		// Size unknown until the metadata arrives, anything non-zero
+		// marks us as a leecher
+		p.Left = 1
+	}
+	return p
+}
+
+func (d *Downloader) announceLoop(ctx context.Context, tracker string) {
+	defer d.wg.Done()
+
+	event := "started"
+	complete := d.complete
+	select {
+	case <-complete:
+		// Seeding from the start, there is no completion to report
+		complete = nil
+	default:
+	}
+	failures := 0
+	for {
+		actx, cancel := context.WithTimeout(ctx, 30*time.Second)
+		res, err := announce(actx, d.Client, tracker, d.announceParams(event))
+		cancel()
+
+		wait := 5 * time.Minute
+		if err != nil {
+			// Back off on unreachable trackers: 15s, 30s, 1m, ...
+			failures++
+			shift := failures
+			if shift > 5 {
+				shift = 5
+			}
+			wait = 15 * time.Second << (shift - 1)
+		} else {
+			failures = 0
+			event = ""
+			for _, addr := range res.Peers {
+				d.addPeer(ctx, addr)
+			}
+			if res.Interval > 0 && res.Interval < wait {
+				wait = res.Interval
+			}
+			if wait < 30*time.Second {
+				wait = 30 * time.Second
+			}
+		}
+
+		timer := time.NewTimer(wait)
+		select {
+		case <-ctx.Done():
+			timer.Stop()
+			return
+		case <-complete:
+			timer.Stop()
+			complete = nil
+			event = "completed"
+		case <-timer.C:
+		}
+	}
+}
+
+// announceStopped tells the trackers we are leaving, best effort
+func (d *Downloader) announceStopped() {
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	var wg sync.WaitGroup
+	for _, tr := range d.meta.Trackers {
+		wg.Add(1)
+		go func(tr string) {
+			defer wg.Done()
+			announce(ctx, d.Client, tr, d.announceParams("stopped"))
+		}(tr)
+	}
+	wg.Wait()
+}
+
+// Peers
+
+func (d *Downloader) addPeer(ctx context.Context, addr string) {
+	d.mu.Lock()
+	if d.known[addr] || len(d.peers)+d.dialing >= d.Config.MaxPeers {
+		d.mu.Unlock()
+		return
+	}
+	d.known[addr] = true
+	d.dialing++
+	d.mu.Unlock()
+
+	d.wg.Add(1)
+	go func() {
+		defer d.wg.Done()
+		dialer := net.Dialer{Timeout: 10 * time.Second}
+		conn, err := dialer.DialContext(ctx, "tcp", addr)
+
+		d.mu.Lock()
+		d.dialing--
+		d.mu.Unlock()
+		if err != nil {
+			d.forget(addr)
+			return
+		}
+		d.runPeer(ctx, conn, addr)
+	}()
+}
+
+// forget allows a later announce to retry the address
+func (d *Downloader) forget(addr string) {
+	d.mu.Lock()
+	delete(d.known, addr)
+	d.mu.Unlock()
+}
+
+func (d *Downloader) acceptLoop(ctx context.Context, ln net.Listener) {
+	defer d.wg.Done()
+	go func() {
+		<-ctx.Done()
+		ln.Close()
+	}()
+
+	for {
+		conn, err := ln.Accept()
+		if err != nil {
+			return
+		}
+		d.mu.Lock()
+		full := len(d.peers)+d.dialing >= d.Config.MaxPeers
+		d.mu.Unlock()
+		if full {
+			conn.Close()
+			continue
+		}
+		d.wg.Add(1)
+		go func() {
+			defer d.wg.Done()
+			d.runPeer(ctx, conn, "")
+		}()
+	}
+}
+
+type peerMessage struct {
+	id      byte
+	payload []byte
+}
+
+// runPeer drives one connection until it fails or the download ends
+func (d *Downloader) runPeer(ctx context.Context, conn net.Conn, addr string) {
+	defer conn.Close()
+	if addr != "" {
+		defer d.forget(addr)
+	}
+
+	pc, err := handshake(conn, d.meta.InfoHash, d.peerID)
+	if err != nil {
+		return
+	}
+	ps := &peerState{peerConn: pc, peerChoking: true, amChoking: true, lastBlock: time.Now()}
+
+	d.mu.Lock()
+	d.peers[ps] = struct{}{}
+	metaLen := len(d.metadata)
+	var have bitfield
+	if d.haveCount > 0 {
+		have = append(bitfield(nil), d.have...)
+	}
+	d.mu.Unlock()
+	defer d.dropPeer(ps)
+
+	if pc.supportsExt {
+		if err := pc.sendExtHandshake(metaLen, d.port); err != nil {
+			return
+		}
+	}
+	if have != nil {
+		if err := pc.writeMessage(msgBitfield, have); err != nil {
+			return
+		}
+	}
+
+	quit := make(chan struct{})
+	defer close(quit)
+	msgs := make(chan peerMessage, 16)
+	errc := make(chan error, 1)
+	go func() {
+		for {
+			id, payload, err := pc.readMessage()
+			if err != nil {
+				errc <- err
+				return
+			}
+			select {
+			case msgs <- peerMessage{id, payload}:
+			case <-quit:
+				return
+			}
+		}
+	}()
+
+	ticker := time.NewTicker(15 * time.Second)
+	defer ticker.Stop()
+	lastKeepAlive := time.Now()
+
+	// Peers that connect before the metadata is known switch over once
+	// the storage is ready
+	ready := d.ready
+	select {
+	case <-ready:
+		ready = nil
+	default:
+	}
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-errc:
+			return
+		case m := <-msgs:
+			if err := d.handleMessage(ps, m); err != nil {
+				return
+			}
+		case <-ready:
+			ready = nil
+			if err := d.onReady(ps); err != nil {
+				return
+			}
+		case <-ticker.C:
+			if time.Since(lastKeepAlive) > 90*time.Second {
+				if ps.keepAlive() != nil {
+					return
+				}
+				lastKeepAlive = time.Now()
+			}
+			if ps.work != nil && time.Since(ps.lastBlock) > snubTimeout {
+				return
+			}
+			if ready != nil {
+				d.requestMetadata(ps)
+			}
+		}
+
+		if ready == nil {
+			if err := d.fillRequests(ps); err != nil {
+				return
+			}
+		}
+	}
+}
+
+func (d *Downloader) dropPeer(ps *peerState) {
+	d.mu.Lock()
+	delete(d.peers, ps)
+	d.mu.Unlock()
+	d.release(ps)
+}
+
+// release gives the peer's unfinished piece back to the picker
+func (d *Downloader) release(ps *peerState) {
+	if ps.work == nil {
+		return
+	}
+	d.mu.Lock()
+	if d.pending[ps.work.index]--; d.pending[ps.work.index] <= 0 {
+		delete(d.pending, ps.work.index)
+	}
+	d.mu.Unlock()
+	ps.work = nil
+}
+
+// onReady announces our pieces to a peer that connected before we had them
+func (d *Downloader) onReady(ps *peerState) error {
+	d.mu.Lock()
+	var have []int
+	for i := range d.info.Pieces {
+		if d.have.has(i) {
+			have = append(have, i)
+		}
+	}
+	d.mu.Unlock()
+	for _, i := range have {
+		if err := ps.sendHave(i); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (d *Downloader) handleMessage(ps *peerState, m peerMessage) error {
+	switch m.id {
+	case msgChoke:
+		ps.peerChoking = true
+		// Pending requests are discarded by a choke
+		d.release(ps)
+	case msgUnchoke:
+		ps.peerChoking = false
+	case msgInterested:
+		ps.peerInterested = true
+		if ps.amChoking {
+			ps.amChoking = false
+			return ps.writeMessage(msgUnchoke, nil)
+		}
+	case msgNotInterested:
+		ps.peerInterested = false
+	case msgHave:
+		if len(m.payload) != 4 {
+			return fmt.Errorf("invalid have message")
+		}
+		d.mu.Lock()
+		ps.bits.set(int(binary.BigEndian.Uint32(m.payload)))
+		d.mu.Unlock()
+	case msgBitfield:
+		d.mu.Lock()
+		ps.bits = append(bitfield(nil), m.payload...)
+		d.mu.Unlock()
+	case msgRequest:
+		return d.serveRequest(ps, m.payload)
+	case msgPiece:
+		return d.handleBlock(ps, m.payload)
+	case msgExtended:
+		return d.handleExtended(ps, m.payload)
+	}
+	return nil
+}
+
+// serveRequest uploads a block to an unchoked peer
+func (d *Downloader) serveRequest(ps *peerState, payload []byte) error {
+	if len(payload) != 12 {
+		return fmt.Errorf("invalid request message")
+	}
+	index := int(binary.BigEndian.Uint32(payload[0:]))
+	begin := int64(binary.BigEndian.Uint32(payload[4:]))
+	length := int64(binary.BigEndian.Uint32(payload[8:]))
+	if ps.amChoking || length <= 0 || length > 128*1024 {
+		return nil
+	}
+
+	d.mu.Lock()
+	info, store := d.info, d.store
+	ok := info != nil && d.have.has(index)
+	d.mu.Unlock()
+	if !ok || begin+length > info.pieceSize(index) {
+		return nil
+	}
+
+	buf := make([]byte, length)
+	if err := store.readAt(buf, int64(index)*info.PieceLength+begin); err != nil {
+		return err
+	}
+	if err := ps.sendPiece(index, begin, buf); err != nil {
+		return err
+	}
+	d.uploaded.Add(length)
+	return nil
+}
+
+// fillRequests keeps the peer's request pipeline full
+func (d *Downloader) fillRequests(ps *peerState) error {
+	if ps.peerChoking {
+		return d.updateInterest(ps)
+	}
+	if ps.work == nil {
+		index, ok := d.pick(ps)
+		if !ok {
+			return d.updateInterest(ps)
+		}
+		size := d.info.pieceSize(index)
+		ps.work = &pieceWork{
+			index:  index,
+			buf:    make([]byte, size),
+			blocks: make([]bool, (size+blockSize-1)/blockSize),
+		}
+		ps.lastBlock = time.Now()
+	}
+
+	w := ps.work
+	size := int64(len(w.buf))
+	for w.requested < size && w.requested-w.received < maxBacklog*blockSize {
+		length := int64(blockSize)
+		if w.requested+length > size {
+			length = size - w.requested
+		}
+		if err := ps.sendRequest(w.index, w.requested, length); err != nil {
+			return err
+		}
+		w.requested += length
+	}
+	return d.updateInterest(ps)
+}
+
+// updateInterest tells the peer whether it has anything we still need
+func (d *Downloader) updateInterest(ps *peerState) error {
+	d.mu.Lock()
+	want := false
+	for i := range d.info.Pieces {
+		if !d.have.has(i) && ps.bits.has(i) {
+			want = true
+			break
+		}
+	}
+	d.mu.Unlock()
+
+	if want != ps.amInterested {
+		ps.amInterested = want
+		if want {
+			return ps.writeMessage(msgInterested, nil)
+		}
+		return ps.writeMessage(msgNotInterested, nil)
+	}
+	return nil
+}
+
+// pick chooses the next piece for a peer: the lowest missing one in
+// sequential mode, otherwise the rarest among connected peers. Once every
+// missing piece is in flight, pieces are requested twice (end game) so a
+// single slow peer cannot hold up completion.
+func (d *Downloader) pick(ps *peerState) (int, bool) {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+
+	n := len(d.info.Pieces)
+	best, bestAvail := -1, 0
+	offset := mrand.Intn(n)
+	for k := 0; k < n; k++ {
+		i := (offset + k) % n
+		if d.Config.Sequential {
+			i = k
+		}
+		if d.have.has(i) || d.pending[i] > 0 || !ps.bits.has(i) {
+			continue
+		}
+		if d.Config.Sequential {
+			best = i
+			break
+		}
+		avail := 0
+		for p := range d.peers {
+			if p.bits.has(i) {
+				avail++
+			}
+		}
+		if best < 0 || avail < bestAvail {
+			best, bestAvail = i, avail
+		}
+	}
+
+	if best < 0 {
+		for i := 0; i < n; i++ {
+			if !d.have.has(i) && d.pending[i] == 1 && ps.bits.has(i) {
+				best = i
+				break
+			}
+		}
+	}
+	if best < 0 {
+		return 0, false
+	}
+	d.pending[best]++
+	return best, true
+}
+
+func (d *Downloader) handleBlock(ps *peerState, payload []byte) error {
+	if len(payload) < 8 {
+		return fmt.Errorf("invalid piece message")
+	}
+	index := int(binary.BigEndian.Uint32(payload[0:]))
+	begin := int64(binary.BigEndian.Uint32(payload[4:]))
+	data := payload[8:]
+
+	w := ps.work
+	if w == nil || index != w.index || begin%blockSize != 0 || begin+int64(len(data)) > int64(len(w.buf)) {
+		// Late block for a released piece
+		return nil
+	}
+	block := begin / blockSize
+	if w.blocks[block] {
+		return nil
+	}
+	w.blocks[block] = true
+	copy(w.buf[begin:], data)
+	w.received += int64(len(data))
+	ps.lastBlock = time.Now()
+
+	if w.received < int64(len(w.buf)) {
+		return nil
+	}
+
+	d.release(ps)
+	if sha1.Sum(w.buf) != d.info.Pieces[index] {
+		if ps.badPieces++; ps.badPieces >= maxBadPieces {
+			return fmt.Errorf("peer sent %d corrupt pieces", ps.badPieces)
+		}
+		return nil
+	}
+	ps.badPieces = 0
+	return d.pieceDone(index, w.buf)
+}
+
+// pieceDone stores a verified piece and announces it to every peer
+func (d *Downloader) pieceDone(index int, buf []byte) error {
+	d.mu.Lock()
+	if d.have.has(index) {
+		// Another peer finished it first (end game)
+		d.mu.Unlock()
+		return nil
+	}
+	if err := d.store.writeAt(buf, int64(index)*d.info.PieceLength); err != nil {
+		d.mu.Unlock()
+		return err
+	}
+	d.have.set(index)
+	d.haveCount++
+	finished := d.haveCount == len(d.info.Pieces)
+	peers := make([]*peerState, 0, len(d.peers))
+	for p := range d.peers {
+		peers = append(peers, p)
+	}
+	d.mu.Unlock()
+
+	d.Stats.AddDownloaded(int64(len(buf)))
+	if finished {
+		close(d.complete)
+	}
+
+	for _, p := range peers {
+		p.sendHave(index)
+	}
+	return nil
+}
+
+// Metadata exchange (BEP 9)
+
+func (d *Downloader) handleExtended(ps *peerState, payload []byte) error {
+	if len(payload) == 0 {
+		return nil
+	}
+	if payload[0] == 0 {
+		ps.handleExtHandshake(payload[1:])
+		d.requestMetadata(ps)
+		return nil
+	}
+	if payload[0] != extMetadataID {
+		return nil
+	}
+
+	// The bencoded header is followed by the raw piece data
+	dec := &decoder{data: payload[1:]}
+	v, err := dec.value(0)
+	if err != nil {
+		return nil
+	}
+	h, _ := v.(map[string]interface{})
+	piece := int(dictInt(h, "piece"))
+
+	switch dictInt(h, "msg_type") {
+	case 0: // request
+		d.mu.Lock()
+		meta := d.metadata
+		d.mu.Unlock()
+		start := piece * metadataPieceSize
+		if meta == nil || piece < 0 || start >= len(meta) {
+			return ps.sendMetadata(piece, nil, 0)
+		}
+		end := start + metadataPieceSize
+		if end > len(meta) {
+			end = len(meta)
+		}
+		return ps.sendMetadata(piece, meta[start:end], len(meta))
+
+	case 1: // data
+		d.storeMetadataPiece(piece, payload[1+dec.pos:])
+	}
+	return nil
+}
+
+// requestMetadata asks a peer for the metadata pieces we are missing
+func (d *Downloader) requestMetadata(ps *peerState) {
+	if ps.metadataExt == 0 || ps.metadataSize == 0 || time.Since(ps.metaAsked) < 15*time.Second {
+		return
+	}
+
+	d.mu.Lock()
+	if d.metaDone {
+		d.mu.Unlock()
+		return
+	}
+	if d.metaSize == 0 {
+		d.metaSize = ps.metadataSize
+		d.metaPieces = make([][]byte, (d.metaSize+metadataPieceSize-1)/metadataPieceSize)
+	}
+	var missing []int
+	if ps.metadataSize == d.metaSize {
+		for i, p := range d.metaPieces {
+			if p == nil {
+				missing = append(missing, i)
+			}
+		}
+	}
+	d.mu.Unlock()
+
+	ps.metaAsked = time.Now()
+	for _, i := range missing {
+		if ps.sendMetadataRequest(i) != nil {
+			return
+		}
+	}
+}
+
+func (d *Downloader) storeMetadataPiece(piece int, data []byte) {
+	d.mu.Lock()
+	if d.metaDone || piece < 0 || piece >= len(d.metaPieces) || d.metaPieces[piece] != nil {
+		d.mu.Unlock()
+		return
+	}
+	d.metaPieces[piece] = append([]byte(nil), data...)
+	for _, p := range d.metaPieces {
+		if p == nil {
+			d.mu.Unlock()
+			return
+		}
+	}
+	raw := bytes.Join(d.metaPieces, nil)
+	// Start over on a bad assembly, the next round of requests may go to
+	// honest peers
+	d.metaPieces = make([][]byte, len(d.metaPieces))
+	metaSize := d.metaSize
+	d.mu.Unlock()
+
+	if len(raw) != metaSize || sha1.Sum(raw) != d.meta.InfoHash {
+		return
+	}
+	info, err := parseInfo(raw)
+	if err != nil {
+		return
+	}
+
+	d.mu.Lock()
+	if d.metaDone {
+		d.mu.Unlock()
+		return
+	}
+	d.metaDone = true
+	d.mu.Unlock()
+
+	// Rechecking existing data may take a while, keep the peer loop going
+	d.wg.Add(1)
+	go func() {
+		defer d.wg.Done()
+		if err := d.prepare(info, raw); err != nil {
+			d.fatal <- err
+		}
+	}()
+}
@@ -0,0 +1,330 @@
+package torrent
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"crypto/rand"
+	"crypto/sha1"
+	"encoding/binary"
+	"encoding/hex"
+	"io"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"os"
+	"path/filepath"
+	"strconv"
+	"sync"
+	"testing"
+	"time"
+)
+
+// testSwarm is a tracker handing every announcing client the others
+type testSwarm struct {
+	*httptest.Server
+
+	mu        sync.Mutex
+	peers     map[string]bool
+	announced chan string // Ports, as clients announce
+}
+
+func newTestSwarm(t *testing.T) *testSwarm {
+	s := &testSwarm{peers: map[string]bool{}, announced: make(chan string, 16)}
+	s.Server = httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		q := r.URL.Query()
+		port := q.Get("port")
+		addr := net.JoinHostPort("127.0.0.1", port)
+		var compact []byte
+		s.mu.Lock()
+		for p := range s.peers {
+			if p != addr {
+				host, portStr, _ := net.SplitHostPort(p)
+				n, _ := strconv.Atoi(portStr)
+				compact = append(compact, net.ParseIP(host).To4()...)
+				compact = binary.BigEndian.AppendUint16(compact, uint16(n))
+			}
+		}
+		if q.Get("event") != "stopped" {
+			s.peers[addr] = true
+		} else {
+			delete(s.peers, addr)
+		}
+		s.mu.Unlock()
+		w.Write(bencode(map[string]interface{}{"interval": 30, "peers": compact}))
+		select {
+		case s.announced <- port:
+		default:
+		}
+	}))
+	t.Cleanup(s.Close)
+	return s
+}
+
+func freePort(t *testing.T) int {
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer ln.Close()
+	return ln.Addr().(*net.TCPAddr).Port
+}
+
+// TestSwarm downloads a multi-file torrent from a seeder, once from the
+// .torrent and once from a magnet link, which fetches the info dictionary
+// from the seeder first
+func TestSwarm(t *testing.T) {
+	a := make([]byte, 70000)
+	b := make([]byte, 150001)
+	rand.Read(a)
+	rand.Read(b)
+	files := []testFile{{path: []string{"a.bin"}, data: a}, {path: []string{"sub", "b.bin"}, data: b}}
+	swarm := newTestSwarm(t)
+	raw, _ := makeTorrent("dir", 32768, files, map[string]interface{}{"announce": swarm.URL + "/announce"})
+	dir := t.TempDir()
+	torrentPath := filepath.Join(dir, "t.torrent")
+	if err := os.WriteFile(torrentPath, raw, 0o644); err != nil {
+		t.Fatal(err)
+	}
+	m, err := ParseTorrent(raw)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// The seeder has the files already
+	seedDir := filepath.Join(dir, "seed")
+	for _, f := range files {
+		p := filepath.Join(append([]string{seedDir}, f.path...)...)
+		os.MkdirAll(filepath.Dir(p), 0o755)
+		if err := os.WriteFile(p, f.data, 0o644); err != nil {
+			t.Fatal(err)
+		}
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), 30*time.Second)
+	defer cancel()
+	seedCtx, stopSeeding := context.WithCancel(ctx)
+	seeder := NewDownloader(Config{Source: torrentPath, OutputName: seedDir, SeedRatio: 100, Port: freePort(t)}, http.DefaultClient)
+	seeded := make(chan error, 1)
+	go func() { seeded <- seeder.Start(seedCtx) }()
+	defer func() {
+		stopSeeding()
+		<-seeded
+	}()
+	select {
+	case <-swarm.announced:
+	case <-ctx.Done():
+		t.Fatal("the seeder didn't announce")
+	}
+
+	magnet := "magnet:?xt=urn:btih:" + hex.EncodeToString(m.InfoHash[:]) + "&dn=dir&tr=" + url.QueryEscape(swarm.URL+"/announce")
+	for _, src := range []string{torrentPath, magnet} {
+		out := filepath.Join(dir, "leech", strconv.Itoa(len(src)))
+		leecher := NewDownloader(Config{Source: src, OutputName: out, Port: freePort(t)}, http.DefaultClient)
+		if err := leecher.Start(ctx); err != nil {
+			t.Fatalf("%s: %v", src, err)
+		}
+		for _, f := range files {
+			got, err := os.ReadFile(filepath.Join(append([]string{out}, f.path...)...))
+			if err != nil || !bytes.Equal(got, f.data) {
+				t.Errorf("%s: %v differs (%v)", src, f.path, err)
+			}
+		}
+		if done := leecher.Stats.GetDownloaded(); done != int64(len(a)+len(b)) {
+			t.Errorf("%s: downloaded %d bytes", src, done)
+		}
+	}
+	if seeder.uploaded.Load() < int64(2*(len(a)+len(b))) {
+		t.Errorf("seeder uploaded %d bytes", seeder.uploaded.Load())
+	}
+}
+
+// pieceTest is a downloader of a single-file torrent of content, all of
+// it on disk already when seeding
+func pieceTest(t *testing.T, content []byte, pieceLength int, seeding bool) (*Downloader, *MetaInfo) {
+	raw, _ := makeTorrent("file.bin", pieceLength, []testFile{{data: content}}, nil)
+	m, err := ParseTorrent(raw)
+	if err != nil {
+		t.Fatal(err)
+	}
+	out := filepath.Join(t.TempDir(), "file.bin")
+	if seeding {
+		if err := os.WriteFile(out, content, 0o644); err != nil {
+			t.Fatal(err)
+		}
+	}
+	d := NewDownloader(Config{OutputName: out}, nil)
+	d.meta = m
+	if err := d.prepare(m.Info, m.raw); err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(d.store.close)
+	return d, m
+}
+
+// testPeer is a connected peer with pieces, whatever we send it is read
+// and dropped
+func testPeer(t *testing.T, d *Downloader, pieces ...int) *peerState {
+	a, b := tcpPair(t)
+	go io.Copy(io.Discard, b)
+	ps := &peerState{peerConn: &peerConn{conn: a}}
+	for _, i := range pieces {
+		ps.bits.set(i)
+	}
+	d.peers[ps] = struct{}{}
+	return ps
+}
+
+func TestPick(t *testing.T) {
+	content := make([]byte, 5*blockSize)
+	rand.Read(content)
+	d, _ := pieceTest(t, content, blockSize, false)
+	d.have.set(0)
+	common := testPeer(t, d, 0, 1, 2, 3, 4)
+	testPeer(t, d, 1, 2, 4)
+	testPeer(t, d, 1, 2)
+
+	// Rarest first: piece 3 is on one peer, 4 on two
+	if i, ok := d.pick(common); !ok || i != 3 {
+		t.Errorf("picked %d, %v, want 3", i, ok)
+	}
+	if i, ok := d.pick(common); !ok || i != 4 {
+		t.Errorf("picked %d, %v, want 4", i, ok)
+	}
+
+	// In order, skipping the piece we have and those in flight
+	d.Config.Sequential = true
+	if i, ok := d.pick(common); !ok || i != 1 {
+		t.Errorf("sequential picked %d, %v, want 1", i, ok)
+	}
+	if i, ok := d.pick(common); !ok || i != 2 {
+		t.Errorf("sequential picked %d, %v, want 2", i, ok)
+	}
+
+	// End game: everything is in flight once, so pieces go out twice
+	if i, ok := d.pick(common); !ok || i != 1 {
+		t.Errorf("end game picked %d, %v, want 1", i, ok)
+	}
+	if d.pending[1] != 2 {
+		t.Errorf("pending[1] = %d", d.pending[1])
+	}
+
+	// Nothing the peer has is missing
+	if i, ok := d.pick(testPeer(t, d, 0)); ok {
+		t.Errorf("picked %d from a peer with nothing we need", i)
+	}
+}
+
+func blockMessage(index int, begin int64, data []byte) []byte {
+	payload := make([]byte, 8+len(data))
+	binary.BigEndian.PutUint32(payload, uint32(index))
+	binary.BigEndian.PutUint32(payload[4:], uint32(begin))
+	copy(payload[8:], data)
+	return payload
+}
+
+func TestHandleBlock(t *testing.T) {
+	content := make([]byte, 2*blockSize+100)
+	rand.Read(content)
+	d, m := pieceTest(t, content, len(content), false)
+	ps := testPeer(t, d, 0)
+
+	deliver := func(piece []byte) error {
+		index, ok := d.pick(ps)
+		if !ok || index != 0 {
+			t.Fatalf("picked %d, %v", index, ok)
+		}
+		ps.work = &pieceWork{index: 0, buf: make([]byte, len(piece)), blocks: make([]bool, 3)}
+		// Out of order, with a duplicate and one for another piece
+		for _, begin := range []int64{2 * blockSize, 0, 0, blockSize} {
+			end := min(begin+blockSize, int64(len(piece)))
+			if err := d.handleBlock(ps, blockMessage(0, begin, piece[begin:end])); err != nil {
+				return err
+			}
+			if err := d.handleBlock(ps, blockMessage(5, begin, piece[begin:end])); err != nil {
+				return err
+			}
+		}
+		if ps.work != nil || d.pending[0] != 0 {
+			t.Fatalf("piece still in flight")
+		}
+		return nil
+	}
+
+	corrupt := append([]byte{}, content...)
+	corrupt[blockSize+7] ^= 1
+	for i := 1; i < maxBadPieces; i++ {
+		if err := deliver(corrupt); err != nil {
+			t.Fatalf("corrupt piece %d: %v", i, err)
+		}
+		if d.have.has(0) || ps.badPieces != i {
+			t.Fatalf("corrupt piece %d: have %v, bad pieces %d", i, d.have.has(0), ps.badPieces)
+		}
+	}
+	// A good piece clears the count, so only consecutive corruption counts
+	if err := deliver(content); err != nil {
+		t.Fatal(err)
+	}
+	if !d.have.has(0) || ps.badPieces != 0 {
+		t.Errorf("good piece: have %v, bad pieces %d", d.have.has(0), ps.badPieces)
+	}
+	if got, _ := os.ReadFile(d.Config.OutputName); sha1.Sum(got) != m.Info.Pieces[0] {
+		t.Error("piece not stored")
+	}
+
+	// A peer sending nothing but corrupt pieces is dropped
+	d, _ = pieceTest(t, content, len(content), false)
+	ps = testPeer(t, d, 0)
+	var err error
+	for i := 0; i < maxBadPieces && err == nil; i++ {
+		err = deliver(corrupt)
+	}
+	if err == nil {
+		t.Error("peer kept after corrupt pieces")
+	}
+}
+
+func TestServeRequest(t *testing.T) {
+	content := make([]byte, 3*blockSize)
+	rand.Read(content)
+	d, _ := pieceTest(t, content, 2*blockSize, true)
+	a, b := tcpPair(t)
+	ps := &peerState{peerConn: &peerConn{conn: a}, amChoking: true}
+	remote := &peerConn{conn: b, r: bufio.NewReader(b)}
+
+	request := func(index int, begin, length uint32) []byte {
+		var p [12]byte
+		binary.BigEndian.PutUint32(p[0:], uint32(index))
+		binary.BigEndian.PutUint32(p[4:], begin)
+		binary.BigEndian.PutUint32(p[8:], length)
+		return p[:]
+	}
+
+	// Choked peers get nothing, so don't peers asking past a piece
+	if err := d.serveRequest(ps, request(0, 0, blockSize)); err != nil {
+		t.Fatal(err)
+	}
+	ps.amChoking = false
+	for _, r := range [][]byte{request(1, blockSize, blockSize), request(0, 0, 200*1024), request(9, 0, 10), request(0, 0, 0)} {
+		if err := d.serveRequest(ps, r); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := d.serveRequest(ps, request(1, 100, 50)); err != nil {
+		t.Fatal(err)
+	}
+	if d.uploaded.Load() != 50 {
+		t.Errorf("uploaded %d bytes, want 50", d.uploaded.Load())
+	}
+	id, payload, err := remote.readMessage()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if id != msgPiece || !bytes.Equal(payload, blockMessage(1, 100, content[2*blockSize+100:2*blockSize+150])) {
+		t.Errorf("got message %d of %d bytes", id, len(payload))
+	}
+
+	if err := d.serveRequest(ps, []byte{1, 2, 3}); err == nil {
+		t.Error("malformed request accepted")
+	}
+}
@@ -0,0 +1,223 @@
+package torrent
+
+import (
+	"crypto/sha1"
+	"encoding/base32"
+	"encoding/hex"
+	"fmt"
+	"net/url"
+	"path/filepath"
+	"strings"
+)
+
+// File is one file of a multi-file torrent
+type File struct {
+	Path   []string
+	Length int64
+}
+
+// Info is the parsed info dictionary
+type Info struct {
+	Name        string
+	PieceLength int64
+	Pieces      [][20]byte
+	Length      int64 // Single-file torrents only
+	Files       []File
+}
+
+// MetaInfo describes a torrent, either from a .torrent file or a magnet link.
+// Info is nil for magnet links until the metadata was fetched from a peer.
+type MetaInfo struct {
+	InfoHash [20]byte
+	Name     string
+	Trackers []string
+	Info     *Info
+
+	raw []byte // Bencoded info dictionary, served to magnet peers
+}
+
+// IsTorrent reports whether src is a magnet link or .torrent file/URL
+func IsTorrent(src string) bool {
+	if strings.HasPrefix(src, "magnet:") {
+		return true
+	}
+	if u, err := url.Parse(src); err == nil && u.Scheme != "" {
+		src = u.Path
+	}
+	return strings.EqualFold(filepath.Ext(src), ".torrent")
+}
+
+// ParseTorrent parses the contents of a .torrent file
+func ParseTorrent(data []byte) (*MetaInfo, error) {
+	d := &decoder{data: data}
+	v, err := d.value(0)
+	if err != nil {
+		return nil, err
+	}
+	root, ok := v.(map[string]interface{})
+	if !ok || d.infoEnd == 0 {
+		return nil, fmt.Errorf("torrent file has no info dictionary")
+	}
+
+	raw := data[d.infoStart:d.infoEnd]
+	info, err := parseInfo(raw)
+	if err != nil {
+		return nil, err
+	}
+
+	m := &MetaInfo{InfoHash: sha1.Sum(raw), Name: info.Name, Info: info, raw: raw}
+
+	// announce-list tiers take precedence, announce is the fallback (BEP 12)
+	for _, tier := range dictList(root, "announce-list") {
+		urls, _ := tier.([]interface{})
+		for _, u := range urls {
+			if s, ok := u.(string); ok {
+				m.addTracker(s)
+			}
+		}
+	}
+	m.addTracker(dictString(root, "announce"))
+	return m, nil
+}
+
+func (m *MetaInfo) addTracker(u string) {
+	if u == "" {
+		return
+	}
+	for _, t := range m.Trackers {
+		if t == u {
+			return
+		}
+	}
+	m.Trackers = append(m.Trackers, u)
+}
+
+func parseInfo(raw []byte) (*Info, error) {
+	v, err := bdecode(raw)
+	if err != nil {
+		return nil, err
+	}
+	d, ok := v.(map[string]interface{})
+	if !ok {
+		return nil, fmt.Errorf("info is not a dictionary")
+	}
+
+	info := &Info{
+		Name:        dictString(d, "name"),
+		PieceLength: dictInt(d, "piece length"),
+		Length:      dictInt(d, "length"),
+	}
+	if info.Name == "" || info.PieceLength <= 0 {
+		return nil, fmt.Errorf("info dictionary is missing name or piece length")
+	}
+
+	pieces := dictString(d, "pieces")
+	if len(pieces) == 0 || len(pieces)%20 != 0 {
+		return nil, fmt.Errorf("invalid pieces field")
+	}
+	info.Pieces = make([][20]byte, len(pieces)/20)
+	for i := range info.Pieces {
+		copy(info.Pieces[i][:], pieces[i*20:])
+	}
+
+	for _, f := range dictList(d, "files") {
+		fd, ok := f.(map[string]interface{})
+		if !ok {
+			return nil, fmt.Errorf("invalid files entry")
+		}
+		file := File{Length: dictInt(fd, "length")}
+		for _, p := range dictList(fd, "path") {
+			s, _ := p.(string)
+			file.Path = append(file.Path, s)
+		}
+		if err := checkPath(file.Path); err != nil || file.Length < 0 {
+			return nil, fmt.Errorf("invalid file path in torrent")
+		}
+		info.Files = append(info.Files, file)
+	}
+	if err := checkPath([]string{info.Name}); err != nil {
+		return nil, fmt.Errorf("invalid torrent name %q", info.Name)
+	}
+
+	total := info.TotalLength()
+	if n := (total + info.PieceLength - 1) / info.PieceLength; n != int64(len(info.Pieces)) {
+		return nil, fmt.Errorf("torrent has %d pieces, expected %d", len(info.Pieces), n)
+	}
+	return info, nil
+}
+
+// checkPath rejects path components that would escape the download directory
+func checkPath(parts []string) error {
+	if len(parts) == 0 {
+		return fmt.Errorf("empty path")
+	}
+	for _, p := range parts {
+		if p == "" || p == "." || p == ".." || strings.ContainsAny(p, "/\\\x00") {
+			return fmt.Errorf("invalid path component %q", p)
+		}
+	}
+	return nil
+}
+
+// TotalLength is the size of all files combined
+func (i *Info) TotalLength() int64 {
+	if len(i.Files) == 0 {
+		return i.Length
+	}
+	var total int64
+	for _, f := range i.Files {
+		total += f.Length
+	}
+	return total
+}
+
+func (i *Info) pieceSize(index int) int64 {
+	if index == len(i.Pieces)-1 {
+		if rem := i.TotalLength() % i.PieceLength; rem != 0 {
+			return rem
+		}
+	}
+	return i.PieceLength
+}
+
+// ParseMagnet parses a magnet:?xt=urn:btih:... link. Hex and base32 info
+// hashes are accepted.
+func ParseMagnet(uri string) (*MetaInfo, error) {
+	u, err := url.Parse(uri)
+	if err != nil || u.Scheme != "magnet" {
+		return nil, fmt.Errorf("invalid magnet link")
+	}
+	q := u.Query()
+
+	m := &MetaInfo{Name: q.Get("dn")}
+	found := false
+	for _, xt := range q["xt"] {
+		if !strings.HasPrefix(xt, "urn:btih:") {
+			continue
+		}
+		hash := strings.TrimPrefix(xt, "urn:btih:")
+		var b []byte
+		switch len(hash) {
+		case 40:
+			b, err = hex.DecodeString(hash)
+		case 32:
+			b, err = base32.StdEncoding.DecodeString(strings.ToUpper(hash))
+		default:
+			err = fmt.Errorf("unexpected length")
+		}
+		if err != nil || len(b) != 20 {
+			return nil, fmt.Errorf("invalid info hash %q", hash)
+		}
+		copy(m.InfoHash[:], b)
+		found = true
+		break
+	}
+	if !found {
+		return nil, fmt.Errorf("magnet link has no BitTorrent info hash")
+	}
+
+	for _, tr := range q["tr"] {
+		m.addTracker(tr)
+	}
+	return m, nil
+}
@@ -0,0 +1,187 @@
+package torrent
+
+import (
+	"crypto/sha1"
+	"encoding/base32"
+	"encoding/hex"
+	"strings"
+	"testing"
+)
+
+// testFile is a file of a torrent made by makeTorrent
+type testFile struct {
+	path []string
+	data []byte
+}
+
+// makeTorrent builds the .torrent of files, a single-file torrent named
+// name when there is one file without a path
+func makeTorrent(name string, pieceLength int, files []testFile, extra map[string]interface{}) ([]byte, []byte) {
+	var content []byte
+	for _, f := range files {
+		content = append(content, f.data...)
+	}
+	var pieces []byte
+	for off := 0; off < len(content); off += pieceLength {
+		sum := sha1.Sum(content[off:min(off+pieceLength, len(content))])
+		pieces = append(pieces, sum[:]...)
+	}
+	info := map[string]interface{}{"name": name, "piece length": pieceLength, "pieces": pieces}
+	if len(files) == 1 && files[0].path == nil {
+		info["length"] = len(content)
+	} else {
+		var list []interface{}
+		for _, f := range files {
+			var path []interface{}
+			for _, p := range f.path {
+				path = append(path, p)
+			}
+			list = append(list, map[string]interface{}{"length": len(f.data), "path": path})
+		}
+		info["files"] = list
+	}
+	root := map[string]interface{}{"info": info}
+	for k, v := range extra {
+		root[k] = v
+	}
+	return bencode(root), content
+}
+
+func TestParseTorrentSingleFile(t *testing.T) {
+	data, _ := makeTorrent("file.bin", 4, []testFile{{data: []byte("0123456789")}}, map[string]interface{}{
+		"announce": "http://b/announce",
+		"announce-list": []interface{}{
+			[]interface{}{"http://a/announce", "http://b/announce"},
+			[]interface{}{"udp://c:80"},
+		},
+	})
+	m, err := ParseTorrent(data)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if m.Name != "file.bin" || m.Info.Length != 10 || m.Info.TotalLength() != 10 || len(m.Info.Pieces) != 3 {
+		t.Errorf("parsed %+v", m.Info)
+	}
+	// The tiers come first, the duplicate announce isn't added again
+	if got := strings.Join(m.Trackers, " "); got != "http://a/announce http://b/announce udp://c:80" {
+		t.Errorf("trackers = %s", got)
+	}
+	if sizes := []int64{m.Info.pieceSize(0), m.Info.pieceSize(1), m.Info.pieceSize(2)}; sizes[0] != 4 || sizes[1] != 4 || sizes[2] != 2 {
+		t.Errorf("piece sizes = %v, want [4 4 2]", sizes)
+	}
+
+	// The info hash is over the info dictionary as it is in the file
+	start := strings.Index(string(data), "4:infod") + len("4:info")
+	want := sha1.Sum(data[start : len(data)-1])
+	if m.InfoHash != want {
+		t.Errorf("info hash = %x, want %x", m.InfoHash, want)
+	}
+}
+
+func TestParseTorrentMultiFile(t *testing.T) {
+	data, _ := makeTorrent("dir", 8, []testFile{
+		{path: []string{"a.txt"}, data: []byte("hello")},
+		{path: []string{"sub", "b.txt"}, data: []byte("world, again")},
+	}, nil)
+	m, err := ParseTorrent(data)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(m.Info.Files) != 2 || m.Info.TotalLength() != 17 || len(m.Info.Pieces) != 3 {
+		t.Fatalf("parsed %+v", m.Info)
+	}
+	if got := strings.Join(m.Info.Files[1].Path, "/"); got != "sub/b.txt" {
+		t.Errorf("path = %s", got)
+	}
+	if m.Info.pieceSize(2) != 1 {
+		t.Errorf("last piece size = %d, want 1", m.Info.pieceSize(2))
+	}
+}
+
+func TestParseTorrentInvalid(t *testing.T) {
+	valid := []testFile{{path: []string{"a"}, data: []byte("abc")}}
+	escape, _ := makeTorrent("dir", 4, []testFile{{path: []string{"..", "evil"}, data: []byte("x")}}, nil)
+	slash, _ := makeTorrent("dir", 4, []testFile{{path: []string{"a/b"}, data: []byte("x")}}, nil)
+	badName, _ := makeTorrent("..", 4, valid, nil)
+	empty, _ := makeTorrent("dir", 4, []testFile{{path: []string{}, data: []byte("x")}}, nil)
+
+	// One piece short
+	short := bencode(map[string]interface{}{"info": map[string]interface{}{
+		"name": "f", "piece length": 2, "length": 5, "pieces": strings.Repeat("x", 40),
+	}})
+	ragged := bencode(map[string]interface{}{"info": map[string]interface{}{
+		"name": "f", "piece length": 2, "length": 2, "pieces": "abc",
+	}})
+	noLength := bencode(map[string]interface{}{"info": map[string]interface{}{
+		"name": "f", "length": 2, "pieces": strings.Repeat("x", 20),
+	}})
+
+	tests := map[string][]byte{
+		"no info":          []byte("d8:announce1:xe"),
+		"not a dictionary": []byte("l4:infoe"),
+		"truncated":        []byte("d4:infod4:name"),
+		"parent directory": escape,
+		"slash in path":    slash,
+		"bad name":         badName,
+		"empty path":       empty,
+		"piece count":      short,
+		"pieces length":    ragged,
+		"no piece length":  noLength,
+	}
+	for name, data := range tests {
+		if _, err := ParseTorrent(data); err == nil {
+			t.Errorf("%s: parsed", name)
+		}
+	}
+}
+
+func TestParseMagnet(t *testing.T) {
+	hash := sha1.Sum([]byte("torrent"))
+	hexHash := hex.EncodeToString(hash[:])
+	b32 := strings.ToLower(base32.StdEncoding.EncodeToString(hash[:]))
+
+	for _, uri := range []string{
+		"magnet:?xt=urn:btih:" + hexHash + "&dn=name&tr=http%3A%2F%2Ft%2Fa&tr=udp%3A%2F%2Fu%3A1",
+		"magnet:?dn=name&xt=urn:btmh:1220abcd&xt=urn:btih:" + strings.ToUpper(b32) + "&tr=http%3A%2F%2Ft%2Fa&tr=udp%3A%2F%2Fu%3A1",
+	} {
+		m, err := ParseMagnet(uri)
+		if err != nil {
+			t.Errorf("%s: %v", uri, err)
+			continue
+		}
+		if m.InfoHash != hash || m.Name != "name" || m.Info != nil {
+			t.Errorf("%s: parsed %+v", uri, m)
+		}
+		if got := strings.Join(m.Trackers, " "); got != "http://t/a udp://u:1" {
+			t.Errorf("%s: trackers = %s", uri, got)
+		}
+	}
+
+	for _, uri := range []string{
+		"http://example.com/?xt=urn:btih:" + hexHash,
+		"magnet:?dn=name",
+		"magnet:?xt=urn:btih:abcd",
+		"magnet:?xt=urn:btih:" + strings.Repeat("z", 40),
+	} {
+		if _, err := ParseMagnet(uri); err == nil {
+			t.Errorf("%s: parsed", uri)
+		}
+	}
+}
+
+func TestIsTorrent(t *testing.T) {
+	tests := map[string]bool{
+		"magnet:?xt=urn:btih:abc":               true,
+		"file.torrent":                          true,
+		"/dl/Ubuntu.TORRENT":                    true,
+		"https://example.com/a.torrent?sig=xyz": true,
+		"https://example.com/a.iso":             false,
+		"https://example.com/?f=a.torrent":      false,
+		"torrent":                               false,
+	}
+	for src, want := range tests {
+		if got := IsTorrent(src); got != want {
+			t.Errorf("IsTorrent(%q) = %v", src, got)
+		}
+	}
+}
@@ -0,0 +1,228 @@
+package torrent
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/binary"
+	"fmt"
+	"io"
+	"net"
+	"sync"
+	"time"
+)
+
+// Peer wire message ids (BEP 3, BEP 10)
+const (
+	msgChoke         = 0
+	msgUnchoke       = 1
+	msgInterested    = 2
+	msgNotInterested = 3
+	msgHave          = 4
+	msgBitfield      = 5
+	msgRequest       = 6
+	msgPiece         = 7
+	msgCancel        = 8
+	msgExtended      = 20
+
+	// Our id for ut_metadata in the extended handshake
+	extMetadataID = 1
+
+	blockSize    = 16 * 1024
+	maxMessage   = 4 << 20
+	peerDeadline = 2 * time.Minute
+)
+
+const protocolName = "BitTorrent protocol"
+
+type peerConn struct {
+	addr string
+	conn net.Conn
+	r    *bufio.Reader
+
+	writeMu sync.Mutex
+
+	// Set by the handshake
+	supportsExt bool
+
+	// Set by the extended handshake
+	metadataExt  int // Remote id of ut_metadata, 0 when unsupported
+	metadataSize int
+}
+
+// handshake exchanges the fixed handshake and checks the info hash
+func handshake(conn net.Conn, infoHash, peerID [20]byte) (*peerConn, error) {
+	buf := make([]byte, 0, 68)
+	buf = append(buf, byte(len(protocolName)))
+	buf = append(buf, protocolName...)
+	reserved := make([]byte, 8)
+	reserved[5] |= 0x10 // Extension protocol (BEP 10)
+	buf = append(buf, reserved...)
+	buf = append(buf, infoHash[:]...)
+	buf = append(buf, peerID[:]...)
+
+	conn.SetDeadline(time.Now().Add(20 * time.Second))
+	defer conn.SetDeadline(time.Time{})
+
+	if _, err := conn.Write(buf); err != nil {
+		return nil, err
+	}
+
+	r := bufio.NewReaderSize(conn, 64*1024)
+	resp := make([]byte, 68)
+	if _, err := io.ReadFull(r, resp); err != nil {
+		return nil, err
+	}
+	if int(resp[0]) != len(protocolName) || string(resp[1:20]) != protocolName {
+		return nil, fmt.Errorf("not a BitTorrent peer")
+	}
+	if !bytes.Equal(resp[28:48], infoHash[:]) {
+		return nil, fmt.Errorf("peer serves a different torrent")
+	}
+	if bytes.Equal(resp[48:68], peerID[:]) {
+		return nil, fmt.Errorf("connected to ourselves")
+	}
+
+	return &peerConn{
+		addr:        conn.RemoteAddr().String(),
+		conn:        conn,
+		r:           r,
+		supportsExt: resp[25]&0x10 != 0,
+	}, nil
+}
+
+// readMessage returns the next message. Keep-alives are skipped.
+func (p *peerConn) readMessage() (byte, []byte, error) {
+	for {
+		p.conn.SetReadDeadline(time.Now().Add(peerDeadline))
+		var lenBuf [4]byte
+		if _, err := io.ReadFull(p.r, lenBuf[:]); err != nil {
+			return 0, nil, err
+		}
+		n := binary.BigEndian.Uint32(lenBuf[:])
+		if n == 0 {
+			continue
+		}
+		if n > maxMessage {
+			return 0, nil, fmt.Errorf("message too large (%d bytes)", n)
+		}
+		msg := make([]byte, n)
+		if _, err := io.ReadFull(p.r, msg); err != nil {
+			return 0, nil, err
+		}
+		return msg[0], msg[1:], nil
+	}
+}
+
+func (p *peerConn) writeMessage(id byte, payload []byte) error {
+	buf := make([]byte, 5+len(payload))
+	binary.BigEndian.PutUint32(buf, uint32(1+len(payload)))
+	buf[4] = id
+	copy(buf[5:], payload)
+
+	p.writeMu.Lock()
+	defer p.writeMu.Unlock()
+	p.conn.SetWriteDeadline(time.Now().Add(peerDeadline))
+	_, err := p.conn.Write(buf)
+	return err
+}
+
+func (p *peerConn) keepAlive() error {
+	p.writeMu.Lock()
+	defer p.writeMu.Unlock()
+	p.conn.SetWriteDeadline(time.Now().Add(peerDeadline))
+	_, err := p.conn.Write([]byte{0, 0, 0, 0})
+	return err
+}
+
+func (p *peerConn) sendHave(index int) error {
+	var b [4]byte
+	binary.BigEndian.PutUint32(b[:], uint32(index))
+	return p.writeMessage(msgHave, b[:])
+}
+
+func (p *peerConn) sendRequest(index int, begin, length int64) error {
+	var b [12]byte
+	binary.BigEndian.PutUint32(b[0:], uint32(index))
+	binary.BigEndian.PutUint32(b[4:], uint32(begin))
+	binary.BigEndian.PutUint32(b[8:], uint32(length))
+	return p.writeMessage(msgRequest, b[:])
+}
+
+func (p *peerConn) sendPiece(index int, begin int64, data []byte) error {
+	payload := make([]byte, 8+len(data))
+	binary.BigEndian.PutUint32(payload[0:], uint32(index))
+	binary.BigEndian.PutUint32(payload[4:], uint32(begin))
+	copy(payload[8:], data)
+	return p.writeMessage(msgPiece, payload)
+}
+
+// sendExtHandshake advertises ut_metadata, with our metadata size once known
+func (p *peerConn) sendExtHandshake(metadataSize int, port int) error {
+	d := map[string]interface{}{
+		"m": map[string]interface{}{"ut_metadata": extMetadataID},
+		"p": port,
+		"v": "warp-dl",
+	}
+	if metadataSize > 0 {
+		d["metadata_size"] = metadataSize
+	}
+	return p.writeMessage(msgExtended, append([]byte{0}, bencode(d)...))
+}
+
+// handleExtHandshake records the peer's extension ids
+func (p *peerConn) handleExtHandshake(payload []byte) {
+	v, err := bdecode(payload)
+	if err != nil {
+		return
+	}
+	d, _ := v.(map[string]interface{})
+	if id := dictInt(dictDict(d, "m"), "ut_metadata"); id > 0 && id < 256 {
+		p.metadataExt = int(id)
+	}
+	if size := dictInt(d, "metadata_size"); size > 0 && size < maxMessage {
+		p.metadataSize = int(size)
+	}
+}
+
+func (p *peerConn) sendMetadataRequest(piece int) error {
+	msg := bencode(map[string]interface{}{"msg_type": 0, "piece": piece})
+	return p.writeMessage(msgExtended, append([]byte{byte(p.metadataExt)}, msg...))
+}
+
+// sendMetadata answers a ut_metadata request with data, or rejects it when
+// data is nil
+func (p *peerConn) sendMetadata(piece int, data []byte, total int) error {
+	if p.metadataExt == 0 {
+		return nil
+	}
+	if data == nil {
+		msg := bencode(map[string]interface{}{"msg_type": 2, "piece": piece})
+		return p.writeMessage(msgExtended, append([]byte{byte(p.metadataExt)}, msg...))
+	}
+	msg := bencode(map[string]interface{}{"msg_type": 1, "piece": piece, "total_size": total})
+	payload := append([]byte{byte(p.metadataExt)}, msg...)
+	return p.writeMessage(msgExtended, append(payload, data...))
+}
+
+// bitfield is a piece set in wire order (high bit of byte 0 is piece 0)
+type bitfield []byte
+
+func newBitfield(n int) bitfield {
+	return make(bitfield, (n+7)/8)
+}
+
+func (b bitfield) has(i int) bool {
+	return i >= 0 && i/8 < len(b) && b[i/8]&(0x80>>(i%8)) != 0
+}
+
+// set grows the field as needed, a peer's have messages may arrive before
+// we know the piece count
+func (b *bitfield) set(i int) {
+	if i < 0 {
+		return
+	}
+	for i/8 >= len(*b) {
+		*b = append(*b, 0)
+	}
+	(*b)[i/8] |= 0x80 >> (i % 8)
+}
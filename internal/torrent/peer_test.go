@@ -0,0 +1,167 @@
+package torrent
+
+import (
+	"bufio"
+	"encoding/binary"
+	"net"
+	"strings"
+	"testing"
+)
+
+// tcpPair is both ends of a loopback connection. net.Pipe won't do, both
+// sides of a handshake write before they read.
+func tcpPair(t *testing.T) (net.Conn, net.Conn) {
+	t.Helper()
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer ln.Close()
+	accepted := make(chan net.Conn, 1)
+	go func() {
+		c, _ := ln.Accept()
+		accepted <- c
+	}()
+	a, err := net.Dial("tcp", ln.Addr().String())
+	if err != nil {
+		t.Fatal(err)
+	}
+	b := <-accepted
+	t.Cleanup(func() {
+		a.Close()
+		b.Close()
+	})
+	return a, b
+}
+
+func peerID(s string) [20]byte {
+	var id [20]byte
+	copy(id[:], s)
+	return id
+}
+
+func TestHandshake(t *testing.T) {
+	hash, other := peerID("hash"), peerID("other hash")
+	tests := []struct {
+		name               string
+		theirHash, theirID [20]byte
+		err                string
+	}{
+		{"same torrent", hash, peerID("them"), ""},
+		{"other torrent", other, peerID("them"), "different torrent"},
+		{"ourselves", hash, peerID("us"), "ourselves"},
+	}
+	for _, tt := range tests {
+		a, b := tcpPair(t)
+		go handshake(b, tt.theirHash, tt.theirID)
+		pc, err := handshake(a, hash, peerID("us"))
+		switch {
+		case tt.err == "" && err != nil:
+			t.Errorf("%s: %v", tt.name, err)
+		case tt.err != "" && (err == nil || !strings.Contains(err.Error(), tt.err)):
+			t.Errorf("%s: err = %v, want %q", tt.name, err, tt.err)
+		case err == nil && !pc.supportsExt:
+			t.Errorf("%s: extension protocol not seen", tt.name)
+		}
+	}
+
+	// Anything but a BitTorrent handshake
+	a, b := tcpPair(t)
+	go b.Write([]byte(strings.Repeat("GET / HTTP/1.1\r\n", 5)))
+	if _, err := handshake(a, hash, peerID("us")); err == nil || !strings.Contains(err.Error(), "not a BitTorrent peer") {
+		t.Errorf("HTTP: err = %v", err)
+	}
+}
+
+func TestMessages(t *testing.T) {
+	a, b := tcpPair(t)
+	send := &peerConn{conn: a}
+	recv := &peerConn{conn: b, r: bufio.NewReader(b)}
+
+	go func() {
+		send.keepAlive()
+		send.sendHave(7)
+		send.sendRequest(1, 16384, 100)
+		send.sendPiece(2, 32, []byte("data"))
+		send.writeMessage(msgUnchoke, nil)
+	}()
+
+	type msg struct {
+		id      byte
+		payload []byte
+	}
+	want := []msg{
+		{msgHave, []byte{0, 0, 0, 7}},
+		{msgRequest, []byte{0, 0, 0, 1, 0, 0, 0x40, 0, 0, 0, 0, 100}},
+		{msgPiece, append([]byte{0, 0, 0, 2, 0, 0, 0, 32}, "data"...)},
+		{msgUnchoke, []byte{}},
+	}
+	// The keep-alive is skipped
+	for _, w := range want {
+		id, payload, err := recv.readMessage()
+		if err != nil {
+			t.Fatal(err)
+		}
+		if id != w.id || string(payload) != string(w.payload) {
+			t.Errorf("got message %d %x, want %d %x", id, payload, w.id, w.payload)
+		}
+	}
+}
+
+func TestMessageTooLarge(t *testing.T) {
+	a, b := tcpPair(t)
+	recv := &peerConn{conn: b, r: bufio.NewReader(b)}
+	var n [4]byte
+	binary.BigEndian.PutUint32(n[:], maxMessage+1)
+	go a.Write(n[:])
+	if _, _, err := recv.readMessage(); err == nil || !strings.Contains(err.Error(), "too large") {
+		t.Errorf("err = %v", err)
+	}
+}
+
+func TestExtHandshake(t *testing.T) {
+	p := &peerConn{}
+	p.handleExtHandshake(bencode(map[string]interface{}{
+		"m":             map[string]interface{}{"ut_metadata": 3, "ut_pex": 1},
+		"metadata_size": 31337,
+	}))
+	if p.metadataExt != 3 || p.metadataSize != 31337 {
+		t.Errorf("ext %d, size %d", p.metadataExt, p.metadataSize)
+	}
+
+	// Out of range values are ignored
+	p = &peerConn{}
+	p.handleExtHandshake(bencode(map[string]interface{}{
+		"m":             map[string]interface{}{"ut_metadata": 300},
+		"metadata_size": maxMessage,
+	}))
+	if p.metadataExt != 0 || p.metadataSize != 0 {
+		t.Errorf("ext %d, size %d", p.metadataExt, p.metadataSize)
+	}
+	p.handleExtHandshake([]byte("not bencode"))
+}
+
+func TestBitfield(t *testing.T) {
+	b := newBitfield(10)
+	if len(b) != 2 {
+		t.Fatalf("len = %d", len(b))
+	}
+	b.set(0)
+	b.set(9)
+	if b[0] != 0x80 || b[1] != 0x40 {
+		t.Errorf("wire order: %08b", b)
+	}
+	for i, want := range map[int]bool{0: true, 1: false, 9: true, -1: false, 100: false} {
+		if b.has(i) != want {
+			t.Errorf("has(%d) = %v", i, !want)
+		}
+	}
+
+	// Haves ahead of the metadata grow the field
+	var grown bitfield
+	grown.set(20)
+	grown.set(-3)
+	if len(grown) != 3 || !grown.has(20) {
+		t.Errorf("grown = %08b", grown)
+	}
+}
@@ -0,0 +1,101 @@
+package torrent
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+)
+
+// storage maps the torrent's contiguous byte space onto its files
+type storage struct {
+	files []storageFile
+}
+
+type storageFile struct {
+	f      *os.File
+	offset int64
+	length int64
+}
+
+// openStorage creates (or reopens) the torrent's files. Single-file torrents
+// are written to root itself, multi-file torrents below the root directory.
+func openStorage(root string, info *Info) (*storage, error) {
+	type entry struct {
+		path   string
+		length int64
+	}
+	var entries []entry
+	if len(info.Files) == 0 {
+		entries = append(entries, entry{root, info.Length})
+	} else {
+		for _, f := range info.Files {
+			entries = append(entries, entry{filepath.Join(append([]string{root}, f.Path...)...), f.Length})
+		}
+	}
+
+	s := &storage{}
+	var offset int64
+	for _, e := range entries {
+		if err := os.MkdirAll(filepath.Dir(e.path), 0o755); err != nil {
+			s.close()
+			return nil, err
+		}
+		f, err := os.OpenFile(e.path, os.O_RDWR|os.O_CREATE, 0o644)
+		if err != nil {
+			s.close()
+			return nil, err
+		}
+		// Size the file up front so pieces can be written in any order
+		if info, err := f.Stat(); err == nil && info.Size() != e.length {
+			if err := f.Truncate(e.length); err != nil {
+				f.Close()
+				s.close()
+				return nil, fmt.Errorf("failed to allocate %s: %w", e.path, err)
+			}
+		}
+		s.files = append(s.files, storageFile{f: f, offset: offset, length: e.length})
+		offset += e.length
+	}
+	return s, nil
+}
+
+// each calls fn for every file section overlapping [off, off+n)
+func (s *storage) each(off, n int64, fn func(f *os.File, fileOff, bufOff, length int64) error) error {
+	for _, sf := range s.files {
+		if off+n <= sf.offset || off >= sf.offset+sf.length {
+			continue
+		}
+		start := off
+		if start < sf.offset {
+			start = sf.offset
+		}
+		end := off + n
+		if end > sf.offset+sf.length {
+			end = sf.offset + sf.length
+		}
+		if err := fn(sf.f, start-sf.offset, start-off, end-start); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (s *storage) readAt(p []byte, off int64) error {
+	return s.each(off, int64(len(p)), func(f *os.File, fileOff, bufOff, length int64) error {
+		_, err := f.ReadAt(p[bufOff:bufOff+length], fileOff)
+		return err
+	})
+}
+
+func (s *storage) writeAt(p []byte, off int64) error {
+	return s.each(off, int64(len(p)), func(f *os.File, fileOff, bufOff, length int64) error {
+		_, err := f.WriteAt(p[bufOff:bufOff+length], fileOff)
+		return err
+	})
+}
+
+func (s *storage) close() {
+	for _, sf := range s.files {
+		sf.f.Close()
+	}
+}
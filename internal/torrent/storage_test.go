@@ -0,0 +1,106 @@
+package torrent
+
+import (
+	"bytes"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestStorageSpansFiles(t *testing.T) {
+	files := []testFile{
+		{path: []string{"a"}, data: []byte("0123")},
+		{path: []string{"empty"}, data: []byte{}},
+		{path: []string{"sub", "b"}, data: []byte("456789")},
+		{path: []string{"c"}, data: []byte("ab")},
+	}
+	raw, content := makeTorrent("dir", 5, files, nil)
+	m, err := ParseTorrent(raw)
+	if err != nil {
+		t.Fatal(err)
+	}
+	root := filepath.Join(t.TempDir(), "dir")
+	s, err := openStorage(root, m.Info)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer s.close()
+
+	// Every file is its full size before anything is written
+	for _, f := range files {
+		fi, err := os.Stat(filepath.Join(append([]string{root}, f.path...)...))
+		if err != nil || fi.Size() != int64(len(f.data)) {
+			t.Fatalf("%v: %v, size %d", f.path, err, fi.Size())
+		}
+	}
+
+	// Pieces written out of order, each crossing file boundaries
+	for _, i := range []int{2, 0, 1} {
+		off := int64(i) * m.Info.PieceLength
+		if err := s.writeAt(content[off:off+m.Info.pieceSize(i)], off); err != nil {
+			t.Fatal(err)
+		}
+	}
+	for _, f := range files {
+		got, _ := os.ReadFile(filepath.Join(append([]string{root}, f.path...)...))
+		if !bytes.Equal(got, f.data) {
+			t.Errorf("%v = %q, want %q", f.path, got, f.data)
+		}
+	}
+
+	buf := make([]byte, 6)
+	if err := s.readAt(buf, 3); err != nil {
+		t.Fatal(err)
+	}
+	if string(buf) != "345678" {
+		t.Errorf("readAt = %q", buf)
+	}
+}
+
+func TestPrepareRechecksPieces(t *testing.T) {
+	raw, content := makeTorrent("file.bin", 4, []testFile{{data: []byte("0123456789")}}, nil)
+	m, err := ParseTorrent(raw)
+	if err != nil {
+		t.Fatal(err)
+	}
+	out := filepath.Join(t.TempDir(), "file.bin")
+	// The first piece is on disk, the second is corrupt, the last missing
+	partial := append(append([]byte{}, content[:4]...), "45X7"...)
+	if err := os.WriteFile(out, partial, 0o644); err != nil {
+		t.Fatal(err)
+	}
+
+	d := NewDownloader(Config{OutputName: out}, nil)
+	d.meta = m
+	if err := d.prepare(m.Info, m.raw); err != nil {
+		t.Fatal(err)
+	}
+	defer d.store.close()
+	if !d.have.has(0) || d.have.has(1) || d.have.has(2) || d.haveCount != 1 {
+		t.Errorf("have = %08b, count %d", d.have, d.haveCount)
+	}
+	if d.Stats.GetDownloaded() != 4 || d.Stats.GetTotal() != 10 {
+		t.Errorf("stats = %d of %d", d.Stats.GetDownloaded(), d.Stats.GetTotal())
+	}
+	select {
+	case <-d.complete:
+		t.Error("complete with pieces missing")
+	default:
+	}
+
+	// A verified piece is stored and counted, the last one completes it
+	for _, i := range []int{1, 2} {
+		off := int64(i) * 4
+		if err := d.pieceDone(i, content[off:off+m.Info.pieceSize(i)]); err != nil {
+			t.Fatal(err)
+		}
+	}
+	select {
+	case <-d.complete:
+	default:
+		t.Error("not complete with every piece")
+	}
+	if got, _ := os.ReadFile(out); !bytes.Equal(got, content) {
+		t.Errorf("file = %q", got)
+	}
+}
@@ -0,0 +1,218 @@
+package torrent
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/binary"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"net/url"
+	"strconv"
+	"time"
+)
+
+type announceParams struct {
+	InfoHash   [20]byte
+	PeerID     [20]byte
+	Port       int
+	Uploaded   int64
+	Downloaded int64
+	Left       int64
+	Event      string // "started", "completed", "stopped" or empty
+}
+
+type announceResult struct {
+	Peers    []string
+	Interval time.Duration
+}
+
+// announce contacts an HTTP(S) or UDP tracker
+func announce(ctx context.Context, client *http.Client, tracker string, p announceParams) (*announceResult, error) {
+	u, err := url.Parse(tracker)
+	if err != nil {
+		return nil, err
+	}
+	switch u.Scheme {
+	case "http", "https":
+		return announceHTTP(ctx, client, u, p)
+	case "udp":
+		return announceUDP(ctx, u.Host, p)
+	}
+	return nil, fmt.Errorf("unsupported tracker scheme %q", u.Scheme)
+}
+
+func announceHTTP(ctx context.Context, client *http.Client, u *url.URL, p announceParams) (*announceResult, error) {
+	q := url.Values{}
+	q.Set("info_hash", string(p.InfoHash[:]))
+	q.Set("peer_id", string(p.PeerID[:]))
+	q.Set("port", strconv.Itoa(p.Port))
+	q.Set("uploaded", strconv.FormatInt(p.Uploaded, 10))
+	q.Set("downloaded", strconv.FormatInt(p.Downloaded, 10))
+	q.Set("left", strconv.FormatInt(p.Left, 10))
+	q.Set("compact", "1")
+	if p.Event != "" {
+		q.Set("event", p.Event)
+	}
+
+	sep := "?"
+	if u.RawQuery != "" {
+		sep = "&"
+	}
+	req, err := http.NewRequestWithContext(ctx, "GET", u.String()+sep+q.Encode(), nil)
+	if err != nil {
+		return nil, err
+	}
+	resp, err := client.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("tracker returned %s", resp.Status)
+	}
+
+	body, err := io.ReadAll(io.LimitReader(resp.Body, 4<<20))
+	if err != nil {
+		return nil, err
+	}
+	v, err := bdecode(body)
+	if err != nil {
+		return nil, err
+	}
+	d, ok := v.(map[string]interface{})
+	if !ok {
+		return nil, fmt.Errorf("invalid tracker response")
+	}
+	if reason := dictString(d, "failure reason"); reason != "" {
+		return nil, fmt.Errorf("tracker: %s", reason)
+	}
+
+	res := &announceResult{Interval: time.Duration(dictInt(d, "interval")) * time.Second}
+	switch peers := d["peers"].(type) {
+	case string:
+		res.Peers = compactPeers([]byte(peers), 4)
+	case []interface{}:
+		// Non-compact list of dictionaries
+		for _, item := range peers {
+			pd, ok := item.(map[string]interface{})
+			if !ok {
+				continue
+			}
+			port := dictInt(pd, "port")
+			if ip := dictString(pd, "ip"); ip != "" && port > 0 && port < 65536 {
+				res.Peers = append(res.Peers, net.JoinHostPort(ip, strconv.FormatInt(port, 10)))
+			}
+		}
+	}
+	res.Peers = append(res.Peers, compactPeers([]byte(dictString(d, "peers6")), 16)...)
+	return res, nil
+}
+
+// compactPeers decodes the packed ip+port format (BEP 23 / BEP 7)
+func compactPeers(b []byte, ipLen int) []string {
+	var peers []string
+	size := ipLen + 2
+	for i := 0; i+size <= len(b); i += size {
+		ip := net.IP(b[i : i+ipLen])
+		port := binary.BigEndian.Uint16(b[i+ipLen:])
+		if port == 0 {
+			continue
+		}
+		peers = append(peers, net.JoinHostPort(ip.String(), strconv.Itoa(int(port))))
+	}
+	return peers
+}
+
+// announceUDP implements the UDP tracker protocol (BEP 15)
+func announceUDP(ctx context.Context, host string, p announceParams) (*announceResult, error) {
+	var d net.Dialer
+	conn, err := d.DialContext(ctx, "udp", host)
+	if err != nil {
+		return nil, err
+	}
+	defer conn.Close()
+
+	// connect
+	req := make([]byte, 16)
+	binary.BigEndian.PutUint64(req[0:], 0x41727101980)
+	binary.BigEndian.PutUint32(req[8:], 0)
+	txID := randUint32()
+	binary.BigEndian.PutUint32(req[12:], txID)
+	resp, err := udpRoundTrip(ctx, conn, req, 0, txID)
+	if err != nil {
+		return nil, err
+	}
+	if len(resp) < 16 {
+		return nil, fmt.Errorf("short connect response")
+	}
+	connID := binary.BigEndian.Uint64(resp[8:])
+
+	// announce
+	events := map[string]uint32{"": 0, "completed": 1, "started": 2, "stopped": 3}
+	req = make([]byte, 98)
+	binary.BigEndian.PutUint64(req[0:], connID)
+	binary.BigEndian.PutUint32(req[8:], 1)
+	txID = randUint32()
+	binary.BigEndian.PutUint32(req[12:], txID)
+	copy(req[16:], p.InfoHash[:])
+	copy(req[36:], p.PeerID[:])
+	binary.BigEndian.PutUint64(req[56:], uint64(p.Downloaded))
+	binary.BigEndian.PutUint64(req[64:], uint64(p.Left))
+	binary.BigEndian.PutUint64(req[72:], uint64(p.Uploaded))
+	binary.BigEndian.PutUint32(req[80:], events[p.Event])
+	binary.BigEndian.PutUint32(req[88:], randUint32()) // key
+	binary.BigEndian.PutUint32(req[92:], ^uint32(0))   // num_want: default
+	binary.BigEndian.PutUint16(req[96:], uint16(p.Port))
+	resp, err = udpRoundTrip(ctx, conn, req, 1, txID)
+	if err != nil {
+		return nil, err
+	}
+	if len(resp) < 20 {
+		return nil, fmt.Errorf("short announce response")
+	}
+	return &announceResult{
+		Interval: time.Duration(binary.BigEndian.Uint32(resp[8:])) * time.Second,
+		Peers:    compactPeers(resp[20:], 4),
+	}, nil
+}
+
+// udpRoundTrip sends req and waits for the matching response, retrying with
+// a growing timeout as BEP 15 suggests (capped to keep startup snappy)
+func udpRoundTrip(ctx context.Context, conn net.Conn, req []byte, action, txID uint32) ([]byte, error) {
+	buf := make([]byte, 2048)
+	timeout := 3 * time.Second
+	for attempt := 0; attempt < 3; attempt++ {
+		if err := ctx.Err(); err != nil {
+			return nil, err
+		}
+		if _, err := conn.Write(req); err != nil {
+			return nil, err
+		}
+		conn.SetReadDeadline(time.Now().Add(timeout))
+		for {
+			n, err := conn.Read(buf)
+			if err != nil {
+				break
+			}
+			if n < 8 || binary.BigEndian.Uint32(buf[4:]) != txID {
+				continue
+			}
+			switch binary.BigEndian.Uint32(buf[0:]) {
+			case action:
+				return append([]byte(nil), buf[:n]...), nil
+			case 3:
+				return nil, fmt.Errorf("tracker: %s", buf[8:n])
+			}
+		}
+		timeout *= 2
+	}
+	return nil, fmt.Errorf("udp tracker %s timed out", conn.RemoteAddr())
+}
+
+func randUint32() uint32 {
+	var b [4]byte
+	rand.Read(b[:])
+	return binary.BigEndian.Uint32(b[:])
+}
@@ -0,0 +1,78 @@
+package torrent
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestCompactPeers(t *testing.T) {
+	v4 := []byte{10, 0, 0, 1, 0x1a, 0xe1, 192, 168, 1, 2, 0, 0, 1, 2, 3, 4, 0, 80, 9}
+	if got := strings.Join(compactPeers(v4, 4), " "); got != "10.0.0.1:6881 1.2.3.4:80" {
+		t.Errorf("IPv4 peers = %s", got)
+	}
+	v6 := append(make([]byte, 15), 1, 0x1a, 0xe1)
+	if got := strings.Join(compactPeers(v6, 16), " "); got != "[::1]:6881" {
+		t.Errorf("IPv6 peers = %s", got)
+	}
+}
+
+func TestAnnounceHTTP(t *testing.T) {
+	tests := []struct {
+		name, body string
+		peers      string
+		interval   time.Duration
+		err        string
+	}{
+		{
+			name:     "compact",
+			body:     "d8:intervali900e5:peers6:\x7f\x00\x00\x01\x1a\xe16:peers618:" + strings.Repeat("\x00", 15) + "\x01\x00\x50e",
+			peers:    "127.0.0.1:6881 [::1]:80",
+			interval: 15 * time.Minute,
+		},
+		{
+			name:  "dictionaries",
+			body:  "d5:peersld2:ip8:10.0.0.14:porti6881eed2:ip4:host4:porti0eed2:ip3:::14:porti443eeee",
+			peers: "10.0.0.1:6881 [::1]:443",
+		},
+		{name: "failure", body: "d14:failure reason9:not founde", err: "tracker: not found"},
+		{name: "not bencode", body: "<html>", err: "bencode"},
+		{name: "not a dictionary", body: "li1ee", err: "invalid tracker response"},
+	}
+	for _, tt := range tests {
+		var query string
+		srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			query = r.URL.RawQuery
+			w.Write([]byte(tt.body))
+		}))
+		p := announceParams{InfoHash: peerID("hash"), PeerID: peerID("us"), Port: 6881, Left: 10, Event: "started"}
+		res, err := announce(context.Background(), srv.Client(), srv.URL+"/announce?key=k", p)
+		srv.Close()
+
+		if tt.err != "" {
+			if err == nil || !strings.Contains(err.Error(), tt.err) {
+				t.Errorf("%s: err = %v, want %q", tt.name, err, tt.err)
+			}
+			continue
+		}
+		if err != nil {
+			t.Errorf("%s: %v", tt.name, err)
+			continue
+		}
+		if got := strings.Join(res.Peers, " "); got != tt.peers || res.Interval != tt.interval {
+			t.Errorf("%s: peers %s, interval %v", tt.name, got, res.Interval)
+		}
+		for _, want := range []string{"key=k&", "info_hash=hash%00", "port=6881", "left=10", "compact=1", "event=started"} {
+			if !strings.Contains(query, want) {
+				t.Errorf("%s: query %s lacks %s", tt.name, query, want)
+			}
+		}
+	}
+
+	if _, err := announce(context.Background(), http.DefaultClient, "wss://tracker", announceParams{}); err == nil {
+		t.Error("announced to a WebSocket tracker")
+	}
+}
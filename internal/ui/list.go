@@ -0,0 +1,313 @@
+package ui
+
+import (
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/charmbracelet/bubbles/progress"
+	tea "github.com/charmbracelet/bubbletea"
+	"warp-dl/internal/downloader"
+)
+
+// ListItem is one download of the list view
+type ListItem struct {
+	ID         string
+	Name       string
+	URL        string
+	State      string // queued, running, done, failed or canceled
+	Error      string
+	Downloaded int64
+	Total      int64
+	Speed      float64 // Bytes per second over the last few seconds
+
+	// Strategy, connections and parts, nil for downloads that can't be
+	// inspected
+	Inspection *downloader.Inspection
+}
+
+// ListModel shows many downloads at once, a row each with its progress,
+// speed and state. ↑ and ↓ select one, enter opens its details with the
+// map of its parts and esc goes back to the list.
+type ListModel struct {
+	items    func() []ListItem
+	list     []ListItem
+	cursor   int
+	selected string // ID under the cursor, kept as downloads come and go
+	detail   bool
+	segments map[string]*segmentMap
+	bar      progress.Model
+	width    int
+	height   int
+}
+
+// NewListModel shows the downloads items returns, it is called every tick
+func NewListModel(items func() []ListItem) ListModel {
+	bar := newBar()
+	bar.Width = 24
+	m := ListModel{items: items, segments: map[string]*segmentMap{}, bar: bar}
+	return m.refresh(time.Now())
+}
+
+func (m ListModel) Init() tea.Cmd {
+	return listTick()
+}
+
+func (m ListModel) Update(msg tea.Msg) (tea.Model, tea.Cmd) {
+	switch msg := msg.(type) {
+	case tea.KeyMsg:
+		switch msg.String() {
+		case "up", "k":
+			m.move(-1)
+		case "down", "j":
+			m.move(1)
+		case "home", "g":
+			m.move(-len(m.list))
+		case "end", "G":
+			m.move(len(m.list))
+		case "enter", "right", "l", "tab":
+			m.detail = len(m.list) > 0 && !(m.detail && msg.String() == "tab")
+		case "esc", "left", "h":
+			m.detail = false
+		case "ctrl+c", "q":
+			return m, tea.Quit
+		}
+		return m, nil
+
+	case tea.WindowSizeMsg:
+		m.width, m.height = msg.Width, msg.Height
+		return m, nil
+
+	case tickMsg:
+		return m.refresh(time.Time(msg)), listTick()
+	}
+	return m, nil
+}
+
+// Refresh reloads the downloads, for callers that changed them
+func (m ListModel) Refresh() ListModel {
+	return m.refresh(time.Now())
+}
+
+func (m ListModel) refresh(now time.Time) ListModel {
+	m.list = m.items()
+	m.cursor = 0
+	live := map[string]bool{}
+	for i, it := range m.list {
+		if it.ID == m.selected {
+			m.cursor = i
+		}
+		live[it.ID] = true
+		if it.Inspection == nil {
+			continue
+		}
+		s := m.segments[it.ID]
+		if s == nil {
+			s = newSegmentMap()
+			m.segments[it.ID] = s
+		}
+		s.observe(it.Inspection.Parts, now)
+	}
+	for id := range m.segments {
+		if !live[id] {
+			delete(m.segments, id)
+		}
+	}
+	if len(m.list) == 0 {
+		m.selected, m.detail = "", false
+	} else {
+		m.selected = m.list[m.cursor].ID
+	}
+	return m
+}
+
+func (m *ListModel) move(by int) {
+	if len(m.list) == 0 {
+		return
+	}
+	m.cursor = min(max(m.cursor+by, 0), len(m.list)-1)
+	m.selected = m.list[m.cursor].ID
+}
+
+func (m ListModel) View() string {
+	if m.detail && len(m.list) > 0 {
+		return m.detailView(m.list[m.cursor])
+	}
+	if len(m.list) == 0 {
+		return dimStyle.Render("No downloads yet") + "\n"
+	}
+
+	width := m.width
+	if width <= 0 {
+		width = 100
+	}
+	// Marker, bar, speed and state around the name
+	nameWidth := max(width-2-m.bar.Width-1-12-10, 12)
+
+	// Rows around the cursor when there are more than fit
+	first, last := 0, len(m.list)
+	if rows := m.height - 8; m.height > 0 && len(m.list) > rows && rows > 0 {
+		first = min(max(m.cursor-rows/2, 0), len(m.list)-rows)
+		last = first + rows
+	}
+
+	var b strings.Builder
+	for i := first; i < last; i++ {
+		it := m.list[i]
+		marker := "  "
+		if i == m.cursor {
+			marker = cursorStyle.Render("> ")
+		}
+		name := padRight(cut(it.Name, nameWidth), nameWidth)
+		if i == m.cursor {
+			name = cursorStyle.Render(name)
+		}
+
+		var bar string
+		if it.Total > 0 {
+			bar = m.bar.ViewAs(float64(it.Downloaded) / float64(it.Total))
+		} else {
+			bar = padLeft(formatMB(it.Downloaded), m.bar.Width)
+		}
+		speed := ""
+		if it.State == "running" {
+			speed = fmt.Sprintf("%.2f MB/s", it.Speed/1024/1024)
+		}
+		fmt.Fprintf(&b, "%s%s %s %12s  %s\n", marker, name, bar, speed, stateStyle(it.State).Render(it.State))
+	}
+	if first > 0 || last < len(m.list) {
+		b.WriteString(dimStyle.Render(fmt.Sprintf("%d-%d of %d", first+1, last, len(m.list))) + "\n")
+	}
+	b.WriteString(dimStyle.Render(arrows+" select, enter details") + "\n")
+	return b.String()
+}
+
+func (m ListModel) detailView(it ListItem) string {
+	var b strings.Builder
+	now := time.Now()
+	width := m.width
+	if width <= 0 {
+		width = 100
+	}
+
+	b.WriteString(cursorStyle.Render(it.Name) + "\n")
+	if it.URL != "" && it.URL != it.Name {
+		b.WriteString(dimStyle.Render(cut(it.URL, width)) + "\n")
+	}
+	b.WriteString("\n")
+
+	line := fmt.Sprintf("State: %s, %s", it.State, formatMB(it.Downloaded))
+	if it.Total > 0 {
+		line += fmt.Sprintf(" / %s (%.0f%%)", formatMB(it.Total), float64(it.Downloaded)*100/float64(it.Total))
+	}
+	b.WriteString(line + "\n")
+	if it.State == "running" {
+		line = fmt.Sprintf("Speed: %.2f MB/s", it.Speed/1024/1024)
+		if eta, ok := downloader.ETA(now, it.Total-it.Downloaded, it.Speed); ok && it.Total > 0 {
+			line += "  ETA: " + eta.Sub(now).Round(time.Second).String()
+		}
+		b.WriteString(line + "\n")
+	}
+	if it.Error != "" {
+		b.WriteString(styleFailed.Render("Error: "+it.Error) + "\n")
+	}
+
+	if in := it.Inspection; in != nil {
+		line = "Strategy: " + in.Strategy
+		if in.Connections > 0 {
+			line += fmt.Sprintf(", %d connections", in.Connections)
+		}
+		if in.Protocol != "" {
+			line += ", " + in.Protocol
+		}
+		b.WriteString(line + "\n")
+		if len(in.Mirrors) > 0 {
+			b.WriteString(cut("Mirrors: "+strings.Join(in.Mirrors, ", "), width) + "\n")
+		}
+		if len(in.Peers) > 0 {
+			b.WriteString(cut("LAN peers: "+strings.Join(in.Peers, ", "), width) + "\n")
+		}
+		if in.Proxy != "" {
+			b.WriteString("Proxy: " + in.Proxy + "\n")
+		}
+		if len(in.Links) > 0 {
+			var links []string
+			for _, l := range in.Links {
+				s := fmt.Sprintf("%s %.2f MB", l.Name, float64(l.Bytes)/1024/1024)
+				if !l.Up {
+					s += " (down)"
+				}
+				links = append(links, s)
+			}
+			b.WriteString(cut("Links: "+strings.Join(links, ", "), width) + "\n")
+		}
+
+		if s := m.segments[it.ID]; s != nil {
+			if row := s.view(in.Parts, now, width-30); row != "" {
+				b.WriteString("\n" + row + "\n")
+				b.WriteString(m.partRows(in.Parts))
+			}
+		}
+	}
+	b.WriteString("\n" + dimStyle.Render("esc back, "+arrows+" other downloads") + "\n")
+	return b.String()
+}
+
+// partRows lists the parts that are still going, as many as fit
+func (m ListModel) partRows(parts []downloader.PartStatus) string {
+	rows := 12
+	if m.height > 0 {
+		rows = max(m.height-16, 3)
+	}
+	var b strings.Builder
+	shown := 0
+	for _, p := range parts {
+		if p.State == downloader.PartDone {
+			continue
+		}
+		if shown == rows {
+			b.WriteString(dimStyle.Render(ellipsis) + "\n")
+			break
+		}
+		size := p.End - p.Start + 1
+		pct := 0.0
+		if size > 0 {
+			pct = float64(p.Downloaded) * 100 / float64(size)
+		}
+		fmt.Fprintf(&b, "  #%-4d %10s - %-10s %4.0f%%  %s\n", p.ID, formatMB(p.Start), formatMB(p.End+1), pct, p.State)
+		shown++
+	}
+	return b.String()
+}
+
+func formatMB(n int64) string {
+	return fmt.Sprintf("%.2f MB", float64(n)/1024/1024)
+}
+
+// cut shortens s to w columns
+func cut(s string, w int) string {
+	if r := []rune(s); w > 1 && len(r) > w {
+		return string(r[:w-len([]rune(ellipsis))]) + ellipsis
+	}
+	return s
+}
+
+func padRight(s string, w int) string {
+	if n := len([]rune(s)); n < w {
+		return s + strings.Repeat(" ", w-n)
+	}
+	return s
+}
+
+func padLeft(s string, w int) string {
+	if n := len([]rune(s)); n < w {
+		return strings.Repeat(" ", w-n) + s
+	}
+	return s
+}
+
+func listTick() tea.Cmd {
+	return tea.Tick(500*time.Millisecond, func(t time.Time) tea.Msg {
+		return tickMsg(t)
+	})
+}
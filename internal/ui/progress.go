@@ -12,30 +12,91 @@ import (
 
 type tickMsg time.Time
 
+// DiskFullMsg pauses the view on a prompt until the user has freed space.
+// Reply receives true to resume, false to give up.
+type DiskFullMsg struct {
+	Dir   string
+	Reply chan<- bool
+}
+
+// DuplicateMsg asks whether to download a file that is already on disk at
+// Path. Reply receives true to download it again, false to keep the copy.
+type DuplicateMsg struct {
+	Path  string
+	Reply chan<- bool
+}
+
 type Model struct {
-	stats    *downloader.Stats
-	progress progress.Model
-	quitting bool
-	err      error
+	stats       *downloader.Stats
+	progress    progress.Model
+	label       string
+	quitting    bool
+	interrupted bool
+	err         error
+	diskFull    *DiskFullMsg
+	duplicate   *DuplicateMsg
+	inspector   downloader.Inspector
+	segments    *segmentMap
 }
 
 func NewModel(stats *downloader.Stats) Model {
 	return Model{
 		stats:    stats,
-		progress: progress.New(progress.WithDefaultGradient()),
+		progress: newBar(),
+		label:    "Downloaded",
 	}
 }
 
+// WithLabel replaces the "Downloaded" caption, e.g. for local verification
+func (m Model) WithLabel(label string) Model {
+	m.label = label
+	return m
+}
+
+// WithSegments adds a row showing the progress of every part of a split
+// download
+func (m Model) WithSegments(source downloader.Inspector) Model {
+	m.inspector, m.segments = source, newSegmentMap()
+	return m
+}
+
 func (m Model) Init() tea.Cmd {
 	return tickCmd()
 }
 
 func (m Model) Update(msg tea.Msg) (tea.Model, tea.Cmd) {
 	switch msg := msg.(type) {
+	case DiskFullMsg:
+		m.diskFull = &msg
+		return m, nil
+
+	case DuplicateMsg:
+		m.duplicate = &msg
+		return m, nil
+
 	case tea.KeyMsg:
 		switch msg.String() {
+		case "r":
+			if m.diskFull != nil {
+				m.diskFull.Reply <- true
+				m.diskFull = nil
+			}
+		case "y", "n", "enter":
+			if m.duplicate != nil {
+				m.duplicate.Reply <- msg.String() == "y"
+				m.duplicate = nil
+			}
 		case "ctrl+c", "q":
+			if m.diskFull != nil {
+				m.diskFull.Reply <- false
+				m.diskFull = nil
+			}
+			if m.duplicate != nil {
+				m.duplicate.Reply <- false
+				m.duplicate = nil
+			}
 			m.quitting = true
+			m.interrupted = true
 			return m, tea.Quit
 		}
 		return m, nil
@@ -51,22 +112,27 @@ func (m Model) Update(msg tea.Msg) (tea.Model, tea.Cmd) {
 		if m.stats == nil {
 			return m, tickCmd()
 		}
+		if m.segments != nil {
+			m.segments.observe(m.inspector.Inspect().Parts, time.Time(msg))
+		}
 
 		// Calculate progress
 		var percent float64
-		if m.stats.TotalBytes > 0 {
-			percent = float64(m.stats.GetDownloaded()) / float64(m.stats.TotalBytes)
+		if total := m.stats.GetTotal(); total > 0 {
+			percent = float64(m.stats.GetDownloaded()) / float64(total)
 		}
 
+		// The caller quits the program once the download really finished,
+		// merging, verification or follow mode may outlast 100%
 		cmd := m.progress.SetPercent(percent)
-		
-		if percent >= 1.0 {
-			m.quitting = true
-			return m, tea.Batch(cmd, tea.Quit)
-		}
-
 		return m, tea.Batch(cmd, tickCmd())
 
+	case progress.FrameMsg:
+		// The bar animates towards the percentage it was set to
+		bar, cmd := m.progress.Update(msg)
+		m.progress = bar.(progress.Model)
+		return m, cmd
+
 	default:
 		return m, nil
 	}
@@ -83,11 +149,65 @@ func (m Model) View() string {
 
 	pad := lipgloss.NewStyle().Padding(1).Render
 
-	info := fmt.Sprintf("Downloaded: %.2f MB / %.2f MB", 
-		float64(m.stats.GetDownloaded())/1024/1024, 
-		float64(m.stats.TotalBytes)/1024/1024)
+	now := time.Now()
+	downloaded, total := m.stats.GetDownloaded(), m.stats.GetTotal()
+	info := fmt.Sprintf("%s: %.2f MB / %.2f MB", m.label,
+		float64(downloaded)/1024/1024,
+		float64(total)/1024/1024)
+	if wire := m.stats.GetWire(); wire > 0 {
+		info += fmt.Sprintf(" (%.2f MB compressed)", float64(wire)/1024/1024)
+	}
+
+	current, average := m.stats.Rates(now)
+	info += fmt.Sprintf("\nSpeed: %.2f MB/s (avg %.2f MB/s)", current/1024/1024, average/1024/1024)
+	if eta, ok := downloader.ETA(now, total-downloaded, current); ok && total > 0 {
+		info += "  ETA: " + eta.Sub(now).Round(time.Second).String()
+	}
+
+	if m.duplicate != nil {
+		info += fmt.Sprintf("\n\n%s already has this file.\nDownload it again? [y/N]", m.duplicate.Path)
+	}
+	if m.diskFull != nil {
+		info += fmt.Sprintf("\n\nDisk full on %s, paused.\nFree up some space, then press r to resume or q to quit.", m.diskFull.Dir)
+	}
+
+	bar := m.progress.View()
+	if m.segments != nil {
+		// Leave room for the caption and the summary after the cells
+		width := m.progress.Width - 30
+		if width < 8 {
+			width = 8
+		}
+		if row := m.segments.view(m.inspector.Inspect().Parts, now, width); row != "" {
+			bar += "\n" + row
+		}
+	}
+
+	return pad(fmt.Sprintf("\n%s\n%s\n", info, bar))
+}
+
+// Line renders the progress as a single plain line, for logs and pipes
+func (m Model) Line(now time.Time) string {
+	downloaded, total := m.stats.GetDownloaded(), m.stats.GetTotal()
+	line := fmt.Sprintf("%s: %.2f MB", m.label, float64(downloaded)/1024/1024)
+	if total > 0 {
+		line += fmt.Sprintf(" / %.2f MB (%.0f%%)", float64(total)/1024/1024, float64(downloaded)*100/float64(total))
+	}
+	if wire := m.stats.GetWire(); wire > 0 {
+		line += fmt.Sprintf(" (%.2f MB compressed)", float64(wire)/1024/1024)
+	}
+
+	current, average := m.stats.Rates(now)
+	line += fmt.Sprintf(", %.2f MB/s (avg %.2f MB/s)", current/1024/1024, average/1024/1024)
+	if eta, ok := downloader.ETA(now, total-downloaded, current); ok && total > 0 {
+		line += ", ETA " + eta.Sub(now).Round(time.Second).String()
+	}
+	return line
+}
 
-	return pad(fmt.Sprintf("\n%s\n%s\n", info, m.progress.View()))
+// Interrupted reports whether the user quit before the download finished
+func (m Model) Interrupted() bool {
+	return m.interrupted
 }
 
 func tickCmd() tea.Cmd {
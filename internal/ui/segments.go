@@ -0,0 +1,147 @@
+package ui
+
+import (
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/charmbracelet/lipgloss"
+	"warp-dl/internal/downloader"
+)
+
+// stuckAfter is how long an active part may go without progress before the
+// map marks it stuck
+const stuckAfter = 5 * time.Second
+
+// cellState orders what a cell shows when it covers several parts, the
+// most alarming one wins
+type cellState int
+
+const (
+	cellDone cellState = iota
+	cellPending
+	cellActive
+	cellThrottled
+	cellStuck
+	cellFailed
+)
+
+// segmentMap draws one cell per part, filled to its completion, so a
+// connection that is stuck stands out from the ones still moving
+type segmentMap struct {
+	seen map[int]partMark // Per part ID
+}
+
+// partMark is when a part's byte count last changed
+type partMark struct {
+	downloaded int64
+	at         time.Time
+}
+
+func newSegmentMap() *segmentMap {
+	return &segmentMap{seen: map[int]partMark{}}
+}
+
+// observe notes which parts moved since the last tick
+func (s *segmentMap) observe(parts []downloader.PartStatus, now time.Time) {
+	for _, p := range parts {
+		if mark, ok := s.seen[p.ID]; !ok || mark.downloaded != p.Downloaded {
+			s.seen[p.ID] = partMark{downloaded: p.Downloaded, at: now}
+		}
+	}
+}
+
+// view renders the map in at most width cells, empty for downloads that
+// aren't split
+func (s *segmentMap) view(parts []downloader.PartStatus, now time.Time, width int) string {
+	if len(parts) < 2 {
+		return ""
+	}
+	cells := len(parts)
+	if width > 0 && cells > width {
+		cells = width
+	}
+
+	var (
+		b                                strings.Builder
+		active, throttled, stuck, failed int
+	)
+	for _, p := range parts {
+		switch s.state(p, now) {
+		case cellActive:
+			active++
+		case cellThrottled:
+			throttled++
+		case cellStuck:
+			stuck++
+		case cellFailed:
+			failed++
+		}
+	}
+	for c := 0; c < cells; c++ {
+		// Parts c*n/cells up to (c+1)*n/cells share this cell
+		group := parts[c*len(parts)/cells : (c+1)*len(parts)/cells]
+		var size, done int64
+		state := cellDone
+		for _, p := range group {
+			size += p.End - p.Start + 1
+			done += p.Downloaded
+			if st := s.state(p, now); st > state {
+				state = st
+			}
+		}
+		level := 0
+		if size > 0 {
+			level = int(done * int64(len(levels)-1) / size)
+		}
+		if level > len(levels)-1 {
+			level = len(levels) - 1
+		}
+		b.WriteString(cellStyle(state).Render(string(levels[level])))
+	}
+
+	summary := fmt.Sprintf("%d active", active)
+	if throttled > 0 {
+		summary += styleThrottled.Render(fmt.Sprintf(", %d throttled by server", throttled))
+	}
+	if stuck > 0 {
+		summary += styleStuck.Render(fmt.Sprintf(", %d stuck", stuck))
+	}
+	if failed > 0 {
+		summary += styleFailed.Render(fmt.Sprintf(", %d failed", failed))
+	}
+	return fmt.Sprintf("Parts: %s  %s", b.String(), summary)
+}
+
+func (s *segmentMap) state(p downloader.PartStatus, now time.Time) cellState {
+	switch p.State {
+	case downloader.PartDone:
+		return cellDone
+	case downloader.PartFailed:
+		return cellFailed
+	case downloader.PartThrottled:
+		return cellThrottled
+	case downloader.PartActive:
+		if mark, ok := s.seen[p.ID]; ok && now.Sub(mark.at) >= stuckAfter {
+			return cellStuck
+		}
+		return cellActive
+	}
+	return cellPending
+}
+
+func cellStyle(s cellState) lipgloss.Style {
+	switch s {
+	case cellActive:
+		return styleActive
+	case cellThrottled:
+		return styleThrottled
+	case cellStuck:
+		return styleStuck
+	case cellFailed:
+		return styleFailed
+	case cellPending:
+		return stylePending
+	}
+	return styleDone
+}
@@ -0,0 +1,163 @@
+package ui
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+
+	"github.com/charmbracelet/bubbles/progress"
+	"github.com/charmbracelet/lipgloss"
+	"github.com/muesli/termenv"
+)
+
+// ThemeConfig is the theme section of config.yaml
+type ThemeConfig struct {
+	Name     string            `yaml:"name"`     // A built-in theme to start from: default, high-contrast or mono
+	Gradient []string          `yaml:"gradient"` // Progress bar colors: two for a gradient, one for a solid bar
+	Colors   map[string]string `yaml:"colors"`   // Replaces colors of the theme, by the names in the default theme
+	ASCII    bool              `yaml:"ascii"`    // Only ASCII characters, for terminals and fonts without block elements
+	NoColor  bool              `yaml:"no_color"` // No colors at all, like --no-color
+}
+
+// theme is what the views draw with. Colors are lipgloss colors: ANSI
+// numbers or #rrggbb.
+type theme struct {
+	gradient []string
+	colors   map[string]string
+	bold     bool // High contrast themes make the states bold too
+}
+
+// themes are the built-in themes by name
+var themes = map[string]theme{
+	"default": {
+		gradient: nil, // The progress bar's own
+		colors: map[string]string{
+			"active": "42", "throttled": "39", "stuck": "214", "failed": "196", "done": "240", "pending": "238", "dim": "8",
+		},
+	},
+	// The 16 colors every terminal has, bright on the usual dark
+	// background
+	"high-contrast": {
+		gradient: []string{"15"},
+		colors: map[string]string{
+			"active": "10", "throttled": "14", "stuck": "11", "failed": "9", "done": "15", "pending": "7", "dim": "7",
+		},
+		bold: true,
+	},
+	// Shades of grey, for terminals that get colors wrong
+	"mono": {
+		gradient: []string{"252"},
+		colors: map[string]string{
+			"active": "255", "throttled": "250", "stuck": "250", "failed": "255", "done": "244", "pending": "240", "dim": "244",
+		},
+	},
+}
+
+var (
+	styleActive, styleThrottled, styleStuck, styleFailed lipgloss.Style
+	styleDone, stylePending                              lipgloss.Style
+	dimStyle, promptStyle, cursorStyle                   lipgloss.Style
+
+	// Heights of the segment map's cells, from nothing done to complete
+	levels []rune
+	// What a cut off text ends in and the hint for the arrow keys
+	ellipsis, arrows string
+
+	newBar func() progress.Model
+)
+
+func init() {
+	if err := SetTheme(ThemeConfig{}, false); err != nil {
+		panic(err)
+	}
+}
+
+// SetTheme sets the look of every view made after it. noColor drops the
+// colors whatever the config says.
+func SetTheme(cfg ThemeConfig, noColor bool) error {
+	name := cfg.Name
+	if name == "" {
+		name = "default"
+	}
+	base, ok := themes[name]
+	if !ok {
+		var names []string
+		for n := range themes {
+			names = append(names, n)
+		}
+		sort.Strings(names)
+		return fmt.Errorf("unknown theme %q: want %s", name, strings.Join(names, ", "))
+	}
+	colors := map[string]string{}
+	for k, v := range base.colors {
+		colors[k] = v
+	}
+	for k, v := range cfg.Colors {
+		if _, ok := colors[k]; !ok {
+			return fmt.Errorf("unknown theme color %q", k)
+		}
+		colors[k] = v
+	}
+	gradient := base.gradient
+	if len(cfg.Gradient) > 0 {
+		gradient = cfg.Gradient
+	}
+	if len(gradient) > 2 {
+		return fmt.Errorf("theme gradient wants one or two colors, not %d", len(gradient))
+	}
+
+	noColor = noColor || cfg.NoColor
+	profile := termenv.EnvColorProfile()
+	if noColor {
+		profile = termenv.Ascii
+	}
+	lipgloss.SetColorProfile(profile)
+
+	style := func(name string) lipgloss.Style {
+		return lipgloss.NewStyle().Foreground(lipgloss.Color(colors[name])).Bold(base.bold)
+	}
+	styleActive, styleStuck, styleFailed = style("active"), style("stuck"), style("failed")
+	styleThrottled = style("throttled")
+	styleDone, stylePending = style("done"), style("pending")
+	dimStyle = lipgloss.NewStyle().Foreground(lipgloss.Color(colors["dim"]))
+	promptStyle = lipgloss.NewStyle().Bold(true)
+	cursorStyle = lipgloss.NewStyle().Bold(true)
+
+	levels, ellipsis, arrows = []rune("·▁▂▃▄▅▆▇█"), "…", "↑/↓"
+	if cfg.ASCII {
+		levels, ellipsis, arrows = []rune(".:-=+*#%@"), "...", "up/down"
+	}
+
+	newBar = func() progress.Model {
+		opts := []progress.Option{progress.WithColorProfile(profile)}
+		switch len(gradient) {
+		case 0:
+			opts = append(opts, progress.WithDefaultGradient())
+		case 1:
+			opts = append(opts, progress.WithSolidFill(gradient[0]))
+		case 2:
+			opts = append(opts, progress.WithGradient(gradient[0], gradient[1]))
+		}
+		bar := progress.New(opts...)
+		if cfg.ASCII {
+			bar.Full, bar.Empty = '#', '-'
+		}
+		return bar
+	}
+	return nil
+}
+
+// stateStyle colors a download's state in the list
+func stateStyle(state string) lipgloss.Style {
+	switch state {
+	case "done":
+		return styleDone
+	case "failed":
+		return styleFailed
+	case "canceled":
+		return styleStuck
+	case "queued":
+		return stylePending
+	}
+	return lipgloss.NewStyle()
+}
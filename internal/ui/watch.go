@@ -0,0 +1,124 @@
+package ui
+
+import (
+	"fmt"
+	"strings"
+
+	tea "github.com/charmbracelet/bubbletea"
+)
+
+// FoundMsg brings a URL the watcher picked up
+type FoundMsg struct {
+	URL string
+}
+
+// WatchModel shows what the clipboard watcher queued in a ListModel. With
+// confirmation on, every URL waits for a y or n first; c switches it on
+// and off.
+type WatchModel struct {
+	what    string
+	confirm bool
+	pending []string
+	queue   func(url string)
+	list    ListModel
+	width   int
+}
+
+// NewWatchModel describes what is watched for with what, hands accepted
+// URLs to queue and shows the downloads list returns
+func NewWatchModel(what string, confirm bool, queue func(url string), list func() []ListItem) WatchModel {
+	return WatchModel{what: what, confirm: confirm, queue: queue, list: NewListModel(list)}
+}
+
+func (m WatchModel) Init() tea.Cmd {
+	return m.list.Init()
+}
+
+func (m WatchModel) Update(msg tea.Msg) (tea.Model, tea.Cmd) {
+	switch msg := msg.(type) {
+	case FoundMsg:
+		if m.confirm {
+			m.pending = append(m.pending, msg.URL)
+		} else {
+			m.queue(msg.URL)
+			m.list = m.list.Refresh()
+		}
+		return m, nil
+
+	case tea.KeyMsg:
+		switch msg.String() {
+		case "y":
+			if len(m.pending) > 0 {
+				m.queue(m.pending[0])
+				m.pending = m.pending[1:]
+				m.list = m.list.Refresh()
+			}
+			return m, nil
+		case "n", "backspace":
+			if len(m.pending) > 0 {
+				m.pending = m.pending[1:]
+			}
+			return m, nil
+		case "enter":
+			// The prompt comes first, then the details
+			if len(m.pending) > 0 {
+				m.queue(m.pending[0])
+				m.pending = m.pending[1:]
+				m.list = m.list.Refresh()
+				return m, nil
+			}
+		case "c":
+			m.confirm = !m.confirm
+			if !m.confirm {
+				// Nobody is asked any more, so what was waiting goes ahead
+				for _, u := range m.pending {
+					m.queue(u)
+				}
+				m.pending = nil
+				m.list = m.list.Refresh()
+			}
+			return m, nil
+		}
+
+	case tea.WindowSizeMsg:
+		m.width = msg.Width
+	}
+
+	list, cmd := m.list.Update(msg)
+	m.list = list.(ListModel)
+	return m, cmd
+}
+
+func (m WatchModel) View() string {
+	var b strings.Builder
+	mode := "off"
+	if m.confirm {
+		mode = "on"
+	}
+	fmt.Fprintf(&b, "Watching the clipboard for %s\n", m.what)
+	b.WriteString(dimStyle.Render(fmt.Sprintf("Confirmation %s (c to switch), q to quit", mode)) + "\n\n")
+
+	if len(m.pending) > 0 {
+		b.WriteString(promptStyle.Render("Download "+m.fit(m.pending[0], 12)+"? [y/n]") + "\n")
+		if more := len(m.pending) - 1; more > 0 {
+			b.WriteString(dimStyle.Render(fmt.Sprintf("%d more waiting", more)) + "\n")
+		}
+		b.WriteString("\n")
+	}
+
+	if len(m.list.list) == 0 {
+		b.WriteString(dimStyle.Render("Nothing queued yet, copy a link") + "\n")
+		return b.String()
+	}
+	b.WriteString(m.list.View())
+	return b.String()
+}
+
+// fit cuts s to the terminal width, less reserve columns
+func (m WatchModel) fit(s string, reserve int) string {
+	if m.width <= 0 {
+		// Width unknown
+		return s
+	}
+	return cut(s, max(m.width-reserve, 20))
+}